@@ -0,0 +1,430 @@
+// Package client 访问 leaf-segment 服务的 Go 客户端
+// 复用连接, 失败时按退避重试, 并在多个服务地址之间故障切换; 服务地址可以固定配置, 也可以从 etcd 发现
+//
+//	c, err := client.New(client.Config{Addrs: []string{"http://10.0.0.1:8880", "http://10.0.0.2:8880"}})
+//	...
+//	id, err := c.NextID(ctx, "test")
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务端响应中的错误码, 与服务端一致
+const (
+	ErrNoFailed        = -1  // 处理失败
+	ErrNoTimeout       = -2  // 处理超过请求时限
+	ErrNoUnauthorized  = -3  // 调用方未认证
+	ErrNoForbidden     = -4  // 调用方无权访问该业务
+	ErrNoRateLimited   = -5  // 业务请求超过限流
+	ErrNoOverloaded    = -6  // 服务器过载, 请求被拒绝
+	ErrNoInvalidBizTag = -7  // 业务标识不符合校验规则
+	ErrNoStandby       = -8  // 服务端是备用实例, 客户端换一个地址重试
+	ErrNoIdExhausted   = -9  // 业务的号码已达到上限
+	ErrNoArchived      = -10 // 业务已归档
+	ErrNoBizTagUnknown = -11 // 号段存储中不存在该业务
+	ErrNoQuotaExceeded = -12 // 业务超过分配配额
+)
+
+// 服务端返回的错误原因, 见 Error.Cause
+const (
+	CauseParam     = "param"     // 请求参数错误或业务不存在
+	CauseAuth      = "auth"      // 调用方未认证或无权访问该业务
+	CauseLimited   = "limited"   // 超过限流、配额或服务器过载
+	CauseStandby   = "standby"   // 服务端是备用实例
+	CauseStorage   = "storage"   // 号段存储不可用或号段暂时耗尽
+	CauseExhausted = "exhausted" // 业务的号码已达到上限或业务已归档
+	CauseTimeout   = "timeout"   // 处理超过请求时限
+)
+
+// ErrNoAddrs 没有配置服务地址
+var ErrNoAddrs = errors.New("leaf client: no server address")
+
+// Config 客户端配置, 零值字段使用默认值
+type Config struct {
+	Addrs        []string      // 服务地址, 如 http://10.0.0.1:8880, 按顺序轮询; 配置 Etcd 时仅在 etcd 中没有实例时使用
+	Etcd         *EtcdConfig   // 从 etcd 发现服务地址, 为 nil 表示只使用 Addrs
+	APIKey       string        // 调用方密钥, 为空表示不携带
+	APIKeyHeader string        // 携带密钥的请求头, 默认 X-API-Key
+	Token        string        // JWT, 以 Authorization: Bearer 携带, 为空表示不携带
+	Timeout      time.Duration // 单次请求的超时时间, 默认 3 秒
+	WaitTimeout  time.Duration // 号段耗尽时服务端最多等待补充的时间, 以 X-Wait-Timeout 请求头携带, 不超过请求剩余的时间; 0 表示使用服务端默认值, 负数表示不等待立即失败
+	Retries      int           // 失败后的最大重试次数, 默认 2, 负数表示不重试
+	Backoff      time.Duration // 第一次重试前的等待时间, 之后每次翻倍, 默认 50 毫秒
+	MaxBackoff   time.Duration // 重试等待时间的上限, 默认 1 秒
+	Cooldown     time.Duration // 地址连接失败后暂停使用的时长, 默认 5 秒
+	MaxIdleConns int           // 每个地址保持的空闲连接数, 默认 100
+	HTTPClient   *http.Client  // 自定义的 HTTP 客户端, 设置后忽略 MaxIdleConns
+	Concurrency  int           // NextIDs 同时发出的请求数, 默认 8
+	LeaseRefill  float64       // Leaser 当前租约剩余比例低于该值时预取下一个租约, 默认 0.2
+}
+
+// Error 服务端返回的错误
+type Error struct {
+	Addr      string // 返回错误的服务地址
+	Status    int    // HTTP 状态码
+	ErrNo     int    // 响应中的错误码
+	Msg       string // 响应中的错误信息
+	Cause     string // 响应中的错误原因, 取值见 Cause 常量, 旧版本服务端或中间件返回纯文本错误时为空
+	Retryable bool   // 服务端判断重试是否可能成功, Cause 为空时按 HTTP 状态码判断
+	RequestID string // 响应头 X-Request-ID 中的请求 ID, 用于在服务端日志中查找这次请求
+}
+
+func (err *Error) Error() string {
+	if err.RequestID != "" {
+		return fmt.Sprintf("leaf client: %s: status %d, err_no %d, request_id %s: %s", err.Addr, err.Status, err.ErrNo, err.RequestID, err.Msg)
+	}
+	return fmt.Sprintf("leaf client: %s: status %d, err_no %d: %s", err.Addr, err.Status, err.ErrNo, err.Msg)
+}
+
+// retryable 换一个地址或稍后重试可能成功的错误, 优先使用服务端给出的判断
+// 参数错误、认证失败、号码达到上限和业务已归档重试也不会成功, 其他错误(超时、限流、过载、号段暂时耗尽)都可以重试
+func (err *Error) retryable() bool {
+	if err.Cause != "" {
+		return err.Retryable
+	}
+	switch err.Status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone:
+		return false
+	}
+	return true
+}
+
+// RefillStatus 服务端补偿线程的状态
+type RefillStatus struct {
+	Failing       bool      `json:"failing"`         // 补偿线程是否已放弃, 下一次分配请求会重新触发
+	GiveUps       int       `json:"give_ups"`        // 连续放弃的次数
+	LastError     string    `json:"last_error"`      // 最近一次获取号段失败的错误
+	LastErrorTime time.Time `json:"last_error_time"` // 最近一次获取号段失败的时间
+}
+
+// Health 业务在某个服务实例上的健康状态
+type Health struct {
+	Addr   string        // 返回状态的服务地址
+	Left   int64         // 内存中剩余的号码数量
+	Refill *RefillStatus // 补偿线程状态, 从未失败过时为 nil
+}
+
+// response /alloc、/health、/lease 和 /reserve 的响应
+type response struct {
+	ErrNo         int           `json:"err_no"`
+	Msg           string        `json:"msg"`
+	ID            int64         `json:"id"`
+	Left          int64         `json:"left"` // /health 中为剩余号码数, /lease 中为号段左边界
+	Right         int64         `json:"right"`
+	LeaseID       string        `json:"lease_id"`
+	ReservationID string        `json:"reservation_id"`
+	TTL           int64         `json:"ttl"` // 租约有效期（毫秒）
+	Refill        *RefillStatus `json:"refill"`
+	Retryable     bool          `json:"retryable"`
+	Cause         string        `json:"cause"`
+}
+
+// Client 并发安全, 应在进程内复用
+type Client struct {
+	conf       Config
+	httpClient *http.Client
+	next       uint32                    // 下一次请求起始的地址下标, 原子读写
+	endpoints  atomic.Pointer[endpoints] // 当前使用的服务地址, 地址变化时整体替换
+	watcher    *etcdWatcher              // 监听 etcd 中的服务地址, 未配置 Etcd 时为 nil
+}
+
+// endpoints 一组服务地址及其暂停状态
+type endpoints struct {
+	addrs     []string
+	downUntil []int64 // 与 addrs 一一对应, 连接失败后暂停使用到该时间(纳秒), 原子读写
+}
+
+// New 创建客户端, 配置 Etcd 时先从 etcd 读取一次服务地址, 并在后台监听变化直到 Close
+func New(conf Config) (client *Client, err error) {
+	if len(conf.Addrs) == 0 && conf.Etcd == nil {
+		return nil, ErrNoAddrs
+	}
+	if conf.APIKeyHeader == "" {
+		conf.APIKeyHeader = "X-API-Key"
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3 * time.Second
+	}
+	if conf.Retries == 0 {
+		conf.Retries = 2
+	}
+	if conf.Backoff <= 0 {
+		conf.Backoff = 50 * time.Millisecond
+	}
+	if conf.MaxBackoff <= 0 {
+		conf.MaxBackoff = time.Second
+	}
+	if conf.Cooldown <= 0 {
+		conf.Cooldown = 5 * time.Second
+	}
+	if conf.MaxIdleConns <= 0 {
+		conf.MaxIdleConns = 100
+	}
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = 8
+	}
+	if conf.LeaseRefill <= 0 || conf.LeaseRefill > 1 {
+		conf.LeaseRefill = 0.2
+	}
+
+	client = &Client{conf: conf, httpClient: conf.HTTPClient}
+	client.conf.Addrs = nil
+	for _, addr := range conf.Addrs {
+		if _, err = url.Parse(addr); err != nil {
+			return nil, fmt.Errorf("leaf client: invalid address %q: %w", addr, err)
+		}
+		client.conf.Addrs = append(client.conf.Addrs, strings.TrimRight(addr, "/"))
+	}
+	client.setAddrs(client.conf.Addrs)
+	if client.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = conf.MaxIdleConns * len(conf.Addrs)
+		if conf.Etcd != nil { // 地址数量不固定, 只限制每个地址的空闲连接数
+			transport.MaxIdleConns = 0
+		}
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConns
+		client.httpClient = &http.Client{Transport: transport}
+	}
+
+	if conf.Etcd != nil {
+		client.watcher = newEtcdWatcher(client, *conf.Etcd)
+		if err = client.watcher.sync(); err != nil && len(conf.Addrs) == 0 {
+			return nil, err
+		}
+		err = nil
+		client.watcher.start()
+	}
+	return
+}
+
+// Close 停止监听 etcd, 未配置 Etcd 时不做任何事
+func (client *Client) Close() error {
+	if client.watcher != nil {
+		client.watcher.stop()
+	}
+	return nil
+}
+
+// setAddrs 替换服务地址, 仍然存在的地址保留暂停状态
+func (client *Client) setAddrs(addrs []string) {
+	current := &endpoints{addrs: addrs, downUntil: make([]int64, len(addrs))}
+	if previous := client.endpoints.Load(); previous != nil {
+		for i, addr := range previous.addrs {
+			for j := range addrs {
+				if addrs[j] == addr {
+					current.downUntil[j] = atomic.LoadInt64(&previous.downUntil[i])
+				}
+			}
+		}
+	}
+	client.endpoints.Store(current)
+}
+
+// NextID 获取一个ID
+func (client *Client) NextID(ctx context.Context, bizTag string) (int64, error) {
+	resp, _, err := client.do(ctx, "/alloc", bizTag, nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+// NextIDs 获取 n 个ID, 并发请求, 结果按返回的先后顺序排列; 任何一个失败时返回错误
+func (client *Client) NextIDs(ctx context.Context, bizTag string, n int) (ids []int64, err error) {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		slots = make(chan struct{}, client.conf.Concurrency)
+	)
+
+	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+
+	ids = make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			id, e := client.NextID(ctx, bizTag)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if e != nil {
+				if err == nil {
+					err = e
+					cancelFunc() // 已经失败, 不再继续请求
+				}
+				return
+			}
+			ids = append(ids, id)
+		}()
+	}
+	wg.Wait()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Health 查询业务的健康状态, 号码耗尽时返回 *Error, 同时返回服务端报告的状态
+func (client *Client) Health(ctx context.Context, bizTag string) (*Health, error) {
+	resp, addr, err := client.do(ctx, "/health", bizTag, nil)
+	if resp == nil {
+		return nil, err
+	}
+	return &Health{Addr: addr, Left: resp.Left, Refill: resp.Refill}, err
+}
+
+// do 依次尝试各个地址, 直到成功或遇到不可重试的错误, params 为 biz_tag 之外的请求参数
+// 失败的请求返回最后一次的错误, 对于服务端错误同时返回解析出的响应
+func (client *Client) do(ctx context.Context, path string, bizTag string, params url.Values) (resp *response, addr string, err error) {
+	var (
+		start   = int(atomic.AddUint32(&client.next, 1) - 1)
+		backoff = client.conf.Backoff
+		wait    time.Duration
+	)
+
+	for attempt := 0; ; attempt++ {
+		// 每次尝试重新读取服务地址, 重试时可以使用刚发现的实例
+		current := client.endpoints.Load()
+		if len(current.addrs) == 0 {
+			return nil, "", ErrNoAddrs
+		}
+		index := current.pick(start + attempt)
+		addr = current.addrs[index]
+
+		if resp, wait, err = client.once(ctx, addr, path, bizTag, params); err == nil {
+			return
+		}
+
+		// 连接失败的地址暂停使用一段时间, 避免每个请求都先等待它超时
+		var serverErr *Error
+		if !errors.As(err, &serverErr) && ctx.Err() == nil {
+			atomic.StoreInt64(&current.downUntil[index], time.Now().Add(client.conf.Cooldown).UnixNano())
+		} else if serverErr != nil && !serverErr.retryable() {
+			return
+		}
+		if attempt >= client.conf.Retries || ctx.Err() != nil {
+			return
+		}
+
+		// 指数退避并加入随机抖动, 服务端给出 Retry-After 时至少等待该时长
+		sleep := backoff/2 + rand.N(backoff)
+		if wait > sleep {
+			sleep = wait
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff = min(backoff*2, client.conf.MaxBackoff)
+	}
+}
+
+// pick 从第 i 个地址开始轮询, 跳过暂停使用的地址; 全部暂停时仍使用第 i 个
+func (current *endpoints) pick(i int) int {
+	var (
+		now = time.Now().UnixNano()
+		n   = len(current.addrs)
+	)
+
+	for j := 0; j < n; j++ {
+		index := (i + j) % n
+		if atomic.LoadInt64(&current.downUntil[index]) <= now {
+			return index
+		}
+	}
+	return i % n
+}
+
+// once 向一个地址发送一次请求, wait 为服务端通过 Retry-After 要求的等待时间
+func (client *Client) once(ctx context.Context, addr string, path string, bizTag string, params url.Values) (resp *response, wait time.Duration, err error) {
+	var (
+		req     *http.Request
+		httpRsp *http.Response
+		body    []byte
+	)
+
+	ctx, cancelFunc := context.WithTimeout(ctx, client.conf.Timeout)
+	defer cancelFunc()
+
+	query := url.Values{"biz_tag": {bizTag}}
+	for key, values := range params {
+		query[key] = values
+	}
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, addr+path+"?"+query.Encode(), nil); err != nil {
+		return
+	}
+	if client.conf.APIKey != "" {
+		req.Header.Set(client.conf.APIKeyHeader, client.conf.APIKey)
+	}
+	if client.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.conf.Token)
+	}
+	if client.conf.WaitTimeout != 0 {
+		hint := max(client.conf.WaitTimeout, 0)
+		if deadline, ok := ctx.Deadline(); ok {
+			hint = min(hint, max(time.Until(deadline), 0))
+		}
+		req.Header.Set("X-Wait-Timeout", strconv.FormatInt(hint.Milliseconds(), 10))
+	}
+
+	if httpRsp, err = client.httpClient.Do(req); err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	// 读完响应体, 连接才能放回连接池复用
+	if body, err = io.ReadAll(httpRsp.Body); err != nil {
+		return
+	}
+	if seconds, e := strconv.Atoi(httpRsp.Header.Get("Retry-After")); e == nil {
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	resp = &response{}
+	if e := json.Unmarshal(body, resp); e != nil {
+		// IP 过滤等中间件返回纯文本错误
+		resp = nil
+		if httpRsp.StatusCode == http.StatusOK {
+			err = fmt.Errorf("leaf client: %s: invalid response: %w", addr, e)
+			return
+		}
+		err = &Error{Addr: addr, Status: httpRsp.StatusCode, ErrNo: ErrNoFailed, Msg: strings.TrimSpace(string(body)), RequestID: httpRsp.Header.Get("X-Request-ID")}
+		return
+	}
+	if httpRsp.StatusCode != http.StatusOK || resp.ErrNo != 0 {
+		err = &Error{Addr: addr, Status: httpRsp.StatusCode, ErrNo: resp.ErrNo, Msg: resp.Msg, Cause: resp.Cause, Retryable: resp.Retryable,
+			RequestID: httpRsp.Header.Get("X-Request-ID")}
+	}
+	return
+}
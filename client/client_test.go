@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServer 模拟服务端, 按顺序返回 /alloc 的号码, failures 次内返回指定的错误
+type fakeServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	next     int64
+	failures int
+	status   int
+	errNo    int
+	cause    string // 错误响应中的错误原因, 为空时模拟旧版本服务端
+	retry    bool   // 错误响应中的 retryable
+	requests int64
+	headers  http.Header
+	ttl      int64               // /lease 返回的租约有效期（毫秒）
+	leases   map[string][]string // 续约、归还、确认和释放请求的标识和 next 参数, 按路径记录
+}
+
+func newFakeServer(t *testing.T, first int64) *fakeServer {
+	server := &fakeServer{next: first, ttl: 60000, leases: map[string][]string{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&server.requests, 1)
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		server.headers = r.Header.Clone()
+		if server.failures > 0 {
+			server.failures--
+			w.Header().Set("X-Request-ID", "req-1")
+			w.WriteHeader(server.status)
+			fmt.Fprintf(w, `{"err_no":%d,"msg":"fake failure","id":0,"retryable":%t,"cause":%q}`, server.errNo, server.retry, server.cause)
+			return
+		}
+		switch r.URL.Path {
+		case "/alloc":
+			server.next++
+			fmt.Fprintf(w, `{"err_no":0,"msg":"success","id":%d}`, server.next)
+		case "/health":
+			fmt.Fprint(w, `{"err_no":0,"msg":"success","left":42}`)
+		case "/lease": // 每次租出 10 个号码
+			fmt.Fprintf(w, `{"err_no":0,"msg":"success","lease_id":"L%d","left":%d,"right":%d,"ttl":%d}`,
+				server.next, server.next, server.next+10, server.ttl)
+			server.next += 10
+		case "/reserve":
+			server.next++
+			fmt.Fprintf(w, `{"err_no":0,"msg":"success","reservation_id":"R%d","id":%d}`, server.next, server.next)
+		case "/reserve/confirm", "/reserve/release", "/lease/renew", "/lease/release":
+			query := r.URL.Query()
+			server.leases[r.URL.Path] = append(server.leases[r.URL.Path], query.Get("lease_id")+query.Get("reservation_id")+":"+query.Get("next"))
+			fmt.Fprintf(w, `{"err_no":0,"msg":"success","ttl":%d}`, server.ttl)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// failNext 接下来的 n 个请求返回错误
+func (server *fakeServer) failNext(n int, status int, errNo int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.failures, server.status, server.errNo = n, status, errNo
+}
+
+func newTestClient(t *testing.T, conf Config) *Client {
+	t.Helper()
+
+	conf.Backoff = time.Millisecond
+	client, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNextIDRetry(t *testing.T) {
+	server := newFakeServer(t, 100)
+	client := newTestClient(t, Config{Addrs: []string{server.URL}, APIKey: "secret"})
+
+	// 过载和号段暂时耗尽可以重试
+	server.failNext(2, http.StatusServiceUnavailable, ErrNoOverloaded)
+	id, err := client.NextID(context.Background(), "test")
+	if err != nil || id != 101 {
+		t.Fatalf("NextID = (%d, %v), want (101, nil)", id, err)
+	}
+	if got := server.headers.Get("X-API-Key"); got != "secret" {
+		t.Fatalf("X-API-Key = %q, want secret", got)
+	}
+
+	// 重试次数用完后返回服务端错误
+	server.failNext(3, http.StatusInternalServerError, ErrNoFailed)
+	var serverErr *Error
+	if _, err = client.NextID(context.Background(), "test"); !errors.As(err, &serverErr) || serverErr.ErrNo != ErrNoFailed {
+		t.Fatalf("NextID err = %v, want server error", err)
+	}
+
+	// 参数错误不重试
+	requests := atomic.LoadInt64(&server.requests)
+	server.failNext(1, http.StatusBadRequest, ErrNoInvalidBizTag)
+	if _, err = client.NextID(context.Background(), "bad tag"); !errors.As(err, &serverErr) || serverErr.ErrNo != ErrNoInvalidBizTag {
+		t.Fatalf("NextID err = %v, want invalid biz_tag", err)
+	}
+	if serverErr.RequestID != "req-1" {
+		t.Fatalf("RequestID = %q, want the X-Request-ID response header", serverErr.RequestID)
+	}
+	if n := atomic.LoadInt64(&server.requests) - requests; n != 1 {
+		t.Fatalf("%d requests for a bad request, want 1", n)
+	}
+}
+
+func TestNextIDServerCause(t *testing.T) {
+	server := newFakeServer(t, 100)
+	client := newTestClient(t, Config{Addrs: []string{server.URL}})
+
+	// 服务端判断不可重试时, 即使状态码为 500 也不重试
+	server.cause, server.retry = CauseExhausted, false
+	server.failNext(3, http.StatusInternalServerError, ErrNoFailed)
+	var serverErr *Error
+	if _, err := client.NextID(context.Background(), "test"); !errors.As(err, &serverErr) || serverErr.Cause != CauseExhausted || serverErr.Retryable {
+		t.Fatalf("NextID err = %v, want a non-retryable exhausted error", err)
+	}
+	if requests := atomic.LoadInt64(&server.requests); requests != 1 {
+		t.Fatalf("%d requests, want 1", requests)
+	}
+}
+
+func TestNextIDWaitTimeout(t *testing.T) {
+	server := newFakeServer(t, 100)
+	waitHint := func(wait time.Duration) string {
+		client := newTestClient(t, Config{Addrs: []string{server.URL}, WaitTimeout: wait, Timeout: time.Second})
+		if _, err := client.NextID(context.Background(), "test"); err != nil {
+			t.Fatal(err)
+		}
+		return server.headers.Get("X-Wait-Timeout")
+	}
+
+	// 0 使用服务端默认值, 负数表示不等待
+	for wait, want := range map[time.Duration]string{0: "", -1: "0", 500 * time.Millisecond: "500"} {
+		if got := waitHint(wait); got != want {
+			t.Errorf("WaitTimeout %v: X-Wait-Timeout = %q, want %q", wait, got, want)
+		}
+	}
+
+	// 不超过请求剩余的时间
+	if ms, err := strconv.Atoi(waitHint(time.Minute)); err != nil || ms <= 0 || ms > 1000 {
+		t.Fatalf("X-Wait-Timeout = (%d, %v), want at most the 1s request timeout", ms, err)
+	}
+}
+
+func TestNextIDFailover(t *testing.T) {
+	down := newFakeServer(t, 0)
+	down.Close() // 连接被拒绝
+	up := newFakeServer(t, 200)
+	client := newTestClient(t, Config{Addrs: []string{down.URL, up.URL}, Cooldown: time.Minute})
+
+	for i := 0; i < 10; i++ {
+		if _, err := client.NextID(context.Background(), "test"); err != nil {
+			t.Fatalf("NextID #%d: %v", i, err)
+		}
+	}
+
+	// 不可达的地址暂停使用后, 后续请求直接发往可用的地址
+	if n := atomic.LoadInt64(&up.requests); n != 10 {
+		t.Fatalf("%d requests reached the healthy server, want 10", n)
+	}
+	if n := atomic.LoadInt64(&down.requests); n != 0 {
+		t.Fatalf("closed server received %d requests", n)
+	}
+}
+
+func TestNextIDs(t *testing.T) {
+	servers := []*fakeServer{newFakeServer(t, 0), newFakeServer(t, 1000)}
+	client := newTestClient(t, Config{Addrs: []string{servers[0].URL, servers[1].URL}})
+
+	ids, err := client.NextIDs(context.Background(), "test", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate id %d", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != 50 {
+		t.Fatalf("got %d ids, want 50", len(seen))
+	}
+
+	// 请求轮询分布到所有地址
+	for i, server := range servers {
+		if n := atomic.LoadInt64(&server.requests); n != 25 {
+			t.Fatalf("server %d received %d requests, want 25", i, n)
+		}
+	}
+
+	// 任何一个请求最终失败时整体失败
+	servers[0].failNext(100, http.StatusForbidden, ErrNoForbidden)
+	servers[1].failNext(100, http.StatusForbidden, ErrNoForbidden)
+	if _, err = client.NextIDs(context.Background(), "test", 10); err == nil {
+		t.Fatal("NextIDs succeeded while every server rejects the request")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	server := newFakeServer(t, 0)
+	client := newTestClient(t, Config{Addrs: []string{server.URL + "/"}})
+
+	health, err := client.Health(context.Background(), "test")
+	if err != nil || health.Left != 42 || health.Addr != server.URL {
+		t.Fatalf("Health = (%+v, %v), want 42 left on %s", health, err, server.URL)
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EtcdConfig 从 etcd 发现服务地址的配置, 与服务端的 registry.etcd 对应
+// 服务端把地址写入 {prefix}{实例标识} 并绑定租约, 客户端读取该前缀下的所有地址并监听变化,
+// 实例退出或租约过期后地址随之删除, 新实例注册后立即加入轮询
+type EtcdConfig struct {
+	Endpoints []string      // etcd 的 HTTP 地址, 如 http://10.0.0.1:2379, 依次尝试
+	Prefix    string        // 服务端注册的键前缀, 默认 /leaf-segment/
+	Timeout   time.Duration // 读取地址的超时时间, 默认 3 秒
+}
+
+// etcdKeyValue etcd v3 HTTP/JSON 接口中的键值, []byte 按 base64 编解码
+type etcdKeyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+// etcdHeader etcd 响应头, int64 字段以字符串表示
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+// etcdWatcher 读取并监听 etcd 中的服务地址, 更新到客户端
+type etcdWatcher struct {
+	client     *Client
+	conf       EtcdConfig
+	httpClient *http.Client      // 请求 etcd 的 HTTP 客户端, 不设置超时, 监听是长连接
+	members    map[string]string // 键到服务地址, 只在 sync 和监听线程中读写
+	revision   int64             // 已经处理到的版本, 只在 sync 和监听线程中读写
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	wait       sync.WaitGroup // 等待监听线程退出
+}
+
+// newEtcdWatcher 创建监听
+func newEtcdWatcher(client *Client, conf EtcdConfig) *etcdWatcher {
+	if conf.Prefix == "" {
+		conf.Prefix = "/leaf-segment/"
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3 * time.Second
+	}
+	watcher := &etcdWatcher{client: client, conf: conf, httpClient: &http.Client{}, members: map[string]string{}}
+	watcher.ctx, watcher.cancelFunc = context.WithCancel(context.Background())
+	return watcher
+}
+
+// start 在后台监听
+func (watcher *etcdWatcher) start() {
+	watcher.wait.Add(1)
+	go watcher.run()
+}
+
+// stop 停止监听
+func (watcher *etcdWatcher) stop() {
+	watcher.cancelFunc()
+	watcher.wait.Wait()
+}
+
+// rangeEnd 返回前缀的范围终点: 最后一个字节加一
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0} // 前缀全为 0xff 时读取所有更大的键
+}
+
+// sync 读取前缀下的所有地址, 替换客户端的服务地址
+func (watcher *etcdWatcher) sync() (err error) {
+	var (
+		rsp struct {
+			Header etcdHeader     `json:"header"`
+			Kvs    []etcdKeyValue `json:"kvs"`
+		}
+		response *http.Response
+	)
+
+	ctx, cancelFunc := context.WithTimeout(watcher.ctx, watcher.conf.Timeout)
+	defer cancelFunc()
+
+	request := map[string]any{"key": []byte(watcher.conf.Prefix), "range_end": rangeEnd(watcher.conf.Prefix)}
+	if response, err = watcher.post(ctx, "/v3/kv/range", request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if err = json.NewDecoder(response.Body).Decode(&rsp); err != nil {
+		return fmt.Errorf("leaf client: etcd range: %w", err)
+	}
+
+	watcher.members = map[string]string{}
+	for _, kv := range rsp.Kvs {
+		watcher.members[string(kv.Key)] = strings.TrimRight(string(kv.Value), "/")
+	}
+	watcher.revision = rsp.Header.Revision
+	watcher.publish()
+	return nil
+}
+
+// run 从已处理的版本之后监听变化, 连接断开后退避重连, 版本已被压缩时重新读取
+func (watcher *etcdWatcher) run() {
+	defer watcher.wait.Done()
+
+	backoff := watcher.client.conf.Backoff
+	for {
+		var err error
+		if watcher.revision == 0 {
+			err = watcher.sync()
+		} else {
+			err = watcher.watch()
+		}
+		if watcher.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			backoff = watcher.client.conf.Backoff
+			continue
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-watcher.ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff = min(backoff*2, watcher.client.conf.MaxBackoff)
+	}
+}
+
+// errCompacted 需要的版本已被压缩, 需要重新读取
+var errCompacted = errors.New("leaf client: etcd revision compacted")
+
+// watch 建立一次监听并处理事件, 直到连接断开; 收到过事件时返回 nil
+func (watcher *etcdWatcher) watch() (err error) {
+	var (
+		rsp     *http.Response
+		message struct {
+			Result *struct {
+				Header          etcdHeader `json:"header"`
+				CompactRevision int64      `json:"compact_revision,string"`
+				Canceled        bool       `json:"canceled"`
+				Events          []struct {
+					Type string       `json:"type"` // PUT 时省略
+					Kv   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		received bool
+	)
+
+	request := map[string]any{"create_request": map[string]any{
+		"key":            []byte(watcher.conf.Prefix),
+		"range_end":      rangeEnd(watcher.conf.Prefix),
+		"start_revision": watcher.revision + 1,
+	}}
+	if rsp, err = watcher.post(watcher.ctx, "/v3/watch", request); err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	decoder := json.NewDecoder(rsp.Body)
+	for {
+		message.Result, message.Error = nil, nil
+		if err = decoder.Decode(&message); err != nil {
+			if received && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+				err = nil
+			}
+			return
+		}
+		if message.Error != nil {
+			return fmt.Errorf("leaf client: etcd watch: %s", message.Error.Message)
+		}
+		if message.Result == nil {
+			continue
+		}
+		if message.Result.CompactRevision != 0 {
+			watcher.revision = 0
+			return errCompacted
+		}
+		if message.Result.Canceled {
+			return errors.New("leaf client: etcd watch canceled")
+		}
+
+		received = true
+		if len(message.Result.Events) == 0 {
+			continue
+		}
+		for _, event := range message.Result.Events {
+			if event.Type == "DELETE" {
+				delete(watcher.members, string(event.Kv.Key))
+			} else {
+				watcher.members[string(event.Kv.Key)] = strings.TrimRight(string(event.Kv.Value), "/")
+			}
+			watcher.revision = max(watcher.revision, event.Kv.ModRevision)
+		}
+		watcher.publish()
+	}
+}
+
+// publish 按键的顺序更新客户端的服务地址, etcd 中没有实例时使用固定配置的地址
+func (watcher *etcdWatcher) publish() {
+	keys := make([]string, 0, len(watcher.members))
+	for key := range watcher.members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	addrs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		addrs = append(addrs, watcher.members[key])
+	}
+	if len(addrs) == 0 {
+		addrs = watcher.client.conf.Addrs
+	}
+	watcher.client.setAddrs(addrs)
+}
+
+// post 依次向各个 etcd 地址发送请求, 返回第一个成功的响应
+func (watcher *etcdWatcher) post(ctx context.Context, path string, request any) (rsp *http.Response, err error) {
+	var (
+		body []byte
+		req  *http.Request
+	)
+
+	if body, err = json.Marshal(request); err != nil {
+		return
+	}
+	err = errors.New("leaf client: no etcd endpoint")
+	for _, endpoint := range watcher.conf.Endpoints {
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+path, bytes.NewReader(body)); err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if rsp, err = watcher.httpClient.Do(req); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		if rsp.StatusCode == http.StatusOK {
+			return rsp, nil
+		}
+		content, _ := io.ReadAll(io.LimitReader(rsp.Body, 4096))
+		rsp.Body.Close()
+		err = fmt.Errorf("leaf client: etcd %s: status %d: %s", endpoint, rsp.StatusCode, strings.TrimSpace(string(content)))
+	}
+	return nil, err
+}
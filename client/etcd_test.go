@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEtcd 模拟 etcd v3 HTTP/JSON 接口的 range 和 watch
+type fakeEtcd struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	kvs      map[string]string
+	revision int64
+	events   chan string // 推送给监听连接的事件
+	starts   []int64     // 各次监听请求的起始版本
+}
+
+func newFakeEtcd(t *testing.T, kvs map[string]string) *fakeEtcd {
+	etcd := &fakeEtcd{kvs: kvs, revision: 10, events: make(chan string, 16)}
+	etcd.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			var request struct {
+				Key      []byte `json:"key"`
+				RangeEnd []byte `json:"range_end"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&request)
+			etcd.mutex.Lock()
+			defer etcd.mutex.Unlock()
+			var items []string
+			for key, value := range etcd.kvs {
+				if key < string(request.Key) || key >= string(request.RangeEnd) {
+					continue
+				}
+				items = append(items, fmt.Sprintf(`{"key":%q,"value":%q,"mod_revision":"%d"}`, encode(key), encode(value), etcd.revision))
+			}
+			fmt.Fprintf(w, `{"header":{"revision":"%d"},"kvs":[%s]}`, etcd.revision, join(items))
+		case "/v3/watch":
+			var request struct {
+				CreateRequest struct {
+					StartRevision int64 `json:"start_revision"`
+				} `json:"create_request"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&request)
+			etcd.mutex.Lock()
+			etcd.starts = append(etcd.starts, request.CreateRequest.StartRevision)
+			etcd.mutex.Unlock()
+			fmt.Fprint(w, `{"result":{"header":{"revision":"10"},"created":true}}`+"\n")
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case event := <-etcd.events:
+					fmt.Fprint(w, event+"\n")
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(etcd.Close)
+	return etcd
+}
+
+// push 推送一个事件, deleted 为 true 时是删除
+func (etcd *fakeEtcd) push(key string, value string, deleted bool) {
+	etcd.mutex.Lock()
+	etcd.revision++
+	kv := fmt.Sprintf(`{"key":%q,"value":%q,"mod_revision":"%d"}`, encode(key), encode(value), etcd.revision)
+	etcd.mutex.Unlock()
+
+	typ := ""
+	if deleted {
+		typ = `"type":"DELETE",`
+	}
+	etcd.events <- fmt.Sprintf(`{"result":{"events":[{%s"kv":%s}]}}`, typ, kv)
+}
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func join(items []string) (s string) {
+	for i, item := range items {
+		if i > 0 {
+			s += ","
+		}
+		s += item
+	}
+	return
+}
+
+// waitAddrs 等待客户端的服务地址变为 want
+func waitAddrs(t *testing.T, client *Client, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(client.endpoints.Load().addrs, want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("addrs = %v, want %v", client.endpoints.Load().addrs, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEtcdDiscovery(t *testing.T) {
+	a, b := newFakeServer(t, 100), newFakeServer(t, 200)
+	etcd := newFakeEtcd(t, map[string]string{"/leaf-segment/a": a.URL + "/", "/other/x": "http://unused"})
+
+	client := newTestClient(t, Config{Etcd: &EtcdConfig{Endpoints: []string{etcd.URL}}})
+	t.Cleanup(func() { _ = client.Close() })
+
+	// 只读取前缀下的地址, 从读取时的版本之后开始监听
+	waitAddrs(t, client, []string{a.URL})
+	if id, err := client.NextID(context.Background(), "test"); err != nil || id != 101 {
+		t.Fatalf("NextID = (%d, %v), want (101, nil)", id, err)
+	}
+
+	// 实例替换后请求发往新实例
+	etcd.push("/leaf-segment/b", b.URL, false)
+	waitAddrs(t, client, []string{a.URL, b.URL})
+	etcd.push("/leaf-segment/a", "", true)
+	waitAddrs(t, client, []string{b.URL})
+	if id, err := client.NextID(context.Background(), "test"); err != nil || id != 201 {
+		t.Fatalf("NextID = (%d, %v), want (201, nil)", id, err)
+	}
+
+	// etcd 中没有实例时没有可用地址
+	etcd.push("/leaf-segment/b", "", true)
+	waitAddrs(t, client, nil)
+	if _, err := client.NextID(context.Background(), "test"); err != ErrNoAddrs {
+		t.Fatalf("NextID err = %v, want ErrNoAddrs", err)
+	}
+	etcd.mutex.Lock()
+	defer etcd.mutex.Unlock()
+	if !reflect.DeepEqual(etcd.starts, []int64{11}) {
+		t.Fatalf("watch start revisions = %v, want [11]", etcd.starts)
+	}
+}
+
+func TestEtcdUnavailable(t *testing.T) {
+	etcd := newFakeEtcd(t, map[string]string{})
+	etcd.Close()
+
+	// 没有固定地址时创建失败
+	if _, err := New(Config{Etcd: &EtcdConfig{Endpoints: []string{etcd.URL}}}); err == nil {
+		t.Fatal("New succeeded with etcd down and no addrs")
+	}
+
+	// 有固定地址时使用固定地址, 后台继续重试
+	server := newFakeServer(t, 300)
+	client := newTestClient(t, Config{Addrs: []string{server.URL}, Etcd: &EtcdConfig{Endpoints: []string{etcd.URL}}})
+	defer client.Close()
+	if id, err := client.NextID(context.Background(), "test"); err != nil || id != 301 {
+		t.Fatalf("NextID = (%d, %v), want (301, nil)", id, err)
+	}
+}
+
+func TestRangeEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"/leaf/": "/leaf0", "a\xff": "b", "\xff\xff": "\x00"} {
+		if got := string(rangeEnd(prefix)); got != want {
+			t.Errorf("rangeEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrLeaserClosed Leaser 已关闭
+var ErrLeaserClosed = errors.New("leaf client: leaser closed")
+
+// Lease 服务端租给客户端的号段 [Left, Right)
+// 租约只记录在租出它的服务实例上, 续约和归还必须发往 Addr
+type Lease struct {
+	Addr    string        // 租出号段的服务地址
+	ID      string        // 租约标识
+	Left    int64         // 号段左边界（包含）
+	Right   int64         // 号段右边界（不包含）
+	TTL     time.Duration // 租约有效期, 到期前未续约时服务端将剩余号码记为烧毁
+	Renewed time.Time     // 租用或最近一次续约成功的本地时间
+}
+
+// Lease 向服务端租用一个完整的号段, 服务端需开启 lease.enable
+func (client *Client) Lease(ctx context.Context, bizTag string) (*Lease, error) {
+	resp, addr, err := client.do(ctx, "/lease", bizTag, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Lease{
+		Addr:    addr,
+		ID:      resp.LeaseID,
+		Left:    resp.Left,
+		Right:   resp.Right,
+		TTL:     time.Duration(resp.TTL) * time.Millisecond,
+		Renewed: time.Now(),
+	}, nil
+}
+
+// RenewLease 延长租约有效期, next 为下一个未使用的号码, 服务端据此统计过期时烧毁的号码数
+// 租约已过期时返回 404 错误, 过期的号码不会再租给其他客户端, 仍可继续使用
+func (client *Client) RenewLease(ctx context.Context, bizTag string, lease *Lease, next int64) error {
+	resp, _, err := client.once(ctx, lease.Addr, "/lease/renew", bizTag, leaseParams(lease, next))
+	if err != nil {
+		return err
+	}
+	lease.TTL, lease.Renewed = time.Duration(resp.TTL)*time.Millisecond, time.Now()
+	return nil
+}
+
+// ReleaseLease 归还租约, [next, Right) 中的号码由服务端回收, 归还后不能再使用
+func (client *Client) ReleaseLease(ctx context.Context, bizTag string, lease *Lease, next int64) error {
+	_, _, err := client.once(ctx, lease.Addr, "/lease/release", bizTag, leaseParams(lease, next))
+	return err
+}
+
+// leaseParams 续约和归还请求的参数
+func leaseParams(lease *Lease, next int64) url.Values {
+	return url.Values{"lease_id": {lease.ID}, "next": {strconv.FormatInt(next, 10)}}
+}
+
+// Leaser 在本地从租来的号段分配ID, 每个号段只访问一次服务端
+// 当前租约剩余不足 LeaseRefill 时在后台预取下一个, 与服务端的双Buffer相同
+// 租约过半有效期时随分配请求在后台续约; 进程退出前调用 Close 归还未用完的号码, 否则号码不连续但不会重复
+type Leaser struct {
+	client   *Client
+	bizTag   string
+	mutex    sync.Mutex
+	current  *Lease        // 正在分配的租约, 尚未租到时为 nil
+	next     int64         // current 中下一个分配的号码
+	pending  *Lease        // 预取的下一个租约
+	running  bool          // 是否正在租用
+	renewing bool          // 是否正在续约
+	done     chan struct{} // 本次租用结束时关闭
+	err      error         // 最近一次租用失败的错误, 被一次分配请求取走后清空
+	closed   bool          // 是否已关闭
+}
+
+// NewLeaser 创建业务的本地分配器, 并发安全, 同一个业务应在进程内复用
+func (client *Client) NewLeaser(bizTag string) *Leaser {
+	return &Leaser{client: client, bizTag: bizTag}
+}
+
+// NextID 从本地租约分配一个ID, 租约用完且下一个尚未租到时等待
+func (leaser *Leaser) NextID(ctx context.Context) (id int64, err error) {
+	leaser.mutex.Lock()
+	defer leaser.mutex.Unlock()
+
+	for {
+		if leaser.closed {
+			return 0, ErrLeaserClosed
+		}
+		if leaser.current != nil && leaser.next < leaser.current.Right {
+			id = leaser.next
+			leaser.next++
+
+			// 剩余不足时提前预取, 避免用完时等待服务端
+			size := leaser.current.Right - leaser.current.Left
+			if float64(leaser.current.Right-leaser.next) < float64(size)*leaser.client.conf.LeaseRefill && leaser.pending == nil {
+				leaser.fetch()
+			}
+			leaser.renew()
+			return
+		}
+		if leaser.pending != nil {
+			leaser.current, leaser.pending = leaser.pending, nil
+			leaser.next = leaser.current.Left
+			continue
+		}
+		if leaser.err != nil {
+			err, leaser.err = leaser.err, nil
+			return
+		}
+
+		// 没有可用的号码, 等待租用结束
+		leaser.fetch()
+		done := leaser.done
+		leaser.mutex.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			leaser.mutex.Lock()
+			return 0, ctx.Err()
+		}
+		leaser.mutex.Lock()
+	}
+}
+
+// Close 归还当前租约中未使用的号码和预取的租约, 之后 NextID 返回 ErrLeaserClosed
+func (leaser *Leaser) Close(ctx context.Context) error {
+	leaser.mutex.Lock()
+	leaser.closed = true
+	done := leaser.done
+	running := leaser.running
+	leaser.mutex.Unlock()
+
+	// 等待正在进行的租用, 租到的号段一并归还
+	if running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	leaser.mutex.Lock()
+	current, next, pending := leaser.current, leaser.next, leaser.pending
+	leaser.current, leaser.pending = nil, nil
+	leaser.mutex.Unlock()
+
+	var errs []error
+	if current != nil {
+		errs = append(errs, leaser.client.ReleaseLease(ctx, leaser.bizTag, current, next))
+	}
+	if pending != nil {
+		errs = append(errs, leaser.client.ReleaseLease(ctx, leaser.bizTag, pending, pending.Left))
+	}
+	return errors.Join(errs...)
+}
+
+// fetch 没有租用在进行时在后台租用下一个号段, 调用方需持有锁
+func (leaser *Leaser) fetch() {
+	if leaser.running {
+		return
+	}
+	leaser.running = true
+	leaser.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		// 租约为多个请求共享, 不随单个请求取消
+		lease, err := leaser.client.Lease(context.Background(), leaser.bizTag)
+
+		leaser.mutex.Lock()
+		defer leaser.mutex.Unlock()
+		leaser.pending, leaser.err = lease, err
+		leaser.running = false
+		close(done)
+	}(leaser.done)
+}
+
+// renew 当前租约已过半有效期时在后台续约, 调用方需持有锁
+// 续约失败不影响分配: 过期的号码只会被服务端记为烧毁, 不会再租给其他客户端
+func (leaser *Leaser) renew() {
+	lease := leaser.current
+	if leaser.renewing || lease.TTL <= 0 || time.Since(lease.Renewed) < lease.TTL/2 {
+		return
+	}
+	leaser.renewing = true
+
+	renewed := *lease
+	go func(next int64) {
+		err := leaser.client.RenewLease(context.Background(), leaser.bizTag, &renewed, next)
+
+		leaser.mutex.Lock()
+		defer leaser.mutex.Unlock()
+		if err != nil {
+			renewed.TTL = 0 // 租约已过期或服务端不可用, 不再续约
+		}
+		lease.TTL, lease.Renewed = renewed.TTL, renewed.Renewed
+		leaser.renewing = false
+	}(leaser.next)
+}
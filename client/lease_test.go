@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaser(t *testing.T) {
+	server := newFakeServer(t, 0)
+	client := newTestClient(t, Config{Addrs: []string{server.URL}})
+	leaser := client.NewLeaser("test")
+
+	const n = 95
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		ids   = map[int64]bool{}
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n/5; j++ {
+				id, err := leaser.NextID(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				ids[id] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) != n {
+		t.Fatalf("got %d distinct ids, want %d", len(ids), n)
+	}
+	for id := range ids {
+		if id < 0 || id >= n+10 {
+			t.Fatalf("id %d outside the leased segments", id)
+		}
+	}
+
+	// 每 10 个号码租用一次, 加上剩余不足时的一次预取
+	if requests := atomic.LoadInt64(&server.requests); requests < n/10 || requests > n/10+2 {
+		t.Fatalf("%d lease requests for %d ids", requests, n)
+	}
+}
+
+func TestLeaserError(t *testing.T) {
+	server := newFakeServer(t, 0)
+	client := newTestClient(t, Config{Addrs: []string{server.URL}, Retries: -1})
+	leaser := client.NewLeaser("test")
+
+	// 租用失败时返回错误, 下一次请求重新租用
+	server.failNext(1, http.StatusNotFound, ErrNoFailed)
+	if _, err := leaser.NextID(context.Background()); err == nil {
+		t.Fatal("NextID succeeded while leasing fails")
+	}
+	if id, err := leaser.NextID(context.Background()); err != nil || id != 0 {
+		t.Fatalf("NextID = (%d, %v), want (0, nil)", id, err)
+	}
+}
+
+func TestLeaserRenewClose(t *testing.T) {
+	server := newFakeServer(t, 0)
+	server.ttl = 2 // 每次分配时租约都已过半有效期
+	client := newTestClient(t, Config{Addrs: []string{server.URL}})
+	leaser := client.NewLeaser("test")
+
+	for i := 0; i < 3; i++ {
+		if _, err := leaser.NextID(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 续约携带租约标识和下一个未使用的号码, 第一次分配时租约刚租到, 从第二次开始续约
+	var renewals []string
+	for deadline := time.Now().Add(time.Second); len(renewals) == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		server.mutex.Lock()
+		renewals = append([]string(nil), server.leases["/lease/renew"]...)
+		server.mutex.Unlock()
+	}
+	if len(renewals) == 0 || renewals[0] != "L0:2" {
+		t.Fatalf("renewals = %q, want the first one for L0 at next 2", renewals)
+	}
+
+	// 关闭时归还当前租约中未使用的号码
+	if err := leaser.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	server.mutex.Lock()
+	released := server.leases["/lease/release"]
+	server.mutex.Unlock()
+	if len(released) != 1 || released[0] != "L0:3" {
+		t.Fatalf("released = %q, want L0 at next 3", released)
+	}
+	if _, err := leaser.NextID(context.Background()); !errors.Is(err, ErrLeaserClosed) {
+		t.Fatalf("NextID after Close err = %v, want ErrLeaserClosed", err)
+	}
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"net/url"
+)
+
+// Reservation 服务端预留的 ID, 确认前不应对外使用
+// 预留只记录在预留它的服务实例上, 确认和释放必须发往 Addr
+type Reservation struct {
+	Addr          string // 预留 ID 的服务地址
+	ReservationID string // 预留标识
+	ID            int64  // 预留的 ID
+}
+
+// Reserve 预留一个 ID, 服务端需开启 reserve.enable, 到期未确认的 ID 作废
+func (client *Client) Reserve(ctx context.Context, bizTag string) (*Reservation, error) {
+	resp, addr, err := client.do(ctx, "/reserve", bizTag, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Reservation{Addr: addr, ReservationID: resp.ReservationID, ID: resp.ID}, nil
+}
+
+// Confirm 确认预留, 之后 ID 归调用方所有; 预留已过期时返回 404 错误
+func (client *Client) Confirm(ctx context.Context, bizTag string, reservation *Reservation) error {
+	_, _, err := client.once(ctx, reservation.Addr, "/reserve/confirm", bizTag, url.Values{"reservation_id": {reservation.ReservationID}})
+	return err
+}
+
+// Release 释放预留, ID 由之后的预留复用
+func (client *Client) Release(ctx context.Context, bizTag string, reservation *Reservation) error {
+	_, _, err := client.once(ctx, reservation.Addr, "/reserve/release", bizTag, url.Values{"reservation_id": {reservation.ReservationID}})
+	return err
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	servers := []*fakeServer{newFakeServer(t, 0), newFakeServer(t, 1000)}
+	client := newTestClient(t, Config{Addrs: []string{servers[0].URL, servers[1].URL}})
+
+	// 确认和释放发往预留 ID 的服务实例
+	for i := 0; i < 2; i++ {
+		reservation, err := client.Reserve(context.Background(), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = client.Confirm(context.Background(), "test", reservation); err != nil {
+			t.Fatal(err)
+		}
+		if err = client.Release(context.Background(), "test", reservation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, server := range servers {
+		server.mutex.Lock()
+		confirmed, released := server.leases["/reserve/confirm"], server.leases["/reserve/release"]
+		server.mutex.Unlock()
+		want := []string{"R1:", "R1001:"}[i]
+		if len(confirmed) != 1 || confirmed[0] != want || len(released) != 1 || released[0] != want {
+			t.Fatalf("server %d: confirmed %q, released %q, want %q", i, confirmed, released, want)
+		}
+	}
+}
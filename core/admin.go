@@ -0,0 +1,162 @@
+package core
+
+import (
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// AdminConfig 定义管理端口的配置
+type AdminConfig struct {
+	Port         int      `json:"port"`          // 管理端口的监听端口, 0 且 listen 为空时不启用
+	Listen       []string `json:"listen"`        // 监听地址列表, 格式同 http_listen, 非空时代替 port, 如 ["127.0.0.1:8881"] 只在本机访问
+	EnablePprof  bool     `json:"enable_pprof"`  // 是否挂载 /debug/pprof 性能分析接口
+	EnableExpvar bool     `json:"enable_expvar"` // 是否挂载 /debug/vars 运行时计数接口
+}
+
+// LogLevelResponse 用于封装日志级别查询和调整请求的响应
+type LogLevelResponse struct {
+	ErrNo int    `json:"err_no"` // 错误码
+	Msg   string `json:"msg"`    // 错误或成功消息
+	Level string `json:"level"`  // 当前日志级别
+}
+
+// handleAdminLogLevel 处理日志级别的查询(GET)和调整(PUT)请求
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp  = LogLevelResponse{} // 响应数据
+		err   error                // 错误信息
+		level slog.Level           // 新的日志级别
+		old   slog.Level           // 调整前的日志级别
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		// 解析请求参数, 支持 query/form 中的 level 参数
+		if err = r.ParseForm(); err != nil {
+			goto RESP
+		}
+		if level, err = parseLevel(r.Form.Get("level")); err != nil {
+			goto RESP
+		}
+		old = logLevel.Level()
+		logLevel.Set(level)
+		auditor.record(r, AuditLogLevel, "", old.String()+" -> "+level.String())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+RESP:
+	// 设置响应信息和状态码
+	resp.Level = logLevel.Level().String()
+	if err != nil {
+		resp.ErrNo = -1                      // 错误码
+		resp.Msg = err.Error()               // 错误信息
+		w.WriteHeader(http.StatusBadRequest) // 参数错误返回 HTTP 400
+	} else {
+		resp.Msg = "success" // 成功消息
+	}
+
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
+
+// newAdminServer 创建管理端口的 HTTP 服务器
+func newAdminServer(auths []authenticator) *http.Server {
+	var (
+		handler http.Handler
+	)
+
+	// 创建管理路由
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)                       // Prometheus 指标抓取
+	mux.HandleFunc("/version", handleVersion)                       // 运行中的二进制的构建信息
+	mux.HandleFunc("/admin/loglevel", handleAdminLogLevel)          // 运行时查看/调整日志级别
+	mux.HandleFunc("/admin/audit", handleAdminAudit)                // 查询管理操作审计日志
+	mux.HandleFunc("/admin/segments", handleAdminSegments)          // 查询号段台账
+	mux.HandleFunc("/admin/shard", handleAdminShard)                // 查询号段行所在的号段表
+	mux.HandleFunc("/admin/tags", handleAdminTags)                  // 查询业务及其描述, 修改业务描述
+	mux.HandleFunc("/admin/advance", handleAdminAdvance)            // 跳号, 作废与迁入数据重叠的号码
+	mux.HandleFunc("/admin/leases", handleAdminLeases)              // 查询未到期的号段租约
+	mux.HandleFunc("/admin/reservations", handleAdminReservations)  // 查询待确认的预留
+	mux.HandleFunc("/admin/cluster", handleAdminCluster)            // 查询集群中各实例的号段容量
+	mux.HandleFunc("/admin/cluster/local", handleAdminClusterLocal) // 本实例的号段容量, 供对等实例拉取
+	mux.HandleFunc("/admin/drain", handleAdminDrain)                // 供 preStop 钩子调用, 开始退出
+	mux.HandleFunc("/admin/graphql", handleAdminGraphQL)            // 以 GraphQL 一次查询业务、号段台账、统计和租约
+
+	// 按配置挂载 pprof, 生产环境抓取 CPU/堆/协程剖析无需重新编译
+	if DefaultConfig.Admin.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	// 按配置挂载滑动窗口统计和事件流, 与业务端口上的 /stats 和 /events 相同
+	if DefaultConfig.Stats.Enable {
+		mux.HandleFunc("/stats", handleStats)
+	}
+	if eventBus != nil {
+		mux.HandleFunc("/events", handleEvents)
+	}
+
+	// 按配置挂载 expvar, 供不使用 Prometheus 的环境抓取
+	if DefaultConfig.Admin.EnableExpvar {
+		mux.Handle("/debug/vars", expvar.Handler())
+	}
+
+	// 按配置要求管理权限
+	handler = mux
+	if DefaultConfig.Auth.ProtectAdmin {
+		handler = withAdminAuth(auths, mux)
+	}
+	if namespaces != nil {
+		handler = withAdminNamespace(handler)
+	}
+
+	// CPU 剖析和 trace 会持续输出数十秒, 因此管理端口不设置写入超时
+	return &http.Server{
+		ReadTimeout: time.Duration(DefaultConfig.HttpReadTimeout) * time.Millisecond,
+		Handler:     withRequestID(withGzip(withRecover(handler))),
+	}
+}
+
+// startAdminServer 启动管理端口, 服务异常退出时的错误写入 errChan
+func startAdminServer(errChan chan<- error, listener net.Listener, auths []authenticator) (srv *http.Server, err error) {
+	var (
+		filter *ipFilter
+	)
+
+	// 解析来源地址规则
+	if filter, err = newIPFilter(DefaultConfig.IPFilter.Admin); err != nil {
+		return
+	}
+
+	// 设置管理端口监听, 未注入时按配置监听
+	listeners := []net.Listener{listener}
+	if listener == nil {
+		if listeners, err = listenAll(DefaultConfig.Admin.Listen, DefaultConfig.Admin.Port); err != nil {
+			return
+		}
+	}
+
+	srv = newAdminServer(auths)
+	tuneServer(srv)
+	if eventBus != nil {
+		srv.RegisterOnShutdown(eventBus.disconnect)
+	}
+	srv.Handler = withIPFilter(filter, withCORS(srv.Handler))
+	logger.Info("admin server started", "addr", listenerAddrs(listeners), "pprof", DefaultConfig.Admin.EnablePprof)
+	serveAll(srv, listeners, errChan)
+	return
+}
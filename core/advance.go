@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+var errInvalidPast = errors.New("past must be between 0 and max int64 - 1")
+
+// AdvanceResponse 用于封装跳号请求的响应
+type AdvanceResponse struct {
+	ErrNo  int    `json:"err_no"`  // 错误码
+	Msg    string `json:"msg"`     // 错误或成功消息
+	BizTag string `json:"biz_tag"` // 号段行的业务标识
+	MaxId  int64  `json:"max_id"`  // 跳号后的 max_id, 之后的号段从这里开始
+	Burned int64  `json:"burned"`  // 本次跳过的号码数量, max_id 已经超过 past 时为0
+}
+
+// Advance 将业务的 max_id 前进到 past+1, 之后从号段行获取的号码都大于 past, 中间的号码作废不再分配
+// 用于迁入 ID 与当前范围重叠的外部数据; max_id 已经超过 past 时不做修改
+// 返回跳号后的 max_id 和跳过的号码数量; 业务不存在时返回 ErrBizTagNotFound
+func (data *Data) Advance(ctx context.Context, bizTag string, past int64) (maxId int64, burned int64, err error) {
+	var (
+		tx    *sql.Tx                                             // 事务对象
+		table = data.conf.Sharding.table(data.conf.Table, bizTag) // 号段行所在的表
+	)
+
+	if past < 0 || past == math.MaxInt64 {
+		return 0, 0, errInvalidPast
+	}
+
+	// 锁定号段行后再修改, 与并发获取号段的实例串行
+	if tx, err = data.current().BeginTx(ctx, nil); err != nil {
+		return
+	}
+	if err = tx.QueryRowContext(ctx, "SELECT max_id FROM "+table+" WHERE biz_tag = ? FOR UPDATE", bizTag).Scan(&maxId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrBizTagNotFound
+		}
+		goto ROLLBACK
+	}
+	if maxId > past { // 已经超过, 无需跳号
+		err = tx.Commit()
+		return
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE "+table+" SET max_id = ? WHERE biz_tag = ?", past+1, bizTag); err != nil {
+		goto ROLLBACK
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	burned, maxId = past+1-maxId, past+1
+	return
+
+ROLLBACK:
+	_ = tx.Rollback()
+	return
+}
+
+// handleAdminAdvance 处理跳号请求(POST), 需要 biz_tag 和 past 参数, 仅 MySQL 号段存储可用
+// 本实例立即丢弃内存中的号段, 其他实例在内存中的号段用完前仍可能分配不大于 past 的号码
+func handleAdminAdvance(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp = AdvanceResponse{} // 响应数据
+		err  error               // 错误信息
+		past int64               // 跳过的最大号码
+	)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if DefaultData == nil {
+		w.WriteHeader(http.StatusNotFound)
+		resp.ErrNo, resp.Msg = -1, "advance needs the mysql store"
+		goto RESP
+	}
+
+	// 解析请求参数, 支持 query/form 中的 biz_tag 和 past 参数
+	if err = r.ParseForm(); err != nil || r.Form.Get("biz_tag") == "" || !r.Form.Has("past") {
+		w.WriteHeader(http.StatusBadRequest)
+		resp.ErrNo, resp.Msg = -1, "biz_tag and past are required"
+		goto RESP
+	}
+	resp.BizTag = r.Form.Get("biz_tag")
+	if past, err = strconv.ParseInt(r.Form.Get("past"), 10, 64); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		resp.ErrNo, resp.Msg = -1, "invalid past"
+		goto RESP
+	}
+
+	if resp.MaxId, resp.Burned, err = DefaultData.Advance(r.Context(), resp.BizTag, past); err != nil {
+		switch {
+		case errors.Is(err, ErrBizTagNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, errInvalidPast):
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		resp.ErrNo, resp.Msg = -1, err.Error()
+		goto RESP
+	}
+	if resp.Burned > 0 {
+		auditor.record(r, AuditAdvance, resp.BizTag, strconv.FormatInt(past, 10))
+		logger.Info("sequence advanced", "biz_tag", resp.BizTag, "max_id", resp.MaxId, "burned", resp.Burned)
+		DefaultAlloc.forget(resp.BizTag) // 内存中的号段可能包含被跳过的号码
+	}
+	resp.Msg = "success"
+
+RESP:
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAdvance(t *testing.T) {
+	setupHandlerTest(t)
+
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleAdminAdvance(w, httptest.NewRequest(http.MethodPost, "/admin/advance?"+query, nil))
+		return w
+	}
+	if w := post("biz_tag=test&past=100"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "mysql store") {
+		t.Fatalf("advance = %d %s, want 404", w.Code, w.Body)
+	}
+
+	DefaultData = &Data{conf: DefaultConfig}
+	t.Cleanup(func() { DefaultData = nil })
+	for _, query := range []string{"biz_tag=test", "past=100", "biz_tag=test&past=abc", "biz_tag=test&past=-1"} {
+		if w := post(query); w.Code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d, want 400", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminAdvance(w, httptest.NewRequest(http.MethodGet, "/admin/advance?biz_tag=test&past=100", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET = %d, want 405", w.Code)
+	}
+}
+
+func TestAdminAdvanceReleased(t *testing.T) {
+	setupHandlerTest(t)
+	table := &fakeTable{columns: []string{"max_id"}, rows: [][]driver.Value{{int64(50)}}}
+	openFakeData(t, table)
+
+	// 释放的 ID 在跳过的范围内, 跳号后不再由预留复用
+	reserved, err := DefaultAlloc.Reserve(context.Background(), "test", "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DefaultAlloc.ReleaseReservation("test", reserved.ReservationID); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminAdvance(w, httptest.NewRequest(http.MethodPost, "/admin/advance?biz_tag=test&past=100", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"burned":51`) {
+		t.Fatalf("advance = %d %s, want 51 burned", w.Code, w.Body)
+	}
+	if len(table.execs) != 1 || table.execs[0][0] != int64(101) {
+		t.Fatalf("execs = %v, want max_id set to 101", table.execs)
+	}
+	if next, err := DefaultAlloc.Reserve(context.Background(), "test", "svc"); err != nil || next.ID == reserved.ID {
+		t.Fatalf("Reserve after advance = (%+v, %v), want an id other than the released %d", next, err, reserved.ID)
+	}
+}
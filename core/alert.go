@@ -0,0 +1,358 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// 告警事件类型
+const (
+	AlertLowRemaining  = "low_remaining"  // 内存中剩余号码低于阈值
+	AlertRefillFailed  = "refill_failed"  // 补偿线程连续放弃
+	AlertBreakerOpen   = "breaker_open"   // 号段存储熔断器打开
+	AlertBreakerClosed = "breaker_closed" // 号段存储熔断器恢复关闭
+	AlertErrorRate     = "error_rate"     // 分配错误率持续超过阈值
+	AlertQPSExceeded   = "qps_exceeded"   // 分配请求速率超过阈值
+	AlertIdNearLimit   = "id_near_limit"  // max_id 接近号码上限
+	AlertIdExhausted   = "id_exhausted"   // max_id 超过号码上限, 拒绝发号
+	AlertIdRollover    = "id_rollover"    // max_id 超过号码上限后重置, 继续发号
+)
+
+// AlertConfig 定义号段告警的配置, 剩余比例、补偿失败次数和请求速率的阈值可以按业务覆盖
+// 未配置任何通知渠道时不发送告警, 阈值仍用于输出指标
+type AlertConfig struct {
+	WebhookURL          string                    `json:"webhook_url"`           // 通用告警回调地址, 为空表示不启用
+	Template            string                    `json:"template"`              // 请求体模板(text/template), 为空时发送事件的 JSON
+	ContentType         string                    `json:"content_type"`          // 请求体类型
+	RemainingThreshold  float64                   `json:"remaining_threshold"`   // 剩余比例低于该值时告警, 0~1
+	RefillFailThreshold int                       `json:"refill_fail_threshold"` // 补偿线程连续放弃多少次后告警
+	QPSThreshold        float64                   `json:"qps_threshold"`         // 检查周期内的分配请求速率（次/秒）超过该值时告警, 0 表示不检查
+	CheckInterval       int                       `json:"check_interval"`        // 检查剩余号码的间隔（毫秒）
+	Cooldown            int                       `json:"cooldown"`              // 同一业务同类告警的最小间隔（毫秒）
+	Timeout             int                       `json:"timeout"`               // 回调请求超时时间（毫秒）
+	ErrorRateThreshold  float64                   `json:"error_rate_threshold"`  // 单个检查周期内分配失败比例超过该值视为异常, 0 表示不检查
+	ErrorRateMinCount   int64                     `json:"error_rate_min_count"`  // 检查周期内请求数不足该值时不计算错误率
+	ErrorRateSustain    int                       `json:"error_rate_sustain"`    // 连续多少个检查周期异常后告警
+	Tags                map[string]AlertThreshold `json:"tags"`                  // 按业务覆盖的阈值
+	Table               string                    `json:"table"`                 // 存放按业务阈值的数据库表, 为空表示不从数据库加载, 优先于 tags
+	ReloadInterval      int                       `json:"reload_interval"`       // 从数据库重新加载阈值的间隔（毫秒）
+	Slack               SlackConfig               `json:"slack"`                 // Slack 通知配置
+	PagerDuty           PagerDutyConfig           `json:"pagerduty"`             // PagerDuty 通知配置
+}
+
+// AlertEvent 告警事件, 也是模板渲染的数据
+type AlertEvent struct {
+	Type      string    `json:"type"`            // 事件类型
+	BizTag    string    `json:"biz_tag"`         // 业务标识
+	Remaining int64     `json:"remaining"`       // 内存中剩余号码数
+	Ratio     float64   `json:"remaining_ratio"` // 剩余号码占双Buffer满载容量的比例
+	FailCount int       `json:"fail_count"`      // 补偿线程连续放弃的次数
+	ErrorRate float64   `json:"error_rate"`      // 检查周期内的分配错误率
+	QPS       float64   `json:"qps"`             // 检查周期内的分配请求速率（次/秒）
+	Error     string    `json:"error"`           // 最近一次错误
+	MaxId     int64     `json:"max_id"`          // 号段存储中的 max_id
+	Limit     int64     `json:"limit"`           // 业务的号码上限
+	Time      time.Time `json:"time"`            // 事件发生时间
+}
+
+// Notifier 告警通知渠道
+type Notifier interface {
+	Notify(ctx context.Context, event AlertEvent) error
+}
+
+// webhookNotifier 将告警事件按模板渲染后 POST 到回调地址
+type webhookNotifier struct {
+	url         string             // 回调地址
+	contentType string             // 请求体类型
+	tmpl        *template.Template // 请求体模板
+	client      *http.Client       // HTTP 客户端
+}
+
+// newWebhookNotifier 创建 webhook 通知渠道
+func newWebhookNotifier(conf AlertConfig) (notifier *webhookNotifier, err error) {
+	var (
+		text = conf.Template
+	)
+
+	if text == "" {
+		text = "{{json .}}"
+	}
+
+	notifier = &webhookNotifier{
+		url:         conf.WebhookURL,
+		contentType: conf.ContentType,
+		client:      &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+	}
+	notifier.tmpl, err = template.New("alert").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	return
+}
+
+// Notify 渲染模板并发送告警
+func (notifier *webhookNotifier) Notify(ctx context.Context, event AlertEvent) (err error) {
+	var (
+		body bytes.Buffer
+		req  *http.Request
+		resp *http.Response
+	)
+
+	if err = notifier.tmpl.Execute(&body, event); err != nil {
+		return
+	}
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, notifier.url, &body); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", notifier.contentType)
+
+	if resp, err = notifier.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err = &notifyStatusError{target: "webhook", status: resp.StatusCode}
+	}
+	return
+}
+
+// alertKey 冷却判断的维度
+type alertKey struct {
+	eventType string
+	bizTag    string
+}
+
+// alertAttempts 每条告警向每个通知渠道最多发送的次数
+const alertAttempts = 3
+
+// Alerter 负责检查告警条件并异步发送, 同一业务同类告警在冷却期内只发送一次
+type Alerter struct {
+	conf       AlertConfig
+	notifiers  []Notifier
+	events     chan AlertEvent
+	retryDelay time.Duration // 发送失败后重试的基础间隔, 第 n 次重试前等待 n 倍
+	mutex      sync.Mutex
+	lastSent   map[alertKey]time.Time    // 各类告警最近一次发送的时间
+	loaded     map[string]AlertThreshold // 最近一次从数据库加载的按业务阈值
+	breaches   map[string]alertBreach    // 各业务最近一次检查时突破的阈值
+	rates      map[string]*rateState     // 各业务请求速率和错误率的检查状态, 仅由 check 访问
+}
+
+// rateState 单个业务请求速率和错误率检查的状态
+type rateState struct {
+	success int64     // 上个周期结束时的成功数
+	fail    int64     // 上个周期结束时的失败数
+	at      time.Time // 上个周期结束的时间
+	streak  int       // 错误率连续异常的周期数
+}
+
+// alerter 全局告警器, 初始化前为 nil
+var alerter *Alerter
+
+// InitAlert 根据配置初始化告警
+func InitAlert() (err error) {
+	var (
+		conf      = DefaultConfig.Alert
+		notifiers []Notifier
+	)
+
+	if err = checkAlertThresholds(conf); err != nil {
+		return
+	}
+	if notifiers, err = newNotifiers(conf); err != nil {
+		return
+	}
+
+	alerter = &Alerter{
+		conf:       conf,
+		notifiers:  notifiers,
+		events:     make(chan AlertEvent, 256),
+		retryDelay: time.Second,
+		lastSent:   map[alertKey]time.Time{},
+		breaches:   map[string]alertBreach{},
+		rates:      map[string]*rateState{},
+	}
+	if len(notifiers) != 0 {
+		go alerter.sendLoop()
+	}
+	go alerter.checkLoop()
+	return
+}
+
+// Fire 提交一个告警事件, 冷却期内或队列已满时丢弃; 启用 /events 时同时推送给订阅者
+func (alerter *Alerter) Fire(event AlertEvent) {
+	if alerter == nil || (len(alerter.notifiers) == 0 && eventBus == nil) {
+		return
+	}
+
+	key := alertKey{eventType: event.Type, bizTag: event.BizTag}
+	cooldown := time.Duration(alerter.conf.Cooldown) * time.Millisecond
+
+	alerter.mutex.Lock()
+	if last, ok := alerter.lastSent[key]; ok && time.Since(last) < cooldown {
+		alerter.mutex.Unlock()
+		return
+	}
+	alerter.lastSent[key] = time.Now()
+	alerter.mutex.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	eventBus.publish(event.Type, event.BizTag, event.Time, event)
+	if len(alerter.notifiers) == 0 {
+		return
+	}
+	select {
+	case alerter.events <- event:
+	default:
+		logger.Warn("alert dropped, queue full", "type", event.Type, "biz_tag", event.BizTag)
+	}
+}
+
+// RefillFailed 补偿线程放弃时调用, 连续放弃次数达到业务的阈值后告警
+func (alerter *Alerter) RefillFailed(bizTag string, failCount int, err error) {
+	if alerter == nil {
+		return
+	}
+	if threshold := alerter.threshold(bizTag).RefillFailThreshold; threshold < 0 || failCount < threshold {
+		return
+	}
+	alerter.Fire(AlertEvent{
+		Type:      AlertRefillFailed,
+		BizTag:    bizTag,
+		FailCount: failCount,
+		Error:     err.Error(),
+	})
+}
+
+// sendLoop 依次将告警发送到所有通知渠道
+func (alerter *Alerter) sendLoop() {
+	for event := range alerter.events {
+		for _, notifier := range alerter.notifiers {
+			alerter.deliver(notifier, event)
+		}
+	}
+}
+
+// deliver 将告警发送到一个通知渠道, 暂时性的失败按递增的间隔重试, 避免数据库故障期间因通知渠道抖动漏报
+func (alerter *Alerter) deliver(notifier Notifier, event AlertEvent) {
+	for attempt := 1; ; attempt++ {
+		err := notifier.Notify(context.Background(), event)
+		if err == nil {
+			return
+		}
+		if attempt >= alertAttempts || !retryableNotify(err) {
+			logger.Warn("send alert failed", "type", event.Type, "biz_tag", event.BizTag, "attempts", attempt, "err", err)
+			return
+		}
+		time.Sleep(alerter.retryDelay * time.Duration(attempt))
+	}
+}
+
+// checkLoop 定期按各业务的阈值检查剩余号码比例、补偿失败次数、请求速率和错误率
+func (alerter *Alerter) checkLoop() {
+	var (
+		interval = time.Duration(alerter.conf.CheckInterval) * time.Millisecond
+		ticker   *time.Ticker
+	)
+
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if DefaultAlloc != nil {
+			alerter.check(DefaultAlloc.gauges(), now)
+		}
+	}
+}
+
+// check 按各业务生效的阈值检查一个周期, 记录突破的阈值并告警
+func (alerter *Alerter) check(gauges []bizGauge, now time.Time) {
+	for _, g := range gauges {
+		var (
+			th     = alerter.threshold(g.bizTag)
+			breach alertBreach
+		)
+
+		// 尚未成功获取过号段的业务没有容量基准, 由补偿失败告警覆盖
+		if g.segSize > 0 && g.ratio < th.RemainingThreshold {
+			breach.remaining = true
+			alerter.Fire(AlertEvent{
+				Type:      AlertLowRemaining,
+				BizTag:    g.bizTag,
+				Remaining: g.remaining,
+				Ratio:     g.ratio,
+			})
+		}
+		breach.refillFail = g.failing && th.RefillFailThreshold >= 0 && g.giveUps >= th.RefillFailThreshold
+		breach.qps = alerter.checkRates(g, th, now)
+
+		alerter.mutex.Lock()
+		alerter.breaches[g.bizTag] = breach
+		alerter.mutex.Unlock()
+	}
+}
+
+// checkRates 计算本周期的请求速率和分配错误率, 速率超过阈值时立即告警, 错误率连续多个周期超过阈值时告警
+// 返回请求速率是否超过阈值
+func (alerter *Alerter) checkRates(g bizGauge, th AlertThreshold, now time.Time) (qpsBreached bool) {
+	var (
+		state   = alerter.rates[g.bizTag]
+		success = atomic.LoadInt64(&g.metrics.allocSuccess)
+		fail    = atomic.LoadInt64(&g.metrics.allocFail)
+		total   int64
+		rate    float64
+	)
+
+	if state == nil { // 第一次见到该业务, 仅记录基准
+		alerter.rates[g.bizTag] = &rateState{success: success, fail: fail, at: now}
+		return
+	}
+
+	total = success - state.success + fail - state.fail
+	if total > 0 {
+		rate = float64(fail-state.fail) / float64(total)
+	}
+	elapsed := now.Sub(state.at).Seconds()
+	state.success, state.fail, state.at = success, fail, now
+
+	if th.QPSThreshold > 0 && elapsed > 0 {
+		if qps := float64(total) / elapsed; qps > th.QPSThreshold {
+			qpsBreached = true
+			alerter.Fire(AlertEvent{
+				Type:   AlertQPSExceeded,
+				BizTag: g.bizTag,
+				QPS:    qps,
+			})
+		}
+	}
+
+	if alerter.conf.ErrorRateThreshold <= 0 {
+		return
+	}
+	if total < alerter.conf.ErrorRateMinCount || rate <= alerter.conf.ErrorRateThreshold {
+		state.streak = 0
+		return
+	}
+	if state.streak++; state.streak >= alerter.conf.ErrorRateSustain {
+		alerter.Fire(AlertEvent{
+			Type:      AlertErrorRate,
+			BizTag:    g.bizTag,
+			ErrorRate: rate,
+		})
+	}
+	return
+}
@@ -1,31 +1,82 @@
 package core
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Segment 号段结构体定义了号码池的号段范围
 type Segment struct {
-	offset int64 // 当前消费偏移量，指示已经分配到的号段位置
+	offset int64 // 当前消费偏移量，指示已经分配到的号段位置, 原子读写, 并发取号时可能超过号段大小
 	left   int64 // 号段左边界（包含）
 	right  int64 // 号段右边界（不包含）
 }
 
+// take 原子地取出下一个号码, ok 为 false 表示号段已用完, last 表示取到的是号段中的最后一个号码
+func (seg *Segment) take() (id int64, ok bool, last bool) {
+	id = seg.left + atomic.AddInt64(&seg.offset, 1) - 1
+	return id, id < seg.right, id+1 == seg.right
+}
+
+// remaining 返回号段中剩余的号码数量
+func (seg *Segment) remaining() int64 {
+	return max(seg.right-seg.left-atomic.LoadInt64(&seg.offset), 0)
+}
+
 // BizAlloc 管理与特定业务标识（bizTag）相关的号段分配
 type BizAlloc struct {
-	mutex        sync.Mutex  // 互斥锁，保证并发安全
-	bizTag       string      // 业务标识，用于区分不同的号段池
-	segments     []*Segment  // 双Buffer, 最少0个, 最多2个号段在内存
-	isAllocating bool        // 是否正在分配中(远程获取)
-	waiting      []chan byte // 因号码池空而挂起等待的客户端
+	mutex        sync.Mutex               // 互斥锁，保证并发安全
+	bizTag       string                   // 业务标识，用于区分不同的号段池
+	segments     []*Segment               // 双Buffer, 最少0个, 最多2个号段在内存(降级期间最多buffer_depth个)
+	current      atomic.Pointer[Segment]  // 正在消费的号段(segments[0]), 供无锁快速路径读取
+	needFill     int32                    // 号段不足且没有补偿线程在运行时为1, 此时请求需进入慢速路径触发补充
+	snapshot     atomic.Pointer[bizStats] // 最近一次发布的状态快照, 供监控读取, 不与分配争抢锁
+	isAllocating bool                     // 是否正在分配中(远程获取)
+	fillSeq      int64                    // 补偿线程的序号, 每次启动加1; 看门狗强制重置后卡住的线程不再修改状态
+	fillStart    time.Time                // 当前补偿线程的启动时间
+	waiting      []chan int64             // 因号码池空而挂起等待的客户端, 按到达顺序排队; 补充成功时直接收到号码, 其他情况下通道被关闭
+	waitSince    time.Time                // 等待队列从空变为非空的时间, 队列为空时为零值
+	step         int64                    // 当前生效的步长, 下一次获取号段使用; 管理接口修改后立即更新, 直接修改号段表时获取号段后更新
+	segSize      int64                    // 最近一次获取的号段大小, 降级期间为步长的倍数
+	maxId        int64                    // 最近一次从号段存储获取到的 max_id, 原子读写
+	fillErr      error                    // 补偿线程最近一次放弃时的错误, 补充成功后清空
+	giveUps      int                      // 补偿线程连续放弃的次数, 补充成功后清零
+	lastErr      error                    // 最近一次获取号段失败的错误, 补充成功后仍保留
+	lastErrTime  time.Time                // 最近一次获取号段失败的时间
+	metrics      *BizMetrics              // 业务指标
+	alloc        *Alloc                   // 所属的全局分配器
+	period       *resetPeriod             // 号段池所属的重置周期, 不重置的业务为 nil
 }
 
 // Alloc 全局分配器, 管理所有的biz号码分配
 type Alloc struct {
-	mutex  sync.Mutex           // 互斥锁，保证并发安全
-	bizMap map[string]*BizAlloc // 存储各业务号段池的映射
+	mutex          sync.Mutex                // 互斥锁，保护退出状态
+	bizMap         sync.Map                  // 存储各业务号段池的映射(bizTag -> *BizAlloc), 查询已有业务无需加锁
+	storage        Storage                   // 号段存储
+	ctx            context.Context           // 补偿线程的上下文, 退出时取消
+	cancelFunc     context.CancelFunc        // 取消所有补偿线程
+	fillWait       sync.WaitGroup            // 正在运行的补偿线程
+	closed         bool                      // 是否已经开始退出, 退出后不再启动补偿线程
+	lastStorageErr int64                     // 最近一次号段存储故障的时间(纳秒), 原子读写
+	fetchSlots     chan struct{}             // 限制同时获取号段的补偿线程数, 未限制时为 nil
+	clock          Clock                     // 时钟, 为 nil 时使用系统时间
+	conf           *Config                   // 分配器配置, 不读取全局配置, 多个分配器可以使用不同的配置
+	leases         leaseTable                // 租出的号段
+	reservations   reservationTable          // 待确认的预留
+	hooks          atomic.Pointer[hookChain] // 注册的钩子, 未注册时为 nil
+	negative       negativeCache             // 号段存储中不存在的业务
+	windows        prefetchWindows           // 正在生效的预取窗口
+	resets         map[string]*resetPolicy   // 周期重置的业务
+	quotas         quotaTable                // 各业务的配额用量
 }
 
 // DefaultAlloc 是全局分配器实例
@@ -33,74 +84,285 @@ var DefaultAlloc *Alloc
 
 // InitAlloc 初始化全局分配器
 func InitAlloc() (err error) {
-	DefaultAlloc = &Alloc{
-		bizMap: map[string]*BizAlloc{}, // 初始化业务号段映射
+	if err = checkPartition(DefaultConfig); err != nil {
+		return
+	}
+	if err = checkLayout(DefaultConfig); err != nil {
+		return
+	}
+	if err = checkCapacity(DefaultConfig); err != nil {
+		return
+	}
+	crons, err := checkPrefetch(DefaultConfig)
+	if err != nil {
+		return
+	}
+	if _, err = newResetPolicies(DefaultConfig); err != nil {
+		return
+	}
+
+	// 按配置装配号段存储
+	DefaultAlloc = newAlloc(DefaultConfig, newStorage(DefaultConfig, DefaultStore, ledger))
+	if len(crons) != 0 {
+		go DefaultAlloc.runSchedules(crons, standby)
+	}
+	if DefaultConfig.Watchdog.Enable {
+		go DefaultAlloc.runWatchdog()
+	}
+	if eventBus != nil {
+		DefaultAlloc.Use(eventBus.hooks()) // 获取到的号段推送到 /events
 	}
 	return
 }
 
-// leftCount 计算BizAlloc中剩余的未分配号码数量
-func (bizAlloc *BizAlloc) leftCount() (count int64) {
-	for i := 0; i < len(bizAlloc.segments); i++ {
-		count += bizAlloc.segments[i].right - bizAlloc.segments[i].left - bizAlloc.segments[i].offset
+// newAlloc 使用指定的配置和号段存储创建分配器
+func newAlloc(conf *Config, storage Storage) (alloc *Alloc) {
+	alloc = &Alloc{
+		storage: storage,
+		conf:    conf,
 	}
-	return count
+	alloc.resets, _ = newResetPolicies(conf) // 创建前已检查
+	alloc.ctx, alloc.cancelFunc = context.WithCancel(context.Background())
+	if maxFetches := conf.Concurrency.MaxFetches; maxFetches > 0 {
+		alloc.fetchSlots = make(chan struct{}, maxFetches)
+	}
+	return
 }
 
-// leftCountWithMutex 在锁保护下计算剩余未分配号码数量
-func (bizAlloc *BizAlloc) leftCountWithMutex() (count int64) {
-	bizAlloc.mutex.Lock()
-	defer bizAlloc.mutex.Unlock()
-	return bizAlloc.leftCount()
+// startFill 登记一个补偿线程, 分配器已退出时返回false
+func (alloc *Alloc) startFill() bool {
+	alloc.mutex.Lock()
+	defer alloc.mutex.Unlock()
+
+	if alloc.closed {
+		return false
+	}
+	alloc.fillWait.Add(1)
+	return true
+}
+
+// Close 停止启动新的补偿线程, 并等待正在运行的补偿线程结束
+// ctx 到期后取消补偿线程中的数据库请求, 再等待它们退出
+func (alloc *Alloc) Close(ctx context.Context) (err error) {
+	var (
+		done = make(chan struct{})
+	)
+
+	alloc.mutex.Lock()
+	alloc.closed = true
+	alloc.mutex.Unlock()
+
+	go func() {
+		alloc.fillWait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done: // 补偿线程全部完成
+	case <-ctx.Done(): // 超过宽限期, 取消补偿线程
+		err = ctx.Err()
+		alloc.cancelFunc()
+		<-done
+	}
+	alloc.cancelFunc()
+	return
+}
+
+// bizStats 业务状态的不可变快照, 在锁内发布, 读取时无需加锁
+// 号段的偏移量仍在原子递增, 因此剩余号码数量总是最新的
+type bizStats struct {
+	segments    []*Segment // 内存中的号段, 发布时复制的切片
+	waiting     int        // 挂起等待的客户端数
+	step        int64      // 当前生效的步长
+	segSize     int64      // 最近一次获取的号段大小
+	failing     bool       // 补偿线程是否已放弃
+	giveUps     int        // 补偿线程连续放弃的次数
+	lastErr     error      // 最近一次获取号段失败的错误
+	lastErrTime time.Time  // 最近一次获取号段失败的时间
+}
+
+// stats 返回最近一次发布的状态快照, 从未发布时返回空快照
+func (bizAlloc *BizAlloc) stats() *bizStats {
+	if stats := bizAlloc.snapshot.Load(); stats != nil {
+		return stats
+	}
+	return &bizStats{}
 }
 
-// newSegment 请求数据库获取一个新的号段
-func (bizAlloc *BizAlloc) newSegment() (seg *Segment, err error) {
+// leftCount 计算快照中剩余的未分配号码数量
+func (stats *bizStats) leftCount() (count int64) {
+	for i := 0; i < len(stats.segments); i++ {
+		count += stats.segments[i].remaining()
+	}
+	return count
+}
+
+// newSegments 请求数据库获取新的号段, 配置了多号段预取的业务在一次事务中获取多个号段
+// step 为号段存储中当前的步长, 不包含降级的倍数
+func (bizAlloc *BizAlloc) newSegments(ctx context.Context) (segs []*Segment, step int64, err error) {
 	var (
-		maxId int64 // 数据库返回的最大ID
-		step  int64 // 每次获取的号段大小
+		maxId     int64                                           // 数据库返回的最大ID
+		startTime time.Time                                       // 开始获取的时间
+		multiple  = bizAlloc.alloc.stepMultiple()                 // 步长倍数, 降级期间大于1
+		count     = bizAlloc.alloc.fetchSegments(bizAlloc.bizTag) // 本次获取的号段个数
 	)
 
+	// 等待获取许可, 大量业务同时补充时避免耗尽数据库连接
+	if err = bizAlloc.alloc.acquireFetch(ctx); err != nil {
+		return
+	}
+	defer bizAlloc.alloc.releaseFetch()
+
 	// 通过数据库获取号段范围
-	if maxId, step, err = DefaultData.NextId(bizAlloc.bizTag); err != nil {
+	startTime = bizAlloc.alloc.now()
+	maxId, step, err = bizAlloc.alloc.storage.NextId(bizAlloc.withResetPeriod(ctx), bizAlloc.bizTag, multiple*count)
+	elapsed := bizAlloc.alloc.since(startTime)
+	bizAlloc.metrics.fetchLatency.observe(elapsed)
+	bizAlloc.alloc.recordFetch(bizAlloc, elapsed)
+	statsd.Timing("segment.fetch.latency", elapsed, "biz_tag:"+bizAlloc.bizTag)
+	if err != nil {
+		if isStorageFault(err) { // 业务不存在或号码耗尽不属于存储故障
+			bizAlloc.alloc.markStorageError()
+		}
+		atomic.AddInt64(&bizAlloc.metrics.fetchFail, 1)
+		statsd.Incr("segment.fetch", "biz_tag:"+bizAlloc.bizTag, "result:fail")
+		logger.Warn("fetch segment failed", "code", CodeSegmentFetch, "biz_tag", bizAlloc.bizTag,
+			"latency_ms", elapsed.Milliseconds(), "err", err)
+		bizAlloc.alloc.loadHooks().onError(ctx, bizAlloc.bizTag, err)
 		return
 	}
+	atomic.AddInt64(&bizAlloc.metrics.fetchSuccess, 1)
+	atomic.StoreInt64(&bizAlloc.maxId, maxId)
+	statsd.Incr("segment.fetch", "biz_tag:"+bizAlloc.bizTag, "result:success")
+	bizAlloc.alloc.loadHooks().onSegmentFetch(ctx, bizAlloc.bizTag, maxId-step*multiple*count, maxId)
+
+	// 将 [maxId - count×step×multiple, maxId) 按顺序拆分为 count 个号段
+	for left := maxId - step*multiple*count; left < maxId; left += step * multiple {
+		segs = append(segs, &Segment{
+			left:  left,                 // 新号段左边界
+			right: left + step*multiple, // 新号段右边界
+		})
+	}
 
-	seg = &Segment{}
-	seg.left = maxId - step // 新号段左边界
-	seg.right = maxId       // 新号段右边界
+	logger.Debug("segment fetched", "biz_tag", bizAlloc.bizTag, "left", maxId-step*multiple*count, "right", maxId,
+		"segments", count, "latency_ms", elapsed.Milliseconds())
 
 	return
 }
 
-// wakeup 唤醒所有等待分配号段的客户端
+// safeNewSegments 获取新号段, 将 panic 转换为错误, 避免补偿线程崩溃导致整个进程退出
+func (bizAlloc *BizAlloc) safeNewSegments(ctx context.Context) (segs []*Segment, step int64, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			recordPanic("refill", bizAlloc.bizTag)
+			logger.Error("panic recovered", "code", CodePanic, "biz_tag", bizAlloc.bizTag,
+				"panic", p, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic while fetching segment: %v", p)
+		}
+	}()
+	return bizAlloc.newSegments(ctx)
+}
+
+// wakeup 唤醒所有等待分配号段的客户端, 调用方需持有锁
 func (bizAlloc *BizAlloc) wakeup() {
 	var (
-		waitChan chan byte
+		waitChan chan int64
 	)
 	for _, waitChan = range bizAlloc.waiting {
 		close(waitChan) // 关闭通道来唤醒等待者
 	}
 	bizAlloc.waiting = bizAlloc.waiting[:0] // 清空等待队列
+	bizAlloc.waitSince = time.Time{}
+}
+
+// handoff 按排队顺序把新号段中的号码直接交给等待者, 号码不够时唤醒其余等待者重新排队, 调用方需持有锁
+// 在发布号段、重新开放快速路径之前调用, 否则被唤醒的等待者要和快速路径上源源不断的新请求争抢号码, 可能一直抢不到直到超时
+func (bizAlloc *BizAlloc) handoff() {
+	var (
+		waitChan chan int64
+		nextId   int64
+		ok       bool
+	)
+	for _, waitChan = range bizAlloc.waiting {
+		if nextId, ok = bizAlloc.popNextId(); !ok {
+			close(waitChan)
+		} else {
+			waitChan <- nextId // 通道有1个缓冲, 不会阻塞
+		}
+	}
+	bizAlloc.waiting = bizAlloc.waiting[:0]
+	bizAlloc.waitSince = time.Time{}
+}
+
+// leave 超时或请求取消的等待者退出等待队列, 调用方需持有锁; 已经收到号码时返回该号码, 避免号码被跳过
+func (bizAlloc *BizAlloc) leave(waitChan chan int64) (nextId int64, ok bool) {
+	if i := slices.Index(bizAlloc.waiting, waitChan); i >= 0 {
+		bizAlloc.waiting = slices.Delete(bizAlloc.waiting, i, i+1)
+		if len(bizAlloc.waiting) == 0 {
+			bizAlloc.waitSince = time.Time{}
+		}
+		return
+	}
+	select {
+	case nextId, ok = <-waitChan: // 通道被关闭时 ok 为 false
+	default:
+	}
+	return
 }
 
 // 分配号码段, 直到足够2个segment, 否则始终不会退出
-func (bizAlloc *BizAlloc) fillSegments() {
+// link 指向触发补偿的请求span, 补偿线程脱离请求生命周期, 因此单独开启一条trace
+// seq 为启动时的补偿线程序号, 被看门狗判定卡住并重置后, 线程恢复时不再修改号段池的状态
+func (bizAlloc *BizAlloc) fillSegments(link trace.Link, seq int64) {
 	var (
-		failTimes int64    // 连续分配失败次数
-		seg       *Segment // 新的号段
+		failTimes int64      // 连续分配失败次数
+		segs      []*Segment // 新的号段
+		step      int64      // 号段存储中当前的步长
 		err       error
 	)
+
+	defer bizAlloc.alloc.fillWait.Done()
+
+	ctx, span := tracer.Start(bizAlloc.alloc.ctx, "BizAlloc.fillSegments",
+		trace.WithLinks(link),
+		trace.WithAttributes(attribute.String("biz_tag", bizAlloc.bizTag)))
+	defer span.End()
+
 	for {
 		bizAlloc.mutex.Lock()
-		if len(bizAlloc.segments) <= 1 { // 只剩余<=1段, 那么继续获取新号段
+		if bizAlloc.fillSeq != seq { // 已被看门狗重置, 由新的补偿线程继续
+			goto LEAVE
+		}
+		if ctx.Err() != nil { // 分配器退出, 唤醒等待者并停止分配
+			bizAlloc.wakeup()
+			goto LEAVE
+		}
+		if len(bizAlloc.segments) < bizAlloc.alloc.bufferDepth(bizAlloc.bizTag) { // 号段不足(正常为<=1段), 那么继续获取新号段
 			bizAlloc.mutex.Unlock()
 
 			// 请求数据库获取新的号段
-			if seg, err = bizAlloc.newSegment(); err != nil {
+			if segs, step, err = bizAlloc.safeNewSegments(ctx); err != nil {
 				failTimes++
-				if failTimes > 3 { // 连续失败超过3次则停止分配
+				bizAlloc.mutex.Lock()
+				bizAlloc.lastErr, bizAlloc.lastErrTime = err, bizAlloc.alloc.now()
+				bizAlloc.publish()
+				bizAlloc.mutex.Unlock()
+				if failTimes > 3 || !retryableFill(err) { // 连续失败超过3次, 或重试不会成功则停止分配
+					recordSpanError(span, err)
+					atomic.AddInt64(&bizAlloc.metrics.refillGiveUp, 1)
+					statsd.Incr("refill.giveup", "biz_tag:"+bizAlloc.bizTag)
+					logger.Error("refill gave up", "code", CodeRefillGiveUp, "biz_tag", bizAlloc.bizTag,
+						"fail_times", failTimes, "err", err)
+					if errors.Is(err, ErrBizTagNotFound) { // 业务不存在, 缓存结果并丢弃号段池
+						bizAlloc.alloc.dropUnknown(bizAlloc)
+					}
 					bizAlloc.mutex.Lock()
+					if bizAlloc.fillSeq != seq { // 已被看门狗重置, 放弃结果不影响新的补偿线程
+						goto LEAVE
+					}
+					bizAlloc.fillErr = err
+					bizAlloc.giveUps++
+					alerter.RefillFailed(bizAlloc.bizTag, bizAlloc.giveUps, err)
 					bizAlloc.wakeup() // 唤醒等待者, 让它们立马失败
 					goto LEAVE
 				}
@@ -108,106 +370,363 @@ func (bizAlloc *BizAlloc) fillSegments() {
 				failTimes = 0 // 分配成功则失败次数重置为0
 				// 新号段补充进去
 				bizAlloc.mutex.Lock()
-				bizAlloc.segments = append(bizAlloc.segments, seg) // 添加新号段
-				bizAlloc.wakeup()                                  // 尝试唤醒等待资源的调用
-				if len(bizAlloc.segments) > 1 {                    // 已生成2个号段, 停止继续分配
+				if bizAlloc.fillSeq != seq { // 已被看门狗重置, 丢弃获取到的号段, 号段池由新的补偿线程补充
+					logger.Warn("stale refill result discarded", "biz_tag", bizAlloc.bizTag,
+						"left", segs[0].left, "right", segs[len(segs)-1].right)
+					goto LEAVE
+				}
+				bizAlloc.segments = append(bizAlloc.segments, segs...) // 添加新号段
+				bizAlloc.segSize = segs[0].right - segs[0].left        // 记录最新号段大小
+				bizAlloc.applyStep(step)                               // 号段表中的步长可能被直接修改
+				bizAlloc.fillErr = nil                                 // 补充成功, 清除放弃时的错误
+				bizAlloc.giveUps = 0                                   // 补充成功, 连续放弃次数清零
+				bizAlloc.handoff()                                     // 先把号码交给等待者, 再发布号段
+				// 号段已补足(正常为2个, 降级期间为buffer_depth个), 停止继续分配
+				if len(bizAlloc.segments) >= bizAlloc.alloc.bufferDepth(bizAlloc.bizTag) {
 					goto LEAVE
 				} else {
+					bizAlloc.publish()
 					bizAlloc.mutex.Unlock()
 				}
 			}
 		} else {
-			break // never reach
+			break // 降级结束后号段深度变小时可能到达
 		}
 	}
 
 LEAVE:
-	bizAlloc.isAllocating = false
+	if bizAlloc.fillSeq == seq {
+		bizAlloc.isAllocating = false
+	}
+	bizAlloc.publish()
 	bizAlloc.mutex.Unlock()
 }
 
-// popNextId 弹出下一个未分配的ID
-func (bizAlloc *BizAlloc) popNextId() (nextId int64) {
-	nextId = bizAlloc.segments[0].left + bizAlloc.segments[0].offset
-	bizAlloc.segments[0].offset++
-	if nextId+1 >= bizAlloc.segments[0].right {
-		bizAlloc.segments = append(bizAlloc.segments[:0], bizAlloc.segments[1:]...) // 弹出第一个seg, 后续seg向前移动
+// applyStep 更新当前生效的步长, 步长变化时记录日志, 调用方需持有锁
+func (bizAlloc *BizAlloc) applyStep(step int64) {
+	if bizAlloc.step != 0 && bizAlloc.step != step {
+		logger.Info("step changed", "biz_tag", bizAlloc.bizTag, "from", bizAlloc.step, "to", step)
+	}
+	bizAlloc.step = step
+}
+
+// popNextId 从第一个号段取出下一个未分配的ID, 用完的号段被弹出, 调用方需持有锁
+func (bizAlloc *BizAlloc) popNextId() (nextId int64, ok bool) {
+	var (
+		last bool
+	)
+
+	for len(bizAlloc.segments) != 0 && !ok {
+		if nextId, ok, last = bizAlloc.segments[0].take(); !ok || last {
+			bizAlloc.segments = append(bizAlloc.segments[:0], bizAlloc.segments[1:]...) // 弹出第一个seg, 后续seg向前移动
+		}
 	}
 	return
 }
 
+// dropExhausted 弹出已经用完的号段, 调用方需持有锁
+func (bizAlloc *BizAlloc) dropExhausted() {
+	for len(bizAlloc.segments) != 0 && bizAlloc.segments[0].remaining() == 0 {
+		bizAlloc.segments = append(bizAlloc.segments[:0], bizAlloc.segments[1:]...)
+	}
+}
+
+// publish 发布快速路径和监控读取的状态, 每次在锁内修改号段或补偿状态后、解锁前调用
+func (bizAlloc *BizAlloc) publish() {
+	var (
+		needFill int32
+	)
+
+	if len(bizAlloc.segments) != 0 {
+		bizAlloc.current.Store(bizAlloc.segments[0])
+	} else {
+		bizAlloc.current.Store(nil)
+	}
+	if len(bizAlloc.segments) < bizAlloc.alloc.bufferDepth(bizAlloc.bizTag) && !bizAlloc.isAllocating {
+		needFill = 1
+	}
+	atomic.StoreInt32(&bizAlloc.needFill, needFill)
+
+	bizAlloc.snapshot.Store(&bizStats{
+		segments:    append([]*Segment(nil), bizAlloc.segments...),
+		waiting:     len(bizAlloc.waiting),
+		step:        bizAlloc.step,
+		segSize:     bizAlloc.segSize,
+		failing:     bizAlloc.fillErr != nil,
+		giveUps:     bizAlloc.giveUps,
+		lastErr:     bizAlloc.lastErr,
+		lastErrTime: bizAlloc.lastErrTime,
+	})
+}
+
 // nextId 获取下一个分配的ID
-func (bizAlloc *BizAlloc) nextId() (nextId int64, err error) {
+func (bizAlloc *BizAlloc) nextId(ctx context.Context) (nextId int64, err error) {
 	var (
-		waitChan  chan byte
-		waitTimer *time.Timer
+		waitChan  chan int64
+		waitTimer Timer
 		hasId     = false
+		lockStart = bizAlloc.alloc.now() // 开始等待锁的时间, 用于区分锁竞争和数据库耗时
+		waitStart time.Time              // 开始等待补偿线程的时间
 	)
 
+	// 0, 快速路径: 正在消费的号段有剩余且无需触发补充时, 原子递增偏移量即可分配, 无需加锁
+	// 取到号段的最后一个号码时仍进入慢速路径, 由其弹出号段并触发补充
+	if seg := bizAlloc.current.Load(); seg != nil && atomic.LoadInt32(&bizAlloc.needFill) == 0 {
+		id, ok, last := seg.take()
+		if ok && !last {
+			return id, nil
+		}
+		nextId, hasId = id, ok
+	}
+
+	ctx, span := tracer.Start(ctx, "BizAlloc.nextId", trace.WithAttributes(attribute.String("biz_tag", bizAlloc.bizTag)))
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
+
 	bizAlloc.mutex.Lock()
-	defer bizAlloc.mutex.Unlock()
+	defer func() {
+		bizAlloc.publish()
+		bizAlloc.mutex.Unlock()
+	}()
+	span.AddEvent("lock acquired", trace.WithAttributes(attribute.Int64("lock_wait_us", bizAlloc.alloc.since(lockStart).Microseconds())))
 
 	// 1, 有剩余号码, 立即分配返回
-	if bizAlloc.leftCount() != 0 {
-		nextId = bizAlloc.popNextId()
-		hasId = true
+	if hasId {
+		bizAlloc.dropExhausted()
+	} else {
+		nextId, hasId = bizAlloc.popNextId()
 	}
 
-	// 2, 段<=1个, 启动补偿线程
-	if len(bizAlloc.segments) <= 1 && !bizAlloc.isAllocating {
-		bizAlloc.isAllocating = true
-		go bizAlloc.fillSegments()
-	}
+	// 2, 段<=1个(降级期间为不足buffer_depth个), 启动补偿线程
+	bizAlloc.triggerFill(ctx)
 
 	// 分配到号码, 立即退出
 	if hasId {
 		return
 	}
 
-	// 3, 没有剩余号码, 此时补偿线程一定正在运行, 等待其至多一段时间
-	waitChan = make(chan byte, 1)
-	bizAlloc.waiting = append(bizAlloc.waiting, waitChan) // 排队等待唤醒
+	// 分配器已退出且没有补偿线程, 无需等待
+	if !bizAlloc.isAllocating {
+		err = errors.New("allocator is closed")
+		return
+	}
 
-	// 释放锁, 等待补偿线程唤醒
-	bizAlloc.mutex.Unlock()
+	// 3, 没有剩余号码, 此时补偿线程一定正在运行, 等待其至多一段时间, 调用方指定不等待时立即失败
+	wait := bizAlloc.alloc.waitTimeout(ctx)
+	if wait <= 0 {
+		err = errors.New("no available id")
+		return
+	}
+	if limit := bizAlloc.alloc.conf.Memory.MaxWaiting; limit > 0 && len(bizAlloc.waiting) >= limit { // 数据库故障时限制等待者占用的内存
+		atomic.AddInt64(&bizAlloc.metrics.waitRejected, 1)
+		err = ErrTooManyWaiters
+		return
+	}
+	waitStart = bizAlloc.alloc.now()
+	waitTimer = bizAlloc.alloc.newTimer(wait)
+	defer waitTimer.Stop()
+	for {
+		waitChan = make(chan int64, 1)
+		if len(bizAlloc.waiting) == 0 {
+			bizAlloc.waitSince = bizAlloc.alloc.now()
+		}
+		bizAlloc.waiting = append(bizAlloc.waiting, waitChan) // 排队等待唤醒
+		bizAlloc.alloc.recordWaiting(bizAlloc)
+
+		// 释放锁, 等待补偿线程唤醒
+		bizAlloc.publish()
+		bizAlloc.mutex.Unlock()
+
+		timeout := false
+		select {
+		case nextId, hasId = <-waitChan: // 收到补偿线程交来的号码, 或通道被关闭而唤醒
+		case <-waitTimer.C(): // 超时
+			timeout = true
+		case <-ctx.Done(): // 请求超过时限或客户端断开, waitChan 有缓冲, 补偿线程唤醒时不会阻塞
+			bizAlloc.mutex.Lock() // 与 defer 中的解锁配对
+			// 退出前已经收到号码时仍返回该号码
+			if nextId, hasId = bizAlloc.leave(waitChan); !hasId {
+				err = ctx.Err()
+			}
+			return
+		}
 
-	waitTimer = time.NewTimer(2 * time.Second) // 最多等待2秒
-	select {
-	case <-waitChan: // 等待唤醒
-	case <-waitTimer.C: // 超时
+		// 4, 再次上锁尝试获取号码
+		bizAlloc.mutex.Lock()
+		if timeout {
+			nextId, hasId = bizAlloc.leave(waitChan)
+		}
+		if !hasId {
+			nextId, hasId = bizAlloc.popNextId()
+		}
+		if hasId { // 补偿线程已补充号码
+			span.AddEvent("refill wait finished", trace.WithAttributes(attribute.Int64("refill_wait_us", bizAlloc.alloc.since(waitStart).Microseconds())))
+			bizAlloc.triggerFill(ctx)
+			return
+		}
+		if errors.Is(bizAlloc.fillErr, ErrCircuitOpen) { // 熔断器打开, 返回可区分的错误
+			err = ErrCircuitOpen
+			return
+		}
+		if !retryableFill(bizAlloc.fillErr) { // 业务不存在、号码耗尽、业务已归档或周期已结束, 返回可区分的错误
+			err = bizAlloc.fillErr
+			return
+		}
+
+		// 等待者多于号段中的号码时, 新号段可能已被其他请求取完, 补偿线程仍在运行或可以重新启动时继续等待
+		if !timeout && bizAlloc.fillErr == nil {
+			bizAlloc.triggerFill(ctx)
+		}
+		if timeout || !bizAlloc.isAllocating {
+			err = errors.New("no available id")
+			return
+		}
 	}
+}
 
-	// 4, 再次上锁尝试获取号码
-	bizAlloc.mutex.Lock()
-	if bizAlloc.leftCount() != 0 {
-		nextId = bizAlloc.popNextId()
-	} else {
-		err = errors.New("no available id")
+// retryableFill 补偿线程重试是否可能成功, 熔断器打开、业务不存在、号码耗尽、业务已归档或重置周期已结束时立即放弃
+func retryableFill(err error) bool {
+	return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrBizTagNotFound) && !errors.Is(err, ErrIdExhausted) &&
+		!errors.Is(err, ErrBizTagArchived) && !errors.Is(err, errPeriodPassed)
+}
+
+// triggerFill 号段不足且没有补偿线程在运行时启动补偿线程, 调用方需持有锁
+func (bizAlloc *BizAlloc) triggerFill(ctx context.Context) {
+	if len(bizAlloc.segments) < bizAlloc.alloc.bufferDepth(bizAlloc.bizTag) && !bizAlloc.isAllocating && bizAlloc.alloc.startFill() {
+		bizAlloc.isAllocating = true
+		bizAlloc.fillSeq++
+		bizAlloc.fillStart = bizAlloc.alloc.now()
+		go bizAlloc.fillSegments(trace.LinkFromContext(ctx), bizAlloc.fillSeq)
+	}
+}
+
+// load 查找业务号段池, 不存在时返回 nil
+func (alloc *Alloc) load(bizTag string) *BizAlloc {
+	if value, ok := alloc.bizMap.Load(bizTag); ok {
+		return value.(*BizAlloc)
+	}
+	return nil
+}
+
+// loadOrCreate 查找业务号段池, 不存在时新建, 并发新建时只保留先存入的一个; 周期重置的业务返回当前周期的号段池
+func (alloc *Alloc) loadOrCreate(bizTag string) *BizAlloc {
+	if policy := alloc.resets[bizTag]; policy != nil {
+		return alloc.loadPeriodic(bizTag, policy)
+	}
+	if bizAlloc := alloc.load(bizTag); bizAlloc != nil {
+		return bizAlloc
+	}
+
+	value, _ := alloc.bizMap.LoadOrStore(bizTag, alloc.newBizAlloc(bizTag))
+	return value.(*BizAlloc)
+}
+
+// newBizAlloc 创建空的业务号段池
+func (alloc *Alloc) newBizAlloc(bizTag string) *BizAlloc {
+	return &BizAlloc{
+		bizTag:       bizTag,
+		segments:     make([]*Segment, 0),
+		isAllocating: false,
+		waiting:      make([]chan int64, 0),
+		metrics:      newBizMetrics(),
+		alloc:        alloc,
+	}
+}
+
+// dropUnknown 号段存储中不存在该业务时缓存结果, 并丢弃为它创建的号段池, 重新创建前不再占用内存
+func (alloc *Alloc) dropUnknown(bizAlloc *BizAlloc) {
+	alloc.markUnknown(bizAlloc.bizTag)
+	alloc.bizMap.CompareAndDelete(bizAlloc.bizTag, bizAlloc)
+	alloc.reservations.discard(bizAlloc.bizTag) // 业务重新创建后号码从头开始
+}
+
+// forget 丢弃业务在内存中的号段, 之后的请求重新从号段存储获取, 用于业务归档后立即停止发号
+func (alloc *Alloc) forget(bizTag string) {
+	if alloc == nil {
+		return
+	}
+	bizTag = alloc.conf.Partition.bizTag(bizTag) // 号段行可能带有分区后缀
+	alloc.bizMap.Delete(bizTag)
+	alloc.reservations.discard(bizTag) // 释放的 ID 同样可能包含被跳过或归档的号码
+}
+
+// applyStep 管理接口修改号段行的步长后, 立即更新对应业务在内存中生效的步长并重新发布状态
+// 下一次补充号段按新步长获取, 已在内存中的号段不受影响; 其他实例在下一次获取号段时读到新步长
+func (alloc *Alloc) applyStep(row string, step int64) {
+	if alloc == nil {
+		return
+	}
+	// 号段行可能带有分区后缀, 其他实例的号段行不影响本实例
+	bizTag := alloc.conf.Partition.bizTag(row)
+	if bizTag+alloc.conf.Partition.suffix() != row {
+		return
+	}
+	if bizAlloc := alloc.load(bizTag); bizAlloc != nil {
+		bizAlloc.mutex.Lock()
+		bizAlloc.applyStep(step)
+		bizAlloc.publish()
+		bizAlloc.mutex.Unlock()
 	}
-	return
 }
 
 // NextId 获取指定业务的下一个ID
-func (alloc *Alloc) NextId(bizTag string) (nextId int64, err error) {
+func (alloc *Alloc) NextId(ctx context.Context, bizTag string) (nextId int64, err error) {
 	var (
 		bizAlloc *BizAlloc
-		exist    bool
+		hooks    = alloc.loadHooks()
 	)
 
-	alloc.mutex.Lock()
-	if bizAlloc, exist = alloc.bizMap[bizTag]; !exist { // 如果bizTag不存在
-		bizAlloc = &BizAlloc{
-			bizTag:       bizTag,
-			segments:     make([]*Segment, 0),
-			isAllocating: false,
-			waiting:      make([]chan byte, 0),
+	// 缓存期内已知不存在的业务, 不创建号段池和补偿线程
+	if err = alloc.unknown(bizTag); err != nil {
+		hooks.onError(ctx, bizTag, err)
+		return
+	}
+	bizAlloc = alloc.loadOrCreate(bizTag)
+
+	// 从业务号段池获取下一个ID, 钩子拒绝或超过配额时不消耗号码
+	if err = hooks.preAlloc(ctx, bizTag); err == nil {
+		err = alloc.takeQuota(bizAlloc, 1)
+	}
+	if err == nil {
+		nextId, err = bizAlloc.nextId(ctx)
+		if errors.Is(err, errPeriodPassed) { // 周期在等待号段期间结束, 换到新周期的号段池重试一次
+			bizAlloc = alloc.loadOrCreate(bizTag)
+			nextId, err = bizAlloc.nextId(ctx)
 		}
-		alloc.bizMap[bizTag] = bizAlloc // 新建并存入映射
 	}
-	alloc.mutex.Unlock()
+	if err == nil {
+		nextId, err = alloc.compose(nextId, bizAlloc.period != nil)
+	}
+	alloc.recordAlloc(bizAlloc, err)
+	if err != nil {
+		atomic.AddInt64(&bizAlloc.metrics.allocFail, 1)
+		statsd.Incr("alloc", "biz_tag:"+bizTag, "result:fail")
+	} else {
+		atomic.AddInt64(&bizAlloc.metrics.allocSuccess, 1)
+		statsd.Incr("alloc", "biz_tag:"+bizTag, "result:success")
+	}
+
+	if err == nil {
+		nextId, err = hooks.postAlloc(ctx, bizTag, nextId)
+	}
+	if err != nil {
+		hooks.onError(ctx, bizTag, err)
+	}
+	return
+}
 
-	// 从业务号段池获取下一个ID
-	nextId, err = bizAlloc.nextId()
+// compose 将号段中的号码映射为对外的 ID: 先按分区映射, 再按位布局组合数据中心编号, 未启用位布局时叠加毫秒时间戳
+// 周期重置的业务是从头计数的业务编号, 不叠加毫秒时间戳
+func (alloc *Alloc) compose(nextId int64, periodic bool) (int64, error) {
+	nextId = alloc.conf.Partition.id(nextId)
+	if alloc.conf.Layout.Enable {
+		return alloc.conf.Layout.compose(nextId)
+	}
+	if periodic {
+		return nextId, nil
+	}
 
 	/*
 		Leaf-segment方案可以生成趋势递增的ID，同时ID号是可计算的，不适用于订单ID生成场景，
@@ -215,22 +734,47 @@ func (alloc *Alloc) NextId(bizTag string) (nextId int64, err error) {
 
 		其实ID可以是：符号位（1位）+机器ID（5位）+业务ID（5位）+毫秒时间戳（41位）+nextId（30位）
 	*/
-	nextId = nextId + time.Now().UnixMilli()
+	return nextId + alloc.now().UnixMilli(), nil
+}
+
+// RefillStatus 业务补偿线程的状态
+type RefillStatus struct {
+	Failing       bool      `json:"failing"`         // 补偿线程是否已放弃, 下一次分配请求会重新触发
+	GiveUps       int       `json:"give_ups"`        // 连续放弃的次数
+	LastError     string    `json:"last_error"`      // 最近一次获取号段失败的错误
+	LastErrorTime time.Time `json:"last_error_time"` // 最近一次获取号段失败的时间
+}
+
+// RefillStatus 获取业务补偿线程的状态, 从未失败过时返回 nil
+func (alloc *Alloc) RefillStatus(bizTag string) (status *RefillStatus) {
+	var (
+		bizAlloc = alloc.load(bizTag)
+		stats    *bizStats
+	)
+
+	if bizAlloc == nil {
+		return
+	}
+
+	if stats = bizAlloc.stats(); stats.lastErr != nil {
+		status = &RefillStatus{
+			Failing:       stats.failing,
+			GiveUps:       stats.giveUps,
+			LastError:     stats.lastErr.Error(),
+			LastErrorTime: stats.lastErrTime,
+		}
+	}
 	return
 }
 
 // LeftCount 获取业务池中的剩余号码数量
 func (alloc *Alloc) LeftCount(bizTag string) (leftCount int64) {
 	var (
-		bizAlloc *BizAlloc
+		bizAlloc = alloc.load(bizTag)
 	)
 
-	alloc.mutex.Lock()
-	bizAlloc, _ = alloc.bizMap[bizTag]
-	alloc.mutex.Unlock()
-
 	if bizAlloc != nil {
-		leftCount = bizAlloc.leftCountWithMutex()
+		leftCount = bizAlloc.stats().leftCount()
 	}
 	return
 }
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// benchmarkBizAllocNextId 并发调用同一业务的 nextId, step 决定号段切换和补充的频率
+func benchmarkBizAllocNextId(b *testing.B, step int64) {
+	setupTestConfig(b)
+	alloc := newTestAlloc(b, newFakeStorage(step))
+	bizAlloc := alloc.loadOrCreate("bench")
+	ctx := context.Background()
+
+	// 预热: 完成第一次号段获取, 避免把冷启动计入结果
+	if _, err := bizAlloc.nextId(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := bizAlloc.nextId(ctx); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkBizAllocNextId(b *testing.B) {
+	for _, step := range []int64{1000, 100000, 10000000} {
+		b.Run("step="+strconv.FormatInt(step, 10), func(b *testing.B) {
+			benchmarkBizAllocNextId(b, step)
+		})
+	}
+}
+
+// BenchmarkAllocNextIdTags 并发调用 Alloc.NextId, 请求均匀分布在多个业务上
+func BenchmarkAllocNextIdTags(b *testing.B) {
+	for _, tags := range []int{1, 16, 1024} {
+		b.Run("tags="+strconv.Itoa(tags), func(b *testing.B) {
+			setupTestConfig(b)
+			alloc := newTestAlloc(b, newFakeStorage(100000))
+			ctx := context.Background()
+
+			bizTags := make([]string, tags)
+			for i := range bizTags {
+				bizTags[i] = "bench-" + strconv.Itoa(i)
+				if _, err := alloc.NextId(ctx, bizTags[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var counter uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bizTag := bizTags[atomic.AddUint64(&counter, 1)%uint64(tags)]
+					if _, err := alloc.NextId(ctx, bizTag); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextIdRollover(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	bizAlloc := newTestAlloc(t, storage).loadOrCreate("test")
+	ctx := context.Background()
+
+	// 第一个号段 [0, 10), 补偿线程随即获取第二个号段 [10, 20)
+	if id, err := bizAlloc.nextId(ctx); err != nil || id != 0 {
+		t.Fatalf("nextId = (%d, %v), want (0, nil)", id, err)
+	}
+	waitFor(t, "double buffer", func() bool { return len(bizAlloc.stats().segments) == 2 })
+
+	for want := int64(1); want < 9; want++ {
+		if id, err := bizAlloc.nextId(ctx); err != nil || id != want {
+			t.Fatalf("nextId = (%d, %v), want (%d, nil)", id, err, want)
+		}
+	}
+
+	// 取到 right-1 时号段立即弹出, 并触发补充第三个号段
+	if id, err := bizAlloc.nextId(ctx); err != nil || id != 9 {
+		t.Fatalf("nextId = (%d, %v), want (9, nil)", id, err)
+	}
+	waitFor(t, "refill after rollover", func() bool { return storage.callCount() == 3 })
+	waitFor(t, "third segment", func() bool { return len(bizAlloc.stats().segments) == 2 })
+
+	segments := bizAlloc.stats().segments
+	if segments[0].left != 10 || segments[1].left != 20 {
+		t.Fatalf("segments start at %d and %d, want 10 and 20", segments[0].left, segments[1].left)
+	}
+	if id, err := bizAlloc.nextId(ctx); err != nil || id != 10 {
+		t.Fatalf("nextId = (%d, %v), want (10, nil)", id, err)
+	}
+	if left := bizAlloc.stats().leftCount(); left != 19 {
+		t.Fatalf("leftCount = %d, want 19", left)
+	}
+}
+
+func TestNextIdScriptedSegments(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	storage.script = []fakeResult{
+		{maxId: 1005, step: 5}, // [1000, 1005)
+		{maxId: 2003, step: 3}, // [2000, 2003), 号段之间可以不连续
+	}
+	bizAlloc := newTestAlloc(t, storage).loadOrCreate("test")
+	ctx := context.Background()
+
+	var ids []int64
+	for i := 0; i < 8; i++ {
+		id, err := bizAlloc.nextId(ctx)
+		if err != nil {
+			t.Fatalf("nextId #%d: %v", i, err)
+		}
+		ids = append(ids, id)
+	}
+
+	want := []int64{1000, 1001, 1002, 1003, 1004, 2000, 2001, 2002}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestStepChange(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	alloc := newTestAlloc(t, storage)
+	bizAlloc := alloc.loadOrCreate("test")
+	ctx := context.Background()
+
+	if _, err := bizAlloc.nextId(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "double buffer", func() bool { return len(bizAlloc.stats().segments) == 2 })
+
+	// 管理接口修改步长后监控立即显示新步长, 容量比例仍按内存中号段的大小计算
+	alloc.applyStep("test", 50)
+	if g := bizAlloc.gauge(); g.step != 50 || g.segSize != 10 || g.ratio != 19.0/20 {
+		t.Fatalf("gauge = step %d, segSize %d, ratio %g, want 50, 10, 0.95", g.step, g.segSize, g.ratio)
+	}
+	alloc.applyStep("other", 50) // 内存中没有的业务无需更新
+
+	// 号段表中的步长被修改后, 下一次补充按新步长获取
+	storage.mutex.Lock()
+	storage.step = 50
+	storage.mutex.Unlock()
+	for i := 0; i < 9; i++ {
+		if _, err := bizAlloc.nextId(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "refill with new step", func() bool { return bizAlloc.stats().segSize == 50 })
+	segments := bizAlloc.stats().segments
+	if last := segments[len(segments)-1]; last.left != 20 || last.right != 70 {
+		t.Fatalf("refilled segment = [%d, %d), want [20, 70)", last.left, last.right)
+	}
+}
+
+func TestFillSegmentsGiveUp(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	storage.setErr(errors.New("db down"))
+	alloc := newTestAlloc(t, storage)
+	bizAlloc := alloc.loadOrCreate("test")
+	ctx := context.Background()
+
+	// 连续失败超过3次后放弃, 等待者被唤醒并立即失败
+	if _, err := bizAlloc.nextId(ctx); err == nil {
+		t.Fatal("nextId succeeded with failing storage")
+	}
+	if calls := storage.callCount(); calls != 4 {
+		t.Fatalf("storage called %d times, want 4", calls)
+	}
+	status := alloc.RefillStatus("test")
+	if status == nil || !status.Failing || status.GiveUps != 1 || status.LastError != "db down" {
+		t.Fatalf("RefillStatus = %+v, want failing after 1 give up", status)
+	}
+
+	// 放弃后的下一次请求重新触发补充
+	if _, err := bizAlloc.nextId(ctx); err == nil {
+		t.Fatal("nextId succeeded with failing storage")
+	}
+	if status = alloc.RefillStatus("test"); status.GiveUps != 2 {
+		t.Fatalf("GiveUps = %d, want 2", status.GiveUps)
+	}
+
+	// 存储恢复后补充成功, 放弃状态清除但保留最近一次错误
+	storage.setErr(nil)
+	if id, err := bizAlloc.nextId(ctx); err != nil || id != 0 {
+		t.Fatalf("nextId = (%d, %v), want (0, nil)", id, err)
+	}
+	if status = alloc.RefillStatus("test"); status.Failing || status.GiveUps != 0 || status.LastError != "db down" {
+		t.Fatalf("RefillStatus = %+v, want recovered", status)
+	}
+}
+
+func TestNextIdWakeup(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(100)
+	storage.gate = make(chan struct{})
+	bizAlloc := newTestAlloc(t, storage).loadOrCreate("test")
+
+	const waiters = 10
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		ids   = map[int64]bool{}
+	)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := bizAlloc.nextId(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			ids[id] = true
+			mutex.Unlock()
+		}()
+	}
+
+	// 所有请求都在等待第一个号段, 且只启动了一个补偿线程
+	waitFor(t, "waiters", func() bool { return bizAlloc.stats().waiting == waiters })
+	if calls := storage.callCount(); calls != 1 {
+		t.Fatalf("storage called %d times, want 1", calls)
+	}
+
+	// 只放行第一次获取: 第一个号段到达后立即唤醒全部等待者, 不必等第二个号段
+	storage.gate <- struct{}{}
+	wg.Wait()
+
+	if len(ids) != waiters {
+		t.Fatalf("got %d distinct ids, want %d", len(ids), waiters)
+	}
+	for id := range ids {
+		if id < 0 || id >= 100 {
+			t.Fatalf("id %d outside the first segment", id)
+		}
+	}
+	if waiting := bizAlloc.stats().waiting; waiting != 0 {
+		t.Fatalf("%d requests still waiting", waiting)
+	}
+}
+
+func TestNextIdWaitTimeout(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	storage.gate = make(chan struct{})
+	bizAlloc := newTestAlloc(t, storage).loadOrCreate("test")
+
+	// 请求时限先于补偿线程到期, 返回超时错误, 补偿线程继续运行
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFunc()
+	if _, err := bizAlloc.nextId(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("nextId err = %v, want context.DeadlineExceeded", err)
+	}
+	if waiting := bizAlloc.stats().waiting; waiting != 0 {
+		t.Fatalf("%d requests still waiting after timeout", waiting)
+	}
+
+	// 超时的请求已经离开等待队列, 号段到达后不会把号码交给它, 后续请求从号段开头分配
+	storage.gate <- struct{}{}
+	if id, err := bizAlloc.nextId(context.Background()); err != nil || id != 0 {
+		t.Fatalf("nextId = (%d, %v), want (0, nil)", id, err)
+	}
+}
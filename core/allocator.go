@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Allocator 独立的号段分配器, 自带配置、数据库连接池、号段存储和台账, 供以库的方式嵌入其他服务
+// 同一个进程中可以创建多个, 例如连接不同的数据库
+// 不读写 DefaultConfig、DefaultData、DefaultStore 和 DefaultAlloc, 也不受 HTTP 服务的选主和对等转发影响;
+// 日志、statsd 指标、告警和事件流是进程内共享的输出, 只有同一进程中按配置启动了服务时 statsd、告警和事件流才会输出
+type Allocator struct {
+	conf   *Config        // 创建时配置的副本
+	store  Storage        // 号段存储(MySQL 或本地文件)
+	data   *Data          // 数据库连接, 本地文件模式下为 nil
+	ledger *segmentLedger // 号段台账, 未配置时为 nil
+	alloc  *Alloc         // 号段分配器
+	rule   *bizTagRule    // 业务标识校验规则
+}
+
+// New 按配置创建分配器, 只使用配置中的号段存储、数据库、号段表、熔断、降级、预取、台账和业务标识校验部分
+// 号段存储为本地文件时不依赖任何外部服务
+// 服务端口、认证和告警等属于 HTTP 服务, 以库的方式使用时不生效
+func New(conf Config) (allocator *Allocator, err error) {
+	allocator = &Allocator{conf: &conf}
+
+	if allocator.rule, err = newBizTagRule(conf.BizTag); err != nil {
+		return nil, err
+	}
+	if err = checkPartition(allocator.conf); err != nil {
+		return nil, err
+	}
+	if err = checkLayout(allocator.conf); err != nil {
+		return nil, err
+	}
+	if err = checkCapacity(allocator.conf); err != nil {
+		return nil, err
+	}
+	crons, err := checkPrefetch(allocator.conf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = newResetPolicies(allocator.conf); err != nil {
+		return nil, err
+	}
+	if allocator.store, allocator.data, err = openStore(allocator.conf); err != nil {
+		return nil, err
+	}
+	if allocator.ledger, err = newLedger(allocator.conf, allocator.data); err != nil {
+		_ = allocator.store.Close()
+		return nil, err
+	}
+	allocator.alloc = newAlloc(allocator.conf, newStorage(allocator.conf, allocator.store, allocator.ledger))
+	if len(crons) != 0 {
+		go allocator.alloc.runSchedules(crons, nil)
+	}
+	return
+}
+
+// NextId 获取指定业务的下一个ID, 业务标识不符合校验规则时返回 ErrInvalidBizTag
+func (allocator *Allocator) NextId(ctx context.Context, bizTag string) (int64, error) {
+	if err := allocator.rule.validate(bizTag); err != nil {
+		return 0, err
+	}
+	return allocator.alloc.NextId(ctx, bizTag)
+}
+
+// Use 注册分配流程的钩子, 按注册顺序执行
+func (allocator *Allocator) Use(hooks ...Hooks) {
+	allocator.alloc.Use(hooks...)
+}
+
+// LeftCount 获取业务在内存中剩余的号码数量
+func (allocator *Allocator) LeftCount(bizTag string) int64 {
+	return allocator.alloc.LeftCount(bizTag)
+}
+
+// RefillStatus 获取业务补偿线程的状态, 从未失败过时返回 nil
+func (allocator *Allocator) RefillStatus(bizTag string) *RefillStatus {
+	return allocator.alloc.RefillStatus(bizTag)
+}
+
+// Close 等待补偿线程结束, ctx 到期后取消它们, 然后关闭台账和号段存储
+func (allocator *Allocator) Close(ctx context.Context) error {
+	var errs []error
+
+	errs = append(errs, allocator.alloc.Close(ctx))
+	if allocator.ledger != nil {
+		errs = append(errs, allocator.ledger.close())
+	}
+	errs = append(errs, allocator.store.Close())
+	return errors.Join(errs...)
+}
+
+// Handler 返回提供 /alloc 和 /health 接口的 HTTP 处理器, 响应格式与独立部署的服务一致
+// 不包含认证、限流等中间件, 由调用方按需包装; 号段存储不可用时不转发给对等实例, /health 不报告选主角色
+func (allocator *Allocator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alloc", func(w http.ResponseWriter, r *http.Request) {
+		serveAlloc(allocator.alloc, allocator.rule, nil, w, r)
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		serveHealth(allocator.alloc, nil, w, r)
+	})
+	return mux
+}
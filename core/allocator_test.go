@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAllocator(t *testing.T) {
+	DefaultConfig, DefaultAlloc, DefaultData = nil, nil, nil
+
+	// 不检查数据库是否可达, 连接池在第一次获取号段时才建立连接
+	conf := NewConfig()
+	conf.DSN = "root:leaf@tcp(127.0.0.1:1)/leaf"
+	conf.Table = "segments"
+	conf.Failover.StartupRetries = -1
+	conf.Log.Level = "error"
+	allocator, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		cancelFunc()
+		if err := allocator.Close(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			t.Error(err)
+		}
+	}()
+	if DefaultConfig != nil || DefaultAlloc != nil || DefaultData != nil {
+		t.Fatal("New modified the global instances")
+	}
+
+	if _, err = allocator.NextId(context.Background(), "bad tag"); !errors.Is(err, ErrInvalidBizTag) {
+		t.Fatalf("NextId err = %v, want ErrInvalidBizTag", err)
+	}
+
+	// 替换为内存号段存储, 验证 HTTP 处理器使用的是该分配器
+	allocator.alloc = newAlloc(allocator.conf, newFakeStorage(10))
+	handler := allocator.Handler()
+	for _, c := range []struct {
+		url    string
+		status int
+		errNo  int
+	}{
+		{"/alloc?biz_tag=test", http.StatusOK, 0},
+		{"/alloc?biz_tag=bad%20tag", http.StatusBadRequest, ErrNoInvalidBizTag},
+		{"/health?biz_tag=test", http.StatusOK, 0},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.url, nil))
+		var resp AllocResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != c.status || resp.ErrNo != c.errNo {
+			t.Fatalf("%s: status %d, body %q, want status %d and err_no %d", c.url, w.Code, w.Body.String(), c.status, c.errNo)
+		}
+	}
+	if left := allocator.LeftCount("test"); left <= 0 {
+		t.Fatalf("LeftCount = %d after allocating", left)
+	}
+}
+
+func TestAllocatorIgnoresServerState(t *testing.T) {
+	setupTestConfig(t)
+
+	// 同一进程中的服务是备用实例并配置了对等实例
+	var forwarded atomic.Int64
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		_, _ = w.Write([]byte(`{"err_no":0,"msg":"success","id":42}`))
+	}))
+	defer peer.Close()
+	election, peers = &elector{}, newPeerForwarder(PeerConfig{Addrs: []string{peer.URL}}, AuthConfig{})
+	t.Cleanup(func() { election, peers = nil, nil })
+
+	storage := newFakeStorage(10)
+	storage.setErr(ErrCircuitOpen)
+	allocator := &Allocator{conf: DefaultConfig, alloc: newTestAlloc(t, storage)}
+	handler := allocator.Handler()
+
+	// 嵌入的分配器不按全局选主报告角色, 也不把请求转发给服务的对等实例
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health?biz_tag=test", nil))
+	if w.Code == http.StatusServiceUnavailable || strings.Contains(w.Body.String(), "standby") {
+		t.Fatalf("/health = %d %s, want no standby role", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil))
+	if w.Code == http.StatusOK || forwarded.Load() != 0 {
+		t.Fatalf("/alloc = %d %s after %d forwards, want the local error", w.Code, w.Body, forwarded.Load())
+	}
+
+	// 服务自身的处理器仍按全局状态处理
+	DefaultAlloc = newTestAlloc(t, storage)
+	t.Cleanup(func() { DefaultAlloc = nil })
+	w = httptest.NewRecorder()
+	handleAlloc(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil))
+	if w.Code != http.StatusOK || forwarded.Load() != 1 {
+		t.Fatalf("server /alloc = %d %s after %d forwards, want forwarded to the peer", w.Code, w.Body, forwarded.Load())
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	CREATE TABLE `audit_log` (
+	 `id` bigint NOT NULL AUTO_INCREMENT,
+	 `time` datetime(3) NOT NULL,
+	 `actor` varchar(128) NOT NULL,
+	 `remote_addr` varchar(64) NOT NULL,
+	 `action` varchar(64) NOT NULL,
+	 `target` varchar(128) DEFAULT '' NOT NULL,
+	 `detail` varchar(1024) DEFAULT '' NOT NULL,
+	 PRIMARY KEY (`id`),
+	 KEY `idx_time` (`time`)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+*/
+
+// 审计的管理操作
+const (
+	AuditLogLevel    = "log_level"   // 调整日志级别
+	AuditDescription = "description" // 修改业务描述
+	AuditArchive     = "archive"     // 归档或恢复业务
+	AuditStep        = "step"        // 修改业务步长
+	AuditAdvance     = "advance"     // 跳号
+)
+
+// AuditConfig 定义管理操作审计日志的配置, 文件和数据库表可同时启用, 查询时优先使用数据库表
+type AuditConfig struct {
+	File  string `json:"file"`  // 审计日志文件, 每行一条 JSON, 为空表示不写文件
+	Table string `json:"table"` // 审计日志数据库表, 为空表示不写数据库
+}
+
+// AuditEntry 一条审计记录
+type AuditEntry struct {
+	Time       time.Time `json:"time"`        // 操作时间
+	Actor      string    `json:"actor"`       // 操作人, 未启用认证时为 anonymous
+	RemoteAddr string    `json:"remote_addr"` // 操作来源地址
+	Action     string    `json:"action"`      // 操作类型
+	Target     string    `json:"target"`      // 操作对象, 如业务标识
+	Detail     string    `json:"detail"`      // 操作内容
+}
+
+// AuditResponse 用于封装审计日志查询请求的响应
+type AuditResponse struct {
+	ErrNo   int          `json:"err_no"`  // 错误码
+	Msg     string       `json:"msg"`     // 错误或成功消息
+	Entries []AuditEntry `json:"entries"` // 审计记录, 按时间倒序
+}
+
+// auditLog 将管理操作写入文件和数据库表
+type auditLog struct {
+	conf  AuditConfig
+	mutex sync.Mutex // 保证文件中每行完整
+	file  *os.File
+}
+
+// auditor 全局审计日志, 未启用时为 nil
+var auditor *auditLog
+
+// InitAudit 根据配置初始化审计日志, 数据库表依赖 InitData
+func InitAudit() (err error) {
+	var (
+		conf = DefaultConfig.Audit
+		file *os.File
+	)
+
+	if conf.File == "" && conf.Table == "" {
+		return
+	}
+	if conf.File != "" {
+		if file, err = os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640); err != nil {
+			return
+		}
+	}
+	auditor = &auditLog{conf: conf, file: file}
+	return
+}
+
+// record 记录一次管理操作, 写入失败只输出日志, 不影响操作本身
+func (audit *auditLog) record(r *http.Request, action string, target string, detail string) {
+	entry := AuditEntry{
+		Time:       time.Now(),
+		Actor:      callerName(r),
+		RemoteAddr: r.RemoteAddr,
+		Action:     action,
+		Target:     target,
+		Detail:     detail,
+	}
+	logger.Info("admin action", "actor", entry.Actor, "remote_addr", entry.RemoteAddr,
+		"action", action, "target", target, "detail", detail)
+
+	if audit == nil {
+		return
+	}
+
+	if audit.file != nil {
+		line, _ := json.Marshal(&entry)
+		audit.mutex.Lock()
+		_, err := audit.file.Write(append(line, '\n'))
+		audit.mutex.Unlock()
+		if err != nil {
+			logger.Error("write audit file failed", "file", audit.conf.File, "err", err)
+		}
+	}
+
+	if audit.conf.Table != "" {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelFunc()
+		_, err := DefaultData.current().ExecContext(ctx,
+			"INSERT INTO "+audit.conf.Table+"(time, actor, remote_addr, action, target, detail) VALUES(?, ?, ?, ?, ?, ?)",
+			entry.Time, entry.Actor, entry.RemoteAddr, entry.Action, entry.Target, entry.Detail)
+		if err != nil {
+			logger.Error("write audit table failed", "table", audit.conf.Table, "err", err)
+		}
+	}
+}
+
+// query 按时间倒序查询最近的审计记录, action 为空表示全部
+func (audit *auditLog) query(ctx context.Context, action string, limit int) ([]AuditEntry, error) {
+	if audit.conf.Table != "" {
+		return audit.queryTable(ctx, action, limit)
+	}
+	return audit.queryFile(action, limit)
+}
+
+// queryTable 从数据库表查询审计记录
+func (audit *auditLog) queryTable(ctx context.Context, action string, limit int) (entries []AuditEntry, err error) {
+	var (
+		rows *sql.Rows
+	)
+
+	if rows, err = DefaultData.reader().QueryContext(ctx,
+		"SELECT time, actor, remote_addr, action, target, detail FROM "+audit.conf.Table+
+			" WHERE ? = '' OR action = ? ORDER BY id DESC LIMIT ?", action, action, limit); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		if err = rows.Scan(&entry.Time, &entry.Actor, &entry.RemoteAddr, &entry.Action, &entry.Target, &entry.Detail); err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	return
+}
+
+// queryFile 从审计日志文件查询审计记录, 只保留最近的 limit 条
+func (audit *auditLog) queryFile(action string, limit int) (entries []AuditEntry, err error) {
+	var (
+		file *os.File
+	)
+
+	if file, err = os.Open(audit.conf.File); err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || (action != "" && entry.Action != action) {
+			continue
+		}
+		if entries = append(entries, entry); len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	// 文件按时间顺序写入, 倒序输出
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, scanner.Err()
+}
+
+// handleAdminAudit 查询审计日志, 支持 action 和 limit 参数
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp  = AuditResponse{} // 响应数据
+		err   error             // 错误信息
+		limit = 100             // 默认返回的记录数
+	)
+
+	if auditor == nil {
+		w.WriteHeader(http.StatusNotFound)
+		resp.ErrNo, resp.Msg = -1, "audit log disabled"
+		goto RESP
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.ErrNo, resp.Msg = -1, "invalid limit"
+			goto RESP
+		}
+	}
+
+	if resp.Entries, err = auditor.query(r.Context(), r.URL.Query().Get("action"), limit); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		resp.ErrNo, resp.Msg = -1, err.Error()
+	} else {
+		resp.Msg = "success"
+	}
+
+RESP:
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,327 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	CREATE TABLE `api_keys` (
+	 `api_key` varchar(128) NOT NULL,
+	 `name` varchar(64) NOT NULL,
+	 `biz_tags` varchar(1024) DEFAULT '' NOT NULL COMMENT '逗号分隔, * 表示全部',
+	 `admin` tinyint(1) DEFAULT 0 NOT NULL COMMENT '是否允许访问管理接口',
+	 `namespaces` varchar(1024) DEFAULT '' NOT NULL COMMENT '逗号分隔, 可访问其中全部业务的命名空间',
+	 `admin_namespaces` varchar(1024) DEFAULT '' NOT NULL COMMENT '逗号分隔, 可管理其中业务的命名空间',
+	 PRIMARY KEY (`api_key`)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+*/
+
+// errNoCredentials 请求没有携带该认证方式的凭证, 交给下一种认证方式处理
+var errNoCredentials = errors.New("no credentials")
+
+// AuthConfig 定义调用方认证的配置
+type AuthConfig struct {
+	Enable         bool      `json:"enable"`          // 是否要求 /alloc 和 /health 的调用方认证
+	ProtectAdmin   bool      `json:"protect_admin"`   // 是否要求管理端口的调用方具有管理权限
+	Header         string    `json:"header"`          // 携带 API key 的请求头
+	Keys           []APIKey  `json:"keys"`            // 配置文件中定义的 API key
+	Table          string    `json:"table"`           // 存放 API key 的数据库表, 为空表示不从数据库加载
+	ReloadInterval int       `json:"reload_interval"` // 从数据库重新加载 API key 的间隔（毫秒）
+	JWT            JWTConfig `json:"jwt"`             // JWT/OIDC 认证配置
+}
+
+// APIKey 定义一个 API key 及其可访问的业务
+type APIKey struct {
+	Key             string   `json:"key"`              // 密钥
+	Name            string   `json:"name"`             // 调用方名称, 用于日志
+	BizTags         []string `json:"biz_tags"`         // 可访问的业务标识, * 表示全部
+	Admin           bool     `json:"admin"`            // 是否允许访问管理接口
+	Namespaces      []string `json:"namespaces"`       // 可访问其中全部业务的命名空间
+	AdminNamespaces []string `json:"admin_namespaces"` // 可管理其中业务的命名空间, 只允许按 biz_tag 操作的管理接口
+}
+
+// principal 已认证的调用方
+type principal struct {
+	name            string
+	bizTags         map[string]bool // 可访问的业务标识, 包含 * 时允许全部
+	admin           bool            // 是否允许访问管理接口
+	namespaces      map[string]bool // 可访问其中全部业务的命名空间
+	adminNamespaces map[string]bool // 可管理其中业务的命名空间
+}
+
+// newPrincipal 创建调用方
+func newPrincipal(name string, bizTags []string) *principal {
+	return &principal{name: name, bizTags: stringSet(bizTags)}
+}
+
+// newKeyPrincipal 按 API key 的权限创建调用方, 配置文件和数据库中的密钥权限相同
+func newKeyPrincipal(key APIKey) *principal {
+	p := newPrincipal(key.Name, key.BizTags)
+	p.admin = key.Admin
+	p.namespaces, p.adminNamespaces = stringSet(key.Namespaces), stringSet(key.AdminNamespaces)
+	return p
+}
+
+// stringSet 将去除空白后的非空字符串转换为集合
+func stringSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
+
+// principalKey 请求上下文中保存调用方的键
+type principalKey struct{}
+
+// callerName 返回请求的调用方名称, 未认证时为 anonymous
+func callerName(r *http.Request) string {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok && p.name != "" {
+		return p.name
+	}
+	return "anonymous"
+}
+
+// allow 判断调用方能否访问该业务
+func (p *principal) allow(bizTag string) bool {
+	if p.bizTags["*"] || p.bizTags[bizTag] {
+		return true
+	}
+	return namespaces != nil && p.namespaces[namespaces.namespace(bizTag)]
+}
+
+// authenticator 一种认证方式
+type authenticator interface {
+	// authenticate 识别请求的调用方, 请求没有携带该方式的凭证时返回 errNoCredentials
+	authenticate(r *http.Request) (*principal, error)
+}
+
+// apiKeyAuth 基于 API key 的认证, 密钥来自配置文件和数据库
+type apiKeyAuth struct {
+	conf     AuthConfig
+	mutex    sync.RWMutex
+	static   map[string]*principal // 配置文件中的密钥
+	loaded   map[string]*principal // 最近一次从数据库加载的密钥
+	stopChan chan struct{}         // 停止重新加载
+	wait     sync.WaitGroup        // 等待重新加载线程退出
+}
+
+// apiKeys 全局 API key 认证, 未启用时为 nil
+var apiKeys *apiKeyAuth
+
+// newAPIKeyAuth 创建 API key 认证, 配置了数据库表时先加载一次, 再定期重新加载
+func newAPIKeyAuth(conf AuthConfig) (auth *apiKeyAuth, err error) {
+	auth = &apiKeyAuth{
+		conf:     conf,
+		static:   map[string]*principal{},
+		stopChan: make(chan struct{}),
+	}
+	for _, key := range conf.Keys {
+		auth.static[key.Key] = newKeyPrincipal(key)
+	}
+
+	if conf.Table == "" {
+		return
+	}
+	if err = auth.reload(); err != nil {
+		return nil, err
+	}
+	auth.wait.Add(1)
+	go auth.reloadLoop()
+	return
+}
+
+// stop 停止重新加载 API key
+func (auth *apiKeyAuth) stop() {
+	close(auth.stopChan)
+	auth.wait.Wait()
+}
+
+// reload 从数据库加载全部 API key
+func (auth *apiKeyAuth) reload() (err error) {
+	var (
+		rows   *sql.Rows
+		loaded = map[string]*principal{}
+	)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFunc()
+
+	query := "SELECT api_key, name, biz_tags, admin, namespaces, admin_namespaces FROM " + auth.conf.Table
+	if rows, err = DefaultData.reader().QueryContext(ctx, query); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key                                  APIKey
+			bizTags, namespaces, adminNamespaces string
+		)
+		if err = rows.Scan(&key.Key, &key.Name, &bizTags, &key.Admin, &namespaces, &adminNamespaces); err != nil {
+			return
+		}
+		key.BizTags, key.Namespaces, key.AdminNamespaces = strings.Split(bizTags, ","), strings.Split(namespaces, ","), strings.Split(adminNamespaces, ",")
+		loaded[key.Key] = newKeyPrincipal(key)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	auth.mutex.Lock()
+	auth.loaded = loaded
+	auth.mutex.Unlock()
+	return
+}
+
+// reloadLoop 定期重新加载数据库中的 API key, 加载失败时保留上一次的结果
+func (auth *apiKeyAuth) reloadLoop() {
+	defer auth.wait.Done()
+
+	var (
+		interval = time.Duration(auth.conf.ReloadInterval) * time.Millisecond
+		ticker   *time.Ticker
+	)
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := auth.reload(); err != nil {
+				logger.Warn("reload api keys failed", "table", auth.conf.Table, "err", err)
+			}
+		case <-auth.stopChan:
+			return
+		}
+	}
+}
+
+// authenticate 根据请求头中的 API key 识别调用方
+func (auth *apiKeyAuth) authenticate(r *http.Request) (*principal, error) {
+	key := r.Header.Get(auth.conf.Header)
+	if key == "" {
+		return nil, errNoCredentials
+	}
+
+	if p, ok := auth.static[key]; ok {
+		return p, nil
+	}
+	auth.mutex.RLock()
+	p, ok := auth.loaded[key]
+	auth.mutex.RUnlock()
+	if ok {
+		return p, nil
+	}
+	return nil, errors.New("invalid api key")
+}
+
+// writeError 以分配接口的响应格式返回中间件拦截的错误
+func writeError(w http.ResponseWriter, status int, errNo int, msg string) {
+	writeFailure(w, nil, status, errNo, msg)
+}
+
+// writeFailure 以分配接口的响应格式返回错误, err 用于判断错误原因, 中间件拦截的错误为 nil
+func writeFailure(w http.ResponseWriter, err error, status int, errNo int, msg string) {
+	resp := AllocResponse{ErrNo: errNo, Msg: msg, RequestID: w.Header().Get(requestIDHeader)}
+	resp.Cause, resp.Retryable = errorCause(err, status, errNo)
+	bytes, _ := json.Marshal(&resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
+
+// authenticate 依次尝试各种认证方式, 使用第一个携带了凭证的认证方式的结果
+func authenticate(auths []authenticator, r *http.Request) (p *principal, err error) {
+	err = errNoCredentials
+	for _, auth := range auths {
+		if p, err = auth.authenticate(r); !errors.Is(err, errNoCredentials) {
+			break
+		}
+	}
+	return
+}
+
+// withAuth 要求调用方通过任意一种认证方式, 并且有权访问请求的 biz_tag
+func withAuth(auths []authenticator, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			bizTag = r.FormValue("biz_tag")
+		)
+
+		p, err := authenticate(auths, r)
+		if err != nil {
+			logger.Warn("request unauthorized", "path", r.URL.Path, "biz_tag", bizTag, "remote_addr", r.RemoteAddr, "err", err)
+			writeError(w, http.StatusUnauthorized, ErrNoUnauthorized, err.Error())
+			return
+		}
+		if bizTag != "" && !p.allow(bizTag) {
+			logger.Warn("request forbidden", "path", r.URL.Path, "biz_tag", bizTag, "caller", p.name)
+			writeError(w, http.StatusForbidden, ErrNoForbidden, "biz_tag not allowed")
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// withAdminAuth 要求调用方通过认证并具有管理权限
+func withAdminAuth(auths []authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticate(auths, r)
+		if err != nil {
+			logger.Warn("admin request unauthorized", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !p.adminAllowed(r) {
+			logger.Warn("admin request forbidden", "path", r.URL.Path, "caller", p.name)
+			http.Error(w, "admin permission required", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// newAuthenticators 按配置创建认证方式, 未启用认证时返回 nil
+func newAuthenticators() (auths []authenticator, err error) {
+	var (
+		conf   = DefaultConfig.Auth
+		apiKey *apiKeyAuth
+		jwt    *jwtAuth
+	)
+
+	if !conf.Enable && !conf.ProtectAdmin {
+		return
+	}
+	if len(conf.Keys) != 0 || conf.Table != "" {
+		if apiKey, err = newAPIKeyAuth(conf); err != nil {
+			return
+		}
+		apiKeys = apiKey
+		auths = append(auths, apiKey)
+	}
+	if conf.JWT.Enable {
+		if jwt, err = newJWTAuth(conf.JWT); err != nil {
+			return
+		}
+		jwtKeys = jwt
+		auths = append(auths, jwt)
+	}
+	if len(auths) == 0 {
+		err = errors.New("auth enabled without api keys or jwt")
+	}
+	return
+}
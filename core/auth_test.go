@@ -0,0 +1,153 @@
+package core
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	setupTestConfig(t)
+	auth, err := newAPIKeyAuth(AuthConfig{Header: "X-Api-Key", Keys: []APIKey{
+		{Key: "all-key", Name: "all", BizTags: []string{"*"}},
+		{Key: "order-key", Name: "order", BizTags: []string{" order ", ""}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caller string
+	handler := withAuth([]authenticator{auth}, func(w http.ResponseWriter, r *http.Request) { caller = callerName(r) })
+
+	for _, c := range []struct {
+		key, query string
+		code       int
+		caller     string
+	}{
+		{"", "biz_tag=order", http.StatusUnauthorized, ""},
+		{"bad-key", "biz_tag=order", http.StatusUnauthorized, ""},
+		{"order-key", "biz_tag=order", http.StatusOK, "order"}, // 去除空白后匹配
+		{"order-key", "biz_tag=user", http.StatusForbidden, ""},
+		{"order-key", "", http.StatusOK, "order"}, // 不指定业务的请求只要求认证
+		{"all-key", "biz_tag=user", http.StatusOK, "all"},
+	} {
+		caller = ""
+		r := httptest.NewRequest(http.MethodGet, "/alloc?"+c.query, nil)
+		if c.key != "" {
+			r.Header.Set("X-Api-Key", c.key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.code || caller != c.caller {
+			t.Errorf("key %q %s = %d caller %q, want %d caller %q", c.key, c.query, w.Code, caller, c.code, c.caller)
+		}
+	}
+}
+
+func TestAPIKeyAuthTable(t *testing.T) {
+	setupTestConfig(t)
+	table := &fakeTable{columns: []string{"api_key", "name", "biz_tags", "admin", "namespaces", "admin_namespaces"}}
+	table.setRows(
+		[]driver.Value{"ops-key", "ops", "", int64(1), "", ""},
+		[]driver.Value{"team-key", "team", "a,b", int64(0), "payments", "search"},
+		[]driver.Value{"static-key", "db", "", int64(1), "", ""},
+	)
+	openFakeData(t, table)
+
+	auth, err := newAPIKeyAuth(AuthConfig{
+		Header:         "X-Api-Key",
+		Keys:           []APIKey{{Key: "static-key", Name: "static", BizTags: []string{"*"}}},
+		Table:          "api_keys",
+		ReloadInterval: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := sync.OnceFunc(auth.stop)
+	t.Cleanup(stop)
+
+	lookup := func(key string) (*principal, error) {
+		r := httptest.NewRequest(http.MethodGet, "/alloc", nil)
+		r.Header.Set("X-Api-Key", key)
+		return auth.authenticate(r)
+	}
+
+	// 数据库中的密钥与配置文件中的密钥具有相同的权限字段
+	if p, err := lookup("ops-key"); err != nil || !p.admin || len(p.bizTags) != 0 {
+		t.Fatalf("ops-key = (%+v, %v), want admin without biz_tags", p, err)
+	}
+	p, err := lookup("team-key")
+	if err != nil || p.admin || !p.bizTags["a"] || !p.bizTags["b"] || !p.namespaces["payments"] || !p.adminNamespaces["search"] {
+		t.Fatalf("team-key = (%+v, %v), want biz_tags a,b, namespace payments and admin namespace search", p, err)
+	}
+
+	// 配置文件中的密钥优先于数据库
+	if p, err := lookup("static-key"); err != nil || p.name != "static" || p.admin {
+		t.Fatalf("static-key = (%+v, %v), want the key from config", p, err)
+	}
+
+	// 数据库中删除的密钥在重新加载后失效
+	table.setRows([]driver.Value{"ops-key", "ops", "", int64(0), "", ""})
+	waitFor(t, "reload", func() bool {
+		_, err := lookup("team-key")
+		return err != nil
+	})
+	if p, err := lookup("ops-key"); err != nil || p.admin {
+		t.Fatalf("ops-key = (%+v, %v), want admin revoked", p, err)
+	}
+
+	// 停止后不再查询
+	stop()
+	queries := table.queryCount()
+	time.Sleep(20 * time.Millisecond)
+	if got := table.queryCount(); got != queries {
+		t.Fatalf("%d queries after stop", got-queries)
+	}
+}
+
+func TestAdminAuthFromTable(t *testing.T) {
+	setupTestConfig(t)
+	table := &fakeTable{columns: []string{"api_key", "name", "biz_tags", "admin", "namespaces", "admin_namespaces"}}
+	table.setRows(
+		[]driver.Value{"ops-key", "ops", "", int64(1), "", ""},
+		[]driver.Value{"svc-key", "svc", "*", int64(0), "", ""},
+	)
+	openFakeData(t, table)
+
+	auth, err := newAPIKeyAuth(AuthConfig{Header: "X-Api-Key", Table: "api_keys"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(auth.stop)
+	handler := withAdminAuth([]authenticator{auth}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// 只从数据库加载密钥时也能授予管理权限
+	for key, code := range map[string]int{"ops-key": http.StatusOK, "svc-key": http.StatusForbidden, "": http.StatusUnauthorized} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/tags", nil)
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("admin request with %q = %d, want %d", key, w.Code, code)
+		}
+	}
+}
+
+func TestNewAuthenticators(t *testing.T) {
+	setupTestConfig(t)
+	t.Cleanup(func() { apiKeys = nil })
+
+	if auths, err := newAuthenticators(); auths != nil || err != nil {
+		t.Fatalf("disabled auth = (%v, %v), want (nil, nil)", auths, err)
+	}
+	DefaultConfig.Auth = AuthConfig{Enable: true}
+	if _, err := newAuthenticators(); err == nil {
+		t.Fatal("auth enabled without keys accepted")
+	}
+	DefaultConfig.Auth.Keys = []APIKey{{Key: "key", BizTags: []string{"*"}}}
+	if auths, err := newAuthenticators(); err != nil || len(auths) != 1 || apiKeys == nil {
+		t.Fatalf("newAuthenticators = (%v, %v), want the api key authenticator", auths, err)
+	}
+}
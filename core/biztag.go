@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidBizTag 业务标识不符合校验规则
+var ErrInvalidBizTag = errors.New("invalid biz_tag")
+
+// BizTagConfig 定义业务标识的校验规则
+type BizTagConfig struct {
+	Pattern   string   `json:"pattern"`    // 业务标识需要匹配的正则表达式, 为空表示不校验
+	MaxLength int      `json:"max_length"` // 业务标识的最大长度, 0 表示不限制
+	Allowlist []string `json:"allowlist"`  // 允许的业务标识, 为空表示不限制
+}
+
+// bizTagRule 编译后的业务标识校验规则
+type bizTagRule struct {
+	pattern   *regexp.Regexp
+	maxLength int
+	allowlist map[string]bool
+}
+
+// bizTagValidator 全局业务标识校验规则, 由 StartServer 初始化
+var bizTagValidator *bizTagRule
+
+// newBizTagRule 编译校验规则
+func newBizTagRule(conf BizTagConfig) (rule *bizTagRule, err error) {
+	rule = &bizTagRule{maxLength: conf.MaxLength}
+	if conf.Pattern != "" {
+		if rule.pattern, err = regexp.Compile(conf.Pattern); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.Allowlist) != 0 {
+		rule.allowlist = map[string]bool{}
+		for _, bizTag := range conf.Allowlist {
+			rule.allowlist[bizTag] = true
+		}
+	}
+	return
+}
+
+// validate 校验业务标识, 在访问分配器之前拒绝无效的业务, 避免创建无用的号段池和数据库查询
+func (rule *bizTagRule) validate(bizTag string) error {
+	if rule == nil {
+		return nil
+	}
+	if rule.maxLength > 0 && len(bizTag) > rule.maxLength {
+		return fmt.Errorf("%w: longer than %d", ErrInvalidBizTag, rule.maxLength)
+	}
+	if rule.pattern != nil && !rule.pattern.MatchString(bizTag) {
+		return fmt.Errorf("%w: must match %s", ErrInvalidBizTag, rule.pattern)
+	}
+	if rule.allowlist != nil && !rule.allowlist[bizTag] {
+		return fmt.Errorf("%w: not in allowlist", ErrInvalidBizTag)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器打开时快速失败返回的错误
+var ErrCircuitOpen = errors.New("segment storage circuit open")
+
+// 熔断器状态
+const (
+	breakerClosed   = 0 // 关闭: 正常放行
+	breakerOpen     = 1 // 打开: 全部快速失败
+	breakerHalfOpen = 2 // 半开: 放行少量探测请求
+)
+
+// breakerStateNames 熔断器状态的名称, 用于日志
+var breakerStateNames = map[int]string{
+	breakerClosed:   "closed",
+	breakerOpen:     "open",
+	breakerHalfOpen: "half_open",
+}
+
+// BreakerConfig 定义号段存储熔断器的配置
+type BreakerConfig struct {
+	Enable           bool `json:"enable"`            // 是否启用熔断器
+	FailureThreshold int  `json:"failure_threshold"` // 连续失败多少次后打开
+	OpenTimeout      int  `json:"open_timeout"`      // 打开后多久进入半开状态（毫秒）
+	HalfOpenProbes   int  `json:"half_open_probes"`  // 半开状态下的探测请求数, 全部成功后关闭
+}
+
+// breakerStorage 为号段存储增加熔断能力
+type breakerStorage struct {
+	Storage                   // 被包装的号段存储
+	mutex       sync.Mutex    // 保护以下状态
+	conf        BreakerConfig // 熔断器配置
+	state       int           // 当前状态
+	failures    int           // 关闭状态下的连续失败次数
+	openedAt    time.Time     // 进入打开状态的时间
+	probing     int           // 半开状态下正在进行的探测数
+	probeOk     int           // 半开状态下成功的探测数
+	openTimeout time.Duration // 打开状态的持续时间
+	clock       Clock         // 时钟, 为 nil 时使用系统时间
+}
+
+// newBreakerStorage 创建熔断器装饰的号段存储
+func newBreakerStorage(storage Storage, conf BreakerConfig) *breakerStorage {
+	if conf.FailureThreshold <= 0 {
+		conf.FailureThreshold = 5
+	}
+	if conf.HalfOpenProbes <= 0 {
+		conf.HalfOpenProbes = 1
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = 5000
+	}
+	return &breakerStorage{
+		Storage:     storage,
+		conf:        conf,
+		openTimeout: time.Duration(conf.OpenTimeout) * time.Millisecond,
+	}
+}
+
+// now 返回熔断器时钟的当前时间, 未设置时钟时使用系统时间
+func (breaker *breakerStorage) now() time.Time {
+	if breaker.clock == nil {
+		return time.Now()
+	}
+	return breaker.clock.Now()
+}
+
+// setState 切换熔断器状态, 调用方需持有锁
+func (breaker *breakerStorage) setState(state int) {
+	if breaker.state == state {
+		return
+	}
+	logger.Warn("circuit breaker state changed", "from", breakerStateNames[breaker.state], "to", breakerStateNames[state])
+	breaker.state = state
+	breaker.failures = 0
+	breaker.probing = 0
+	breaker.probeOk = 0
+	switch state {
+	case breakerOpen:
+		breaker.openedAt = breaker.now()
+		alerter.Fire(AlertEvent{Type: AlertBreakerOpen})
+	case breakerClosed:
+		alerter.Fire(AlertEvent{Type: AlertBreakerClosed})
+	}
+}
+
+// allow 判断本次请求是否放行
+func (breaker *breakerStorage) allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	// 打开状态持续足够久后进入半开
+	if breaker.state == breakerOpen && breaker.now().Sub(breaker.openedAt) >= breaker.openTimeout {
+		breaker.setState(breakerHalfOpen)
+	}
+
+	switch breaker.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if breaker.probing+breaker.probeOk >= breaker.conf.HalfOpenProbes { // 探测名额已用完
+			return false
+		}
+		breaker.probing++
+	}
+	return true
+}
+
+// report 根据请求结果更新熔断器状态
+func (breaker *breakerStorage) report(err error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	// 业务标识不存在或号码耗尽不属于存储故障
+	failed := isStorageFault(err)
+
+	switch breaker.state {
+	case breakerClosed:
+		if !failed {
+			breaker.failures = 0
+		} else if breaker.failures++; breaker.failures >= breaker.conf.FailureThreshold {
+			breaker.setState(breakerOpen)
+		}
+	case breakerHalfOpen:
+		breaker.probing--
+		if failed { // 探测失败, 重新打开
+			breaker.setState(breakerOpen)
+		} else if breaker.probeOk++; breaker.probeOk >= breaker.conf.HalfOpenProbes {
+			breaker.setState(breakerClosed)
+		}
+	}
+}
+
+// breaker 返回分配器号段存储的熔断器, 未启用时返回 nil
+func (alloc *Alloc) breaker() *breakerStorage {
+	storage := alloc.storage
+	if partition, ok := storage.(*partitionStorage); ok { // 分区包装在熔断器之外
+		storage = partition.Storage
+	}
+	breaker, _ := storage.(*breakerStorage)
+	return breaker
+}
+
+// State 返回熔断器当前状态的名称
+func (breaker *breakerStorage) State() string {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breakerStateNames[breaker.state]
+}
+
+// NextId 熔断器打开时直接返回 ErrCircuitOpen, 否则转发给被包装的存储
+func (breaker *breakerStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	if !breaker.allow() {
+		err = ErrCircuitOpen
+		return
+	}
+	maxId, step, err = breaker.Storage.NextId(ctx, bizTag, multiple)
+	breaker.report(err)
+	return
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBreakerStateMachine(t *testing.T) {
+	setupTestConfig(t)
+	saved := alerter
+	alerter = newTestAlerter(AlertConfig{})
+	t.Cleanup(func() { alerter = saved })
+	storage := newFakeStorage(10)
+	breaker := newBreakerStorage(storage, BreakerConfig{Enable: true, FailureThreshold: 3, OpenTimeout: 1000, HalfOpenProbes: 2})
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	breaker.clock = clock
+	dbErr := errors.New("driver: bad connection")
+
+	next := func() error {
+		_, _, err := breaker.NextId(context.Background(), "test", 1)
+		return err
+	}
+	expect := func(state string) {
+		t.Helper()
+		if got := breaker.State(); got != state {
+			t.Fatalf("state = %s, want %s", got, state)
+		}
+	}
+
+	// 关闭状态下连续失败达到阈值才打开, 中间的成功和业务不存在都不计入
+	storage.setErr(dbErr)
+	_, _ = next(), next()
+	storage.setErr(nil)
+	if err := next(); err != nil {
+		t.Fatal(err)
+	}
+	storage.setErr(ErrBizTagNotFound)
+	_, _, _ = next(), next(), next()
+	expect("closed")
+	storage.setErr(dbErr)
+	_, _, _ = next(), next(), next()
+	expect("open")
+
+	// 打开状态下快速失败, 不访问号段存储
+	calls := storage.callCount()
+	if err := next(); !errors.Is(err, ErrCircuitOpen) || storage.callCount() != calls {
+		t.Fatalf("NextId while open = %v with %d storage calls, want ErrCircuitOpen and none", err, storage.callCount()-calls)
+	}
+
+	// 超过打开时长后半开, 探测失败重新打开并重新计时
+	clock.advance(999 * time.Millisecond)
+	if err := next(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("NextId before the open timeout = %v, want ErrCircuitOpen", err)
+	}
+	clock.advance(time.Millisecond)
+	if err := next(); !errors.Is(err, dbErr) {
+		t.Fatalf("probe = %v, want the storage error", err)
+	}
+	expect("open")
+	clock.advance(999 * time.Millisecond)
+	if err := next(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("NextId after a failed probe = %v, want ErrCircuitOpen", err)
+	}
+
+	// 半开状态只放行配置数量的探测, 全部成功后关闭
+	clock.advance(time.Millisecond)
+	if !breaker.allow() || !breaker.allow() || breaker.allow() {
+		t.Fatal("half open breaker did not allow exactly 2 probes")
+	}
+	expect("half_open")
+	breaker.report(nil)
+	expect("half_open")
+	breaker.report(nil)
+	expect("closed")
+	storage.setErr(nil)
+	if err := next(); err != nil {
+		t.Fatalf("NextId after closing = %v", err)
+	}
+
+	// 打开和关闭都发出告警
+	want := []string{AlertBreakerOpen + ":", AlertBreakerOpen + ":", AlertBreakerClosed + ":"}
+	if alerts := drainAlerts(alerter); !slices.Equal(alerts, want) {
+		t.Fatalf("alerts = %v, want %v", alerts, want)
+	}
+}
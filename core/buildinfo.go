@@ -0,0 +1,86 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// 构建时通过 ldflags 注入的版本信息, 例如:
+//
+//	go build -ldflags "-X leaf-segment/core.Version=v1.2.0 -X leaf-segment/core.Commit=$(git rev-parse HEAD) -X leaf-segment/core.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// 未注入时版本和提交取自编译器嵌入的模块和版本控制信息
+var (
+	Version   string // 版本号
+	Commit    string // 构建时的提交
+	BuildDate string // 构建时间
+)
+
+// BuildInfo 运行中的二进制的构建信息
+type BuildInfo struct {
+	Version   string `json:"version"`    // 版本号, 未知时为 unknown
+	Commit    string `json:"commit"`     // 构建时的提交, 未知时为 unknown
+	BuildDate string `json:"build_date"` // 构建时间, 未知时为 unknown
+	GoVersion string `json:"go_version"` // 编译使用的 Go 版本
+}
+
+// buildInfo 读取一次构建信息, 之后复用
+var buildInfo = sync.OnceValue(readBuildInfo)
+
+// GetBuildInfo 返回运行中的二进制的构建信息
+func GetBuildInfo() BuildInfo {
+	return buildInfo()
+}
+
+// readBuildInfo 读取构建信息, ldflags 注入的值优先, 其次为编译器嵌入的模块和版本控制信息
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{Version: "unknown", Commit: "unknown", BuildDate: "unknown", GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" && setting.Value != "" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	if Version != "" {
+		info.Version = Version
+	}
+	if Commit != "" {
+		info.Commit = Commit
+	}
+	if BuildDate != "" {
+		info.BuildDate = BuildDate
+	}
+	return info
+}
+
+// String 返回一行可读的构建信息, 用于 -version 输出
+func (info BuildInfo) String() string {
+	return fmt.Sprintf("leaf-segment %s (commit %s, built %s, %s)", info.Version, info.Commit, info.BuildDate, info.GoVersion)
+}
+
+// handleVersion 返回运行中的二进制的构建信息
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if bytes, err := json.Marshal(buildInfo()); err == nil {
+		_, _ = w.Write(bytes)
+	}
+}
+
+// writeBuildInfoMetrics 输出构建信息, 值恒为1, 按标签统计各版本的实例数以发现版本不一致
+func writeBuildInfoMetrics(b *strings.Builder) {
+	info := buildInfo()
+	fmt.Fprintln(b, "# HELP leaf_build_info Build information of the running binary, always 1.")
+	fmt.Fprintln(b, "# TYPE leaf_build_info gauge")
+	fmt.Fprintf(b, "leaf_build_info{version=\"%s\",commit=\"%s\",build_date=\"%s\",go_version=\"%s\"} 1\n",
+		escapeLabel(info.Version), escapeLabel(info.Commit), escapeLabel(info.BuildDate), escapeLabel(info.GoVersion))
+}
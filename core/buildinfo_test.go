@@ -0,0 +1,25 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	saved := Version
+	Version = "v1.2.3"
+	t.Cleanup(func() { Version = saved })
+
+	info := readBuildInfo()
+	if info.Version != "v1.2.3" || info.GoVersion == "" || info.BuildDate == "" {
+		t.Fatalf("readBuildInfo = %+v, want the injected version", info)
+	}
+
+	w := httptest.NewRecorder()
+	handleVersion(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"go_version":"go`) {
+		t.Fatalf("version = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrIdExhausted 业务的号码已达到上限, 不再发放新号段
+var ErrIdExhausted = errors.New("id exhausted")
+
+// mysqlOutOfRange MySQL 的 BIGINT value is out of range 错误码, max_id 超过 int64 时返回
+const mysqlOutOfRange = 1690
+
+// CapacityConfig 定义号码容量的上限和告警, 防止 max_id 溢出
+// 号段的右边界超过上限时整段拒绝, 上限附近一个号段以内的号码不会发出
+type CapacityConfig struct {
+	WarnRatio float64                      `json:"warn_ratio"` // max_id 达到上限的该比例时告警, 0 表示不告警
+	Tags      map[string]TagCapacityConfig `json:"tags"`       // 按业务标识配置的上限和循环策略, 未配置的业务上限为 int64 最大值
+}
+
+// TagCapacityConfig 定义单个业务的号码上限
+type TagCapacityConfig struct {
+	Max      int64 `json:"max"`      // max_id 的上限, 0 表示 int64 最大值
+	Rollover bool  `json:"rollover"` // 达到上限后是否将 max_id 重置为 reset_to 继续发号, 重置后会再次发出以前的号码, 只用于允许循环的业务
+	ResetTo  int64 `json:"reset_to"` // 循环时重置到的 max_id, 需小于 max
+}
+
+// resetter 支持将号段行的 max_id 重置, 用于允许循环的业务
+type resetter interface {
+	// reset 仅当 max_id 仍为 from 时改为 to, 其他实例已经重置过时不做任何事
+	reset(ctx context.Context, bizTag string, from int64, to int64) error
+}
+
+// checkCapacity 检查号码容量配置
+func checkCapacity(conf *Config) error {
+	capacity := conf.Capacity
+	if capacity.WarnRatio < 0 || capacity.WarnRatio > 1 {
+		return fmt.Errorf("capacity.warn_ratio %g out of range 0 ~ 1", capacity.WarnRatio)
+	}
+	for bizTag, tag := range capacity.Tags {
+		if tag.Max < 0 {
+			return fmt.Errorf("capacity.tags[%q].max %d must not be negative", bizTag, tag.Max)
+		}
+		if tag.Rollover && (tag.ResetTo < 0 || tag.ResetTo >= tag.limit()) {
+			return fmt.Errorf("capacity.tags[%q].reset_to %d out of range 0 ~ max", bizTag, tag.ResetTo)
+		}
+	}
+	return nil
+}
+
+// limit 返回 max_id 的上限
+func (tag TagCapacityConfig) limit() int64 {
+	if tag.Max == 0 {
+		return math.MaxInt64
+	}
+	return tag.Max
+}
+
+// isStorageFault 错误是否属于号段存储故障, 业务不存在、已归档、号码耗尽或重置周期已结束不计入存储故障;
+// 请求被取消或超过时限是调用方的原因(客户端断开、request_timeout 到期、退出时取消补偿线程), 也不计入,
+// 否则数据库正常时也会打开熔断器、进入降级; 数据库本身无响应由驱动的读写超时报告
+func isStorageFault(err error) bool {
+	return err != nil && !errors.Is(err, ErrBizTagNotFound) && !errors.Is(err, ErrBizTagArchived) && !errors.Is(err, ErrIdExhausted) &&
+		!errors.Is(err, errPeriodPassed) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// capacityStorage 检查号段是否超过业务的号码上限
+type capacityStorage struct {
+	Storage
+	conf      CapacityConfig
+	partition PartitionConfig // 启用分区时号段行带有分区后缀, 按业务标识查找配置
+}
+
+// wrapCapacity 包装基础号段存储, 重置 max_id 时直接作用于基础存储
+func wrapCapacity(storage Storage, conf *Config) Storage {
+	return &capacityStorage{Storage: storage, conf: conf.Capacity, partition: conf.Partition}
+}
+
+// NextId 获取号段, 超过上限时拒绝或按配置循环, 接近上限时告警
+func (storage *capacityStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	var (
+		tag   = storage.conf.Tags[storage.partition.bizTag(bizTag)]
+		limit = tag.limit()
+	)
+
+	for attempt := 0; ; attempt++ {
+		if maxId, step, err = storage.Storage.NextId(ctx, bizTag, multiple); err != nil {
+			var mysqlErr *mysql.MySQLError
+			if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlOutOfRange {
+				storage.exhausted(bizTag, 0, math.MaxInt64)
+				err = fmt.Errorf("%w: biz_tag %s max_id exceeds int64", ErrIdExhausted, bizTag)
+			}
+			return
+		}
+		if maxId <= limit {
+			break
+		}
+
+		// 超过上限, 不允许循环的业务拒绝发号
+		resetter, ok := storage.Storage.(resetter)
+		if !tag.Rollover || !ok || attempt > 0 {
+			storage.exhausted(bizTag, maxId, limit)
+			return 0, 0, fmt.Errorf("%w: biz_tag %s max_id %d exceeds %d", ErrIdExhausted, bizTag, maxId, limit)
+		}
+		if err = resetter.reset(ctx, bizTag, maxId, tag.ResetTo); err != nil {
+			return 0, 0, err
+		}
+		logger.Warn("max_id rolled over", "biz_tag", bizTag, "max_id", maxId, "limit", limit, "reset_to", tag.ResetTo)
+		alerter.Fire(AlertEvent{Type: AlertIdRollover, BizTag: bizTag, MaxId: maxId, Limit: limit})
+	}
+
+	// 接近上限时提前告警, 冷却期内只发送一次
+	if ratio := storage.conf.WarnRatio; ratio > 0 && float64(maxId) >= float64(limit)*ratio {
+		alerter.Fire(AlertEvent{Type: AlertIdNearLimit, BizTag: bizTag, MaxId: maxId, Limit: limit})
+	}
+	return
+}
+
+// exhausted 记录号码耗尽并告警, 溢出 int64 时 maxId 未知, 为 0
+func (storage *capacityStorage) exhausted(bizTag string, maxId int64, limit int64) {
+	logger.Error("id exhausted", "biz_tag", bizTag, "max_id", maxId, "limit", limit)
+	alerter.Fire(AlertEvent{Type: AlertIdExhausted, BizTag: bizTag, MaxId: maxId, Limit: limit})
+}
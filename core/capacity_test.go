@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCapacity(t *testing.T) {
+	for name, capacity := range map[string]CapacityConfig{
+		"warn ratio":  {WarnRatio: 1.5},
+		"negative":    {Tags: map[string]TagCapacityConfig{"test": {Max: -1}}},
+		"reset above": {Tags: map[string]TagCapacityConfig{"test": {Max: 100, Rollover: true, ResetTo: 100}}},
+	} {
+		if err := checkCapacity(&Config{Capacity: capacity}); err == nil {
+			t.Errorf("%s: checkCapacity accepted %+v", name, capacity)
+		}
+	}
+	if err := checkCapacity(&Config{Capacity: CapacityConfig{WarnRatio: 0.9, Tags: map[string]TagCapacityConfig{"test": {Max: 100, Rollover: true}}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapacityExhausted(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Capacity = CapacityConfig{Tags: map[string]TagCapacityConfig{"test": {Max: 250}}}
+	storage := wrapCapacity(newFakeStorage(100), DefaultConfig)
+	ctx := context.Background()
+
+	for _, want := range []int64{100, 200} {
+		if maxId, _, err := storage.NextId(ctx, "test", 1); err != nil || maxId != want {
+			t.Fatalf("NextId = (%d, %v), want %d", maxId, err, want)
+		}
+	}
+	// 号段 [200, 300) 跨过上限, 整段拒绝, 且不属于存储故障
+	_, _, err := storage.NextId(ctx, "test", 1)
+	if !errors.Is(err, ErrIdExhausted) || isStorageFault(err) {
+		t.Fatalf("NextId err = %v, want ErrIdExhausted", err)
+	}
+	if status, errNo, _ := allocFailure(context.Background(), "test", err); status != http.StatusGone || errNo != ErrNoIdExhausted {
+		t.Fatalf("allocFailure = (%d, %d), want (410, %d)", status, errNo, ErrNoIdExhausted)
+	}
+
+	// 未配置上限的业务不受影响
+	if _, _, err = storage.NextId(ctx, "other", 5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapacityRollover(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Partition = PartitionConfig{Enable: true, Count: 2, Instance: 1, Separator: "#"}
+	DefaultConfig.Capacity = CapacityConfig{Tags: map[string]TagCapacityConfig{"test": {Max: 200, Rollover: true, ResetTo: 0}}}
+	store, err := openFileStore(FileStoreConfig{Path: filepath.Join(t.TempDir(), "segments.json"), Step: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storage := wrapCapacity(store, DefaultConfig)
+	ctx := context.Background()
+
+	// 按去掉分区后缀的业务标识查找配置, 超过上限后重置并继续发号
+	for _, want := range []int64{100, 200, 100, 200, 100} {
+		if maxId, _, err := storage.NextId(ctx, "test#1", 1); err != nil || maxId != want {
+			t.Fatalf("NextId = (%d, %v), want %d", maxId, err, want)
+		}
+	}
+}
+
+func TestFileStoreOverflow(t *testing.T) {
+	store, err := openFileStore(FileStoreConfig{Path: filepath.Join(t.TempDir(), "segments.json"), Step: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.segments["test"] = fileSegment{MaxId: math.MaxInt64 - 150, Step: 100}
+
+	if maxId, _, err := store.NextId(context.Background(), "test", 1); err != nil || maxId != math.MaxInt64-50 {
+		t.Fatalf("NextId = (%d, %v)", maxId, err)
+	}
+	if _, _, err = store.NextId(context.Background(), "test", 1); !errors.Is(err, ErrIdExhausted) {
+		t.Fatalf("NextId err = %v, want ErrIdExhausted", err)
+	}
+}
+
+func TestAllocIdExhausted(t *testing.T) {
+	setupHandlerTest(t)
+	DefaultConfig.Capacity = CapacityConfig{Tags: map[string]TagCapacityConfig{"test": {Max: 1}}}
+	DefaultAlloc = newTestAlloc(t, wrapCapacity(newFakeStorage(100), DefaultConfig))
+
+	w := httptest.NewRecorder()
+	handleAlloc(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil))
+	if w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "id exhausted") {
+		t.Fatalf("alloc = %d %s, want 410 id exhausted", w.Code, w.Body)
+	}
+}
+
+func TestStorageFaultExcludesContextErrors(t *testing.T) {
+	setupTestConfig(t)
+	breaker := newBreakerStorage(newFakeStorage(100), BreakerConfig{Enable: true, FailureThreshold: 2})
+
+	// 客户端断开和请求超时不计入存储故障, 不会打开熔断器
+	for _, err := range []error{context.Canceled, fmt.Errorf("fetch segment: %w", context.DeadlineExceeded)} {
+		if isStorageFault(err) {
+			t.Fatalf("isStorageFault(%v) = true, want false", err)
+		}
+		breaker.report(err)
+		breaker.report(err)
+	}
+	if breaker.state != breakerClosed {
+		t.Fatalf("breaker state = %s after context errors, want closed", breakerStateNames[breaker.state])
+	}
+	if !isStorageFault(errors.New("driver: bad connection")) {
+		t.Fatal("isStorageFault(driver error) = false, want true")
+	}
+}
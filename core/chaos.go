@@ -0,0 +1,15 @@
+package core
+
+// ChaosConfig 定义号段存储故障注入的配置, 用于演练数据库延迟抖动和故障,
+// 只在使用 -tags chaos 编译时生效, 生产构建中启用只会输出告警
+type ChaosConfig struct {
+	Enable      bool     `json:"enable"`       // 是否启用故障注入
+	Seed        int64    `json:"seed"`         // 随机数种子, 0 表示每次启动随机, 固定种子可复现同一序列
+	Tags        []string `json:"tags"`         // 只对这些业务注入故障, 为空表示全部业务
+	LatencyRate float64  `json:"latency_rate"` // 注入延迟的比例, 0~1
+	Latency     int      `json:"latency"`      // 注入的基础延迟（毫秒）
+	Jitter      int      `json:"jitter"`       // 在基础延迟上随机增加的最大延迟（毫秒）
+	ErrorRate   float64  `json:"error_rate"`   // 不访问数据库直接返回错误的比例, 0~1
+	PartialRate float64  `json:"partial_rate"` // 数据库已推进 max_id 但返回错误的比例, 模拟提交成功而响应丢失, 0~1
+	HangRate    float64  `json:"hang_rate"`    // 一直阻塞直到请求被取消的比例, 模拟数据库无响应, 0~1
+}
@@ -0,0 +1,11 @@
+//go:build !chaos
+
+package core
+
+// wrapChaos 生产构建不包含故障注入, 配置了也不生效
+func wrapChaos(storage Storage, conf ChaosConfig) Storage {
+	if conf.Enable {
+		logger.Warn("chaos is enabled in config but this binary was built without -tags chaos, ignored")
+	}
+	return storage
+}
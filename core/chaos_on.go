@@ -0,0 +1,103 @@
+//go:build chaos
+
+package core
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrChaos 故障注入返回的错误
+var ErrChaos = errors.New("chaos: injected storage error")
+
+// chaosStorage 按配置随机注入延迟、错误和部分失败的号段存储
+type chaosStorage struct {
+	Storage             // 被包装的号段存储
+	conf    ChaosConfig // 故障注入配置
+	mutex   sync.Mutex  // 保护随机数生成器
+	rand    *rand.Rand  // 随机数生成器
+}
+
+// wrapChaos 按配置为号段存储增加故障注入
+func wrapChaos(storage Storage, conf ChaosConfig) Storage {
+	var (
+		seed = conf.Seed
+	)
+
+	if !conf.Enable {
+		return storage
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.Warn("chaos storage enabled, segment fetches will randomly fail",
+		"seed", seed, "tags", conf.Tags, "latency_rate", conf.LatencyRate, "error_rate", conf.ErrorRate,
+		"partial_rate", conf.PartialRate, "hang_rate", conf.HangRate)
+	return &chaosStorage{
+		Storage: storage,
+		conf:    conf,
+		rand:    rand.New(rand.NewPCG(uint64(seed), uint64(seed))),
+	}
+}
+
+// roll 掷一次骰子, 返回 [0, 1) 的随机数和 [0, n) 的随机整数
+func (chaos *chaosStorage) roll(n int) (p float64, jitter int) {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+
+	p = chaos.rand.Float64()
+	if n > 0 {
+		jitter = chaos.rand.IntN(n)
+	}
+	return
+}
+
+// NextId 按配置的比例依次注入延迟、无响应、错误和部分失败
+func (chaos *chaosStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	var (
+		conf = chaos.conf
+	)
+
+	if len(conf.Tags) != 0 && !slices.Contains(conf.Tags, bizTag) {
+		return chaos.Storage.NextId(ctx, bizTag, multiple)
+	}
+
+	// 注入延迟, 请求被取消时提前返回
+	if p, jitter := chaos.roll(conf.Jitter); p < conf.LatencyRate {
+		delay := time.Duration(conf.Latency+jitter) * time.Millisecond
+		logger.Debug("chaos latency injected", "biz_tag", bizTag, "delay_ms", delay.Milliseconds())
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, 0, ctx.Err()
+		}
+	}
+
+	// 模拟数据库无响应, 直到请求被取消
+	if p, _ := chaos.roll(0); p < conf.HangRate {
+		logger.Debug("chaos hang injected", "biz_tag", bizTag)
+		<-ctx.Done()
+		return 0, 0, ctx.Err()
+	}
+
+	// 不访问数据库直接失败
+	if p, _ := chaos.roll(0); p < conf.ErrorRate {
+		logger.Debug("chaos error injected", "biz_tag", bizTag)
+		return 0, 0, ErrChaos
+	}
+
+	maxId, step, err = chaos.Storage.NextId(ctx, bizTag, multiple)
+
+	// 数据库已推进 max_id, 但调用方拿不到号段, 这段号码被浪费
+	if p, _ := chaos.roll(0); err == nil && p < conf.PartialRate {
+		logger.Debug("chaos partial failure injected", "biz_tag", bizTag, "lost_max_id", maxId)
+		return 0, 0, ErrChaos
+	}
+	return
+}
@@ -0,0 +1,52 @@
+//go:build chaos
+
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChaosStorage(t *testing.T) {
+	setupTestConfig(t)
+	ctx := context.Background()
+
+	t.Run("error", func(t *testing.T) {
+		storage := newFakeStorage(10)
+		chaos := wrapChaos(storage, ChaosConfig{Enable: true, Seed: 1, ErrorRate: 1})
+		if _, _, err := chaos.NextId(ctx, "test", 1); !errors.Is(err, ErrChaos) {
+			t.Fatalf("err = %v, want ErrChaos", err)
+		}
+		if calls := storage.callCount(); calls != 0 {
+			t.Fatalf("storage called %d times, want 0", calls)
+		}
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		storage := newFakeStorage(10)
+		chaos := wrapChaos(storage, ChaosConfig{Enable: true, Seed: 1, PartialRate: 1})
+		if _, _, err := chaos.NextId(ctx, "test", 1); !errors.Is(err, ErrChaos) {
+			t.Fatalf("err = %v, want ErrChaos", err)
+		}
+		if maxId, _, _ := storage.NextId(ctx, "test", 1); maxId != 20 {
+			t.Fatalf("max_id = %d, want 20 after a lost segment", maxId)
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		chaos := wrapChaos(newFakeStorage(10), ChaosConfig{Enable: true, Seed: 1, ErrorRate: 1, Tags: []string{"other"}})
+		if _, _, err := chaos.NextId(ctx, "test", 1); err != nil {
+			t.Fatalf("err = %v for a tag without chaos", err)
+		}
+	})
+
+	t.Run("hang", func(t *testing.T) {
+		chaos := wrapChaos(newFakeStorage(10), ChaosConfig{Enable: true, Seed: 1, HangRate: 1})
+		ctx, cancelFunc := context.WithCancel(ctx)
+		cancelFunc()
+		if _, _, err := chaos.NextId(ctx, "test", 1); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CheckResult 一项配置检查的结果
+type CheckResult struct {
+	Name   string `json:"name"`   // 检查项
+	OK     bool   `json:"ok"`     // 是否通过
+	Detail string `json:"detail"` // 通过时为检查内容的摘要, 失败时为错误
+}
+
+// CheckReport 配置检查的报告
+type CheckReport struct {
+	Config  string        `json:"config"`  // 配置文件路径
+	Results []CheckResult `json:"results"` // 各项检查的结果, 按检查顺序排列
+}
+
+// OK 所有检查项是否都通过
+func (report CheckReport) OK() bool {
+	for _, result := range report.Results {
+		if !result.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// String 每个检查项输出一行, 最后一行为结论
+func (report CheckReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "checking %s\n", report.Config)
+	for _, result := range report.Results {
+		status := "ok  "
+		if !result.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "  %s %-20s %s\n", status, result.Name, result.Detail)
+	}
+	if report.OK() {
+		b.WriteString("config ok\n")
+	} else {
+		b.WriteString("config has errors\n")
+	}
+	return b.String()
+}
+
+// add 记录一项检查的结果, 通过时记录摘要
+func (report *CheckReport) add(name string, detail string, err error) {
+	if err != nil {
+		report.Results = append(report.Results, CheckResult{Name: name, Detail: err.Error()})
+		return
+	}
+	report.Results = append(report.Results, CheckResult{Name: name, OK: true, Detail: detail})
+}
+
+// CheckConfig 加载并校验配置文件, 读取证书和密钥, 连接数据库并检查号段表结构, 用于部署前发现配置错误
+// 不启动服务、不修改数据、不锁定号段文件, 可以在正在服务的实例旁边运行; 配置文件无法加载时不再进行后续检查
+func CheckConfig(ctx context.Context, filename string) (report CheckReport) {
+	report.Config = filename
+
+	if err := LoadConfig(filename); err != nil {
+		report.add("config", "", err)
+		return
+	}
+	conf := DefaultConfig
+	report.add("config", fmt.Sprintf("store=%s table=%s http_port=%d admin_port=%d",
+		cmp.Or(conf.Store.Type, StoreMySQL), conf.Table, conf.HttpPort, conf.Admin.Port), nil)
+
+	report.add("log", "level="+conf.Log.Level+" file="+conf.Log.File.Path, checkLogConfig(conf.Log))
+	report.add("secrets", "tls certificates and keys readable", checkSecrets(conf))
+	report.add("memory", fmt.Sprintf("limit=%dMB limit_ratio=%g gc_percent=%d", conf.Memory.Limit, conf.Memory.LimitRatio,
+		conf.Memory.GCPercent), checkMemory(conf.Memory))
+	report.add("alert", fmt.Sprintf("%d biz_tag thresholds table=%s", len(conf.Alert.Tags), conf.Alert.Table),
+		checkAlertThresholds(conf.Alert))
+	report.add("allocator", "partition, layout, capacity, prefetch, reset and registry valid", checkAllocConfig(conf))
+	report.add("http", "biz_tag rule, ip filters, access log sampling, http2, cors, listen addresses and events valid", checkHTTPConfig(conf))
+
+	switch conf.Store.Type {
+	case "", StoreMySQL:
+		checkDatabases(ctx, conf, &report)
+	case StoreFile:
+		report.add("store", "file "+conf.Store.File.Path, checkFileStoreConfig(conf))
+	default:
+		report.add("store", "", fmt.Errorf("unknown store type %q", conf.Store.Type))
+	}
+	return
+}
+
+// checkSecrets 读取配置中引用的证书和密钥文件: MySQL 客户端证书、服务端证书和校验客户端证书的 CA
+func checkSecrets(conf *Config) (err error) {
+	if _, err = checkMySQL(conf.MySQL); err != nil {
+		return fmt.Errorf("mysql tls: %w", err)
+	}
+	if !conf.TLS.Enable {
+		return nil
+	}
+	if _, ok := tlsVersions[conf.TLS.MinVersion]; !ok {
+		return errors.New("unsupported tls min_version: " + conf.TLS.MinVersion)
+	}
+	if !conf.TLS.ACME.Enable {
+		if _, err = tls.LoadX509KeyPair(conf.TLS.CertFile, conf.TLS.KeyFile); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	} else if len(conf.TLS.ACME.Domains) == 0 {
+		return errors.New("tls acme requires at least one domain")
+	}
+	if err = setClientAuth(&tls.Config{}, conf.TLS.ClientAuth); err != nil {
+		return fmt.Errorf("tls client_auth: %w", err)
+	}
+	return nil
+}
+
+// checkAllocConfig 执行分配器初始化时的配置检查
+func checkAllocConfig(conf *Config) (err error) {
+	for _, check := range []func(*Config) error{checkPartition, checkLayout, checkCapacity, checkRegistry} {
+		if err = check(conf); err != nil {
+			return
+		}
+	}
+	if _, err = checkPrefetch(conf); err != nil {
+		return
+	}
+	_, err = newResetPolicies(conf)
+	return
+}
+
+// checkHTTPConfig 执行启动服务器时的配置检查
+func checkHTTPConfig(conf *Config) (err error) {
+	if _, err = newBizTagRule(conf.BizTag); err != nil {
+		return fmt.Errorf("biz_tag.pattern: %w", err)
+	}
+	if _, err = newIPFilter(conf.IPFilter.Alloc); err != nil {
+		return fmt.Errorf("ip_filter.alloc: %w", err)
+	}
+	if _, err = newIPFilter(conf.IPFilter.Admin); err != nil {
+		return fmt.Errorf("ip_filter.admin: %w", err)
+	}
+	if err = checkAccessLog(conf.AccessLog); err != nil {
+		return
+	}
+	if err = checkHTTP2(conf.HTTP2); err != nil {
+		return
+	}
+	if err = checkCORS(conf.CORS); err != nil {
+		return
+	}
+	if err = checkListen(conf); err != nil {
+		return
+	}
+	if err = checkEvents(conf.Events); err != nil {
+		return
+	}
+	return nil
+}
+
+// checkFileStoreConfig 检查本地文件号段存储的配置, 号段文件存在时检查能否读取
+func checkFileStoreConfig(conf *Config) (err error) {
+	if err = checkWithoutMySQL(conf); err != nil {
+		return
+	}
+	if _, err = os.ReadFile(conf.Store.File.Path); errors.Is(err, os.ErrNotExist) {
+		err = nil // 首次启动时从空状态开始
+	}
+	return
+}
+
+// checkDatabases 连接每个数据库和只读副本, 检查号段表结构; 每个数据库一个检查项
+func checkDatabases(ctx context.Context, conf *Config, report *CheckReport) {
+	var (
+		tlsConfig *tls.Config
+		err       error
+	)
+
+	if tlsConfig, err = checkMySQL(conf.MySQL); err == nil {
+		if err = checkSchema(conf); err == nil {
+			if err = checkSharding(conf); err == nil {
+				_, err = txStrategies(conf.Transaction, dsnList(conf))
+			}
+		}
+	}
+	report.add("store", "mysql schema="+cmp.Or(conf.Schema, SchemaSegments)+" sharding="+conf.Sharding.Type, err)
+	if err != nil {
+		return
+	}
+
+	tables := conf.Sharding.tables(conf.Table)
+	for i, dsn := range dsnList(conf) {
+		report.add(fmt.Sprintf("database[%d]", i), dsnAddr(dsn)+" "+strings.Join(tables, ","),
+			checkDatabase(ctx, conf, dsn, tlsConfig, tables))
+	}
+	if conf.ReplicaDSN != "" {
+		report.add("replica", dsnAddr(conf.ReplicaDSN), checkDatabase(ctx, conf, conf.ReplicaDSN, tlsConfig, tables))
+	}
+}
+
+// checkDatabase 连接数据库, 确认号段表存在并且有当前配置需要的列
+func checkDatabase(ctx context.Context, conf *Config, dsn string, tlsConfig *tls.Config, tables []string) (err error) {
+	var (
+		db      *sql.DB
+		rows    *sql.Rows
+		columns = []string{"biz_tag", "max_id", "step", "description", "update_time"}
+	)
+
+	if conf.Archive.Enable {
+		columns = append(columns, "archived")
+	}
+	if len(conf.Reset.Tags) != 0 {
+		columns = append(columns, "reset_at")
+	}
+
+	if db, err = openMySQL(dsn, conf.MySQL, tlsConfig); err != nil {
+		return
+	}
+	defer db.Close()
+
+	if err = db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	for _, table := range tables {
+		// 不读取任何行, 只检查表和列是否存在
+		if rows, err = db.QueryContext(ctx, "SELECT "+strings.Join(columns, ", ")+" FROM "+table+" LIMIT 0"); err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		rows.Close()
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	setupTestConfig(t)
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "allocate.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	report := CheckConfig(context.Background(), write(`{"store": {"type": "file", "file": {"path": "`+filepath.Join(dir, "segments.json")+`"}}}`))
+	if !report.OK() || !strings.HasSuffix(report.String(), "config ok\n") {
+		t.Fatalf("file store config failed:\n%s", report)
+	}
+
+	// 每个检查项独立报告, 一项失败不影响其他检查项
+	report = CheckConfig(context.Background(), write(`{"store": {"type": "file"}, "log": {"level": "loud"}, "archive": {"enable": true},
+		"tls": {"enable": true, "cert_file": "`+filepath.Join(dir, "missing.pem")+`"}}`))
+	failed := map[string]bool{}
+	for _, result := range report.Results {
+		failed[result.Name] = !result.OK
+	}
+	if report.OK() || !failed["log"] || !failed["secrets"] || !failed["store"] || failed["allocator"] || failed["http"] {
+		t.Fatalf("results = %+v, want log, secrets and store to fail", report.Results)
+	}
+
+	if report = CheckConfig(context.Background(), filepath.Join(dir, "missing.json")); report.OK() || len(report.Results) != 1 {
+		t.Fatalf("missing config results = %+v, want a single failed config check", report.Results)
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"time"
+)
+
+// Clock 时钟抽象, 分配器中的时间都从这里获取, 测试中可替换为手动推进的时钟
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+
+	// NewTimer 创建一个 d 之后触发的定时器
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer 定时器抽象, 与 time.Timer 对应
+type Timer interface {
+	// C 返回定时器触发时写入的通道
+	C() <-chan time.Time
+
+	// Stop 停止定时器, 定时器已触发或已停止时返回 false
+	Stop() bool
+}
+
+// realClock 基于系统时间的时钟
+type realClock struct{}
+
+// realTimer 包装 time.Timer
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+func (timer realTimer) C() <-chan time.Time {
+	return timer.timer.C
+}
+
+func (timer realTimer) Stop() bool {
+	return timer.timer.Stop()
+}
+
+// now 返回分配器时钟的当前时间, 未设置时钟时使用系统时间
+func (alloc *Alloc) now() time.Time {
+	if alloc.clock == nil {
+		return time.Now()
+	}
+	return alloc.clock.Now()
+}
+
+// since 返回分配器时钟上自 t 以来经过的时间
+func (alloc *Alloc) since(t time.Time) time.Duration {
+	return alloc.now().Sub(t)
+}
+
+// newTimer 使用分配器时钟创建定时器
+func (alloc *Alloc) newTimer(d time.Duration) Timer {
+	if alloc.clock == nil {
+		return realClock{}.NewTimer(d)
+	}
+	return alloc.clock.NewTimer(d)
+}
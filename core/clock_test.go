@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 手动推进的时钟, 推进时触发到期的定时器
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer fakeClock 创建的定时器
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) NewTimer(d time.Duration) Timer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	timer := &fakeTimer{clock: clock, deadline: clock.now.Add(d), c: make(chan time.Time, 1)}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// pending 返回尚未触发也未停止的定时器个数
+func (clock *fakeClock) pending() int {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return len(clock.timers)
+}
+
+// advance 推进时钟, 触发到期的定时器
+func (clock *fakeClock) advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = clock.now.Add(d)
+	timers := clock.timers[:0]
+	for _, timer := range clock.timers {
+		if timer.deadline.After(clock.now) {
+			timers = append(timers, timer)
+		} else {
+			timer.c <- clock.now
+		}
+	}
+	clock.timers = timers
+}
+
+func (timer *fakeTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *fakeTimer) Stop() bool {
+	timer.clock.mutex.Lock()
+	defer timer.clock.mutex.Unlock()
+
+	for i, t := range timer.clock.timers {
+		if t == timer {
+			timer.clock.timers = append(timer.clock.timers[:i], timer.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestNextIdWaitClockTimeout(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	storage.gate = make(chan struct{})
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc := newTestAlloc(t, storage)
+	alloc.clock = clock
+	bizAlloc := alloc.loadOrCreate("test")
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := bizAlloc.nextId(context.Background())
+		errChan <- err
+	}()
+
+	// 等待者登记了2秒的定时器, 推进不足2秒不会超时
+	waitFor(t, "wait timer", func() bool { return clock.pending() == 1 })
+	clock.advance(2*time.Second - time.Millisecond)
+	select {
+	case err := <-errChan:
+		t.Fatalf("nextId returned %v before the wait timed out", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.advance(time.Millisecond)
+	if err := <-errChan; err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("nextId err = %v, want no available id", err)
+	}
+	if pending := clock.pending(); pending != 0 {
+		t.Fatalf("%d timers still pending", pending)
+	}
+}
+
+func TestNextIdClockComposition(t *testing.T) {
+	setupTestConfig(t)
+	now := time.UnixMilli(1700000000123)
+	storage := newFakeStorage(10)
+	storage.setErr(errors.New("db down"))
+	alloc := newTestAlloc(t, storage)
+	alloc.clock = newFakeClock(now)
+
+	// 失败时间取自分配器时钟
+	if _, err := alloc.NextId(context.Background(), "test"); err == nil {
+		t.Fatal("NextId succeeded with failing storage")
+	}
+	if status := alloc.RefillStatus("test"); status == nil || !status.LastErrorTime.Equal(now) {
+		t.Fatalf("RefillStatus = %+v, want last error at %v", status, now)
+	}
+
+	// 返回的ID为号码加上分配器时钟的毫秒时间戳
+	storage.setErr(nil)
+	for want := int64(0); want < 3; want++ {
+		id, err := alloc.NextId(context.Background(), "test")
+		if err != nil || id != want+now.UnixMilli() {
+			t.Fatalf("NextId = (%d, %v), want (%d, nil)", id, err, want+now.UnixMilli())
+		}
+	}
+}
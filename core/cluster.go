@@ -0,0 +1,311 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClusterConfig 定义实例之间交换号段容量状态的配置
+// 每个实例按间隔检查本地各业务的 max_id 是否接近上限(位布局和分区决定的最大值), 并从配置的对等实例管理端口拉取它们的状态,
+// 任一实例发现接近耗尽时, 所有实例的 /admin/cluster 和指标中都能看到, 不必各自等到号段表触顶才发现
+type ClusterConfig struct {
+	Enable    bool     `json:"enable"`    // 是否启用
+	Peers     []string `json:"peers"`     // 对等实例的管理端口地址, 如 http://10.0.0.2:8081, 为空时只检查本地
+	Interval  int      `json:"interval"`  // 检查和拉取的间隔（毫秒）
+	Timeout   int      `json:"timeout"`   // 拉取单个对等实例的超时时间（毫秒）
+	Threshold float64  `json:"threshold"` // max_id 占上限的比例超过该值时视为接近耗尽, 0~1
+	APIKey    string   `json:"api_key"`   // 对等实例管理端口启用认证时携带的 API key, 放在 auth.header 指定的请求头中
+}
+
+// ClusterTag 一个业务在某个实例上的号段容量
+type ClusterTag struct {
+	BizTag    string  `json:"biz_tag"`   // 业务标识
+	MaxId     int64   `json:"max_id"`    // 最近一次从号段存储获取到的 max_id
+	Capacity  int64   `json:"capacity"`  // max_id 的上限, 超过后对外的 ID 溢出
+	Usage     float64 `json:"usage"`     // max_id 占上限的比例
+	Exhausted bool    `json:"exhausted"` // 是否接近耗尽
+}
+
+// ClusterMember 一个实例的状态
+type ClusterMember struct {
+	Instance  string       `json:"instance"`        // 实例标识
+	Addr      string       `json:"addr,omitempty"`  // 管理端口地址, 本实例为空
+	Up        bool         `json:"up"`              // 最近一次拉取是否成功
+	Error     string       `json:"error,omitempty"` // 最近一次拉取失败的原因
+	CheckedAt time.Time    `json:"checked_at"`      // 最近一次检查或拉取的时间
+	Tags      []ClusterTag `json:"tags"`            // 各业务的号段容量, 按业务标识排序
+}
+
+// ClusterResponse 用于封装集群状态查询请求的响应
+type ClusterResponse struct {
+	ErrNo     int             `json:"err_no"`    // 错误码
+	Msg       string          `json:"msg"`       // 错误或成功消息
+	Members   []ClusterMember `json:"members"`   // 本实例和各对等实例, 本实例在第一个
+	Exhausted []string        `json:"exhausted"` // 在任一实例上接近耗尽的业务, 按业务标识排序
+}
+
+// clusterView 定期检查本地并拉取对等实例的状态
+type clusterView struct {
+	conf     ClusterConfig
+	instance string          // 本实例标识
+	alloc    *Alloc          // 本地分配器
+	header   string          // 携带 API key 的请求头
+	client   *http.Client    // 拉取使用的 HTTP 客户端
+	mutex    sync.Mutex      // 保护以下字段
+	local    ClusterMember   // 最近一次检查的本地状态
+	peers    []ClusterMember // 与 conf.Peers 一一对应的对等实例状态
+	warned   map[string]bool // 已经告警过的业务, 恢复后清除
+	stopChan chan struct{}   // 停止检查
+	wait     sync.WaitGroup  // 等待检查线程退出
+}
+
+// cluster 全局集群状态, 未启用时为 nil
+var cluster *clusterView
+
+// newClusterView 按配置创建集群状态并立即检查一次, 未启用时返回 nil
+func newClusterView(conf *Config, alloc *Alloc) *clusterView {
+	if !conf.Cluster.Enable {
+		return nil
+	}
+
+	view := &clusterView{
+		conf:     conf.Cluster,
+		instance: instanceName(conf),
+		alloc:    alloc,
+		header:   conf.Auth.Header,
+		client:   &http.Client{Timeout: time.Duration(conf.Cluster.Timeout) * time.Millisecond},
+		peers:    make([]ClusterMember, len(conf.Cluster.Peers)),
+		warned:   map[string]bool{},
+		stopChan: make(chan struct{}),
+	}
+	for i, addr := range conf.Cluster.Peers {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		view.peers[i].Addr = strings.TrimRight(addr, "/")
+	}
+	view.refresh()
+
+	view.wait.Add(1)
+	go view.loop()
+	return view
+}
+
+// instanceName 返回实例标识, 与号段台账一致, 未配置时使用 主机名:HTTP端口
+func instanceName(conf *Config) string {
+	if conf.Ledger.Instance != "" {
+		return conf.Ledger.Instance
+	}
+	hostname, _ := os.Hostname()
+	return hostname + ":" + strconv.Itoa(conf.HttpPort)
+}
+
+// loop 按间隔检查, 直到停止
+func (view *clusterView) loop() {
+	defer view.wait.Done()
+
+	interval := time.Duration(view.conf.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			view.refresh()
+		case <-view.stopChan:
+			return
+		}
+	}
+}
+
+// stop 停止检查
+func (view *clusterView) stop() {
+	close(view.stopChan)
+	view.wait.Wait()
+}
+
+// refresh 检查本地并并发拉取所有对等实例, 然后对新出现的接近耗尽的业务告警
+func (view *clusterView) refresh() {
+	local := view.localMember()
+
+	var wait sync.WaitGroup
+	peers := make([]ClusterMember, len(view.peers))
+	for i := range peers {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			peers[i] = view.fetch(view.peers[i].Addr)
+		}(i)
+	}
+	wait.Wait()
+
+	view.mutex.Lock()
+	view.local, view.peers = local, peers
+	view.mutex.Unlock()
+
+	view.warn()
+}
+
+// localMember 返回本实例各业务的号段容量
+func (view *clusterView) localMember() ClusterMember {
+	member := ClusterMember{Instance: view.instance, Up: true, CheckedAt: view.alloc.now(), Tags: []ClusterTag{}}
+	capacity := view.alloc.maxIdCapacity()
+
+	view.alloc.bizMap.Range(func(_, value any) bool {
+		bizAlloc := value.(*BizAlloc)
+		if maxId := atomic.LoadInt64(&bizAlloc.maxId); maxId > 0 {
+			usage := float64(maxId) / float64(capacity)
+			member.Tags = append(member.Tags, ClusterTag{
+				BizTag:    bizAlloc.bizTag,
+				MaxId:     maxId,
+				Capacity:  capacity,
+				Usage:     usage,
+				Exhausted: view.conf.Threshold > 0 && usage >= view.conf.Threshold,
+			})
+		}
+		return true
+	})
+	sort.Slice(member.Tags, func(i, j int) bool { return member.Tags[i].BizTag < member.Tags[j].BizTag })
+	return member
+}
+
+// fetch 从对等实例的管理端口拉取它的本地状态
+func (view *clusterView) fetch(addr string) (member ClusterMember) {
+	var (
+		req  *http.Request
+		rsp  *http.Response
+		body []byte
+		err  error
+	)
+
+	member = ClusterMember{Addr: addr, CheckedAt: view.alloc.now()}
+	defer func() {
+		if err != nil {
+			member.Up, member.Error = false, err.Error()
+		}
+	}()
+
+	if req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, addr+"/admin/cluster/local", nil); err != nil {
+		return
+	}
+	if view.conf.APIKey != "" && view.header != "" {
+		req.Header.Set(view.header, view.conf.APIKey)
+	}
+	if rsp, err = view.client.Do(req); err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if body, err = io.ReadAll(io.LimitReader(rsp.Body, 1<<20)); err != nil {
+		return
+	}
+	if rsp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %d: %s", rsp.StatusCode, strings.TrimSpace(string(body)))
+		return
+	}
+	if err = json.Unmarshal(body, &member); err != nil {
+		return
+	}
+	member.Addr, member.Up, member.Error = addr, true, ""
+	return
+}
+
+// snapshot 返回最近一次检查的集群状态
+func (view *clusterView) snapshot() (members []ClusterMember, exhausted []string) {
+	view.mutex.Lock()
+	members = append([]ClusterMember{view.local}, view.peers...)
+	view.mutex.Unlock()
+
+	exhausted = []string{}
+	seen := map[string]bool{}
+	for _, member := range members {
+		for _, tag := range member.Tags {
+			if tag.Exhausted && !seen[tag.BizTag] {
+				seen[tag.BizTag] = true
+				exhausted = append(exhausted, tag.BizTag)
+			}
+		}
+	}
+	sort.Strings(exhausted)
+	return
+}
+
+// warn 对新出现的接近耗尽的业务记录一次告警日志, 恢复后可以再次告警
+func (view *clusterView) warn() {
+	members, exhausted := view.snapshot()
+
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+
+	current := map[string]bool{}
+	for _, bizTag := range exhausted {
+		current[bizTag] = true
+		if view.warned[bizTag] {
+			continue
+		}
+		for _, member := range members {
+			for _, tag := range member.Tags {
+				if tag.BizTag == bizTag && tag.Exhausted {
+					logger.Warn("biz_tag nearly exhausted", "biz_tag", bizTag, "instance", member.Instance,
+						"max_id", tag.MaxId, "capacity", tag.Capacity, "usage", tag.Usage)
+				}
+			}
+		}
+	}
+	view.warned = current
+}
+
+// maxIdCapacity 返回号段表中 max_id 的上限: 按分区映射并按位布局组合后仍不溢出的最大号码
+func (alloc *Alloc) maxIdCapacity() int64 {
+	capacity := int64(math.MaxInt64)
+	if alloc.conf.Layout.Enable {
+		capacity = 1 << (63 - alloc.conf.Layout.DatacenterBits)
+	}
+	if partition := alloc.conf.Partition; partition.Enable {
+		capacity = (capacity - int64(partition.Instance)) / int64(partition.Count)
+	}
+	return capacity
+}
+
+// handleAdminCluster 查询集群中各实例的号段容量和接近耗尽的业务
+func handleAdminCluster(w http.ResponseWriter, r *http.Request) {
+	resp := ClusterResponse{Msg: "success", Members: []ClusterMember{}, Exhausted: []string{}}
+	if cluster == nil {
+		resp.ErrNo, resp.Msg = ErrNoFailed, "cluster is not enabled"
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		resp.Members, resp.Exhausted = cluster.snapshot()
+	}
+
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
+
+// handleAdminClusterLocal 返回本实例的号段容量, 供对等实例拉取; 每次请求时重新检查
+func handleAdminClusterLocal(w http.ResponseWriter, r *http.Request) {
+	if cluster == nil {
+		writeError(w, http.StatusNotFound, ErrNoFailed, "cluster is not enabled")
+		return
+	}
+
+	// 将响应数据编码为 JSON 并写入响应
+	member := cluster.localMember()
+	if bytes, err := json.Marshal(&member); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// checkCluster 检查多实例校验的结果: 全局唯一, 号段不重叠不跳号, 且每个实例至少分到公平份额的一半
+func checkCluster(t *testing.T, result VerifyResult, total int) {
+	t.Helper()
+
+	t.Logf("allocated %d across %d segments in %v, per instance %v",
+		result.Allocated, result.Segments, result.Elapsed, result.Instances)
+	if result.Allocated != total || result.Failed != 0 {
+		t.Fatalf("allocated %d, failed %d, want %d allocated", result.Allocated, result.Failed, total)
+	}
+	if !result.OK() {
+		t.Fatalf("found %d duplicate, %d overlapping and %d skipped ids across %d segments",
+			result.Duplicates, result.Overlaps, result.Gaps, result.Segments)
+	}
+
+	// 各实例从同一份负载中争抢请求, 没有实例因为争抢数据库行锁而等不到号段、分不到请求
+	fair := total / len(result.Instances)
+	for i, allocated := range result.Instances {
+		if allocated < fair/2 {
+			t.Fatalf("instance %d allocated %d ids, want at least %d of fair share %d", i, allocated, fair/2, fair)
+		}
+	}
+}
+
+// TestCluster 多个实例共享同一个号段存储, 存储的互斥锁相当于数据库的行锁
+func TestCluster(t *testing.T) {
+	setupTestConfig(t)
+
+	cases := []struct {
+		name      string
+		instances int
+		step      int64
+		latency   time.Duration
+		workers   int
+		total     int
+	}{
+		{name: "rollover", instances: 4, step: 50, workers: 50, total: 200000},
+		{name: "slow storage", instances: 8, step: 200, latency: time.Millisecond, workers: 25, total: 100000},
+	}
+	if testing.Short() {
+		for i := range cases {
+			cases[i].total /= 10
+		}
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			storage := newFakeStorage(c.step)
+			storage.latency = c.latency
+			storages := make([]Storage, c.instances)
+			for i := range storages {
+				storages[i] = storage
+			}
+
+			result, err := verifyCluster(context.Background(), storages, "cluster", c.workers, c.total)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCluster(t, result, c.total)
+		})
+	}
+}
+
+func TestClusterDetectsOverlap(t *testing.T) {
+	setupTestConfig(t)
+
+	// 两个实例各自的存储都从0开始, 相当于没有共享数据库, 号段完全重叠
+	result, err := verifyCluster(context.Background(), []Storage{newFakeStorage(10), newFakeStorage(10)}, "overlap", 1, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Overlaps == 0 || result.Duplicates == 0 || result.OK() {
+		t.Fatalf("result %+v, want overlapping segments and duplicate ids", result)
+	}
+}
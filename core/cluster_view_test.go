@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClusterView(t *testing.T) {
+	setupTestConfig(t)
+
+	// 位布局只留 7 位序号, 获取一个号段后就接近上限
+	conf := &Config{
+		Auth:    AuthConfig{Header: "X-API-Key"},
+		Layout:  LayoutConfig{Enable: true, DatacenterBits: 56},
+		Cluster: ClusterConfig{Enable: true, Interval: int(time.Hour / time.Millisecond), Timeout: 1000, Threshold: 0.5, APIKey: "secret"},
+	}
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	alloc.conf = conf
+	if _, err := alloc.NextId(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 对等实例报告另一个业务接近耗尽
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/cluster/local" || r.Header.Get("X-API-Key") != "secret" {
+			writeError(w, http.StatusForbidden, ErrNoForbidden, "forbidden")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ClusterMember{Instance: "peer", Up: true, Tags: []ClusterTag{
+			{BizTag: "hot", MaxId: 95, Capacity: 100, Usage: 0.95, Exhausted: true},
+		}})
+	}))
+	defer peer.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	conf.Cluster.Peers = []string{peer.URL, down.URL}
+	cluster = newClusterView(conf, alloc)
+	t.Cleanup(func() { cluster.stop(); cluster = nil })
+
+	w := httptest.NewRecorder()
+	handleAdminCluster(w, httptest.NewRequest(http.MethodGet, "/admin/cluster", nil))
+	var resp ClusterResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(resp.Exhausted, []string{"hot", "test"}) {
+		t.Fatalf("exhausted = %v, want [hot test]", resp.Exhausted)
+	}
+	if len(resp.Members) != 3 || !resp.Members[0].Up || !resp.Members[1].Up || resp.Members[1].Instance != "peer" || resp.Members[2].Up {
+		t.Fatalf("members = %+v", resp.Members)
+	}
+	if tags := resp.Members[0].Tags; len(tags) != 1 || tags[0].Capacity != 128 || !tags[0].Exhausted {
+		t.Fatalf("local tags = %+v, want test near capacity 128", tags)
+	}
+
+	// 本地状态供对等实例拉取
+	w = httptest.NewRecorder()
+	handleAdminClusterLocal(w, httptest.NewRequest(http.MethodGet, "/admin/cluster/local", nil))
+	var member ClusterMember
+	if err := json.Unmarshal(w.Body.Bytes(), &member); err != nil || len(member.Tags) != 1 || member.Tags[0].BizTag != "test" {
+		t.Fatalf("local member = %+v, %v", member, err)
+	}
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionConfig 定义响应压缩的配置, 只支持 gzip
+type CompressionConfig struct {
+	Enable  bool `json:"enable"`   // 是否按 Accept-Encoding 压缩响应
+	MinSize int  `json:"min_size"` // 响应达到该字节数才压缩, 单个 ID 这类小响应压缩得不偿失
+	Level   int  `json:"level"`    // gzip 压缩级别, 1~9, 0 或无效值表示默认级别
+}
+
+// gzipWriters 复用 gzip 压缩器, 每个压缩器占用数百 KB 内存
+var gzipWriters sync.Pool
+
+// acceptsGzip 判断客户端是否接受 gzip 编码
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if name = strings.TrimSpace(name); name != "gzip" && name != "*" {
+			continue
+		}
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+				return false // 客户端显式拒绝
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter 先缓存响应, 达到最小长度后切换为 gzip 输出, 响应结束时仍不足则原样输出
+type gzipWriter struct {
+	http.ResponseWriter
+	conf   CompressionConfig
+	buf    []byte       // 尚未决定是否压缩时缓存的响应
+	status int          // 尚未写出的状态码
+	gz     *gzip.Writer // 已决定压缩时的压缩器
+	plain  bool         // 已决定不压缩
+}
+
+// WriteHeader 暂存状态码, 等决定是否压缩后再写出
+func (gw *gzipWriter) WriteHeader(status int) {
+	if gw.status == 0 && gw.gz == nil && !gw.plain {
+		gw.status = status
+		return
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+// Write 按是否压缩写出响应
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	switch {
+	case gw.gz != nil:
+		return gw.gz.Write(b)
+	case gw.plain:
+		return gw.ResponseWriter.Write(b)
+	}
+
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) >= gw.conf.MinSize {
+		if err := gw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide 决定是否压缩, 写出状态码和已缓存的响应
+func (gw *gzipWriter) decide(compress bool) (err error) {
+	header := gw.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Type") == "application/octet-stream" {
+		compress = false // 已编码或二进制内容(如 pprof 剖析)不再压缩
+	}
+	if gw.status == http.StatusNoContent || gw.status == http.StatusNotModified {
+		compress = false
+	}
+
+	if compress {
+		if header.Get("Content-Type") == "" { // 压缩后无法再按内容探测类型
+			header.Set("Content-Type", http.DetectContentType(gw.buf))
+		}
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		if gz, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			gz.Reset(gw.ResponseWriter)
+			gw.gz = gz
+		} else {
+			gw.gz, _ = gzip.NewWriterLevel(gw.ResponseWriter, gw.conf.Level) // 级别已在 withGzip 中校验
+		}
+	} else {
+		gw.plain = true
+	}
+
+	if gw.status != 0 {
+		gw.ResponseWriter.WriteHeader(gw.status)
+	}
+	buf := gw.buf
+	gw.buf = nil
+	if len(buf) == 0 {
+		return
+	}
+	if gw.gz != nil {
+		_, err = gw.gz.Write(buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(buf)
+	}
+	return
+}
+
+// Flush 先决定是否压缩, 再将已压缩的数据刷出, 支持流式输出
+func (gw *gzipWriter) Flush() {
+	if gw.gz == nil && !gw.plain {
+		_ = gw.decide(len(gw.buf) >= gw.conf.MinSize)
+	}
+	if gw.gz != nil {
+		_ = gw.gz.Flush()
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 返回底层 ResponseWriter, 供 http.ResponseController 设置超时
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// close 结束响应, 不足最小长度的响应原样输出, 压缩器归还到池中
+func (gw *gzipWriter) close() {
+	if gw.gz == nil && !gw.plain {
+		_ = gw.decide(false)
+	}
+	if gw.gz != nil {
+		_ = gw.gz.Close()
+		gzipWriters.Put(gw.gz)
+		gw.gz = nil
+	}
+}
+
+// withGzip 按 Accept-Encoding 对响应进行 gzip 压缩
+func withGzip(handler http.Handler) http.Handler {
+	conf := DefaultConfig.Compression
+	if !conf.Enable {
+		return handler
+	}
+	if conf.Level < gzip.HuffmanOnly || conf.Level > gzip.BestCompression || conf.Level == 0 {
+		conf.Level = gzip.DefaultCompression
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w, conf: conf}
+		defer gw.close()
+		handler.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyConfig 定义全局并发限制的配置
+type ConcurrencyConfig struct {
+	MaxInflight  int `json:"max_inflight"`  // 同时处理的 /alloc 请求数上限, 0 表示不限制
+	MaxQueue     int `json:"max_queue"`     // 超过上限后允许排队的请求数, 队列满时直接拒绝
+	QueueTimeout int `json:"queue_timeout"` // 排队的最长时间（毫秒）, 超时后拒绝
+	MaxFetches   int `json:"max_fetches"`   // 同时向数据库获取号段的补偿线程数上限, 0 表示不限制
+}
+
+// inflightLimiter 限制同时处理的请求数, 超出的请求排队等待, 队列满或等待超时时拒绝
+type inflightLimiter struct {
+	slots    chan struct{} // 处理槽位
+	timeout  time.Duration // 排队的最长时间
+	maxQueue int64         // 队列长度上限
+	queued   int64         // 正在排队的请求数, 原子读写
+	shed     int64         // 被拒绝的请求数, 原子读写
+}
+
+// inflight 全局请求并发限制, 未启用时为 nil
+var inflight *inflightLimiter
+
+// newInflightLimiter 创建请求并发限制
+func newInflightLimiter(conf ConcurrencyConfig) *inflightLimiter {
+	return &inflightLimiter{
+		slots:    make(chan struct{}, conf.MaxInflight),
+		timeout:  time.Duration(conf.QueueTimeout) * time.Millisecond,
+		maxQueue: int64(conf.MaxQueue),
+	}
+}
+
+// acquire 获取处理槽位, 返回false表示请求被拒绝
+func (limiter *inflightLimiter) acquire(ctx context.Context) bool {
+	// 有空闲槽位, 立即处理
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	// 队列已满, 直接拒绝
+	if atomic.AddInt64(&limiter.queued, 1) > limiter.maxQueue {
+		atomic.AddInt64(&limiter.queued, -1)
+		atomic.AddInt64(&limiter.shed, 1)
+		return false
+	}
+	defer atomic.AddInt64(&limiter.queued, -1)
+
+	timer := time.NewTimer(limiter.timeout)
+	defer timer.Stop()
+
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	atomic.AddInt64(&limiter.shed, 1)
+	return false
+}
+
+// release 归还处理槽位
+func (limiter *inflightLimiter) release() {
+	<-limiter.slots
+}
+
+// withInflightLimit 限制同时处理的请求数, 过载时返回 HTTP 503
+func withInflightLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !inflight.acquire(r.Context()) {
+			statsd.Incr("shed")
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, ErrNoOverloaded, "server overloaded")
+			return
+		}
+		defer inflight.release()
+
+		handler(w, r)
+	}
+}
+
+// acquireFetch 获取向数据库获取号段的许可, 未限制时立即返回
+func (alloc *Alloc) acquireFetch(ctx context.Context) error {
+	if alloc.fetchSlots == nil {
+		return nil
+	}
+	select {
+	case alloc.fetchSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseFetch 归还向数据库获取号段的许可
+func (alloc *Alloc) releaseFetch() {
+	if alloc.fetchSlots != nil {
+		<-alloc.fetchSlots
+	}
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestInflight 创建全局请求并发限制, 测试结束时恢复
+func newTestInflight(tb testing.TB, conf ConcurrencyConfig) *inflightLimiter {
+	tb.Helper()
+
+	inflight = newInflightLimiter(conf)
+	tb.Cleanup(func() { inflight = nil })
+	return inflight
+}
+
+// serveInflight 在后台处理一个请求, 返回的通道在处理结束后收到响应
+func serveInflight(handler http.HandlerFunc, query string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/alloc?"+query, nil))
+		done <- w
+	}()
+	return done
+}
+
+func TestInflightLimit(t *testing.T) {
+	setupTestConfig(t)
+	limiter := newTestInflight(t, ConcurrencyConfig{MaxInflight: 1})
+	gate := make(chan struct{})
+	handler := withInflightLimit(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			<-gate
+		}
+	})
+
+	// 第一个请求占用唯一的槽位
+	blocked := serveInflight(handler, "biz_tag=test&block=1")
+	waitFor(t, "slot taken", func() bool { return len(limiter.slots) == 1 })
+
+	// 不允许排队时立即拒绝, 返回 HTTP 503 和 Retry-After
+	w := <-serveInflight(handler, "biz_tag=test")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), `"err_no":-6`) {
+		t.Fatalf("overloaded request = %d Retry-After %q %s, want 503", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	var b strings.Builder
+	writeConcurrencyMetrics(&b)
+	if !strings.Contains(b.String(), "leaf_inflight_requests 1\n") || !strings.Contains(b.String(), "leaf_shed_total 1\n") {
+		t.Fatalf("metrics = %s, want 1 inflight and 1 shed", b.String())
+	}
+
+	// 请求处理结束后归还槽位, 之后的请求正常处理
+	close(gate)
+	if w := <-blocked; w.Code != http.StatusOK {
+		t.Fatalf("blocked request = %d, want 200", w.Code)
+	}
+	if len(limiter.slots) != 0 {
+		t.Fatalf("%d slots still taken after completion", len(limiter.slots))
+	}
+	if w := <-serveInflight(handler, "biz_tag=test"); w.Code != http.StatusOK {
+		t.Fatalf("request after release = %d, want 200", w.Code)
+	}
+}
+
+func TestInflightQueue(t *testing.T) {
+	setupTestConfig(t)
+	limiter := newTestInflight(t, ConcurrencyConfig{MaxInflight: 1, MaxQueue: 1, QueueTimeout: 5000})
+	var (
+		gate    = make(chan struct{})
+		handled atomic.Int64
+	)
+	handler := withInflightLimit(func(w http.ResponseWriter, r *http.Request) {
+		handled.Add(1)
+		<-gate
+	})
+
+	// 一个请求处理中, 一个请求排队, 队列满后的请求被拒绝
+	first := serveInflight(handler, "biz_tag=test")
+	waitFor(t, "slot taken", func() bool { return handled.Load() == 1 })
+	queued := serveInflight(handler, "biz_tag=test")
+	waitFor(t, "request queued", func() bool { return atomic.LoadInt64(&limiter.queued) == 1 })
+	if w := <-serveInflight(handler, "biz_tag=test"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request beyond the queue = %d, want 503", w.Code)
+	}
+
+	// 槽位归还后排队的请求得到处理
+	gate <- struct{}{}
+	if w := <-first; w.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", w.Code)
+	}
+	waitFor(t, "queued request handled", func() bool { return handled.Load() == 2 })
+	gate <- struct{}{}
+	if w := <-queued; w.Code != http.StatusOK {
+		t.Fatalf("queued request = %d, want 200", w.Code)
+	}
+	if len(limiter.slots) != 0 || atomic.LoadInt64(&limiter.queued) != 0 || atomic.LoadInt64(&limiter.shed) != 1 {
+		t.Fatalf("slots %d, queued %d, shed %d, want 0, 0, 1", len(limiter.slots), atomic.LoadInt64(&limiter.queued), atomic.LoadInt64(&limiter.shed))
+	}
+}
+
+func TestInflightQueueTimeout(t *testing.T) {
+	setupTestConfig(t)
+	limiter := newTestInflight(t, ConcurrencyConfig{MaxInflight: 1, MaxQueue: 2, QueueTimeout: 20})
+	if !limiter.acquire(context.Background()) {
+		t.Fatal("acquire on an idle limiter failed")
+	}
+
+	// 排队超时或请求被取消时拒绝, 并离开队列
+	start := time.Now()
+	if limiter.acquire(context.Background()) {
+		t.Fatal("acquire succeeded while the slot was taken")
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("rejected after %v, want after the 20ms queue timeout", elapsed)
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+	if limiter.acquire(ctx) {
+		t.Fatal("acquire succeeded with a canceled context")
+	}
+	if atomic.LoadInt64(&limiter.queued) != 0 || atomic.LoadInt64(&limiter.shed) != 2 {
+		t.Fatalf("queued %d, shed %d, want 0 and 2", atomic.LoadInt64(&limiter.queued), atomic.LoadInt64(&limiter.shed))
+	}
+	limiter.release()
+}
+
+func TestInflightReleaseOnPanic(t *testing.T) {
+	setupTestConfig(t)
+	limiter := newTestInflight(t, ConcurrencyConfig{MaxInflight: 1})
+	handler := withInflightLimit(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	// 处理函数 panic 时同样归还槽位, 由外层的 withRecover 返回 HTTP 500
+	for i := 0; i < 3; i++ {
+		func() {
+			defer func() { _ = recover() }()
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil))
+		}()
+	}
+	if len(limiter.slots) != 0 || atomic.LoadInt64(&limiter.shed) != 0 {
+		t.Fatalf("slots %d, shed %d after panics, want 0 and 0", len(limiter.slots), atomic.LoadInt64(&limiter.shed))
+	}
+}
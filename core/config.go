@@ -7,11 +7,85 @@ import (
 
 // Config 定义配置文件的格式
 type Config struct {
-	DSN              string `json:"dsn"`                // 数据库连接字符串
-	Table            string `json:"table"`              // 数据库中用于存储段的表名
-	HttpPort         int    `json:"http_port"`          // HTTP服务器的监听端口
-	HttpReadTimeout  int    `json:"http_read_timeout"`  // HTTP读取请求的超时时间（毫秒）
-	HttpWriteTimeout int    `json:"http_write_timeout"` // HTTP写入响应的超时时间（毫秒）
+	DSN                   string            `json:"dsn"`                      // 数据库连接字符串
+	DSNs                  []string          `json:"dsns"`                     // 按优先级排列的多个数据库连接字符串, 配置后忽略 dsn
+	ReplicaDSN            string            `json:"replica_dsn"`              // 只读副本的连接字符串, 用于管理查询、配置表加载等非关键读, 为空时都使用主库
+	MySQL                 MySQLConfig       `json:"mysql"`                    // MySQL 连接选项, 应用到 dsn 和 dsns 中的每一个连接
+	Table                 string            `json:"table"`                    // 数据库中用于存储段的表名
+	Schema                string            `json:"schema"`                   // 号段表的表结构: segments 或 leaf(美团 Leaf 的 leaf_alloc 表), 为空时为 segments
+	Sharding              ShardingConfig    `json:"sharding"`                 // 号段表的分表规则, 未配置时只使用 table
+	Transaction           TransactionConfig `json:"transaction"`              // 获取号段的事务隔离级别和加锁方式
+	Capacity              CapacityConfig    `json:"capacity"`                 // 号码上限、接近上限的告警和循环策略
+	Archive               ArchiveConfig     `json:"archive"`                  // 业务归档配置
+	Reset                 ResetConfig       `json:"reset"`                    // 按周期重置序列的配置
+	Quota                 QuotaConfig       `json:"quota"`                    // 按业务的分配配额
+	Store                 StoreConfig       `json:"store"`                    // 号段存储配置, 默认使用 MySQL
+	HttpPort              int               `json:"http_port"`                // HTTP服务器的监听端口
+	HttpListen            []string          `json:"http_listen"`              // 分配端口的监听地址列表, 如 ["0.0.0.0:8880", "unix:/run/leaf/alloc.sock"], 非空时代替 http_port; 服务注册仍使用 http_port
+	HttpReadTimeout       int               `json:"http_read_timeout"`        // HTTP读取请求的超时时间（毫秒）
+	HttpWriteTimeout      int               `json:"http_write_timeout"`       // HTTP写入响应的超时时间（毫秒）
+	HttpIdleTimeout       int               `json:"http_idle_timeout"`        // keep-alive 连接的空闲超时时间（毫秒）, 0 表示使用读取超时时间
+	HttpReadHeaderTimeout int               `json:"http_read_header_timeout"` // HTTP读取请求头的超时时间（毫秒）, 0 表示使用读取超时时间
+	HttpMaxHeaderBytes    int               `json:"http_max_header_bytes"`    // 请求头的最大字节数, 0 表示默认 1MB
+	HttpDisableKeepAlive  bool              `json:"http_disable_keep_alive"`  // 是否禁用 HTTP keep-alive, 每个请求后关闭连接
+	HttpTCPKeepAlive      int               `json:"http_tcp_keep_alive"`      // TCP keep-alive 探测间隔（毫秒）, 0 表示默认 15 秒, 负数表示不启用
+	HttpListenBacklog     int               `json:"http_listen_backlog"`      // 期望的监听队列长度, 由内核 somaxconn 决定, 不足时启动时告警
+	SlowQueryThreshold    int               `json:"slow_query_threshold"`     // 号段事务的慢查询阈值（毫秒）, 0 表示不记录
+	ShutdownTimeout       int               `json:"shutdown_timeout"`         // 优雅退出的宽限期（毫秒）
+	RequestTimeout        int               `json:"request_timeout"`          // 单个请求的处理时限（毫秒）, 包含等待补偿线程的时间, 0 表示不限制
+	Wait                  WaitConfig        `json:"wait"`                     // 号段耗尽时等待补偿线程的时间配置
+	TLS                   TLSConfig         `json:"tls"`                      // HTTPS 配置
+	HTTP2                 HTTP2Config       `json:"http2"`                    // HTTP/2 和 h2c 配置
+	Fast                  FastConfig        `json:"fast"`                     // 高性能分配端口配置
+	Lease                 LeaseConfig       `json:"lease"`                    // 号段租约配置
+	Reserve               ReserveConfig     `json:"reserve"`                  // 两阶段分配配置
+	Auth                  AuthConfig        `json:"auth"`                     // 调用方认证配置
+	RateLimit             RateLimitConfig   `json:"rate_limit"`               // 按业务限流配置
+	Namespace             NamespaceConfig   `json:"namespace"`                // 多租户命名空间配置
+	UnknownTags           UnknownTagConfig  `json:"unknown_tags"`             // 不存在业务的负缓存配置
+	Idempotency           IdempotencyConfig `json:"idempotency"`              // 分配请求幂等配置
+	Concurrency           ConcurrencyConfig `json:"concurrency"`              // 全局并发限制配置
+	IPFilter              IPFilterConfig    `json:"ip_filter"`                // 来源地址访问控制配置
+	CORS                  CORSConfig        `json:"cors"`                     // 浏览器跨域访问配置
+	BizTag                BizTagConfig      `json:"biz_tag"`                  // 业务标识校验规则
+	Compression           CompressionConfig `json:"compression"`              // 响应压缩配置
+	Audit                 AuditConfig       `json:"audit"`                    // 管理操作审计日志配置
+	Ledger                LedgerConfig      `json:"ledger"`                   // 号段台账配置
+	Usage                 UsageConfig       `json:"usage"`                    // 业务使用统计配置
+	Stats                 StatsConfig       `json:"stats"`                    // /stats 滑动窗口统计配置
+	Trace                 TraceConfig       `json:"trace"`                    // 链路追踪配置
+	Log                   LogConfig         `json:"log"`                      // 日志配置
+	AccessLog             AccessLogConfig   `json:"access_log"`               // 访问日志配置
+	Admin                 AdminConfig       `json:"admin"`                    // 管理端口配置
+	Statsd                StatsdConfig      `json:"statsd"`                   // StatsD 指标上报配置
+	Breaker               BreakerConfig     `json:"breaker"`                  // 号段存储熔断器配置
+	Failover              FailoverConfig    `json:"failover"`                 // 多数据库故障切换配置
+	Degrade               DegradeConfig     `json:"degrade"`                  // 数据库不稳定时的降级预取配置
+	Watchdog              WatchdogConfig    `json:"watchdog"`                 // 补偿线程和等待队列的自检配置
+	Memory                MemoryConfig      `json:"memory"`                   // 软内存上限、GC 和等待者上限配置
+	Prefetch              PrefetchConfig    `json:"prefetch"`                 // 热点业务多号段预取配置
+	Preload               PreloadConfig     `json:"preload"`                  // 启动预热配置
+	Alert                 AlertConfig       `json:"alert"`                    // 号段告警配置
+	Events                EventsConfig      `json:"events"`                   // /events 事件流配置
+	Election              ElectionConfig    `json:"election"`                 // 主备部署的选主配置
+	Partition             PartitionConfig   `json:"partition"`                // 多实例分区配置
+	Layout                LayoutConfig      `json:"layout"`                   // 对外 ID 的位布局和按数据中心的号段存储配置
+	Peer                  PeerConfig        `json:"peer"`                     // 本地号段存储不可用时转发分配请求的配置
+	Cluster               ClusterConfig     `json:"cluster"`                  // 实例之间交换号段容量状态的配置
+	Registry              RegistryConfig    `json:"registry"`                 // 服务注册配置
+	Kubernetes            KubernetesConfig  `json:"kubernetes"`               // 以 Kubernetes 工作负载运行的配置
+	Chaos                 ChaosConfig       `json:"chaos"`                    // 号段存储故障注入配置, 仅用于非生产环境演练
+}
+
+// TraceConfig 定义OpenTelemetry链路追踪的配置
+type TraceConfig struct {
+	Enable      bool              `json:"enable"`       // 是否启用链路追踪
+	ServiceName string            `json:"service_name"` // 上报的服务名
+	Endpoint    string            `json:"endpoint"`     // OTLP/HTTP接收端地址, 如 localhost:4318
+	URLPath     string            `json:"url_path"`     // OTLP/HTTP接收路径, 默认 /v1/traces
+	Insecure    bool              `json:"insecure"`     // 是否使用明文HTTP
+	Headers     map[string]string `json:"headers"`      // 附加的请求头, 如鉴权token
+	SampleRatio float64           `json:"sample_ratio"` // 采样比例, 0~1
 }
 
 // DefaultConfig 是一个全局的配置变量，用于存储加载后的配置
@@ -25,8 +99,8 @@ func LoadConfig(filename string) error {
 		return err
 	}
 
-	// 创建Config实例用于解析JSON
-	config := Config{}
+	// 创建Config实例用于解析JSON, 并设置默认值
+	config := NewConfig()
 
 	// 将JSON内容解析到config结构体,如果解析JSON失败，返回错误
 	err = json.Unmarshal(content, &config)
@@ -40,3 +114,205 @@ func LoadConfig(filename string) error {
 	// 返回nil表示加载成功
 	return nil
 }
+
+// NewConfig 返回带默认值的配置, 以库的方式使用时在此基础上修改
+func NewConfig() Config {
+	return Config{
+		Store: StoreConfig{
+			File: FileStoreConfig{
+				Path: "segments.json",
+			},
+		},
+		MySQL: MySQLConfig{
+			MaxIdleConns: 10,
+		},
+		SlowQueryThreshold: 200,
+		ShutdownTimeout:    10000,
+		RequestTimeout:     3000,
+		Wait: WaitConfig{
+			Default: 2000,
+			Max:     3000,
+		},
+		Stats: StatsConfig{
+			Enable: true,
+		},
+		Trace: TraceConfig{
+			ServiceName: "leaf-segment",
+			SampleRatio: 1,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "console",
+			File: LogFileConfig{
+				MaxSize:    100,
+				MaxBackups: 10,
+				MaxAge:     30,
+			},
+		},
+		Auth: AuthConfig{
+			Header:         "X-API-Key",
+			ReloadInterval: 60000,
+			JWT: JWTConfig{
+				BizTagsClaim:    "biz_tags",
+				AdminClaim:      "leaf_admin",
+				NamespacesClaim: "leaf_namespaces",
+				AdminNSClaim:    "leaf_admin_namespaces",
+				Leeway:          30000,
+				RefreshInterval: 3600000,
+			},
+		},
+		RateLimit: RateLimitConfig{
+			ReloadInterval: 60000,
+		},
+		Preload: PreloadConfig{
+			Timeout:     10000,
+			Parallelism: 8,
+		},
+		UnknownTags: UnknownTagConfig{
+			TTL:        5000,
+			MaxEntries: 10000,
+		},
+		Namespace: NamespaceConfig{
+			Separator: ":",
+		},
+		Idempotency: IdempotencyConfig{
+			Header:  "Idempotency-Key",
+			Window:  3600000,
+			MaxKeys: 100000,
+			Redis: RedisConfig{
+				Prefix:   "leaf:idem:",
+				Timeout:  100,
+				PoolSize: 16,
+			},
+		},
+		HTTP2: HTTP2Config{
+			Enable: true,
+		},
+		Fast: FastConfig{
+			MaxHeaderBytes: 8192,
+		},
+		Events: EventsConfig{
+			Buffer:         256,
+			Heartbeat:      15000,
+			MaxSubscribers: 100,
+		},
+		Lease: LeaseConfig{
+			TTL: 60000,
+		},
+		Reserve: ReserveConfig{
+			TTL: 30000,
+		},
+		Kubernetes: KubernetesConfig{
+			PodNameEnv: "POD_NAME",
+			PodIPEnv:   "POD_IP",
+			DrainDelay: 5000,
+		},
+		Registry: RegistryConfig{
+			Etcd: EtcdRegistryConfig{
+				Prefix:  "/leaf-segment/",
+				TTL:     10,
+				Timeout: 3000,
+			},
+			Nacos: NacosRegistryConfig{
+				Service:  "leaf-segment",
+				Group:    "DEFAULT_GROUP",
+				Cluster:  "DEFAULT",
+				Weight:   1,
+				Interval: 5000,
+				Timeout:  3000,
+			},
+		},
+		Cluster: ClusterConfig{
+			Interval:  5000,
+			Timeout:   1000,
+			Threshold: 0.9,
+		},
+		Peer: PeerConfig{
+			Timeout: 500,
+		},
+		Layout: LayoutConfig{
+			DatacenterBits: 5,
+		},
+		Partition: PartitionConfig{
+			Count:     1,
+			Separator: "#",
+		},
+		Sharding: ShardingConfig{
+			Separator: ":",
+		},
+		Usage: UsageConfig{
+			Interval: 60000,
+		},
+		Capacity: CapacityConfig{
+			WarnRatio: 0.9,
+		},
+		Election: ElectionConfig{
+			Lock:     "leaf-segment",
+			Interval: 1000,
+		},
+		Concurrency: ConcurrencyConfig{
+			MaxQueue:     1000,
+			QueueTimeout: 500,
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "PUT", "OPTIONS"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "Idempotency-Key"},
+			MaxAge:         600,
+		},
+		BizTag: BizTagConfig{
+			Pattern:   `^[A-Za-z0-9_.:-]+$`,
+			MaxLength: 32, // 与号段表 biz_tag 字段长度一致
+		},
+		Compression: CompressionConfig{
+			MinSize: 1024,
+		},
+		AccessLog: AccessLogConfig{
+			SampleRate: 1,
+			KeepErrors: true,
+		},
+		Statsd: StatsdConfig{
+			Prefix:        "leaf.",
+			FlushInterval: 1000,
+		},
+		Failover: FailoverConfig{
+			ProbeInterval:        1000,
+			ProbeTimeout:         500,
+			FailbackAfter:        3,
+			StartupRetries:       5,
+			StartupRetryInterval: 2000,
+		},
+		Degrade: DegradeConfig{
+			StepMultiplier: 5,
+			BufferDepth:    4,
+			Window:         60000,
+		},
+		Watchdog: WatchdogConfig{
+			Interval:     5000,
+			StuckAfter:   30000,
+			WaitingAfter: 30000,
+		},
+		Memory: MemoryConfig{
+			MaxWaiting: 10000,
+		},
+		Alert: AlertConfig{
+			ContentType:         "application/json",
+			RemainingThreshold:  0.2,
+			RefillFailThreshold: 1,
+			CheckInterval:       5000,
+			Cooldown:            300000,
+			Timeout:             3000,
+			ErrorRateMinCount:   100,
+			ErrorRateSustain:    3,
+			ReloadInterval:      60000,
+			PagerDuty: PagerDutyConfig{
+				URL:      "https://events.pagerduty.com/v2/enqueue",
+				Severity: "critical",
+			},
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: 5,
+			OpenTimeout:      5000,
+			HalfOpenProbes:   1,
+		},
+	}
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule 五段式 cron 表达式: 分 时 日 月 周, 每段支持 *、数字、a-b 范围、/n 间隔和逗号分隔的列表
+// 日和周都不是 * 时, 与标准 cron 相同, 满足其中一个即触发
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64         // 各段允许的取值, 按位表示
+	domAny, dowAny                bool           // 日、周是否为 *
+	location                      *time.Location // 计算触发时间使用的时区
+}
+
+// cronFields 各段的取值范围, 周的 7 与 0 相同, 都表示周日
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron 解析 cron 表达式, timezone 为空时使用本地时区
+func parseCron(spec string, timezone string) (schedule *cronSchedule, err error) {
+	var (
+		fields = strings.Fields(spec)
+		values [5]uint64
+	)
+
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron %q needs 5 fields", spec)
+	}
+	for i, field := range fields {
+		if values[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max); err != nil {
+			return nil, fmt.Errorf("cron %q %s: %w", spec, cronFields[i].name, err)
+		}
+	}
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1 // 7 表示周日
+	}
+
+	schedule = &cronSchedule{
+		minute: values[0], hour: values[1], dom: values[2], month: values[3], dow: values[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+		location: time.Local,
+	}
+	if timezone != "" {
+		if schedule.location, err = time.LoadLocation(timezone); err != nil {
+			return nil, err
+		}
+	}
+	return schedule, nil
+}
+
+// parseCronField 解析 cron 的一段, 返回按位表示的取值
+func parseCronField(field string, first int, last int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var (
+			low, high = first, last
+			step      = 1
+			rangePart = part
+		)
+
+		if before, after, found := strings.Cut(part, "/"); found {
+			if step, err = strconv.Atoi(after); err != nil || step < 1 {
+				return 0, errors.New("invalid step " + after)
+			}
+			rangePart = before
+		}
+		switch before, after, found := strings.Cut(rangePart, "-"); {
+		case rangePart == "*":
+		case found:
+			if low, err = strconv.Atoi(before); err != nil {
+				return 0, errors.New("invalid value " + before)
+			}
+			if high, err = strconv.Atoi(after); err != nil {
+				return 0, errors.New("invalid value " + after)
+			}
+		default:
+			if low, err = strconv.Atoi(rangePart); err != nil {
+				return 0, errors.New("invalid value " + rangePart)
+			}
+			if rangePart == part { // 单个数字, 没有间隔
+				high = low
+			}
+		}
+		if low < first || high > last || low > high {
+			return 0, fmt.Errorf("%s out of range %d ~ %d", part, first, last)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// next 返回 t 之后(不含 t 所在的分钟)的第一个触发时间, 一年内没有触发时间时返回零值
+func (schedule *cronSchedule) next(t time.Time) time.Time {
+	var (
+		origin = t.Location()
+		limit  = t.AddDate(1, 0, 1)
+	)
+
+	t = t.In(schedule.location).Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		switch {
+		case schedule.month&(1<<t.Month()) == 0: // 跳到下个月的第一天
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, schedule.location)
+		case !schedule.matchDay(t): // 跳到第二天
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, schedule.location)
+		case schedule.hour&(1<<t.Hour()) == 0: // 跳到下一个小时
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, schedule.location)
+		case schedule.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t.In(origin)
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay 日期是否满足日和周两段
+func (schedule *cronSchedule) matchDay(t time.Time) bool {
+	var (
+		dom = schedule.dom&(1<<t.Day()) != 0
+		dow = schedule.dow&(1<<t.Weekday()) != 0
+	)
+
+	if schedule.domAny || schedule.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec, ""); err == nil {
+			t.Errorf("parseCron(%q) accepted", spec)
+		}
+	}
+	if _, err := parseCron("* * * * *", "Nowhere/Unknown"); err == nil {
+		t.Error("parseCron accepted an unknown timezone")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	var (
+		utc  = time.UTC
+		from = time.Date(2026, 3, 14, 10, 30, 45, 0, utc) // 周六
+	)
+
+	for _, c := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 14, 10, 31, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2026, 3, 14, 10, 45, 0, 0, utc)},
+		{"0 9-17/4 * * *", time.Date(2026, 3, 14, 13, 0, 0, 0, utc)},
+		{"30 10 * * *", time.Date(2026, 3, 15, 10, 30, 0, 0, utc)},    // 当前分钟不算
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, utc)},         // 每月第一天
+		{"0 0 * * 7", time.Date(2026, 3, 15, 0, 0, 0, 0, utc)},        // 7 表示周日
+		{"0 0 20 * 1", time.Date(2026, 3, 16, 0, 0, 0, 0, utc)},       // 日和周满足一个即可
+		{"50 23 11 11 *", time.Date(2026, 11, 11, 23, 50, 0, 0, utc)}, // 一年一次
+		{"0 0 30 2 *", time.Time{}},                                   // 不存在的日期
+	} {
+		schedule, err := parseCron(c.spec, "UTC")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.next(from); !got.Equal(c.want) {
+			t.Errorf("next(%q) = %v, want %v", c.spec, got, c.want)
+		}
+	}
+
+	// 按配置的时区计算, 上海的 0 点是 UTC 前一天的 16 点
+	schedule, err := parseCron("0 0 * * *", "Asia/Shanghai")
+	if err != nil {
+		t.Skip(err) // 没有时区数据库
+	}
+	if got := schedule.next(from); !got.Equal(time.Date(2026, 3, 14, 16, 0, 0, 0, utc)) {
+		t.Errorf("next in Asia/Shanghai = %v", got)
+	}
+}
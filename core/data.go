@@ -2,15 +2,21 @@ package core
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"errors"
-	_ "github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 /*
 	create database leaf-segment;
-	
+
+	也可以直接使用美团 Leaf 的 leaf_alloc 表, 见 schema.go
+
 	CREATE TABLE `segments` (
 	 `biz_tag` varchar(32) NOT NULL,
 	 `max_id` bigint NOT NULL,
@@ -19,100 +25,622 @@ import (
 	 `update_time` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 	 PRIMARY KEY (`biz_tag`)
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8;
-	
+
 	INSERT INTO segments(`biz_tag`, `max_id`, `step`, `description`) VALUES('test', 0, 100000, "test业务");
+
+	启用 archive 时号段表需要 archived 列:
+	ALTER TABLE `segments` ADD COLUMN `archived` tinyint NOT NULL DEFAULT 0;
 */
 
+// ErrBizTagNotFound 号段表中不存在该业务标识
+var ErrBizTagNotFound = errors.New("biz_tag not found")
+
+// ErrBizTagArchived 业务已归档, 号段行保留但不再发号
+var ErrBizTagArchived = errors.New("biz_tag archived")
+
+// FailoverConfig 定义数据库健康探测和多数据库故障切换的配置
+type FailoverConfig struct {
+	ProbeInterval        int `json:"probe_interval"`         // 健康探测间隔（毫秒）
+	ProbeTimeout         int `json:"probe_timeout"`          // 单次探测超时时间（毫秒）
+	FailbackAfter        int `json:"failback_after"`         // 高优先级数据库连续探测成功多少次后切回
+	StartupRetries       int `json:"startup_retries"`        // 启动时所有数据库都不可达的重试次数, 负数表示不检查直接启动
+	StartupRetryInterval int `json:"startup_retry_interval"` // 启动重试的间隔（毫秒）
+}
+
 type Data struct {
-	db *sql.DB // 数据库连接对象
+	conf      *Config                    // 数据库相关配置: 号段表、故障切换和慢查询阈值
+	dbs       []*sql.DB                  // 按优先级排列的数据库连接池, 第0个为主库
+	dsns      []string                   // 与 dbs 一一对应的 DSN
+	active    int32                      // 当前使用的连接池下标, 原子读写
+	steps     sync.Map                   // 各业务最近一次读取到的步长, 用于单语句获取号段
+	stmts     []map[string]*segmentStmts // 与 dbs 一一对应, 按号段表复用的预处理语句
+	stmtMutex sync.Mutex                 // 保护预处理语句的创建
+	up        []int32                    // 与 dbs 一一对应, 最近一次探测是否可达(1可达, 0不可达, -1未探测), 原子读写
+	replica   *sql.DB                    // 只读副本连接池, 未配置 replica_dsn 时为 nil
+	replicaUp int32                      // 只读副本最近一次探测是否可达, 取值同 up
+	txs       []txStrategy               // 与 dbs 一一对应, 获取号段的事务选项和加锁方式
+	probeChan chan struct{}              // 触发一次立即探测
+	stopChan  chan struct{}              // 停止后台探测
+	probeWait sync.WaitGroup             // 等待后台探测退出
 }
 
 var DefaultData *Data //全局数据库实例
 
-// InitData 初始化MySQL数据库连接
-func InitData() (err error) {
-	// 使用全局配置的 DSN (数据源名称) 初始化数据库连接
-	db, err := sql.Open("mysql", DefaultConfig.DSN)
-	if err != nil {
-		return err
+// dsnList 返回配置中的 DSN 列表, 未配置 dsns 时使用单个 dsn, 按数据中心配置时使用本数据中心的一项
+func dsnList(conf *Config) []string {
+	if dsns, ok := datacenterDSNs(conf); ok {
+		return dsns
+	}
+	if len(conf.DSNs) != 0 {
+		return conf.DSNs
 	}
+	return []string{conf.DSN}
+}
 
-	// 设置连接池的最大空闲连接数
-	db.SetMaxIdleConns(10)
+// InitData 初始化号段存储, MySQL 模式下连接数据库, 本地文件模式下 DefaultData 为 nil
+func InitData() (err error) {
+	var (
+		store Storage
+		data  *Data
+	)
 
-	// 设置连接的最大生命周期（0表示不限制）
-	db.SetConnMaxLifetime(0)
+	if store, data, err = openStore(DefaultConfig); err != nil {
+		return
+	}
 
-	// 赋值全局数据库实例
-	DefaultData = &Data{db: db}
+	// 赋值全局号段存储和数据库实例
+	DefaultStore, DefaultData = store, data
 	return nil
 }
 
+// newData 按优先级连接多个数据库并启动后台健康探测
+// 同一组数据库可以创建多个实例, 各自拥有独立的连接池, 与多个服务实例共享数据库时的状态相同
+func newData(conf *Config) (data *Data, err error) {
+	var (
+		db        *sql.DB
+		tlsConfig *tls.Config
+	)
+
+	// 检查连接选项和分表规则, 证书等配置错误时不必等到连接数据库才发现
+	if tlsConfig, err = checkMySQL(conf.MySQL); err != nil {
+		return nil, err
+	}
+	if err = checkSchema(conf); err != nil {
+		return nil, err
+	}
+	if err = checkSharding(conf); err != nil {
+		return nil, err
+	}
+
+	data = &Data{
+		conf:      conf,
+		dsns:      dsnList(conf),
+		probeChan: make(chan struct{}, 1),
+		stopChan:  make(chan struct{}),
+	}
+	if data.txs, err = txStrategies(conf.Transaction, data.dsns); err != nil {
+		return nil, err
+	}
+
+	for _, dsn := range data.dsns {
+		// 使用 DSN (数据源名称) 和连接选项初始化数据库连接池
+		if db, err = openMySQL(dsn, conf.MySQL, tlsConfig); err != nil {
+			data.closeDBs()
+			return nil, err
+		}
+		data.dbs = append(data.dbs, db)
+	}
+	if conf.ReplicaDSN != "" {
+		if data.replica, err = openMySQL(conf.ReplicaDSN, conf.MySQL, tlsConfig); err != nil {
+			data.closeDBs()
+			return nil, err
+		}
+	}
+	data.replicaUp = -1
+	data.up = make([]int32, len(data.dbs))
+	data.stmts = make([]map[string]*segmentStmts, len(data.dbs))
+	for i := range data.stmts {
+		data.stmts[i] = map[string]*segmentStmts{}
+	}
+	for i := range data.up {
+		data.up[i] = -1 // 尚未探测, 第一次探测的结果总会输出日志
+	}
+
+	// sql.OpenDB 不会建立连接, 启动时确认至少有一个数据库可达
+	if err = data.waitReachable(); err != nil {
+		data.closeDBs()
+		return nil, err
+	}
+
+	// 只读副本不可达不影响启动, 在恢复前只读查询使用主库
+	if data.replica != nil {
+		data.pingReplica()
+	}
+
+	// 后台探测健康状态, 配置了多个数据库时自动切换
+	data.probeWait.Add(1)
+	go data.probeLoop()
+	return
+}
+
+// waitReachable 探测所有数据库, 使用优先级最高的可达库, 都不可达时按配置重试
+func (data *Data) waitReachable() error {
+	var (
+		conf     = data.conf.Failover
+		interval = time.Duration(conf.StartupRetryInterval) * time.Millisecond
+	)
+
+	if conf.StartupRetries < 0 {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		for i := range data.dbs {
+			if data.ping(i) {
+				atomic.StoreInt32(&data.active, int32(i))
+				return nil
+			}
+		}
+		if attempt >= conf.StartupRetries {
+			return errors.New("no database reachable")
+		}
+		logger.Warn("database unreachable, retrying", "attempt", attempt+1, "retries", conf.StartupRetries)
+		time.Sleep(interval)
+	}
+}
+
+// ping 探测单个数据库并记录可达状态, 状态变化时输出日志
+func (data *Data) ping(index int) bool {
+	return data.pingDB(data.dbs[index], data.dsns[index], &data.up[index], "database")
+}
+
+// pingReplica 探测只读副本并记录可达状态
+func (data *Data) pingReplica() bool {
+	return data.pingDB(data.replica, data.conf.ReplicaDSN, &data.replicaUp, "replica")
+}
+
+// pingDB 探测连接池, 将结果写入 state, 状态变化时以 role 为前缀输出日志
+func (data *Data) pingDB(db *sql.DB, dsn string, state *int32, role string) bool {
+	var (
+		timeout = time.Duration(data.conf.Failover.ProbeTimeout) * time.Millisecond
+		up      int32
+	)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+	defer cancelFunc()
+
+	err := db.PingContext(ctx)
+	if err == nil {
+		up = 1
+	}
+	if atomic.SwapInt32(state, up) != up {
+		if up == 1 {
+			logger.Info(role+" reachable", "dsn", redactDSN(dsn))
+		} else {
+			logger.Warn(role+" unreachable", "dsn", redactDSN(dsn), "err", err)
+		}
+	}
+	return up == 1
+}
+
+// ReplicaReachable 返回只读副本最近一次探测是否可达, 未配置只读副本时 configured 为 false
+func (data *Data) ReplicaReachable() (configured bool, up bool) {
+	return data.replica != nil, atomic.LoadInt32(&data.replicaUp) == 1
+}
+
+// Reachable 返回每个数据库最近一次探测是否可达, 与配置中的 DSN 顺序一致
+func (data *Data) Reachable() []bool {
+	result := make([]bool, len(data.up))
+	for i := range data.up {
+		result[i] = atomic.LoadInt32(&data.up[i]) == 1
+	}
+	return result
+}
+
+// segmentStmts 获取号段用到的预处理语句, database/sql 会在每个连接上按需重新预处理
+type segmentStmts struct {
+	update   *sql.Stmt // 将 max_id 前进 multiple 个步长
+	updateId *sql.Stmt // 步长未变时将 max_id 前进并通过 LAST_INSERT_ID 返回
+	query    *sql.Stmt // 读取 max_id 和 step
+	lock     *sql.Stmt // 锁定号段行并读取 max_id 和 step, 仅 select_for_update 方式使用
+	set      *sql.Stmt // 写回前进后的 max_id, 仅 select_for_update 方式使用
+}
+
+// close 关闭所有预处理语句
+func (stmts *segmentStmts) close() {
+	for _, stmt := range []*sql.Stmt{stmts.update, stmts.updateId, stmts.query, stmts.lock, stmts.set} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// statements 返回数据库上某张号段表的预处理语句, 首次使用时创建, 创建失败时下次调用重试
+func (data *Data) statements(ctx context.Context, index int, table string) (stmts *segmentStmts, err error) {
+	var (
+		db     = data.dbs[index]
+		active string // 只更新未归档的号段行
+	)
+
+	data.stmtMutex.Lock()
+	defer data.stmtMutex.Unlock()
+
+	if stmts = data.stmts[index][table]; stmts != nil {
+		return stmts, nil
+	}
+
+	stmts = &segmentStmts{}
+	if data.conf.Archive.Enable { // 已归档的业务不再前进 max_id
+		active = " AND archived = 0"
+	}
+	if data.txs[index].locking == LockingSelectForUpdate {
+		if stmts.lock, err = db.PrepareContext(ctx, "SELECT max_id, step FROM "+table+" WHERE biz_tag = ?"+active+" FOR UPDATE"); err != nil {
+			goto ERROR
+		}
+		if stmts.set, err = db.PrepareContext(ctx, "UPDATE "+table+" SET max_id = ? WHERE biz_tag = ?"); err != nil {
+			goto ERROR
+		}
+		data.stmts[index][table] = stmts
+		return
+	}
+	if stmts.update, err = db.PrepareContext(ctx, "UPDATE "+table+" SET max_id = max_id + step * ? WHERE biz_tag = ?"+active); err != nil {
+		goto ERROR
+	}
+	if stmts.updateId, err = db.PrepareContext(ctx, "UPDATE "+table+" SET max_id = LAST_INSERT_ID(max_id + step * ?) WHERE biz_tag = ? AND step = ?"+active); err != nil {
+		goto ERROR
+	}
+	if stmts.query, err = db.PrepareContext(ctx, "SELECT max_id, step FROM "+table+" WHERE biz_tag = ?"); err != nil {
+		goto ERROR
+	}
+	data.stmts[index][table] = stmts
+	return
+
+ERROR:
+	stmts.close()
+	return nil, err
+}
+
+// current 返回当前使用的数据库连接池
+func (data *Data) current() *sql.DB {
+	return data.dbs[atomic.LoadInt32(&data.active)]
+}
+
+// reader 返回非关键只读查询使用的连接池, 只读副本最近一次探测可达时使用副本, 否则使用当前库
+// 副本存在复制延迟, 只用于管理查询和配置表加载, 号段的读取和更新始终在当前库的事务中进行
+func (data *Data) reader() *sql.DB {
+	if data.replica != nil && atomic.LoadInt32(&data.replicaUp) == 1 {
+		return data.replica
+	}
+	return data.current()
+}
+
+// switchTo 切换当前使用的数据库
+func (data *Data) switchTo(index int) {
+	from := int(atomic.SwapInt32(&data.active, int32(index)))
+	if from != index {
+		logger.Warn("database switched", "from", redactDSN(data.dsns[from]), "to", redactDSN(data.dsns[index]))
+	}
+}
+
+// triggerProbe 请求后台立即探测一次, 不阻塞调用方
+func (data *Data) triggerProbe() {
+	select {
+	case data.probeChan <- struct{}{}:
+	default:
+	}
+}
+
+// probeLoop 定期探测所有数据库并记录可达状态, 当前库不可用时切换, 高优先级库恢复后切回
+func (data *Data) probeLoop() {
+	var (
+		conf     = data.conf.Failover
+		interval = time.Duration(conf.ProbeInterval) * time.Millisecond
+		healthy  = make([]int, len(data.dbs)) // 每个数据库连续探测成功的次数
+		ticker   *time.Ticker
+	)
+
+	defer data.probeWait.Done()
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-data.probeChan:
+		case <-data.stopChan:
+			return
+		}
+		data.probe(healthy)
+	}
+}
+
+// probe 探测一轮并根据结果切换数据库
+func (data *Data) probe(healthy []int) {
+	var (
+		conf   = data.conf.Failover
+		active = int(atomic.LoadInt32(&data.active))
+	)
+
+	for i := range data.dbs {
+		if data.ping(i) {
+			healthy[i]++
+		} else {
+			healthy[i] = 0
+		}
+	}
+	if data.replica != nil {
+		data.pingReplica()
+	}
+
+	// 当前库不可用, 切换到优先级最高的可用库
+	if healthy[active] == 0 {
+		for i := range data.dbs {
+			if healthy[i] > 0 {
+				data.switchTo(i)
+				return
+			}
+		}
+		return
+	}
+
+	// 高优先级的库已稳定恢复, 切回
+	for i := 0; i < active; i++ {
+		if healthy[i] >= conf.FailbackAfter {
+			data.switchTo(i)
+			return
+		}
+	}
+}
+
+// closeDBs 关闭所有数据库连接池和只读副本连接池
+func (data *Data) closeDBs() (err error) {
+	for _, db := range data.dbs {
+		if closeErr := db.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	if data.replica != nil {
+		if closeErr := data.replica.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// Close 停止后台探测并关闭预处理语句和数据库连接池
+func (data *Data) Close() error {
+	close(data.stopChan)
+	data.probeWait.Wait()
+	for _, tables := range data.stmts {
+		for _, stmts := range tables {
+			stmts.close()
+		}
+	}
+	return data.closeDBs()
+}
+
 // NextId 获取并更新下一个可用的 ID 段
-func (data *Data) NextId(bizTag string) (maxId int64, step int64, err error) {
+// 已知业务步长时通过 LAST_INSERT_ID 单条语句完成, 步长未知或已被修改时回退到事务
+// 配置为 select_for_update 的数据库总是在事务中先锁定号段行再更新
+func (data *Data) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
 	var (
-		tx           *sql.Tx    // 事务对象
-		query        string     // SQL 查询语句
-		stmt         *sql.Stmt  // SQL 预处理语句
-		result       sql.Result // SQL 执行结果
-		rowsAffected int64      // 受影响的行数
+		index        = int(atomic.LoadInt32(&data.active))               // 本次使用的数据库, 避免中途切换
+		tx           = data.txs[index]                                   // 该数据库的事务选项和加锁方式
+		table        = data.conf.Sharding.table(data.conf.Table, bizTag) // 号段行所在的表
+		stmts        *segmentStmts                                       // 该数据库的预处理语句
+		rowsAffected int64                                               // 受影响的行数
+		phases       = newQueryPhases(time.Duration(data.conf.SlowQueryThreshold) * time.Millisecond)
+		cached       any // 缓存的业务步长
+		ok           bool
 	)
 
+	// 查询结束后检查是否为慢查询
+	defer func() {
+		phases.logIfSlow(bizTag, rowsAffected, err)
+	}()
+
+	// 开启数据库查询的链路追踪 span
+	ctx, span := tracer.Start(ctx, "Data.NextId", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.sql.table", table),
+		attribute.String("biz_tag", bizTag),
+		attribute.String("db.locking", tx.locking),
+	))
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
+
 	// 设置 2 秒超时，防止长时间等待
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancelFunc := context.WithTimeout(ctx, 2*time.Second)
 
 	// 函数退出时取消超时上下文
 	defer cancelFunc()
 
-	// 开启事务，设置上下文以支持超时和取消
-	if tx, err = data.db.BeginTx(ctx, nil); err != nil {
+	// 周期重置的业务在事务中按所属周期前进 max_id
+	if period, periodic := periodFrom(ctx); periodic {
+		if maxId, step, rowsAffected, err = data.nextIdPeriod(ctx, data.dbs[index], tx.options, table, bizTag, multiple, period, phases); err == nil {
+			span.SetAttributes(attribute.Int64("max_id", maxId), attribute.Int64("step", step))
+		}
+		return
+	}
+
+	// 获取复用的预处理语句
+	if stmts, err = data.statements(ctx, index, table); err != nil {
+		data.triggerProbe() // 连接失败, 尽快探测是否需要切换
+		return
+	}
+
+	// 先锁定再更新, 不使用单语句的快速路径
+	if tx.locking == LockingSelectForUpdate {
+		if maxId, step, rowsAffected, err = data.nextIdLocked(ctx, data.dbs[index], stmts, tx.options, table, bizTag, multiple, phases); err == nil {
+			span.SetAttributes(attribute.Int64("max_id", maxId), attribute.Int64("step", step))
+		}
+		return
+	}
+
+	// 快速路径: 按缓存的步长更新, 新的 max_id 随 OK 包返回, 一次往返
+	if cached, ok = data.steps.Load(bizTag); ok {
+		step = cached.(int64)
+		if maxId, rowsAffected, err = updateWithStep(ctx, stmts, bizTag, step, multiple); err != nil {
+			data.triggerProbe() // 可能是连接失败, 尽快探测是否需要切换
+			return
+		}
+		phases.mark("update")
+		if rowsAffected == 1 {
+			span.SetAttributes(attribute.Int64("max_id", maxId), attribute.Int64("step", step))
+			return
+		}
+		span.AddEvent("step changed, fallback to transaction") // 步长被修改或业务被删除
+	}
+
+	// 慢速路径: 在事务中更新并读取最新的步长
+	if maxId, step, rowsAffected, err = data.nextIdTx(ctx, data.dbs[index], stmts, tx.options, table, bizTag, multiple, phases); err != nil {
+		if errors.Is(err, ErrBizTagNotFound) || errors.Is(err, ErrBizTagArchived) {
+			data.steps.Delete(bizTag)
+		}
 		return
 	}
+	data.steps.Store(bizTag, step)
+	span.SetAttributes(attribute.Int64("max_id", maxId), attribute.Int64("step", step))
+	return
+}
+
+// reset 仅当 max_id 仍为 from 时改为 to, 其他实例已经重置过时不做任何事
+func (data *Data) reset(ctx context.Context, bizTag string, from int64, to int64) (err error) {
+	table := data.conf.Sharding.table(data.conf.Table, bizTag)
+	_, err = data.current().ExecContext(ctx, "UPDATE "+table+" SET max_id = ? WHERE biz_tag = ? AND max_id = ?", to, bizTag, from)
+	return
+}
+
+// missing 号段行没有被更新时, 区分业务不存在和已归档
+func (data *Data) missing(ctx context.Context, tx *sql.Tx, table string, bizTag string) (err error) {
+	var (
+		archived bool
+	)
+
+	if !data.conf.Archive.Enable {
+		return ErrBizTagNotFound
+	}
+	if err = tx.QueryRowContext(ctx, "SELECT archived FROM "+table+" WHERE biz_tag = ?", bizTag).Scan(&archived); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrBizTagNotFound
+		}
+		return err
+	}
+	if archived {
+		return ErrBizTagArchived
+	}
+	return ErrBizTagNotFound
+}
 
-	// STEP 1: 更新 max_id，将其前进一个步长，获取一个新的 ID 段
-	query = "UPDATE " + DefaultConfig.Table + " SET max_id = max_id + step WHERE biz_tag = ? "
+// updateWithStep 步长仍为 step 时将 max_id 前进 multiple 个步长, 返回新的 max_id
+func updateWithStep(ctx context.Context, stmts *segmentStmts, bizTag string, step int64, multiple int64) (maxId int64, rowsAffected int64, err error) {
+	var (
+		result sql.Result // SQL 执行结果
+	)
 
-	// 预处理查询语句
-	if stmt, err = tx.PrepareContext(ctx, query); err != nil {
-		goto ROLLBACK // 失败则回滚事务
+	if result, err = stmts.updateId.ExecContext(ctx, multiple, bizTag, step); err != nil {
+		return
+	}
+	if rowsAffected, err = result.RowsAffected(); err != nil || rowsAffected != 1 {
+		return
 	}
+	maxId, err = result.LastInsertId() // LAST_INSERT_ID(expr) 的值
+	return
+}
 
-	// 确保 stmt 关闭，以免资源泄漏
-	defer stmt.Close()
+// nextIdTx 在事务中更新 max_id 并读取最新的 max_id 和 step
+func (data *Data) nextIdTx(ctx context.Context, db *sql.DB, stmts *segmentStmts, options *sql.TxOptions, table string, bizTag string, multiple int64, phases *queryPhases) (maxId int64, step int64, rowsAffected int64, err error) {
+	var (
+		tx     *sql.Tx    // 事务对象
+		result sql.Result // SQL 执行结果
+	)
 
-	// 执行更新操作，使用指定的业务标签
-	if result, err = stmt.ExecContext(ctx, bizTag); err != nil {
+	// 开启事务，设置上下文以支持超时和取消
+	if tx, err = db.BeginTx(ctx, options); err != nil {
+		data.triggerProbe() // 连接失败, 尽快探测是否需要切换
+		return
+	}
+	phases.mark("begin")
+
+	// STEP 1: 更新 max_id，将其前进 multiple 个步长，获取一个新的 ID 段
+	// 复用预处理语句, 事务结束时 database/sql 自动关闭事务内的语句
+	if result, err = tx.StmtContext(ctx, stmts.update).ExecContext(ctx, multiple, bizTag); err != nil {
 		goto ROLLBACK // 执行失败则回滚
 	}
 
 	// 检查更新操作影响的行数，确保存在该业务标签的记录
 	if rowsAffected, err = result.RowsAffected(); err != nil { // 获取受影响行数出错
 		goto ROLLBACK
-	} else if rowsAffected == 0 { // 没有找到相应的记录
-		err = errors.New("biz_tag not found")
+	} else if rowsAffected == 0 { // 没有找到相应的记录, 或业务已归档
+		err = data.missing(ctx, tx, table, bizTag)
 		goto ROLLBACK
 	}
 
-	// STEP 2: 查询最新的 max_id 和 step，在事务中以保证数据一致性
-	query = "SELECT max_id , step " +
-		" FROM " + DefaultConfig.Table + " WHERE biz_tag = ? "
+	phases.mark("update")
 
-	// 重新准备查询语句
-	if stmt, err = tx.PrepareContext(ctx, query); err != nil {
+	// STEP 2: 查询最新的 max_id 和 step，在事务中以保证数据一致性
+	if err = tx.StmtContext(ctx, stmts.query).QueryRowContext(ctx, bizTag).Scan(&maxId, &step); err != nil {
 		goto ROLLBACK
 	}
 
-	// 查询新的 max_id 和 step 值
-	if err = stmt.QueryRowContext(ctx, bizTag).Scan(&maxId, &step); err != nil {
-		goto ROLLBACK
-	}
+	phases.mark("select")
 
 	// STEP 3: 提交事务，保存更新的 max_id
 	err = tx.Commit()
+	phases.mark("commit")
 	return
 
 ROLLBACK:
 	// 如果有任何错误则回滚事务
 	tx.Rollback()
+	phases.mark("rollback")
 	return
 }
+
+// queryPhases 记录号段事务各阶段的耗时, 用于慢查询日志
+type queryPhases struct {
+	threshold time.Duration // 慢查询阈值, 0 表示不记录
+	start     time.Time     // 事务开始时间
+	last      time.Time     // 上一阶段结束时间
+	attrs     []any         // 各阶段耗时, 以日志键值对形式保存
+}
+
+// newQueryPhases 开始计时
+func newQueryPhases(threshold time.Duration) *queryPhases {
+	now := time.Now()
+	return &queryPhases{threshold: threshold, start: now, last: now}
+}
+
+// mark 记录从上一阶段结束到现在的耗时
+func (phases *queryPhases) mark(name string) {
+	now := time.Now()
+	phases.attrs = append(phases.attrs, name+"_ms", float64(now.Sub(phases.last).Microseconds())/1000)
+	phases.last = now
+}
+
+// logIfSlow 事务总耗时超过阈值时输出慢查询日志
+func (phases *queryPhases) logIfSlow(bizTag string, rowsAffected int64, err error) {
+	var (
+		threshold = phases.threshold
+		elapsed   = time.Since(phases.start)
+		attrs     []any
+	)
+
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+
+	attrs = append(attrs, "code", CodeSlowQuery, "biz_tag", bizTag, "rows_affected", rowsAffected,
+		"elapsed_ms", float64(elapsed.Microseconds())/1000)
+	attrs = append(attrs, phases.attrs...)
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	logger.Warn("slow segment query", attrs...)
+}
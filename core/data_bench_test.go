@@ -0,0 +1,45 @@
+package core
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// BenchmarkDataNextId 测量单次号段获取的数据库耗时, 需要设置 LEAF_TEST_DSN 指向测试库,
+// 例如 LEAF_TEST_DSN='root:123456@tcp(localhost:3306)/leaf-segment' go test -bench DataNextId ./core
+func BenchmarkDataNextId(b *testing.B) {
+	dsn := os.Getenv("LEAF_TEST_DSN")
+	if dsn == "" {
+		b.Skip("LEAF_TEST_DSN not set")
+	}
+
+	setupTestConfig(b)
+	DefaultConfig.DSN = dsn
+	DefaultConfig.Table = "segments"
+	DefaultConfig.Failover.ProbeInterval = 1000
+	DefaultConfig.Failover.ProbeTimeout = 500
+	if err := InitData(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = DefaultData.Close()
+	})
+
+	ctx := context.Background()
+	if _, err := DefaultData.current().ExecContext(ctx,
+		"INSERT INTO segments(biz_tag, max_id, step, description) VALUES('bench', 0, 1000, 'benchmark') "+
+			"ON DUPLICATE KEY UPDATE step = VALUES(step)"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := DefaultData.NextId(ctx, "bench", 1); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DegradeConfig 定义数据库不稳定时的降级预取配置
+// 参考 Leaf 的建议, 内存中的号段应足够支撑数据库故障期间的分配
+type DegradeConfig struct {
+	Enable         bool  `json:"enable"`          // 是否启用降级预取
+	StepMultiplier int64 `json:"step_multiplier"` // 降级期间每次获取 step × N 个号码
+	BufferDepth    int   `json:"buffer_depth"`    // 降级期间内存中保留的号段个数
+	Window         int   `json:"window"`          // 最近一次数据库错误后保持降级的时长（毫秒）
+}
+
+// markStorageError 记录一次号段存储故障, 用于判断是否进入降级
+func (alloc *Alloc) markStorageError() {
+	atomic.StoreInt64(&alloc.lastStorageErr, alloc.now().UnixNano())
+}
+
+// degraded 判断当前是否处于降级状态: 最近一个窗口内出现过数据库错误
+func (alloc *Alloc) degraded() bool {
+	var (
+		conf = alloc.conf.Degrade
+		last = atomic.LoadInt64(&alloc.lastStorageErr)
+	)
+	if !conf.Enable || last == 0 {
+		return false
+	}
+	return alloc.since(time.Unix(0, last)) < time.Duration(conf.Window)*time.Millisecond
+}
+
+// bufferDepth 返回内存中应保留的号段个数, 正常为双Buffer
+func (alloc *Alloc) bufferDepth(bizTag string) int {
+	depth := 2
+	if alloc.degraded() && alloc.conf.Degrade.BufferDepth > 2 {
+		depth = alloc.conf.Degrade.BufferDepth
+	}
+	return max(depth, alloc.scheduleDepth(bizTag)) // 预取窗口内保持更多的号段
+}
+
+// stepMultiple 返回本次获取号段时的步长倍数, 正常为1
+func (alloc *Alloc) stepMultiple() int64 {
+	if alloc.degraded() && alloc.conf.Degrade.StepMultiplier > 1 {
+		return alloc.conf.Degrade.StepMultiplier
+	}
+	return 1
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStandby 本实例是备用实例, 不分配 ID
+var ErrStandby = errors.New("standby instance")
+
+// ElectionConfig 定义主备部署的选主配置
+// 同一组实例竞争同一个 MySQL 命名锁(GET_LOCK), 持有锁的实例为主实例, 其余实例保持数据库连接作为备用:
+// 分配、租约和预留请求返回 503, 健康检查返回 standby. 主实例退出或与数据库断开时锁随会话释放, 备用实例在一个检查间隔内接管
+type ElectionConfig struct {
+	Enable   bool     `json:"enable"`   // 是否启用选主, 需要 MySQL 号段存储
+	Lock     string   `json:"lock"`     // 竞争的锁名, 同一组主备实例使用相同的锁名
+	Interval int      `json:"interval"` // 备用实例尝试加锁、主实例确认仍持有锁的间隔（毫秒）
+	Warm     []string `json:"warm"`     // 备用实例预先加载号段的业务, 接管后直接从内存分配; 从未接管时这些号段作废
+}
+
+// leaderLock 选主使用的锁
+type leaderLock interface {
+	// tryLock 未持有时尝试加锁, 已持有时确认锁仍然有效, 返回当前是否持有
+	tryLock(ctx context.Context) (held bool, err error)
+	// unlock 释放锁, 未持有时什么也不做
+	unlock(ctx context.Context) error
+}
+
+// mysqlLock 基于 MySQL 命名锁的选主锁, 锁属于单个会话, 因此固定使用一个连接
+type mysqlLock struct {
+	db   *sql.DB   // 加锁使用的连接池
+	name string    // 锁名
+	conn *sql.Conn // 持有锁的会话, 出错后丢弃
+	held bool      // 是否持有锁
+}
+
+// tryLock 实现 leaderLock
+func (lock *mysqlLock) tryLock(ctx context.Context) (held bool, err error) {
+	var result sql.NullInt64
+
+	if lock.conn == nil {
+		if lock.conn, err = lock.db.Conn(ctx); err != nil {
+			return false, err
+		}
+	}
+	if lock.held {
+		err = lock.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", lock.name).Scan(&result)
+	} else {
+		err = lock.conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", lock.name).Scan(&result)
+	}
+	if err != nil {
+		// 会话状态未知, 丢弃连接让锁随会话释放, 下次重新建立
+		lock.discard()
+		return false, err
+	}
+	lock.held = result.Valid && result.Int64 == 1
+	return lock.held, nil
+}
+
+// unlock 实现 leaderLock
+func (lock *mysqlLock) unlock(ctx context.Context) (err error) {
+	if lock.conn == nil {
+		return nil
+	}
+	if lock.held {
+		_, err = lock.conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", lock.name)
+	}
+	lock.discard()
+	return
+}
+
+// discard 关闭持有锁的连接, 不放回连接池, 避免其他查询继续持有锁
+func (lock *mysqlLock) discard() {
+	_ = lock.conn.Raw(func(any) error { return driver.ErrBadConn })
+	_ = lock.conn.Close()
+	lock.conn, lock.held = nil, false
+}
+
+// elector 周期性竞争选主锁, 按结果切换主备角色
+type elector struct {
+	conf     ElectionConfig // 选主配置
+	lock     leaderLock     // 选主锁
+	alloc    *Alloc         // 备用期间预先加载号段的分配器
+	leader   atomic.Bool    // 当前是否为主实例
+	stopChan chan struct{}  // 停止竞争
+	wait     sync.WaitGroup // 等待竞争线程退出
+}
+
+// election 全局选主, 未启用时为 nil
+var election *elector
+
+// InitElection 按配置启动选主, 需在分配器初始化之后调用; 返回前完成第一次加锁, 单独运行的实例直接成为主实例
+func InitElection() error {
+	conf := DefaultConfig.Election
+	if election = nil; !conf.Enable {
+		return nil
+	}
+	if DefaultData == nil {
+		return errors.New("election needs the mysql store")
+	}
+	if conf.Lock == "" {
+		return errors.New("election.lock is empty")
+	}
+
+	election = newElector(conf, &mysqlLock{db: DefaultData.current(), name: conf.Lock}, DefaultAlloc)
+	return nil
+}
+
+// newElector 创建并启动选主
+func newElector(conf ElectionConfig, lock leaderLock, alloc *Alloc) *elector {
+	e := &elector{conf: conf, lock: lock, alloc: alloc, stopChan: make(chan struct{})}
+	e.campaign()
+
+	e.wait.Add(1)
+	go e.loop()
+	return e
+}
+
+// interval 返回竞争间隔
+func (e *elector) interval() time.Duration {
+	if e.conf.Interval > 0 {
+		return time.Duration(e.conf.Interval) * time.Millisecond
+	}
+	return time.Second
+}
+
+// loop 每个间隔竞争一次, 直到停止
+func (e *elector) loop() {
+	defer e.wait.Done()
+
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.campaign()
+		case <-e.stopChan:
+			return
+		}
+	}
+}
+
+// campaign 竞争一次选主锁并切换角色, 单次查询不超过一个间隔
+func (e *elector) campaign() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), e.interval())
+	defer cancelFunc()
+
+	held, err := e.lock.tryLock(ctx)
+	if err != nil {
+		logger.Warn("election lock check failed", "lock", e.conf.Lock, "err", err)
+	}
+
+	switch wasLeader := e.leader.Swap(held); {
+	case held && !wasLeader:
+		logger.Info("promoted to leader", "lock", e.conf.Lock)
+	case !held && wasLeader:
+		logger.Warn("lost leadership, now standby", "lock", e.conf.Lock)
+	case !held:
+		// 备用期间保持预热业务的号段充足
+		for _, bizTag := range e.conf.Warm {
+			e.alloc.warm(bizTag)
+		}
+	}
+}
+
+// isLeader 当前是否为主实例
+func (e *elector) isLeader() bool {
+	return e.leader.Load()
+}
+
+// stop 停止竞争并释放锁, 备用实例可以立即接管
+func (e *elector) stop(ctx context.Context) error {
+	close(e.stopChan)
+	e.wait.Wait()
+
+	e.leader.Store(false)
+	return e.lock.unlock(ctx)
+}
+
+// standby 启用了选主且当前不是主实例, 未启用选主时 e 为 nil
+func (e *elector) standby() bool {
+	return e != nil && !e.isLeader()
+}
+
+// role 返回本实例的角色, 未启用选主时为空
+func (e *elector) role() string {
+	switch {
+	case e == nil:
+		return ""
+	case e.isLeader():
+		return "leader"
+	default:
+		return "standby"
+	}
+}
+
+// standby 按全局选主判断本实例是否为备用实例
+func standby() bool {
+	return election.standby()
+}
+
+// role 返回全局选主中本实例的角色
+func role() string {
+	return election.role()
+}
+
+// withLeader 备用实例拒绝请求, 调用方应改为访问主实例
+func withLeader(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if standby() {
+			writeError(w, http.StatusServiceUnavailable, ErrNoStandby, ErrStandby.Error())
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// warm 号段不足时在后台补充, 不消耗号码
+func (alloc *Alloc) warm(bizTag string) {
+	bizAlloc := alloc.loadOrCreate(bizTag)
+	bizAlloc.mutex.Lock()
+	bizAlloc.triggerFill(alloc.ctx)
+	bizAlloc.publish()
+	bizAlloc.mutex.Unlock()
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLock 由测试控制是否能取得的选主锁
+type fakeLock struct {
+	grant    atomic.Bool  // 加锁是否成功
+	unlocked atomic.Int32 // 释放次数
+}
+
+func (lock *fakeLock) tryLock(ctx context.Context) (bool, error) {
+	return lock.grant.Load(), nil
+}
+
+func (lock *fakeLock) unlock(ctx context.Context) error {
+	lock.unlocked.Add(1)
+	return nil
+}
+
+func TestElection(t *testing.T) {
+	setupHandlerTest(t)
+	storage := newFakeStorage(1000)
+	DefaultAlloc = newTestAlloc(t, storage)
+
+	lock := &fakeLock{}
+	lock.grant.Store(true)
+	election = newElector(ElectionConfig{Lock: "test", Interval: int(time.Hour / time.Millisecond), Warm: []string{"warm"}}, lock, DefaultAlloc)
+	t.Cleanup(func() { election = nil })
+
+	health := func() (int, HealthResponse) {
+		w := httptest.NewRecorder()
+		handleHealth(w, httptest.NewRequest(http.MethodGet, "/health?biz_tag=test", nil))
+		var resp HealthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+	alloc := withLeader(handleAlloc)
+
+	// 单独运行的实例直接成为主实例
+	if code, resp := health(); resp.Role != "leader" || code == http.StatusServiceUnavailable {
+		t.Fatalf("health = %d %+v, want leader", code, resp)
+	}
+	w := httptest.NewRecorder()
+	alloc(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("leader alloc status = %d: %s", w.Code, w.Body.String())
+	}
+
+	// 失去锁后作为备用实例拒绝分配
+	lock.grant.Store(false)
+	election.campaign()
+	if code, resp := health(); code != http.StatusServiceUnavailable || resp.Role != "standby" || resp.ErrNo != ErrNoStandby {
+		t.Fatalf("health = %d %+v, want standby", code, resp)
+	}
+	w = httptest.NewRecorder()
+	alloc(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil))
+	var resp AllocResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusServiceUnavailable || resp.ErrNo != ErrNoStandby {
+		t.Fatalf("standby alloc = %d %s", w.Code, w.Body.String())
+	}
+
+	// 备用期间预先加载号段, 不消耗号码
+	election.campaign()
+	deadline := time.Now().Add(5 * time.Second)
+	for DefaultAlloc.LeftCount("warm") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("warm biz_tag has no segments on standby")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	left := DefaultAlloc.LeftCount("warm")
+
+	// 重新取得锁后接管, 直接从预热的号段分配
+	lock.grant.Store(true)
+	election.campaign()
+	calls := storage.callCount()
+	w = httptest.NewRecorder()
+	alloc(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=warm", nil))
+	if w.Code != http.StatusOK || DefaultAlloc.LeftCount("warm") != left-1 || storage.callCount() != calls {
+		t.Fatalf("alloc after takeover = %d %s, left %d, want served from warm segments", w.Code, w.Body.String(), DefaultAlloc.LeftCount("warm"))
+	}
+
+	// 退出时释放锁
+	if err := election.stop(context.Background()); err != nil || lock.unlocked.Load() != 1 || !standby() {
+		t.Fatalf("stop err = %v, unlocked %d times", err, lock.unlocked.Load())
+	}
+}
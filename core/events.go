@@ -0,0 +1,315 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventsConfig 定义 /events 事件流的配置
+// 仪表盘和自动化工具通过 Server-Sent Events 实时订阅分配器事件, 不必轮询 /stats 或管理接口:
+// segment_fetched 为获取到号段, 其余事件与告警类型相同(low_remaining、id_near_limit、refill_failed、breaker_open 等),
+// 同样受告警冷却期限制, 未配置通知渠道时也会推送
+type EventsConfig struct {
+	Enable         bool `json:"enable"`          // 是否在分配端口和管理端口提供 /events
+	Buffer         int  `json:"buffer"`          // 每个订阅者缓冲的事件数, 也是断线重连时可以补发的最近事件数
+	Heartbeat      int  `json:"heartbeat"`       // 心跳间隔（毫秒）, 防止代理关闭空闲连接
+	MaxSubscribers int  `json:"max_subscribers"` // 同时订阅的连接数上限, 0 表示不限制
+}
+
+// 事件类型, 其余事件类型与告警类型相同
+const (
+	EventSegmentFetched = "segment_fetched" // 从号段存储获取到号段, 包括租出的号段
+)
+
+// ErrTooManySubscribers 订阅 /events 的连接数达到上限
+var ErrTooManySubscribers = errors.New("too many event subscribers")
+
+// Event /events 推送的事件, 作为 SSE 的 data 以 JSON 编码
+type Event struct {
+	ID     int64     `json:"id"`                // 事件序号, 进程内递增, 即 SSE 的 id
+	Type   string    `json:"type"`              // 事件类型, 即 SSE 的 event
+	BizTag string    `json:"biz_tag,omitempty"` // 业务标识, 熔断器等全局事件为空
+	Time   time.Time `json:"time"`              // 事件发生时间
+	Data   any       `json:"data,omitempty"`    // 事件内容: 号段的边界或告警事件
+}
+
+// SegmentFetchedData segment_fetched 事件的内容
+type SegmentFetchedData struct {
+	Left  int64 `json:"left"`  // 号段左边界（包含）
+	Right int64 `json:"right"` // 号段右边界（不包含）
+}
+
+// eventFrame 编码好的事件, 所有订阅者共用
+type eventFrame struct {
+	id     int64
+	typ    string
+	bizTag string
+	frame  []byte // SSE 格式的事件
+}
+
+// eventFilter 订阅者关心的事件类型和业务, 为空表示不过滤; 没有业务标识的全局事件不按业务过滤
+type eventFilter struct {
+	types  []string
+	bizTag string
+	caller *principal // 已认证的调用方, 只推送其有权访问的业务的事件; 未启用认证时为 nil
+}
+
+// match 判断事件是否推送给订阅者
+func (filter eventFilter) match(frame *eventFrame) bool {
+	if len(filter.types) != 0 && !slices.Contains(filter.types, frame.typ) {
+		return false
+	}
+	if frame.bizTag == "" {
+		return true
+	}
+	return (filter.bizTag == "" || frame.bizTag == filter.bizTag) && (filter.caller == nil || filter.caller.allow(frame.bizTag))
+}
+
+// eventSubscriber 一个 /events 连接
+type eventSubscriber struct {
+	filter eventFilter
+	frames chan *eventFrame // 待推送的事件, 跟不上时被关闭, 客户端重连后从 Last-Event-ID 补发
+}
+
+// eventBroker 向所有订阅者分发事件, 并保留最近的事件用于断线补发
+type eventBroker struct {
+	conf         EventsConfig
+	mutex        sync.Mutex
+	nextID       int64
+	recent       []*eventFrame                 // 最近的事件, 按序号递增
+	subscribers  map[*eventSubscriber]struct{} // 当前的订阅者
+	published    map[string]int64              // 各类型事件的推送次数
+	disconnected atomic.Int64                  // 因跟不上而断开的订阅者数
+}
+
+// eventBus 全局事件分发器, 未启用 /events 时为 nil
+var eventBus *eventBroker
+
+// checkEvents 检查事件流配置
+func checkEvents(conf EventsConfig) error {
+	if conf.Enable && (conf.Buffer <= 0 || conf.Heartbeat <= 0 || conf.MaxSubscribers < 0) {
+		return errors.New("events buffer and heartbeat must be positive and max_subscribers must not be negative")
+	}
+	return nil
+}
+
+// InitEvents 根据配置初始化事件分发
+func InitEvents() error {
+	conf := DefaultConfig.Events
+	if err := checkEvents(conf); err != nil {
+		return err
+	}
+	if !conf.Enable {
+		eventBus = nil
+		return nil
+	}
+	eventBus = &eventBroker{
+		conf:        conf,
+		subscribers: map[*eventSubscriber]struct{}{},
+		published:   map[string]int64{},
+	}
+	return nil
+}
+
+// hooks 返回将获取到的号段发布为事件的钩子
+func (broker *eventBroker) hooks() Hooks {
+	return Hooks{
+		OnSegmentFetch: func(_ context.Context, bizTag string, left int64, right int64) {
+			broker.publish(EventSegmentFetched, bizTag, time.Now(), SegmentFetchedData{Left: left, Right: right})
+		},
+	}
+}
+
+// publish 编码事件并推送给所有匹配的订阅者, 未启用时什么也不做; 订阅者的缓冲已满时断开该订阅者, 不阻塞发布方
+func (broker *eventBroker) publish(eventType string, bizTag string, at time.Time, data any) {
+	if broker == nil {
+		return
+	}
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.nextID++
+	body, err := json.Marshal(Event{ID: broker.nextID, Type: eventType, BizTag: bizTag, Time: at, Data: data})
+	if err != nil {
+		logger.Warn("encode event failed", "type", eventType, "biz_tag", bizTag, "err", err)
+		return
+	}
+	frame := &eventFrame{
+		id:     broker.nextID,
+		typ:    eventType,
+		bizTag: bizTag,
+		frame:  fmt.Appendf(nil, "id: %d\nevent: %s\ndata: %s\n\n", broker.nextID, eventType, body),
+	}
+	broker.published[eventType]++
+	if broker.recent = append(broker.recent, frame); len(broker.recent) > broker.conf.Buffer {
+		broker.recent = slices.Delete(broker.recent, 0, len(broker.recent)-broker.conf.Buffer)
+	}
+
+	for sub := range broker.subscribers {
+		if !sub.filter.match(frame) {
+			continue
+		}
+		select {
+		case sub.frames <- frame:
+		default:
+			delete(broker.subscribers, sub)
+			close(sub.frames)
+			broker.disconnected.Add(1)
+		}
+	}
+}
+
+// subscribe 注册订阅者, lastID 大于0时补发之后仍保留的匹配事件
+func (broker *eventBroker) subscribe(filter eventFilter, lastID int64) (sub *eventSubscriber, err error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	if limit := broker.conf.MaxSubscribers; limit > 0 && len(broker.subscribers) >= limit {
+		return nil, ErrTooManySubscribers
+	}
+	sub = &eventSubscriber{filter: filter, frames: make(chan *eventFrame, broker.conf.Buffer)}
+	if lastID > 0 {
+		for _, frame := range broker.recent {
+			if frame.id > lastID && filter.match(frame) {
+				sub.frames <- frame // 补发的事件不超过缓冲大小
+			}
+		}
+	}
+	broker.subscribers[sub] = struct{}{}
+	return
+}
+
+// unsubscribe 注销订阅者, 已被断开时什么也不做
+func (broker *eventBroker) unsubscribe(sub *eventSubscriber) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	if _, ok := broker.subscribers[sub]; ok {
+		delete(broker.subscribers, sub)
+		close(sub.frames)
+	}
+}
+
+// disconnect 断开所有订阅者, 服务器优雅退出时调用, 否则长连接会阻塞到宽限期结束
+func (broker *eventBroker) disconnect() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for sub := range broker.subscribers {
+		delete(broker.subscribers, sub)
+		close(sub.frames)
+	}
+}
+
+// splitList 拆分逗号分隔的参数, 忽略空项
+func splitList(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// handleEvents 以 Server-Sent Events 推送分配器事件, 支持 type(逗号分隔)和 biz_tag 参数过滤,
+// 重连时按 Last-Event-ID 请求头或 last_event_id 参数补发错过的事件; 不受 request_timeout 限制
+// 分配端口上与 /alloc 一样经过认证和命名空间解析, 调用方只收到有权访问的业务的事件和全局事件
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	var (
+		broker = eventBus // 重新初始化不影响已建立的连接
+		query  = r.URL.Query()
+		filter = eventFilter{types: splitList(query.Get("type")), bizTag: query.Get("biz_tag")}
+		lastID int64
+		sub    *eventSubscriber
+		err    error
+	)
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok && !p.admin { // 管理员可以收到全部业务的事件
+		filter.caller = p
+	}
+	if value := cmp.Or(r.Header.Get("Last-Event-ID"), query.Get("last_event_id")); value != "" {
+		if lastID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			http.Error(w, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+	}
+	if sub, err = broker.subscribe(filter, lastID); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer broker.unsubscribe(sub)
+
+	// 事件流不受写入超时限制, 客户端断开或服务器退出时结束
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // 关闭 nginx 的响应缓冲
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write([]byte(": connected\n\n")); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(broker.conf.Heartbeat) * time.Millisecond)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-sub.frames:
+			if !ok {
+				return // 跟不上或服务器退出, 客户端重连后补发
+			}
+			_, err = w.Write(frame.frame)
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": ping\n\n"))
+		}
+		if err != nil || rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writeEventMetrics 输出事件的推送次数和订阅者数, 未启用时不输出
+func writeEventMetrics(b *strings.Builder) {
+	if eventBus == nil {
+		return
+	}
+
+	eventBus.mutex.Lock()
+	types := make([]string, 0, len(eventBus.published))
+	for eventType := range eventBus.published {
+		types = append(types, eventType)
+	}
+	slices.Sort(types)
+	fmt.Fprintln(b, "# HELP leaf_events_published_total Number of events published to /events by type.")
+	fmt.Fprintln(b, "# TYPE leaf_events_published_total counter")
+	for _, eventType := range types {
+		fmt.Fprintf(b, "leaf_events_published_total{type=\"%s\"} %d\n", escapeLabel(eventType), eventBus.published[eventType])
+	}
+	fmt.Fprintln(b, "# HELP leaf_events_subscribers Number of connected /events subscribers.")
+	fmt.Fprintln(b, "# TYPE leaf_events_subscribers gauge")
+	fmt.Fprintf(b, "leaf_events_subscribers %d\n", len(eventBus.subscribers))
+	eventBus.mutex.Unlock()
+
+	fmt.Fprintln(b, "# HELP leaf_events_disconnected_total Number of /events subscribers disconnected for falling behind.")
+	fmt.Fprintln(b, "# TYPE leaf_events_disconnected_total counter")
+	fmt.Fprintf(b, "leaf_events_disconnected_total %d\n", eventBus.disconnected.Load())
+}
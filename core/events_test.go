@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent 读取下一个 SSE 事件, 跳过注释行
+func readEvent(t *testing.T, reader *bufio.Reader) (event Event) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			if err = json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if line == "\n" && event.ID != 0 {
+			return
+		}
+	}
+}
+
+// subscribeEvents 订阅事件流, 返回读取器
+func subscribeEvents(t *testing.T, url string, lastID string) *bufio.Reader {
+	t.Helper()
+	header := http.Header{}
+	if lastID != "" {
+		header.Set("Last-Event-ID", lastID)
+	}
+	return subscribeEventsWith(t, url, header)
+}
+
+// subscribeEventsWith 带指定请求头订阅事件流, 返回读取器
+func subscribeEventsWith(t *testing.T, url string, header http.Header) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header = header
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("first line %q", line)
+	}
+	return reader
+}
+
+func TestEvents(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Events = EventsConfig{Enable: true, Buffer: 16, Heartbeat: 60000}
+	DefaultConfig.RequestTimeout = 50
+	DefaultConfig.Compression = CompressionConfig{Enable: true, MinSize: 1024}
+	if err := InitEvents(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { eventBus = nil })
+
+	// 经过压缩和请求时限中间件后仍能逐条推送, 不受请求时限限制
+	srv := httptest.NewServer(withAccessLog(withGzip(withTimeout(http.HandlerFunc(handleEvents)))))
+	t.Cleanup(srv.Close) // 在订阅的连接关闭后关闭
+	reader := subscribeEvents(t, srv.URL+"/events?biz_tag=test", "")
+
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	alloc.Use(eventBus.hooks())
+	saved := alerter
+	alerter = &Alerter{conf: AlertConfig{Cooldown: 60000}, lastSent: map[alertKey]time.Time{}}
+	defer func() { alerter = saved }()
+
+	time.Sleep(100 * time.Millisecond) // 超过请求时限
+	if _, err := alloc.loadOrCreate("other").nextId(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.loadOrCreate("test").nextId(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	alerter.Fire(AlertEvent{Type: AlertBreakerOpen})
+	alerter.Fire(AlertEvent{Type: AlertBreakerOpen}) // 冷却期内不再推送
+
+	// 只收到 test 的号段和全局的熔断事件, 不收到 other 的号段
+	event := readEvent(t, reader)
+	data, _ := event.Data.(map[string]any)
+	if event.Type != EventSegmentFetched || event.BizTag != "test" || data["right"] == nil {
+		t.Fatalf("event = %+v, want segment_fetched of test", event)
+	}
+	for event.Type == EventSegmentFetched { // 之后可能还有后台预取的号段
+		event = readEvent(t, reader)
+	}
+	if event.Type != AlertBreakerOpen || event.BizTag != "" {
+		t.Fatalf("event = %+v, want breaker_open", event)
+	}
+	lastID := event.ID
+
+	// 重连时补发错过的事件
+	eventBus.publish(AlertLowRemaining, "test", time.Now(), AlertEvent{Type: AlertLowRemaining, BizTag: "test"})
+	reader = subscribeEvents(t, srv.URL+"/events?type=low_remaining", "1")
+	if event = readEvent(t, reader); event.Type != AlertLowRemaining || event.ID != lastID+1 {
+		t.Fatalf("replayed event = %+v, want low_remaining after %d", event, lastID)
+	}
+}
+
+func TestEventSubscribers(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Events = EventsConfig{Enable: true, Buffer: 2, Heartbeat: 1000, MaxSubscribers: 1}
+	if err := InitEvents(); err != nil {
+		t.Fatal(err)
+	}
+	broker := eventBus
+	eventBus = nil
+
+	sub, err := broker.subscribe(eventFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = broker.subscribe(eventFilter{}, 0); !errors.Is(err, ErrTooManySubscribers) {
+		t.Fatalf("err = %v, want ErrTooManySubscribers", err)
+	}
+
+	// 跟不上的订阅者被断开, 发布方不阻塞
+	for i := 0; i < 3; i++ {
+		broker.publish(EventSegmentFetched, "test", time.Now(), nil)
+	}
+	for range sub.frames {
+	}
+	if broker.disconnected.Load() != 1 || len(broker.subscribers) != 0 {
+		t.Fatalf("disconnected = %d, subscribers = %d", broker.disconnected.Load(), len(broker.subscribers))
+	}
+	broker.unsubscribe(sub) // 已断开时什么也不做
+
+	if err = checkEvents(EventsConfig{Enable: true, Buffer: 0, Heartbeat: 1000}); err == nil {
+		t.Fatal("zero buffer accepted")
+	}
+}
+
+func TestEventsAuth(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Events = EventsConfig{Enable: true, Buffer: 16, Heartbeat: 60000}
+	if err := InitEvents(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { eventBus = nil })
+	auth, err := newAPIKeyAuth(AuthConfig{Header: "X-Api-Key", Keys: []APIKey{
+		{Key: "team", Name: "team", BizTags: []string{"test"}},
+		{Key: "ops", Name: "ops", Admin: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(withAuth([]authenticator{auth}, handleEvents))
+	t.Cleanup(srv.Close)
+
+	// 未认证或订阅无权访问的业务时拒绝
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "team": http.StatusForbidden} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?biz_tag=other", nil)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("key %q: status %d, want %d", key, resp.StatusCode, want)
+		}
+	}
+
+	// 不指定业务时只收到有权访问的业务和全局事件, 管理员收到全部事件
+	team := subscribeEventsWith(t, srv.URL+"/events", http.Header{"X-Api-Key": {"team"}})
+	ops := subscribeEventsWith(t, srv.URL+"/events", http.Header{"X-Api-Key": {"ops"}})
+	eventBus.publish(EventSegmentFetched, "other", time.Now(), nil)
+	eventBus.publish(EventSegmentFetched, "test", time.Now(), nil)
+	eventBus.publish(AlertBreakerOpen, "", time.Now(), nil)
+	for _, want := range []string{"test", ""} {
+		if event := readEvent(t, team); event.BizTag != want {
+			t.Fatalf("team got event %+v, want biz_tag %q", event, want)
+		}
+	}
+	for _, want := range []string{"other", "test", ""} {
+		if event := readEvent(t, ops); event.BizTag != want {
+			t.Fatalf("ops got event %+v, want biz_tag %q", event, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"expvar"
+	"runtime"
+	"sync/atomic"
+)
+
+// expvarBiz 单个业务在 expvar 中输出的内容
+type expvarBiz struct {
+	AllocSuccess int64   `json:"alloc_success"`   // 成功分配的ID数
+	AllocFail    int64   `json:"alloc_fail"`      // 分配失败次数
+	FetchSuccess int64   `json:"fetch_success"`   // 成功获取号段次数
+	FetchFail    int64   `json:"fetch_fail"`      // 获取号段失败次数
+	RefillGiveUp int64   `json:"refill_give_up"`  // 补偿线程放弃次数
+	Remaining    int64   `json:"remaining"`       // 内存中剩余号码数
+	Ratio        float64 `json:"remaining_ratio"` // 剩余号码占双Buffer满载容量的比例
+	Buffers      int     `json:"buffers"`         // 内存中的号段个数
+	Waiting      int     `json:"waiting"`         // 挂起等待的客户端数
+	Step         int64   `json:"step"`            // 当前生效的步长
+}
+
+// expvarStats 汇总分配器内部状态, 供 /debug/vars 输出
+func expvarStats() any {
+	var (
+		bizStats = map[string]expvarBiz{}
+	)
+
+	if DefaultAlloc != nil {
+		for _, g := range DefaultAlloc.gauges() {
+			bizStats[g.bizTag] = expvarBiz{
+				AllocSuccess: atomic.LoadInt64(&g.metrics.allocSuccess),
+				AllocFail:    atomic.LoadInt64(&g.metrics.allocFail),
+				FetchSuccess: atomic.LoadInt64(&g.metrics.fetchSuccess),
+				FetchFail:    atomic.LoadInt64(&g.metrics.fetchFail),
+				RefillGiveUp: atomic.LoadInt64(&g.metrics.refillGiveUp),
+				Remaining:    g.remaining,
+				Ratio:        g.ratio,
+				Buffers:      g.buffers,
+				Waiting:      g.waiting,
+				Step:         g.step,
+			}
+		}
+	}
+
+	return map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"biz":        bizStats,
+	}
+}
+
+func init() {
+	// 进程内只能注册一次, 因此在包初始化时发布
+	expvar.Publish("leaf", expvar.Func(expvarStats))
+}
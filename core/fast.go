@@ -0,0 +1,146 @@
+package core
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FastConfig 定义高性能分配端口的配置
+// 该端口只提供 /alloc, 跳过链路追踪、访问日志和跨域处理, 成功响应直接拼接到池化的缓冲区中
+// 认证、限流、并发限制、来源地址过滤和请求时限与主端口保持一致
+type FastConfig struct {
+	Enable         bool     `json:"enable"`           // 是否启用高性能分配端口
+	Port           int      `json:"port"`             // 高性能分配端口的监听端口
+	Listen         []string `json:"listen"`           // 监听地址列表, 格式同 http_listen, 非空时代替 port
+	MaxHeaderBytes int      `json:"max_header_bytes"` // 请求头的最大字节数, 分配请求的请求头很小, 调小可减少每个连接的内存, 0 表示使用 http_max_header_bytes
+}
+
+var (
+	fastServer  *http.Server // 高性能分配端口的 HTTP 服务器, 未启用时为 nil
+	contentJSON = []string{"application/json"}
+	respPool    = sync.Pool{New: func() any { buf := make([]byte, 0, 64); return &buf }} // 成功响应的缓冲区
+)
+
+// queryValue 从原始查询字符串中取出参数值, 不构造完整的参数表
+func queryValue(rawQuery string, key string) string {
+	var (
+		pair  string
+		value string
+		found bool
+	)
+
+	for rawQuery != "" {
+		pair, rawQuery, _ = strings.Cut(rawQuery, "&")
+		if pair, value, found = strings.Cut(pair, "="); !found && pair == "" {
+			continue
+		}
+		if strings.ContainsAny(pair, "%+") { // 参数名也可能被编码
+			pair, _ = url.QueryUnescape(pair)
+		}
+		if pair != key {
+			continue
+		}
+		if strings.ContainsAny(value, "%+") { // 只有编码过的值才需要解码
+			value, _ = url.QueryUnescape(value)
+		}
+		return value
+	}
+	return ""
+}
+
+// handleAllocFast 处理高性能端口的分配请求, biz_tag 只从查询字符串读取
+func handleAllocFast(w http.ResponseWriter, r *http.Request) {
+	var (
+		bizTag    = queryValue(r.URL.RawQuery, "biz_tag") // 业务标签
+		id        int64                                   // 分配的ID
+		err       error                                   // 错误信息
+		startTime = DefaultAlloc.now()                    // 开始处理的时间
+	)
+
+	// 记录处理耗时, 供 /stats 统计
+	defer DefaultAlloc.recordLatency(bizTag, startTime)
+
+	if bizTag == "" {
+		err = paramError("need biz_tag param") // 缺少biz_tag参数
+		goto ERROR
+	}
+
+	// 校验 biz_tag, 无效的业务不进入分配器
+	if err = bizTagValidator.validate(bizTag); err != nil {
+		goto ERROR
+	}
+
+	// 循环分配ID，确保ID不为0
+	for {
+		if id, err = DefaultAlloc.NextId(r.Context(), bizTag); err != nil {
+			if id, err = peers.forwardAlloc(r, bizTag, err); err != nil {
+				goto ERROR
+			}
+		}
+		if id != 0 { // 跳过ID为0的情况
+			break
+		}
+	}
+
+	// 成功响应与 AllocResponse 的 JSON 编码一致, 直接拼接避免反射和内存分配
+	{
+		bufPtr := respPool.Get().(*[]byte)
+		buf := append((*bufPtr)[:0], `{"err_no":0,"msg":"success","id":`...)
+		buf = strconv.AppendInt(buf, id, 10)
+		buf = append(buf, '}')
+
+		header := w.Header()
+		header["Content-Type"] = contentJSON // 显式设置, 避免按内容探测类型
+		header["Content-Length"] = []string{strconv.Itoa(len(buf))}
+		_, _ = w.Write(buf)
+
+		*bufPtr = buf
+		respPool.Put(bufPtr)
+	}
+	return
+
+ERROR:
+	status, errNo, msg := allocFailure(r.Context(), bizTag, err)
+	writeFailure(w, err, status, errNo, msg)
+}
+
+// startFastServer 启动高性能分配端口, alloc 为已按配置包装好认证、限流和并发限制的处理函数
+func startFastServer(errChan chan<- error, listener net.Listener, alloc http.HandlerFunc, filter *ipFilter, tlsConfig *tls.Config) (srv *http.Server, err error) {
+	listeners := []net.Listener{listener}
+	if listener == nil {
+		if listeners, err = listenAll(DefaultConfig.Fast.Listen, DefaultConfig.Fast.Port); err != nil {
+			return
+		}
+	}
+	if tlsConfig != nil {
+		for i := range listeners {
+			listeners[i] = tls.NewListener(listeners[i], tlsConfig)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alloc", alloc)
+
+	srv = &http.Server{
+		ReadTimeout:                  time.Duration(DefaultConfig.HttpReadTimeout) * time.Millisecond,
+		WriteTimeout:                 time.Duration(DefaultConfig.HttpWriteTimeout) * time.Millisecond,
+		MaxHeaderBytes:               DefaultConfig.Fast.MaxHeaderBytes,
+		DisableGeneralOptionsHandler: true, // 不处理 OPTIONS *, 该端口不面向浏览器
+		Handler:                      withRequestID(withRecover(withIPFilter(filter, withTimeout(withWaitHint(mux))))),
+	}
+	tuneServer(srv)
+	if err = configureHTTP2(srv, tlsConfig); err != nil {
+		closeAll(listeners)
+		return nil, err
+	}
+
+	logger.Info("fast alloc server started", "addr", listenerAddrs(listeners), "tls", tlsConfig != nil)
+	serveAll(srv, listeners, errChan)
+	return
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package core
+
+import "os"
+
+// lockFile 非 unix 平台不加锁, 需要自行保证只有一个进程使用号段文件
+func lockFile(*os.File) error {
+	return nil
+}
+
+// syncDir 非 unix 平台不支持同步目录
+func syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 号段存储类型
+const (
+	StoreMySQL = "mysql" // MySQL 号段表, 多个实例可以共享
+	StoreFile  = "file"  // 本地文件, 单实例使用, 不依赖任何外部服务
+)
+
+// StoreConfig 定义号段存储的配置
+type StoreConfig struct {
+	Type string          `json:"type"` // 号段存储类型: mysql 或 file, 为空表示 mysql
+	File FileStoreConfig `json:"file"` // 本地文件存储配置, type 为 file 时生效
+}
+
+// FileStoreConfig 定义本地文件号段存储的配置
+// 适合小规模部署和隔离网络: 单个二进制文件加配置即可提供服务, 文件被排他锁定, 同一时间只能有一个进程使用
+type FileStoreConfig struct {
+	Path string           `json:"path"` // 号段文件路径, 每次获取号段时原子替换
+	Step int64            `json:"step"` // 未在 tags 中配置的业务首次使用时的步长, 0 表示只允许 tags 中的业务
+	Tags map[string]int64 `json:"tags"` // 按业务标识配置的步长, 修改后对下一个号段生效
+}
+
+// fileSegment 文件中一个业务的号段状态, 与号段表的一行对应
+type fileSegment struct {
+	MaxId   int64 `json:"max_id"`             // 已分配出去的最大号码(不包含)
+	Step    int64 `json:"step"`               // 最近一次使用的步长
+	ResetAt int64 `json:"reset_at,omitempty"` // 周期重置的业务当前所属周期的开始时间(毫秒时间戳)
+}
+
+// fileContent 号段文件的格式
+type fileContent struct {
+	Segments map[string]fileSegment `json:"segments"`
+}
+
+// fileStore 本地文件号段存储, 实现 Storage
+type fileStore struct {
+	conf     FileStoreConfig
+	mutex    sync.Mutex
+	lock     *os.File               // 持有排他锁的文件, 关闭时释放
+	segments map[string]fileSegment // 文件中的号段状态, 写入成功后才更新
+}
+
+// DefaultStore 全局号段存储, MySQL 模式下与 DefaultData 相同
+var DefaultStore Storage
+
+// UseStore 使用调用方提供的号段存储代替 InitData, 退出时由 Shutdown 关闭
+func UseStore(store Storage) error {
+	if err := checkWithoutMySQL(DefaultConfig); err != nil {
+		return err
+	}
+	DefaultStore, DefaultData = store, nil
+	return nil
+}
+
+// openStore 按配置打开号段存储, MySQL 模式下同时返回数据库连接, 供台账等功能使用
+func openStore(conf *Config) (store Storage, data *Data, err error) {
+	switch conf.Store.Type {
+	case "", StoreMySQL:
+		if data, err = newData(conf); err != nil {
+			return nil, nil, err
+		}
+		return data, data, nil
+	case StoreFile:
+		if err = checkWithoutMySQL(conf); err != nil {
+			return nil, nil, err
+		}
+		file, err := openFileStore(conf.Store.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown store type %q", conf.Store.Type)
+	}
+}
+
+// checkWithoutMySQL 不使用 MySQL 号段表时, 检查配置中是否有依赖 MySQL 的功能
+func checkWithoutMySQL(conf *Config) error {
+	for name, table := range map[string]string{
+		"audit.table":      conf.Audit.Table,
+		"ledger.table":     conf.Ledger.Table,
+		"auth.table":       conf.Auth.Table,
+		"rate_limit.table": conf.RateLimit.Table,
+		"usage.table":      conf.Usage.Table,
+	} {
+		if table != "" {
+			return fmt.Errorf("%s needs the mysql store", name)
+		}
+	}
+	if conf.Election.Enable {
+		return errors.New("election needs the mysql store") // 本地文件已加锁, 同一份文件只能有一个实例
+	}
+	if conf.Sharding.Type != "" {
+		return errors.New("sharding needs the mysql store")
+	}
+	if conf.Archive.Enable {
+		return errors.New("archive needs the mysql store")
+	}
+	if conf.Schema == SchemaLeaf {
+		return errors.New("leaf schema needs the mysql store")
+	}
+	return nil
+}
+
+// openFileStore 锁定并读取号段文件, 文件不存在时从空状态开始
+func openFileStore(conf FileStoreConfig) (store *fileStore, err error) {
+	var (
+		content []byte
+		file    fileContent
+	)
+
+	if conf.Path == "" {
+		return nil, errors.New("store.file.path is empty")
+	}
+	store = &fileStore{conf: conf, segments: map[string]fileSegment{}}
+
+	// 锁定同目录下的锁文件, 号段文件本身每次都会被替换
+	if store.lock, err = os.OpenFile(conf.Path+".lock", os.O_CREATE|os.O_RDWR, 0o644); err != nil {
+		return nil, err
+	}
+	if err = lockFile(store.lock); err != nil {
+		_ = store.lock.Close()
+		return nil, fmt.Errorf("segment file %s is used by another process: %w", conf.Path, err)
+	}
+
+	if content, err = os.ReadFile(conf.Path); errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		goto ERROR
+	}
+	if err = json.Unmarshal(content, &file); err != nil {
+		err = fmt.Errorf("parse segment file %s: %w", conf.Path, err)
+		goto ERROR
+	}
+	if file.Segments != nil {
+		store.segments = file.Segments
+	}
+	return store, nil
+
+ERROR:
+	_ = store.Close()
+	return nil, err
+}
+
+// NextId 将 max_id 推进 multiple 个步长, 写入文件成功后才返回新号段
+func (store *fileStore) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// 步长以配置为准, 业务从配置中删除后沿用文件中记录的步长
+	segment, ok := store.segments[bizTag]
+	if step = store.conf.Tags[bizTag]; step <= 0 {
+		step = store.conf.Step
+	}
+	if step <= 0 && ok {
+		step = segment.Step
+	}
+	if step <= 0 {
+		return 0, 0, ErrBizTagNotFound
+	}
+
+	// 周期重置的业务, 新周期中第一次获取号段时先重置 max_id
+	if period, periodic := periodFrom(ctx); periodic {
+		if segment.ResetAt > period.start {
+			return 0, 0, errPeriodPassed
+		}
+		if segment.ResetAt < period.start {
+			segment.MaxId = period.resetTo
+		}
+		segment.ResetAt = period.start
+	}
+
+	if step > (math.MaxInt64-segment.MaxId)/multiple {
+		return 0, 0, fmt.Errorf("%w: biz_tag %s max_id exceeds int64", ErrIdExhausted, bizTag)
+	}
+	segment = fileSegment{MaxId: segment.MaxId + step*multiple, Step: step, ResetAt: segment.ResetAt}
+	if err = store.save(bizTag, segment); err != nil {
+		return 0, 0, err
+	}
+	store.segments[bizTag] = segment
+	return segment.MaxId, step, nil
+}
+
+// reset 仅当 max_id 仍为 from 时改为 to, 步长不变
+func (store *fileStore) reset(ctx context.Context, bizTag string, from int64, to int64) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	segment, ok := store.segments[bizTag]
+	if !ok || segment.MaxId != from {
+		return nil
+	}
+	segment.MaxId = to
+	if err = store.save(bizTag, segment); err != nil {
+		return
+	}
+	store.segments[bizTag] = segment
+	return nil
+}
+
+// save 将更新后的号段状态写入临时文件并同步到磁盘, 再原子替换号段文件
+// 替换前进程退出时文件保持原状, 已返回的号段不会被再次分配
+func (store *fileStore) save(bizTag string, segment fileSegment) (err error) {
+	var (
+		content = fileContent{Segments: make(map[string]fileSegment, len(store.segments)+1)}
+		bytes   []byte
+		tmp     *os.File
+	)
+
+	for tag, s := range store.segments {
+		content.Segments[tag] = s
+	}
+	content.Segments[bizTag] = segment
+	if bytes, err = json.MarshalIndent(&content, "", "  "); err != nil {
+		return
+	}
+
+	dir := filepath.Dir(store.conf.Path)
+	if tmp, err = os.CreateTemp(dir, filepath.Base(store.conf.Path)+".*.tmp"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(bytes); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), store.conf.Path); err != nil {
+		return
+	}
+	return syncDir(dir)
+}
+
+// Close 释放文件锁
+func (store *fileStore) Close() error {
+	return store.lock.Close()
+}
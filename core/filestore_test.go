@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	conf := FileStoreConfig{Path: filepath.Join(t.TempDir(), "segments.json"), Tags: map[string]int64{"test": 100}}
+	store, err := openFileStore(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与号段表相同, 新业务从0开始, 每次推进 multiple 个步长
+	for _, c := range []struct{ multiple, want int64 }{{1, 100}, {2, 300}} {
+		if maxId, step, err := store.NextId(context.Background(), "test", c.multiple); err != nil || maxId != c.want || step != 100 {
+			t.Fatalf("NextId = (%d, %d, %v), want (%d, 100, nil)", maxId, step, err, c.want)
+		}
+	}
+	if _, _, err = store.NextId(context.Background(), "unknown", 1); !errors.Is(err, ErrBizTagNotFound) {
+		t.Fatalf("NextId of an unconfigured tag err = %v, want ErrBizTagNotFound", err)
+	}
+
+	// 文件被锁定时其他进程不能使用
+	if _, err = openFileStore(conf); err == nil {
+		t.Fatal("opened a segment file locked by another store")
+	}
+
+	// 重新打开后从文件中的 max_id 继续, 步长修改对下一个号段生效
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Tags["test"] = 1000
+	if store, err = openFileStore(conf); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if maxId, step, err := store.NextId(context.Background(), "test", 1); err != nil || maxId != 1300 || step != 1000 {
+		t.Fatalf("NextId after reopen = (%d, %d, %v), want (1300, 1000, nil)", maxId, step, err)
+	}
+
+	// 没有遗留临时文件
+	entries, err := os.ReadDir(filepath.Dir(conf.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name != "segments.json" && name != "segments.json.lock" {
+			t.Fatalf("unexpected file %s", name)
+		}
+	}
+}
+
+func TestAllocatorFileStore(t *testing.T) {
+	conf := NewConfig()
+	conf.Store.Type = StoreFile
+	conf.Store.File.Path = filepath.Join(t.TempDir(), "segments.json")
+	conf.Store.File.Step = 10
+
+	// 不需要数据库, 重启后不会重复分配
+	var last int64
+	for i := 0; i < 2; i++ {
+		allocator, err := New(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 25; j++ {
+			id, err := allocator.alloc.loadOrCreate("test").nextId(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id <= last && last != 0 {
+				t.Fatalf("id %d after %d", id, last)
+			}
+			last = id
+		}
+		if err = allocator.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 依赖 MySQL 的功能不能与本地文件同时使用
+	conf.Audit.Table = "audit_log"
+	if _, err := New(conf); err == nil {
+		t.Fatal("New accepted audit.table with the file store")
+	}
+}
@@ -0,0 +1,627 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 管理端口的 /admin/graphql 在业务、号段台账、滑动窗口统计和租约之上提供 GraphQL 查询,
+// 内部工具一次请求取回需要的字段, 不必拼接多个管理接口的结果; 只读, 不支持 mutation
+//
+// 支持的语法为查询的子集: 字段、别名、参数、变量和 __typename, 不支持片段、指令和内省;
+// 字段名取自各查询结果类型的 json 标签, 标签不是合法的 GraphQL 名称时使用 graphql 标签, 例如:
+//
+//	{ tags(biz_tag: "order") { biz_tag max_id step } stats { biz_tag remaining last_minute { rate errors } } }
+
+// graphqlMaxBody 请求体的最大字节数
+const graphqlMaxBody = 64 << 10
+
+// graphqlRequest GraphQL 请求, GET 时取自 query 和 variables 参数
+type graphqlRequest struct {
+	Query     string         `json:"query"`     // 查询文档
+	Variables map[string]any `json:"variables"` // 变量的值
+}
+
+// graphqlResponse GraphQL 响应, 语法或校验错误时没有 data
+type graphqlResponse struct {
+	Data   *graphqlObject `json:"data,omitempty"`   // 查询结果, 字段按查询中的顺序排列
+	Errors []graphqlError `json:"errors,omitempty"` // 错误, 单个字段出错时该字段为 null, 其他字段照常返回
+}
+
+// graphqlError GraphQL 错误
+type graphqlError struct {
+	Message string `json:"message"`        // 错误信息
+	Path    []any  `json:"path,omitempty"` // 出错的字段路径, 语法或校验错误时为空
+}
+
+// graphqlObject 按查询顺序排列字段的对象
+type graphqlObject []graphqlEntry
+
+// graphqlEntry 对象中的一个字段
+type graphqlEntry struct {
+	key   string
+	value any
+}
+
+// MarshalJSON 按字段顺序编码, 与查询中的顺序一致
+func (object graphqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, entry := range object {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// graphqlField 查询中的一个字段
+type graphqlField struct {
+	alias     string          // 结果中的名称, 未指定别名时为字段名
+	name      string          // 字段名
+	args      map[string]any  // 参数, 变量引用为 graphqlVar
+	selection []*graphqlField // 子字段, 标量字段为空
+}
+
+// graphqlVar 参数中的变量引用
+type graphqlVar string
+
+// graphqlQuery 一个根查询字段
+type graphqlQuery struct {
+	args    map[string]string                                        // 接受的参数及其类型: String 或 Int
+	result  reflect.Type                                             // 结果类型, 用于校验子字段
+	resolve func(ctx context.Context, args graphqlArgs) (any, error) // 查询结果
+}
+
+// graphqlArgs 代入变量后的参数
+type graphqlArgs map[string]any
+
+// TagStats GraphQL stats 查询中单个业务的统计
+type TagStats struct {
+	BizTag string `json:"biz_tag"` // 业务标识
+	BizStats
+}
+
+// graphqlQueries 根查询字段
+var graphqlQueries = map[string]graphqlQuery{
+	"tags": {
+		args:   map[string]string{"biz_tag": "String"},
+		result: reflect.TypeFor[[]TagInfo](),
+		resolve: func(ctx context.Context, args graphqlArgs) (any, error) {
+			if DefaultData == nil {
+				return nil, errors.New("tags need the mysql store")
+			}
+			return DefaultData.Tags(ctx, args.string("biz_tag"))
+		},
+	},
+	"segments": {
+		args:   map[string]string{"biz_tag": "String", "id": "Int", "limit": "Int"},
+		result: reflect.TypeFor[[]LedgerEntry](),
+		resolve: func(ctx context.Context, args graphqlArgs) (any, error) {
+			if ledger == nil {
+				return nil, errors.New("segment ledger disabled")
+			}
+			id, limit := args.int("id", -1), args.int("limit", 100)
+			if limit <= 0 {
+				return nil, errors.New("invalid limit")
+			}
+			return ledger.query(ctx, args.string("biz_tag"), int64(id), limit)
+		},
+	},
+	"stats": {
+		args:   map[string]string{"biz_tag": "String"},
+		result: reflect.TypeFor[[]TagStats](),
+		resolve: func(ctx context.Context, args graphqlArgs) (any, error) {
+			if !DefaultConfig.Stats.Enable {
+				return nil, errors.New("stats disabled")
+			}
+			var result []TagStats
+			for bizTag, stats := range DefaultAlloc.Stats() {
+				if want := args.string("biz_tag"); want == "" || want == bizTag {
+					result = append(result, TagStats{BizTag: bizTag, BizStats: stats})
+				}
+			}
+			slices.SortFunc(result, func(a, b TagStats) int { return strings.Compare(a.BizTag, b.BizTag) })
+			return result, nil
+		},
+	},
+	"leases": {
+		args:   map[string]string{"biz_tag": "String"},
+		result: reflect.TypeFor[[]LeaseInfo](),
+		resolve: func(ctx context.Context, args graphqlArgs) (any, error) {
+			return DefaultAlloc.Leases(args.string("biz_tag")), nil
+		},
+	},
+	"version": {
+		result: reflect.TypeFor[BuildInfo](),
+		resolve: func(ctx context.Context, args graphqlArgs) (any, error) {
+			return buildInfo(), nil
+		},
+	},
+}
+
+// string 返回字符串参数, 未指定或为 null 时返回空串
+func (args graphqlArgs) string(name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+// int 返回整数参数, 未指定或为 null 时返回默认值
+func (args graphqlArgs) int(name string, fallback int) int {
+	if value, ok := args[name].(int); ok {
+		return value
+	}
+	return fallback
+}
+
+// handleAdminGraphQL 处理 GraphQL 查询, GET 时查询在 query 参数中, POST 时请求体为 JSON
+func handleAdminGraphQL(w http.ResponseWriter, r *http.Request) {
+	var (
+		req    graphqlRequest // 请求
+		status = http.StatusOK
+		resp   graphqlResponse
+		err    error
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		if value := r.URL.Query().Get("variables"); value != "" {
+			err = json.Unmarshal([]byte(value), &req.Variables)
+		}
+	case http.MethodPost:
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, graphqlMaxBody)).Decode(&req)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		status, resp.Errors = http.StatusBadRequest, []graphqlError{{Message: "invalid request: " + err.Error()}}
+	} else if resp, err = executeGraphQL(r.Context(), req); err != nil {
+		status, resp.Errors = http.StatusBadRequest, []graphqlError{{Message: err.Error()}}
+	}
+
+	// 将响应数据编码为 JSON 并写入响应
+	w.Header().Set("Content-Type", "application/json")
+	if bytes, err := json.Marshal(&resp); err == nil {
+		w.WriteHeader(status)
+		_, _ = w.Write(bytes)
+	} else {
+		logger.Error("encode response failed", "code", CodeResponseEncode, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// executeGraphQL 解析、校验并执行查询; 语法和校验错误时返回错误, 字段执行出错时记录在响应中
+func executeGraphQL(ctx context.Context, req graphqlRequest) (resp graphqlResponse, err error) {
+	var (
+		fields []*graphqlField
+		data   graphqlObject
+	)
+
+	if fields, err = parseGraphQL(req.Query); err != nil {
+		return
+	}
+	for _, field := range fields {
+		if field.name == "__typename" {
+			continue
+		}
+		query, ok := graphqlQueries[field.name]
+		if !ok {
+			return resp, fmt.Errorf("cannot query field %q on type Query", field.name)
+		}
+		for name := range field.args {
+			if _, ok = query.args[name]; !ok {
+				return resp, fmt.Errorf("unknown argument %q on field %q", name, field.name)
+			}
+		}
+		if err = validateGraphQL(field, query.result); err != nil {
+			return
+		}
+	}
+
+	for _, field := range fields {
+		if field.name == "__typename" {
+			data = append(data, graphqlEntry{field.alias, "Query"})
+			continue
+		}
+		var (
+			args   graphqlArgs
+			result any
+		)
+		if args, err = bindGraphQLArgs(field.args, graphqlQueries[field.name].args, req.Variables); err == nil {
+			result, err = graphqlQueries[field.name].resolve(ctx, args)
+		}
+		if err != nil {
+			resp.Errors = append(resp.Errors, graphqlError{Message: err.Error(), Path: []any{field.alias}})
+			data = append(data, graphqlEntry{field.alias, nil})
+			continue
+		}
+		data = append(data, graphqlEntry{field.alias, selectGraphQL(reflect.ValueOf(result), field.selection)})
+	}
+	return graphqlResponse{Data: &data, Errors: resp.Errors}, nil
+}
+
+// bindGraphQLArgs 代入变量并按参数类型检查, 数值参数转换为整数; null 表示未指定
+func bindGraphQLArgs(args map[string]any, types map[string]string, variables map[string]any) (bound graphqlArgs, err error) {
+	bound = graphqlArgs{}
+	for name, value := range args {
+		if ref, ok := value.(graphqlVar); ok {
+			value = variables[string(ref)]
+		}
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			if types[name] == "String" {
+				bound[name] = v
+				continue
+			}
+		case float64: // 字面量和 JSON 变量中的数值
+			if types[name] == "Int" && v == float64(int(v)) {
+				bound[name] = int(v)
+				continue
+			}
+		}
+		return nil, fmt.Errorf("argument %q must be of type %s", name, types[name])
+	}
+	return
+}
+
+// graphqlType 去掉指针和列表后的元素类型
+func graphqlType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// graphqlIsObject 类型是否有子字段; 自定义 JSON 编码的类型(如时间)视为标量
+func graphqlIsObject(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !t.Implements(reflect.TypeFor[json.Marshaler]())
+}
+
+// graphqlFieldName 结构体字段在查询中的名称, 不导出或不编码的字段返回空串
+func graphqlFieldName(field reflect.StructField) string {
+	if !field.IsExported() || field.Anonymous {
+		return ""
+	}
+	if name := field.Tag.Get("graphql"); name != "" {
+		return name
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return cmp.Or(name, field.Name)
+}
+
+// graphqlLookup 按名称查找结构体字段, 包括嵌入结构体中的字段
+func graphqlLookup(t reflect.Type, name string) (reflect.StructField, bool) {
+	for _, field := range reflect.VisibleFields(t) {
+		if graphqlFieldName(field) == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// validateGraphQL 按结果类型校验子字段: 对象必须选择子字段, 标量不能选择子字段
+func validateGraphQL(field *graphqlField, t reflect.Type) error {
+	t = graphqlType(t)
+	if !graphqlIsObject(t) {
+		if len(field.selection) != 0 {
+			return fmt.Errorf("field %q is a scalar and has no subfields", field.name)
+		}
+		return nil
+	}
+	if len(field.selection) == 0 {
+		return fmt.Errorf("field %q of type %s must have a selection of subfields", field.name, t.Name())
+	}
+	for _, sub := range field.selection {
+		if len(sub.args) != 0 {
+			return fmt.Errorf("unknown argument on field %q", sub.name)
+		}
+		if sub.name == "__typename" {
+			continue
+		}
+		structField, ok := graphqlLookup(t, sub.name)
+		if !ok {
+			return fmt.Errorf("cannot query field %q on type %s", sub.name, t.Name())
+		}
+		if err := validateGraphQL(sub, structField.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectGraphQL 按已校验的子字段取出结果
+func selectGraphQL(value reflect.Value, selection []*graphqlField) any {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return nil
+	}
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.Uint8 {
+		list := make([]any, value.Len())
+		for i := range list {
+			list[i] = selectGraphQL(value.Index(i), selection)
+		}
+		return list
+	}
+	if len(selection) == 0 {
+		return value.Interface()
+	}
+
+	object := make(graphqlObject, 0, len(selection))
+	for _, sub := range selection {
+		if sub.name == "__typename" {
+			object = append(object, graphqlEntry{sub.alias, value.Type().Name()})
+			continue
+		}
+		structField, _ := graphqlLookup(value.Type(), sub.name)
+		object = append(object, graphqlEntry{sub.alias, selectGraphQL(value.FieldByIndex(structField.Index), sub.selection)})
+	}
+	return object
+}
+
+// graphqlParser 查询文档的递归下降解析器
+type graphqlParser struct {
+	src string
+	pos int
+}
+
+// parseGraphQL 解析只包含一个查询操作的文档, 返回根字段
+func parseGraphQL(src string) (fields []*graphqlField, err error) {
+	p := &graphqlParser{src: src}
+	defer func() {
+		if r := recover(); r != nil {
+			if syntaxErr, ok := r.(graphqlSyntaxError); ok {
+				fields, err = nil, syntaxErr
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	p.skip()
+	if p.peek() != '{' {
+		switch keyword := p.name(); keyword {
+		case "query":
+		case "mutation", "subscription":
+			p.fail(keyword + " is not supported")
+		default:
+			p.fail("expected query, found " + strconv.Quote(keyword))
+		}
+		if isNameStart(p.peek()) {
+			p.name() // 操作名
+		}
+		if p.peek() == '(' {
+			p.variableDefinitions()
+		}
+	}
+	fields = p.selectionSet()
+	if p.pos < len(p.src) {
+		p.fail("only one operation is supported")
+	}
+	return
+}
+
+// graphqlSyntaxError 解析错误, 解析器内部通过 panic 传递
+type graphqlSyntaxError string
+
+// Error 返回带位置的错误信息
+func (err graphqlSyntaxError) Error() string {
+	return string(err)
+}
+
+// fail 以当前位置报告语法错误
+func (p *graphqlParser) fail(msg string) {
+	panic(graphqlSyntaxError(fmt.Sprintf("syntax error at offset %d: %s", p.pos, msg)))
+}
+
+// skip 跳过空白、逗号和注释
+func (p *graphqlParser) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek 返回下一个字符, 到达结尾时返回 0
+func (p *graphqlParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// expect 读取指定的标点
+func (p *graphqlParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail(fmt.Sprintf("expected %q", c))
+	}
+	p.pos++
+	p.skip()
+}
+
+// isNameStart 是否可以作为名称的首字符
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// name 读取一个名称
+func (p *graphqlParser) name() string {
+	start := p.pos
+	if !isNameStart(p.peek()) {
+		p.fail("expected name")
+	}
+	for p.pos < len(p.src) && (isNameStart(p.src[p.pos]) || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	p.skip()
+	return name
+}
+
+// variableDefinitions 跳过变量定义, 变量的值在执行时从请求中读取, 不检查类型和默认值
+func (p *graphqlParser) variableDefinitions() {
+	p.expect('(')
+	for p.peek() != ')' {
+		p.expect('$')
+		p.name()
+		p.expect(':')
+		p.typeRef()
+		if p.peek() == '=' {
+			p.expect('=')
+			p.value()
+		}
+	}
+	p.expect(')')
+}
+
+// typeRef 跳过类型, 如 String!、[Int]
+func (p *graphqlParser) typeRef() {
+	if p.peek() == '[' {
+		p.expect('[')
+		p.typeRef()
+		p.expect(']')
+	} else {
+		p.name()
+	}
+	if p.peek() == '!' {
+		p.expect('!')
+	}
+}
+
+// selectionSet 读取 { 字段... }
+func (p *graphqlParser) selectionSet() (fields []*graphqlField) {
+	p.expect('{')
+	for p.peek() != '}' {
+		switch p.peek() {
+		case 0:
+			p.fail("unexpected end of query")
+		case '.':
+			p.fail("fragments are not supported")
+		}
+		fields = append(fields, p.field())
+	}
+	if len(fields) == 0 {
+		p.fail("empty selection set")
+	}
+	p.expect('}')
+	return
+}
+
+// field 读取 [别名:] 字段名 [(参数...)] [{ 子字段... }]
+func (p *graphqlParser) field() *graphqlField {
+	field := &graphqlField{name: p.name()}
+	if p.peek() == ':' {
+		p.expect(':')
+		field.alias, field.name = field.name, p.name()
+	}
+	field.alias = cmp.Or(field.alias, field.name)
+	if p.peek() == '(' {
+		p.expect('(')
+		field.args = map[string]any{}
+		for p.peek() != ')' {
+			name := p.name()
+			p.expect(':')
+			field.args[name] = p.value()
+		}
+		p.expect(')')
+	}
+	if p.peek() == '@' {
+		p.fail("directives are not supported")
+	}
+	if p.peek() == '{' {
+		field.selection = p.selectionSet()
+	}
+	return field
+}
+
+// value 读取参数值: 变量、整数、浮点数、字符串、布尔值或 null; 数值统一为 float64, 与 JSON 变量一致
+func (p *graphqlParser) value() any {
+	switch c := p.peek(); {
+	case c == '$':
+		p.expect('$')
+		return graphqlVar(p.name())
+	case c == '"':
+		return p.string()
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && strings.IndexByte("+-.eE0123456789", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		number, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			p.fail("invalid number")
+		}
+		p.skip()
+		return number
+	case isNameStart(c):
+		switch name := p.name(); name {
+		case "true", "false":
+			return name == "true"
+		case "null":
+			return nil
+		default:
+			p.fail("enum values are not supported")
+		}
+	}
+	p.fail("expected value")
+	return nil
+}
+
+// string 读取双引号字符串, 转义规则与 JSON 相同
+func (p *graphqlParser) string() string {
+	start := p.pos
+	for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+	}
+	if p.pos >= len(p.src) {
+		p.fail("unterminated string")
+	}
+	p.pos++
+	var value string
+	if err := json.Unmarshal([]byte(p.src[start:p.pos]), &value); err != nil {
+		p.fail("invalid string")
+	}
+	p.skip()
+	return value
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGraphQL(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Stats.Enable = true
+	DefaultAlloc = newTestAlloc(t, newFakeStorage(100))
+	DefaultData, ledger = nil, nil
+	if _, err := DefaultAlloc.loadOrCreate("test").nextId(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DefaultAlloc.Lease(context.Background(), "test", "svc", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"query": "query Admin($tag: String) { __typename leases(biz_tag: $tag) { caller left right }` +
+		` s: stats(biz_tag: $tag) { biz_tag remaining last_minute { allocs } } tags { biz_tag } }",` +
+		` "variables": {"tag": "test"}}`
+	w := httptest.NewRecorder()
+	handleAdminGraphQL(w, httptest.NewRequest(http.MethodPost, "/admin/graphql", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+
+	// 字段按查询顺序输出, 未启用 MySQL 时 tags 为 null 并报告错误, 不影响其他字段
+	want := `{"data":{"__typename":"Query","leases":[{"caller":"svc","left":200,"right":300}],` +
+		`"s":[{"biz_tag":"test","remaining":199,"last_minute":{"allocs":0}}],"tags":null},` +
+		`"errors":[{"message":"tags need the mysql store","path":["tags"]}]}`
+	if got := w.Body.String(); got != want {
+		t.Fatalf("response\n%s\nwant\n%s", got, want)
+	}
+
+	// GET 请求, 字面量参数
+	w = httptest.NewRecorder()
+	query := url.Values{"query": {`{ leases(biz_tag: "other") { lease_id } version { __typename go_version } }`}}
+	handleAdminGraphQL(w, httptest.NewRequest(http.MethodGet, "/admin/graphql?"+query.Encode(), nil))
+	var resp struct {
+		Data struct {
+			Leases  []any             `json:"leases"`
+			Version map[string]string `json:"version"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if len(resp.Data.Leases) != 0 || resp.Data.Version["__typename"] != "BuildInfo" || resp.Data.Version["go_version"] == "" {
+		t.Fatalf("response %s", w.Body.String())
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	setupTestConfig(t)
+	DefaultAlloc = newTestAlloc(t, newFakeStorage(100))
+
+	for query, want := range map[string]string{
+		`mutation { tags { biz_tag } }`:              "mutation is not supported",
+		`{ leases { ...fields } }`:                   "fragments are not supported",
+		`{ leases(biz_tag: "test) { caller } }`:      "unterminated string",
+		`{ leases { caller } } { tags { biz_tag } }`: "only one operation is supported",
+		`{ users { name } }`:                         `cannot query field "users" on type Query`,
+		`{ leases { owner } }`:                       `cannot query field "owner" on type LeaseInfo`,
+		`{ leases }`:                                 `field "leases" of type LeaseInfo must have a selection of subfields`,
+		`{ leases { left { value } } }`:              `field "left" is a scalar and has no subfields`,
+		`{ leases(limit: 10) { left } }`:             `unknown argument "limit" on field "leases"`,
+		`{ stats { biz_tag 1m { allocs } } }`:        "expected name",
+	} {
+		if _, err := executeGraphQL(context.Background(), graphqlRequest{Query: query}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", query, err, want)
+		}
+	}
+
+	// 参数类型错误在执行时报告, 只影响该字段
+	ledger = &segmentLedger{}
+	defer func() { ledger = nil }()
+	resp, err := executeGraphQL(context.Background(), graphqlRequest{Query: `{ segments(limit: "ten") { left } }`})
+	if err != nil || len(resp.Errors) != 1 || resp.Errors[0].Message != `argument "limit" must be of type Int` {
+		t.Fatalf("resp = %+v, err = %v", resp, err)
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminGraphQL(w, httptest.NewRequest(http.MethodPost, "/admin/graphql", strings.NewReader(`{"query": "{ nope }"}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"errors"`) || strings.Contains(w.Body.String(), `"data"`) {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+}
@@ -1,53 +1,136 @@
 package core
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"strconv"
 	"time"
 )
 
+// 响应中的错误码
+const (
+	ErrNoFailed        = -1  // 处理失败
+	ErrNoTimeout       = -2  // 处理超过请求时限
+	ErrNoUnauthorized  = -3  // 调用方未认证
+	ErrNoForbidden     = -4  // 调用方无权访问该业务
+	ErrNoRateLimited   = -5  // 业务请求超过限流
+	ErrNoOverloaded    = -6  // 服务器过载, 请求被拒绝
+	ErrNoInvalidBizTag = -7  // 业务标识不符合校验规则
+	ErrNoStandby       = -8  // 本实例是备用实例, 请求应发往主实例
+	ErrNoIdExhausted   = -9  // 业务的号码已达到上限
+	ErrNoArchived      = -10 // 业务已归档
+	ErrNoBizTagUnknown = -11 // 号段存储中不存在该业务
+	ErrNoQuotaExceeded = -12 // 业务超过分配配额
+)
+
+// 响应中的错误原因, 客户端按原因和 retryable 决定是否重试, 无需匹配 msg 文本
+const (
+	CauseParam     = "param"     // 请求参数错误或业务不存在, 重试不会成功
+	CauseAuth      = "auth"      // 调用方未认证或无权访问该业务, 重试不会成功
+	CauseLimited   = "limited"   // 超过限流、配额或服务器过载, 稍后重试
+	CauseStandby   = "standby"   // 本实例是备用实例, 换一个地址重试
+	CauseStorage   = "storage"   // 号段存储不可用或号段暂时耗尽, 稍后或换一个地址重试
+	CauseExhausted = "exhausted" // 业务的号码已达到上限或业务已归档, 重试不会成功
+	CauseTimeout   = "timeout"   // 处理超过请求时限, 可以重试
+)
+
 // AllocResponse 用于封装分配ID请求的响应
 type AllocResponse struct {
-	ErrNo int    `json:"err_no"` // 错误码
-	Msg   string `json:"msg"`    // 错误或成功消息
-	ID    int64  `json:"id"`     // 分配的ID
+	ErrNo     int    `json:"err_no"`               // 错误码
+	Msg       string `json:"msg"`                  // 错误或成功消息
+	ID        int64  `json:"id"`                   // 分配的ID
+	Retryable bool   `json:"retryable,omitempty"`  // 失败时重试是否可能成功, 缺省为 false
+	Cause     string `json:"cause,omitempty"`      // 失败时的错误原因, 取值见 Cause 常量
+	RequestID string `json:"request_id,omitempty"` // 失败时返回请求 ID, 便于与服务端日志对照
+}
+
+// paramError 请求参数缺失或无法解析
+type paramError string
+
+func (err paramError) Error() string {
+	return string(err)
+}
+
+// errorCause 按错误、错误码和 HTTP 状态码返回错误原因, 以及重试是否可能成功; 中间件拦截的错误 err 为 nil
+func errorCause(err error, status int, errNo int) (cause string, retryable bool) {
+	if errors.As(err, new(paramError)) {
+		return CauseParam, false
+	}
+	switch errNo {
+	case ErrNoTimeout:
+		return CauseTimeout, true
+	case ErrNoUnauthorized, ErrNoForbidden:
+		return CauseAuth, false
+	case ErrNoRateLimited, ErrNoOverloaded, ErrNoQuotaExceeded:
+		return CauseLimited, true
+	case ErrNoInvalidBizTag, ErrNoBizTagUnknown:
+		return CauseParam, false
+	case ErrNoStandby:
+		return CauseStandby, true
+	case ErrNoIdExhausted, ErrNoArchived:
+		return CauseExhausted, false
+	}
+	if status >= http.StatusBadRequest && status < http.StatusInternalServerError { // 租约或预留不存在等
+		return CauseParam, false
+	}
+	return CauseStorage, true
 }
 
 // HealthResponse 用于封装健康检查请求的响应
 type HealthResponse struct {
-	ErrNo int    `json:"err_no"` // 错误码
-	Msg   string `json:"msg"`    // 错误或成功消息
-	Left  int64  `json:"left"`   // 剩余ID数量
+	ErrNo  int           `json:"err_no"`           // 错误码
+	Msg    string        `json:"msg"`              // 错误或成功消息
+	Left   int64         `json:"left"`             // 剩余ID数量
+	Refill *RefillStatus `json:"refill,omitempty"` // 补偿线程状态, 从未失败过时省略
+	Role   string        `json:"role,omitempty"`   // 启用选主时为 leader 或 standby
 }
 
 // handleAlloc 处理分配 ID 的 HTTP 请求
 func handleAlloc(w http.ResponseWriter, r *http.Request) {
+	serveAlloc(DefaultAlloc, bizTagValidator, peers, w, r)
+}
+
+// serveAlloc 使用指定的分配器和业务标识校验规则处理分配 ID 的请求, 本地号段存储不可用时由 forwarder 转发, 为 nil 时不转发
+func serveAlloc(alloc *Alloc, rule *bizTagRule, forwarder *peerForwarder, w http.ResponseWriter, r *http.Request) {
 	var (
-		resp   = AllocResponse{} // 响应数据
-		err    error             // 错误信息
-		bytes  []byte            // 响应数据的JSON字节数组
-		bizTag string            // 业务标签
+		resp      = AllocResponse{} // 响应数据
+		err       error             // 错误信息
+		bytes     []byte            // 响应数据的JSON字节数组
+		bizTag    string            // 业务标签
+		startTime = alloc.now()     // 开始处理的时间
 	)
 
+	// 记录处理耗时, 供 /stats 统计
+	defer func() { alloc.recordLatency(bizTag, startTime) }()
+
 	// 解析请求参数
 	if err = r.ParseForm(); err != nil {
+		err = paramError(err.Error())
 		goto RESP // 解析失败则跳转到响应逻辑
 	}
 
 	// 获取并验证 biz_tag 参数
 	if bizTag = r.Form.Get("biz_tag"); bizTag == "" {
-		err = errors.New("need biz_tag param") // 缺少biz_tag参数
+		err = paramError("need biz_tag param") // 缺少biz_tag参数
+		goto RESP
+	}
+
+	// 校验 biz_tag, 无效的业务不进入分配器
+	if err = rule.validate(bizTag); err != nil {
 		goto RESP
 	}
 
 	// 循环分配ID，确保ID不为0
 	for {
-		if resp.ID, err = DefaultAlloc.NextId(bizTag); err != nil {
-			goto RESP // 分配ID出错则跳转到响应逻辑
+		if resp.ID, err = alloc.NextId(r.Context(), bizTag); err != nil {
+			// 本地号段存储不可用时由对等实例分配
+			if resp.ID, err = forwarder.forwardAlloc(r, bizTag, err); err != nil {
+				goto RESP // 分配ID出错则跳转到响应逻辑
+			}
 		}
 		if resp.ID != 0 { // 跳过ID为0的情况
 			break
@@ -57,9 +140,12 @@ func handleAlloc(w http.ResponseWriter, r *http.Request) {
 RESP:
 	// 设置响应信息和状态码
 	if err != nil {
-		resp.ErrNo = -1                               // 错误码
-		resp.Msg = fmt.Sprintf("%v", err)             // 错误信息
-		w.WriteHeader(http.StatusInternalServerError) // 设置HTTP500错误码
+		status, errNo, msg := allocFailure(r.Context(), bizTag, err)
+		resp.ErrNo = errNo                                          // 错误码
+		resp.Msg = msg                                              // 错误信息
+		resp.Cause, resp.Retryable = errorCause(err, status, errNo) // 错误原因和是否可以重试
+		resp.RequestID = w.Header().Get(requestIDHeader)            // 请求 ID
+		w.WriteHeader(status)                                       // 设置HTTP错误码
 	} else {
 		resp.Msg = "success" // 成功消息
 	}
@@ -68,12 +154,42 @@ RESP:
 	if bytes, err = json.Marshal(&resp); err == nil {
 		_, _ = w.Write(bytes) // 写入响应数据
 	} else {
+		logger.Error("encode response failed", "code", CodeResponseEncode, "err", err)
 		w.WriteHeader(http.StatusInternalServerError) // JSON 编码失败返回 HTTP 500
 	}
 }
 
+// allocFailure 将分配失败的错误映射为 HTTP 状态码、错误码和错误信息, 并记录日志
+func allocFailure(ctx context.Context, bizTag string, err error) (status int, errNo int, msg string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Warn("alloc timeout", "code", CodeRequestTimeout, "biz_tag", bizTag, "request_id", RequestID(ctx), "err", err)
+		return http.StatusGatewayTimeout, ErrNoTimeout, "request timeout"
+	case errors.Is(err, ErrInvalidBizTag):
+		return http.StatusBadRequest, ErrNoInvalidBizTag, err.Error()
+	case errors.Is(err, ErrIdExhausted):
+		return http.StatusGone, ErrNoIdExhausted, err.Error() // 各实例共用同一个号段行, 重试不会成功
+	case errors.Is(err, ErrBizTagArchived):
+		return http.StatusGone, ErrNoArchived, err.Error()
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusTooManyRequests, ErrNoQuotaExceeded, err.Error()
+	case errors.Is(err, ErrTooManyWaiters):
+		return http.StatusServiceUnavailable, ErrNoOverloaded, err.Error()
+	case errors.Is(err, ErrBizTagNotFound):
+		return http.StatusNotFound, ErrNoBizTagUnknown, err.Error() // 不输出日志, 配置错误的客户端反复请求时避免刷屏
+	default:
+		logger.Warn("alloc failed", "code", CodeAllocFail, "biz_tag", bizTag, "request_id", RequestID(ctx), "err", err)
+		return http.StatusInternalServerError, ErrNoFailed, fmt.Sprintf("%v", err)
+	}
+}
+
 // handleHealth 处理健康检查的 HTTP 请求
 func handleHealth(w http.ResponseWriter, r *http.Request) {
+	serveHealth(DefaultAlloc, election, w, r)
+}
+
+// serveHealth 使用指定的分配器处理健康检查请求, 按 elect 报告本实例的角色, 为 nil 表示不参与选主
+func serveHealth(alloc *Alloc, elect *elector, w http.ResponseWriter, r *http.Request) {
 	var (
 		resp   = HealthResponse{} // 响应数据
 		err    error              // 错误信息
@@ -91,17 +207,31 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 		goto RESP
 	}
 
+	// 备用实例不分配 ID, 负载均衡应将请求发往主实例
+	if resp.Role = elect.role(); elect.standby() {
+		err = ErrStandby
+		goto RESP
+	}
+
 	// 查询剩余 ID 数量
-	resp.Left = DefaultAlloc.LeftCount(bizTag)
+	resp.Left = alloc.LeftCount(bizTag)
+	resp.Refill = alloc.RefillStatus(bizTag)
 	if resp.Left == 0 { // 没有剩余 ID
-		err = errors.New("no available id")
+		if resp.Refill != nil && resp.Refill.Failing { // 补偿线程已放弃, 带上失败原因
+			err = fmt.Errorf("no available id, refill failing: %s", resp.Refill.LastError)
+		} else {
+			err = errors.New("no available id")
+		}
 		goto RESP
 	}
 
 RESP:
 	// 设置响应信息和状态码
-	if err != nil {
-		resp.ErrNo = -1                               // 错误码
+	if errors.Is(err, ErrStandby) {
+		resp.ErrNo, resp.Msg = ErrNoStandby, "standby"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if err != nil {
+		resp.ErrNo = ErrNoFailed                      // 错误码
 		resp.Msg = fmt.Sprintf("%v", err)             // 错误信息
 		w.WriteHeader(http.StatusInternalServerError) // 设置 HTTP 500 错误码
 	} else {
@@ -112,30 +242,317 @@ RESP:
 	if bytes, err := json.Marshal(&resp); err == nil {
 		_, _ = w.Write(bytes) // 写入响应数据
 	} else {
+		logger.Error("encode response failed", "code", CodeResponseEncode, "err", err)
 		w.WriteHeader(http.StatusInternalServerError) // JSON 编码失败返回 HTTP 500
 	}
 }
 
-// StartServer 启动 HTTP 服务器
+var (
+	httpServer    *http.Server // 分配 ID 的 HTTP 服务器
+	adminServer   *http.Server // 管理端口的 HTTP 服务器, 未启用时为 nil
+	serverErrChan chan error   // 服务器异常退出的错误
+)
+
+// ServerOptions 启动服务器时注入的组件, 零值表示按配置监听端口
+type ServerOptions struct {
+	Listener      net.Listener                      // 分配端口的监听, 为 nil 时监听 http_listen 或 http_port
+	FastListener  net.Listener                      // 高性能分配端口的监听, 设置后即使 fast.enable 为 false 也启动
+	AdminListener net.Listener                      // 管理端口的监听, 设置后即使 admin.port 为 0 也启动
+	Middleware    []func(http.Handler) http.Handler // 包装在分配端口最外层的中间件, 第一个在最外层
+}
+
+// StartServer 按配置启动 HTTP 服务器, 监听成功后立即返回
 func StartServer() error {
+	return StartServerWith(ServerOptions{})
+}
+
+// StartServerWith 使用注入的监听和中间件启动 HTTP 服务器, 监听成功后立即返回
+func StartServerWith(opts ServerOptions) error {
+	fastServer, adminServer = nil, nil // 上一次启动的服务器已经退出
+
+	// 创建调用方认证
+	auths, err := newAuthenticators()
+	if err != nil {
+		return err // 认证初始化失败返回错误
+	}
+
+	// 由 systemd 套接字激活时使用传入的监听
+	if err = inheritSystemdListeners(&opts); err != nil {
+		return err
+	}
+	alloc, health, fast, lease, reserve := handleAlloc, handleHealth, handleAllocFast, handleLease, handleReserve
+	stats, events, metrics := handleStats, handleEvents, handleMetrics
+
+	// 限制同时处理的分配请求数, 放在认证和限流之后, 被拒绝的请求不占用槽位
+	if DefaultConfig.Concurrency.MaxInflight > 0 {
+		inflight = newInflightLimiter(DefaultConfig.Concurrency)
+		alloc, fast, lease, reserve = withInflightLimit(alloc), withInflightLimit(fast), withInflightLimit(lease), withInflightLimit(reserve)
+	}
+
+	// 创建按业务的限流器, 限流在认证之后, 未认证的请求不消耗令牌
+	if DefaultConfig.RateLimit.Enable {
+		if limiter, err = newRateLimiter(DefaultConfig.RateLimit); err != nil {
+			return err // 限流初始化失败返回错误
+		}
+		alloc, fast, lease, reserve = withRateLimit(alloc), withRateLimit(fast), withRateLimit(lease), withRateLimit(reserve)
+	}
+
+	// 命名空间的配额在按业务限流之前检查, 同一命名空间的业务共享配额
+	if namespaces, err = newNamespaceRegistry(DefaultConfig); err != nil {
+		return err // 命名空间配置错误返回错误
+	}
+	if namespaces != nil {
+		alloc, fast, lease, reserve = withNamespaceQuota(alloc), withNamespaceQuota(fast), withNamespaceQuota(lease), withNamespaceQuota(reserve)
+	}
+
+	// 幂等键在限流之前检查, 重试命中时不消耗令牌
+	if DefaultConfig.Idempotency.Enable {
+		idempotency = newIdempotencyStore(DefaultConfig.Idempotency)
+		alloc = withIdempotency(DefaultConfig.Idempotency.Header, alloc)
+	}
+	if DefaultConfig.Auth.Enable {
+		alloc, health, fast, lease = withAuth(auths, alloc), withAuth(auths, health), withAuth(auths, fast), withAuth(auths, lease)
+		reserve, stats, events = withAuth(auths, reserve), withAuth(auths, stats), withAuth(auths, events)
+		metrics = withAuth(auths, metrics) // 指标包含全部业务的名称和状态
+	}
+
+	// 命名空间在认证之前解析, 认证、限流和分配都使用带命名空间前缀的业务标识
+	if namespaces != nil {
+		alloc, health, fast, lease = withNamespace(alloc), withNamespace(health), withNamespace(fast), withNamespace(lease)
+		reserve, stats, events = withNamespace(reserve), withNamespace(stats), withNamespace(events)
+	}
+
+	// 备用实例在最外层拒绝请求, 不消耗令牌和幂等键
+	if DefaultConfig.Election.Enable {
+		alloc, fast, lease, reserve = withLeader(alloc), withLeader(fast), withLeader(lease), withLeader(reserve)
+	}
+
+	// 本地号段存储不可用时转发给对等实例
+	peers = newPeerForwarder(DefaultConfig.Peer, DefaultConfig.Auth)
+
+	// 与对等实例交换号段容量状态
+	cluster = newClusterView(DefaultConfig, DefaultAlloc)
+
+	// 预加载就绪探针要求的业务
+	draining.Store(false)
+	warmReadyTags(DefaultAlloc)
+
+	// 编译业务标识校验规则
+	if bizTagValidator, err = newBizTagRule(DefaultConfig.BizTag); err != nil {
+		return err // 规则编译失败返回错误
+	}
+
+	// 检查服务注册配置
+	if err = checkRegistry(DefaultConfig); err != nil {
+		return err
+	}
+
+	// 解析来源地址规则
+	filter, err := newIPFilter(DefaultConfig.IPFilter.Alloc)
+	if err != nil {
+		return err // 规则解析失败返回错误
+	}
+	if err = checkAccessLog(DefaultConfig.AccessLog); err != nil {
+		return err
+	}
+	if err = checkHTTP2(DefaultConfig.HTTP2); err != nil {
+		return err
+	}
+	if err = checkCORS(DefaultConfig.CORS); err != nil {
+		return err
+	}
+	if err = checkListen(DefaultConfig); err != nil {
+		return err
+	}
+
 	// 创建 HTTP 路由多路复用器
 	mux := http.NewServeMux()
-	mux.HandleFunc("/alloc", handleAlloc)   // 路由分配 ID 请求
-	mux.HandleFunc("/health", handleHealth) // 路由健康检查请求
+	mux.HandleFunc("/alloc", withTrace("/alloc", alloc))    // 路由分配 ID 请求
+	mux.HandleFunc("/health", withTrace("/health", health)) // 路由健康检查请求
+	mux.HandleFunc("/metrics", metrics)                     // 路由 Prometheus 指标抓取请求
+	mux.HandleFunc("/version", handleVersion)               // 路由构建信息查询请求
+	if DefaultConfig.Stats.Enable {
+		mux.HandleFunc("/stats", stats) // 路由滑动窗口统计请求
+	}
+	if eventBus != nil {
+		mux.HandleFunc("/events", events) // 路由分配器事件流
+	}
+	if DefaultConfig.Lease.Enable {
+		mux.HandleFunc("/lease", withTrace("/lease", lease))                 // 路由租用号段请求
+		mux.HandleFunc("/lease/renew", withTrace("/lease/renew", lease))     // 路由续约请求
+		mux.HandleFunc("/lease/release", withTrace("/lease/release", lease)) // 路由归还租约请求
+	}
+	if DefaultConfig.Kubernetes.Enable {
+		mux.HandleFunc("/livez", handleLivez)   // 路由存活探针
+		mux.HandleFunc("/readyz", handleReadyz) // 路由就绪探针
+	}
+	if DefaultConfig.Reserve.Enable {
+		mux.HandleFunc("/reserve", withTrace("/reserve", reserve))                 // 路由预留 ID 请求
+		mux.HandleFunc("/reserve/confirm", withTrace("/reserve/confirm", reserve)) // 路由确认预留请求
+		mux.HandleFunc("/reserve/release", withTrace("/reserve/release", reserve)) // 路由释放预留请求
+	}
+
+	// 路由处理器(带请求 ID、访问日志、响应压缩、panic 恢复、来源地址过滤、跨域、请求时限和调用方指定的等待时间), 注入的中间件在最外层
+	handler := withRequestID(withAccessLog(withGzip(withRecover(withIPFilter(filter, withCORS(withTimeout(withWaitHint(mux))))))))
+	for i := len(opts.Middleware) - 1; i >= 0; i-- {
+		handler = opts.Middleware[i](handler)
+	}
 
 	// 初始化 HTTP 服务器
-	srv := &http.Server{
+	httpServer = &http.Server{
 		ReadTimeout:  time.Duration(DefaultConfig.HttpReadTimeout) * time.Millisecond,  // 读取超时时间
 		WriteTimeout: time.Duration(DefaultConfig.HttpWriteTimeout) * time.Millisecond, // 写入超时时间
-		Handler:      mux,                                                              // 路由处理器
+		Handler:      handler,
 	}
 
-	// 设置服务器监听端口
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(DefaultConfig.HttpPort))
-	if err != nil {
-		return err // 监听失败返回错误
+	// 开始监听前预热业务, 发布后的第一个请求不必等待获取号段
+	preload(DefaultAlloc, DefaultConfig)
+
+	// 设置服务器监听端口, 退出时先断开事件流的长连接
+	tuneServer(httpServer)
+	if eventBus != nil {
+		httpServer.RegisterOnShutdown(eventBus.disconnect)
+	}
+	listeners := []net.Listener{opts.Listener}
+	if opts.Listener == nil {
+		if listeners, err = listenAll(DefaultConfig.HttpListen, DefaultConfig.HttpPort); err != nil {
+			return err // 监听失败返回错误
+		}
+	}
+
+	// 任意一个服务异常退出都视为服务器退出
+	serverErrChan = make(chan error, 4)
+
+	// 启用 HTTPS 时在监听之上完成 TLS 握手
+	var tlsConfig *tls.Config
+	if DefaultConfig.TLS.Enable {
+		if tlsConfig, err = newTLSConfig(serverErrChan); err != nil {
+			closeAll(listeners)
+			return err // 证书加载失败返回错误
+		}
+		for i := range listeners {
+			listeners[i] = tls.NewListener(listeners[i], tlsConfig)
+		}
+	}
+	if err = configureHTTP2(httpServer, tlsConfig); err != nil {
+		closeAll(listeners)
+		return err
+	}
+
+	// 启动高性能分配端口, 与主端口共用 TLS 配置
+	if DefaultConfig.Fast.Enable || opts.FastListener != nil {
+		if fastServer, err = startFastServer(serverErrChan, opts.FastListener, fast, filter, tlsConfig); err != nil {
+			closeAll(listeners)
+			return err // 高性能端口监听失败返回错误
+		}
+	}
+
+	// 启动管理端口
+	if DefaultConfig.Admin.Port > 0 || len(DefaultConfig.Admin.Listen) != 0 || opts.AdminListener != nil {
+		if adminServer, err = startAdminServer(serverErrChan, opts.AdminListener, auths); err != nil {
+			closeAll(listeners)
+			if fastServer != nil {
+				fastServer.Close()
+			}
+			return err // 管理端口监听失败返回错误
+		}
 	}
 
 	// 启动 HTTP 服务器
-	return srv.Serve(listener)
+	logger.Info("http server started", "addr", listenerAddrs(listeners), "tls", DefaultConfig.TLS.Enable)
+	serveAll(httpServer, listeners, serverErrChan)
+
+	// 开始监听后注册服务, 并通知 systemd 启动完成
+	startRegistry(DefaultConfig)
+	sdNotify("READY=1\nSTATUS=serving on " + listenerAddrs(listeners))
+	return nil
+}
+
+// ServerErrors 返回服务器异常退出时的错误通道
+func ServerErrors() <-chan error {
+	return serverErrChan
+}
+
+// Shutdown 优雅退出: 注销服务, 停止接收新连接并等待处理中的请求完成,
+// 再释放选主锁并等待补偿线程结束, 最后关闭数据库连接池, 整个过程不超过配置的宽限期
+func Shutdown() (err error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(DefaultConfig.ShutdownTimeout)*time.Millisecond)
+	defer cancelFunc()
+
+	// 通知 systemd 正在退出, 最先让就绪探针失败并注销服务, 等待负载均衡和客户端摘除本实例后再停止监听
+	sdNotify("STOPPING=1")
+	drain(ctx)
+
+	// 停止 HTTP 服务器, 等待处理中的 /alloc 请求完成
+	if httpServer != nil {
+		if err = httpServer.Shutdown(ctx); err != nil {
+			logger.Warn("http server shutdown incomplete", "err", err)
+		}
+	}
+	if fastServer != nil {
+		if err = fastServer.Shutdown(ctx); err != nil {
+			logger.Warn("fast alloc server shutdown incomplete", "err", err)
+		}
+	}
+	if adminServer != nil {
+		_ = adminServer.Shutdown(ctx)
+	}
+	if acmeServer != nil {
+		_ = acmeServer.Shutdown(ctx)
+	}
+
+	// 停止交换号段容量状态
+	if cluster != nil {
+		cluster.stop()
+	}
+
+	// 停止重新加载限流配置、API key 和 JWKS
+	if limiter != nil {
+		limiter.stop()
+		limiter = nil
+	}
+	if apiKeys != nil {
+		apiKeys.stop()
+		apiKeys = nil
+	}
+	if jwtKeys != nil {
+		jwtKeys.stop()
+		jwtKeys = nil
+	}
+
+	// 释放选主锁, 备用实例立即接管
+	if election != nil {
+		if err = election.stop(ctx); err != nil {
+			logger.Warn("release election lock failed", "err", err)
+		}
+	}
+
+	// 等待补偿线程结束, 超过宽限期则取消
+	if DefaultAlloc != nil {
+		if err = DefaultAlloc.Close(ctx); err != nil {
+			logger.Warn("refill goroutines cancelled", "err", err)
+		}
+	}
+
+	// 补偿线程结束后写入最后一个周期的使用统计
+	if usage != nil {
+		usage.close()
+		usage = nil
+	}
+
+	// 补偿线程结束后不再写入台账
+	if ledger != nil {
+		if err = ledger.close(); err != nil {
+			logger.Warn("close ledger file failed", "err", err)
+		}
+	}
+
+	// 关闭数据库连接池或释放号段文件
+	if DefaultStore != nil {
+		if err = DefaultStore.Close(); err != nil {
+			return err
+		}
+	}
+	logger.Info("server shutdown complete")
+	return nil
 }
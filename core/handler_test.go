@@ -0,0 +1,197 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setupHandlerTest 使用内存号段存储初始化全局分配器和业务标识校验规则
+func setupHandlerTest(tb testing.TB) {
+	tb.Helper()
+
+	setupTestConfig(tb)
+	DefaultAlloc = newTestAlloc(tb, newFakeStorage(1000))
+
+	var err error
+	if bizTagValidator, err = newBizTagRule(BizTagConfig{Pattern: `^[A-Za-z0-9_.:-]+$`, MaxLength: 32}); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// newFuzzRequest 构造查询字符串和表单都可能非法的请求, 不经过 URL 解析
+func newFuzzRequest(path string, query string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.URL.RawQuery = query
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// fuzzSeeds 畸形表单、超长或非法 biz_tag 和恶意查询字符串
+var fuzzSeeds = []struct{ query, body string }{
+	{"biz_tag=test", ""},
+	{"", "biz_tag=test"},
+	{"biz_tag=", ""},
+	{"biz_tag=%zz", ""},
+	{"biz_tag=%00", ""},
+	{"biz_tag=a;b", ""},
+	{"biz_tag=" + strings.Repeat("a", 4096), ""},
+	{"biz_tag=%E4%B8%AD%E6%96%87", ""},
+	{"biz_tag=test&biz_tag=other", "biz_tag=third"},
+	{"biz_tag=..%2F..%2Fetc%2Fpasswd", ""},
+	{"biz_tag=%22%7D%7B", ""},
+	{"&&&==&", "%%%"},
+	{"biz_tag=test", "biz_tag=%ff%fe"},
+}
+
+// FuzzHandleAlloc 补偿线程在后台运行, 覆盖率不完全确定, 建议缩短最小化时间, 例如
+// go test -run '^$' -fuzz '^FuzzHandleAlloc$' -fuzztime 1m -fuzzminimizetime 5s ./core
+func FuzzHandleAlloc(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed.query, seed.body)
+	}
+	setupHandlerTest(f)
+
+	f.Fuzz(func(t *testing.T, query string, body string) {
+		w := httptest.NewRecorder()
+		handleAlloc(w, newFuzzRequest("/alloc", query, body))
+
+		var resp AllocResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("response is not valid JSON: %v: %q", err, w.Body.String())
+		}
+		switch w.Code {
+		case http.StatusOK:
+			if resp.ErrNo != 0 || resp.ID == 0 {
+				t.Fatalf("success response %+v", resp)
+			}
+		case http.StatusBadRequest, http.StatusInternalServerError:
+			if resp.ErrNo == 0 || resp.Msg == "" || resp.Cause == "" {
+				t.Fatalf("error response %+v with status %d", resp, w.Code)
+			}
+		default:
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+	})
+}
+
+func TestAllocErrorCause(t *testing.T) {
+	setupHandlerTest(t)
+	storage := newFakeStorage(10)
+	DefaultAlloc = newTestAlloc(t, storage)
+
+	cases := []struct {
+		name      string
+		query     string
+		storeErr  error
+		cause     string
+		retryable bool
+	}{
+		{"missing biz_tag", "", nil, CauseParam, false},
+		{"invalid biz_tag", "biz_tag=a%20b", nil, CauseParam, false},
+		{"unknown biz_tag", "biz_tag=gone", ErrBizTagNotFound, CauseParam, false},
+		{"exhausted", "biz_tag=full", ErrIdExhausted, CauseExhausted, false},
+		{"storage down", "biz_tag=down", errors.New("db down"), CauseStorage, true},
+	}
+	for _, c := range cases {
+		storage.setErr(c.storeErr)
+		w := httptest.NewRecorder()
+		handleAlloc(w, httptest.NewRequest(http.MethodGet, "/alloc?"+c.query, nil))
+
+		var resp AllocResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if resp.Cause != c.cause || resp.Retryable != c.retryable {
+			t.Errorf("%s: cause %q retryable %v, want %q %v", c.name, resp.Cause, resp.Retryable, c.cause, c.retryable)
+		}
+	}
+
+	// 中间件拦截的错误按错误码判断, 成功响应不带错误原因
+	w := httptest.NewRecorder()
+	writeError(w, http.StatusServiceUnavailable, ErrNoStandby, "standby")
+	if body := w.Body.String(); !strings.Contains(body, `"retryable":true,"cause":"standby"`) {
+		t.Errorf("standby response %s, want retryable standby cause", body)
+	}
+	storage.setErr(nil)
+	w = httptest.NewRecorder()
+	handleAlloc(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=ok", nil))
+	if body := w.Body.String(); strings.Contains(body, "cause") || strings.Contains(body, "retryable") {
+		t.Errorf("success response %s carries error fields", body)
+	}
+}
+
+func FuzzHandleHealth(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed.query, seed.body)
+	}
+	setupHandlerTest(f)
+
+	f.Fuzz(func(t *testing.T, query string, body string) {
+		w := httptest.NewRecorder()
+		handleHealth(w, newFuzzRequest("/health", query, body))
+
+		var resp HealthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("response is not valid JSON: %v: %q", err, w.Body.String())
+		}
+		if (w.Code == http.StatusOK) != (resp.ErrNo == 0) {
+			t.Fatalf("status %d with response %+v", w.Code, resp)
+		}
+	})
+}
+
+func FuzzQueryValue(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed.query)
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		got := queryValue(query, "biz_tag")
+
+		// 与标准库解析的第一个值一致, 标准库因其他参数非法而报错时不比较
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return
+		}
+		if want := values.Get("biz_tag"); got != want {
+			t.Fatalf("queryValue(%q) = %q, want %q", query, got, want)
+		}
+	})
+}
+
+func TestMetricsAuth(t *testing.T) {
+	setupHandlerTest(t)
+	DefaultConfig.Auth = AuthConfig{Enable: true, Header: "X-Api-Key", Keys: []APIKey{{Key: "team", Name: "team", BizTags: []string{"test"}}}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = StartServerWith(ServerOptions{Listener: listener}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = httpServer.Close()
+		apiKeys.stop()
+		apiKeys, httpServer = nil, nil
+	})
+
+	// 指标包含全部业务的名称和状态, 启用认证时未认证的调用方不能抓取
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "bad": http.StatusUnauthorized, "team": http.StatusOK} {
+		r, _ := http.NewRequest(http.MethodGet, "http://"+listener.Addr().String()+"/metrics", nil)
+		r.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("key %q: GET /metrics = %d, want %d", key, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+)
+
+// Hooks 分配流程中的扩展点, 部署方可以注册自己的策略(打标签、配额计数、ID 变换等), 未设置的字段跳过
+// 钩子在请求协程或补偿线程中同步执行, 应尽快返回; PreAlloc 和 PostAlloc 每次分配都会执行, 直接增加分配延迟
+type Hooks struct {
+	PreAlloc       func(ctx context.Context, bizTag string) error                    // 分配前执行, 返回错误时拒绝本次分配, 不消耗号码
+	PostAlloc      func(ctx context.Context, bizTag string, id int64) (int64, error) // 分配成功后执行, 返回值作为最终的 ID; 返回错误时本次分配失败, 号码作废
+	OnSegmentFetch func(ctx context.Context, bizTag string, left int64, right int64) // 从号段存储获取到 [left, right) 后执行, 包括租出的号段
+	OnError        func(ctx context.Context, bizTag string, err error)               // 分配或获取号段失败时执行, 包括被钩子拒绝的分配
+}
+
+// hookChain 按注册顺序执行的钩子, 为 nil 时什么也不做
+type hookChain []Hooks
+
+// Use 注册钩子, 按注册顺序执行; 可以在分配器运行时注册, 已开始的分配不受影响
+func (alloc *Alloc) Use(hooks ...Hooks) {
+	alloc.mutex.Lock()
+	defer alloc.mutex.Unlock()
+
+	// 写时复制, 分配路径只读取一次原子指针
+	var chain hookChain
+	if old := alloc.hooks.Load(); old != nil {
+		chain = append(chain, *old...)
+	}
+	chain = append(chain, hooks...)
+	alloc.hooks.Store(&chain)
+}
+
+// loadHooks 返回当前注册的钩子
+func (alloc *Alloc) loadHooks() hookChain {
+	if chain := alloc.hooks.Load(); chain != nil {
+		return *chain
+	}
+	return nil
+}
+
+// preAlloc 依次执行 PreAlloc, 第一个错误拒绝本次分配
+func (chain hookChain) preAlloc(ctx context.Context, bizTag string) error {
+	for _, hooks := range chain {
+		if hooks.PreAlloc != nil {
+			if err := hooks.PreAlloc(ctx, bizTag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postAlloc 依次执行 PostAlloc, 前一个的结果作为后一个的输入
+func (chain hookChain) postAlloc(ctx context.Context, bizTag string, id int64) (_ int64, err error) {
+	for _, hooks := range chain {
+		if hooks.PostAlloc != nil {
+			if id, err = hooks.PostAlloc(ctx, bizTag, id); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return id, nil
+}
+
+// onSegmentFetch 依次执行 OnSegmentFetch
+func (chain hookChain) onSegmentFetch(ctx context.Context, bizTag string, left int64, right int64) {
+	for _, hooks := range chain {
+		if hooks.OnSegmentFetch != nil {
+			hooks.OnSegmentFetch(ctx, bizTag, left, right)
+		}
+	}
+}
+
+// onError 依次执行 OnError
+func (chain hookChain) onError(ctx context.Context, bizTag string, err error) {
+	for _, hooks := range chain {
+		if hooks.OnError != nil {
+			hooks.OnError(ctx, bizTag, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(100)
+	alloc := newTestAlloc(t, storage)
+	alloc.clock = newFakeClock(time.Unix(1700000000, 0))
+
+	var (
+		errQuota = errors.New("quota exceeded")
+		allowed  atomic.Int64 // 剩余配额
+		fetched  atomic.Int64 // 获取到的号码数
+		mutex    sync.Mutex
+		errs     []error
+	)
+	allowed.Store(3)
+	alloc.Use(Hooks{
+		PreAlloc: func(ctx context.Context, bizTag string) error {
+			if allowed.Add(-1) < 0 {
+				return errQuota
+			}
+			return nil
+		},
+		OnSegmentFetch: func(ctx context.Context, bizTag string, left int64, right int64) {
+			fetched.Add(right - left)
+		},
+		OnError: func(ctx context.Context, bizTag string, err error) {
+			mutex.Lock()
+			errs = append(errs, err)
+			mutex.Unlock()
+		},
+	})
+	// 后注册的钩子接收前一个的结果
+	alloc.Use(Hooks{PostAlloc: func(ctx context.Context, bizTag string, id int64) (int64, error) {
+		return -id, nil
+	}})
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		id, err := alloc.NextId(context.Background(), "test")
+		if err != nil || id >= 0 || (last != 0 && id != last-1) {
+			t.Fatalf("NextId = (%d, %v) after %d, want a transformed id", id, err, last)
+		}
+		last = id
+	}
+	if fetched.Load() == 0 {
+		t.Fatal("OnSegmentFetch not called")
+	}
+
+	// 被拒绝的分配不消耗号码
+	if _, err := alloc.NextId(context.Background(), "test"); !errors.Is(err, errQuota) {
+		t.Fatalf("NextId over quota err = %v, want errQuota", err)
+	}
+	allowed.Store(1)
+	if id, err := alloc.NextId(context.Background(), "test"); err != nil || id != last-1 {
+		t.Fatalf("NextId after rejection = (%d, %v), want %d", id, err, last-1)
+	}
+
+	// 获取号段失败同样通知 OnError
+	errStorage := errors.New("storage down")
+	storage.setErr(errStorage)
+	allowed.Store(1)
+	if _, err := alloc.NextId(context.Background(), "other"); err == nil {
+		t.Fatal("NextId with failing storage succeeded")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(errs) < 2 || !errors.Is(errs[0], errQuota) || !errors.Is(errs[1], errStorage) {
+		t.Fatalf("OnError got %v, want [errQuota, errStorage, ...]", errs)
+	}
+}
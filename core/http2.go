@@ -0,0 +1,66 @@
+package core
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"slices"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP2Config 定义 HTTP/2 的配置
+// 高峰期客户端为并发的 /alloc 请求建立大量 TCP 连接, 启用 HTTP/2 后多个请求复用少量连接;
+// HTTPS 端口通过 ALPN 协商 HTTP/2, 内网明文端口需要显式启用 h2c, 客户端以先验知识(prior knowledge)或 Upgrade 方式使用
+type HTTP2Config struct {
+	Enable               bool   `json:"enable"`                 // HTTPS 端口是否协商 HTTP/2, 关闭后只使用 HTTP/1.1
+	H2C                  bool   `json:"h2c"`                    // 明文端口是否接受 h2c, 仅用于内网流量
+	MaxConcurrentStreams uint32 `json:"max_concurrent_streams"` // 每个连接的最大并发流数, 0 表示默认 250
+	MaxReadFrameSize     uint32 `json:"max_read_frame_size"`    // 读取帧的最大字节数, 0 表示默认 1MB
+}
+
+// checkHTTP2 检查 HTTP/2 配置
+func checkHTTP2(conf HTTP2Config) error {
+	if conf.MaxReadFrameSize != 0 && (conf.MaxReadFrameSize < 16<<10 || conf.MaxReadFrameSize > 1<<24-1) {
+		return errors.New("http2.max_read_frame_size must be between 16384 and 16777215")
+	}
+	return nil
+}
+
+// configureHTTP2 按配置为主端口或高性能端口启用 HTTP/2, 需在 tuneServer 之后调用以沿用空闲超时;
+// tlsConfig 为空表示明文端口, 启用 h2c 时包装处理器; 否则在 ALPN 中声明或移除 h2
+func configureHTTP2(srv *http.Server, tlsConfig *tls.Config) error {
+	var (
+		conf = DefaultConfig.HTTP2
+		h2   = &http2.Server{
+			MaxConcurrentStreams: conf.MaxConcurrentStreams,
+			MaxReadFrameSize:     conf.MaxReadFrameSize,
+			IdleTimeout:          srv.IdleTimeout,
+		}
+	)
+
+	if tlsConfig == nil {
+		if conf.H2C {
+			srv.Handler = h2c.NewHandler(srv.Handler, h2)
+		}
+		return nil
+	}
+
+	if !conf.Enable {
+		// 非空的空映射阻止标准库自动启用 HTTP/2
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		tlsConfig.NextProtos = slices.DeleteFunc(tlsConfig.NextProtos, func(proto string) bool { return proto == http2.NextProtoTLS })
+		return nil
+	}
+	if err := http2.ConfigureServer(srv, h2); err != nil {
+		return err
+	}
+	// 监听使用独立的 TLS 配置, 在其 ALPN 中声明 h2, 保留 ACME 等已有的协议
+	for _, proto := range []string{http2.NextProtoTLS, "http/1.1"} {
+		if !slices.Contains(tlsConfig.NextProtos, proto) {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, proto)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// serveHTTP2 按当前配置启动返回协议版本的服务器, tlsEnabled 时使用测试证书
+func serveHTTP2(t *testing.T, tlsEnabled bool) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	var tlsConfig *tls.Config
+	if tlsEnabled {
+		srv.TLS = &tls.Config{}
+		tlsConfig = srv.TLS
+	}
+	if err := configureHTTP2(srv.Config, tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	if tlsEnabled {
+		srv.StartTLS()
+	} else {
+		srv.Start()
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestH2C(t *testing.T) {
+	setupTestConfig(t)
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	// 未启用 h2c 时明文端口只接受 HTTP/1.1
+	srv := serveHTTP2(t, false)
+	if _, err := h2c.Get(srv.URL); err == nil {
+		t.Fatal("h2c accepted without http2.h2c")
+	}
+
+	DefaultConfig.HTTP2.H2C = true
+	srv = serveHTTP2(t, false)
+	resp, err := h2c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("proto = %s, want HTTP/2.0", resp.Proto)
+	}
+
+	// HTTP/1.1 客户端仍然可用
+	if resp, err = http.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 {
+		t.Fatalf("proto = %s, want HTTP/1.1", resp.Proto)
+	}
+}
+
+func TestHTTP2TLS(t *testing.T) {
+	setupTestConfig(t)
+	for _, enable := range []bool{true, false} {
+		DefaultConfig.HTTP2.Enable = enable
+		srv := serveHTTP2(t, true)
+		client := srv.Client()
+		client.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if want := map[bool]int{true: 2, false: 1}[enable]; resp.ProtoMajor != want {
+			t.Fatalf("enable=%v: proto = %s, want major %d", enable, resp.Proto, want)
+		}
+	}
+}
+
+func TestCheckHTTP2(t *testing.T) {
+	for _, size := range []uint32{1024, 1 << 24} {
+		if err := checkHTTP2(HTTP2Config{MaxReadFrameSize: size}); err == nil {
+			t.Fatalf("max_read_frame_size %d accepted", size)
+		}
+	}
+	if err := checkHTTP2(HTTP2Config{MaxReadFrameSize: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyConfig 定义分配请求幂等的配置
+// 调用方在重试时携带相同的幂等键, 窗口期内返回第一次分配的 ID, 避免至少一次投递的消息重复分配
+type IdempotencyConfig struct {
+	Enable  bool        `json:"enable"`   // 是否在 /alloc 上支持幂等键
+	Header  string      `json:"header"`   // 携带幂等键的请求头, 也可以使用 idempotency_key 参数, 默认 Idempotency-Key
+	Window  int         `json:"window"`   // 幂等键的有效期（毫秒）
+	MaxKeys int         `json:"max_keys"` // 内存中最多保留的幂等键数, 超过时淘汰最早的, 使用 Redis 时不生效
+	Redis   RedisConfig `json:"redis"`    // 多实例部署时共享幂等键的 Redis, addr 为空表示只保存在本实例内存中
+}
+
+// RedisConfig 定义 Redis 连接配置
+type RedisConfig struct {
+	Addr     string `json:"addr"`      // Redis 地址, 如 127.0.0.1:6379
+	Password string `json:"password"`  // 密码, 为空表示不认证
+	DB       int    `json:"db"`        // 数据库编号
+	Prefix   string `json:"prefix"`    // 键前缀
+	Timeout  int    `json:"timeout"`   // 单个命令的超时时间（毫秒）
+	PoolSize int    `json:"pool_size"` // 保持的空闲连接数
+}
+
+// maxIdempotencyKey 幂等键的最大长度
+const maxIdempotencyKey = 128
+
+// idempotencyStore 保存幂等键对应的响应
+type idempotencyStore interface {
+	// get 查询幂等键对应的响应, 不存在时返回 nil
+	get(ctx context.Context, key string) ([]byte, error)
+	// add 幂等键不存在时保存响应, 返回最终保存的响应; 并发的相同请求以先保存的为准
+	add(ctx context.Context, key string, body []byte) ([]byte, error)
+}
+
+// idempotency 全局幂等键存储, 未启用时为 nil
+var idempotency idempotencyStore
+
+// newIdempotencyStore 按配置创建幂等键存储
+func newIdempotencyStore(conf IdempotencyConfig) idempotencyStore {
+	window := time.Duration(conf.Window) * time.Millisecond
+	if conf.Redis.Addr != "" {
+		return &redisStore{conf: conf.Redis, window: window, conns: make(chan *redisConn, max(conf.Redis.PoolSize, 1))}
+	}
+	return &memoryStore{window: window, maxKeys: conf.MaxKeys, entries: map[string]memoryEntry{}}
+}
+
+// withIdempotency 携带幂等键的分配请求在窗口期内返回相同的响应
+// 幂等键按调用方和业务隔离, 只保存成功的响应, 失败的请求重试时重新分配
+// 存储不可用时不影响分配, 只是失去幂等保证
+func withIdempotency(header string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(header)
+		if key == "" {
+			key = r.FormValue("idempotency_key")
+		}
+		if key == "" {
+			handler(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, http.StatusBadRequest, ErrNoFailed, fmt.Sprintf("idempotency key longer than %d bytes", maxIdempotencyKey))
+			return
+		}
+
+		bizTag := r.FormValue("biz_tag")
+		key = callerName(r) + ":" + bizTag + ":" + key
+		body, err := idempotency.get(r.Context(), key)
+		if err != nil {
+			logger.Warn("idempotency lookup failed", "biz_tag", bizTag, "err", err)
+		}
+		if body != nil {
+			statsd.Incr("idempotent_replay", "biz_tag:"+bizTag)
+			writeReplay(w, body)
+			return
+		}
+
+		// 记录响应, 成功时保存后再写出
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		if recorder.status == http.StatusOK {
+			stored, err := idempotency.add(r.Context(), key, recorder.body.Bytes())
+			if err != nil {
+				logger.Warn("idempotency store failed", "biz_tag", bizTag, "err", err)
+			} else if !bytes.Equal(stored, recorder.body.Bytes()) {
+				// 并发的相同请求已先保存, 本次分配的 ID 不返回给调用方
+				statsd.Incr("idempotent_replay", "biz_tag:"+bizTag)
+				writeReplay(w, stored)
+				return
+			}
+		}
+		w.WriteHeader(recorder.status)
+		_, _ = w.Write(recorder.body.Bytes())
+	}
+}
+
+// writeReplay 返回保存的响应, 并通过 Idempotent-Replayed 告知调用方
+func writeReplay(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	_, _ = w.Write(body)
+}
+
+// responseRecorder 记录处理器写出的状态码和响应体, 响应头直接写入原响应
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+}
+
+func (recorder *responseRecorder) Write(b []byte) (int, error) {
+	return recorder.body.Write(b)
+}
+
+// memoryEntry 内存中保存的一个响应
+type memoryEntry struct {
+	body    []byte
+	expires time.Time
+	seq     uint64 // 保存时的序号, 与 order 中的位置对应
+}
+
+// memorySlot order 中的一个位置, 序号与幂等键当前的保存序号不同时说明该键已被重新保存, 该位置作废
+type memorySlot struct {
+	key string
+	seq uint64
+}
+
+// memoryStore 在本实例内存中保存幂等键, 按保存的先后顺序过期和淘汰
+type memoryStore struct {
+	window  time.Duration
+	maxKeys int
+	mutex   sync.Mutex
+	entries map[string]memoryEntry
+	order   []memorySlot // 按保存顺序排列的幂等键, 窗口固定, 也是过期顺序
+	seq     uint64       // 最近一次保存的序号
+}
+
+func (store *memoryStore) get(_ context.Context, key string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if entry, ok := store.entries[key]; ok && time.Now().Before(entry.expires) {
+		return entry.body, nil
+	}
+	return nil, nil
+}
+
+func (store *memoryStore) add(_ context.Context, key string, body []byte) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	if entry, ok := store.entries[key]; ok && now.Before(entry.expires) {
+		return entry.body, nil
+	}
+
+	// 删除过期的幂等键, 数量超过上限时淘汰最早保存的; 作废的位置直接跳过, 不影响该键重新保存的响应
+	for len(store.order) > 0 {
+		oldest := store.order[0]
+		if entry, ok := store.entries[oldest.key]; ok && entry.seq == oldest.seq {
+			if now.Before(entry.expires) && (store.maxKeys <= 0 || len(store.entries) < store.maxKeys) {
+				break
+			}
+			delete(store.entries, oldest.key)
+		}
+		store.order = store.order[1:]
+	}
+
+	// 过期后重新保存的幂等键使用新的序号, 原来的位置作废
+	store.seq++
+	store.entries[key] = memoryEntry{body: body, expires: now.Add(store.window), seq: store.seq}
+	store.order = append(store.order, memorySlot{key: key, seq: store.seq})
+	return body, nil
+}
+
+// redisStore 在 Redis 中保存幂等键, 多个实例共享
+type redisStore struct {
+	conf   RedisConfig
+	window time.Duration
+	conns  chan *redisConn // 空闲连接
+}
+
+// redisConn 一个 Redis 连接
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// redisError Redis 返回的错误
+type redisError string
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+func (store *redisStore) get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := store.do(ctx, "GET", store.conf.Prefix+key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	return reply.([]byte), nil
+}
+
+func (store *redisStore) add(ctx context.Context, key string, body []byte) ([]byte, error) {
+	key = store.conf.Prefix + key
+	reply, err := store.do(ctx, "SET", key, string(body), "NX", "PX", strconv.FormatInt(store.window.Milliseconds(), 10))
+	if err != nil {
+		return nil, err
+	}
+	if reply != nil { // OK, 本次保存成功
+		return body, nil
+	}
+
+	// 已存在, 返回先保存的响应
+	if reply, err = store.do(ctx, "GET", key); err != nil || reply == nil {
+		return body, err // 刚好过期, 按本次保存成功处理
+	}
+	return reply.([]byte), nil
+}
+
+// do 执行一个命令, 返回 []byte、int64 或 nil
+func (store *redisStore) do(ctx context.Context, args ...string) (reply any, err error) {
+	var (
+		conn     *redisConn
+		deadline time.Time // 零值表示不限制
+	)
+
+	// 命令超时和请求的截止时间取较早的一个
+	if store.conf.Timeout > 0 {
+		deadline = time.Now().Add(time.Duration(store.conf.Timeout) * time.Millisecond)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	select {
+	case conn = <-store.conns:
+		_ = conn.SetDeadline(deadline)
+	default:
+		if conn, err = store.dial(ctx, deadline); err != nil {
+			return
+		}
+	}
+
+	if reply, err = conn.command(args...); err != nil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) { // 网络错误后连接不再可用
+			_ = conn.Close()
+			return
+		}
+	}
+
+	// 放回空闲连接, 已满时关闭
+	select {
+	case store.conns <- conn:
+	default:
+		_ = conn.Close()
+	}
+	return
+}
+
+// dial 建立连接, 按配置认证并选择数据库
+func (store *redisStore) dial(ctx context.Context, deadline time.Time) (conn *redisConn, err error) {
+	var (
+		dialer = net.Dialer{Deadline: deadline}
+		c      net.Conn
+	)
+
+	if c, err = dialer.DialContext(ctx, "tcp", store.conf.Addr); err != nil {
+		return
+	}
+	conn = &redisConn{Conn: c, reader: bufio.NewReader(c)}
+	_ = conn.SetDeadline(deadline)
+	if store.conf.Password != "" {
+		if _, err = conn.command("AUTH", store.conf.Password); err != nil {
+			goto ERROR
+		}
+	}
+	if store.conf.DB != 0 {
+		if _, err = conn.command("SELECT", strconv.Itoa(store.conf.DB)); err != nil {
+			goto ERROR
+		}
+	}
+	return
+
+ERROR:
+	_ = conn.Close()
+	return nil, err
+}
+
+// command 按 RESP 协议发送命令并读取回复
+func (conn *redisConn) command(args ...string) (reply any, err error) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err = conn.Write([]byte(b.String())); err != nil {
+		return
+	}
+
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err // $-1 表示不存在
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(conn.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
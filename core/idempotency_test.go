@@ -0,0 +1,264 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveIdempotent 用幂等中间件包装一个每次返回新 ID 的处理器
+func serveIdempotent(t *testing.T, store idempotencyStore) (handler http.HandlerFunc, calls *int64) {
+	t.Helper()
+
+	saved := idempotency
+	idempotency = store
+	t.Cleanup(func() { idempotency = saved })
+
+	calls = new(int64)
+	handler = withIdempotency("Idempotency-Key", func(w http.ResponseWriter, r *http.Request) {
+		id := atomic.AddInt64(calls, 1)
+		if r.FormValue("fail") != "" {
+			writeError(w, http.StatusInternalServerError, ErrNoFailed, "failed")
+			return
+		}
+		fmt.Fprintf(w, `{"err_no":0,"msg":"success","id":%d}`, id)
+	})
+	return
+}
+
+func TestIdempotency(t *testing.T) {
+	handler, calls := serveIdempotent(t, newIdempotencyStore(IdempotencyConfig{Window: 60000}))
+
+	request := func(target string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// 相同的幂等键返回第一次的响应, 不再分配
+	first := request("/alloc?biz_tag=test", "msg-1")
+	replay := request("/alloc?biz_tag=test", "msg-1")
+	if first.Body.String() != replay.Body.String() || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay = %q, want %q", replay.Body.String(), first.Body.String())
+	}
+	if n := atomic.LoadInt64(calls); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+
+	// 幂等键也可以通过参数携带, 按业务隔离
+	if w := request("/alloc?biz_tag=test&idempotency_key=msg-1", ""); w.Body.String() != first.Body.String() {
+		t.Fatalf("key from param = %q, want the replay", w.Body.String())
+	}
+	if w := request("/alloc?biz_tag=other", "msg-1"); w.Body.String() == first.Body.String() {
+		t.Fatal("idempotency key shared across biz_tags")
+	}
+
+	// 失败的响应不保存, 重试时重新分配
+	if w := request("/alloc?biz_tag=test&fail=1", "msg-2"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", w.Code)
+	}
+	if w := request("/alloc?biz_tag=test", "msg-2"); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after a failure: status %d, replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+
+	if w := request("/alloc?biz_tag=test", strings.Repeat("k", maxIdempotencyKey+1)); w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d for an oversized key, want 400", w.Code)
+	}
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	handler, _ := serveIdempotent(t, newIdempotencyStore(IdempotencyConfig{Window: 60000}))
+
+	// 并发的相同请求都返回先保存的响应
+	var (
+		wg     sync.WaitGroup
+		bodies = make([]string, 8)
+	)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil)
+			r.Header.Set("Idempotency-Key", "same")
+			w := httptest.NewRecorder()
+			handler(w, r)
+			bodies[i] = w.Body.String()
+		}()
+	}
+	wg.Wait()
+	for _, body := range bodies {
+		if body != bodies[0] {
+			t.Fatalf("concurrent responses differ: %q", bodies)
+		}
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	store := newIdempotencyStore(IdempotencyConfig{Window: 60000, MaxKeys: 2})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := store.add(ctx, strconv.Itoa(i), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 超过上限时淘汰最早保存的幂等键
+	if body, _ := store.get(ctx, "0"); body != nil {
+		t.Fatalf("evicted key returned %q", body)
+	}
+	if body, _ := store.get(ctx, "2"); string(body) != "2" {
+		t.Fatalf("get = %q, want 2", body)
+	}
+
+}
+
+func TestMemoryStoreReAdd(t *testing.T) {
+	store := newIdempotencyStore(IdempotencyConfig{Window: 60000, MaxKeys: 4}).(*memoryStore)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := store.add(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b 过期后被重新保存, 原来排在 a 和 c 之间的位置作废
+	store.mutex.Lock()
+	entry := store.entries["b"]
+	entry.expires = time.Now().Add(-time.Second)
+	store.entries["b"] = entry
+	store.mutex.Unlock()
+	if body, _ := store.add(ctx, "b", []byte("b2")); string(body) != "b2" {
+		t.Fatalf("re-added expired key returned %q, want b2", body)
+	}
+
+	// 超过上限时依次淘汰 a 和 c, 重新保存的 b 在窗口期内仍然可以重放
+	for _, key := range []string{"d", "e", "f"} {
+		if _, err := store.add(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, want := range map[string]string{"a": "", "b": "b2", "c": "", "f": "f"} {
+		if body, _ := store.get(ctx, key); string(body) != want {
+			t.Fatalf("get(%s) = %q, want %q", key, body, want)
+		}
+	}
+}
+
+// fakeRedis 只支持 AUTH、GET 和 SET NX PX 的 Redis
+type fakeRedis struct {
+	mutex sync.Mutex
+	data  map[string]string
+}
+
+func newFakeRedis(t *testing.T, password string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	redis := &fakeRedis{data: map[string]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go redis.serve(conn, password)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (redis *fakeRedis) serve(conn net.Conn, password string) {
+	defer conn.Close()
+
+	var (
+		reader = bufio.NewReader(conn)
+		authed = password == ""
+	)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(reader, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(reader, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+
+		redis.mutex.Lock()
+		switch {
+		case args[0] == "AUTH" && args[1] == password:
+			authed = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "GET":
+			if value, ok := redis.data[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case args[0] == "SET":
+			if _, ok := redis.data[args[1]]; ok {
+				fmt.Fprint(conn, "$-1\r\n")
+			} else {
+				redis.data[args[1]] = args[2]
+				fmt.Fprint(conn, "+OK\r\n")
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		redis.mutex.Unlock()
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	addr := newFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	store := newIdempotencyStore(IdempotencyConfig{Window: 60000, Redis: RedisConfig{Addr: addr, Password: "secret", Timeout: 1000}})
+	if body, err := store.get(ctx, "k"); err != nil || body != nil {
+		t.Fatalf("get = (%q, %v), want a miss", body, err)
+	}
+	if body, err := store.add(ctx, "k", []byte("first")); err != nil || string(body) != "first" {
+		t.Fatalf("add = (%q, %v), want first", body, err)
+	}
+
+	// 已存在时返回先保存的响应, 其他实例共享同一个 Redis 时同样生效
+	other := newIdempotencyStore(IdempotencyConfig{Window: 60000, Redis: RedisConfig{Addr: addr, Password: "secret", Timeout: 1000}})
+	if body, err := other.add(ctx, "k", []byte("second")); err != nil || string(body) != "first" {
+		t.Fatalf("add = (%q, %v), want the first response", body, err)
+	}
+
+	// 认证失败时返回 Redis 的错误
+	wrong := newIdempotencyStore(IdempotencyConfig{Window: 60000, Redis: RedisConfig{Addr: addr, Password: "wrong", Timeout: 1000}})
+	if _, err := wrong.get(ctx, "k"); err == nil {
+		t.Fatal("get succeeded with a wrong password")
+	}
+}
@@ -0,0 +1,430 @@
+//go:build integration
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 集成测试需要真实的 MySQL, 运行方式:
+//
+//	go test -tags integration ./core
+//
+// 设置了 LEAF_TEST_DSN 时直接使用该数据库, 否则通过 docker 启动一个临时的 MySQL 容器, 测试结束后删除
+// LEAF_TEST_MYSQL_IMAGE 可指定镜像, 默认 mysql:8.0
+// 测试使用单独的号段表 it_segments, 每次运行前清空, 不影响库中已有的 segments 表
+
+const integrationSchema = "CREATE TABLE IF NOT EXISTS `it_segments` (" +
+	" `biz_tag` varchar(32) NOT NULL," +
+	" `max_id` bigint NOT NULL," +
+	" `step` bigint NOT NULL," +
+	" `description` varchar(1024) DEFAULT '' NOT NULL," +
+	" `update_time` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP," +
+	" `archived` tinyint NOT NULL DEFAULT 0," +
+	" `reset_at` bigint NOT NULL DEFAULT 0," +
+	" PRIMARY KEY (`biz_tag`)" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+
+// startMySQL 返回测试数据库的 DSN, 没有配置 LEAF_TEST_DSN 时启动 MySQL 容器
+func startMySQL(t *testing.T) string {
+	t.Helper()
+
+	if dsn := os.Getenv("LEAF_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("LEAF_TEST_DSN not set and docker not available")
+	}
+
+	image := os.Getenv("LEAF_TEST_MYSQL_IMAGE")
+	if image == "" {
+		image = "mysql:8.0"
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "MYSQL_ROOT_PASSWORD=leaf", "-e", "MYSQL_DATABASE=leaf_test",
+		"-p", "127.0.0.1::3306", image).Output()
+	if err != nil {
+		t.Fatalf("start mysql container: %v", err)
+	}
+	container := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		_ = exec.Command("docker", "rm", "-f", container).Run()
+	})
+
+	// 随机映射的宿主机端口, 输出形如 127.0.0.1:49153
+	if out, err = exec.Command("docker", "port", container, "3306/tcp").Output(); err != nil {
+		t.Fatalf("inspect mysql container port: %v", err)
+	}
+	addr := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	return fmt.Sprintf("root:leaf@tcp(%s)/leaf_test?parseTime=true", addr)
+}
+
+// setupIntegration 通过配置文件加载配置, 初始化数据库和分配器, 并创建号段表
+func setupIntegration(t *testing.T) {
+	t.Helper()
+
+	dsn := startMySQL(t)
+	configFile := filepath.Join(t.TempDir(), "allocate.json")
+	content := fmt.Sprintf(`{
+		"dsn": %q,
+		"table": "it_segments",
+		"log": {"level": "error"},
+		"breaker": {"enable": false},
+		"failover": {"startup_retries": 60, "startup_retry_interval": 1000}
+	}`, dsn)
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(configFile); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if err := InitLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 容器刚启动时 MySQL 尚未就绪, 由启动重试等待
+	if err := InitData(); err != nil {
+		t.Fatalf("init data: %v", err)
+	}
+	if err := InitAlloc(); err != nil {
+		t.Fatalf("init alloc: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = DefaultAlloc.Close(context.Background())
+		_ = DefaultData.Close()
+	})
+
+	ctx := context.Background()
+	for _, query := range []string{integrationSchema, "DELETE FROM it_segments"} {
+		if _, err := DefaultData.current().ExecContext(ctx, query); err != nil {
+			t.Fatalf("apply schema: %v", err)
+		}
+	}
+}
+
+// insertBizTag 在号段表中创建业务
+func insertBizTag(t *testing.T, bizTag string, maxId int64, step int64) {
+	t.Helper()
+
+	if _, err := DefaultData.current().ExecContext(context.Background(),
+		"INSERT INTO it_segments(biz_tag, max_id, step, description) VALUES(?, ?, ?, 'integration test')",
+		bizTag, maxId, step); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	setupIntegration(t)
+	ctx := context.Background()
+
+	t.Run("rollover", func(t *testing.T) {
+		const step = 10
+		insertBizTag(t, "rollover", 0, step)
+		bizAlloc := DefaultAlloc.loadOrCreate("rollover")
+
+		// 连续跨越多个号段, 号码必须连续且不重复
+		for want := int64(0); want < step*5+3; want++ {
+			got, err := bizAlloc.nextId(ctx)
+			if err != nil {
+				t.Fatalf("nextId #%d: %v", want, err)
+			}
+			if got != want {
+				t.Fatalf("nextId = %d, want %d", got, want)
+			}
+		}
+
+		// 数据库中的 max_id 不小于已分配的号码
+		var maxId int64
+		if err := DefaultData.current().QueryRowContext(ctx,
+			"SELECT max_id FROM it_segments WHERE biz_tag = 'rollover'").Scan(&maxId); err != nil {
+			t.Fatal(err)
+		}
+		if maxId < step*5+3 {
+			t.Fatalf("max_id = %d, want >= %d", maxId, step*5+3)
+		}
+	})
+
+	t.Run("step change", func(t *testing.T) {
+		insertBizTag(t, "resize", 0, 10)
+		if _, _, err := DefaultData.NextId(ctx, "resize", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// 步长在两次获取之间被修改, 单语句获取失败后回退到事务并读到新步长
+		if _, err := DefaultData.current().ExecContext(ctx, "UPDATE it_segments SET step = 25 WHERE biz_tag = 'resize'"); err != nil {
+			t.Fatal(err)
+		}
+		maxId, step, err := DefaultData.NextId(ctx, "resize", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maxId != 35 || step != 25 {
+			t.Fatalf("NextId = (%d, %d), want (35, 25)", maxId, step)
+		}
+
+		// 通过 SetStep 修改后单语句获取直接使用新步长
+		if err := DefaultData.SetStep(ctx, "resize", 40); err != nil {
+			t.Fatal(err)
+		}
+		if maxId, step, err = DefaultData.NextId(ctx, "resize", 1); err != nil || maxId != 75 || step != 40 {
+			t.Fatalf("NextId = (%d, %d, %v), want (75, 40, nil)", maxId, step, err)
+		}
+		if err := DefaultData.SetStep(ctx, "missing", 40); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("SetStep err = %v, want ErrBizTagNotFound", err)
+		}
+	})
+
+	t.Run("description", func(t *testing.T) {
+		insertBizTag(t, "owned", 0, 10)
+		if err := DefaultData.SetDescription(ctx, "owned", "payments team"); err != nil {
+			t.Fatal(err)
+		}
+		// 描述未变化时同样成功
+		if err := DefaultData.SetDescription(ctx, "owned", "payments team"); err != nil {
+			t.Fatal(err)
+		}
+		if err := DefaultData.SetDescription(ctx, "missing", "nobody"); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("SetDescription err = %v, want ErrBizTagNotFound", err)
+		}
+
+		tags, err := DefaultData.Tags(ctx, "owned")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != 1 || tags[0].Description != "payments team" || tags[0].Table != "it_segments" || tags[0].UpdateTime.IsZero() {
+			t.Fatalf("Tags = %+v, want the owned row with its description", tags)
+		}
+	})
+
+	t.Run("advance", func(t *testing.T) {
+		insertBizTag(t, "migrated", 20, 10)
+		maxId, burned, err := DefaultData.Advance(ctx, "migrated", 99)
+		if err != nil || maxId != 100 || burned != 80 {
+			t.Fatalf("Advance = (%d, %d, %v), want (100, 80, nil)", maxId, burned, err)
+		}
+		// 已经超过时不做修改
+		if maxId, burned, err = DefaultData.Advance(ctx, "migrated", 50); err != nil || maxId != 100 || burned != 0 {
+			t.Fatalf("Advance = (%d, %d, %v), want (100, 0, nil)", maxId, burned, err)
+		}
+		if maxId, _, err = DefaultData.NextId(ctx, "migrated", 1); err != nil || maxId != 110 {
+			t.Fatalf("NextId = (%d, %v), want (110, nil)", maxId, err)
+		}
+		if _, _, err = DefaultData.Advance(ctx, "missing", 50); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("Advance err = %v, want ErrBizTagNotFound", err)
+		}
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		if _, err := DefaultData.current().ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `it_segment_usage` ("+
+			" `biz_tag` varchar(32) NOT NULL, `stat_date` date NOT NULL,"+
+			" `ids_issued` bigint NOT NULL DEFAULT 0, `segments_fetched` bigint NOT NULL DEFAULT 0,"+
+			" `last_alloc_time` datetime(3) DEFAULT NULL,"+
+			" `update_time` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,"+
+			" PRIMARY KEY (`biz_tag`, `stat_date`)) ENGINE=InnoDB DEFAULT CHARSET=utf8"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DefaultData.current().ExecContext(ctx, "DELETE FROM it_segment_usage"); err != nil {
+			t.Fatal(err)
+		}
+		insertBizTag(t, "usage", 0, 10)
+		usage := &usageRecorder{conf: UsageConfig{Table: "it_segment_usage"}, data: DefaultData, written: map[string]usageCounters{}}
+
+		// 两个周期的增量累加到同一行
+		for round := 0; round < 2; round++ {
+			for i := 0; i < 5; i++ {
+				if _, err := DefaultAlloc.NextId(ctx, "usage"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			usage.flush(DefaultAlloc, time.Now())
+		}
+		var issued, fetched int64
+		if err := DefaultData.current().QueryRowContext(ctx,
+			"SELECT ids_issued, segments_fetched FROM it_segment_usage WHERE biz_tag = 'usage'").Scan(&issued, &fetched); err != nil {
+			t.Fatal(err)
+		}
+		if issued != 10 || fetched < 1 {
+			t.Fatalf("usage = (%d ids, %d segments), want 10 ids", issued, fetched)
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		insertBizTag(t, "retired", 0, 10)
+		conf := *DefaultConfig
+		conf.Archive.Enable = true
+		for _, locking := range []string{LockingUpdateSelect, LockingSelectForUpdate} {
+			conf.Transaction = TransactionConfig{Locking: locking}
+			data, err := newData(&conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer data.Close()
+
+			if err = data.SetArchived(ctx, "retired", true); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err = data.NextId(ctx, "retired", 1); !errors.Is(err, ErrBizTagArchived) {
+				t.Fatalf("%s: NextId err = %v, want ErrBizTagArchived", locking, err)
+			}
+			if _, _, err = data.NextId(ctx, "missing", 1); !errors.Is(err, ErrBizTagNotFound) {
+				t.Fatalf("%s: NextId err = %v, want ErrBizTagNotFound", locking, err)
+			}
+
+			// 归档后号段行仍可查询, 恢复后继续发号
+			tags, err := data.Tags(ctx, "retired")
+			if err != nil || len(tags) != 1 || !tags[0].Archived {
+				t.Fatalf("Tags = (%+v, %v), want the archived row", tags, err)
+			}
+			if err = data.SetArchived(ctx, "retired", false); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err = data.NextId(ctx, "retired", 1); err != nil {
+				t.Fatalf("%s: NextId after restore: %v", locking, err)
+			}
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		insertBizTag(t, "ticket", 500, 10)
+		today := resetPeriod{start: 1000, end: 2000, resetTo: 1}
+		yesterday := resetPeriod{start: 0, end: 1000, resetTo: 1}
+
+		// 新周期中第一次获取号段时重置, 之后在同一周期内继续前进
+		for _, want := range []int64{11, 21} {
+			if maxId, _, err := DefaultData.NextId(context.WithValue(ctx, resetPeriodKey{}, today), "ticket", 1); err != nil || maxId != want {
+				t.Fatalf("NextId = (%d, %v), want (%d, nil)", maxId, err, want)
+			}
+		}
+		if _, _, err := DefaultData.NextId(context.WithValue(ctx, resetPeriodKey{}, yesterday), "ticket", 1); !errors.Is(err, errPeriodPassed) {
+			t.Fatalf("NextId of a passed period err = %v, want errPeriodPassed", err)
+		}
+	})
+
+	t.Run("biz_tag not found", func(t *testing.T) {
+		if _, _, err := DefaultData.NextId(ctx, "missing", 1); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("Data.NextId err = %v, want ErrBizTagNotFound", err)
+		}
+		if _, err := DefaultAlloc.NextId(ctx, "missing"); err == nil {
+			t.Fatal("Alloc.NextId on missing biz_tag succeeded")
+		}
+	})
+}
+
+// TestIntegrationCluster 多个实例各自持有独立的连接池, 只通过同一个数据库协调号段
+func TestIntegrationCluster(t *testing.T) {
+	setupIntegration(t)
+
+	const (
+		instances = 4
+		workers   = 50
+		total     = 100000
+	)
+	insertBizTag(t, "cluster", 0, 100)
+
+	storages := make([]Storage, instances)
+	for i := range storages {
+		data, err := newData(DefaultConfig)
+		if err != nil {
+			t.Fatalf("init data for instance %d: %v", i, err)
+		}
+		t.Cleanup(func() { _ = data.Close() })
+		storages[i] = data
+	}
+
+	result, err := verifyCluster(context.Background(), storages, "cluster", workers, total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCluster(t, result, total)
+}
+
+// TestIntegrationLocking 不同加锁方式和隔离级别的实例共享同一个号段行, 号段不重叠也不跳号
+func TestIntegrationLocking(t *testing.T) {
+	setupIntegration(t)
+
+	const (
+		workers = 20
+		total   = 20000
+	)
+	insertBizTag(t, "locking", 0, 100)
+
+	var storages []Storage
+	for _, transaction := range []TransactionConfig{
+		{Locking: LockingUpdateSelect},
+		{Locking: LockingSelectForUpdate},
+		{Locking: LockingSelectForUpdate, Isolation: "read_committed"},
+		{Locking: LockingUpdateSelect, Isolation: "serializable"},
+	} {
+		conf := *DefaultConfig
+		conf.Transaction = transaction
+		data, err := newData(&conf)
+		if err != nil {
+			t.Fatalf("init data with %+v: %v", transaction, err)
+		}
+		t.Cleanup(func() { _ = data.Close() })
+		storages = append(storages, data)
+	}
+
+	result, err := verifyCluster(context.Background(), storages, "locking", workers, total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCluster(t, result, total)
+
+	// select_for_update 方式同样识别不存在的业务
+	if _, _, err = storages[1].NextId(context.Background(), "missing", 1); !errors.Is(err, ErrBizTagNotFound) {
+		t.Fatalf("NextId err = %v, want ErrBizTagNotFound", err)
+	}
+}
+
+// TestIntegrationLeafSchema 直接使用美团 Leaf 的 leaf_alloc 表发号, description 为 NULL 的业务同样可以查询
+func TestIntegrationLeafSchema(t *testing.T) {
+	setupIntegration(t)
+	ctx := context.Background()
+
+	for _, query := range []string{
+		"CREATE TABLE IF NOT EXISTS `leaf_alloc` (" +
+			" `biz_tag` varchar(128) NOT NULL DEFAULT ''," +
+			" `max_id` bigint(20) NOT NULL DEFAULT '1'," +
+			" `step` int(11) NOT NULL," +
+			" `description` varchar(256) DEFAULT NULL," +
+			" `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP," +
+			" PRIMARY KEY (`biz_tag`)" +
+			") ENGINE=InnoDB",
+		"DELETE FROM leaf_alloc",
+		"INSERT INTO leaf_alloc(biz_tag, max_id, step) VALUES('leaf-segment-test', 1, 2000)",
+	} {
+		if _, err := DefaultData.current().ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := *DefaultConfig
+	conf.Table, conf.Schema = "leaf_alloc", SchemaLeaf
+	data, err := newData(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.Close()
+
+	// 与 Java Leaf 相同, 第一个号段为 [1, 2001)
+	maxId, step, err := data.NextId(ctx, "leaf-segment-test", 1)
+	if err != nil || maxId != 2001 || step != 2000 {
+		t.Fatalf("NextId = (%d, %d, %v), want (2001, 2000, nil)", maxId, step, err)
+	}
+	tags, err := data.Tags(ctx, "leaf-segment-test")
+	if err != nil || len(tags) != 1 || tags[0].Description != "" {
+		t.Fatalf("Tags = (%+v, %v), want one row with an empty description", tags, err)
+	}
+	if err = data.SetDescription(ctx, "leaf-segment-test", strings.Repeat("x", leafDescriptionLength+1)); !errors.Is(err, errDescriptionTooLong) {
+		t.Fatalf("SetDescription err = %v, want errDescriptionTooLong", err)
+	}
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// IPFilterConfig 定义按来源地址的访问控制
+type IPFilterConfig struct {
+	Alloc IPRules `json:"alloc"` // 分配端口的规则
+	Admin IPRules `json:"admin"` // 管理端口的规则
+}
+
+// IPRules 定义一组 CIDR 规则, 先匹配拒绝列表, 允许列表非空时只放行其中的地址
+type IPRules struct {
+	Allow []string `json:"allow"` // 允许的 CIDR 或 IP, 为空表示不限制
+	Deny  []string `json:"deny"`  // 拒绝的 CIDR 或 IP
+}
+
+// ipFilter 解析后的 CIDR 规则
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// parsePrefixes 解析 CIDR 列表, 单个 IP 视为只包含自身的网段
+func parsePrefixes(values []string) (prefixes []netip.Prefix, err error) {
+	for _, value := range values {
+		var prefix netip.Prefix
+		if prefix, err = netip.ParsePrefix(value); err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, err
+			}
+			prefix, err = addr.Unmap().Prefix(addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return
+}
+
+// newIPFilter 解析规则, 没有任何规则时返回 nil
+func newIPFilter(rules IPRules) (filter *ipFilter, err error) {
+	if len(rules.Allow) == 0 && len(rules.Deny) == 0 {
+		return
+	}
+
+	filter = &ipFilter{}
+	if filter.allow, err = parsePrefixes(rules.Allow); err != nil {
+		return nil, err
+	}
+	if filter.deny, err = parsePrefixes(rules.Deny); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// allowed 判断来源地址是否放行
+func (filter *ipFilter) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap() // IPv4-mapped IPv6 地址按 IPv4 匹配
+
+	for _, prefix := range filter.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(filter.allow) == 0 {
+		return true
+	}
+	for _, prefix := range filter.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// withIPFilter 拒绝不在规则内的来源地址, 返回 HTTP 403
+func withIPFilter(filter *ipFilter, handler http.Handler) http.Handler {
+	if filter == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !filter.allowed(r.RemoteAddr) && !fromUnixSocket(r) {
+			logger.Warn("request denied by ip filter", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// fromUnixSocket 请求是否来自 Unix 套接字监听, 这类请求没有来源 IP, 只有本机进程能连接, 不按地址规则过滤
+func fromUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
@@ -0,0 +1,392 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig 定义 JWT/OIDC bearer token 认证的配置
+type JWTConfig struct {
+	Enable          bool   `json:"enable"`           // 是否接受 Authorization: Bearer <jwt>
+	Issuer          string `json:"issuer"`           // 要求的 iss, 未配置 jwks_url 时从 <issuer>/.well-known/openid-configuration 发现
+	Audience        string `json:"audience"`         // 要求 aud 中包含该值, 为空表示不校验
+	JWKSURL         string `json:"jwks_url"`         // JWKS 地址
+	BizTagsClaim    string `json:"biz_tags_claim"`   // 可访问业务标识所在的 claim, 数组或空格/逗号分隔的字符串
+	AdminClaim      string `json:"admin_claim"`      // 值为 true 时允许访问管理接口的 claim
+	NamespacesClaim string `json:"namespaces_claim"` // 可访问其中全部业务的命名空间所在的 claim
+	AdminNSClaim    string `json:"admin_ns_claim"`   // 可管理其中业务的命名空间所在的 claim
+	Leeway          int    `json:"leeway"`           // 校验 exp/nbf 时允许的时钟偏差（毫秒）
+	RefreshInterval int    `json:"refresh_interval"` // 定期刷新 JWKS 的间隔（毫秒）
+}
+
+// jwtHashes 支持的签名算法对应的摘要算法
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// jwtCurves ES 系列签名算法要求的曲线, 公钥的曲线必须与之一致
+var jwtCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521(),
+}
+
+// jwk JWKS 中的一个公钥
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtAuth 基于 JWT 的认证, 公钥来自 JWKS 并定期刷新
+type jwtAuth struct {
+	conf        JWTConfig
+	client      *http.Client
+	mutex       sync.RWMutex
+	keys        map[string]crypto.PublicKey // kid 到公钥的映射
+	lastRefresh time.Time                   // 最近一次刷新 JWKS 的时间
+	stopChan    chan struct{}               // 停止定期刷新
+	wait        sync.WaitGroup              // 等待刷新线程退出
+}
+
+// jwtKeys 全局 JWT 认证, 未启用时为 nil
+var jwtKeys *jwtAuth
+
+// newJWTAuth 创建 JWT 认证, 先加载一次 JWKS, 再定期刷新
+func newJWTAuth(conf JWTConfig) (auth *jwtAuth, err error) {
+	auth = &jwtAuth{
+		conf:     conf,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		keys:     map[string]crypto.PublicKey{},
+		stopChan: make(chan struct{}),
+	}
+
+	if auth.conf.JWKSURL == "" {
+		if auth.conf.JWKSURL, err = auth.discover(); err != nil {
+			return nil, err
+		}
+	}
+	if err = auth.refresh(); err != nil {
+		return nil, err
+	}
+	auth.wait.Add(1)
+	go auth.refreshLoop()
+	return
+}
+
+// stop 停止定期刷新 JWKS
+func (auth *jwtAuth) stop() {
+	close(auth.stopChan)
+	auth.wait.Wait()
+}
+
+// getJSON 请求并解析 JSON
+func (auth *jwtAuth) getJSON(url string, v any) (err error) {
+	var (
+		resp *http.Response
+	)
+
+	if resp, err = auth.client.Get(url); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover 通过 OIDC discovery 获取 JWKS 地址
+func (auth *jwtAuth) discover() (jwksURL string, err error) {
+	var (
+		doc struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+	)
+
+	if auth.conf.Issuer == "" {
+		return "", errors.New("jwt requires issuer or jwks_url")
+	}
+	if err = auth.getJSON(strings.TrimSuffix(auth.conf.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("jwks_uri missing in openid configuration")
+	}
+	return doc.JWKSURI, nil
+}
+
+// refresh 重新加载 JWKS, 无法解析的公钥被忽略
+func (auth *jwtAuth) refresh() (err error) {
+	var (
+		set struct {
+			Keys []jwk `json:"keys"`
+		}
+		keys = map[string]crypto.PublicKey{}
+	)
+
+	if err = auth.getJSON(auth.conf.JWKSURL, &set); err != nil {
+		return
+	}
+	for _, k := range set.Keys {
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		} else {
+			logger.Warn("skip jwks key", "kid", k.Kid, "err", err)
+		}
+	}
+
+	auth.mutex.Lock()
+	auth.keys = keys
+	auth.lastRefresh = time.Now()
+	auth.mutex.Unlock()
+	return
+}
+
+// refreshLoop 定期刷新 JWKS, 刷新失败时保留上一次的公钥
+func (auth *jwtAuth) refreshLoop() {
+	defer auth.wait.Done()
+
+	var (
+		interval = time.Duration(auth.conf.RefreshInterval) * time.Millisecond
+		ticker   *time.Ticker
+	)
+
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := auth.refresh(); err != nil {
+				logger.Warn("refresh jwks failed", "url", auth.conf.JWKSURL, "err", err)
+			}
+		case <-auth.stopChan:
+			return
+		}
+	}
+}
+
+// key 查找公钥, 未知的 kid 可能是签发方轮换了密钥, 至多每分钟触发一次刷新
+func (auth *jwtAuth) key(kid string) (crypto.PublicKey, bool) {
+	auth.mutex.Lock()
+	key, ok := auth.keys[kid]
+	stale := !ok && time.Since(auth.lastRefresh) > time.Minute
+	if stale { // 先更新时间, 避免并发请求或刷新失败时反复请求 JWKS
+		auth.lastRefresh = time.Now()
+	}
+	auth.mutex.Unlock()
+
+	if stale {
+		if err := auth.refresh(); err != nil {
+			logger.Warn("refresh jwks failed", "url", auth.conf.JWKSURL, "err", err)
+		}
+		auth.mutex.RLock()
+		key, ok = auth.keys[kid]
+		auth.mutex.RUnlock()
+	}
+	return key, ok
+}
+
+// publicKey 将 JWK 转换为公钥
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+// decodeBigInt 解码 base64url 编码的大整数
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifySignature 按算法校验签名, ES 系列算法要求公钥使用对应的曲线, 签名为该曲线长度的 r 和 s 拼接
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return errors.New("unsupported alg " + alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		}
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		curve, ok := jwtCurves[alg]
+		if !ok || pub.Curve.Params().Name != curve.Params().Name {
+			break
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if ecdsa.Verify(pub, digest, r, s) {
+			return nil
+		}
+		return errors.New("invalid signature")
+	}
+	return errors.New("alg " + alg + " does not match key")
+}
+
+// claimStrings 将数组或空格/逗号分隔的字符串 claim 转换为字符串列表
+func claimStrings(v any) (result []string) {
+	switch value := v.(type) {
+	case string:
+		result = strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+	case []any:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return
+}
+
+// claimTime 读取数值类型的时间 claim
+func claimTime(claims map[string]any, name string) (t time.Time, ok bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// authenticate 校验 Authorization 中的 bearer token 并识别调用方
+func (auth *jwtAuth) authenticate(r *http.Request) (*principal, error) {
+	var (
+		header struct {
+			Alg string `json:"alg"`
+			Kid string `json:"kid"`
+		}
+		claims map[string]any
+		leeway = time.Duration(auth.conf.Leeway) * time.Millisecond
+		now    = time.Now()
+	)
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errNoCredentials
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed jwt header")
+	}
+	key, ok := auth.key(header.Kid)
+	if !ok {
+		return nil, errors.New("unknown jwt kid " + header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed jwt signature")
+	}
+	if err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed jwt claims")
+	}
+
+	// 校验标准 claim
+	if exp, ok := claimTime(claims, "exp"); !ok || now.After(exp.Add(leeway)) {
+		return nil, errors.New("jwt expired")
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Before(nbf.Add(-leeway)) {
+		return nil, errors.New("jwt not yet valid")
+	}
+	if iss, _ := claims["iss"].(string); auth.conf.Issuer != "" && iss != auth.conf.Issuer {
+		return nil, errors.New("jwt issuer mismatch")
+	}
+	if auth.conf.Audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			found = found || aud == auth.conf.Audience
+		}
+		if !found {
+			return nil, errors.New("jwt audience mismatch")
+		}
+	}
+
+	sub, _ := claims["sub"].(string)
+	p := newPrincipal(sub, claimStrings(claims[auth.conf.BizTagsClaim]))
+	p.admin, _ = claims[auth.conf.AdminClaim].(bool)
+	p.namespaces = stringSet(claimStrings(claims[auth.conf.NamespacesClaim]))
+	p.adminNamespaces = stringSet(claimStrings(claims[auth.conf.AdminNSClaim]))
+	return p, nil
+}
+
+// decodeSegment 解码 JWT 中 base64url 编码的 JSON 段
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJWKS 可替换公钥集合的 JWKS 服务
+type testJWKS struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	keys     []jwk
+	requests atomic.Int64 // 请求次数
+}
+
+// newTestJWKS 启动 JWKS 服务, 测试结束时关闭
+func newTestJWKS(t *testing.T, keys ...jwk) *testJWKS {
+	t.Helper()
+
+	jwks := &testJWKS{keys: keys}
+	jwks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks.requests.Add(1)
+		jwks.mutex.Lock()
+		defer jwks.mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": jwks.keys})
+	}))
+	t.Cleanup(jwks.Close)
+	return jwks
+}
+
+// setKeys 替换公钥集合, 模拟签发方轮换密钥
+func (jwks *testJWKS) setKeys(keys ...jwk) {
+	jwks.mutex.Lock()
+	jwks.keys = keys
+	jwks.mutex.Unlock()
+}
+
+// rsaJWK 将 RSA 公钥编码为 JWK
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	e := []byte{byte(key.E >> 16), byte(key.E >> 8), byte(key.E)}
+	return jwk{Kid: kid, Kty: "RSA", N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()), E: base64.RawURLEncoding.EncodeToString(e)}
+}
+
+// ecJWK 将 ECDSA 公钥编码为 JWK
+func ecJWK(kid string, key *ecdsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "EC", Crv: key.Curve.Params().Name,
+		X: base64.RawURLEncoding.EncodeToString(key.X.Bytes()), Y: base64.RawURLEncoding.EncodeToString(key.Y.Bytes())}
+}
+
+// encodeSegment 将 JSON 编码为 JWT 中的一段
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signJWT 按 alg 签发 JWT, key 为 nil 时签名为空; ES 签名的 r 和 s 各按 size 字节补齐
+func signJWT(t *testing.T, alg string, kid string, key crypto.Signer, size int, claims map[string]any) string {
+	t.Helper()
+
+	signed := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		digest := jwtHashes[alg].New()
+		digest.Write([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, jwtHashes[alg], digest.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		digest := jwtHashes[alg].New()
+		digest.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	setupTestConfig(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	jwks := newTestJWKS(t, rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("p256", &p256.PublicKey), ecJWK("p384", &p384.PublicKey))
+
+	auth, err := newJWTAuth(JWTConfig{Enable: true, Issuer: "https://issuer", Audience: "leaf", JWKSURL: jwks.URL,
+		BizTagsClaim: "biz_tags", AdminClaim: "leaf_admin", Leeway: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(auth.stop)
+
+	now := time.Now().Unix()
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"sub": "svc", "iss": "https://issuer", "aud": "leaf", "exp": now + 60, "biz_tags": "order user"}
+		for name, value := range overrides {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+	valid := signJWT(t, "RS256", "rsa", rsaKey, 0, claims(nil))
+
+	// HS256 以 RSA 公钥作为 HMAC 密钥签名, 验证方若按 alg 选择算法就会被伪造
+	pubDer, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	hsSigned := encodeSegment(t, map[string]string{"alg": "HS256", "kid": "rsa"}) + "." + encodeSegment(t, claims(nil))
+	mac := hmac.New(crypto.SHA256.New, pubDer)
+	mac.Write([]byte(hsSigned))
+
+	// 修改 token 中的签名
+	editSig := func(token string, edit func(sig []byte) []byte) string {
+		i := strings.LastIndex(token, ".")
+		sig, _ := base64.RawURLEncoding.DecodeString(token[i+1:])
+		return token[:i+1] + base64.RawURLEncoding.EncodeToString(edit(sig))
+	}
+	tamper := func(sig []byte) []byte { sig[len(sig)-1] ^= 0xff; return sig }
+	es256 := signJWT(t, "ES256", "p256", p256, 32, claims(nil))
+
+	for _, c := range []struct {
+		name  string
+		token string
+		err   string // 为空表示认证通过
+	}{
+		{"rs256", valid, ""},
+		{"es256", es256, ""},
+		{"es384", signJWT(t, "ES384", "p384", p384, 48, claims(nil)), ""},
+		{"bad signature", editSig(valid, tamper), "verification error"},
+		{"bad es signature", editSig(es256, tamper), "invalid signature"},
+		{"es signature too short", editSig(es256, func(sig []byte) []byte { return sig[2:] }), "invalid signature length"},
+		{"es signature too long", signJWT(t, "ES256", "p256", p256, 48, claims(nil)), "invalid signature length"},
+		{"es alg with another curve", signJWT(t, "ES384", "p256", p256, 32, claims(nil)), "does not match key"},
+		{"es alg with rsa key", signJWT(t, "ES256", "rsa", p256, 32, claims(nil)), "does not match key"},
+		{"rs alg with ec key", signJWT(t, "RS256", "p256", rsaKey, 0, claims(nil)), "does not match key"},
+		{"alg none", signJWT(t, "none", "rsa", nil, 0, claims(nil)), "unsupported alg"},
+		{"hs256 with rsa key", hsSigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), "unsupported alg"},
+		{"expired", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"exp": now - 10})), "expired"},
+		{"expired within leeway", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"exp": now - 2})), ""},
+		{"missing exp", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"exp": nil})), "expired"},
+		{"not yet valid", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"nbf": now + 10})), "not yet valid"},
+		{"nbf within leeway", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"nbf": now + 2})), ""},
+		{"issuer mismatch", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"iss": "https://other"})), "issuer mismatch"},
+		{"audience mismatch", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"aud": []string{"other"}})), "audience mismatch"},
+		{"audience in list", signJWT(t, "RS256", "rsa", rsaKey, 0, claims(map[string]any{"aud": []string{"other", "leaf"}})), ""},
+		{"malformed", "a.b", "malformed jwt"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/alloc", nil)
+		r.Header.Set("Authorization", "Bearer "+c.token)
+		p, err := auth.authenticate(r)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.err == "" && (p.name != "svc" || !p.bizTags["order"] || !p.bizTags["user"] || p.admin):
+			t.Errorf("%s: principal = %+v, want svc with order and user", c.name, p)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+		}
+	}
+
+	// 没有携带 bearer token 时交给下一种认证方式
+	if _, err := auth.authenticate(httptest.NewRequest(http.MethodGet, "/alloc", nil)); !errors.Is(err, errNoCredentials) {
+		t.Fatalf("no token err = %v, want errNoCredentials", err)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	setupTestConfig(t)
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := newTestJWKS(t, ecJWK("old", &oldKey.PublicKey))
+
+	auth, err := newJWTAuth(JWTConfig{Enable: true, JWKSURL: jwks.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(auth.stop)
+	authenticate := func(token string) error {
+		r := httptest.NewRequest(http.MethodGet, "/alloc", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		_, err := auth.authenticate(r)
+		return err
+	}
+
+	// 签发方轮换密钥后出现未知的 kid, 距上次刷新不足一分钟时不请求 JWKS
+	jwks.setKeys(ecJWK("old", &oldKey.PublicKey), ecJWK("new", &newKey.PublicKey))
+	token := signJWT(t, "ES256", "new", newKey, 32, map[string]any{"sub": "svc", "exp": time.Now().Unix() + 60})
+	requests := jwks.requests.Load()
+	if err := authenticate(token); err == nil || !strings.Contains(err.Error(), "unknown jwt kid") {
+		t.Fatalf("err = %v, want unknown kid", err)
+	}
+	if jwks.requests.Load() != requests {
+		t.Fatal("jwks refreshed within a minute of the last refresh")
+	}
+
+	// 超过一分钟后未知的 kid 触发一次刷新, 之后使用新公钥验证
+	auth.mutex.Lock()
+	auth.lastRefresh = time.Now().Add(-2 * time.Minute)
+	auth.mutex.Unlock()
+	if err := authenticate(token); err != nil {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if got := jwks.requests.Load(); got != requests+1 {
+		t.Fatalf("jwks requested %d times, want 1", got-requests)
+	}
+
+	// 刷新后仍然未知的 kid 不会再次触发刷新
+	bogus := signJWT(t, "ES256", "bogus", newKey, 32, map[string]any{"sub": "svc", "exp": time.Now().Unix() + 60})
+	if err := authenticate(bogus); err == nil {
+		t.Fatal("unknown kid accepted")
+	}
+	if got := jwks.requests.Load(); got != requests+1 {
+		t.Fatalf("jwks requested %d times, want 1", got-requests)
+	}
+}
+
+func TestJWTRefreshStop(t *testing.T) {
+	setupTestConfig(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := newTestJWKS(t, ecJWK("k", &key.PublicKey))
+
+	auth, err := newJWTAuth(JWTConfig{Enable: true, JWKSURL: jwks.URL, RefreshInterval: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := sync.OnceFunc(auth.stop)
+	t.Cleanup(stop)
+	waitFor(t, "periodic refresh", func() bool { return jwks.requests.Load() >= 3 })
+
+	// 退出时停止定期刷新
+	stop()
+	requests := jwks.requests.Load()
+	time.Sleep(20 * time.Millisecond)
+	if got := jwks.requests.Load(); got != requests {
+		t.Fatalf("%d jwks requests after stop", got-requests)
+	}
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// KubernetesConfig 定义以 Deployment 或 StatefulSet 运行时的配置
+// 实例标识取自 Pod 名称, 注册地址取自 Pod IP, 均通过 downward API 注入的环境变量读取;
+// 主端口提供 /livez 和 /readyz 探针; 收到 SIGTERM(或 preStop 请求管理端口的 /admin/drain)后先让 /readyz 失败并注销服务,
+// 等待 drain_delay 让 Service 摘除端点, 再停止监听并排空处理中的请求; terminationGracePeriodSeconds 应大于 drain_delay 与 shutdown_timeout 之和
+type KubernetesConfig struct {
+	Enable               bool     `json:"enable"`                 // 是否启用
+	PodNameEnv           string   `json:"pod_name_env"`           // Pod 名称的环境变量, 未设置时使用主机名(即 Pod 名称)
+	PodIPEnv             string   `json:"pod_ip_env"`             // Pod IP 的环境变量, registry.addr 为空时注册 Pod IP
+	PartitionFromOrdinal bool     `json:"partition_from_ordinal"` // 启用分区时以 StatefulSet 序号(Pod 名称末尾的 -N)作为 partition.instance
+	ReadyTags            []string `json:"ready_tags"`             // 这些业务在内存中都有号码时才就绪, 启动时预加载
+	DrainDelay           int      `json:"drain_delay"`            // 开始退出后 /readyz 失败到停止监听的等待时间（毫秒）
+}
+
+// ProbeResponse 用于封装探针请求的响应
+type ProbeResponse struct {
+	Status   string   `json:"status"`             // ok 或 fail
+	Checks   []string `json:"checks,omitempty"`   // 失败的检查项
+	Role     string   `json:"role,omitempty"`     // 启用选主时本实例的角色
+	Instance string   `json:"instance,omitempty"` // 实例标识, 以 Kubernetes 运行时为 Pod 名称
+}
+
+// draining 是否正在退出, 退出期间 /readyz 失败
+var draining atomic.Bool
+
+// InitKubernetes 按 Pod 信息补全实例标识、分区编号和注册地址, 需在初始化台账和分配器之前调用
+func InitKubernetes() error {
+	conf := DefaultConfig
+	if !conf.Kubernetes.Enable {
+		return nil
+	}
+
+	pod := podName(conf.Kubernetes)
+	if conf.Ledger.Instance == "" {
+		conf.Ledger.Instance = pod
+	}
+	if conf.Partition.Enable && conf.Kubernetes.PartitionFromOrdinal {
+		ordinal, err := podOrdinal(pod)
+		if err != nil {
+			return err
+		}
+		conf.Partition.Instance = ordinal
+	}
+	if conf.Registry.Addr == "" && conf.Kubernetes.PodIPEnv != "" {
+		if ip := os.Getenv(conf.Kubernetes.PodIPEnv); ip != "" {
+			scheme := "http"
+			if conf.TLS.Enable {
+				scheme = "https"
+			}
+			conf.Registry.Addr = scheme + "://" + ip + ":" + strconv.Itoa(conf.HttpPort)
+		}
+	}
+	logger.Info("kubernetes identity", "pod", pod, "instance", conf.Ledger.Instance,
+		"partition", conf.Partition.Instance, "registry_addr", conf.Registry.Addr)
+	return nil
+}
+
+// podName 返回 Pod 名称, 环境变量未设置时使用主机名
+func podName(conf KubernetesConfig) string {
+	if conf.PodNameEnv != "" {
+		if name := os.Getenv(conf.PodNameEnv); name != "" {
+			return name
+		}
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// podOrdinal 从 StatefulSet 的 Pod 名称 {name}-{ordinal} 中解析序号
+func podOrdinal(pod string) (int, error) {
+	index := strings.LastIndexByte(pod, '-')
+	if index < 0 {
+		return 0, fmt.Errorf("pod name %q has no statefulset ordinal", pod)
+	}
+	ordinal, err := strconv.Atoi(pod[index+1:])
+	if err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("pod name %q has no statefulset ordinal", pod)
+	}
+	return ordinal, nil
+}
+
+// warmReadyTags 预加载就绪所需的业务
+func warmReadyTags(alloc *Alloc) {
+	if !DefaultConfig.Kubernetes.Enable || standby() {
+		return
+	}
+	for _, bizTag := range DefaultConfig.Kubernetes.ReadyTags {
+		alloc.warm(bizTag)
+	}
+}
+
+// drain 开始退出: /readyz 失败, 注销服务, 然后等待 drain_delay; 已经开始过时立即返回
+func drain(ctx context.Context) {
+	if draining.Swap(true) {
+		return
+	}
+	if err := stopRegistry(ctx); err != nil {
+		logger.Warn("deregister failed", "err", err)
+	}
+
+	delay := time.Duration(DefaultConfig.Kubernetes.DrainDelay) * time.Millisecond
+	if !DefaultConfig.Kubernetes.Enable || delay <= 0 {
+		return
+	}
+
+	logger.Info("draining, waiting for endpoints removal", "delay", delay.String())
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// handleLivez 存活探针: 分配器已初始化即存活, 号段存储不可用时重启也无法恢复, 因此不影响存活
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	resp := ProbeResponse{Status: "ok"}
+	if DefaultAlloc == nil {
+		resp.Status, resp.Checks = "fail", []string{"allocator"}
+	}
+	writeProbe(w, resp)
+}
+
+// handleReadyz 就绪探针: 未在退出、不是备用实例、号段存储未熔断, 且 ready_tags 中的业务在内存中都有号码
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := ProbeResponse{Status: "ok", Role: role(), Instance: instanceName(DefaultConfig)}
+	if draining.Load() {
+		resp.Checks = append(resp.Checks, "draining")
+	}
+	if standby() {
+		resp.Checks = append(resp.Checks, "standby")
+	}
+	if DefaultAlloc == nil {
+		resp.Checks = append(resp.Checks, "allocator")
+	} else {
+		if breaker := DefaultAlloc.breaker(); breaker != nil && breaker.State() == breakerStateNames[breakerOpen] {
+			resp.Checks = append(resp.Checks, "breaker")
+		}
+		for _, bizTag := range DefaultConfig.Kubernetes.ReadyTags {
+			if DefaultAlloc.LeftCount(bizTag) == 0 {
+				resp.Checks = append(resp.Checks, "biz_tag:"+bizTag)
+			}
+		}
+	}
+	if len(resp.Checks) != 0 {
+		resp.Status = "fail"
+	}
+	writeProbe(w, resp)
+}
+
+// handleAdminDrain 供 preStop 钩子调用: 开始退出并等待 drain_delay 后返回, 之后收到 SIGTERM 时不再等待
+func handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	if !DefaultConfig.Kubernetes.Enable {
+		writeError(w, http.StatusNotFound, ErrNoFailed, "kubernetes is not enabled")
+		return
+	}
+	drain(r.Context())
+	writeProbe(w, ProbeResponse{Status: "ok", Checks: []string{"draining"}})
+}
+
+// writeProbe 写入探针响应, 失败时返回 HTTP 503
+func writeProbe(w http.ResponseWriter, resp ProbeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
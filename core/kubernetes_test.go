@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInitKubernetes(t *testing.T) {
+	setupTestConfig(t)
+	t.Setenv("POD_NAME", "leaf-segment-3")
+	t.Setenv("POD_IP", "10.1.2.3")
+	DefaultConfig.HttpPort = 8880
+	DefaultConfig.Kubernetes = KubernetesConfig{Enable: true, PodNameEnv: "POD_NAME", PodIPEnv: "POD_IP", PartitionFromOrdinal: true}
+	DefaultConfig.Partition = PartitionConfig{Enable: true, Count: 4, Separator: "#"}
+
+	if err := InitKubernetes(); err != nil {
+		t.Fatal(err)
+	}
+	if DefaultConfig.Ledger.Instance != "leaf-segment-3" || DefaultConfig.Partition.Instance != 3 || DefaultConfig.Registry.Addr != "http://10.1.2.3:8880" {
+		t.Fatalf("instance = %q, partition = %d, registry addr = %q", DefaultConfig.Ledger.Instance, DefaultConfig.Partition.Instance, DefaultConfig.Registry.Addr)
+	}
+
+	// Deployment 的 Pod 名称没有序号
+	t.Setenv("POD_NAME", "leaf-segment-7d9f8b6c4-x2k9p")
+	if err := InitKubernetes(); err == nil {
+		t.Fatal("InitKubernetes accepted a pod name without ordinal")
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	setupHandlerTest(t)
+	DefaultConfig.Kubernetes = KubernetesConfig{Enable: true, ReadyTags: []string{"test"}, DrainDelay: 50}
+	draining.Store(false)
+	t.Cleanup(func() { draining.Store(false) })
+
+	probe := func(handler http.HandlerFunc) (int, ProbeResponse) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp ProbeResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	// 就绪所需的业务还没有号码
+	if code, resp := probe(handleReadyz); code != http.StatusServiceUnavailable || len(resp.Checks) != 1 || resp.Checks[0] != "biz_tag:test" {
+		t.Fatalf("readyz = (%d, %+v), want biz_tag:test failing", code, resp)
+	}
+	warmReadyTags(DefaultAlloc)
+	deadline := time.Now().Add(5 * time.Second)
+	for DefaultAlloc.LeftCount("test") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if code, resp := probe(handleReadyz); code != http.StatusOK || resp.Status != "ok" {
+		t.Fatalf("readyz = (%d, %+v), want ok", code, resp)
+	}
+	if code, _ := probe(handleLivez); code != http.StatusOK {
+		t.Fatalf("livez = %d, want 200", code)
+	}
+
+	// preStop 开始退出后就绪探针失败, 存活探针不受影响, 之后的退出不再等待
+	start := time.Now()
+	if code, _ := probe(handleAdminDrain); code != http.StatusOK || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("drain = %d after %v, want 200 after drain_delay", code, time.Since(start))
+	}
+	if code, resp := probe(handleReadyz); code != http.StatusServiceUnavailable || resp.Checks[0] != "draining" {
+		t.Fatalf("readyz = (%d, %+v), want draining", code, resp)
+	}
+	if code, _ := probe(handleLivez); code != http.StatusOK {
+		t.Fatalf("livez = %d while draining, want 200", code)
+	}
+	start = time.Now()
+	drain(context.Background())
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("second drain waited %v", elapsed)
+	}
+}
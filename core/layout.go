@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrIdOverflow 号码超出 ID 布局中序号部分的位数
+var ErrIdOverflow = errors.New("id overflows the sequence bits of the layout")
+
+// LayoutConfig 定义对外 ID 的位布局
+// 启用后 ID 为 符号位(1位) + 数据中心(datacenter_bits 位) + 序号(其余位), 序号为号段中的号码(启用分区时为分区映射后的号码), 不再叠加毫秒时间戳
+// 多地多活时每个数据中心使用各自的号段存储, 即使各地号段表的进度不一致, 不同数据中心的 ID 也不会重复
+type LayoutConfig struct {
+	Enable         bool                     `json:"enable"`          // 是否启用位布局
+	DatacenterBits int                      `json:"datacenter_bits"` // 数据中心编号占用的位数, 1 ~ 31, 序号占用其余的 63 - datacenter_bits 位
+	Datacenter     int                      `json:"datacenter"`      // 本实例的数据中心编号, 0 ~ 2^datacenter_bits - 1
+	Datacenters    map[int]DatacenterConfig `json:"datacenters"`     // 按数据中心编号配置号段存储, 本实例使用 datacenter 对应的一项, 没有时使用 dsn 和 dsns
+}
+
+// DatacenterConfig 定义一个数据中心的号段存储, 各数据中心可以共用同一份配置文件
+type DatacenterConfig struct {
+	DSN  string   `json:"dsn"`  // 该数据中心的数据库连接字符串
+	DSNs []string `json:"dsns"` // 该数据中心按优先级排列的多个数据库连接字符串, 配置后忽略 dsn
+}
+
+// checkLayout 检查位布局配置
+func checkLayout(conf *Config) error {
+	layout := conf.Layout
+	if !layout.Enable {
+		return nil
+	}
+	if layout.DatacenterBits < 1 || layout.DatacenterBits > 31 {
+		return fmt.Errorf("layout.datacenter_bits %d out of range 1 ~ 31", layout.DatacenterBits)
+	}
+	if layout.Datacenter < 0 || layout.Datacenter >= 1<<layout.DatacenterBits {
+		return fmt.Errorf("layout.datacenter %d does not fit in %d bits", layout.Datacenter, layout.DatacenterBits)
+	}
+	if conf.Lease.Enable {
+		return errors.New("lease cannot be enabled with layout: leased ranges carry no datacenter bits")
+	}
+	return nil
+}
+
+// compose 将序号与数据中心编号组合为对外的 ID
+func (layout LayoutConfig) compose(sequence int64) (int64, error) {
+	sequenceBits := 63 - layout.DatacenterBits
+	if sequence < 0 || sequence >= 1<<sequenceBits {
+		return 0, ErrIdOverflow
+	}
+	return int64(layout.Datacenter)<<sequenceBits | sequence, nil
+}
+
+// datacenterDSNs 返回本实例所在数据中心的 DSN 列表, 未启用位布局或未单独配置时返回 false
+func datacenterDSNs(conf *Config) ([]string, bool) {
+	if !conf.Layout.Enable {
+		return nil, false
+	}
+	datacenter, ok := conf.Layout.Datacenters[conf.Layout.Datacenter]
+	if !ok {
+		return nil, false
+	}
+	if len(datacenter.DSNs) != 0 {
+		return datacenter.DSNs, true
+	}
+	return []string{datacenter.DSN}, true
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	setupTestConfig(t)
+
+	// 两个数据中心的号段存储进度相同, 组合后的 ID 仍不重复
+	seen := map[int64]int{}
+	for datacenter := 0; datacenter < 2; datacenter++ {
+		conf := &Config{Layout: LayoutConfig{Enable: true, DatacenterBits: 5, Datacenter: datacenter}}
+		if err := checkLayout(conf); err != nil {
+			t.Fatal(err)
+		}
+		alloc := newTestAlloc(t, newFakeStorage(100))
+		alloc.conf = conf
+
+		for i := 0; i < 10; i++ {
+			id, err := alloc.NextId(context.Background(), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := int(id >> 58); got != datacenter {
+				t.Fatalf("id %d carries datacenter %d, want %d", id, got, datacenter)
+			}
+			if other, ok := seen[id]; ok {
+				t.Fatalf("id %d allocated in datacenter %d and %d", id, other, datacenter)
+			}
+			seen[id] = datacenter
+		}
+	}
+
+	// 序号超出剩余位数时拒绝, 不与其他数据中心的 ID 重叠
+	layout := LayoutConfig{Enable: true, DatacenterBits: 31, Datacenter: 1}
+	if id, err := layout.compose(1<<32 - 1); err != nil || id != 1<<32|(1<<32-1) {
+		t.Fatalf("compose = (%d, %v)", id, err)
+	}
+	if _, err := layout.compose(1 << 32); !errors.Is(err, ErrIdOverflow) {
+		t.Fatalf("compose overflow err = %v, want ErrIdOverflow", err)
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	for _, conf := range []Config{
+		{Layout: LayoutConfig{Enable: true, DatacenterBits: 0}},
+		{Layout: LayoutConfig{Enable: true, DatacenterBits: 32}},
+		{Layout: LayoutConfig{Enable: true, DatacenterBits: 2, Datacenter: 4}},
+		{Layout: LayoutConfig{Enable: true, DatacenterBits: 2, Datacenter: -1}},
+		{Layout: LayoutConfig{Enable: true, DatacenterBits: 2}, Lease: LeaseConfig{Enable: true}},
+	} {
+		if err := checkLayout(&conf); err == nil {
+			t.Fatalf("checkLayout(%+v) = nil, want error", conf.Layout)
+		}
+	}
+}
+
+func TestDatacenterDSNs(t *testing.T) {
+	conf := &Config{DSN: "default", Layout: LayoutConfig{Enable: true, DatacenterBits: 2, Datacenter: 1, Datacenters: map[int]DatacenterConfig{
+		0: {DSN: "dc0"},
+		1: {DSN: "dc1", DSNs: []string{"dc1-a", "dc1-b"}},
+	}}}
+	if dsns := dsnList(conf); !slices.Equal(dsns, []string{"dc1-a", "dc1-b"}) {
+		t.Fatalf("dsnList = %v, want datacenter 1", dsns)
+	}
+	conf.Layout.Datacenter = 2
+	if dsns := dsnList(conf); !slices.Equal(dsns, []string{"default"}) {
+		t.Fatalf("dsnList = %v, want fallback to dsn", dsns)
+	}
+}
@@ -0,0 +1,352 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLeaseNotFound 租约不存在, 已过期、已归还或属于其他业务
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// LeaseConfig 定义号段租约的配置
+// 客户端租用整个号段后在本地分配, 用完前再来续租, 每个号段只访问一次服务端
+type LeaseConfig struct {
+	Enable        bool `json:"enable"`         // 是否提供 /lease 接口
+	TTL           int  `json:"ttl"`            // 租约有效期（毫秒）, 客户端需在到期前续约, 0 表示默认 60 秒
+	SweepInterval int  `json:"sweep_interval"` // 检查过期租约的间隔（毫秒）, 0 表示有效期的一半
+}
+
+// LeaseResponse 用于封装租用、续约和归还号段请求的响应, 租出的号段为 [Left, Right)
+type LeaseResponse struct {
+	ErrNo     int       `json:"err_no"`              // 错误码
+	Msg       string    `json:"msg"`                 // 错误或成功消息
+	LeaseID   string    `json:"lease_id"`            // 租约标识, 续约和归还时携带
+	Left      int64     `json:"left"`                // 号段左边界（包含）
+	Right     int64     `json:"right"`               // 号段右边界（不包含）
+	ExpireAt  time.Time `json:"expire_at"`           // 租约到期时间
+	TTL       int64     `json:"ttl"`                 // 租约有效期（毫秒）, 客户端据此安排续约, 不受两端时钟偏差影响
+	Retryable bool      `json:"retryable,omitempty"` // 失败时重试是否可能成功, 缺省为 false
+	Cause     string    `json:"cause,omitempty"`     // 失败时的错误原因, 取值见 Cause 常量
+}
+
+// LeaseInfo 一个未到期的租约, 用于管理接口查询
+type LeaseInfo struct {
+	LeaseID  string    `json:"lease_id"`  // 租约标识
+	BizTag   string    `json:"biz_tag"`   // 业务标识
+	Caller   string    `json:"caller"`    // 租用的调用方, 未启用认证时为 anonymous
+	Addr     string    `json:"addr"`      // 租用的来源地址
+	Left     int64     `json:"left"`      // 号段左边界（包含）
+	Right    int64     `json:"right"`     // 号段右边界（不包含）
+	Next     int64     `json:"next"`      // 客户端最近一次报告的下一个未使用号码
+	Renewals int       `json:"renewals"`  // 续约次数
+	LeasedAt time.Time `json:"leased_at"` // 租用时间
+	ExpireAt time.Time `json:"expire_at"` // 到期时间
+}
+
+// LeasesResponse 用于封装租约查询请求的响应
+type LeasesResponse struct {
+	ErrNo  int         `json:"err_no"` // 错误码
+	Msg    string      `json:"msg"`    // 错误或成功消息
+	Leases []LeaseInfo `json:"leases"` // 未到期的租约, 按租用时间排序
+}
+
+// leaseTable 记录租出的号段和客户端归还的剩余号码
+// 过期的租约只记为烧毁, 不再租给其他客户端: 客户端可能只是失联而仍在使用
+// 只有客户端主动归还的剩余号码才会被回收, 优先租给下一个客户端
+type leaseTable struct {
+	mutex     sync.Mutex
+	leases    map[string]*LeaseInfo // 未到期的租约(lease_id -> 租约)
+	reclaimed map[string][]idRange  // 各业务归还的剩余号码
+	sweepOnce sync.Once             // 第一次租用时启动过期检查
+}
+
+// leaseTTL 返回租约有效期
+func (alloc *Alloc) leaseTTL() time.Duration {
+	if ttl := alloc.conf.Lease.TTL; ttl > 0 {
+		return time.Duration(ttl) * time.Millisecond
+	}
+	return time.Minute
+}
+
+// newToken 生成不可猜测的租约或预留标识, 持有标识即可续约、确认和归还
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Lease 租给客户端一个完整的号段, 优先使用其他客户端归还的剩余号码, 否则从号段存储获取, 不占用内存中的双Buffer
+// 租出的号码不叠加 NextId 的毫秒时间戳, 同一个业务应只使用租约或只使用 /alloc 中的一种
+func (alloc *Alloc) Lease(ctx context.Context, bizTag string, caller string, addr string) (lease LeaseInfo, err error) {
+	var (
+		bizAlloc *BizAlloc
+		table    = &alloc.leases
+		maxId    int64
+		step     int64
+	)
+
+	// 缓存期内已知不存在的业务, 不访问号段存储
+	if err = alloc.unknown(bizTag); err != nil {
+		return
+	}
+	if alloc.resets[bizTag] != nil { // 租出的号段不会随周期作废
+		err = errors.New("lease is not supported for periodically reset biz_tag")
+		return
+	}
+	bizAlloc = alloc.loadOrCreate(bizTag)
+	if err = alloc.takeQuota(bizAlloc, 0); err != nil { // 已用完配额时不再租出, 租出后按号段大小计入
+		atomic.AddInt64(&bizAlloc.metrics.leaseFail, 1)
+		return
+	}
+
+	table.sweepOnce.Do(func() { go alloc.sweepLoop(alloc.conf.Lease.SweepInterval, alloc.leaseTTL(), alloc.sweepLeases) })
+	lease = LeaseInfo{LeaseID: newToken(), BizTag: bizTag, Caller: caller, Addr: addr}
+
+	// 优先租出归还的号码
+	table.mutex.Lock()
+	if ranges := table.reclaimed[bizTag]; len(ranges) != 0 {
+		lease.Left, lease.Right = ranges[0].left, ranges[0].right
+		table.reclaimed[bizTag] = ranges[1:]
+	}
+	table.mutex.Unlock()
+
+	if lease.Right == 0 {
+		// 与补偿线程共用获取许可, 大量租约请求不会耗尽数据库连接
+		if err = alloc.acquireFetch(ctx); err != nil {
+			return
+		}
+		startTime := alloc.now()
+		maxId, step, err = alloc.storage.NextId(ctx, bizTag, 1)
+		elapsed := alloc.since(startTime)
+		bizAlloc.metrics.fetchLatency.observe(elapsed)
+		alloc.recordFetch(bizAlloc, elapsed)
+		alloc.releaseFetch()
+		if err != nil {
+			if isStorageFault(err) { // 业务不存在或号码耗尽不属于存储故障
+				alloc.markStorageError()
+			} else if errors.Is(err, ErrBizTagNotFound) {
+				alloc.dropUnknown(bizAlloc)
+			}
+			atomic.AddInt64(&bizAlloc.metrics.leaseFail, 1)
+			statsd.Incr("lease", "biz_tag:"+bizTag, "result:fail")
+			alloc.loadHooks().onError(ctx, bizTag, err)
+			return
+		}
+		lease.Left, lease.Right = maxId-step, maxId
+		atomic.StoreInt64(&bizAlloc.maxId, maxId)
+		alloc.loadHooks().onSegmentFetch(ctx, bizTag, lease.Left, lease.Right)
+	}
+	alloc.chargeQuota(bizTag, lease.Right-lease.Left)
+	atomic.AddInt64(&bizAlloc.metrics.leaseSuccess, 1)
+	statsd.Incr("lease", "biz_tag:"+bizTag, "result:success")
+
+	lease.Next = lease.Left
+	lease.LeasedAt = alloc.now()
+	lease.ExpireAt = lease.LeasedAt.Add(alloc.leaseTTL())
+
+	table.mutex.Lock()
+	if table.leases == nil {
+		table.leases = map[string]*LeaseInfo{}
+	}
+	entry := lease
+	table.leases[lease.LeaseID] = &entry
+	table.mutex.Unlock()
+
+	logger.Debug("segment leased", "biz_tag", bizTag, "lease_id", lease.LeaseID, "caller", caller,
+		"left", lease.Left, "right", lease.Right)
+	return
+}
+
+// RenewLease 延长租约有效期, next 为客户端下一个未使用的号码, 用于统计过期时烧毁的号码数
+func (alloc *Alloc) RenewLease(bizTag string, leaseID string, next int64) (lease LeaseInfo, err error) {
+	table := &alloc.leases
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	entry := table.leases[leaseID]
+	if entry == nil || entry.BizTag != bizTag {
+		return lease, ErrLeaseNotFound
+	}
+	entry.Next = min(max(next, entry.Next), entry.Right)
+	entry.ExpireAt = alloc.now().Add(alloc.leaseTTL())
+	entry.Renewals++
+	return *entry, nil
+}
+
+// ReleaseLease 客户端归还租约, [next, right) 中未使用的号码被回收, 租给下一个客户端
+func (alloc *Alloc) ReleaseLease(bizTag string, leaseID string, next int64) (lease LeaseInfo, err error) {
+	table := &alloc.leases
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	entry := table.leases[leaseID]
+	if entry == nil || entry.BizTag != bizTag {
+		return lease, ErrLeaseNotFound
+	}
+	delete(table.leases, leaseID)
+
+	// 只回收客户端声明未使用的部分, next 不能回退到已报告使用过的号码之前
+	entry.Next = min(max(next, entry.Next), entry.Right)
+	if entry.Next < entry.Right {
+		if table.reclaimed == nil {
+			table.reclaimed = map[string][]idRange{}
+		}
+		table.reclaimed[bizTag] = append(table.reclaimed[bizTag], idRange{left: entry.Next, right: entry.Right})
+		atomic.AddInt64(&alloc.loadOrCreate(bizTag).metrics.leaseReclaimed, entry.Right-entry.Next)
+	}
+	logger.Debug("lease released", "biz_tag", bizTag, "lease_id", leaseID, "reclaimed", entry.Right-entry.Next)
+	return *entry, nil
+}
+
+// Leases 返回未到期的租约, bizTag 为空表示全部业务
+func (alloc *Alloc) Leases(bizTag string) (leases []LeaseInfo) {
+	table := &alloc.leases
+	table.mutex.Lock()
+	for _, entry := range table.leases {
+		if bizTag == "" || entry.BizTag == bizTag {
+			leases = append(leases, *entry)
+		}
+	}
+	table.mutex.Unlock()
+
+	sort.Slice(leases, func(i, j int) bool { return leases[i].LeasedAt.Before(leases[j].LeasedAt) })
+	return
+}
+
+// sweepLeases 删除过期的租约, 其中客户端未报告使用的号码记为烧毁
+func (alloc *Alloc) sweepLeases() {
+	var (
+		now     = alloc.now()
+		expired []LeaseInfo
+	)
+
+	table := &alloc.leases
+	table.mutex.Lock()
+	for leaseID, entry := range table.leases {
+		if !now.Before(entry.ExpireAt) {
+			expired = append(expired, *entry)
+			delete(table.leases, leaseID)
+		}
+	}
+	table.mutex.Unlock()
+
+	for _, lease := range expired {
+		burned := lease.Right - lease.Next
+		atomic.AddInt64(&alloc.loadOrCreate(lease.BizTag).metrics.leaseBurned, burned)
+		logger.Warn("lease expired, remaining ids burned", "biz_tag", lease.BizTag, "lease_id", lease.LeaseID,
+			"caller", lease.Caller, "addr", lease.Addr, "left", lease.Left, "right", lease.Right, "next", lease.Next,
+			"burned", burned)
+	}
+}
+
+// sweepLoop 每隔 interval 毫秒执行一次过期检查, 未配置时为有效期的一半, 分配器退出时停止
+func (alloc *Alloc) sweepLoop(interval int, ttl time.Duration, sweep func()) {
+	every := time.Duration(interval) * time.Millisecond
+	if every <= 0 {
+		every = ttl / 2
+	}
+	for {
+		timer := alloc.newTimer(every)
+		select {
+		case <-timer.C():
+			sweep()
+		case <-alloc.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// handleLease 处理租用、续约和归还号段的 HTTP 请求
+func handleLease(w http.ResponseWriter, r *http.Request) {
+	serveLease(DefaultAlloc, bizTagValidator, w, r)
+}
+
+// serveLease 使用指定的分配器处理租用(/lease)、续约(/lease/renew)和归还(/lease/release)请求
+// 续约和归还需要携带 lease_id 和 next, next 为客户端下一个未使用的号码
+func serveLease(alloc *Alloc, rule *bizTagRule, w http.ResponseWriter, r *http.Request) {
+	var (
+		resp   = LeaseResponse{} // 响应数据
+		err    error             // 错误信息
+		bizTag string            // 业务标签
+		lease  LeaseInfo         // 租约
+		next   int64             // 客户端下一个未使用的号码
+	)
+
+	// 解析请求参数
+	if err = r.ParseForm(); err != nil {
+		err = paramError(err.Error())
+		goto RESP
+	}
+
+	// 获取并验证 biz_tag 参数
+	if bizTag = r.Form.Get("biz_tag"); bizTag == "" {
+		err = paramError("need biz_tag param")
+		goto RESP
+	}
+	if err = rule.validate(bizTag); err != nil {
+		goto RESP
+	}
+
+	switch r.URL.Path {
+	case "/lease/renew", "/lease/release":
+		if next, err = strconv.ParseInt(r.Form.Get("next"), 10, 64); err != nil {
+			err = paramError("need next param")
+			goto RESP
+		}
+		if r.URL.Path == "/lease/renew" {
+			lease, err = alloc.RenewLease(bizTag, r.Form.Get("lease_id"), next)
+		} else {
+			lease, err = alloc.ReleaseLease(bizTag, r.Form.Get("lease_id"), next)
+		}
+	default:
+		lease, err = alloc.Lease(r.Context(), bizTag, callerName(r), r.RemoteAddr)
+	}
+	if err == nil && r.URL.Path != "/lease/release" {
+		resp.TTL = alloc.leaseTTL().Milliseconds()
+	}
+	resp.LeaseID, resp.Left, resp.Right, resp.ExpireAt = lease.LeaseID, lease.Left, lease.Right, lease.ExpireAt
+
+RESP:
+	// 设置响应信息和状态码, 错误映射与分配请求一致
+	if errors.Is(err, ErrLeaseNotFound) {
+		resp.ErrNo, resp.Msg = ErrNoFailed, err.Error()
+		resp.Cause, resp.Retryable = CauseParam, false
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		status, errNo, msg := allocFailure(r.Context(), bizTag, err)
+		resp.ErrNo = errNo
+		resp.Msg = msg
+		resp.Cause, resp.Retryable = errorCause(err, status, errNo)
+		w.WriteHeader(status)
+	} else {
+		resp.Msg = "success"
+	}
+
+	// 将响应数据编码为JSON并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes)
+	} else {
+		logger.Error("encode response failed", "code", CodeResponseEncode, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleAdminLeases 查询未到期的租约, 支持 biz_tag 参数
+func handleAdminLeases(w http.ResponseWriter, r *http.Request) {
+	resp := LeasesResponse{Msg: "success", Leases: DefaultAlloc.Leases(r.URL.Query().Get("biz_tag"))}
+
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(100)
+	alloc := newTestAlloc(t, storage)
+
+	// 租约直接来自号段存储, 不占用内存中的号段
+	lease, err := alloc.Lease(context.Background(), "test", "anonymous", "127.0.0.1:1234")
+	if err != nil || lease.Left != 0 || lease.Right != 100 || lease.LeaseID == "" {
+		t.Fatalf("Lease = (%+v, %v), want [0, 100)", lease, err)
+	}
+	if left := alloc.LeftCount("test"); left != 0 {
+		t.Fatalf("LeftCount = %d after leasing, want 0", left)
+	}
+
+	// 之后的分配和租约都不会与已租出的号段重叠
+	if id, err := alloc.loadOrCreate("test").nextId(context.Background()); err != nil || id != 100 {
+		t.Fatalf("nextId = (%d, %v), want (100, nil)", id, err)
+	}
+
+	w := httptest.NewRecorder()
+	serveLease(alloc, nil, w, httptest.NewRequest(http.MethodGet, "/lease?biz_tag=test", nil))
+	var resp LeaseResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %q", w.Code, w.Body.String())
+	}
+	if resp.Right-resp.Left != 100 || resp.Left < 200 || resp.LeaseID == "" || resp.ExpireAt.IsZero() {
+		t.Fatalf("leased %+v, want a new 100 id segment after the buffered ones", resp)
+	}
+
+	w = httptest.NewRecorder()
+	serveLease(alloc, nil, w, httptest.NewRequest(http.MethodGet, "/lease", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d without biz_tag, want 500", w.Code)
+	}
+}
+
+func TestLeaseRenewRelease(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc.clock = clock
+
+	lease, err := alloc.Lease(context.Background(), "test", "svc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 续约延长有效期, 记录客户端的进度
+	clock.advance(alloc.leaseTTL() / 2)
+	renewed, err := alloc.RenewLease("test", lease.LeaseID, 30)
+	if err != nil || renewed.Next != 30 || !renewed.ExpireAt.After(lease.ExpireAt) {
+		t.Fatalf("RenewLease = (%+v, %v), want next 30 and a later expiry", renewed, err)
+	}
+	if _, err = alloc.RenewLease("other", lease.LeaseID, 30); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("RenewLease with another biz_tag err = %v, want ErrLeaseNotFound", err)
+	}
+	if leases := alloc.Leases("test"); len(leases) != 1 || leases[0].Caller != "svc" {
+		t.Fatalf("Leases = %+v, want the outstanding lease", leases)
+	}
+
+	// 归还时 next 不能回退, 未使用的 [30, 100) 租给下一个客户端
+	if _, err = alloc.ReleaseLease("test", lease.LeaseID, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alloc.ReleaseLease("test", lease.LeaseID, 10); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("second ReleaseLease err = %v, want ErrLeaseNotFound", err)
+	}
+	next, err := alloc.Lease(context.Background(), "test", "svc", "")
+	if err != nil || next.Left != 30 || next.Right != 100 {
+		t.Fatalf("Lease after release = (%+v, %v), want the reclaimed [30, 100)", next, err)
+	}
+
+	// 回收的号码用完后重新从号段存储获取
+	if next, err = alloc.Lease(context.Background(), "test", "svc", ""); err != nil || next.Left != 100 {
+		t.Fatalf("Lease = (%+v, %v), want a new segment from 100", next, err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc.clock = clock
+
+	lease, err := alloc.Lease(context.Background(), "test", "svc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alloc.RenewLease("test", lease.LeaseID, 40); err != nil {
+		t.Fatal(err)
+	}
+
+	// 过期的租约被删除, 剩余号码记为烧毁而不是回收, 失联的客户端可能仍在使用
+	clock.advance(alloc.leaseTTL())
+	alloc.sweepLeases()
+	if leases := alloc.Leases(""); len(leases) != 0 {
+		t.Fatalf("Leases = %+v after expiry, want none", leases)
+	}
+	if burned := atomic.LoadInt64(&alloc.loadOrCreate("test").metrics.leaseBurned); burned != 60 {
+		t.Fatalf("burned %d ids, want 60", burned)
+	}
+	if _, err = alloc.RenewLease("test", lease.LeaseID, 50); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("RenewLease after expiry err = %v, want ErrLeaseNotFound", err)
+	}
+	if next, err := alloc.Lease(context.Background(), "test", "svc", ""); err != nil || next.Left < lease.Right {
+		t.Fatalf("Lease after expiry = (%+v, %v), want a segment after the burned one", next, err)
+	}
+
+	w := httptest.NewRecorder()
+	serveLease(alloc, nil, w, httptest.NewRequest(http.MethodGet, "/lease/renew?biz_tag=test&next=50&lease_id="+lease.LeaseID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("renew of an expired lease: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,262 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	CREATE TABLE `segment_ledger` (
+	 `id` bigint NOT NULL AUTO_INCREMENT,
+	 `time` datetime(3) NOT NULL,
+	 `instance` varchar(128) NOT NULL,
+	 `biz_tag` varchar(32) NOT NULL,
+	 `left_id` bigint NOT NULL,
+	 `right_id` bigint NOT NULL,
+	 PRIMARY KEY (`id`),
+	 KEY `idx_biz_tag` (`biz_tag`, `left_id`)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+*/
+
+// LedgerConfig 定义号段台账的配置, 记录每个实例获取到的号段, 用于追查重复ID的来源
+// 文件和数据库表可同时启用, 查询时优先使用数据库表
+type LedgerConfig struct {
+	File     string `json:"file"`     // 台账文件, 每行一条 JSON, 每次写入后同步到磁盘, 为空表示不写文件
+	Table    string `json:"table"`    // 台账数据库表, 为空表示不写数据库
+	Instance string `json:"instance"` // 实例标识, 为空时使用 主机名:HTTP端口
+}
+
+// LedgerEntry 一条号段台账记录, 号段为 [Left, Right)
+type LedgerEntry struct {
+	Time     time.Time `json:"time"`     // 获取号段的时间
+	Instance string    `json:"instance"` // 获取号段的实例
+	BizTag   string    `json:"biz_tag"`  // 业务标识
+	Left     int64     `json:"left"`     // 号段左边界（包含）
+	Right    int64     `json:"right"`    // 号段右边界（不包含）
+}
+
+// LedgerResponse 用于封装号段台账查询请求的响应
+type LedgerResponse struct {
+	ErrNo   int           `json:"err_no"`  // 错误码
+	Msg     string        `json:"msg"`     // 错误或成功消息
+	Entries []LedgerEntry `json:"entries"` // 台账记录, 按时间倒序
+}
+
+// segmentLedger 将获取到的号段追加写入文件和数据库表
+type segmentLedger struct {
+	conf     LedgerConfig
+	instance string
+	data     *Data      // 写入和查询数据库表使用的连接
+	mutex    sync.Mutex // 保证文件中每行完整
+	file     *os.File
+}
+
+// ledger 全局号段台账, 未启用时为 nil
+var ledger *segmentLedger
+
+// InitLedger 根据配置初始化号段台账, 数据库表依赖 InitData, 需在 InitAlloc 之前调用
+func InitLedger() (err error) {
+	ledger, err = newLedger(DefaultConfig, DefaultData)
+	return
+}
+
+// newLedger 按配置创建号段台账, 未配置文件和数据库表时返回 nil
+func newLedger(conf *Config, data *Data) (ledger *segmentLedger, err error) {
+	var (
+		file *os.File
+	)
+
+	if conf.Ledger.File == "" && conf.Ledger.Table == "" {
+		return
+	}
+	if conf.Ledger.File != "" {
+		if file, err = os.OpenFile(conf.Ledger.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640); err != nil {
+			return
+		}
+	}
+	ledger = &segmentLedger{conf: conf.Ledger, instance: instanceName(conf), data: data, file: file}
+	return
+}
+
+// ledgerStorage 记录每次从存储获取到的号段
+type ledgerStorage struct {
+	Storage
+	ledger *segmentLedger
+}
+
+// NextId 获取号段并写入台账
+func (storage *ledgerStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	if maxId, step, err = storage.Storage.NextId(ctx, bizTag, multiple); err == nil {
+		storage.ledger.record(bizTag, maxId-step*multiple, maxId)
+	}
+	return
+}
+
+// wrapLedger 启用台账时包装号段存储
+func wrapLedger(storage Storage, ledger *segmentLedger) Storage {
+	if ledger == nil {
+		return storage
+	}
+	return &ledgerStorage{Storage: storage, ledger: ledger}
+}
+
+// record 记录一个号段, 写入失败只输出日志, 号段仍然正常使用
+func (ledger *segmentLedger) record(bizTag string, left int64, right int64) {
+	entry := LedgerEntry{
+		Time:     time.Now(),
+		Instance: ledger.instance,
+		BizTag:   bizTag,
+		Left:     left,
+		Right:    right,
+	}
+
+	if ledger.file != nil {
+		line, _ := json.Marshal(&entry)
+		ledger.mutex.Lock()
+		_, err := ledger.file.Write(append(line, '\n'))
+		if err == nil {
+			err = ledger.file.Sync() // 每个号段只写一次, 同步的开销可以接受
+		}
+		ledger.mutex.Unlock()
+		if err != nil {
+			logger.Error("write ledger file failed", "file", ledger.conf.File, "biz_tag", bizTag,
+				"left", left, "right", right, "err", err)
+		}
+	}
+
+	if ledger.conf.Table != "" {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancelFunc()
+		_, err := ledger.data.current().ExecContext(ctx,
+			"INSERT INTO "+ledger.conf.Table+"(time, instance, biz_tag, left_id, right_id) VALUES(?, ?, ?, ?, ?)",
+			entry.Time, entry.Instance, entry.BizTag, entry.Left, entry.Right)
+		if err != nil {
+			logger.Error("write ledger table failed", "table", ledger.conf.Table, "biz_tag", bizTag,
+				"left", left, "right", right, "err", err)
+		}
+	}
+}
+
+// close 关闭台账文件
+func (ledger *segmentLedger) close() error {
+	if ledger.file == nil {
+		return nil
+	}
+	return ledger.file.Close()
+}
+
+// query 按时间倒序查询最近的台账记录, bizTag 为空表示全部业务, id 为负数表示不按号码过滤
+func (ledger *segmentLedger) query(ctx context.Context, bizTag string, id int64, limit int) ([]LedgerEntry, error) {
+	if ledger.conf.Table != "" {
+		return ledger.queryTable(ctx, bizTag, id, limit)
+	}
+	return ledger.queryFile(bizTag, id, limit)
+}
+
+// queryTable 从数据库表查询台账记录
+func (ledger *segmentLedger) queryTable(ctx context.Context, bizTag string, id int64, limit int) (entries []LedgerEntry, err error) {
+	var (
+		rows *sql.Rows
+	)
+
+	if rows, err = ledger.data.reader().QueryContext(ctx,
+		"SELECT time, instance, biz_tag, left_id, right_id FROM "+ledger.conf.Table+
+			" WHERE (? = '' OR biz_tag = ?) AND (? < 0 OR (left_id <= ? AND right_id > ?)) ORDER BY id DESC LIMIT ?",
+		bizTag, bizTag, id, id, id, limit); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry LedgerEntry
+		if err = rows.Scan(&entry.Time, &entry.Instance, &entry.BizTag, &entry.Left, &entry.Right); err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	return
+}
+
+// queryFile 从台账文件查询记录, 只保留最近的 limit 条
+func (ledger *segmentLedger) queryFile(bizTag string, id int64, limit int) (entries []LedgerEntry, err error) {
+	var (
+		file *os.File
+	)
+
+	if file, err = os.Open(ledger.conf.File); err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry LedgerEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil ||
+			(bizTag != "" && entry.BizTag != bizTag) ||
+			(id >= 0 && (id < entry.Left || id >= entry.Right)) {
+			continue
+		}
+		if entries = append(entries, entry); len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	// 文件按时间顺序写入, 倒序输出
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, scanner.Err()
+}
+
+// handleAdminSegments 查询号段台账, 支持 biz_tag、id 和 limit 参数
+// id 为号段内的号码, 不含分配接口返回时叠加的毫秒时间戳; 同一个号码出现在多条记录中说明号段被重复发放
+func handleAdminSegments(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp  = LedgerResponse{}       // 响应数据
+		err   error                    // 错误信息
+		id    int64              = -1  // 按号码过滤, 默认不过滤
+		limit                    = 100 // 默认返回的记录数
+	)
+
+	if ledger == nil {
+		w.WriteHeader(http.StatusNotFound)
+		resp.ErrNo, resp.Msg = -1, "segment ledger disabled"
+		goto RESP
+	}
+
+	if value := r.URL.Query().Get("id"); value != "" {
+		if id, err = strconv.ParseInt(value, 10, 64); err != nil || id < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.ErrNo, resp.Msg = -1, "invalid id"
+			goto RESP
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.ErrNo, resp.Msg = -1, "invalid limit"
+			goto RESP
+		}
+	}
+
+	if resp.Entries, err = ledger.query(r.Context(), r.URL.Query().Get("biz_tag"), id, limit); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		resp.ErrNo, resp.Msg = -1, err.Error()
+	} else {
+		resp.Msg = "success"
+	}
+
+RESP:
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestLedgerFile(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Ledger = LedgerConfig{File: filepath.Join(t.TempDir(), "ledger.log"), Instance: "node-1"}
+	if err := InitLedger(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ledger.close()
+		ledger = nil
+	})
+
+	storage := wrapLedger(newFakeStorage(10), ledger)
+	ctx := context.Background()
+	for _, bizTag := range []string{"order", "user", "order"} {
+		if _, _, err := storage.NextId(ctx, bizTag, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 按号码查找所属号段, 最近的记录在前
+	entries, err := ledger.query(ctx, "order", 15, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Left != 10 || entries[0].Right != 20 || entries[0].Instance != "node-1" {
+		t.Fatalf("entries = %+v, want order [10, 20) from node-1", entries)
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminSegments(w, httptest.NewRequest(http.MethodGet, "/admin/segments?limit=2", nil))
+	var resp LedgerResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %q", w.Code, w.Body.String())
+	}
+	if len(resp.Entries) != 2 || resp.Entries[0].BizTag != "order" || resp.Entries[1].BizTag != "user" {
+		t.Fatalf("entries = %+v, want the latest order and user segments", resp.Entries)
+	}
+
+	w = httptest.NewRecorder()
+	handleAdminSegments(w, httptest.NewRequest(http.MethodGet, "/admin/segments?id=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d for negative id, want 400", w.Code)
+	}
+}
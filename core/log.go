@@ -0,0 +1,199 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 日志中使用的错误码, 便于按类别检索和告警
+const (
+	CodeConfigInvalid  = "config_invalid"  // 配置文件加载或校验失败
+	CodeDataInit       = "data_init"       // 数据库初始化失败
+	CodeAllocInit      = "alloc_init"      // 分配器初始化失败
+	CodeServerExit     = "server_exit"     // HTTP服务异常退出
+	CodeSegmentFetch   = "segment_fetch"   // 从数据库获取号段失败
+	CodeSlowQuery      = "slow_query"      // 号段事务耗时超过阈值
+	CodeRefillGiveUp   = "refill_give_up"  // 补偿线程连续失败后放弃
+	CodeRefillStuck    = "refill_stuck"    // 看门狗发现补偿线程卡住或等待队列不清空
+	CodeAllocFail      = "alloc_fail"      // 分配ID失败
+	CodeResponseEncode = "response_encode" // 响应编码失败
+	CodePanic          = "panic"           // 捕获到 panic
+	CodeRequestTimeout = "request_timeout" // 请求处理超过时限
+	CodeVerifyFail     = "verify_fail"     // 独立校验发现重复或跳号
+)
+
+// LogConfig 定义日志输出的配置
+type LogConfig struct {
+	Level  string        `json:"level"`  // 日志级别: debug, info, warn, error
+	Format string        `json:"format"` // 输出格式: json 或 console
+	File   LogFileConfig `json:"file"`   // 日志文件输出, 未配置路径时输出到标准错误
+}
+
+// logLevel 全局日志级别, 可在运行时调整
+var logLevel = new(slog.LevelVar)
+
+// logFile 配置了日志文件时的输出文件, 重新初始化日志时关闭
+var logFile *rotatingFile
+
+// logger 全局日志对象, 未初始化前以console格式输出到标准错误
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+// Logger 返回全局日志对象
+func Logger() *slog.Logger {
+	return logger
+}
+
+// SetLogger 替换全局日志对象, 以组件方式使用时由调用方统一日志输出, 需在启动服务器之前调用
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// parseLevel 将配置中的日志级别字符串转换为slog级别
+func parseLevel(level string) (l slog.Level, err error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		err = fmt.Errorf("unknown log level: %s", level)
+	}
+	return
+}
+
+// checkLogConfig 检查日志级别、格式和日志文件配置, 不打开日志文件
+func checkLogConfig(conf LogConfig) (err error) {
+	if _, err = parseLevel(conf.Level); err != nil {
+		return
+	}
+	switch strings.ToLower(conf.Format) {
+	case "json", "", "console", "text":
+	default:
+		return fmt.Errorf("unknown log format: %s", conf.Format)
+	}
+	return checkLogFile(conf.File)
+}
+
+// InitLog 根据配置初始化全局日志
+func InitLog() (err error) {
+	var (
+		conf    = DefaultConfig.Log
+		level   slog.Level
+		handler slog.Handler
+		output  io.Writer     // 日志输出, 默认为标准错误
+		file    *rotatingFile // 配置了日志文件时打开的文件
+		opts    = &slog.HandlerOptions{Level: logLevel}
+	)
+
+	// 检查日志级别、输出格式和日志文件配置
+	if err = checkLogConfig(conf); err != nil {
+		return
+	}
+	level, _ = parseLevel(conf.Level)
+
+	// 配置了日志文件时写入文件, 按配置同时输出到标准错误
+	output = os.Stderr
+	if conf.File.Path != "" {
+		if file, err = openRotatingFile(conf.File); err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		output = file
+		if conf.File.Stderr {
+			output = io.MultiWriter(os.Stderr, file)
+		}
+	}
+	logLevel.Set(level)
+
+	if strings.ToLower(conf.Format) == "json" {
+		handler = slog.NewJSONHandler(output, opts)
+	} else {
+		handler = slog.NewTextHandler(output, opts)
+	}
+
+	logger = slog.New(handler)
+	slog.SetDefault(logger)
+
+	// 替换日志对象后关闭之前的日志文件
+	if logFile != nil {
+		_ = logFile.Close()
+	}
+	logFile = file
+	return
+}
+
+// redactDSN 隐藏DSN中的密码, 用于日志输出
+func redactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "<invalid dsn>"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = "***"
+	}
+	return cfg.FormatDSN()
+}
+
+// dsnAddr 返回DSN中的数据库地址, 用作指标标签
+func dsnAddr(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "<invalid dsn>"
+	}
+	return cfg.Addr
+}
+
+// redactReplicaDSN 隐藏只读副本DSN中的密码, 未配置时返回空
+func redactReplicaDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	return redactDSN(dsn)
+}
+
+// redactDSNs 隐藏多个DSN中的密码
+func redactDSNs(dsns []string) string {
+	result := make([]string, 0, len(dsns))
+	for _, dsn := range dsns {
+		result = append(result, redactDSN(dsn))
+	}
+	return strings.Join(result, ",")
+}
+
+// LogConfigSummary 输出启动时的配置摘要
+func LogConfigSummary() {
+	logger.Info("config loaded",
+		"store", DefaultConfig.Store.Type,
+		"dsn", redactDSNs(dsnList(DefaultConfig)),
+		"replica_dsn", redactReplicaDSN(DefaultConfig.ReplicaDSN),
+		"mysql_tls", DefaultConfig.MySQL.TLS.Enable,
+		"table", DefaultConfig.Table,
+		"sharding", DefaultConfig.Sharding.Type,
+		"transaction_locking", DefaultConfig.Transaction.Locking,
+		"http_port", DefaultConfig.HttpPort,
+		"http_listen", DefaultConfig.HttpListen,
+		"http_read_timeout_ms", DefaultConfig.HttpReadTimeout,
+		"http_write_timeout_ms", DefaultConfig.HttpWriteTimeout,
+		"log_level", logLevel.Level().String(),
+		"log_file", DefaultConfig.Log.File.Path,
+		"tls_enable", DefaultConfig.TLS.Enable,
+		"trace_enable", DefaultConfig.Trace.Enable,
+		"access_log_enable", DefaultConfig.AccessLog.Enable,
+		"admin_port", DefaultConfig.Admin.Port,
+		"admin_listen", DefaultConfig.Admin.Listen,
+		"statsd_enable", DefaultConfig.Statsd.Enable,
+		"election_enable", DefaultConfig.Election.Enable,
+		"partition_enable", DefaultConfig.Partition.Enable,
+		"layout_enable", DefaultConfig.Layout.Enable,
+		"datacenter", DefaultConfig.Layout.Datacenter,
+		"kubernetes_enable", DefaultConfig.Kubernetes.Enable,
+	)
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateTimeFormat 轮转文件名中的时间格式, 按文件名排序即按轮转时间排序
+const rotateTimeFormat = "20060102T150405.000"
+
+// LogFileConfig 定义日志文件输出的配置, 用于不采集标准错误的虚拟机部署
+// 当前文件写到 path, 轮转后重命名为 path.{轮转时间}, 按个数和天数清理旧文件
+type LogFileConfig struct {
+	Path           string `json:"path"`            // 日志文件路径, 为空表示只输出到标准错误
+	MaxSize        int    `json:"max_size"`        // 单个文件的最大大小（MB）, 超过后轮转, 0 表示不按大小轮转
+	RotateInterval int    `json:"rotate_interval"` // 文件打开多久后轮转（小时）, 如 24 表示按天轮转, 0 表示不按时间轮转
+	MaxBackups     int    `json:"max_backups"`     // 保留的轮转文件个数, 0 表示不限
+	MaxAge         int    `json:"max_age"`         // 轮转文件的保留天数, 0 表示不限
+	Stderr         bool   `json:"stderr"`          // 写文件时是否同时输出到标准错误
+}
+
+// checkLogFile 检查日志文件配置
+func checkLogFile(conf LogFileConfig) error {
+	if conf.MaxSize < 0 || conf.RotateInterval < 0 || conf.MaxBackups < 0 || conf.MaxAge < 0 {
+		return errors.New("log.file max_size, rotate_interval, max_backups and max_age must not be negative")
+	}
+	return nil
+}
+
+// rotatingFile 按大小和打开时长轮转的日志文件, 并发写入安全
+type rotatingFile struct {
+	mutex    sync.Mutex
+	conf     LogFileConfig
+	file     *os.File  // 当前写入的文件
+	size     int64     // 当前文件的大小
+	openedAt time.Time // 当前文件的打开时间, 沿用已有文件时为其修改时间
+	closed   bool      // 是否已关闭, 关闭后不再写入
+}
+
+// openRotatingFile 打开日志文件, 已存在时追加写入
+func openRotatingFile(conf LogFileConfig) (f *rotatingFile, err error) {
+	f = &rotatingFile{conf: conf}
+	if err = os.MkdirAll(filepath.Dir(conf.Path), 0o755); err != nil {
+		return nil, err
+	}
+	if err = f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open 以追加方式打开当前文件, 调用方需持有锁
+func (f *rotatingFile) open() (err error) {
+	var (
+		info os.FileInfo
+	)
+
+	if f.file, err = os.OpenFile(f.conf.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640); err != nil {
+		return
+	}
+	if info, err = f.file.Stat(); err != nil {
+		_ = f.file.Close()
+		f.file = nil
+		return
+	}
+	f.size, f.openedAt = info.Size(), info.ModTime()
+	if f.size == 0 {
+		f.openedAt = time.Now()
+	}
+	return
+}
+
+// Write 写入一条日志, 写入前文件达到轮转条件时先轮转; 轮转失败时继续写入当前文件, 不丢弃日志
+func (f *rotatingFile) Write(p []byte) (n int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(len(p)) {
+		if err = f.rotate(); err != nil {
+			_, _ = os.Stderr.WriteString("rotate log file failed: " + err.Error() + "\n")
+		}
+	}
+	if f.file == nil { // 轮转后重新打开失败
+		if err = f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return
+}
+
+// shouldRotate 判断写入 n 字节前是否需要轮转, 空文件不轮转
+func (f *rotatingFile) shouldRotate(n int) bool {
+	if f.file == nil || f.size == 0 {
+		return false
+	}
+	if f.conf.MaxSize > 0 && f.size+int64(n) > int64(f.conf.MaxSize)<<20 {
+		return true
+	}
+	return f.conf.RotateInterval > 0 && time.Since(f.openedAt) >= time.Duration(f.conf.RotateInterval)*time.Hour
+}
+
+// rotate 将当前文件重命名为带轮转时间的文件并打开新文件, 随后在后台清理过期的轮转文件, 调用方需持有锁
+func (f *rotatingFile) rotate() (err error) {
+	if err = f.file.Close(); err != nil {
+		return
+	}
+	f.file = nil
+	if err = os.Rename(f.conf.Path, f.conf.Path+"."+time.Now().Format(rotateTimeFormat)); err != nil {
+		return
+	}
+	if err = f.open(); err != nil {
+		return
+	}
+	go f.cleanup()
+	return
+}
+
+// backups 返回已有的轮转文件, 按轮转时间从新到旧排序
+func (f *rotatingFile) backups() (paths []string, err error) {
+	var (
+		matches []string
+		prefix  = filepath.Base(f.conf.Path) + "."
+	)
+
+	if matches, err = filepath.Glob(f.conf.Path + ".*"); err != nil {
+		return
+	}
+	for _, path := range matches {
+		if _, err := time.Parse(rotateTimeFormat, strings.TrimPrefix(filepath.Base(path), prefix)); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return
+}
+
+// cleanup 删除超过保留个数或保留天数的轮转文件
+func (f *rotatingFile) cleanup() {
+	if f.conf.MaxBackups == 0 && f.conf.MaxAge == 0 {
+		return
+	}
+
+	paths, err := f.backups()
+	if err != nil {
+		return
+	}
+	for i, path := range paths {
+		expired := f.conf.MaxBackups > 0 && i >= f.conf.MaxBackups
+		if info, err := os.Stat(path); err == nil && f.conf.MaxAge > 0 &&
+			time.Since(info.ModTime()) > time.Duration(f.conf.MaxAge)*24*time.Hour {
+			expired = true
+		}
+		if expired {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+// Close 关闭当前文件
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
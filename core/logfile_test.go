@@ -0,0 +1,97 @@
+package core
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "leaf.log")
+	f, err := openRotatingFile(LogFileConfig{Path: path, MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+
+	// 每次写入半MB, 第三次写入前超过1MB触发轮转
+	line := []byte(strings.Repeat("x", 512<<10-1) + "\n")
+	for i := 0; i < 8; i++ {
+		if _, err = f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // 轮转文件名精确到毫秒
+	}
+
+	// 清理在后台进行, 只保留最新的2个轮转文件
+	waitFor(t, "backup cleanup", func() bool {
+		paths, _ := f.backups()
+		return len(paths) == 2
+	})
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(2*len(line)) {
+		t.Fatalf("current file = %v, %v, want %d bytes", info, err, 2*len(line))
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(line); err == nil {
+		t.Fatal("Write succeeded after Close")
+	}
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leaf.log")
+	f, err := openRotatingFile(LogFileConfig{Path: path, RotateInterval: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+
+	if _, err = f.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	f.openedAt = f.openedAt.Add(-25 * time.Hour) // 文件已打开超过一天
+	if _, err = f.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := f.backups()
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("backups = %v, %v, want 1 file", paths, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Fatalf("current file = %q, want the line written after rotation", data)
+	}
+}
+
+func TestInitLogFile(t *testing.T) {
+	setupTestConfig(t)
+	saved := logger
+	t.Cleanup(func() {
+		logger = saved
+		slog.SetDefault(saved)
+		if logFile != nil {
+			_ = logFile.Close()
+			logFile = nil
+		}
+	})
+
+	path := filepath.Join(t.TempDir(), "leaf.log")
+	DefaultConfig.Log.File.Path = path
+	if err := InitLog(); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("written to file")
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "written to file") {
+		t.Fatalf("log file = %q, want the logged message", data)
+	}
+
+	DefaultConfig.Log.File.MaxSize = -1
+	if err := InitLog(); err == nil {
+		t.Fatal("InitLog accepted a negative max_size")
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrTooManyWaiters 业务等待补偿线程的请求数已达到上限
+var ErrTooManyWaiters = errors.New("too many requests waiting for a refill")
+
+// MemoryConfig 定义运行时内存和 GC 的配置, 用于在内存受限的容器中保持可预期的行为
+// limit 或 limit_ratio 设置软内存上限(相当于 GOMEMLIMIT), 接近上限时 GC 更积极地回收, 避免超过容器限制被杀;
+// gc_percent 相当于 GOGC, 配置后覆盖环境变量; 未配置时保留环境变量或运行时的默认值
+// 号段池只为号段存储中存在的业务创建, 负缓存和幂等键已有各自的上限, 等待者在数据库故障时可能无限堆积, 由 max_waiting 限制
+type MemoryConfig struct {
+	Limit      int     `json:"limit"`       // 软内存上限（MB）, 0 表示不设置, 优先于 limit_ratio
+	LimitRatio float64 `json:"limit_ratio"` // 按容器(cgroup)内存限制的比例设置软内存上限, 如 0.8, 0 表示不设置, 未运行在有内存限制的容器中时不生效
+	GCPercent  int     `json:"gc_percent"`  // GC 触发比例, 0 表示不修改, 负数表示只在接近软内存上限时 GC, 此时必须设置上限
+	MaxWaiting int     `json:"max_waiting"` // 每个业务等待补偿线程的最大请求数, 超过时立即拒绝, 0 表示不限制
+}
+
+// 估算分配器内存占用时每个对象的大小（字节）, 包含对象本身和引用它的指针或队列槽位
+const (
+	poolBytes    = 1024 // 号段池: 锁、快照、计数器和耗时直方图
+	segmentBytes = 40   // 号段: 偏移量和左右边界
+	waiterBytes  = 112  // 等待者: 带一个缓冲的通道
+)
+
+// cgroupMemoryFiles cgroup v2 和 v1 的内存限制文件
+var cgroupMemoryFiles = []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"}
+
+// checkMemory 检查内存配置
+func checkMemory(conf MemoryConfig) error {
+	if conf.Limit < 0 || conf.MaxWaiting < 0 {
+		return errors.New("memory.limit and memory.max_waiting must not be negative")
+	}
+	if conf.LimitRatio < 0 || conf.LimitRatio > 1 {
+		return errors.New("memory.limit_ratio must be between 0 and 1")
+	}
+	if conf.GCPercent < 0 && conf.Limit == 0 && conf.LimitRatio == 0 && os.Getenv("GOMEMLIMIT") == "" {
+		return errors.New("memory.gc_percent below 0 needs memory.limit, memory.limit_ratio or GOMEMLIMIT")
+	}
+	return nil
+}
+
+// InitMemory 按配置设置软内存上限和 GC 触发比例
+func InitMemory() (err error) {
+	var (
+		conf  = DefaultConfig.Memory
+		limit int64 // 软内存上限（字节）, 0 表示不修改
+	)
+
+	if err = checkMemory(conf); err != nil {
+		return
+	}
+	if conf.Limit > 0 {
+		limit = int64(conf.Limit) << 20
+	} else if conf.LimitRatio > 0 {
+		if cgroup, ok := cgroupMemoryLimit(); ok {
+			limit = int64(float64(cgroup) * conf.LimitRatio)
+		} else {
+			logger.Warn("memory.limit_ratio ignored, no cgroup memory limit found")
+		}
+	}
+	if limit > 0 {
+		debug.SetMemoryLimit(limit)
+	}
+	if conf.GCPercent != 0 {
+		debug.SetGCPercent(conf.GCPercent)
+	}
+	logger.Info("memory settings", "limit_bytes", debug.SetMemoryLimit(-1), "gc_percent", conf.GCPercent,
+		"max_waiting", conf.MaxWaiting)
+	return
+}
+
+// cgroupMemoryLimit 读取容器的内存限制, 没有限制或无法读取时 ok 为 false
+func cgroupMemoryLimit() (limit int64, ok bool) {
+	for _, path := range cgroupMemoryFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(content))
+		if value == "max" { // cgroup v2 未限制
+			return 0, false
+		}
+		// cgroup v1 未限制时为接近 int64 最大值的页对齐数值
+		if limit, err = strconv.ParseInt(value, 10, 64); err == nil && limit > 0 && limit < math.MaxInt64/2 {
+			return limit, true
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// runtimeSamples 从运行时读取的内存和 GC 指标
+var runtimeSamples = []string{
+	"/gc/gomemlimit:bytes",
+	"/gc/gogc:percent",
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/total:bytes",
+	"/gc/cycles/total:gc-cycles",
+}
+
+// writeMemoryMetrics 输出运行时内存、GC 设置和分配器持有的内存估算
+func writeMemoryMetrics(b *strings.Builder, gauges []bizGauge) {
+	var (
+		samples  = make([]metrics.Sample, len(runtimeSamples))
+		segments int64
+		waiters  int64
+	)
+
+	for i, name := range runtimeSamples {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	value := func(i int) uint64 {
+		if samples[i].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return samples[i].Value.Uint64()
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_memory_limit_bytes Soft memory limit of the Go runtime (GOMEMLIMIT).")
+	fmt.Fprintln(b, "# TYPE leaf_memory_limit_bytes gauge")
+	fmt.Fprintf(b, "leaf_memory_limit_bytes %d\n", value(0))
+	fmt.Fprintln(b, "# HELP leaf_memory_gc_percent GC target percentage of the Go runtime (GOGC), 0 when GC only runs near the memory limit.")
+	fmt.Fprintln(b, "# TYPE leaf_memory_gc_percent gauge")
+	fmt.Fprintf(b, "leaf_memory_gc_percent %d\n", value(1))
+	fmt.Fprintln(b, "# HELP leaf_memory_heap_bytes Bytes occupied by live and unswept heap objects.")
+	fmt.Fprintln(b, "# TYPE leaf_memory_heap_bytes gauge")
+	fmt.Fprintf(b, "leaf_memory_heap_bytes %d\n", value(2))
+	fmt.Fprintln(b, "# HELP leaf_memory_runtime_bytes Bytes of memory mapped by the Go runtime.")
+	fmt.Fprintln(b, "# TYPE leaf_memory_runtime_bytes gauge")
+	fmt.Fprintf(b, "leaf_memory_runtime_bytes %d\n", value(3))
+	fmt.Fprintln(b, "# HELP leaf_memory_gc_cycles_total Number of completed GC cycles.")
+	fmt.Fprintln(b, "# TYPE leaf_memory_gc_cycles_total counter")
+	fmt.Fprintf(b, "leaf_memory_gc_cycles_total %d\n", value(4))
+
+	for _, g := range gauges {
+		segments += int64(g.buffers)
+		waiters += int64(g.waiting)
+	}
+	fmt.Fprintln(b, "# HELP leaf_memory_alloc_bytes Estimated bytes held by the allocator by component.")
+	fmt.Fprintln(b, "# TYPE leaf_memory_alloc_bytes gauge")
+	fmt.Fprintf(b, "leaf_memory_alloc_bytes{component=\"pools\"} %d\n", int64(len(gauges))*poolBytes)
+	fmt.Fprintf(b, "leaf_memory_alloc_bytes{component=\"segments\"} %d\n", segments*segmentBytes)
+	fmt.Fprintf(b, "leaf_memory_alloc_bytes{component=\"waiters\"} %d\n", waiters*waiterBytes)
+
+	fmt.Fprintln(b, "# HELP leaf_waiting_rejected_total Number of requests rejected because too many requests were waiting for a refill.")
+	fmt.Fprintln(b, "# TYPE leaf_waiting_rejected_total counter")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_waiting_rejected_total{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), atomic.LoadInt64(&g.metrics.waitRejected))
+	}
+}
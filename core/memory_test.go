@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMaxWaiting(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Memory.MaxWaiting = 1
+	storage := newFakeStorage(10)
+	storage.gate = make(chan struct{})
+	bizAlloc := newTestAlloc(t, storage).loadOrCreate("test")
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := bizAlloc.nextId(context.Background())
+		errChan <- err
+	}()
+	waitFor(t, "waiter", func() bool { return bizAlloc.stats().waiting == 1 })
+
+	// 等待者已达到上限, 新请求立即被拒绝
+	if _, err := bizAlloc.nextId(context.Background()); !errors.Is(err, ErrTooManyWaiters) {
+		t.Fatalf("nextId err = %v, want ErrTooManyWaiters", err)
+	}
+	if rejected := atomic.LoadInt64(&bizAlloc.metrics.waitRejected); rejected != 1 {
+		t.Fatalf("waitRejected = %d, want 1", rejected)
+	}
+
+	// 已在等待的请求不受影响
+	storage.gate <- struct{}{}
+	if err := <-errChan; err != nil {
+		t.Fatalf("waiter err = %v", err)
+	}
+}
+
+func TestCheckMemory(t *testing.T) {
+	t.Setenv("GOMEMLIMIT", "")
+	cases := []struct {
+		conf MemoryConfig
+		ok   bool
+	}{
+		{MemoryConfig{}, true},
+		{MemoryConfig{Limit: 256, GCPercent: -1}, true},
+		{MemoryConfig{LimitRatio: 0.8, GCPercent: 50, MaxWaiting: 100}, true},
+		{MemoryConfig{GCPercent: -1}, false}, // 关闭 GC 且没有内存上限
+		{MemoryConfig{LimitRatio: 1.5}, false},
+		{MemoryConfig{Limit: -1}, false},
+		{MemoryConfig{MaxWaiting: -1}, false},
+	}
+	for _, c := range cases {
+		if err := checkMemory(c.conf); (err == nil) != c.ok {
+			t.Errorf("checkMemory(%+v) = %v, want ok %v", c.conf, err, c.ok)
+		}
+	}
+}
+
+func TestMemoryMetrics(t *testing.T) {
+	setupHandlerTest(t)
+	if _, err := DefaultAlloc.NextId(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{"leaf_memory_limit_bytes ", "leaf_memory_heap_bytes ", `leaf_memory_alloc_bytes{component="pools"} `,
+		`leaf_waiting_rejected_total{biz_tag="test"} 0`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	指标命名规范, 升级时保持不变, 仪表盘和告警规则可以长期依赖:
+
+	- 所有指标以 leaf_ 开头, 其后为子系统(alloc、segment、refill、lease、reservation、quota、db、cluster 等)和含义, 全部小写加下划线
+	- 计数器以 _total 结尾; 耗时以秒为单位, 以 _seconds 结尾; 时间戳以 _timestamp_seconds 结尾; 比例以 _ratio 结尾
+	- 开关状态为取值 0 或 1 的 gauge; 信息类指标以 _info 结尾, 值恒为1, 信息放在标签中
+	- 单个业务的指标使用 biz_tag 标签, 区分结果的指标使用 result 标签, 不把业务或结果拼进指标名
+	- gauge 不以 _count、_sum、_bucket 结尾, 避免与直方图的序列混淆
+
+	需要改名时, 旧名称作为已弃用的别名继续输出至少一个版本, HELP 中注明替代的指标
+*/
+
+// fetchLatencyBuckets 号段获取耗时直方图的桶上界（秒）
+var fetchLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// histogram 简单的累积直方图, 所有字段原子更新
+type histogram struct {
+	buckets []int64 // 每个桶的计数(非累积), 最后一个为+Inf
+	count   int64   // 观测次数
+	sumNano int64   // 观测值总和(纳秒)
+}
+
+// newHistogram 创建与fetchLatencyBuckets对应的直方图
+func newHistogram() *histogram {
+	return &histogram{
+		buckets: make([]int64, len(fetchLatencyBuckets)+1),
+	}
+}
+
+// observe 记录一次耗时
+func (h *histogram) observe(elapsed time.Duration) {
+	var (
+		seconds = elapsed.Seconds()
+		idx     = len(fetchLatencyBuckets) // 默认落入+Inf桶
+	)
+	for i, bound := range fetchLatencyBuckets {
+		if seconds <= bound {
+			idx = i
+			break
+		}
+	}
+	atomic.AddInt64(&h.buckets[idx], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sumNano, int64(elapsed))
+}
+
+// BizMetrics 单个业务的计数类指标, 所有字段原子更新
+type BizMetrics struct {
+	allocSuccess     int64         // 成功分配的ID数
+	allocFail        int64         // 分配失败次数
+	fetchSuccess     int64         // 成功获取号段次数
+	fetchFail        int64         // 获取号段失败次数
+	refillGiveUp     int64         // 补偿线程连续失败后放弃的次数
+	leaseSuccess     int64         // 成功租出的号段数
+	leaseFail        int64         // 租用号段失败次数
+	leaseReclaimed   int64         // 客户端归还后回收的号码数
+	leaseBurned      int64         // 租约过期时烧毁的号码数
+	reserveConfirmed int64         // 已确认的预留数
+	reserveReleased  int64         // 已释放的预留数
+	reserveExpired   int64         // 过期未确认的预留数
+	quotaMinute      int64         // 超过每分钟配额被拒绝的次数
+	quotaDay         int64         // 超过每天配额被拒绝的次数
+	watchdogStuck    int64         // 看门狗重置卡住的补偿线程的次数
+	watchdogWaiting  int64         // 看门狗唤醒的长期等待者个数
+	waitRejected     int64         // 等待者达到上限被拒绝的请求数
+	fetchLatency     *histogram    // 获取号段耗时分布
+	window           *slidingStats // 最近 5 分钟的滑动窗口统计, 启用 /stats 时记录
+}
+
+// newBizMetrics 创建业务指标
+func newBizMetrics() *BizMetrics {
+	return &BizMetrics{
+		fetchLatency: newHistogram(),
+		window:       &slidingStats{},
+	}
+}
+
+// panicKey 标识一类 panic: 发生位置和业务
+type panicKey struct {
+	source string // 发生位置: http 或 refill
+	bizTag string // 业务标识
+}
+
+var (
+	panicMutex sync.Mutex             // 保护 panicTotal
+	panicTotal = map[panicKey]int64{} // 捕获的 panic 次数
+)
+
+// recordPanic 记录一次被捕获的 panic
+func recordPanic(source string, bizTag string) {
+	panicMutex.Lock()
+	panicTotal[panicKey{source: source, bizTag: bizTag}]++
+	panicMutex.Unlock()
+}
+
+// writePanicMetrics 输出 panic 计数
+func writePanicMetrics(b *strings.Builder) {
+	var (
+		keys []panicKey
+	)
+
+	panicMutex.Lock()
+	defer panicMutex.Unlock()
+
+	for key := range panicTotal {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].bizTag != keys[j].bizTag {
+			return keys[i].bizTag < keys[j].bizTag
+		}
+		return keys[i].source < keys[j].source
+	})
+
+	fmt.Fprintln(b, "# HELP leaf_panics_total Number of recovered panics by source.")
+	fmt.Fprintln(b, "# TYPE leaf_panics_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(b, "leaf_panics_total{biz_tag=\"%s\",source=\"%s\"} %d\n", escapeLabel(key.bizTag), key.source, panicTotal[key])
+	}
+}
+
+// writeDegradeMetrics 输出降级预取状态
+func writeDegradeMetrics(b *strings.Builder) {
+	value := 0
+	if DefaultAlloc.degraded() {
+		value = 1
+	}
+	fmt.Fprintln(b, "# HELP leaf_degraded Whether oversize prefetch is active because of recent storage errors.")
+	fmt.Fprintln(b, "# TYPE leaf_degraded gauge")
+	fmt.Fprintf(b, "leaf_degraded %d\n", value)
+}
+
+// writeElectionMetrics 输出本实例是否为主实例, 未启用选主时不输出
+func writeElectionMetrics(b *strings.Builder) {
+	if election == nil {
+		return
+	}
+	value := 0
+	if election.isLeader() {
+		value = 1
+	}
+	fmt.Fprintln(b, "# HELP leaf_leader Whether this instance holds the election lock and serves allocations.")
+	fmt.Fprintln(b, "# TYPE leaf_leader gauge")
+	fmt.Fprintf(b, "leaf_leader %d\n", value)
+}
+
+// writeBreakerMetrics 输出号段存储熔断器状态
+func writeBreakerMetrics(b *strings.Builder) {
+	breaker := DefaultAlloc.breaker()
+	if breaker == nil {
+		return
+	}
+
+	state := breaker.State()
+	fmt.Fprintln(b, "# HELP leaf_breaker_state Circuit breaker state of the segment storage (1 for the current state).")
+	fmt.Fprintln(b, "# TYPE leaf_breaker_state gauge")
+	for _, name := range []string{"closed", "open", "half_open"} {
+		value := 0
+		if name == state {
+			value = 1
+		}
+		fmt.Fprintf(b, "leaf_breaker_state{state=\"%s\"} %d\n", name, value)
+	}
+}
+
+// writeDataMetrics 输出各数据库的可达状态
+func writeDataMetrics(b *strings.Builder) {
+	if DefaultData == nil {
+		return
+	}
+
+	active := int(atomic.LoadInt32(&DefaultData.active))
+	fmt.Fprintln(b, "# HELP leaf_db_up Whether the database responded to the last health probe.")
+	fmt.Fprintln(b, "# TYPE leaf_db_up gauge")
+	for i, up := range DefaultData.Reachable() {
+		value := 0
+		if up {
+			value = 1
+		}
+		fmt.Fprintf(b, "leaf_db_up{index=\"%d\",addr=\"%s\",active=\"%t\"} %d\n", i, escapeLabel(dsnAddr(DefaultData.dsns[i])), i == active, value)
+	}
+
+	if configured, up := DefaultData.ReplicaReachable(); configured {
+		value := 0
+		if up {
+			value = 1
+		}
+		fmt.Fprintln(b, "# HELP leaf_db_replica_up Whether the read-only replica responded to the last health probe.")
+		fmt.Fprintln(b, "# TYPE leaf_db_replica_up gauge")
+		fmt.Fprintf(b, "leaf_db_replica_up{addr=\"%s\"} %d\n", escapeLabel(dsnAddr(DefaultData.conf.ReplicaDSN)), value)
+	}
+}
+
+// writeRateLimitMetrics 输出各业务被限流的请求数
+func writeRateLimitMetrics(b *strings.Builder) {
+	if limiter == nil {
+		return
+	}
+
+	limiter.mutex.RLock()
+	defer limiter.mutex.RUnlock()
+
+	bizTags := make([]string, 0, len(limiter.rejected))
+	for bizTag := range limiter.rejected {
+		bizTags = append(bizTags, bizTag)
+	}
+	sort.Strings(bizTags)
+
+	fmt.Fprintln(b, "# HELP leaf_rate_limited_total Number of /alloc requests rejected by the per-biz rate limit.")
+	fmt.Fprintln(b, "# TYPE leaf_rate_limited_total counter")
+	for _, bizTag := range bizTags {
+		fmt.Fprintf(b, "leaf_rate_limited_total{biz_tag=\"%s\"} %d\n", escapeLabel(bizTag), atomic.LoadInt64(limiter.rejected[bizTag]))
+	}
+}
+
+// writeConcurrencyMetrics 输出全局并发限制的状态
+func writeConcurrencyMetrics(b *strings.Builder) {
+	if inflight == nil {
+		return
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_inflight_requests Number of /alloc requests being processed.")
+	fmt.Fprintln(b, "# TYPE leaf_inflight_requests gauge")
+	fmt.Fprintf(b, "leaf_inflight_requests %d\n", len(inflight.slots))
+	fmt.Fprintln(b, "# HELP leaf_queued_requests Number of /alloc requests waiting for a processing slot.")
+	fmt.Fprintln(b, "# TYPE leaf_queued_requests gauge")
+	fmt.Fprintf(b, "leaf_queued_requests %d\n", atomic.LoadInt64(&inflight.queued))
+	fmt.Fprintln(b, "# HELP leaf_shed_total Number of /alloc requests rejected because the server was overloaded.")
+	fmt.Fprintln(b, "# TYPE leaf_shed_total counter")
+	fmt.Fprintf(b, "leaf_shed_total %d\n", atomic.LoadInt64(&inflight.shed))
+}
+
+// bizGauge 采集时刻单个业务的瞬时状态
+type bizGauge struct {
+	bizTag    string
+	remaining int64     // 内存中剩余可分配的号码数
+	ratio     float64   // 剩余号码占双Buffer满载容量的比例
+	buffers   int       // 内存中的号段个数
+	waiting   int       // 挂起等待的客户端数
+	step      int64     // 当前生效的步长
+	segSize   int64     // 最近一次获取的号段大小, 从未获取过时为0
+	failing   bool      // 补偿线程是否已放弃
+	giveUps   int       // 补偿线程连续放弃的次数
+	lastErrAt time.Time // 最近一次获取号段失败的时间, 从未失败时为零值
+	metrics   *BizMetrics
+}
+
+// gauge 从最近一次发布的快照采集业务的瞬时状态, 不加锁
+func (bizAlloc *BizAlloc) gauge() (g bizGauge) {
+	stats := bizAlloc.stats()
+
+	g.bizTag = bizAlloc.bizTag
+	g.remaining = stats.leftCount()
+	g.buffers = len(stats.segments)
+	g.waiting = stats.waiting
+	g.step = stats.step
+	g.segSize = stats.segSize
+	g.failing = stats.failing
+	g.giveUps = stats.giveUps
+	g.lastErrAt = stats.lastErrTime
+	g.metrics = bizAlloc.metrics
+	if g.segSize > 0 { // 满载时内存中应有2个号段
+		g.ratio = float64(g.remaining) / float64(2*g.segSize)
+	}
+	return
+}
+
+// gauges 采集所有业务的瞬时状态, 按biz_tag排序
+func (alloc *Alloc) gauges() (result []bizGauge) {
+	var (
+		bizAllocs []*BizAlloc
+	)
+
+	alloc.bizMap.Range(func(_, value any) bool {
+		bizAllocs = append(bizAllocs, value.(*BizAlloc))
+		return true
+	})
+
+	for _, bizAlloc := range bizAllocs {
+		result = append(result, bizAlloc.gauge())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].bizTag < result[j].bizTag
+	})
+	return
+}
+
+// escapeLabel 转义Prometheus标签值中的特殊字符
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return value
+}
+
+// boolGauge 将开关状态转换为 0 或 1
+func boolGauge(on bool) int {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// formatFloat 按Prometheus文本格式输出浮点数
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", value)
+}
+
+// writeMetrics 以Prometheus文本格式输出所有指标
+func writeMetrics(b *strings.Builder, gauges []bizGauge) {
+	// 计数类指标
+	fmt.Fprintln(b, "# HELP leaf_alloc_total Number of ID allocation requests by result.")
+	fmt.Fprintln(b, "# TYPE leaf_alloc_total counter")
+	for _, g := range gauges {
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_alloc_total{biz_tag=\"%s\",result=\"success\"} %d\n", tag, atomic.LoadInt64(&g.metrics.allocSuccess))
+		fmt.Fprintf(b, "leaf_alloc_total{biz_tag=\"%s\",result=\"fail\"} %d\n", tag, atomic.LoadInt64(&g.metrics.allocFail))
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_segment_fetch_total Number of segment fetches from the database by result.")
+	fmt.Fprintln(b, "# TYPE leaf_segment_fetch_total counter")
+	for _, g := range gauges {
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_segment_fetch_total{biz_tag=\"%s\",result=\"success\"} %d\n", tag, atomic.LoadInt64(&g.metrics.fetchSuccess))
+		fmt.Fprintf(b, "leaf_segment_fetch_total{biz_tag=\"%s\",result=\"fail\"} %d\n", tag, atomic.LoadInt64(&g.metrics.fetchFail))
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_refill_giveup_total Number of times the refill goroutine gave up after consecutive failures.")
+	fmt.Fprintln(b, "# TYPE leaf_refill_giveup_total counter")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_refill_giveup_total{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), atomic.LoadInt64(&g.metrics.refillGiveUp))
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_lease_total Number of whole segments leased to clients.")
+	fmt.Fprintln(b, "# TYPE leaf_lease_total counter")
+	for _, g := range gauges {
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_lease_total{biz_tag=\"%s\",result=\"success\"} %d\n", tag, atomic.LoadInt64(&g.metrics.leaseSuccess))
+		fmt.Fprintf(b, "leaf_lease_total{biz_tag=\"%s\",result=\"fail\"} %d\n", tag, atomic.LoadInt64(&g.metrics.leaseFail))
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_lease_ids_total Number of leased ids reclaimed after release or burned after lease expiry.")
+	fmt.Fprintln(b, "# TYPE leaf_lease_ids_total counter")
+	for _, g := range gauges {
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_lease_ids_total{biz_tag=\"%s\",result=\"reclaimed\"} %d\n", tag, atomic.LoadInt64(&g.metrics.leaseReclaimed))
+		fmt.Fprintf(b, "leaf_lease_ids_total{biz_tag=\"%s\",result=\"burned\"} %d\n", tag, atomic.LoadInt64(&g.metrics.leaseBurned))
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_reservation_total Number of reserved ids by how the reservation ended.")
+	fmt.Fprintln(b, "# TYPE leaf_reservation_total counter")
+	for _, g := range gauges {
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_reservation_total{biz_tag=\"%s\",result=\"confirmed\"} %d\n", tag, atomic.LoadInt64(&g.metrics.reserveConfirmed))
+		fmt.Fprintf(b, "leaf_reservation_total{biz_tag=\"%s\",result=\"released\"} %d\n", tag, atomic.LoadInt64(&g.metrics.reserveReleased))
+		fmt.Fprintf(b, "leaf_reservation_total{biz_tag=\"%s\",result=\"expired\"} %d\n", tag, atomic.LoadInt64(&g.metrics.reserveExpired))
+	}
+
+	// 直方图
+	fmt.Fprintln(b, "# HELP leaf_segment_fetch_duration_seconds Latency of segment fetches from the database.")
+	fmt.Fprintln(b, "# TYPE leaf_segment_fetch_duration_seconds histogram")
+	for _, g := range gauges {
+		var (
+			tag        = escapeLabel(g.bizTag)
+			h          = g.metrics.fetchLatency
+			cumulative int64
+		)
+		for i, bound := range append(fetchLatencyBuckets, math.Inf(1)) {
+			cumulative += atomic.LoadInt64(&h.buckets[i])
+			fmt.Fprintf(b, "leaf_segment_fetch_duration_seconds_bucket{biz_tag=\"%s\",le=\"%s\"} %d\n", tag, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(b, "leaf_segment_fetch_duration_seconds_sum{biz_tag=\"%s\"} %g\n", tag, time.Duration(atomic.LoadInt64(&h.sumNano)).Seconds())
+		fmt.Fprintf(b, "leaf_segment_fetch_duration_seconds_count{biz_tag=\"%s\"} %d\n", tag, atomic.LoadInt64(&h.count))
+	}
+
+	// 瞬时状态
+	fmt.Fprintln(b, "# HELP leaf_segment_remaining Number of IDs left in memory.")
+	fmt.Fprintln(b, "# TYPE leaf_segment_remaining gauge")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_segment_remaining{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), g.remaining)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_segment_remaining_ratio Remaining IDs in memory relative to a full double buffer (2 x step).")
+	fmt.Fprintln(b, "# TYPE leaf_segment_remaining_ratio gauge")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_segment_remaining_ratio{biz_tag=\"%s\"} %g\n", escapeLabel(g.bizTag), g.ratio)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_refill_failing Whether the refill goroutine gave up and is waiting for the next request to retry.")
+	fmt.Fprintln(b, "# TYPE leaf_refill_failing gauge")
+	for _, g := range gauges {
+		value := 0
+		if g.failing {
+			value = 1
+		}
+		fmt.Fprintf(b, "leaf_refill_failing{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), value)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_refill_last_error_timestamp_seconds Unix time of the most recent failed segment fetch.")
+	fmt.Fprintln(b, "# TYPE leaf_refill_last_error_timestamp_seconds gauge")
+	for _, g := range gauges {
+		if !g.lastErrAt.IsZero() {
+			fmt.Fprintf(b, "leaf_refill_last_error_timestamp_seconds{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), g.lastErrAt.Unix())
+		}
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_segment_step Step the next segment fetch will use.")
+	fmt.Fprintln(b, "# TYPE leaf_segment_step gauge")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_segment_step{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), g.step)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_segment_buffers Number of segments held in memory (0-2, up to buffer_depth while degraded).")
+	fmt.Fprintln(b, "# TYPE leaf_segment_buffers gauge")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_segment_buffers{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), g.buffers)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_buffer_count Deprecated: use leaf_segment_buffers.")
+	fmt.Fprintln(b, "# TYPE leaf_buffer_count gauge")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_buffer_count{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), g.buffers)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_waiting_clients Number of clients blocked waiting for a segment refill.")
+	fmt.Fprintln(b, "# TYPE leaf_waiting_clients gauge")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_waiting_clients{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), g.waiting)
+	}
+	writeQuotaMetrics(b, gauges)
+	writeWatchdogMetrics(b, gauges)
+	writeMemoryMetrics(b, gauges)
+	writeAlertMetrics(b, gauges)
+	writeEventMetrics(b)
+}
+
+// handleMetrics 处理Prometheus指标抓取请求
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var (
+		b strings.Builder
+	)
+
+	writeBuildInfoMetrics(&b)
+	writeMetrics(&b, DefaultAlloc.gauges())
+	writePanicMetrics(&b)
+	writeBreakerMetrics(&b)
+	writeDegradeMetrics(&b)
+	writeElectionMetrics(&b)
+	writeDataMetrics(&b)
+	writeRateLimitMetrics(&b)
+	writeConcurrencyMetrics(&b)
+	writeUnknownTagMetrics(&b)
+	writePeerMetrics(&b)
+	writeClusterMetrics(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// writePeerMetrics 输出转发给对等实例的次数, 未配置对等实例时不输出
+func writePeerMetrics(b *strings.Builder) {
+	if peers == nil {
+		return
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_peer_forward_total Number of /alloc requests forwarded to peers while the segment storage circuit was open.")
+	fmt.Fprintln(b, "# TYPE leaf_peer_forward_total counter")
+	fmt.Fprintf(b, "leaf_peer_forward_total{result=\"success\"} %d\n", peers.success.Load())
+	fmt.Fprintf(b, "leaf_peer_forward_total{result=\"fail\"} %d\n", peers.fail.Load())
+	fmt.Fprintf(b, "leaf_peer_forward_total{result=\"loop\"} %d\n", peers.loop.Load())
+}
+
+// writeClusterMetrics 输出集群中各实例的状态和接近耗尽的业务, 未启用时不输出
+func writeClusterMetrics(b *strings.Builder) {
+	if cluster == nil {
+		return
+	}
+
+	members, exhausted := cluster.snapshot()
+	fmt.Fprintln(b, "# HELP leaf_cluster_member_up Whether the last pull from a cluster member succeeded.")
+	fmt.Fprintln(b, "# TYPE leaf_cluster_member_up gauge")
+	for _, member := range members[1:] {
+		value := 0
+		if member.Up {
+			value = 1
+		}
+		fmt.Fprintf(b, "leaf_cluster_member_up{addr=\"%s\"} %d\n", escapeLabel(member.Addr), value)
+	}
+	fmt.Fprintln(b, "# HELP leaf_cluster_exhausted Biz tags whose max_id is near its capacity on any cluster member.")
+	fmt.Fprintln(b, "# TYPE leaf_cluster_exhausted gauge")
+	for _, bizTag := range exhausted {
+		fmt.Fprintf(b, "leaf_cluster_exhausted{biz_tag=\"%s\"} 1\n", escapeLabel(bizTag))
+	}
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricNaming(t *testing.T) {
+	setupHandlerTest(t)
+	if _, err := DefaultAlloc.NextId(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `leaf_build_info{version="`) || !strings.Contains(body, `go_version="go`) {
+		t.Fatalf("metrics missing leaf_build_info:\n%s", body)
+	}
+
+	// 按命名规范检查每个指标的名称和类型
+	deprecated := map[string]bool{}
+	for _, line := range strings.Split(body, "\n") {
+		if name, help, ok := strings.Cut(strings.TrimPrefix(line, "# HELP "), " "); ok && strings.HasPrefix(line, "# HELP ") {
+			deprecated[name] = strings.HasPrefix(help, "Deprecated:")
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "# TYPE "))
+		if !strings.HasPrefix(line, "# TYPE ") || len(fields) != 2 || deprecated[fields[0]] {
+			continue
+		}
+		name, kind := fields[0], fields[1]
+		switch {
+		case !strings.HasPrefix(name, "leaf_"):
+			t.Errorf("%s: missing leaf_ prefix", name)
+		case kind == "counter" && !strings.HasSuffix(name, "_total"):
+			t.Errorf("%s: counter without _total suffix", name)
+		case kind == "gauge" && (strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_count") ||
+			strings.HasSuffix(name, "_sum") || strings.HasSuffix(name, "_bucket")):
+			t.Errorf("%s: gauge with a counter or histogram suffix", name)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CORSConfig 定义浏览器跨域访问的配置
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`   // 允许的来源, * 表示全部, 为空表示不启用 CORS
+	AllowedMethods   []string `json:"allowed_methods"`   // 允许的方法
+	AllowedHeaders   []string `json:"allowed_headers"`   // 允许的请求头
+	AllowCredentials bool     `json:"allow_credentials"` // 是否允许携带凭证, 不能与来源 * 同时使用
+	MaxAge           int      `json:"max_age"`           // 预检结果的缓存时间（秒）
+}
+
+// AccessLogConfig 定义 HTTP 访问日志的配置
+// 高 QPS 下可以只记录部分成功请求: sample_every 按固定间隔记录, 结果可预期; sample_rate 随机记录;
+// 失败的请求(状态码 >= 400)在 keep_errors 时总是记录; tags 为单个业务覆盖采样规则, 如热点业务记录得更少, 排查中的业务全部记录
+type AccessLogConfig struct {
+	Enable      bool                       `json:"enable"`       // 是否记录访问日志
+	SampleRate  float64                    `json:"sample_rate"`  // 成功请求的采样比例, 0~1, 1 表示全部记录
+	SampleEvery int                        `json:"sample_every"` // 每 N 个成功请求记录一个, 优先于 sample_rate, 0 表示按 sample_rate 采样
+	KeepErrors  bool                       `json:"keep_errors"`  // 失败的请求是否不受采样影响, 总是记录
+	Tags        map[string]AccessLogSample `json:"tags"`         // 按业务覆盖的采样规则, 未配置的业务使用上面的规则
+}
+
+// AccessLogSample 定义单个业务的访问日志采样规则
+type AccessLogSample struct {
+	SampleRate  float64 `json:"sample_rate"`  // 成功请求的采样比例, 0~1
+	SampleEvery int     `json:"sample_every"` // 每 N 个成功请求记录一个, 优先于 sample_rate
+}
+
+// accessSampler 按一条采样规则决定是否记录成功请求, 并发安全
+type accessSampler struct {
+	rate  float64
+	every int64
+	seen  atomic.Int64 // 经过该规则的成功请求数
+}
+
+// sample 是否记录本次成功请求, 按间隔采样时记录每个间隔的第一个请求
+func (sampler *accessSampler) sample() bool {
+	if sampler.every > 0 {
+		return (sampler.seen.Add(1)-1)%sampler.every == 0
+	}
+	return sampler.rate >= 1 || rand.Float64() < sampler.rate
+}
+
+// checkAccessLog 检查访问日志的采样规则
+func checkAccessLog(conf AccessLogConfig) error {
+	rules := map[string]AccessLogSample{"": {SampleRate: conf.SampleRate, SampleEvery: conf.SampleEvery}}
+	for bizTag, rule := range conf.Tags {
+		rules[bizTag] = rule
+	}
+	for bizTag, rule := range rules {
+		if rule.SampleRate < 0 || rule.SampleRate > 1 || rule.SampleEvery < 0 {
+			return fmt.Errorf("access_log %q: sample_rate must be between 0 and 1 and sample_every must not be negative", bizTag)
+		}
+	}
+	return nil
+}
+
+// statusRecorder 记录处理函数写出的 HTTP 状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int // 响应状态码
+}
+
+// WriteHeader 记录状态码后转发给底层 ResponseWriter
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write 未显式设置状态码时按 200 记录
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap 返回底层 ResponseWriter, 供 http.ResponseController 刷出和设置超时
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// withAccessLog 为 HTTP 处理器增加访问日志, 成功的请求按业务的采样规则记录
+func withAccessLog(handler http.Handler) http.Handler {
+	var (
+		conf     = DefaultConfig.AccessLog
+		fallback = &accessSampler{rate: conf.SampleRate, every: int64(conf.SampleEvery)} // 未单独配置的业务共用
+		samplers = make(map[string]*accessSampler, len(conf.Tags))                       // 启动后只读, 无需加锁
+	)
+
+	if !conf.Enable {
+		return handler
+	}
+	for bizTag, rule := range conf.Tags {
+		samplers[bizTag] = &accessSampler{rate: rule.SampleRate, every: int64(rule.SampleEvery)}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			rec       = &statusRecorder{ResponseWriter: w}
+			startTime = time.Now()
+		)
+
+		handler.ServeHTTP(rec, r)
+
+		if rec.status == 0 { // 处理函数没有写出任何内容
+			rec.status = http.StatusOK
+		}
+		bizTag := r.URL.Query().Get("biz_tag")
+
+		// 按采样规则丢弃部分成功请求的日志
+		if rec.status < http.StatusBadRequest || !conf.KeepErrors {
+			sampler := samplers[bizTag]
+			if sampler == nil {
+				sampler = fallback
+			}
+			if !sampler.sample() {
+				return
+			}
+		}
+		logger.Info("access",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"biz_tag", bizTag,
+			"status", rec.status,
+			"latency_ms", float64(time.Since(startTime).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+			"request_id", RequestID(r.Context()),
+		)
+	})
+}
+
+// withTimeout 为每个请求设置处理时限, 超时后处理函数通过 context 感知并返回 HTTP 504
+// 与 http.Server 的读写超时不同, 该时限约束的是处理函数本身的执行时间
+func withTimeout(handler http.Handler) http.Handler {
+	timeout := time.Duration(DefaultConfig.RequestTimeout) * time.Millisecond
+	if timeout <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" { // 事件流是长连接, 不受请求时限限制; 按路由判断, 其他接口不能通过请求头绕过时限
+			handler.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancelFunc := context.WithTimeout(r.Context(), timeout)
+		defer cancelFunc()
+
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withRecover 捕获处理函数中的 panic, 记录堆栈和指标后返回 HTTP 500, 避免整个进程退出
+func withRecover(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler { // 主动中断连接, 交由 net/http 处理
+				panic(p)
+			}
+
+			bizTag := r.URL.Query().Get("biz_tag")
+			recordPanic("http", bizTag)
+			logger.Error("panic recovered", "code", CodePanic, "method", r.Method, "path", r.URL.Path,
+				"biz_tag", bizTag, "request_id", RequestID(r.Context()), "panic", p, "stack", string(debug.Stack()))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"err_no":-1,"msg":"internal error"}`))
+		}()
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// checkCORS 检查跨域配置: 允许全部来源时不能同时允许携带凭证, 否则任意网站都能以用户的身份调用分配和管理接口
+func checkCORS(conf CORSConfig) error {
+	if conf.AllowCredentials && slices.Contains(conf.AllowedOrigins, "*") {
+		return errors.New("cors allow_credentials cannot be used with allowed_origins *")
+	}
+	return nil
+}
+
+// withCORS 为允许的来源增加跨域响应头, 并直接响应预检请求
+func withCORS(handler http.Handler) http.Handler {
+	var (
+		conf     = DefaultConfig.CORS
+		allowAll = false
+		origins  = map[string]bool{}
+	)
+
+	if len(conf.AllowedOrigins) == 0 {
+		return handler
+	}
+	for _, origin := range conf.AllowedOrigins {
+		allowAll = allowAll || origin == "*"
+		origins[origin] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(allowAll || origins[origin]) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// 携带凭证时不能使用通配符, 总是回显具体来源
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+		if conf.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// 预检请求
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", strings.Join(conf.AllowedMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(conf.AllowedHeaders, ", "))
+			if conf.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
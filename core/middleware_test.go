@@ -0,0 +1,164 @@
+package core
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogSampling(t *testing.T) {
+	setupTestConfig(t)
+	var buf bytes.Buffer
+	saved := logger
+	logger = slog.New(slog.NewTextHandler(&buf, nil))
+	t.Cleanup(func() { logger = saved })
+
+	DefaultConfig.AccessLog = AccessLogConfig{
+		Enable:      true,
+		SampleEvery: 10,
+		KeepErrors:  true,
+		Tags: map[string]AccessLogSample{
+			"debug": {SampleRate: 1},
+			"quiet": {SampleRate: 0},
+		},
+	}
+	handler := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	count := func(target string, n int) int {
+		buf.Reset()
+		for i := 0; i < n; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}
+		return strings.Count(buf.String(), "msg=access")
+	}
+
+	// 每10个成功请求记录一个, 失败的请求全部记录
+	if got := count("/alloc?biz_tag=test", 25); got != 3 {
+		t.Errorf("logged %d of 25 successful requests, want 3", got)
+	}
+	if got := count("/alloc?biz_tag=test&fail=1", 5); got != 5 {
+		t.Errorf("logged %d of 5 failed requests, want 5", got)
+	}
+
+	// 按业务覆盖采样规则
+	if got := count("/alloc?biz_tag=debug", 5); got != 5 {
+		t.Errorf("logged %d of 5 requests for a fully sampled biz_tag, want 5", got)
+	}
+	if got := count("/alloc?biz_tag=quiet", 5); got != 0 {
+		t.Errorf("logged %d of 5 requests for an unsampled biz_tag, want 0", got)
+	}
+	if got := count("/alloc?biz_tag=quiet&fail=1", 2); got != 2 {
+		t.Errorf("logged %d of 2 failed requests for an unsampled biz_tag, want 2", got)
+	}
+
+	// 不保留失败请求时失败的请求也参与采样
+	DefaultConfig.AccessLog.KeepErrors = false
+	handler = withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	if got := count("/alloc?biz_tag=quiet", 3); got != 0 {
+		t.Errorf("logged %d failed requests without keep_errors, want 0", got)
+	}
+}
+
+func TestCheckAccessLog(t *testing.T) {
+	if err := checkAccessLog(AccessLogConfig{SampleRate: 1, Tags: map[string]AccessLogSample{"a": {SampleEvery: 100}}}); err != nil {
+		t.Fatalf("checkAccessLog = %v, want nil", err)
+	}
+	if err := checkAccessLog(AccessLogConfig{SampleRate: 2}); err == nil {
+		t.Fatal("checkAccessLog accepted sample_rate 2")
+	}
+	if err := checkAccessLog(AccessLogConfig{Tags: map[string]AccessLogSample{"a": {SampleEvery: -1}}}); err == nil {
+		t.Fatal("checkAccessLog accepted a negative sample_every")
+	}
+}
+
+func TestTimeoutExemptsOnlyEvents(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.RequestTimeout = 50
+	handler := withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	serve := func(target string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept", "text/event-stream")
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// 事件流按路由豁免, 其他接口带上事件流的请求头也有处理时限
+	if code := serve("/events"); code != http.StatusNoContent {
+		t.Fatalf("/events has a deadline, status %d", code)
+	}
+	if code := serve("/alloc?biz_tag=test"); code != http.StatusOK {
+		t.Fatalf("/alloc has no deadline, status %d", code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.CORS = CORSConfig{
+		AllowedOrigins:   []string{"https://tools.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+	handler := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method string, origin string, preflight bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/health", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// 预检请求直接响应, 回显具体来源并允许携带凭证
+	w := serve(http.MethodOptions, "https://tools.example.com", true)
+	header := w.Header()
+	if w.Code != http.StatusNoContent || header.Get("Access-Control-Allow-Origin") != "https://tools.example.com" ||
+		header.Get("Access-Control-Allow-Credentials") != "true" || header.Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+		header.Get("Access-Control-Allow-Headers") != "Authorization" || header.Get("Access-Control-Max-Age") != "600" ||
+		header.Get("Vary") != "Origin" {
+		t.Fatalf("preflight status %d, headers %v", w.Code, header)
+	}
+
+	// 普通跨域请求交给处理函数, 带跨域响应头
+	if w = serve(http.MethodGet, "https://tools.example.com", false); w.Code != http.StatusOK ||
+		w.Header().Get("Access-Control-Allow-Origin") != "https://tools.example.com" {
+		t.Fatalf("cors request status %d, headers %v", w.Code, w.Header())
+	}
+
+	// 不允许的来源和同源请求不带跨域响应头, 预检请求不被直接响应
+	for _, origin := range []string{"https://evil.example.com", ""} {
+		w = serve(http.MethodOptions, origin, true)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" ||
+			w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Fatalf("origin %q: status %d, headers %v", origin, w.Code, w.Header())
+		}
+	}
+
+	// 允许全部来源时不能允许携带凭证
+	if err := checkCORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Fatal("checkCORS accepted allowed_origins * with allow_credentials")
+	}
+	if err := checkCORS(CORSConfig{AllowedOrigins: []string{"*"}}); err != nil {
+		t.Fatalf("checkCORS = %v, want nil", err)
+	}
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLConfig 定义 MySQL 连接的选项, 应用到 dsn 和 dsns 中的每一个连接, 非零值覆盖 DSN 中的同名参数
+// 密码和地址仍写在 DSN 中, TLS 证书、字符集和超时不必再拼接进 DSN 字符串
+type MySQLConfig struct {
+	TLS             MySQLTLSConfig `json:"tls"`               // 连接 MySQL 的 TLS 配置
+	Charset         string         `json:"charset"`           // 连接字符集, 如 utf8mb4, 为空时使用 DSN 或驱动的默认值
+	Collation       string         `json:"collation"`         // 连接排序规则, 如 utf8mb4_general_ci, 为空时使用 DSN 或驱动的默认值
+	Timeout         int            `json:"timeout"`           // 建立连接的超时时间（毫秒）, 0 表示使用 DSN 中的设置
+	ReadTimeout     int            `json:"read_timeout"`      // 读取的超时时间（毫秒）, 0 表示使用 DSN 中的设置
+	WriteTimeout    int            `json:"write_timeout"`     // 写入的超时时间（毫秒）, 0 表示使用 DSN 中的设置
+	MaxOpenConns    int            `json:"max_open_conns"`    // 每个连接池的最大连接数, 0 表示不限制; 启用选主时选主锁长期占用一个连接
+	MaxIdleConns    int            `json:"max_idle_conns"`    // 每个连接池的最大空闲连接数, 0 表示默认 10
+	ConnMaxLifetime int            `json:"conn_max_lifetime"` // 连接的最大存活时间（毫秒）, 0 表示不限制
+}
+
+// MySQLTLSConfig 定义连接 MySQL 的 TLS 配置
+type MySQLTLSConfig struct {
+	Enable             bool   `json:"enable"`               // 是否使用 TLS 连接, 启用后忽略 DSN 中的 tls 参数
+	CAFile             string `json:"ca_file"`              // 校验服务端证书的 CA 证书包(PEM), 为空时使用系统根证书
+	CertFile           string `json:"cert_file"`            // 客户端证书(PEM), 服务端要求客户端证书时配置
+	KeyFile            string `json:"key_file"`             // 客户端私钥(PEM)
+	ServerName         string `json:"server_name"`          // 校验证书时使用的服务端名称, 为空时使用 DSN 中的主机名
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // 是否跳过服务端证书校验, 仅用于测试环境
+	MinVersion         string `json:"min_version"`          // 最低 TLS 版本: 1.2 或 1.3
+}
+
+// charsetPattern 字符集和排序规则名称
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// checkMySQL 检查 MySQL 连接选项并构造 TLS 配置, 未启用 TLS 时返回 nil
+func checkMySQL(conf MySQLConfig) (tlsConfig *tls.Config, err error) {
+	for name, value := range map[string]string{"charset": conf.Charset, "collation": conf.Collation} {
+		if value != "" && !charsetPattern.MatchString(value) {
+			return nil, fmt.Errorf("mysql.%s %q is invalid", name, value)
+		}
+	}
+	for name, value := range map[string]int{"timeout": conf.Timeout, "read_timeout": conf.ReadTimeout, "write_timeout": conf.WriteTimeout,
+		"max_open_conns": conf.MaxOpenConns, "max_idle_conns": conf.MaxIdleConns, "conn_max_lifetime": conf.ConnMaxLifetime} {
+		if value < 0 {
+			return nil, fmt.Errorf("mysql.%s %d must not be negative", name, value)
+		}
+	}
+	if !conf.TLS.Enable {
+		return nil, nil
+	}
+
+	version, ok := tlsVersions[conf.TLS.MinVersion]
+	if !ok {
+		return nil, errors.New("unsupported mysql.tls.min_version: " + conf.TLS.MinVersion)
+	}
+	tlsConfig = &tls.Config{
+		ServerName:         conf.TLS.ServerName,
+		InsecureSkipVerify: conf.TLS.InsecureSkipVerify,
+		MinVersion:         version,
+	}
+	if conf.TLS.CAFile != "" {
+		pem, err := os.ReadFile(conf.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read mysql.tls.ca_file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("mysql.tls.ca_file contains no certificate")
+		}
+	}
+	if conf.TLS.CertFile != "" || conf.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.TLS.CertFile, conf.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load mysql.tls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// openMySQL 解析 DSN, 应用连接选项后创建连接池
+func openMySQL(dsn string, conf MySQLConfig, tlsConfig *tls.Config) (db *sql.DB, err error) {
+	var (
+		cfg       *mysql.Config
+		connector driver.Connector
+	)
+
+	if cfg, err = mysqlConfig(dsn, conf, tlsConfig); err != nil {
+		return nil, err
+	}
+	if connector, err = mysql.NewConnector(cfg); err != nil {
+		return nil, fmt.Errorf("invalid dsn %s: %w", redactDSN(dsn), err)
+	}
+	db = sql.OpenDB(connector)
+
+	// 连接池大小和连接存活时间
+	if conf.MaxIdleConns == 0 {
+		conf.MaxIdleConns = 10
+	}
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(conf.ConnMaxLifetime) * time.Millisecond)
+	return db, nil
+}
+
+// mysqlConfig 解析 DSN 并应用连接选项
+func mysqlConfig(dsn string, conf MySQLConfig, tlsConfig *tls.Config) (cfg *mysql.Config, err error) {
+	if cfg, err = mysql.ParseDSN(dsn); err != nil {
+		return nil, fmt.Errorf("invalid dsn %s: %w", redactDSN(dsn), err)
+	}
+	if tlsConfig != nil {
+		cfg.TLS, cfg.TLSConfig = tlsConfig.Clone(), ""
+	}
+	if conf.Charset != "" {
+		if cfg.Params == nil {
+			cfg.Params = map[string]string{}
+		}
+		cfg.Params["charset"] = conf.Charset
+	}
+	if conf.Collation != "" {
+		cfg.Collation = conf.Collation
+	}
+	if conf.Timeout > 0 {
+		cfg.Timeout = time.Duration(conf.Timeout) * time.Millisecond
+	}
+	if conf.ReadTimeout > 0 {
+		cfg.ReadTimeout = time.Duration(conf.ReadTimeout) * time.Millisecond
+	}
+	if conf.WriteTimeout > 0 {
+		cfg.WriteTimeout = time.Duration(conf.WriteTimeout) * time.Millisecond
+	}
+	return cfg, nil
+}
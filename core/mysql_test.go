@@ -0,0 +1,148 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert 生成自签名证书和私钥, 返回文件路径
+func writeTestCert(t *testing.T) (certFile string, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "leaf-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCheckMySQL(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	for name, conf := range map[string]MySQLConfig{
+		"bad charset":     {Charset: "utf8mb4;drop"},
+		"negative":        {ReadTimeout: -1},
+		"min version":     {TLS: MySQLTLSConfig{Enable: true, MinVersion: "1.0"}},
+		"missing ca":      {TLS: MySQLTLSConfig{Enable: true, CAFile: filepath.Join(t.TempDir(), "none.pem")}},
+		"ca without cert": {TLS: MySQLTLSConfig{Enable: true, CAFile: keyFile}},
+		"key only":        {TLS: MySQLTLSConfig{Enable: true, KeyFile: keyFile}},
+	} {
+		if _, err := checkMySQL(conf); err == nil {
+			t.Errorf("%s: checkMySQL accepted %+v", name, conf)
+		}
+	}
+
+	tlsConfig, err := checkMySQL(MySQLConfig{TLS: MySQLTLSConfig{Enable: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}})
+	if err != nil || tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 {
+		t.Fatalf("checkMySQL = (%+v, %v), want CA and client certificate", tlsConfig, err)
+	}
+	if tlsConfig, err = checkMySQL(MySQLConfig{Charset: "utf8mb4"}); err != nil || tlsConfig != nil {
+		t.Fatalf("checkMySQL without tls = (%v, %v), want (nil, nil)", tlsConfig, err)
+	}
+}
+
+func TestMySQLConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	conf := MySQLConfig{
+		TLS:          MySQLTLSConfig{Enable: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile},
+		Charset:      "utf8mb4",
+		Timeout:      1500,
+		ReadTimeout:  2000,
+		WriteTimeout: 0, // 使用 DSN 中的设置
+	}
+	tlsConfig, err := checkMySQL(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 结构化选项覆盖 DSN 中的同名参数, DSN 中的 tls 参数被忽略
+	cfg, err := mysqlConfig("root:pw@tcp(db.internal:3306)/leaf?charset=latin1&timeout=5s&writeTimeout=3s&tls=skip-verify", conf, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Params["charset"] != "utf8mb4" || cfg.Timeout != 1500*time.Millisecond || cfg.ReadTimeout != 2*time.Second || cfg.WriteTimeout != 3*time.Second {
+		t.Fatalf("charset = %q, timeouts = %v/%v/%v", cfg.Params["charset"], cfg.Timeout, cfg.ReadTimeout, cfg.WriteTimeout)
+	}
+	if cfg.TLS == nil || cfg.TLS.InsecureSkipVerify || cfg.TLSConfig != "" || cfg.TLS == tlsConfig {
+		t.Fatalf("tls = %+v (%q), want a copy of the configured tls", cfg.TLS, cfg.TLSConfig)
+	}
+
+	if _, err = mysqlConfig("not a dsn", conf, nil); err == nil {
+		t.Fatal("mysqlConfig accepted an invalid dsn")
+	}
+
+	// 连接池选项
+	db, err := openMySQL("root:pw@tcp(127.0.0.1:1)/leaf", MySQLConfig{MaxOpenConns: 7}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := db.Stats().MaxOpenConnections; n != 7 {
+		t.Fatalf("MaxOpenConnections = %d, want 7", n)
+	}
+}
+
+func TestReplicaReader(t *testing.T) {
+	conf := &Config{ReplicaDSN: "root:pw@tcp(127.0.0.1:1)/leaf", Failover: FailoverConfig{ProbeTimeout: 200}}
+	primary, err := openMySQL("root:pw@tcp(127.0.0.1:1)/leaf", conf.MySQL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := openMySQL(conf.ReplicaDSN, conf.MySQL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &Data{conf: conf, dbs: []*sql.DB{primary}, up: []int32{1}, replica: replica, replicaUp: -1}
+	defer data.closeDBs()
+
+	// 副本尚未探测或不可达时使用主库
+	if data.reader() != primary {
+		t.Fatal("reader used the replica before it was probed")
+	}
+	if data.pingReplica() || data.reader() != primary {
+		t.Fatal("reader used an unreachable replica")
+	}
+	if configured, up := data.ReplicaReachable(); !configured || up {
+		t.Fatalf("ReplicaReachable = (%t, %t), want (true, false)", configured, up)
+	}
+
+	// 副本可达时只读查询使用副本, 号段仍使用当前库
+	data.replicaUp = 1
+	if data.reader() != replica || data.current() != primary {
+		t.Fatal("reader did not use the reachable replica")
+	}
+}
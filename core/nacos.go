@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NacosRegistryConfig 定义注册到 Nacos 的配置
+// 使用 Nacos v1 Open API 注册临时实例并定期发送心跳, 实例不可分配(备用实例或号段存储熔断)时暂停心跳,
+// Nacos 在心跳超时后将实例标记为不健康, 恢复后继续心跳; 实例异常退出时由 Nacos 按心跳超时删除
+type NacosRegistryConfig struct {
+	Addrs     []string          `json:"addrs"`     // Nacos 的地址, 如 http://10.0.0.1:8848, 为空表示不注册
+	Namespace string            `json:"namespace"` // 命名空间 ID, 为空时使用 public
+	Service   string            `json:"service"`   // 服务名
+	Group     string            `json:"group"`     // 分组名
+	Cluster   string            `json:"cluster"`   // 集群名
+	Weight    float64           `json:"weight"`    // 权重
+	Metadata  map[string]string `json:"metadata"`  // 实例元数据
+	Username  string            `json:"username"`  // 启用鉴权时的用户名, 为空表示不登录
+	Password  string            `json:"password"`  // 启用鉴权时的密码
+	Interval  int               `json:"interval"`  // 心跳间隔（毫秒）
+	Timeout   int               `json:"timeout"`   // 单次请求 Nacos 的超时时间（毫秒）
+}
+
+// nacosResourceNotFound 心跳时实例不存在的返回码, 需要重新注册
+const nacosResourceNotFound = 20404
+
+// nacosRegistrar 通过 Nacos Open API 注册
+type nacosRegistrar struct {
+	conf       NacosRegistryConfig
+	ip         string        // 注册的实例 IP
+	port       int           // 注册的实例端口
+	metadata   string        // JSON 编码的实例元数据
+	client     *http.Client  // 请求 Nacos 的 HTTP 客户端
+	healthy    func() bool   // 本实例是否可以分配, 不可分配时暂停心跳
+	registered bool          // 是否已注册, 只在保持线程中读写
+	paused     bool          // 是否已暂停心跳, 只在保持线程中读写
+	token      string        // 鉴权令牌
+	expireAt   time.Time     // 鉴权令牌的过期时间
+	stopChan   chan struct{} // 停止心跳
+	wait       sync.WaitGroup
+}
+
+// newNacosRegistrar 创建 Nacos 注册, ip 和 port 为注册的服务地址
+func newNacosRegistrar(conf NacosRegistryConfig, ip string, port int) *nacosRegistrar {
+	metadata, _ := json.Marshal(conf.Metadata)
+	if conf.Interval <= 0 {
+		conf.Interval = 5000
+	}
+	return &nacosRegistrar{
+		conf:     conf,
+		ip:       ip,
+		port:     port,
+		metadata: string(metadata),
+		client:   &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+		healthy:  serving,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// serving 本实例是否可以分配: 不是备用实例, 号段存储的熔断器也没有打开
+func serving() bool {
+	if standby() || DefaultAlloc == nil {
+		return false
+	}
+	if breaker := DefaultAlloc.breaker(); breaker != nil && breaker.State() == breakerStateNames[breakerOpen] {
+		return false
+	}
+	return true
+}
+
+// register 实现 registrar: 在后台注册并发送心跳
+func (nacos *nacosRegistrar) register() {
+	nacos.wait.Add(1)
+	go nacos.keepAlive()
+}
+
+// keepAlive 立即注册, 之后按间隔发送心跳; 尚未注册成功或 Nacos 中实例已不存在时重新注册
+func (nacos *nacosRegistrar) keepAlive() {
+	defer nacos.wait.Done()
+
+	ticker := time.NewTicker(time.Duration(nacos.conf.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		var err error
+		if healthy := nacos.healthy(); !healthy && !nacos.paused {
+			logger.Warn("instance not serving, pausing nacos heartbeat", "service", nacos.conf.Service, "ip", nacos.ip, "port", nacos.port)
+			nacos.paused = true
+		} else if healthy && nacos.paused {
+			logger.Info("instance serving again, resuming nacos heartbeat", "service", nacos.conf.Service, "ip", nacos.ip, "port", nacos.port)
+			nacos.paused = false
+		}
+		if !nacos.paused {
+			if !nacos.registered {
+				err = nacos.put(context.Background())
+			} else {
+				err = nacos.beat(context.Background())
+			}
+		}
+		if err != nil {
+			logger.Warn("nacos register failed", "service", nacos.conf.Service, "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-nacos.stopChan:
+			return
+		}
+	}
+}
+
+// params 返回标识本实例的请求参数
+func (nacos *nacosRegistrar) params() url.Values {
+	params := url.Values{
+		"serviceName": {nacos.conf.Service},
+		"groupName":   {nacos.conf.Group},
+		"clusterName": {nacos.conf.Cluster},
+		"ip":          {nacos.ip},
+		"port":        {strconv.Itoa(nacos.port)},
+		"ephemeral":   {"true"},
+	}
+	if nacos.conf.Namespace != "" {
+		params.Set("namespaceId", nacos.conf.Namespace)
+	}
+	return params
+}
+
+// put 注册实例
+func (nacos *nacosRegistrar) put(ctx context.Context) error {
+	params := nacos.params()
+	params.Set("weight", strconv.FormatFloat(nacos.conf.Weight, 'f', -1, 64))
+	params.Set("metadata", nacos.metadata)
+	params.Set("healthy", "true")
+	params.Set("enabled", "true")
+	if _, err := nacos.call(ctx, http.MethodPost, "/nacos/v1/ns/instance", params); err != nil {
+		return err
+	}
+	nacos.registered = true
+	logger.Info("registered to nacos", "service", nacos.conf.Service, "group", nacos.conf.Group, "ip", nacos.ip, "port", nacos.port)
+	return nil
+}
+
+// beat 发送一次心跳, Nacos 中实例已不存在时重新注册
+func (nacos *nacosRegistrar) beat(ctx context.Context) (err error) {
+	var (
+		body []byte
+		rsp  struct {
+			Code int `json:"code"`
+		}
+	)
+
+	beat, _ := json.Marshal(map[string]any{
+		"serviceName": nacos.conf.Group + "@@" + nacos.conf.Service,
+		"cluster":     nacos.conf.Cluster,
+		"ip":          nacos.ip,
+		"port":        nacos.port,
+		"weight":      nacos.conf.Weight,
+		"metadata":    nacos.conf.Metadata,
+		"scheduled":   true,
+	})
+	params := nacos.params()
+	params.Set("beat", string(beat))
+	if body, err = nacos.call(ctx, http.MethodPut, "/nacos/v1/ns/instance/beat", params); err != nil {
+		return
+	}
+	if json.Unmarshal(body, &rsp) == nil && rsp.Code == nacosResourceNotFound { // 实例已被 Nacos 删除
+		logger.Warn("nacos instance not found, registering again", "service", nacos.conf.Service, "ip", nacos.ip, "port", nacos.port)
+		nacos.registered = false
+		return nacos.put(ctx)
+	}
+	return nil
+}
+
+// deregister 实现 registrar: 停止心跳并注销实例
+func (nacos *nacosRegistrar) deregister(ctx context.Context) error {
+	close(nacos.stopChan)
+	nacos.wait.Wait()
+
+	if !nacos.registered {
+		return nil
+	}
+	if _, err := nacos.call(ctx, http.MethodDelete, "/nacos/v1/ns/instance", nacos.params()); err != nil {
+		return fmt.Errorf("nacos: deregister: %w", err)
+	}
+	logger.Info("deregistered from nacos", "service", nacos.conf.Service, "ip", nacos.ip, "port", nacos.port)
+	return nil
+}
+
+// call 依次向各个 Nacos 地址发送请求, 直到有一个成功; 配置了用户名时携带鉴权令牌
+func (nacos *nacosRegistrar) call(ctx context.Context, method string, path string, params url.Values) (body []byte, err error) {
+	for _, addr := range nacos.conf.Addrs {
+		addr = strings.TrimRight(addr, "/")
+		if nacos.conf.Username != "" {
+			if err = nacos.login(ctx, addr); err != nil {
+				continue
+			}
+			params.Set("accessToken", nacos.token)
+		}
+		if body, err = nacos.once(ctx, method, addr+path+"?"+params.Encode(), nil); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// login 令牌不存在或即将过期时登录
+func (nacos *nacosRegistrar) login(ctx context.Context, addr string) (err error) {
+	var (
+		body []byte
+		rsp  struct {
+			AccessToken string `json:"accessToken"`
+			TokenTTL    int64  `json:"tokenTtl"` // 有效期（秒）
+		}
+	)
+
+	if nacos.token != "" && time.Now().Before(nacos.expireAt) {
+		return nil
+	}
+	form := url.Values{"username": {nacos.conf.Username}, "password": {nacos.conf.Password}}
+	if body, err = nacos.once(ctx, http.MethodPost, addr+"/nacos/v1/auth/login", form); err != nil {
+		return fmt.Errorf("nacos: login: %w", err)
+	}
+	if err = json.Unmarshal(body, &rsp); err != nil || rsp.AccessToken == "" {
+		return errors.Join(errors.New("nacos: login returned no access token"), err)
+	}
+	// 提前十分之一有效期重新登录
+	nacos.token, nacos.expireAt = rsp.AccessToken, time.Now().Add(time.Duration(rsp.TokenTTL)*time.Second*9/10)
+	return nil
+}
+
+// once 向一个 Nacos 地址发送请求, form 不为空时作为表单提交
+func (nacos *nacosRegistrar) once(ctx context.Context, method string, rawURL string, form url.Values) (body []byte, err error) {
+	var (
+		req    *http.Request
+		rsp    *http.Response
+		reader io.Reader
+	)
+
+	if form != nil {
+		reader = strings.NewReader(form.Encode())
+	}
+	if req, err = http.NewRequestWithContext(ctx, method, rawURL, reader); err != nil {
+		return
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if rsp, err = nacos.client.Do(req); err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if body, err = io.ReadAll(io.LimitReader(rsp.Body, 1<<20)); err != nil {
+		return
+	}
+	if rsp.StatusCode != http.StatusOK {
+		if rsp.StatusCode == http.StatusForbidden { // 令牌可能已失效, 下次重新登录
+			nacos.token = ""
+		}
+		// 不输出查询参数, 其中有鉴权令牌
+		return nil, fmt.Errorf("nacos: %s%s: status %d: %s", req.URL.Host, req.URL.Path, rsp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNacosRegistrar(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		calls    []string // 按顺序记录的请求
+		logins   int
+		notFound = true // 第一次心跳时报告实例不存在
+		healthy  atomic.Bool
+		requests = make(chan string, 256)
+	)
+
+	nacos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		call := r.Method + " " + r.URL.Path
+		switch {
+		case r.URL.Path == "/nacos/v1/auth/login":
+			if r.PostForm.Get("username") != "nacos" || r.PostForm.Get("password") != "secret" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			logins++
+			fmt.Fprintf(w, `{"accessToken":"token-%d","tokenTtl":18000}`, logins)
+		case r.URL.Query().Get("accessToken") != "token-1":
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case r.URL.Path == "/nacos/v1/ns/instance/beat":
+			if notFound {
+				notFound = false
+				_, _ = w.Write([]byte(`{"code":20404}`))
+			} else {
+				_, _ = w.Write([]byte(`{"code":10200,"clientBeatInterval":5000}`))
+			}
+		default:
+			query := r.URL.Query()
+			call += fmt.Sprintf(" %s@%s/%s %s:%s", query.Get("serviceName"), query.Get("groupName"), query.Get("clusterName"), query.Get("ip"), query.Get("port"))
+			if r.Method == http.MethodPost {
+				call += " " + query.Get("metadata")
+			}
+			_, _ = w.Write([]byte("ok"))
+		}
+		if len(calls) == 0 || calls[len(calls)-1] != call { // 连续的心跳只记录一次
+			calls = append(calls, call)
+		}
+		requests <- call
+	}))
+	defer nacos.Close()
+
+	conf := NewConfig().Registry.Nacos
+	conf.Addrs = []string{nacos.URL}
+	conf.Username, conf.Password = "nacos", "secret"
+	conf.Metadata = map[string]string{"zone": "a"}
+	conf.Interval = 10
+	registrar := newNacosRegistrar(conf, "10.0.0.1", 8880)
+	registrar.healthy = healthy.Load
+	healthy.Store(true)
+	registrar.register()
+
+	// waitBeats 等待 n 次心跳
+	waitBeats := func(n int) {
+		deadline := time.After(5 * time.Second)
+		for n > 0 {
+			select {
+			case call := <-requests:
+				if call == "PUT /nacos/v1/ns/instance/beat" {
+					n--
+				}
+			case <-deadline:
+				t.Fatal("timed out waiting for heartbeats")
+			}
+		}
+	}
+
+	// 心跳时实例不存在则重新注册
+	waitBeats(3)
+
+	// 不可分配时暂停心跳, 恢复后继续
+	healthy.Store(false)
+	time.Sleep(50 * time.Millisecond)
+	for len(requests) > 0 {
+		<-requests
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(requests); n != 0 {
+		t.Fatalf("%d requests while not serving, want 0", n)
+	}
+	healthy.Store(true)
+	waitBeats(1)
+
+	if err := registrar.deregister(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	instance := " leaf-segment@DEFAULT_GROUP/DEFAULT 10.0.0.1:8880"
+	want := []string{
+		"POST /nacos/v1/auth/login",
+		"POST /nacos/v1/ns/instance" + instance + ` {"zone":"a"}`,
+		"PUT /nacos/v1/ns/instance/beat",
+		"POST /nacos/v1/ns/instance" + instance + ` {"zone":"a"}`,
+		"PUT /nacos/v1/ns/instance/beat",
+		"DELETE /nacos/v1/ns/instance" + instance,
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Fatalf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestCheckRegistry(t *testing.T) {
+	conf := NewConfig()
+	conf.Registry.Nacos.Addrs = []string{"http://127.0.0.1:8848"}
+	conf.Registry.Addr = "http://10.0.0.1"
+	if err := checkRegistry(&conf); err == nil {
+		t.Fatal("checkRegistry accepted an addr without port")
+	}
+	conf.Registry.Addr = "http://10.0.0.1:8880"
+	if host, port, err := splitAddr(conf.Registry.Addr); err != nil || host != "10.0.0.1" || port != 8880 {
+		t.Fatalf("splitAddr = (%q, %d, %v)", host, port, err)
+	}
+	if err := checkRegistry(&conf); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NamespaceConfig 定义多租户命名空间的配置
+// 命名空间内的业务以 {ns}{separator}{biz_tag} 为号段行的业务标识, 请求可以用 ns 参数指定命名空间, 也可以直接使用带前缀的 biz_tag
+// 命名空间使用独立的号段表时, 配置 namespace 分表规则, 分隔符需与 sharding.separator 一致
+type NamespaceConfig struct {
+	Enable     bool                      `json:"enable"`     // 是否启用命名空间
+	Separator  string                    `json:"separator"`  // 命名空间与业务名之间的分隔符, 默认 :
+	Strict     bool                      `json:"strict"`     // 是否拒绝不属于任何命名空间的业务, 多个组织共用部署时开启
+	Namespaces map[string]NamespaceQuota `json:"namespaces"` // 允许的命名空间及其配额, 未列出的命名空间被拒绝
+}
+
+// NamespaceQuota 定义一个命名空间的配额
+type NamespaceQuota struct {
+	Quota RateLimit `json:"quota"` // 命名空间内所有业务共享的请求限流, rate 为 0 表示不限制
+}
+
+// namespaceAdminPaths 命名空间管理员可以访问的管理接口, 都按 biz_tag 参数限定操作的业务
+var namespaceAdminPaths = map[string]bool{
+	"/admin/tags":         true,
+	"/admin/advance":      true,
+	"/admin/segments":     true,
+	"/admin/shard":        true,
+	"/admin/leases":       true,
+	"/admin/reservations": true,
+}
+
+// namespaceRegistry 解析请求的命名空间并执行命名空间配额
+type namespaceRegistry struct {
+	conf    NamespaceConfig
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket // 各命名空间的令牌桶
+}
+
+// namespaces 全局命名空间, 未启用时为 nil
+var namespaces *namespaceRegistry
+
+// newNamespaceRegistry 检查命名空间配置, 未启用时返回 nil
+func newNamespaceRegistry(conf *Config) (registry *namespaceRegistry, err error) {
+	ns := conf.Namespace
+	if !ns.Enable {
+		return nil, nil
+	}
+	if ns.Separator == "" {
+		ns.Separator = ":"
+	}
+	if len(ns.Namespaces) == 0 {
+		return nil, errors.New("namespace enabled without namespaces")
+	}
+	for name := range ns.Namespaces {
+		if name == "" || strings.Contains(name, ns.Separator) {
+			return nil, fmt.Errorf("invalid namespace %q", name)
+		}
+	}
+	if conf.Sharding.Type == "namespace" && conf.Sharding.Separator != ns.Separator {
+		return nil, fmt.Errorf("namespace.separator %q differs from sharding.separator %q", ns.Separator, conf.Sharding.Separator)
+	}
+	return &namespaceRegistry{conf: ns, buckets: map[string]*tokenBucket{}}, nil
+}
+
+// namespace 返回业务标识所属的命名空间, 不带命名空间前缀时返回空字符串
+func (registry *namespaceRegistry) namespace(bizTag string) string {
+	if ns, _, found := strings.Cut(bizTag, registry.conf.Separator); found {
+		return ns
+	}
+	return ""
+}
+
+// qualify 将 ns 参数合并到 biz_tag 参数中, 并检查命名空间是否允许
+// 同时修改查询字符串和已解析的表单, 后续按任一方式读取 biz_tag 的处理器都得到带前缀的业务标识
+func (registry *namespaceRegistry) qualify(r *http.Request) (bizTag string, err error) {
+	var (
+		query = r.URL.Query()
+		ns    = query.Get("ns")
+	)
+
+	if err = r.ParseForm(); err != nil {
+		return
+	}
+	if ns == "" {
+		ns = r.Form.Get("ns")
+	}
+	if bizTag = r.Form.Get("biz_tag"); bizTag == "" {
+		if ns != "" {
+			err = errors.New("ns param needs biz_tag param")
+		}
+		return
+	}
+
+	if ns != "" {
+		if strings.HasPrefix(bizTag, ns+registry.conf.Separator) {
+			err = errors.New("biz_tag already has the namespace prefix")
+			return
+		}
+		bizTag = ns + registry.conf.Separator + bizTag
+		query.Set("biz_tag", bizTag)
+		query.Del("ns")
+		r.URL.RawQuery = query.Encode()
+		r.Form.Set("biz_tag", bizTag)
+		r.Form.Del("ns")
+	}
+
+	switch ns = registry.namespace(bizTag); {
+	case ns == "" && registry.conf.Strict:
+		err = errors.New("biz_tag needs a namespace")
+	case ns != "":
+		if _, ok := registry.conf.Namespaces[ns]; !ok {
+			err = errors.New("unknown namespace: " + ns)
+		}
+	}
+	return
+}
+
+// allow 判断命名空间的请求是否在配额内, 超过时返回建议的重试等待时间
+func (registry *namespaceRegistry) allow(ns string) (ok bool, wait time.Duration) {
+	limit := registry.conf.Namespaces[ns].Quota
+	if ns == "" || limit.Rate <= 0 {
+		return true, 0
+	}
+
+	registry.mutex.Lock()
+	bucket := registry.buckets[ns]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: limit.capacity(), last: time.Now()}
+		registry.buckets[ns] = bucket
+	}
+	registry.mutex.Unlock()
+	return bucket.take(limit)
+}
+
+// withNamespace 解析请求的命名空间, 放在认证之前, 认证和限流都使用带前缀的业务标识
+func withNamespace(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bizTag, err := namespaces.qualify(r); err != nil {
+			logger.Warn("namespace rejected", "path", r.URL.Path, "biz_tag", bizTag, "err", err)
+			writeError(w, http.StatusBadRequest, ErrNoInvalidBizTag, err.Error())
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// withNamespaceQuota 按命名空间的配额限流, 超过配额时返回 HTTP 429 和 Retry-After
+func withNamespaceQuota(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := namespaces.namespace(r.FormValue("biz_tag"))
+		if ok, wait := namespaces.allow(ns); !ok {
+			statsd.Incr("namespace_quota_exceeded", "namespace:"+ns)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, ErrNoRateLimited, "namespace quota exceeded")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// withAdminNamespace 解析管理请求的命名空间, 放在管理权限检查之前
+func withAdminNamespace(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := namespaces.qualify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// adminAllowed 判断调用方能否执行该管理请求, 命名空间管理员只能按 biz_tag 操作自己命名空间内的业务
+func (p *principal) adminAllowed(r *http.Request) bool {
+	if p.admin {
+		return true
+	}
+	if namespaces == nil || len(p.adminNamespaces) == 0 || !namespaceAdminPaths[r.URL.Path] {
+		return false
+	}
+	ns := namespaces.namespace(r.FormValue("biz_tag"))
+	return ns != "" && p.adminNamespaces[ns]
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setupNamespaceTest 启用 payments 和 search 两个命名空间, payments 每秒只允许 2 个请求
+func setupNamespaceTest(t *testing.T) {
+	t.Helper()
+
+	setupHandlerTest(t)
+	conf := NewConfig()
+	conf.Namespace = NamespaceConfig{Enable: true, Separator: ":", Strict: true, Namespaces: map[string]NamespaceQuota{
+		"payments": {Quota: RateLimit{Rate: 0.001, Burst: 2}},
+		"search":   {},
+	}}
+	var err error
+	if namespaces, err = newNamespaceRegistry(&conf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { namespaces = nil })
+}
+
+func TestNewNamespaceRegistry(t *testing.T) {
+	for _, conf := range []Config{
+		{Namespace: NamespaceConfig{Enable: true, Separator: ":"}},
+		{Namespace: NamespaceConfig{Enable: true, Separator: ":", Namespaces: map[string]NamespaceQuota{"a:b": {}}}},
+		{Namespace: NamespaceConfig{Enable: true, Separator: ":", Namespaces: map[string]NamespaceQuota{"a": {}}},
+			Sharding: ShardingConfig{Type: "namespace", Separator: "/"}},
+	} {
+		if _, err := newNamespaceRegistry(&conf); err == nil {
+			t.Errorf("newNamespaceRegistry accepted %+v", conf.Namespace)
+		}
+	}
+	if registry, err := newNamespaceRegistry(&Config{}); registry != nil || err != nil {
+		t.Fatalf("disabled namespace = (%v, %v), want (nil, nil)", registry, err)
+	}
+}
+
+func TestNamespaceAlloc(t *testing.T) {
+	setupNamespaceTest(t)
+	auth, err := newAPIKeyAuth(AuthConfig{Header: "X-API-Key", Keys: []APIKey{
+		{Key: "payments-key", Name: "payments", Namespaces: []string{"payments"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := withNamespace(withAuth([]authenticator{auth}, withNamespaceQuota(handleAlloc)))
+
+	get := func(query string) int {
+		r := httptest.NewRequest(http.MethodGet, "/alloc?"+query, nil)
+		r.Header.Set("X-API-Key", "payments-key")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	for _, c := range []struct {
+		query string
+		code  int
+	}{
+		{"ns=payments&biz_tag=order", http.StatusOK},
+		{"biz_tag=payments:order", http.StatusOK},                 // 直接使用带前缀的业务标识
+		{"ns=payments&biz_tag=order", http.StatusTooManyRequests}, // 超过命名空间配额
+		{"ns=search&biz_tag=order", http.StatusForbidden},         // 其他命名空间的业务
+		{"ns=unknown&biz_tag=order", http.StatusBadRequest},
+		{"biz_tag=order", http.StatusBadRequest}, // strict 时业务必须属于命名空间
+		{"ns=payments&biz_tag=payments:order", http.StatusBadRequest},
+	} {
+		if code := get(c.query); code != c.code {
+			t.Errorf("GET /alloc?%s = %d, want %d", c.query, code, c.code)
+		}
+	}
+	if DefaultAlloc.load("payments:order") == nil {
+		t.Fatal("namespaced biz_tag not allocated with the namespace prefix")
+	}
+}
+
+func TestNamespaceAdmin(t *testing.T) {
+	setupNamespaceTest(t)
+	p := &principal{name: "payments-admin", adminNamespaces: map[string]bool{"payments": true}}
+
+	allowed := func(path string, query string) bool {
+		r := httptest.NewRequest(http.MethodGet, path+"?"+query, nil)
+		if _, err := namespaces.qualify(r); err != nil {
+			t.Fatal(err)
+		}
+		return p.adminAllowed(r)
+	}
+
+	if !allowed("/admin/tags", "ns=payments&biz_tag=order") || !allowed("/admin/leases", "biz_tag=payments:order") {
+		t.Fatal("namespace admin denied its own biz_tag")
+	}
+	if allowed("/admin/tags", "ns=search&biz_tag=order") || allowed("/admin/tags", "") {
+		t.Fatal("namespace admin allowed another namespace or all biz_tags")
+	}
+	// 不按业务限定的管理接口只允许全局管理员
+	if allowed("/admin/loglevel", "biz_tag=payments:order") {
+		t.Fatal("namespace admin allowed a global admin endpoint")
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SlackConfig 定义 Slack 通知的配置
+type SlackConfig struct {
+	WebhookURL string `json:"webhook_url"` // Slack Incoming Webhook 地址, 为空表示不启用
+	Channel    string `json:"channel"`     // 覆盖默认频道, 可为空
+	Username   string `json:"username"`    // 消息显示的发送者名称
+}
+
+// PagerDutyConfig 定义 PagerDuty Events API v2 通知的配置
+type PagerDutyConfig struct {
+	RoutingKey string `json:"routing_key"` // 服务集成的 routing key, 为空表示不启用
+	URL        string `json:"url"`         // Events API 地址
+	Severity   string `json:"severity"`    // 事件级别: critical, error, warning, info
+}
+
+// describe 生成告警事件的单行描述
+func describe(event AlertEvent) string {
+	switch event.Type {
+	case AlertLowRemaining:
+		return fmt.Sprintf("biz_tag %s is running out of IDs: %d left (%.1f%% of buffer)", event.BizTag, event.Remaining, event.Ratio*100)
+	case AlertRefillFailed:
+		return fmt.Sprintf("biz_tag %s failed to refill %d times in a row: %s", event.BizTag, event.FailCount, event.Error)
+	case AlertBreakerOpen:
+		return "segment database circuit breaker opened, allocations will fail once buffers drain"
+	case AlertBreakerClosed:
+		return "segment database circuit breaker closed, refills recovered"
+	case AlertErrorRate:
+		return fmt.Sprintf("biz_tag %s allocation error rate %.1f%% sustained", event.BizTag, event.ErrorRate*100)
+	}
+	return fmt.Sprintf("%s biz_tag=%s", event.Type, event.BizTag)
+}
+
+// notifyStatusError 通知渠道返回了非 2xx 状态码
+type notifyStatusError struct {
+	target string // 通知地址或渠道名称
+	status int    // HTTP 状态码
+}
+
+func (err *notifyStatusError) Error() string {
+	return fmt.Sprintf("%s responded with status %d", err.target, err.status)
+}
+
+// retryableNotify 网络错误、HTTP 429 和 5xx 可能是暂时的, 值得重试; 其他状态码说明配置有误, 重试不会成功
+func retryableNotify(err error) bool {
+	var statusErr *notifyStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status == http.StatusTooManyRequests || statusErr.status >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// postJSON 发送 JSON 请求, 非 2xx 状态码视为失败
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) (err error) {
+	var (
+		body []byte
+		req  *http.Request
+		resp *http.Response
+	)
+
+	if body, err = json.Marshal(payload); err != nil {
+		return
+	}
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if resp, err = client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err = &notifyStatusError{target: url, status: resp.StatusCode}
+	}
+	return
+}
+
+// slackNotifier 通过 Incoming Webhook 发送 Slack 消息
+type slackNotifier struct {
+	conf   SlackConfig
+	client *http.Client
+}
+
+// Notify 发送 Slack 消息
+func (notifier *slackNotifier) Notify(ctx context.Context, event AlertEvent) error {
+	icon := ":rotating_light:"
+	if event.Type == AlertBreakerClosed {
+		icon = ":white_check_mark:"
+	}
+
+	payload := map[string]string{
+		"text": fmt.Sprintf("%s *[leaf-segment]* %s", icon, describe(event)),
+	}
+	if notifier.conf.Channel != "" {
+		payload["channel"] = notifier.conf.Channel
+	}
+	if notifier.conf.Username != "" {
+		payload["username"] = notifier.conf.Username
+	}
+	return postJSON(ctx, notifier.client, notifier.conf.WebhookURL, payload)
+}
+
+// pagerDutyNotifier 通过 Events API v2 触发和恢复 PagerDuty 事件
+type pagerDutyNotifier struct {
+	conf   PagerDutyConfig
+	client *http.Client
+	source string // 事件来源, 使用主机名
+}
+
+// dedupKey 同一问题使用相同的 dedup_key, PagerDuty 侧会合并为一个事件
+func (notifier *pagerDutyNotifier) dedupKey(event AlertEvent) string {
+	switch event.Type {
+	case AlertBreakerOpen, AlertBreakerClosed:
+		return "leaf-segment/" + notifier.source + "/breaker"
+	}
+	return "leaf-segment/" + notifier.source + "/" + event.Type + "/" + event.BizTag
+}
+
+// Notify 触发或恢复 PagerDuty 事件, 熔断器关闭时恢复对应的打开事件
+func (notifier *pagerDutyNotifier) Notify(ctx context.Context, event AlertEvent) error {
+	payload := map[string]any{
+		"routing_key":  notifier.conf.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    notifier.dedupKey(event),
+	}
+
+	if event.Type == AlertBreakerClosed {
+		payload["event_action"] = "resolve"
+	} else {
+		payload["payload"] = map[string]any{
+			"summary":        "[leaf-segment] " + describe(event),
+			"source":         notifier.source,
+			"severity":       notifier.conf.Severity,
+			"timestamp":      event.Time.Format(time.RFC3339),
+			"component":      "leaf-segment",
+			"custom_details": event,
+		}
+	}
+	return postJSON(ctx, notifier.client, notifier.conf.URL, payload)
+}
+
+// newNotifiers 按配置创建所有告警通知渠道
+func newNotifiers(conf AlertConfig) (notifiers []Notifier, err error) {
+	var (
+		client   = &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond}
+		webhook  *webhookNotifier
+		hostname string
+	)
+
+	if conf.WebhookURL != "" {
+		if webhook, err = newWebhookNotifier(conf); err != nil {
+			return
+		}
+		notifiers = append(notifiers, webhook)
+	}
+
+	if conf.Slack.WebhookURL != "" {
+		notifiers = append(notifiers, &slackNotifier{conf: conf.Slack, client: client})
+	}
+
+	if conf.PagerDuty.RoutingKey != "" {
+		if hostname, err = os.Hostname(); err != nil {
+			return
+		}
+		notifiers = append(notifiers, &pagerDutyNotifier{
+			conf:   conf.PagerDuty,
+			client: client,
+			source: strings.ToLower(hostname),
+		})
+	}
+	return
+}
@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifyRequest 通知服务端收到的一次请求
+type notifyRequest struct {
+	contentType string
+	body        string
+}
+
+// notifyServer 记录收到的通知请求, 并按顺序返回预设的状态码, 用完后返回 HTTP 200
+type notifyServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []notifyRequest
+}
+
+// newNotifyServer 启动记录通知请求的 HTTP 服务, 测试结束时关闭
+func newNotifyServer(tb testing.TB, statuses ...int) *notifyServer {
+	tb.Helper()
+
+	server := &notifyServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		server.mutex.Lock()
+		server.requests = append(server.requests, notifyRequest{contentType: r.Header.Get("Content-Type"), body: string(body)})
+		status := http.StatusOK
+		if len(server.statuses) != 0 {
+			status, server.statuses = server.statuses[0], server.statuses[1:]
+		}
+		server.mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	tb.Cleanup(server.Close)
+	return server
+}
+
+// received 返回目前收到的所有请求
+func (server *notifyServer) received() []notifyRequest {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]notifyRequest(nil), server.requests...)
+}
+
+// decodeNotify 解析请求体中的 JSON
+func decodeNotify(tb testing.TB, request notifyRequest) (payload map[string]any) {
+	tb.Helper()
+
+	if err := json.Unmarshal([]byte(request.body), &payload); err != nil {
+		tb.Fatalf("decode %q: %v", request.body, err)
+	}
+	return
+}
+
+func TestSlackNotifier(t *testing.T) {
+	server := newNotifyServer(t)
+	notifier := &slackNotifier{
+		conf:   SlackConfig{WebhookURL: server.URL, Channel: "#oncall", Username: "leaf"},
+		client: server.Client(),
+	}
+
+	events := []AlertEvent{
+		{Type: AlertLowRemaining, BizTag: "order", Remaining: 12, Ratio: 0.05},
+		{Type: AlertBreakerClosed},
+	}
+	for _, event := range events {
+		if err := notifier.Notify(context.Background(), event); err != nil {
+			t.Fatalf("notify %s: %v", event.Type, err)
+		}
+	}
+
+	requests := server.received()
+	if len(requests) != 2 {
+		t.Fatalf("received %d requests, want 2", len(requests))
+	}
+	want := []string{
+		":rotating_light: *[leaf-segment]* biz_tag order is running out of IDs: 12 left (5.0% of buffer)",
+		":white_check_mark: *[leaf-segment]* segment database circuit breaker closed, refills recovered",
+	}
+	for i, request := range requests {
+		if request.contentType != "application/json" {
+			t.Fatalf("Content-Type = %q, want application/json", request.contentType)
+		}
+		payload := decodeNotify(t, request)
+		if payload["text"] != want[i] || payload["channel"] != "#oncall" || payload["username"] != "leaf" {
+			t.Fatalf("payload = %v, want text %q in #oncall as leaf", payload, want[i])
+		}
+	}
+
+	// 未配置频道和发送者时不带这两个字段
+	notifier.conf = SlackConfig{WebhookURL: server.URL}
+	if err := notifier.Notify(context.Background(), events[0]); err != nil {
+		t.Fatal(err)
+	}
+	payload := decodeNotify(t, server.received()[2])
+	if _, ok := payload["channel"]; ok || len(payload) != 1 {
+		t.Fatalf("payload = %v, want only text", payload)
+	}
+}
+
+func TestPagerDutyNotifier(t *testing.T) {
+	server := newNotifyServer(t)
+	notifier := &pagerDutyNotifier{
+		conf:   PagerDutyConfig{RoutingKey: "rk", URL: server.URL, Severity: "critical"},
+		client: server.Client(),
+		source: "leaf-1",
+	}
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	events := []AlertEvent{
+		{Type: AlertBreakerOpen, Time: now},
+		{Type: AlertBreakerClosed, Time: now},
+		{Type: AlertRefillFailed, BizTag: "order", FailCount: 3, Error: "timeout", Time: now},
+	}
+	for _, event := range events {
+		if err := notifier.Notify(context.Background(), event); err != nil {
+			t.Fatalf("notify %s: %v", event.Type, err)
+		}
+	}
+	requests := server.received()
+	if len(requests) != 3 {
+		t.Fatalf("received %d requests, want 3", len(requests))
+	}
+
+	// 熔断器打开时触发事件
+	open := decodeNotify(t, requests[0])
+	if open["routing_key"] != "rk" || open["event_action"] != "trigger" || open["dedup_key"] != "leaf-segment/leaf-1/breaker" {
+		t.Fatalf("breaker open = %v, want trigger of leaf-segment/leaf-1/breaker", open)
+	}
+	detail, _ := open["payload"].(map[string]any)
+	if detail["summary"] != "[leaf-segment] segment database circuit breaker opened, allocations will fail once buffers drain" ||
+		detail["source"] != "leaf-1" || detail["severity"] != "critical" || detail["component"] != "leaf-segment" ||
+		detail["timestamp"] != "2024-05-01T08:00:00Z" {
+		t.Fatalf("breaker open payload = %v", detail)
+	}
+	if custom, _ := detail["custom_details"].(map[string]any); custom["type"] != AlertBreakerOpen {
+		t.Fatalf("custom_details = %v, want the event", detail["custom_details"])
+	}
+
+	// 熔断器关闭时用相同的 dedup_key 恢复, 不带 payload
+	closed := decodeNotify(t, requests[1])
+	if closed["event_action"] != "resolve" || closed["dedup_key"] != open["dedup_key"] {
+		t.Fatalf("breaker closed = %v, want resolve of %v", closed, open["dedup_key"])
+	}
+	if _, ok := closed["payload"]; ok {
+		t.Fatalf("resolve carries payload %v", closed["payload"])
+	}
+
+	// 其他告警按类型和业务区分 dedup_key
+	refill := decodeNotify(t, requests[2])
+	if refill["event_action"] != "trigger" || refill["dedup_key"] != "leaf-segment/leaf-1/refill_failed/order" {
+		t.Fatalf("refill failed = %v, want trigger of leaf-segment/leaf-1/refill_failed/order", refill)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	server := newNotifyServer(t)
+	event := AlertEvent{Type: AlertLowRemaining, BizTag: "order", Remaining: 12}
+
+	// 默认发送事件的 JSON
+	notifier, err := newWebhookNotifier(AlertConfig{WebhookURL: server.URL, ContentType: "application/json", Timeout: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = notifier.Notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	payload := decodeNotify(t, server.received()[0])
+	if server.received()[0].contentType != "application/json" || payload["type"] != AlertLowRemaining || payload["biz_tag"] != "order" || payload["remaining"] != 12.0 {
+		t.Fatalf("webhook %q %v, want the event as JSON", server.received()[0].contentType, payload)
+	}
+
+	// 按模板渲染请求体
+	notifier, err = newWebhookNotifier(AlertConfig{
+		WebhookURL:  server.URL,
+		ContentType: "text/plain",
+		Template:    "{{.Type}} {{.BizTag}} {{.Remaining}}",
+		Timeout:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = notifier.Notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.received()[1]; request.contentType != "text/plain" || request.body != "low_remaining order 12" {
+		t.Fatalf("webhook %q %q, want text/plain %q", request.contentType, request.body, "low_remaining order 12")
+	}
+
+	// 非 2xx 状态码视为失败
+	server.statuses = []int{http.StatusBadGateway}
+	if err = notifier.Notify(context.Background(), event); err == nil || err.Error() != "webhook responded with status 502" {
+		t.Fatalf("err = %v, want webhook responded with status 502", err)
+	}
+}
+
+func TestAlertRetry(t *testing.T) {
+	event := AlertEvent{Type: AlertBreakerOpen}
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int // 期望的请求次数
+	}{
+		{name: "ok", want: 1},
+		{name: "recovered", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, want: 3},
+		{name: "exhausted", statuses: []int{500, 500, 500, 500}, want: alertAttempts},
+		{name: "rejected", statuses: []int{http.StatusBadRequest, 500}, want: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newNotifyServer(t, test.statuses...)
+			alerter := newTestAlerter(AlertConfig{})
+			alerter.retryDelay = time.Millisecond
+
+			// 暂时性的失败重试, 最多 alertAttempts 次; 4xx 说明配置有误, 不重试
+			alerter.deliver(&slackNotifier{conf: SlackConfig{WebhookURL: server.URL}, client: server.Client()}, event)
+			if requests := server.received(); len(requests) != test.want {
+				t.Fatalf("received %d requests, want %d", len(requests), test.want)
+			}
+		})
+	}
+
+	// 网络错误同样重试
+	server := newNotifyServer(t)
+	server.Close()
+	if !retryableNotify(postJSON(context.Background(), server.Client(), server.URL, event)) {
+		t.Fatal("network error is not retryable")
+	}
+}
+
+func TestAlertDedup(t *testing.T) {
+	server := newNotifyServer(t)
+	alerter := newTestAlerter(AlertConfig{Cooldown: 60000})
+	alerter.notifiers = []Notifier{&slackNotifier{conf: SlackConfig{WebhookURL: server.URL}, client: server.Client()}}
+	done := make(chan struct{})
+	go func() {
+		alerter.sendLoop()
+		close(done)
+	}()
+
+	// 冷却期内同一业务同类告警只发送一次, 不同业务和不同类型各自计算
+	for i := 0; i < 3; i++ {
+		alerter.Fire(AlertEvent{Type: AlertBreakerOpen})
+		alerter.Fire(AlertEvent{Type: AlertLowRemaining, BizTag: "order"})
+		alerter.Fire(AlertEvent{Type: AlertLowRemaining, BizTag: "user"})
+	}
+	close(alerter.events)
+	<-done
+
+	requests := server.received()
+	if len(requests) != 3 {
+		t.Fatalf("received %d requests, want 3", len(requests))
+	}
+	for i, want := range []string{"circuit breaker opened", "biz_tag order", "biz_tag user"} {
+		if text, _ := decodeNotify(t, requests[i])["text"].(string); !strings.Contains(text, want) {
+			t.Fatalf("request %d text = %q, want %q", i, text, want)
+		}
+	}
+}
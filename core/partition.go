@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PartitionConfig 定义多实例分区的配置
+// count 个实例共享同一个业务时, 实例 i 只使用自己的号段行 {biz_tag}{separator}{i}, 补充号段时不再争抢同一行的行锁
+// 实例 i 号段行中的第 k 个号码对外为 k × count + i, 各实例的 ID 交错且不重复
+// 号段行需预先创建; count 一旦对外发号就不能修改, 否则不同 count 下映射出的 ID 会重复, 扩容需预留足够的 count
+type PartitionConfig struct {
+	Enable    bool   `json:"enable"`    // 是否启用分区
+	Count     int    `json:"count"`     // 分区总数, 即最多同时运行的实例数
+	Instance  int    `json:"instance"`  // 本实例的分区编号, 0 ~ count-1, 同时运行的实例不能相同
+	Separator string `json:"separator"` // 业务标识与分区编号之间的分隔符, 默认 #
+}
+
+// checkPartition 检查分区配置
+func checkPartition(conf *Config) error {
+	partition := conf.Partition
+	if !partition.Enable {
+		return nil
+	}
+	if partition.Count < 1 || partition.Instance < 0 || partition.Instance >= partition.Count {
+		return fmt.Errorf("partition.instance %d out of range for partition.count %d", partition.Instance, partition.Count)
+	}
+	if conf.Lease.Enable {
+		return errors.New("lease cannot be enabled with partition: leased ranges are instance-local ids")
+	}
+	return nil
+}
+
+// id 将本实例号段行中的号码映射为对外的 ID, 未启用分区时不变
+func (partition PartitionConfig) id(local int64) int64 {
+	if !partition.Enable {
+		return local
+	}
+	return local*int64(partition.Count) + int64(partition.Instance)
+}
+
+// partitionStorage 将业务映射到本实例的号段行
+type partitionStorage struct {
+	Storage
+	suffix string // 号段行相对于业务标识的后缀
+}
+
+// wrapPartition 启用分区时包装号段存储
+func wrapPartition(storage Storage, partition PartitionConfig) Storage {
+	if !partition.Enable {
+		return storage
+	}
+	return &partitionStorage{Storage: storage, suffix: partition.suffix()}
+}
+
+// suffix 返回本实例号段行相对于业务标识的后缀, 未启用分区时为空
+func (partition PartitionConfig) suffix() string {
+	if !partition.Enable {
+		return ""
+	}
+	return partition.Separator + strconv.Itoa(partition.Instance)
+}
+
+// bizTag 返回号段行对应的业务标识
+func (partition PartitionConfig) bizTag(row string) string {
+	return strings.TrimSuffix(row, partition.suffix())
+}
+
+// NextId 从本实例的号段行获取号段
+func (storage *partitionStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	return storage.Storage.NextId(ctx, bizTag+storage.suffix, multiple)
+}
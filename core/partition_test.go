@@ -0,0 +1,57 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+
+	// 两个实例共享存储, 各自使用独立的号段行
+	seen := map[int64]int{}
+	for instance := 0; instance < 2; instance++ {
+		conf := &Config{Partition: PartitionConfig{Enable: true, Count: 2, Instance: instance, Separator: "#"}}
+		if err := checkPartition(conf); err != nil {
+			t.Fatal(err)
+		}
+		alloc := newAlloc(conf, newStorage(conf, storage, nil))
+		alloc.clock = newFakeClock(time.UnixMilli(0)) // 不叠加时间戳, 直接比较映射后的 ID
+		t.Cleanup(func() { _ = alloc.Close(context.Background()) })
+
+		for i := 0; i < 25; i++ {
+			id, err := alloc.NextId(context.Background(), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(id%2) != instance {
+				t.Fatalf("instance %d got id %d from another partition", instance, id)
+			}
+			if other, ok := seen[id]; ok {
+				t.Fatalf("id %d allocated by instance %d and %d", id, other, instance)
+			}
+			seen[id] = instance
+		}
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if storage.maxId["test"] != 0 || storage.maxId["test#0"] == 0 || storage.maxId["test#1"] == 0 {
+		t.Fatalf("storage rows = %v, want only test#0 and test#1", storage.maxId)
+	}
+}
+
+func TestCheckPartition(t *testing.T) {
+	for _, conf := range []Config{
+		{Partition: PartitionConfig{Enable: true, Count: 0}},
+		{Partition: PartitionConfig{Enable: true, Count: 2, Instance: 2}},
+		{Partition: PartitionConfig{Enable: true, Count: 2, Instance: -1}},
+		{Partition: PartitionConfig{Enable: true, Count: 2}, Lease: LeaseConfig{Enable: true}},
+	} {
+		if err := checkPartition(&conf); err == nil {
+			t.Fatalf("checkPartition(%+v) = nil, want error", conf.Partition)
+		}
+	}
+}
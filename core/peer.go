@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PeerConfig 定义本地号段存储不可用时转发分配请求的配置
+// 熔断器打开且内存中的号码用完时, /alloc 请求依次转发给对等实例, 由对等实例从它自己的号段存储分配
+// 转发的请求带有 X-Leaf-Forwarded 头, 对等实例收到后即使自己也无法分配也不会再次转发, 避免环路
+type PeerConfig struct {
+	Addrs   []string `json:"addrs"`   // 对等实例的地址, 如 http://10.0.0.2:8080, 为空表示不转发
+	Timeout int      `json:"timeout"` // 单次转发的超时时间（毫秒）
+}
+
+// forwardedHeader 标记请求已被转发过一次
+const forwardedHeader = "X-Leaf-Forwarded"
+
+// peerForwarder 将分配请求转发给对等实例
+type peerForwarder struct {
+	addrs   []string     // 对等实例的地址, 已去掉末尾的 /
+	headers []string     // 需要透传的认证请求头
+	client  *http.Client // 转发使用的 HTTP 客户端
+	next    atomic.Uint32
+	success atomic.Int64 // 对等实例分配成功的次数
+	fail    atomic.Int64 // 所有对等实例都分配失败的次数
+	loop    atomic.Int64 // 收到已转发过的请求且本地无法分配, 未再次转发的次数
+}
+
+// peers 全局对等转发, 未配置对等实例时为 nil
+var peers *peerForwarder
+
+// newPeerForwarder 按配置创建对等转发, 未配置对等实例时返回 nil
+func newPeerForwarder(conf PeerConfig, auth AuthConfig) *peerForwarder {
+	if len(conf.Addrs) == 0 {
+		return nil
+	}
+
+	forwarder := &peerForwarder{
+		headers: []string{"Authorization", requestIDHeader},
+		client:  &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+	}
+	if auth.Header != "" {
+		forwarder.headers = append(forwarder.headers, auth.Header)
+	}
+	for _, addr := range conf.Addrs {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		forwarder.addrs = append(forwarder.addrs, strings.TrimRight(addr, "/"))
+	}
+	return forwarder
+}
+
+// forwardAlloc 本地因熔断器打开无法分配时转发给对等实例, 无法转发或转发失败时返回本地的错误, 未配置对等实例时 forwarder 为 nil
+func (forwarder *peerForwarder) forwardAlloc(r *http.Request, bizTag string, cause error) (int64, error) {
+	if forwarder == nil || !errors.Is(cause, ErrCircuitOpen) {
+		return 0, cause
+	}
+	if r.Header.Get(forwardedHeader) != "" {
+		forwarder.loop.Add(1)
+		return 0, cause
+	}
+
+	id, err := forwarder.forward(r.Context(), r.Header, bizTag)
+	if err != nil {
+		forwarder.fail.Add(1)
+		logger.Warn("forward alloc to peers failed", "biz_tag", bizTag, "err", err)
+		return 0, cause
+	}
+	forwarder.success.Add(1)
+	return id, nil
+}
+
+// forward 从上次之后的一个对等实例开始依次尝试, 返回第一个成功分配的 ID
+func (forwarder *peerForwarder) forward(ctx context.Context, header http.Header, bizTag string) (id int64, err error) {
+	var errs []error
+
+	start := int(forwarder.next.Add(1))
+	for i := range forwarder.addrs {
+		addr := forwarder.addrs[(start+i)%len(forwarder.addrs)]
+		if id, err = forwarder.once(ctx, addr, header, bizTag); err == nil {
+			return id, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil { // 请求已超时或客户端已断开, 不再尝试
+			break
+		}
+	}
+	return 0, errors.Join(errs...)
+}
+
+// once 向一个对等实例转发分配请求
+func (forwarder *peerForwarder) once(ctx context.Context, addr string, header http.Header, bizTag string) (id int64, err error) {
+	var (
+		req  *http.Request
+		rsp  *http.Response
+		body []byte
+		resp AllocResponse
+	)
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, addr+"/alloc?biz_tag="+url.QueryEscape(bizTag), nil); err != nil {
+		return
+	}
+	for _, name := range forwarder.headers {
+		if value := header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set(forwardedHeader, "1")
+
+	if rsp, err = forwarder.client.Do(req); err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if body, err = io.ReadAll(io.LimitReader(rsp.Body, 4096)); err != nil {
+		return
+	}
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return 0, fmt.Errorf("peer %s: status %d: %w", addr, rsp.StatusCode, err)
+	}
+	if rsp.StatusCode != http.StatusOK || resp.ErrNo != 0 {
+		return 0, fmt.Errorf("peer %s: status %d: %s", addr, rsp.StatusCode, resp.Msg)
+	}
+	return resp.ID, nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeerForward(t *testing.T) {
+	setupHandlerTest(t)
+	storage := newFakeStorage(100)
+	storage.setErr(ErrCircuitOpen)
+	DefaultAlloc = newTestAlloc(t, storage)
+
+	// 对等实例检查透传的认证头和转发标记
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(forwardedHeader) == "" || r.Header.Get("X-API-Key") != "secret" || r.URL.Query().Get("biz_tag") != "test" {
+			writeError(w, http.StatusBadRequest, ErrNoFailed, "bad forward")
+			return
+		}
+		_, _ = w.Write([]byte(`{"err_no":0,"msg":"success","id":42}`))
+	}))
+	defer peer.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusServiceUnavailable, ErrNoStandby, "standby")
+	}))
+	defer down.Close()
+
+	peers = newPeerForwarder(PeerConfig{Addrs: []string{down.URL, peer.URL + "/"}, Timeout: 1000}, AuthConfig{Header: "X-API-Key"})
+	t.Cleanup(func() { peers = nil })
+
+	alloc := func(forwarded bool) (int, AllocResponse) {
+		r := httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil)
+		r.Header.Set("X-API-Key", "secret")
+		if forwarded {
+			r.Header.Set(forwardedHeader, "1")
+		}
+		w := httptest.NewRecorder()
+		handleAlloc(w, r)
+		var resp AllocResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	// 熔断器打开时跳过不可用的对等实例, 由可用的对等实例分配
+	for i := 0; i < 2; i++ {
+		if code, resp := alloc(false); code != http.StatusOK || resp.ID != 42 {
+			t.Fatalf("alloc = %d %+v, want id 42 from peer", code, resp)
+		}
+	}
+
+	// 已转发过的请求不再转发
+	if code, _ := alloc(true); code == http.StatusOK {
+		t.Fatal("forwarded request was forwarded again")
+	}
+	if peers.success.Load() != 2 || peers.loop.Load() != 1 || peers.fail.Load() != 0 {
+		t.Fatalf("success %d, loop %d, fail %d", peers.success.Load(), peers.loop.Load(), peers.fail.Load())
+	}
+
+	// 所有对等实例都失败时返回本地的错误
+	peer.Close()
+	if code, _ := alloc(false); code == http.StatusOK || peers.fail.Load() != 1 {
+		t.Fatalf("alloc with peers down = %d, fail %d", code, peers.fail.Load())
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PrefetchConfig 定义热点业务一次事务获取多个号段的配置
+// 每次获取时 max_id 前进 k × step, 结果在内存中拆分为 k 个号段, 减少数据库事务次数
+type PrefetchConfig struct {
+	Segments  int                `json:"segments"`  // 未单独配置的业务每次获取的号段个数, 小于等于1表示每次一个
+	Tags      map[string]int     `json:"tags"`      // 按业务标识单独配置每次获取的号段个数
+	Schedules []PrefetchSchedule `json:"schedules"` // 按计划在已知的流量高峰前补足号段
+}
+
+// PrefetchSchedule 定义一个业务的预取计划
+// 到达 cron 指定的时间时立即补充号段, 之后 duration 内内存中保持 buffer_depth 个号段, 高峰时的补充在后台提前完成
+type PrefetchSchedule struct {
+	BizTag      string `json:"biz_tag"`      // 业务标识
+	Cron        string `json:"cron"`         // 开始补充的时间, 五段式 cron 表达式: 分 时 日 月 周, 应早于高峰并留出补充时间
+	Timezone    string `json:"timezone"`     // cron 使用的时区, 如 Asia/Shanghai, 为空时使用本地时区
+	Duration    int    `json:"duration"`     // 窗口持续的时间（毫秒）, 应覆盖整个高峰
+	BufferDepth int    `json:"buffer_depth"` // 窗口内内存中保持的号段个数, 需大于2
+}
+
+// prefetchWindow 一个业务正在生效的预取窗口
+type prefetchWindow struct {
+	until time.Time // 窗口结束时间
+	depth int       // 窗口内保持的号段个数
+}
+
+// prefetchWindows 各业务正在生效的预取窗口
+type prefetchWindows struct {
+	mutex   sync.Mutex
+	windows map[string]prefetchWindow
+}
+
+// fetchSegments 返回该业务每次从数据库获取的号段个数, 至少为1
+func (alloc *Alloc) fetchSegments(bizTag string) int64 {
+	var (
+		conf  = alloc.conf.Prefetch
+		count int
+		found bool
+	)
+
+	if count, found = conf.Tags[bizTag]; !found {
+		count = conf.Segments
+	}
+	return int64(max(count, 1))
+}
+
+// checkPrefetch 检查预取计划, 返回与 schedules 一一对应的 cron 表达式
+func checkPrefetch(conf *Config) (crons []*cronSchedule, err error) {
+	for i, schedule := range conf.Prefetch.Schedules {
+		var cron *cronSchedule
+		if schedule.BizTag == "" || schedule.Duration <= 0 || schedule.BufferDepth <= 2 {
+			return nil, fmt.Errorf("prefetch.schedules[%d] needs biz_tag, duration > 0 and buffer_depth > 2", i)
+		}
+		if cron, err = parseCron(schedule.Cron, schedule.Timezone); err != nil {
+			return nil, fmt.Errorf("prefetch.schedules[%d]: %w", i, err)
+		}
+		crons = append(crons, cron)
+	}
+	return
+}
+
+// scheduleDepth 返回业务当前预取窗口内保持的号段个数, 不在窗口内时返回 0
+func (alloc *Alloc) scheduleDepth(bizTag string) int {
+	windows := &alloc.windows
+	if len(alloc.conf.Prefetch.Schedules) == 0 {
+		return 0
+	}
+	windows.mutex.Lock()
+	defer windows.mutex.Unlock()
+
+	window, ok := windows.windows[bizTag]
+	if !ok {
+		return 0
+	}
+	if !alloc.now().Before(window.until) {
+		delete(windows.windows, bizTag)
+		return 0
+	}
+	return window.depth
+}
+
+// openWindow 打开业务的预取窗口并立即补充号段, 与生效中的窗口重叠时合并
+func (alloc *Alloc) openWindow(schedule PrefetchSchedule) {
+	var (
+		windows = &alloc.windows
+		now     = alloc.now()
+		window  = prefetchWindow{until: now.Add(time.Duration(schedule.Duration) * time.Millisecond), depth: schedule.BufferDepth}
+	)
+
+	windows.mutex.Lock()
+	if windows.windows == nil {
+		windows.windows = map[string]prefetchWindow{}
+	}
+	if current, ok := windows.windows[schedule.BizTag]; ok && now.Before(current.until) {
+		window.until = maxTime(window.until, current.until)
+		window.depth = max(window.depth, current.depth)
+	}
+	windows.windows[schedule.BizTag] = window
+	windows.mutex.Unlock()
+
+	logger.Info("prefetch window opened", "biz_tag", schedule.BizTag, "buffer_depth", window.depth, "until", window.until)
+	alloc.warm(schedule.BizTag)
+}
+
+// maxTime 返回较晚的时间
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// runSchedules 按预取计划打开窗口, 分配器退出时返回; isStandby 判断本实例是否为备用实例, 为 nil 表示不参与选主
+func (alloc *Alloc) runSchedules(crons []*cronSchedule, isStandby func() bool) {
+	var (
+		schedules = alloc.conf.Prefetch.Schedules
+		next      = make([]time.Time, len(crons)) // 各计划的下一次触发时间
+	)
+
+	for i, cron := range crons {
+		next[i] = cron.next(alloc.now())
+	}
+	for {
+		// 等待最早的一次触发
+		earliest := -1
+		for i := range next {
+			if !next[i].IsZero() && (earliest < 0 || next[i].Before(next[earliest])) {
+				earliest = i
+			}
+		}
+		if earliest < 0 {
+			return
+		}
+
+		timer := alloc.newTimer(max(next[earliest].Sub(alloc.now()), 0))
+		select {
+		case <-timer.C():
+		case <-alloc.ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		// 同一时刻触发的计划一起打开
+		now := alloc.now()
+		for i := range next {
+			if !next[i].IsZero() && !next[i].After(now) {
+				if isStandby == nil || !isStandby() { // 备用实例不消耗号段
+					alloc.openWindow(schedules[i])
+				}
+				next[i] = crons[i].next(now)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckPrefetch(t *testing.T) {
+	for _, schedule := range []PrefetchSchedule{
+		{Cron: "0 0 * * *", Duration: 1000, BufferDepth: 4},
+		{BizTag: "test", Cron: "0 0 * * *", BufferDepth: 4},
+		{BizTag: "test", Cron: "0 0 * * *", Duration: 1000, BufferDepth: 2},
+		{BizTag: "test", Cron: "0 0 * *", Duration: 1000, BufferDepth: 4},
+	} {
+		if _, err := checkPrefetch(&Config{Prefetch: PrefetchConfig{Schedules: []PrefetchSchedule{schedule}}}); err == nil {
+			t.Errorf("checkPrefetch accepted %+v", schedule)
+		}
+	}
+}
+
+func TestPrefetchSchedule(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Prefetch.Schedules = []PrefetchSchedule{
+		{BizTag: "sale", Cron: "0 12 * * *", Timezone: "UTC", Duration: 60000, BufferDepth: 5},
+	}
+	crons, err := checkPrefetch(DefaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(time.Date(2026, 11, 11, 11, 59, 0, 0, time.UTC))
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	alloc.clock = clock
+	go alloc.runSchedules(crons, nil)
+
+	// 窗口打开前保持双Buffer
+	waitFor(t, "schedule timer", func() bool { return clock.pending() == 1 })
+	if depth := alloc.bufferDepth("sale"); depth != 2 {
+		t.Fatalf("bufferDepth before window = %d, want 2", depth)
+	}
+
+	// 到达计划时间后立即补足 buffer_depth 个号段
+	clock.advance(time.Minute)
+	waitFor(t, "segments prefetched", func() bool { return alloc.LeftCount("sale") == 500 })
+	if depth := alloc.bufferDepth("other"); depth != 2 {
+		t.Fatalf("bufferDepth of another biz_tag = %d, want 2", depth)
+	}
+
+	// 窗口结束后恢复双Buffer, 已获取的号段继续使用
+	waitFor(t, "next schedule timer", func() bool { return clock.pending() == 1 })
+	clock.advance(time.Minute)
+	if depth := alloc.bufferDepth("sale"); depth != 2 {
+		t.Fatalf("bufferDepth after window = %d, want 2", depth)
+	}
+}
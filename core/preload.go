@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PreloadConfig 定义启动预热的配置
+// 启动时先为这些业务获取第一个号段再开始监听, 发布后的第一个请求不必等待从号段存储获取号段
+type PreloadConfig struct {
+	Tags        []string `json:"tags"`        // 启动时预热的业务
+	All         bool     `json:"all"`         // 是否预热号段存储中的全部业务: 号段表中未归档的业务(启用分区时为本实例的号段行), 本地文件为 store.file.tags 中的业务
+	Timeout     int      `json:"timeout"`     // 等待预热完成的最长时间（毫秒）, 超时后仍然启动, 未完成的业务在后台继续获取; 0 表示不等待
+	Parallelism int      `json:"parallelism"` // 同时预热的业务数, 小于1时按1处理
+}
+
+// preload 按配置预热业务, 等待至多 timeout 后返回, 备用实例由选主预热
+func preload(alloc *Alloc, conf *Config) {
+	var (
+		timeout = time.Duration(conf.Preload.Timeout) * time.Millisecond
+		start   = time.Now()
+		tags    []string
+		ready   int64 // 已获取到号段的业务数
+		jobs    = make(chan string)
+		workers sync.WaitGroup
+		err     error
+	)
+
+	if standby() || (len(conf.Preload.Tags) == 0 && !conf.Preload.All) {
+		return
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), max(timeout, 0))
+	defer cancelFunc()
+
+	if tags, err = preloadTags(ctx, conf); err != nil {
+		logger.Warn("list preload tags failed", "err", err)
+	}
+
+	// 不等待时只在后台触发补充
+	if timeout <= 0 {
+		for _, bizTag := range tags {
+			alloc.warm(bizTag)
+		}
+		logger.Info("preload triggered", "tags", len(tags))
+		return
+	}
+
+	for i := 0; i < max(conf.Preload.Parallelism, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for bizTag := range jobs {
+				if alloc.preloadTag(ctx, bizTag) {
+					atomic.AddInt64(&ready, 1)
+				}
+			}
+		}()
+	}
+FEED:
+	for _, bizTag := range tags {
+		select {
+		case jobs <- bizTag:
+		case <-ctx.Done():
+			break FEED
+		}
+	}
+	close(jobs)
+	workers.Wait()
+
+	if int(ready) < len(tags) {
+		logger.Warn("preload incomplete", "tags", len(tags), "ready", ready, "elapsed_ms", time.Since(start).Milliseconds())
+		return
+	}
+	logger.Info("preload finished", "tags", len(tags), "elapsed_ms", time.Since(start).Milliseconds())
+}
+
+// preloadTags 返回需要预热的业务, 去除重复
+func preloadTags(ctx context.Context, conf *Config) (tags []string, err error) {
+	var (
+		rows   []TagInfo
+		suffix = conf.Partition.suffix()
+	)
+
+	tags = append(tags, conf.Preload.Tags...)
+	if conf.Preload.All {
+		if DefaultData == nil { // 本地文件号段存储
+			for bizTag := range conf.Store.File.Tags {
+				tags = append(tags, bizTag)
+			}
+		} else if rows, err = DefaultData.Tags(ctx, ""); err == nil {
+			for _, row := range rows {
+				if !row.Archived && strings.HasSuffix(row.BizTag, suffix) {
+					tags = append(tags, conf.Partition.bizTag(row.BizTag))
+				}
+			}
+		}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), err
+}
+
+// preloadTag 触发业务补充并等待第一个号段, 获取到号码时返回 true, 补偿线程放弃或超时时返回 false
+func (alloc *Alloc) preloadTag(ctx context.Context, bizTag string) bool {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	alloc.warm(bizTag)
+	for {
+		bizAlloc := alloc.load(bizTag)
+		if bizAlloc == nil { // 业务不存在, 号段池已被丢弃
+			return false
+		}
+		if stats := bizAlloc.stats(); stats.leftCount() > 0 {
+			return true
+		} else if stats.failing {
+			logger.Warn("preload failed", "biz_tag", bizTag, "err", stats.lastErr)
+			return false
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
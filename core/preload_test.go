@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPreloadTags(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Preload = PreloadConfig{Tags: []string{"b", "a"}, All: true}
+	DefaultConfig.Store.File.Tags = map[string]int64{"a": 100, "c": 100}
+
+	tags, err := preloadTags(context.Background(), DefaultConfig)
+	if err != nil || !slices.Equal(tags, []string{"a", "b", "c"}) {
+		t.Fatalf("preloadTags = (%v, %v), want [a b c]", tags, err)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Preload = PreloadConfig{Tags: []string{"a", "b", "c"}, Timeout: 1000, Parallelism: 2}
+	storage := newFakeStorage(100)
+	alloc := newTestAlloc(t, storage)
+
+	// 开始监听前每个业务都已获取到号段
+	preload(alloc, DefaultConfig)
+	for _, bizTag := range DefaultConfig.Preload.Tags {
+		if left := alloc.LeftCount(bizTag); left == 0 {
+			t.Errorf("LeftCount(%s) = 0 after preload", bizTag)
+		}
+	}
+	calls := storage.callCount()
+	if _, err := alloc.NextId(context.Background(), "a"); err != nil || storage.callCount() != calls {
+		t.Fatalf("first NextId after preload: err = %v, fetched again = %v", err, storage.callCount() != calls)
+	}
+}
+
+func TestPreloadFailure(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Preload = PreloadConfig{Tags: []string{"missing"}, Timeout: 5000, Parallelism: 1}
+	storage := newFakeStorage(100)
+	storage.setErr(ErrBizTagNotFound)
+	alloc := newTestAlloc(t, storage)
+
+	// 业务不存在时不等到超时
+	start := time.Now()
+	preload(alloc, DefaultConfig)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("preload of a missing biz_tag took %v", elapsed)
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded 业务在当前分钟或当天分配的ID数已达到配额
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaConfig 定义按业务的分配配额, 防止失控或有缺陷的调用方耗尽共用的号码空间
+// 配额按实例统计, 多实例部署时每个实例各自执行配额; 租出的号段按号段大小计入配额
+type QuotaConfig struct {
+	Default TagQuota            `json:"default"` // 未单独配置的业务使用的配额
+	Tags    map[string]TagQuota `json:"tags"`    // 按业务标识单独配置的配额
+}
+
+// TagQuota 定义单个业务的配额, 0 表示不限制
+type TagQuota struct {
+	PerMinute int64 `json:"per_minute"` // 每分钟最多分配的ID数
+	PerDay    int64 `json:"per_day"`    // 每天(本地时区 0 点起)最多分配的ID数
+}
+
+// quotaUsage 单个业务在当前窗口内已分配的ID数
+type quotaUsage struct {
+	minute      int64 // 当前分钟窗口的开始时间(毫秒时间戳)
+	minuteCount int64 // 当前分钟已分配的ID数
+	day         int64 // 当天窗口的开始时间(毫秒时间戳)
+	dayCount    int64 // 当天已分配的ID数
+}
+
+// quotaTable 各业务的配额用量
+type quotaTable struct {
+	mutex sync.Mutex
+	usage map[string]*quotaUsage
+}
+
+// quota 返回业务的配额
+func (alloc *Alloc) quota(bizTag string) TagQuota {
+	if quota, ok := alloc.conf.Quota.Tags[bizTag]; ok {
+		return quota
+	}
+	return alloc.conf.Quota.Default
+}
+
+// takeQuota 检查业务是否还有配额, 有配额时计入 n 个ID; n 为 0 时只检查
+func (alloc *Alloc) takeQuota(bizAlloc *BizAlloc, n int64) (err error) {
+	var (
+		quota = alloc.quota(bizAlloc.bizTag)
+		table = &alloc.quotas
+	)
+
+	if quota.PerMinute <= 0 && quota.PerDay <= 0 {
+		return nil
+	}
+
+	table.mutex.Lock()
+	usage := table.current(bizAlloc.bizTag, alloc.now())
+	switch {
+	case quota.PerMinute > 0 && usage.minuteCount >= quota.PerMinute:
+		err = fmt.Errorf("%w: biz_tag %s allocated %d ids this minute", ErrQuotaExceeded, bizAlloc.bizTag, usage.minuteCount)
+		atomic.AddInt64(&bizAlloc.metrics.quotaMinute, 1)
+	case quota.PerDay > 0 && usage.dayCount >= quota.PerDay:
+		err = fmt.Errorf("%w: biz_tag %s allocated %d ids today", ErrQuotaExceeded, bizAlloc.bizTag, usage.dayCount)
+		atomic.AddInt64(&bizAlloc.metrics.quotaDay, 1)
+	default:
+		usage.minuteCount += n
+		usage.dayCount += n
+	}
+	table.mutex.Unlock()
+
+	if err != nil {
+		statsd.Incr("quota_exceeded", "biz_tag:"+bizAlloc.bizTag)
+	}
+	return
+}
+
+// chargeQuota 将已分配的 n 个ID计入配额, 不检查是否超过, 用于整段租出的号段
+func (alloc *Alloc) chargeQuota(bizTag string, n int64) {
+	quota := alloc.quota(bizTag)
+	if quota.PerMinute <= 0 && quota.PerDay <= 0 {
+		return
+	}
+
+	alloc.quotas.mutex.Lock()
+	usage := alloc.quotas.current(bizTag, alloc.now())
+	usage.minuteCount += n
+	usage.dayCount += n
+	alloc.quotas.mutex.Unlock()
+}
+
+// current 返回业务在 now 所在窗口的用量, 进入新的窗口时清零, 调用方持有锁
+func (table *quotaTable) current(bizTag string, now time.Time) *quotaUsage {
+	if table.usage == nil {
+		table.usage = map[string]*quotaUsage{}
+	}
+	usage := table.usage[bizTag]
+	if usage == nil {
+		usage = &quotaUsage{}
+		table.usage[bizTag] = usage
+	}
+	if minute := now.Truncate(time.Minute).UnixMilli(); usage.minute != minute {
+		usage.minute, usage.minuteCount = minute, 0
+	}
+	if day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).UnixMilli(); usage.day != day {
+		usage.day, usage.dayCount = day, 0
+	}
+	return usage
+}
+
+// writeQuotaMetrics 输出各业务超过配额被拒绝的次数
+func writeQuotaMetrics(b *strings.Builder, gauges []bizGauge) {
+	fmt.Fprintln(b, "# HELP leaf_quota_exceeded_total Number of allocations rejected by the per-minute or per-day quota.")
+	fmt.Fprintln(b, "# TYPE leaf_quota_exceeded_total counter")
+	for _, g := range gauges {
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_quota_exceeded_total{biz_tag=\"%s\",window=\"minute\"} %d\n", tag, atomic.LoadInt64(&g.metrics.quotaMinute))
+		fmt.Fprintf(b, "leaf_quota_exceeded_total{biz_tag=\"%s\",window=\"day\"} %d\n", tag, atomic.LoadInt64(&g.metrics.quotaDay))
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllocQuota(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Quota = QuotaConfig{
+		Default: TagQuota{PerMinute: 3},
+		Tags:    map[string]TagQuota{"daily": {PerDay: 150}, "free": {}},
+	}
+	clock := newFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local))
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	alloc.clock = clock
+	ctx := context.Background()
+
+	// 每分钟最多 3 个, 下一分钟恢复
+	for i := 0; i < 3; i++ {
+		if _, err := alloc.NextId(ctx, "test"); err != nil {
+			t.Fatalf("NextId #%d: %v", i, err)
+		}
+	}
+	if _, err := alloc.NextId(ctx, "test"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("NextId over quota err = %v, want ErrQuotaExceeded", err)
+	}
+	clock.advance(time.Minute)
+	if _, err := alloc.NextId(ctx, "test"); err != nil {
+		t.Fatalf("NextId in the next minute: %v", err)
+	}
+
+	// 单独配置为不限制的业务不受默认配额影响
+	for i := 0; i < 10; i++ {
+		if _, err := alloc.NextId(ctx, "free"); err != nil {
+			t.Fatalf("NextId of unlimited biz_tag: %v", err)
+		}
+	}
+
+	// 租出的号段按号段大小计入每天的配额, 用完后拒绝租用和分配, 第二天恢复
+	if _, err := alloc.Lease(ctx, "daily", "test", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Lease(ctx, "daily", "test", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Lease(ctx, "daily", "test", ""); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Lease over quota err = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := alloc.NextId(ctx, "daily"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("NextId over quota err = %v, want ErrQuotaExceeded", err)
+	}
+	clock.advance(24 * time.Hour)
+	if _, err := alloc.NextId(ctx, "daily"); err != nil {
+		t.Fatalf("NextId on the next day: %v", err)
+	}
+
+	if status, errNo, _ := allocFailure(context.Background(), "test", ErrQuotaExceeded); status != http.StatusTooManyRequests || errNo != ErrNoQuotaExceeded {
+		t.Fatalf("allocFailure = (%d, %d), want (429, %d)", status, errNo, ErrNoQuotaExceeded)
+	}
+	var b strings.Builder
+	writeMetrics(&b, alloc.gauges())
+	for _, want := range []string{
+		`leaf_quota_exceeded_total{biz_tag="test",window="minute"} 1`,
+		`leaf_quota_exceeded_total{biz_tag="daily",window="day"} 2`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	CREATE TABLE `rate_limits` (
+	 `biz_tag` varchar(32) NOT NULL,
+	 `rate` double NOT NULL COMMENT '每秒允许的请求数',
+	 `burst` int NOT NULL COMMENT '允许的突发请求数',
+	 PRIMARY KEY (`biz_tag`)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+*/
+
+// RateLimitConfig 定义按业务限流的配置
+type RateLimitConfig struct {
+	Enable         bool                 `json:"enable"`          // 是否对 /alloc 按业务限流
+	Default        RateLimit            `json:"default"`         // 未单独配置的业务使用的限流, rate 为 0 表示不限流
+	Limits         map[string]RateLimit `json:"limits"`          // 按业务标识单独配置的限流
+	Table          string               `json:"table"`           // 存放限流配置的数据库表, 为空表示不从数据库加载, 优先于配置文件
+	ReloadInterval int                  `json:"reload_interval"` // 从数据库重新加载限流配置的间隔（毫秒）
+}
+
+// RateLimit 定义一个令牌桶
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  // 每秒补充的令牌数
+	Burst int     `json:"burst"` // 桶容量, 小于1时按1处理
+}
+
+// tokenBucket 令牌桶, 按流逝时间补充令牌
+type tokenBucket struct {
+	mutex  sync.Mutex
+	tokens float64   // 当前令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// capacity 返回桶容量
+func (limit RateLimit) capacity() float64 {
+	return math.Max(float64(limit.Burst), 1)
+}
+
+// take 取一个令牌, 令牌不足时返回需要等待的时间
+func (bucket *tokenBucket) take(limit RateLimit) (ok bool, wait time.Duration) {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	// 限流配置可能已重新加载, 总是按最新的配置补充令牌
+	now := time.Now()
+	bucket.tokens = math.Min(limit.capacity(), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+}
+
+// maxRateBuckets 令牌桶数量超过该值时清理已经补满的桶, 避免大量不同的业务标识让内存无限增长
+const maxRateBuckets = 10000
+
+// rateLimiter 按业务标识限流
+type rateLimiter struct {
+	conf     RateLimitConfig
+	mutex    sync.RWMutex
+	loaded   map[string]RateLimit    // 最近一次从数据库加载的限流配置
+	buckets  map[string]*tokenBucket // 各业务的令牌桶
+	rejected map[string]*int64       // 各业务被限流的请求数, 第一次被限流时创建
+	sweepAt  int                     // 令牌桶数量达到该值时清理一次
+	stopChan chan struct{}           // 停止重新加载
+	wait     sync.WaitGroup          // 等待重新加载线程退出
+}
+
+// limiter 全局限流器, 未启用时为 nil
+var limiter *rateLimiter
+
+// newRateLimiter 创建限流器, 配置了数据库表时先加载一次, 再定期重新加载
+func newRateLimiter(conf RateLimitConfig) (rl *rateLimiter, err error) {
+	rl = &rateLimiter{
+		conf:     conf,
+		buckets:  map[string]*tokenBucket{},
+		rejected: map[string]*int64{},
+		sweepAt:  maxRateBuckets,
+		stopChan: make(chan struct{}),
+	}
+
+	if conf.Table == "" {
+		return
+	}
+	if err = rl.reload(); err != nil {
+		return nil, err
+	}
+	rl.wait.Add(1)
+	go rl.reloadLoop()
+	return
+}
+
+// stop 停止重新加载限流配置
+func (rl *rateLimiter) stop() {
+	close(rl.stopChan)
+	rl.wait.Wait()
+}
+
+// reload 从数据库加载全部限流配置
+func (rl *rateLimiter) reload() (err error) {
+	var (
+		rows   *sql.Rows
+		loaded = map[string]RateLimit{}
+	)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFunc()
+
+	if rows, err = DefaultData.reader().QueryContext(ctx, "SELECT biz_tag, rate, burst FROM "+rl.conf.Table); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			bizTag string
+			limit  RateLimit
+		)
+		if err = rows.Scan(&bizTag, &limit.Rate, &limit.Burst); err != nil {
+			return
+		}
+		loaded[bizTag] = limit
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	rl.mutex.Lock()
+	rl.loaded = loaded
+	rl.mutex.Unlock()
+	return
+}
+
+// reloadLoop 定期重新加载数据库中的限流配置, 加载失败时保留上一次的结果
+func (rl *rateLimiter) reloadLoop() {
+	defer rl.wait.Done()
+
+	var (
+		interval = time.Duration(rl.conf.ReloadInterval) * time.Millisecond
+		ticker   *time.Ticker
+	)
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := rl.reload(); err != nil {
+				logger.Warn("reload rate limits failed", "table", rl.conf.Table, "err", err)
+			}
+		case <-rl.stopChan:
+			return
+		}
+	}
+}
+
+// limitOf 返回该业务的限流配置, 数据库中的配置优先于配置文件, 调用方需持有锁
+func (rl *rateLimiter) limitOf(bizTag string) RateLimit {
+	if limit, found := rl.loaded[bizTag]; found {
+		return limit
+	}
+	if limit, found := rl.conf.Limits[bizTag]; found {
+		return limit
+	}
+	return rl.conf.Default
+}
+
+// allow 判断该业务的请求是否放行, 被限流时返回建议的重试等待时间
+// 非法的业务标识直接放行, 由处理函数返回参数错误, 不为其创建令牌桶
+func (rl *rateLimiter) allow(bizTag string) (ok bool, wait time.Duration) {
+	var (
+		limit   RateLimit
+		bucket  *tokenBucket
+		counter *int64
+	)
+
+	if bizTagValidator.validate(bizTag) != nil {
+		return true, 0
+	}
+
+	rl.mutex.RLock()
+	limit = rl.limitOf(bizTag)
+	bucket = rl.buckets[bizTag]
+	rl.mutex.RUnlock()
+
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	if bucket == nil {
+		rl.mutex.Lock()
+		if bucket = rl.buckets[bizTag]; bucket == nil {
+			if len(rl.buckets) >= rl.sweepAt {
+				rl.sweep()
+			}
+			bucket = &tokenBucket{tokens: limit.capacity(), last: time.Now()}
+			rl.buckets[bizTag] = bucket
+		}
+		rl.mutex.Unlock()
+	}
+
+	if ok, wait = bucket.take(limit); ok {
+		return
+	}
+
+	rl.mutex.RLock()
+	counter = rl.rejected[bizTag]
+	rl.mutex.RUnlock()
+	if counter == nil {
+		rl.mutex.Lock()
+		if counter = rl.rejected[bizTag]; counter == nil {
+			counter = new(int64)
+			rl.rejected[bizTag] = counter
+		}
+		rl.mutex.Unlock()
+	}
+	atomic.AddInt64(counter, 1)
+	return
+}
+
+// sweep 删除已经补满的令牌桶及其被限流计数, 补满的桶与新建的桶等价, 删除后不影响限流结果
+// 被删除的计数在指标中表现为计数器重置, 调用方需持有写锁
+func (rl *rateLimiter) sweep() {
+	now := time.Now()
+	for bizTag, bucket := range rl.buckets {
+		limit := rl.limitOf(bizTag)
+		bucket.mutex.Lock()
+		full := limit.Rate <= 0 || bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= limit.capacity()
+		bucket.mutex.Unlock()
+		if full {
+			delete(rl.buckets, bizTag)
+		}
+	}
+	for bizTag := range rl.rejected {
+		if rl.buckets[bizTag] == nil {
+			delete(rl.rejected, bizTag)
+		}
+	}
+
+	// 仍在消耗令牌的桶很多时推迟下一次清理, 避免每新建一个桶都遍历一遍
+	rl.sweepAt = max(maxRateBuckets, 2*len(rl.buckets))
+	logger.Debug("rate limit buckets swept", "remaining", len(rl.buckets))
+}
+
+// withRateLimit 按业务限流, 超过限制时返回 HTTP 429 和 Retry-After
+func withRateLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bizTag := r.FormValue("biz_tag")
+		if ok, wait := limiter.allow(bizTag); !ok {
+			statsd.Incr("rate_limited", "biz_tag:"+bizTag)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, ErrNoRateLimited, "rate limit exceeded")
+			return
+		}
+		handler(w, r)
+	}
+}
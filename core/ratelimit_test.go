@@ -0,0 +1,190 @@
+package core
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLimiter 创建全局限流器, 测试结束时停止并恢复
+func newTestLimiter(tb testing.TB, conf RateLimitConfig) *rateLimiter {
+	tb.Helper()
+
+	rl, err := newRateLimiter(conf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	limiter = rl
+	tb.Cleanup(func() {
+		rl.stop()
+		limiter = nil
+	})
+	return rl
+}
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{Rate: 10, Burst: 2}
+	bucket := &tokenBucket{tokens: limit.capacity(), last: time.Now()}
+
+	// 突发请求用完桶容量后被限流, 等待时间按补充一个令牌计算
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.take(limit); !ok {
+			t.Fatalf("take #%d rejected within burst", i)
+		}
+	}
+	ok, wait := bucket.take(limit)
+	if ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("take = (%v, %v), want rejected with wait in (0, 100ms]", ok, wait)
+	}
+
+	// 按流逝时间补充令牌, 且不超过桶容量
+	bucket.last = bucket.last.Add(-time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.take(limit); !ok {
+			t.Fatalf("take #%d rejected after refill", i)
+		}
+	}
+	if ok, _ := bucket.take(limit); ok {
+		t.Fatal("refill exceeded the burst")
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	setupHandlerTest(t)
+	newTestLimiter(t, RateLimitConfig{Enable: true, Limits: map[string]RateLimit{"test": {Rate: 0.5, Burst: 1}}})
+	handler := withRateLimit(func(w http.ResponseWriter, r *http.Request) {})
+
+	get := func(bizTag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/alloc?biz_tag="+bizTag, nil))
+		return w
+	}
+	if w := get("test"); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", w.Code)
+	}
+
+	// 每秒补充 0.5 个令牌, 建议2秒后重试
+	w := get("test")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" || !strings.Contains(w.Body.String(), `"err_no":-5`) {
+		t.Fatalf("second request = %d Retry-After %q %s, want 429 after 2s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+
+	// 未单独配置且默认不限流的业务不受影响
+	for i := 0; i < 3; i++ {
+		if w := get("other"); w.Code != http.StatusOK {
+			t.Fatalf("unlimited request = %d, want 200", w.Code)
+		}
+	}
+
+	var b strings.Builder
+	writeRateLimitMetrics(&b)
+	if metrics := b.String(); !strings.Contains(metrics, `leaf_rate_limited_total{biz_tag="test"} 1`) || strings.Contains(metrics, `"other"`) {
+		t.Fatalf("metrics = %s, want only test rejected once", metrics)
+	}
+}
+
+func TestRateLimitInvalidBizTag(t *testing.T) {
+	setupHandlerTest(t)
+	rl := newTestLimiter(t, RateLimitConfig{Enable: true, Default: RateLimit{Rate: 1, Burst: 1}})
+
+	// 非法的业务标识交给处理函数返回参数错误, 不创建令牌桶和计数
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.allow(strings.Repeat("x", 33)); !ok {
+			t.Fatal("invalid biz_tag rate limited")
+		}
+		if ok, _ := rl.allow("bad tag"); !ok {
+			t.Fatal("invalid biz_tag rate limited")
+		}
+	}
+	if len(rl.buckets) != 0 || len(rl.rejected) != 0 {
+		t.Fatalf("%d buckets and %d counters for invalid biz_tags, want none", len(rl.buckets), len(rl.rejected))
+	}
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	setupHandlerTest(t)
+	rl := newTestLimiter(t, RateLimitConfig{
+		Enable:  true,
+		Default: RateLimit{Rate: 1000, Burst: 1},
+		Limits:  map[string]RateLimit{"hot": {Rate: 0.001, Burst: 1}},
+	})
+	rl.sweepAt = 3
+
+	// hot 的令牌已耗尽, 其余业务很快补满
+	rl.allow("hot")
+	if ok, _ := rl.allow("hot"); ok {
+		t.Fatal("hot not rate limited")
+	}
+	rl.allow("idle1")
+	rl.allow("idle2")
+	time.Sleep(5 * time.Millisecond)
+
+	// 达到上限时只清理补满的桶, 仍在限流的业务保留令牌桶和计数
+	rl.allow("new")
+	if len(rl.buckets) != 2 || rl.buckets["hot"] == nil || rl.buckets["new"] == nil {
+		t.Fatalf("buckets after sweep = %v, want hot and new", rl.buckets)
+	}
+	if counter := rl.rejected["hot"]; counter == nil || *counter != 1 {
+		t.Fatal("rejected counter of hot lost in sweep")
+	}
+	if rl.sweepAt != maxRateBuckets {
+		t.Fatalf("sweepAt = %d, want %d", rl.sweepAt, maxRateBuckets)
+	}
+	if ok, _ := rl.allow("hot"); ok {
+		t.Fatal("hot not rate limited after sweep")
+	}
+}
+
+func TestRateLimitReload(t *testing.T) {
+	setupHandlerTest(t)
+	table := &fakeTable{columns: []string{"biz_tag", "rate", "burst"}}
+	table.setRows([]driver.Value{"test", 0.5, int64(1)})
+	openFakeData(t, table)
+
+	// 数据库中的配置优先于配置文件
+	rl, err := newRateLimiter(RateLimitConfig{
+		Enable:         true,
+		Limits:         map[string]RateLimit{"test": {Rate: 1000, Burst: 1000}},
+		Table:          "rate_limits",
+		ReloadInterval: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := sync.OnceFunc(rl.stop)
+	t.Cleanup(stop)
+	rl.allow("test")
+	if ok, _ := rl.allow("test"); ok {
+		t.Fatal("limit from table not applied")
+	}
+
+	// 重新加载失败时保留上一次的结果
+	table.mutex.Lock()
+	table.err = errors.New("db down")
+	table.mutex.Unlock()
+	queries := table.queryCount()
+	waitFor(t, "failed reload", func() bool { return table.queryCount() > queries+1 })
+	if ok, _ := rl.allow("test"); ok {
+		t.Fatal("limit dropped after failed reload")
+	}
+
+	// 表中删除该业务后按配置文件限流
+	table.mutex.Lock()
+	table.err = nil
+	table.mutex.Unlock()
+	table.setRows()
+	waitFor(t, "reload", func() bool { ok, _ := rl.allow("test"); return ok })
+
+	// 停止后不再查询
+	stop()
+	queries = table.queryCount()
+	time.Sleep(20 * time.Millisecond)
+	if got := table.queryCount(); got != queries {
+		t.Fatalf("%d queries after stop", got-queries)
+	}
+}
@@ -0,0 +1,255 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegistryConfig 定义服务注册的配置, 服务端开始监听后注册, 优雅退出时最先注销, 客户端不再把请求发往正在退出的实例
+type RegistryConfig struct {
+	Addr  string              `json:"addr"`  // 注册的服务地址, 如 http://10.0.0.1:8880, 为空时使用 主机名:http_port
+	Etcd  EtcdRegistryConfig  `json:"etcd"`  // etcd 注册配置
+	Nacos NacosRegistryConfig `json:"nacos"` // Nacos 注册配置
+}
+
+// EtcdRegistryConfig 定义注册到 etcd 的配置
+// 使用 etcd v3 的 HTTP/JSON 接口, 实例地址写入 {prefix}{实例标识}, 绑定租约并定期续约, 实例异常退出时键随租约过期删除
+type EtcdRegistryConfig struct {
+	Endpoints []string `json:"endpoints"` // etcd 的 HTTP 地址, 如 http://10.0.0.1:2379, 为空表示不注册
+	Prefix    string   `json:"prefix"`    // 注册的键前缀, 客户端监听同一个前缀
+	TTL       int      `json:"ttl"`       // 租约有效期（秒）, 每隔三分之一有效期续约一次
+	Timeout   int      `json:"timeout"`   // 单次请求 etcd 的超时时间（毫秒）
+}
+
+// registrar 一种服务注册方式
+type registrar interface {
+	// register 在后台注册并保持, 直到 deregister; 注册中心不可用时不影响服务启动, 在后台重试
+	register()
+	// deregister 停止保持并注销
+	deregister(ctx context.Context) error
+}
+
+// registrars 已注册的服务注册方式
+var registrars []registrar
+
+// advertiseAddr 返回注册的服务地址
+func advertiseAddr(conf *Config) string {
+	if conf.Registry.Addr != "" {
+		return conf.Registry.Addr
+	}
+	scheme := "http"
+	if conf.TLS.Enable {
+		scheme = "https"
+	}
+	hostname, _ := os.Hostname()
+	return scheme + "://" + hostname + ":" + strconv.Itoa(conf.HttpPort)
+}
+
+// checkRegistry 检查服务注册配置, 在开始监听前调用
+func checkRegistry(conf *Config) (err error) {
+	if len(conf.Registry.Nacos.Addrs) != 0 {
+		_, _, err = splitAddr(advertiseAddr(conf))
+	}
+	return
+}
+
+// splitAddr 将服务地址拆分为主机和端口
+func splitAddr(addr string) (host string, port int, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid registry.addr %q: %w", addr, err)
+	}
+	if port, err = strconv.Atoi(u.Port()); err != nil {
+		return "", 0, fmt.Errorf("registry.addr %q has no port", addr)
+	}
+	return u.Hostname(), port, nil
+}
+
+// startRegistry 按配置注册服务, 配置已由 checkRegistry 检查
+func startRegistry(conf *Config) {
+	registrars = nil
+	if len(conf.Registry.Etcd.Endpoints) != 0 {
+		registrars = append(registrars, newEtcdRegistrar(conf.Registry.Etcd, instanceName(conf), advertiseAddr(conf)))
+	}
+	if len(conf.Registry.Nacos.Addrs) != 0 {
+		host, port, _ := splitAddr(advertiseAddr(conf))
+		registrars = append(registrars, newNacosRegistrar(conf.Registry.Nacos, host, port))
+	}
+	for _, r := range registrars {
+		r.register()
+	}
+}
+
+// stopRegistry 注销所有注册方式
+func stopRegistry(ctx context.Context) (err error) {
+	for _, r := range registrars {
+		err = errors.Join(err, r.deregister(ctx))
+	}
+	registrars = nil
+	return
+}
+
+// etcdRegistrar 通过 etcd v3 HTTP/JSON 接口注册
+type etcdRegistrar struct {
+	conf     EtcdRegistryConfig
+	key      string         // 注册的键
+	value    string         // 注册的服务地址
+	client   *http.Client   // 请求 etcd 的 HTTP 客户端
+	lease    string         // 当前租约 ID, 只在保持线程中读写
+	stopChan chan struct{}  // 停止续约
+	wait     sync.WaitGroup // 等待续约线程退出
+}
+
+// newEtcdRegistrar 创建 etcd 注册
+func newEtcdRegistrar(conf EtcdRegistryConfig, instance string, addr string) *etcdRegistrar {
+	if conf.TTL <= 0 {
+		conf.TTL = 10
+	}
+	return &etcdRegistrar{
+		conf:     conf,
+		key:      conf.Prefix + instance,
+		value:    addr,
+		client:   &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+		stopChan: make(chan struct{}),
+	}
+}
+
+// register 实现 registrar: 在后台申请租约、写入地址并续约
+func (etcd *etcdRegistrar) register() {
+	etcd.wait.Add(1)
+	go etcd.keepAlive()
+}
+
+// put 申请新的租约并写入地址
+func (etcd *etcdRegistrar) put(ctx context.Context) (err error) {
+	var grant struct {
+		ID string `json:"ID"`
+	}
+
+	if err = etcd.call(ctx, "/v3/lease/grant", map[string]any{"TTL": etcd.conf.TTL}, &grant); err != nil {
+		return err
+	}
+	if grant.ID == "" {
+		return errors.New("etcd: lease grant returned no id")
+	}
+	if err = etcd.call(ctx, "/v3/kv/put", map[string]any{
+		"key":   base64.StdEncoding.EncodeToString([]byte(etcd.key)),
+		"value": base64.StdEncoding.EncodeToString([]byte(etcd.value)),
+		"lease": grant.ID,
+	}, nil); err != nil {
+		return err
+	}
+	etcd.lease = grant.ID
+	logger.Info("registered to etcd", "key", etcd.key, "addr", etcd.value, "lease", etcd.lease)
+	return nil
+}
+
+// keepAlive 立即注册, 之后每隔三分之一有效期续约; 尚未注册成功或租约已过期时重新注册
+func (etcd *etcdRegistrar) keepAlive() {
+	var (
+		rsp struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		err error
+	)
+
+	defer etcd.wait.Done()
+
+	ticker := time.NewTicker(time.Duration(etcd.conf.TTL) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		if etcd.lease == "" {
+			err = etcd.put(context.Background())
+		} else {
+			rsp.Result.TTL = ""
+			err = etcd.call(context.Background(), "/v3/lease/keepalive", map[string]any{"ID": etcd.lease}, &rsp)
+			if err == nil && (rsp.Result.TTL == "" || rsp.Result.TTL == "0") { // 租约已过期, 键已被删除
+				logger.Warn("etcd lease expired, registering again", "key", etcd.key, "lease", etcd.lease)
+				etcd.lease = ""
+				err = etcd.put(context.Background())
+			}
+		}
+		if err != nil {
+			logger.Warn("etcd register failed", "key", etcd.key, "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-etcd.stopChan:
+			return
+		}
+	}
+}
+
+// deregister 实现 registrar: 停止续约并撤销租约, 键随租约立即删除
+func (etcd *etcdRegistrar) deregister(ctx context.Context) error {
+	close(etcd.stopChan)
+	etcd.wait.Wait()
+
+	if etcd.lease == "" {
+		return nil
+	}
+	if err := etcd.call(ctx, "/v3/lease/revoke", map[string]any{"ID": etcd.lease}, nil); err != nil {
+		return fmt.Errorf("etcd: revoke lease: %w", err)
+	}
+	logger.Info("deregistered from etcd", "key", etcd.key)
+	return nil
+}
+
+// call 依次向各个 etcd 地址发送请求, 直到有一个成功
+func (etcd *etcdRegistrar) call(ctx context.Context, path string, request any, response any) (err error) {
+	var body []byte
+
+	if body, err = json.Marshal(request); err != nil {
+		return
+	}
+	for _, endpoint := range etcd.conf.Endpoints {
+		if err = etcd.once(ctx, strings.TrimRight(endpoint, "/")+path, body, response); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// once 向一个 etcd 地址发送请求
+func (etcd *etcdRegistrar) once(ctx context.Context, url string, body []byte, response any) (err error) {
+	var (
+		req     *http.Request
+		rsp     *http.Response
+		content []byte
+	)
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rsp, err = etcd.client.Do(req); err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if content, err = io.ReadAll(io.LimitReader(rsp.Body, 1<<20)); err != nil {
+		return
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd: %s: status %d: %s", url, rsp.StatusCode, strings.TrimSpace(string(content)))
+	}
+	if response != nil {
+		err = json.Unmarshal(content, response)
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEtcdRegistrar(t *testing.T) {
+	var (
+		mutex      sync.Mutex
+		leases     int
+		calls      []string // 按顺序记录的请求路径和租约
+		kvs        = map[string]string{}
+		registered string
+		expired    = true // 第一次续约时报告租约已过期
+		requests   = make(chan string, 64)
+	)
+
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID    string `json:"ID"`
+			TTL   int    `json:"TTL"`
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+			Lease string `json:"lease"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			leases++
+			fmt.Fprintf(w, `{"ID":"%d","TTL":"%d"}`, leases, request.TTL)
+			calls = append(calls, fmt.Sprintf("grant:%d", request.TTL))
+		case "/v3/kv/put":
+			kvs[string(request.Key)] = string(request.Value)
+			registered = string(request.Key) + "=" + string(request.Value)
+			_, _ = w.Write([]byte(`{}`))
+			calls = append(calls, "put:"+request.Lease)
+		case "/v3/lease/keepalive":
+			if expired {
+				expired = false
+				_, _ = w.Write([]byte(`{"result":{"ID":"` + request.ID + `"}}`))
+			} else {
+				_, _ = w.Write([]byte(`{"result":{"ID":"` + request.ID + `","TTL":"1"}}`))
+			}
+			calls = append(calls, "keepalive:"+request.ID)
+		case "/v3/lease/revoke":
+			delete(kvs, "/leaf-segment/node-1")
+			_, _ = w.Write([]byte(`{}`))
+			calls = append(calls, "revoke:"+request.ID)
+		}
+		requests <- r.URL.Path
+	}))
+	defer etcd.Close()
+
+	// 第一个地址不可用, 依次尝试下一个
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	registrar := newEtcdRegistrar(EtcdRegistryConfig{Endpoints: []string{down.URL, etcd.URL}, Prefix: "/leaf-segment/", TTL: 1, Timeout: 1000},
+		"node-1", "http://10.0.0.1:8880")
+	registrar.register()
+
+	// 注册后续约, 租约过期时重新注册, 然后继续续约新的租约
+	deadline := time.After(5 * time.Second)
+	for keepalives := 0; keepalives < 2; {
+		select {
+		case path := <-requests:
+			if path == "/v3/lease/keepalive" {
+				keepalives++
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for keepalives")
+		}
+	}
+	if err := registrar.deregister(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []string{"grant:1", "put:1", "keepalive:1", "grant:1", "put:2", "keepalive:2", "revoke:2"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	if registered != "/leaf-segment/node-1=http://10.0.0.1:8880" {
+		t.Fatalf("registered %q", registered)
+	}
+	if len(kvs) != 0 {
+		t.Fatalf("kvs = %v after deregister, want empty", kvs)
+	}
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	conf := NewConfig()
+	conf.HttpPort = 8880
+	conf.TLS.Enable = true
+	if addr := advertiseAddr(&conf); addr[:8] != "https://" || addr[len(addr)-5:] != ":8880" {
+		t.Fatalf("advertiseAddr = %q, want https://{hostname}:8880", addr)
+	}
+	conf.Registry.Addr = "http://10.0.0.1:8880"
+	if addr := advertiseAddr(&conf); addr != conf.Registry.Addr {
+		t.Fatalf("advertiseAddr = %q, want %q", addr, conf.Registry.Addr)
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// requestIDHeader 请求 ID 的请求头和响应头
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 接受的调用方请求 ID 的最大长度, 超过时重新生成
+const maxRequestIDLength = 128
+
+// requestIDKey context 中保存请求 ID 的键
+type requestIDKey struct{}
+
+var (
+	requestIDPrefix = newRequestIDPrefix() // 本进程生成的请求 ID 的前缀, 区分不同实例和重启
+	requestIDSeq    uint64                 // 本进程生成的请求 ID 的序号, 原子递增
+)
+
+// newRequestIDPrefix 生成随机的请求 ID 前缀
+func newRequestIDPrefix() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newRequestID 生成请求 ID, 只做一次原子递增, 不影响分配端口的性能
+func newRequestID() string {
+	return requestIDPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&requestIDSeq, 1), 36)
+}
+
+// validRequestID 调用方的请求 ID 是否可以直接使用: 不超过最大长度, 只包含可见的 ASCII 字符, 避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID 返回 context 中的请求 ID, 不在 HTTP 请求中时返回空字符串, 钩子可以用它关联服务端日志
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID 沿用调用方的 X-Request-ID, 没有或不合法时生成一个, 写入响应头并放入 context, 供日志、链路追踪和错误响应使用
+func withRequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id) // 转发给对等实例时沿用同一个请求 ID
+		}
+		w.Header().Set(requestIDHeader, id)
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
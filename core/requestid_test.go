@@ -0,0 +1,49 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		writeError(w, http.StatusInternalServerError, ErrNoFailed, "boom")
+	}))
+
+	serve := func(id string) (*httptest.ResponseRecorder, AllocResponse) {
+		r := httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test", nil)
+		if id != "" {
+			r.Header.Set(requestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var resp AllocResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w, resp
+	}
+
+	// 沿用调用方的请求 ID, 响应头、context 和错误响应中一致
+	w, resp := serve("client-42")
+	if got := w.Header().Get(requestIDHeader); got != "client-42" || seen != "client-42" || resp.RequestID != "client-42" {
+		t.Fatalf("request id = (header %q, context %q, body %q), want client-42", got, seen, resp.RequestID)
+	}
+
+	// 没有或不合法时生成新的请求 ID
+	for _, id := range []string{"", "bad id\n", strings.Repeat("x", maxRequestIDLength+1)} {
+		w, resp = serve(id)
+		got := w.Header().Get(requestIDHeader)
+		if got == "" || got == id || seen != got || resp.RequestID != got {
+			t.Fatalf("request id for %q = (header %q, context %q, body %q), want a generated id", id, got, seen, resp.RequestID)
+		}
+	}
+	if first, _ := serve(""); first.Header().Get(requestIDHeader) == w.Header().Get(requestIDHeader) {
+		t.Fatal("generated the same request id twice")
+	}
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReservationNotFound 预留不存在, 已过期、已确认、已释放或属于其他业务
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// ReserveConfig 定义两阶段分配的配置
+// 调用方先预留一个 ID, 业务成功后确认, 中止时释放; 释放的 ID 由之后的预留复用, 不会在外部留下空洞
+// 周期重置的业务不支持预留: 新周期的号码从头开始, 上一周期释放的 ID 会与之重复
+type ReserveConfig struct {
+	Enable        bool `json:"enable"`         // 是否提供 /reserve 接口
+	TTL           int  `json:"ttl"`            // 预留的有效期（毫秒）, 到期未确认的 ID 记录日志后作废, 0 表示默认 30 秒
+	SweepInterval int  `json:"sweep_interval"` // 检查过期预留的间隔（毫秒）, 0 表示有效期的一半
+}
+
+// ReserveResponse 用于封装预留、确认和释放请求的响应
+type ReserveResponse struct {
+	ErrNo         int       `json:"err_no"`              // 错误码
+	Msg           string    `json:"msg"`                 // 错误或成功消息
+	ReservationID string    `json:"reservation_id"`      // 预留标识, 确认和释放时携带
+	ID            int64     `json:"id"`                  // 预留的ID
+	ExpireAt      time.Time `json:"expire_at"`           // 预留到期时间
+	Retryable     bool      `json:"retryable,omitempty"` // 失败时重试是否可能成功, 缺省为 false
+	Cause         string    `json:"cause,omitempty"`     // 失败时的错误原因, 取值见 Cause 常量
+}
+
+// Reservation 一个待确认的预留, 用于管理接口查询
+type Reservation struct {
+	ReservationID string    `json:"reservation_id"` // 预留标识
+	BizTag        string    `json:"biz_tag"`        // 业务标识
+	ID            int64     `json:"id"`             // 预留的ID
+	Caller        string    `json:"caller"`         // 预留的调用方, 未启用认证时为 anonymous
+	ReservedAt    time.Time `json:"reserved_at"`    // 预留时间
+	ExpireAt      time.Time `json:"expire_at"`      // 到期时间
+}
+
+// ReservationsResponse 用于封装预留查询请求的响应
+type ReservationsResponse struct {
+	ErrNo        int           `json:"err_no"`       // 错误码
+	Msg          string        `json:"msg"`          // 错误或成功消息
+	Reservations []Reservation `json:"reservations"` // 待确认的预留, 按预留时间排序
+}
+
+// maxReleasedIds 每个业务最多保存的已释放 ID, 超出后释放的 ID 记录日志后作废
+const maxReleasedIds = 1024
+
+// reservationTable 记录待确认的预留和释放后可复用的 ID
+// 与租约相同, 过期的预留只记录日志后作废, 不再复用: 调用方可能只是确认晚了
+type reservationTable struct {
+	mutex     sync.Mutex
+	pending   map[string]*Reservation // 待确认的预留(reservation_id -> 预留)
+	released  map[string][]int64      // 各业务释放的 ID, 每个业务最多 maxReleasedIds 个
+	sweepOnce sync.Once               // 第一次预留时启动过期检查
+}
+
+// reserveTTL 返回预留有效期
+func (alloc *Alloc) reserveTTL() time.Duration {
+	if ttl := alloc.conf.Reserve.TTL; ttl > 0 {
+		return time.Duration(ttl) * time.Millisecond
+	}
+	return 30 * time.Second
+}
+
+// Reserve 预留一个 ID, 优先复用已释放的 ID, 否则与 /alloc 相同地分配
+func (alloc *Alloc) Reserve(ctx context.Context, bizTag string, caller string) (reservation Reservation, err error) {
+	if alloc.resets[bizTag] != nil { // 释放的 ID 不随周期作废
+		err = errors.New("reserve is not supported for periodically reset biz_tag")
+		return
+	}
+
+	table := &alloc.reservations
+	table.sweepOnce.Do(func() {
+		go alloc.sweepLoop(alloc.conf.Reserve.SweepInterval, alloc.reserveTTL(), alloc.sweepReservations)
+	})
+	reservation = Reservation{ReservationID: newToken(), BizTag: bizTag, Caller: caller}
+
+	table.mutex.Lock()
+	if ids := table.released[bizTag]; len(ids) != 0 {
+		reservation.ID = ids[0]
+		if table.released[bizTag] = ids[1:]; len(ids) == 1 {
+			delete(table.released, bizTag)
+		}
+	}
+	table.mutex.Unlock()
+
+	// 与 /alloc 相同, 跳过为0的ID
+	for reservation.ID == 0 {
+		if reservation.ID, err = alloc.NextId(ctx, bizTag); err != nil {
+			return Reservation{}, err
+		}
+	}
+
+	reservation.ReservedAt = alloc.now()
+	reservation.ExpireAt = reservation.ReservedAt.Add(alloc.reserveTTL())
+
+	table.mutex.Lock()
+	if table.pending == nil {
+		table.pending = map[string]*Reservation{}
+	}
+	entry := reservation
+	table.pending[reservation.ReservationID] = &entry
+	table.mutex.Unlock()
+	return
+}
+
+// ConfirmReservation 确认预留, 之后 ID 归调用方所有
+func (alloc *Alloc) ConfirmReservation(bizTag string, reservationID string) (reservation Reservation, err error) {
+	if reservation, err = alloc.takeReservation(bizTag, reservationID); err != nil {
+		return
+	}
+	atomic.AddInt64(&alloc.loadOrCreate(bizTag).metrics.reserveConfirmed, 1)
+	return
+}
+
+// ReleaseReservation 释放预留, ID 由之后的预留复用; 业务已保存的释放 ID 过多时作废
+func (alloc *Alloc) ReleaseReservation(bizTag string, reservationID string) (reservation Reservation, err error) {
+	if reservation, err = alloc.takeReservation(bizTag, reservationID); err != nil {
+		return
+	}
+
+	table := &alloc.reservations
+	table.mutex.Lock()
+	if table.released == nil {
+		table.released = map[string][]int64{}
+	}
+	full := len(table.released[bizTag]) >= maxReleasedIds
+	if !full {
+		table.released[bizTag] = append(table.released[bizTag], reservation.ID)
+	}
+	table.mutex.Unlock()
+
+	if full {
+		logger.Warn("released id burned, too many released ids", "biz_tag", bizTag, "id", reservation.ID, "max", maxReleasedIds)
+	}
+	atomic.AddInt64(&alloc.loadOrCreate(bizTag).metrics.reserveReleased, 1)
+	return
+}
+
+// discard 丢弃业务释放的 ID, 之后的预留重新从号段获取
+func (table *reservationTable) discard(bizTag string) {
+	table.mutex.Lock()
+	if ids := table.released[bizTag]; len(ids) != 0 {
+		logger.Info("released ids discarded", "biz_tag", bizTag, "count", len(ids))
+		delete(table.released, bizTag)
+	}
+	table.mutex.Unlock()
+}
+
+// takeReservation 删除并返回待确认的预留
+func (alloc *Alloc) takeReservation(bizTag string, reservationID string) (reservation Reservation, err error) {
+	table := &alloc.reservations
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	entry := table.pending[reservationID]
+	if entry == nil || entry.BizTag != bizTag {
+		return reservation, ErrReservationNotFound
+	}
+	delete(table.pending, reservationID)
+	return *entry, nil
+}
+
+// Reservations 返回待确认的预留, bizTag 为空表示全部业务
+func (alloc *Alloc) Reservations(bizTag string) (reservations []Reservation) {
+	table := &alloc.reservations
+	table.mutex.Lock()
+	for _, entry := range table.pending {
+		if bizTag == "" || entry.BizTag == bizTag {
+			reservations = append(reservations, *entry)
+		}
+	}
+	table.mutex.Unlock()
+
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].ReservedAt.Before(reservations[j].ReservedAt) })
+	return
+}
+
+// sweepReservations 删除过期的预留并记录日志, 过期的 ID 作废
+func (alloc *Alloc) sweepReservations() {
+	var (
+		now     = alloc.now()
+		expired []Reservation
+	)
+
+	table := &alloc.reservations
+	table.mutex.Lock()
+	for reservationID, entry := range table.pending {
+		if !now.Before(entry.ExpireAt) {
+			expired = append(expired, *entry)
+			delete(table.pending, reservationID)
+		}
+	}
+	table.mutex.Unlock()
+
+	for _, reservation := range expired {
+		atomic.AddInt64(&alloc.loadOrCreate(reservation.BizTag).metrics.reserveExpired, 1)
+		logger.Warn("reservation expired unconfirmed", "biz_tag", reservation.BizTag, "reservation_id", reservation.ReservationID,
+			"id", reservation.ID, "caller", reservation.Caller, "reserved_at", reservation.ReservedAt)
+	}
+}
+
+// handleReserve 处理预留、确认和释放的 HTTP 请求
+func handleReserve(w http.ResponseWriter, r *http.Request) {
+	serveReserve(DefaultAlloc, bizTagValidator, w, r)
+}
+
+// serveReserve 使用指定的分配器处理预留(/reserve)、确认(/reserve/confirm)和释放(/reserve/release)请求
+// 确认和释放需要携带 reservation_id
+func serveReserve(alloc *Alloc, rule *bizTagRule, w http.ResponseWriter, r *http.Request) {
+	var (
+		resp        = ReserveResponse{} // 响应数据
+		err         error               // 错误信息
+		bizTag      string              // 业务标签
+		reservation Reservation         // 预留
+	)
+
+	// 解析请求参数
+	if err = r.ParseForm(); err != nil {
+		err = paramError(err.Error())
+		goto RESP
+	}
+
+	// 获取并验证 biz_tag 参数
+	if bizTag = r.Form.Get("biz_tag"); bizTag == "" {
+		err = paramError("need biz_tag param")
+		goto RESP
+	}
+	if err = rule.validate(bizTag); err != nil {
+		goto RESP
+	}
+
+	switch r.URL.Path {
+	case "/reserve/confirm":
+		reservation, err = alloc.ConfirmReservation(bizTag, r.Form.Get("reservation_id"))
+	case "/reserve/release":
+		reservation, err = alloc.ReleaseReservation(bizTag, r.Form.Get("reservation_id"))
+	default:
+		reservation, err = alloc.Reserve(r.Context(), bizTag, callerName(r))
+	}
+	resp.ReservationID, resp.ID, resp.ExpireAt = reservation.ReservationID, reservation.ID, reservation.ExpireAt
+
+RESP:
+	// 设置响应信息和状态码, 错误映射与分配请求一致
+	if errors.Is(err, ErrReservationNotFound) {
+		resp.ErrNo, resp.Msg = ErrNoFailed, err.Error()
+		resp.Cause, resp.Retryable = CauseParam, false
+		w.WriteHeader(http.StatusNotFound)
+	} else if err != nil {
+		status, errNo, msg := allocFailure(r.Context(), bizTag, err)
+		resp.ErrNo = errNo
+		resp.Msg = msg
+		resp.Cause, resp.Retryable = errorCause(err, status, errNo)
+		w.WriteHeader(status)
+	} else {
+		resp.Msg = "success"
+	}
+
+	// 将响应数据编码为JSON并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes)
+	} else {
+		logger.Error("encode response failed", "code", CodeResponseEncode, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleAdminReservations 查询待确认的预留, 支持 biz_tag 参数
+func handleAdminReservations(w http.ResponseWriter, r *http.Request) {
+	resp := ReservationsResponse{Msg: "success", Reservations: DefaultAlloc.Reservations(r.URL.Query().Get("biz_tag"))}
+
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReserve(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc.clock = clock
+
+	first, err := alloc.Reserve(context.Background(), "test", "svc")
+	if err != nil || first.ID == 0 || first.ReservationID == "" {
+		t.Fatalf("Reserve = (%+v, %v)", first, err)
+	}
+	second, err := alloc.Reserve(context.Background(), "test", "svc")
+	if err != nil || second.ID == first.ID {
+		t.Fatalf("Reserve = (%+v, %v), want a different id", second, err)
+	}
+	if reservations := alloc.Reservations("test"); len(reservations) != 2 {
+		t.Fatalf("Reservations = %+v, want 2 pending", reservations)
+	}
+
+	// 确认后不能再释放, 其他业务不能确认
+	if _, err = alloc.ConfirmReservation("other", first.ReservationID); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("ConfirmReservation with another biz_tag err = %v, want ErrReservationNotFound", err)
+	}
+	if _, err = alloc.ConfirmReservation("test", first.ReservationID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alloc.ReleaseReservation("test", first.ReservationID); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("ReleaseReservation after confirm err = %v, want ErrReservationNotFound", err)
+	}
+
+	// 释放的 ID 由下一个预留复用
+	if _, err = alloc.ReleaseReservation("test", second.ReservationID); err != nil {
+		t.Fatal(err)
+	}
+	third, err := alloc.Reserve(context.Background(), "test", "svc")
+	if err != nil || third.ID != second.ID {
+		t.Fatalf("Reserve after release = (%+v, %v), want id %d", third, err, second.ID)
+	}
+
+	// 过期未确认的预留作废, 不再复用
+	clock.advance(alloc.reserveTTL())
+	alloc.sweepReservations()
+	if reservations := alloc.Reservations(""); len(reservations) != 0 {
+		t.Fatalf("Reservations = %+v after expiry, want none", reservations)
+	}
+	if _, err = alloc.ConfirmReservation("test", third.ReservationID); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("ConfirmReservation after expiry err = %v, want ErrReservationNotFound", err)
+	}
+	if fourth, err := alloc.Reserve(context.Background(), "test", "svc"); err != nil || fourth.ID == third.ID {
+		t.Fatalf("Reserve after expiry = (%+v, %v), want a new id", fourth, err)
+	}
+
+	metrics := alloc.loadOrCreate("test").metrics
+	if atomic.LoadInt64(&metrics.reserveConfirmed) != 1 || atomic.LoadInt64(&metrics.reserveReleased) != 1 || atomic.LoadInt64(&metrics.reserveExpired) != 1 {
+		t.Fatal("reservation metrics do not count one confirm, one release and one expiry")
+	}
+}
+
+func TestReserveReleased(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(10000))
+
+	release := func(n int) {
+		for i := 0; i < n; i++ {
+			reservation, err := alloc.Reserve(context.Background(), "test", "svc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = alloc.ReleaseReservation("test", reservation.ReservationID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	released := func() int {
+		alloc.reservations.mutex.Lock()
+		defer alloc.reservations.mutex.Unlock()
+		return len(alloc.reservations.released["test"])
+	}
+
+	// 反复预留和释放只复用同一个 ID
+	release(3)
+	if n := released(); n != 1 {
+		t.Fatalf("%d released ids after reusing, want 1", n)
+	}
+
+	// 同时释放的 ID 超过上限后作废
+	var pending []Reservation
+	for i := 0; i < maxReleasedIds+10; i++ {
+		reservation, err := alloc.Reserve(context.Background(), "test", "svc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, reservation)
+	}
+	for _, reservation := range pending {
+		if _, err := alloc.ReleaseReservation("test", reservation.ReservationID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := released(); n != maxReleasedIds {
+		t.Fatalf("%d released ids, want the %d limit", n, maxReleasedIds)
+	}
+
+	// 业务归档或不再存在时丢弃释放的 ID
+	alloc.forget("test")
+	if n := released(); n != 0 {
+		t.Fatalf("%d released ids after forget, want 0", n)
+	}
+	release(1)
+	alloc.dropUnknown(alloc.loadOrCreate("test"))
+	if n := released(); n != 0 {
+		t.Fatalf("%d released ids after drop, want 0", n)
+	}
+}
+
+func TestServeReserve(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(100))
+
+	serve := func(target string) (resp ReserveResponse, code int) {
+		w := httptest.NewRecorder()
+		serveReserve(alloc, nil, w, httptest.NewRequest(http.MethodGet, target, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: body %q", target, w.Body.String())
+		}
+		return resp, w.Code
+	}
+
+	reserved, code := serve("/reserve?biz_tag=test")
+	if code != http.StatusOK || reserved.ID == 0 || reserved.ReservationID == "" {
+		t.Fatalf("reserve: status %d, %+v", code, reserved)
+	}
+	if confirmed, code := serve("/reserve/confirm?biz_tag=test&reservation_id=" + reserved.ReservationID); code != http.StatusOK || confirmed.ID != reserved.ID {
+		t.Fatalf("confirm: status %d, %+v, want id %d", code, confirmed, reserved.ID)
+	}
+	if _, code = serve("/reserve/release?biz_tag=test&reservation_id=" + reserved.ReservationID); code != http.StatusNotFound {
+		t.Fatalf("release after confirm: status %d, want 404", code)
+	}
+}
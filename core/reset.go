@@ -0,0 +1,206 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+	启用周期重置时号段表需要 reset_at 列, 记录号段行当前所属周期的开始时间(毫秒时间戳):
+	ALTER TABLE `segments` ADD COLUMN `reset_at` bigint NOT NULL DEFAULT 0;
+*/
+
+// errPeriodPassed 号段池所属的周期已经结束, 号段行已被其他实例带入新的周期
+var errPeriodPassed = errors.New("reset period passed")
+
+// 重置周期
+const (
+	PeriodDaily   = "daily"   // 每天 0 点
+	PeriodMonthly = "monthly" // 每月 1 日 0 点
+	PeriodYearly  = "yearly"  // 每年 1 月 1 日 0 点
+)
+
+// ResetConfig 定义按周期重置序列的配置, 用于每天从 1 开始的票号、批次号等业务编号
+// 新周期中第一个获取号段的实例在同一个事务中将 max_id 重置, 号段行记录所属的周期, 其他实例不会重复重置, 也不会回到上一个周期
+// 重置的业务直接返回号段中的号码, 不叠加毫秒时间戳; 各实例的时钟偏差内, 时钟较慢的实例获取号段会失败, 直到它也进入新的周期
+type ResetConfig struct {
+	Tags map[string]TagResetConfig `json:"tags"` // 按业务标识配置的重置策略
+}
+
+// TagResetConfig 定义单个业务的重置策略
+type TagResetConfig struct {
+	Period   string `json:"period"`   // 重置周期: daily、monthly 或 yearly
+	Timezone string `json:"timezone"` // 计算周期使用的时区, 如 Asia/Shanghai, 为空时使用本地时区
+	ResetTo  int64  `json:"reset_to"` // 新周期开始时 max_id 重置到的值, 即新周期的第一个号码, 如 1
+}
+
+// resetPolicy 解析后的重置策略
+type resetPolicy struct {
+	period   string
+	location *time.Location
+	resetTo  int64
+}
+
+// resetPeriod 号段所属的周期, 通过 context 传给号段存储
+type resetPeriod struct {
+	start   int64 // 周期开始时间(毫秒时间戳), 号段行中的 reset_at
+	end     int64 // 周期结束时间(毫秒时间戳)
+	resetTo int64 // 进入该周期时 max_id 重置到的值
+}
+
+// resetPeriodKey context 中保存号段所属周期的键
+type resetPeriodKey struct{}
+
+// newResetPolicies 检查并解析重置策略
+func newResetPolicies(conf *Config) (policies map[string]*resetPolicy, err error) {
+	policies = map[string]*resetPolicy{}
+	for bizTag, tag := range conf.Reset.Tags {
+		policy := &resetPolicy{period: tag.Period, location: time.Local, resetTo: tag.ResetTo}
+		switch tag.Period {
+		case PeriodDaily, PeriodMonthly, PeriodYearly:
+		default:
+			return nil, fmt.Errorf("reset.tags[%q]: unsupported period %q", bizTag, tag.Period)
+		}
+		if conf.Schema == SchemaLeaf {
+			return nil, fmt.Errorf("reset.tags[%q]: leaf schema has no reset_at column", bizTag)
+		}
+		if tag.ResetTo < 0 {
+			return nil, fmt.Errorf("reset.tags[%q].reset_to %d must not be negative", bizTag, tag.ResetTo)
+		}
+		if tag.Timezone != "" {
+			if policy.location, err = time.LoadLocation(tag.Timezone); err != nil {
+				return nil, fmt.Errorf("reset.tags[%q]: %w", bizTag, err)
+			}
+		}
+		policies[bizTag] = policy
+	}
+	return
+}
+
+// current 返回 now 所在的周期
+func (policy *resetPolicy) current(now time.Time) resetPeriod {
+	var (
+		t          = now.In(policy.location)
+		start, end time.Time
+	)
+
+	switch policy.period {
+	case PeriodMonthly:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, policy.location)
+		end = start.AddDate(0, 1, 0)
+	case PeriodYearly:
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, policy.location)
+		end = start.AddDate(1, 0, 0)
+	default:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, policy.location)
+		end = start.AddDate(0, 0, 1)
+	}
+	return resetPeriod{start: start.UnixMilli(), end: end.UnixMilli(), resetTo: policy.resetTo}
+}
+
+// withResetPeriod 为获取号段的 context 附加号段池所属的周期, 不重置的业务原样返回
+func (bizAlloc *BizAlloc) withResetPeriod(ctx context.Context) context.Context {
+	if bizAlloc.period == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, resetPeriodKey{}, *bizAlloc.period)
+}
+
+// periodFrom 返回 context 中号段所属的周期
+func periodFrom(ctx context.Context) (period resetPeriod, ok bool) {
+	period, ok = ctx.Value(resetPeriodKey{}).(resetPeriod)
+	return
+}
+
+// advance 按周期前进 max_id: 号段行处于上一个周期时先重置, 已进入更新的周期时返回 errPeriodPassed
+func (period resetPeriod) advance(maxId int64, resetAt int64, step int64, multiple int64) (int64, error) {
+	switch {
+	case resetAt > period.start:
+		return 0, errPeriodPassed
+	case resetAt < period.start: // 新周期中第一次获取号段
+		maxId = period.resetTo
+	}
+	return maxId + step*multiple, nil
+}
+
+// loadPeriodic 查找重置业务当前周期的号段池, 周期结束时换成新的号段池, 上一个周期剩余的号码作废
+func (alloc *Alloc) loadPeriodic(bizTag string, policy *resetPolicy) *BizAlloc {
+	now := alloc.now()
+	for {
+		current := alloc.load(bizTag)
+		if current != nil && current.period != nil && now.UnixMilli() < current.period.end {
+			return current
+		}
+
+		period := policy.current(now)
+		fresh := alloc.newBizAlloc(bizTag)
+		fresh.period = &period
+		if current == nil {
+			if value, loaded := alloc.bizMap.LoadOrStore(bizTag, fresh); loaded {
+				fresh = value.(*BizAlloc)
+				if fresh.period == nil || now.UnixMilli() >= fresh.period.end {
+					continue
+				}
+			}
+			return fresh
+		}
+		if alloc.bizMap.CompareAndSwap(bizTag, current, fresh) {
+			logger.Info("sequence reset period started", "biz_tag", bizTag, "period_start", time.UnixMilli(period.start))
+			return fresh
+		}
+	}
+}
+
+// nextIdPeriod 在事务中锁定号段行, 号段行处于上一个周期时先重置 max_id 并记录新的周期, 再前进 multiple 个步长
+func (data *Data) nextIdPeriod(ctx context.Context, db *sql.DB, options *sql.TxOptions, table string, bizTag string, multiple int64, period resetPeriod, phases *queryPhases) (maxId int64, step int64, rowsAffected int64, err error) {
+	var (
+		tx      *sql.Tx    // 事务对象
+		result  sql.Result // SQL 执行结果
+		resetAt int64      // 号段行当前所属周期的开始时间
+		active  string     // 只更新未归档的号段行
+	)
+
+	if data.conf.Archive.Enable {
+		active = " AND archived = 0"
+	}
+
+	if tx, err = db.BeginTx(ctx, options); err != nil {
+		data.triggerProbe() // 连接失败, 尽快探测是否需要切换
+		return
+	}
+	phases.mark("begin")
+
+	// STEP 1: 锁定号段行并读取当前的 max_id、step 和所属周期
+	if err = tx.QueryRowContext(ctx, "SELECT max_id, step, reset_at FROM "+table+" WHERE biz_tag = ?"+active+" FOR UPDATE", bizTag).Scan(&maxId, &step, &resetAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) { // 业务不存在或已归档
+			err = data.missing(ctx, tx, table, bizTag)
+		}
+		goto ROLLBACK
+	}
+	phases.mark("select")
+
+	// STEP 2: 按周期前进 max_id 并记录所属周期
+	if maxId, err = period.advance(maxId, resetAt, step, multiple); err != nil {
+		goto ROLLBACK
+	}
+	if result, err = tx.ExecContext(ctx, "UPDATE "+table+" SET max_id = ?, reset_at = ? WHERE biz_tag = ?", maxId, period.start, bizTag); err != nil {
+		goto ROLLBACK
+	}
+	if rowsAffected, err = result.RowsAffected(); err != nil {
+		goto ROLLBACK
+	}
+	phases.mark("update")
+
+	// STEP 3: 提交事务
+	err = tx.Commit()
+	phases.mark("commit")
+	return
+
+ROLLBACK:
+	tx.Rollback()
+	phases.mark("rollback")
+	return
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewResetPolicies(t *testing.T) {
+	for _, conf := range []Config{
+		{Reset: ResetConfig{Tags: map[string]TagResetConfig{"ticket": {Period: "weekly"}}}},
+		{Reset: ResetConfig{Tags: map[string]TagResetConfig{"ticket": {Period: PeriodDaily, ResetTo: -1}}}},
+		{Reset: ResetConfig{Tags: map[string]TagResetConfig{"ticket": {Period: PeriodDaily, Timezone: "Nowhere/City"}}}},
+		{Schema: SchemaLeaf, Reset: ResetConfig{Tags: map[string]TagResetConfig{"ticket": {Period: PeriodDaily}}}},
+	} {
+		if _, err := newResetPolicies(&conf); err == nil {
+			t.Errorf("newResetPolicies accepted %+v", conf.Reset)
+		}
+	}
+}
+
+func TestResetPeriod(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2026, 3, 31, 23, 30, 0, 0, time.UTC) // 上海时间 4 月 1 日 7:30
+
+	for _, c := range []struct {
+		period     string
+		start, end time.Time
+	}{
+		{PeriodDaily, time.Date(2026, 4, 1, 0, 0, 0, 0, shanghai), time.Date(2026, 4, 2, 0, 0, 0, 0, shanghai)},
+		{PeriodMonthly, time.Date(2026, 4, 1, 0, 0, 0, 0, shanghai), time.Date(2026, 5, 1, 0, 0, 0, 0, shanghai)},
+		{PeriodYearly, time.Date(2026, 1, 1, 0, 0, 0, 0, shanghai), time.Date(2027, 1, 1, 0, 0, 0, 0, shanghai)},
+	} {
+		period := (&resetPolicy{period: c.period, location: shanghai, resetTo: 1}).current(now)
+		if period.start != c.start.UnixMilli() || period.end != c.end.UnixMilli() {
+			t.Errorf("%s period = [%v, %v), want [%v, %v)", c.period,
+				time.UnixMilli(period.start).In(shanghai), time.UnixMilli(period.end).In(shanghai), c.start, c.end)
+		}
+	}
+
+	period := resetPeriod{start: 1000, end: 2000, resetTo: 1}
+	for _, c := range []struct {
+		maxId, resetAt, want int64
+		err                  error
+	}{
+		{500, 0, 101, nil},    // 新周期中第一次获取号段, 先重置
+		{500, 1000, 600, nil}, // 同一周期内继续前进
+		{500, 2000, 0, errPeriodPassed},
+	} {
+		if maxId, err := period.advance(c.maxId, c.resetAt, 100, 1); maxId != c.want || !errors.Is(err, c.err) {
+			t.Errorf("advance(%d, %d) = (%d, %v), want (%d, %v)", c.maxId, c.resetAt, maxId, err, c.want, c.err)
+		}
+	}
+}
+
+func TestAllocReset(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Reset.Tags = map[string]TagResetConfig{"ticket": {Period: PeriodDaily, Timezone: "UTC", ResetTo: 1}}
+	store, err := openFileStore(FileStoreConfig{Path: filepath.Join(t.TempDir(), "segments.json"), Step: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	clock := newFakeClock(time.Date(2026, 10, 16, 23, 59, 0, 0, time.UTC))
+	alloc := newTestAlloc(t, store)
+	alloc.clock = clock
+
+	// 重置的业务不叠加时间戳, 每天从 1 开始
+	for want := int64(1); want <= 3; want++ {
+		if id, err := alloc.NextId(context.Background(), "ticket"); err != nil || id != want {
+			t.Fatalf("NextId = (%d, %v), want (%d, nil)", id, err, want)
+		}
+	}
+	first := alloc.load("ticket")
+
+	clock.advance(time.Minute)
+	if id, err := alloc.NextId(context.Background(), "ticket"); err != nil || id != 1 {
+		t.Fatalf("NextId on the next day = (%d, %v), want (1, nil)", id, err)
+	}
+	if alloc.load("ticket") == first {
+		t.Fatal("pool of the previous period was not replaced")
+	}
+
+	// 号段行已进入更新的周期时, 时钟较慢的实例不会回到上一个周期
+	ctx := first.withResetPeriod(context.Background())
+	if _, _, err = store.NextId(ctx, "ticket", 1); !errors.Is(err, errPeriodPassed) {
+		t.Fatalf("NextId of a passed period err = %v, want errPeriodPassed", err)
+	}
+
+	// 租约不随周期作废, 不允许租出
+	if _, err = alloc.Lease(context.Background(), "ticket", "test", "127.0.0.1"); err == nil {
+		t.Fatal("leased a periodically reset biz_tag")
+	}
+}
+
+func TestReserveReset(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Reset.Tags = map[string]TagResetConfig{"ticket": {Period: PeriodDaily, Timezone: "UTC", ResetTo: 1}}
+	store, err := openFileStore(FileStoreConfig{Path: filepath.Join(t.TempDir(), "segments.json"), Step: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	clock := newFakeClock(time.Date(2026, 10, 16, 23, 59, 0, 0, time.UTC))
+	alloc := newTestAlloc(t, store)
+	alloc.clock = clock
+
+	// 释放的 ID 会在下一个周期与重新开始的号码重复, 重置前后都不允许预留
+	seen := map[int64]bool{}
+	for day := 0; day < 2; day++ {
+		if reservation, err := alloc.Reserve(context.Background(), "ticket", "test"); err == nil {
+			t.Fatalf("day %d: reserved %+v of a periodically reset biz_tag", day, reservation)
+		}
+		for i := 0; i < 3; i++ {
+			id, err := alloc.NextId(context.Background(), "ticket")
+			if err != nil || seen[id] != (day == 1) {
+				t.Fatalf("day %d: NextId = (%d, %v), want a sequence restarting from 1", day, id, err)
+			}
+			seen[id] = true
+		}
+		clock.advance(time.Minute)
+	}
+	if len(alloc.Reservations("")) != 0 {
+		t.Fatalf("Reservations = %+v, want none", alloc.Reservations(""))
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"errors"
+)
+
+/*
+	美团 Leaf 的号段表, schema 为 leaf 时可直接使用, 不必转换表结构:
+
+	CREATE TABLE `leaf_alloc` (
+	 `biz_tag` varchar(128) NOT NULL DEFAULT '',
+	 `max_id` bigint(20) NOT NULL DEFAULT '1',
+	 `step` int(11) NOT NULL,
+	 `description` varchar(256) DEFAULT NULL,
+	 `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	 PRIMARY KEY (`biz_tag`)
+	) ENGINE=InnoDB;
+
+	两者的号段语义相同: max_id 前进一个步长后, 号段为 [max_id - step, max_id)
+	迁移期间 Java Leaf 和本服务可以同时使用同一张表, 各自通过更新 max_id 获取互不重叠的号段
+	leaf_alloc 的 biz_tag 最长 128 个字符, 迁移时需将 biz_tag.max_length 调整为 128
+*/
+
+// 号段表的表结构
+const (
+	SchemaSegments = "segments" // 本服务的号段表, 见 data.go
+	SchemaLeaf     = "leaf"     // 美团 Leaf 的 leaf_alloc 表
+)
+
+// leafDescriptionLength leaf_alloc 表 description 列的长度
+const leafDescriptionLength = 256
+
+// checkSchema 检查号段表结构配置
+func checkSchema(conf *Config) error {
+	switch conf.Schema {
+	case "", SchemaSegments, SchemaLeaf:
+		return nil
+	default:
+		return errors.New("unsupported schema: " + conf.Schema)
+	}
+}
+
+// descriptionColumn 返回查询业务描述的列, leaf_alloc 的 description 可以为 NULL
+func descriptionColumn(conf *Config) string {
+	if conf.Schema == SchemaLeaf {
+		return "COALESCE(description, '')"
+	}
+	return "description"
+}
+
+// descriptionLength 返回业务描述的最大字符数, 与号段表 description 列的长度一致
+func descriptionLength(conf *Config) int {
+	if conf.Schema == SchemaLeaf {
+		return leafDescriptionLength
+	}
+	return maxDescriptionLength
+}
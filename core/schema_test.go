@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	for _, schema := range []string{"", SchemaSegments, SchemaLeaf} {
+		if err := checkSchema(&Config{Schema: schema}); err != nil {
+			t.Errorf("checkSchema(%q) = %v", schema, err)
+		}
+	}
+	if err := checkSchema(&Config{Schema: "leaf_alloc"}); err == nil {
+		t.Fatal("checkSchema accepted an unknown schema")
+	}
+	if err := checkWithoutMySQL(&Config{Schema: SchemaLeaf}); err == nil {
+		t.Fatal("checkWithoutMySQL accepted the leaf schema with the file store")
+	}
+}
+
+func TestLeafSchemaColumns(t *testing.T) {
+	segments, leaf := &Config{}, &Config{Schema: SchemaLeaf}
+	if descriptionColumn(segments) != "description" || descriptionLength(segments) != maxDescriptionLength {
+		t.Fatal("segments schema description changed")
+	}
+	// leaf_alloc 的 description 可以为 NULL, 长度为 256
+	if descriptionColumn(leaf) != "COALESCE(description, '')" || descriptionLength(leaf) != leafDescriptionLength {
+		t.Fatal("leaf schema description not adapted")
+	}
+}
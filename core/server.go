@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backlogOnce 多个端口只检查一次监听队列长度
+var backlogOnce sync.Once
+
+// unixPrefix 监听地址中 Unix 套接字的前缀, 如 unix:/run/leaf/alloc.sock
+const unixPrefix = "unix:"
+
+// listen 监听一个地址: host:port 按配置的 TCP keep-alive 监听, unix: 前缀表示 Unix 套接字, 所有对外的 HTTP 端口共用
+func listen(addr string) (net.Listener, error) {
+	var (
+		lc = net.ListenConfig{KeepAlive: time.Duration(DefaultConfig.HttpTCPKeepAlive) * time.Millisecond} // 0 为默认 15 秒, 负数表示不启用
+	)
+
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		// 上次异常退出时遗留的套接字文件会导致监听失败, 只删除套接字, 不删除同名的普通文件
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+		return lc.Listen(context.Background(), "unix", path)
+	}
+	backlogOnce.Do(checkBacklog)
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenAll 监听配置的地址列表, 列表为空时在所有网卡上监听 port; 任一地址监听失败时关闭已监听的地址
+func listenAll(addrs []string, port int) (listeners []net.Listener, err error) {
+	if len(addrs) == 0 {
+		addrs = []string{":" + strconv.Itoa(port)}
+	}
+	for _, addr := range addrs {
+		var listener net.Listener
+		if listener, err = listen(addr); err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return
+}
+
+// checkListenAddrs 检查监听地址的格式, name 为配置项名称
+func checkListenAddrs(name string, addrs []string) error {
+	for _, addr := range addrs {
+		if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+			if path == "" {
+				return fmt.Errorf("%s: empty unix socket path", name)
+			}
+			continue
+		}
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		} else if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("%s: invalid port in %q", name, addr)
+		}
+	}
+	return nil
+}
+
+// checkListen 检查分配端口、高性能端口和管理端口的监听地址
+func checkListen(conf *Config) error {
+	if err := checkListenAddrs("http_listen", conf.HttpListen); err != nil {
+		return err
+	}
+	if err := checkListenAddrs("fast.listen", conf.Fast.Listen); err != nil {
+		return err
+	}
+	return checkListenAddrs("admin.listen", conf.Admin.Listen)
+}
+
+// serveAll 在每个监听上提供服务, 服务异常退出时的错误写入 errChan; 关闭服务器即关闭所有监听
+func serveAll(srv *http.Server, listeners []net.Listener, errChan chan<- error) {
+	for _, listener := range listeners {
+		go func() {
+			if err := srv.Serve(listener); err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
+	}
+}
+
+// closeAll 关闭监听, 用于启动失败时的清理
+func closeAll(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// listenerAddrs 返回监听地址列表, 用于日志
+func listenerAddrs(listeners []net.Listener) string {
+	addrs := make([]string, 0, len(listeners))
+	for _, listener := range listeners {
+		addrs = append(addrs, listener.Addr().String())
+	}
+	return strings.Join(addrs, ",")
+}
+
+// tuneServer 将超时、请求头和 keep-alive 配置应用到 HTTP 服务器
+func tuneServer(srv *http.Server) {
+	srv.IdleTimeout = time.Duration(DefaultConfig.HttpIdleTimeout) * time.Millisecond
+	srv.ReadHeaderTimeout = time.Duration(DefaultConfig.HttpReadHeaderTimeout) * time.Millisecond
+	if srv.MaxHeaderBytes == 0 {
+		srv.MaxHeaderBytes = DefaultConfig.HttpMaxHeaderBytes
+	}
+	srv.SetKeepAlivesEnabled(!DefaultConfig.HttpDisableKeepAlive)
+}
+
+// checkBacklog 监听队列长度由内核的 somaxconn 决定, Go 无法单独指定,
+// 配置了期望的长度时检查系统设置, 不足则提示运维调整
+func checkBacklog() {
+	var (
+		want = DefaultConfig.HttpListenBacklog
+	)
+
+	if want <= 0 {
+		return
+	}
+	content, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return // 非 Linux 系统
+	}
+	if somaxconn, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil && somaxconn < want {
+		logger.Warn("listen backlog limited by somaxconn, raise net.core.somaxconn",
+			"somaxconn", somaxconn, "http_listen_backlog", want)
+	}
+}
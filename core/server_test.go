@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenAll(t *testing.T) {
+	setupTestConfig(t)
+	dir, err := os.MkdirTemp("", "leaf") // Unix 套接字路径长度有限, 不使用较长的测试目录
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "alloc.sock")
+
+	// 遗留的套接字文件不影响监听
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := listenAll([]string{"127.0.0.1:0", unixPrefix + socket}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, _ := newIPFilter(IPRules{Allow: []string{"10.0.0.0/8"}})
+	srv := &http.Server{Handler: withIPFilter(filter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))}
+	errChan := make(chan error, len(listeners))
+	serveAll(srv, listeners, errChan)
+
+	// TCP 来源地址不在允许列表中, Unix 套接字不按地址过滤
+	resp, err := http.Get("http://" + listeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("tcp status = %d, want 403", resp.StatusCode)
+	}
+	unix := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	if resp, err = unix.Get("http://leaf/"); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("unix response = %d %q, want 200 ok", resp.StatusCode, body)
+	}
+
+	// 关闭服务器即关闭所有监听, 并删除套接字文件
+	if err = srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed: %v", err)
+	}
+	select {
+	case err = <-errChan:
+		t.Fatalf("serve error: %v", err)
+	default:
+	}
+
+	// 任一地址监听失败时已监听的地址被关闭
+	if _, err = listenAll([]string{"127.0.0.1:0", "256.0.0.1:0"}, 0); err == nil {
+		t.Fatal("invalid address listened")
+	}
+}
+
+func TestCheckListenAddrs(t *testing.T) {
+	for _, addr := range []string{"8880", "localhost:http", "unix:", ":70000"} {
+		if err := checkListenAddrs("http_listen", []string{addr}); err == nil {
+			t.Fatalf("%q accepted", addr)
+		}
+	}
+	if err := checkListenAddrs("http_listen", []string{":8880", "127.0.0.1:8881", "[::1]:8882", "unix:/run/leaf.sock"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ShardingConfig 定义号段表分表的配置
+// 业务达到数万个时, 将号段行分散到多张结构相同的号段表, 单张表和它的锁不再限制吞吐
+// 分表规则一旦对外发号就不能修改, 否则业务会映射到另一张表中的号段行, 造成 ID 重复; 号段行需在对应的表中预先创建
+type ShardingConfig struct {
+	Type      string            `json:"type"`      // 分表规则: 空表示不分表, hash 按号段行的业务标识哈希, namespace 按业务标识的命名空间前缀
+	Count     int               `json:"count"`     // hash 规则的分表数量, 表名为 {table}_0 ~ {table}_{count-1}
+	Separator string            `json:"separator"` // namespace 规则中命名空间与业务名之间的分隔符, 默认 :
+	Tables    map[string]string `json:"tables"`    // namespace 规则中命名空间到表名的映射, 未匹配的业务使用 table
+}
+
+// ShardResponse 用于封装号段表查询请求的响应
+type ShardResponse struct {
+	ErrNo  int    `json:"err_no"`  // 错误码
+	Msg    string `json:"msg"`     // 错误或成功消息
+	BizTag string `json:"biz_tag"` // 号段行的业务标识
+	Table  string `json:"table"`   // 号段行所在的表
+}
+
+// tableNamePattern 号段表名称, 表名拼接在 SQL 中, 只允许标识符字符
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z0-9_$.]+$`)
+
+// checkSharding 检查分表配置
+func checkSharding(conf *Config) error {
+	sharding := conf.Sharding
+	switch sharding.Type {
+	case "":
+	case "hash":
+		if sharding.Count < 1 {
+			return fmt.Errorf("sharding.count %d must be positive", sharding.Count)
+		}
+	case "namespace":
+		if sharding.Separator == "" {
+			return errors.New("sharding.separator must not be empty")
+		}
+		if len(sharding.Tables) == 0 {
+			return errors.New("sharding.tables must not be empty")
+		}
+		for namespace, table := range sharding.Tables {
+			if !tableNamePattern.MatchString(table) {
+				return fmt.Errorf("sharding.tables[%q] %q is invalid", namespace, table)
+			}
+		}
+	default:
+		return errors.New("unsupported sharding.type: " + sharding.Type)
+	}
+	return nil
+}
+
+// table 返回号段行所在的表, base 为配置的号段表
+// 启用分区时 bizTag 为带分区后缀的号段行, 同一业务的各分区行可能位于不同的表
+func (sharding ShardingConfig) table(base string, bizTag string) string {
+	switch sharding.Type {
+	case "hash":
+		h := fnv.New32a()
+		h.Write([]byte(bizTag))
+		return base + "_" + strconv.Itoa(int(h.Sum32()%uint32(sharding.Count)))
+	case "namespace":
+		if namespace, _, ok := strings.Cut(bizTag, sharding.Separator); ok {
+			if table, ok := sharding.Tables[namespace]; ok {
+				return table
+			}
+		}
+	}
+	return base
+}
+
+// tables 返回规则可能用到的全部号段表, 用于跨表查询
+func (sharding ShardingConfig) tables(base string) []string {
+	switch sharding.Type {
+	case "hash":
+		result := make([]string, 0, sharding.Count)
+		for i := 0; i < sharding.Count; i++ {
+			result = append(result, base+"_"+strconv.Itoa(i))
+		}
+		return result
+	case "namespace":
+		result := []string{base}
+		for _, table := range sharding.Tables {
+			if !slices.Contains(result, table) {
+				result = append(result, table)
+			}
+		}
+		slices.Sort(result[1:])
+		return result
+	}
+	return []string{base}
+}
+
+// handleAdminShard 查询号段行所在的表, 供创建业务时确定插入哪张表
+func handleAdminShard(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp = ShardResponse{BizTag: r.URL.Query().Get("biz_tag")} // 响应数据
+	)
+
+	if resp.BizTag == "" {
+		writeError(w, http.StatusBadRequest, ErrNoFailed, "biz_tag is required")
+		return
+	}
+	resp.Msg = "success"
+	resp.Table = DefaultConfig.Sharding.table(DefaultConfig.Table, resp.BizTag)
+
+	// 将响应数据编码为 JSON 并写入响应
+	w.Header().Set("Content-Type", "application/json")
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
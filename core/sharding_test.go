@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckSharding(t *testing.T) {
+	for name, sharding := range map[string]ShardingConfig{
+		"unknown type":  {Type: "range"},
+		"hash count":    {Type: "hash"},
+		"no tables":     {Type: "namespace", Separator: ":"},
+		"no separator":  {Type: "namespace", Tables: map[string]string{"order": "segments_order"}},
+		"invalid table": {Type: "namespace", Separator: ":", Tables: map[string]string{"order": "segments; drop"}},
+	} {
+		if err := checkSharding(&Config{Sharding: sharding}); err == nil {
+			t.Errorf("%s: checkSharding accepted %+v", name, sharding)
+		}
+	}
+	if err := checkSharding(&Config{Sharding: ShardingConfig{Type: "hash", Count: 16}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWithoutMySQL(&Config{Sharding: ShardingConfig{Type: "hash", Count: 16}}); err == nil {
+		t.Fatal("checkWithoutMySQL accepted sharding with the file store")
+	}
+}
+
+func TestShardingTable(t *testing.T) {
+	// 哈希规则稳定且分散到全部表
+	hash := ShardingConfig{Type: "hash", Count: 4}
+	seen := map[string]bool{}
+	for _, bizTag := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "order", "user", "pay", "test"} {
+		table := hash.table("segments", bizTag)
+		if table != hash.table("segments", bizTag) {
+			t.Fatalf("table(%q) is not stable", bizTag)
+		}
+		seen[table] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("tables used = %v, want all 4", seen)
+	}
+
+	// 命名空间规则, 未匹配的业务使用基础表
+	namespace := ShardingConfig{Type: "namespace", Separator: ":", Tables: map[string]string{"order": "segments_order"}}
+	for bizTag, want := range map[string]string{"order:pay": "segments_order", "user:id": "segments", "order": "segments"} {
+		if table := namespace.table("segments", bizTag); table != want {
+			t.Errorf("table(%q) = %q, want %q", bizTag, table, want)
+		}
+	}
+	if table := (ShardingConfig{}).table("segments", "order:pay"); table != "segments" {
+		t.Fatalf("table without sharding = %q", table)
+	}
+}
+
+func TestShardingTables(t *testing.T) {
+	for _, tc := range []struct {
+		sharding ShardingConfig
+		want     string
+	}{
+		{ShardingConfig{}, "segments"},
+		{ShardingConfig{Type: "hash", Count: 3}, "segments_0,segments_1,segments_2"},
+		{ShardingConfig{Type: "namespace", Tables: map[string]string{"pay": "seg_pay", "order": "seg_order", "refund": "seg_pay"}}, "segments,seg_order,seg_pay"},
+	} {
+		if got := strings.Join(tc.sharding.tables("segments"), ","); got != tc.want {
+			t.Errorf("tables(%+v) = %s, want %s", tc.sharding, got, tc.want)
+		}
+	}
+}
+
+func TestAdminShard(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Table = "segments"
+	DefaultConfig.Sharding = ShardingConfig{Type: "namespace", Separator: ":", Tables: map[string]string{"order": "segments_order"}}
+
+	w := httptest.NewRecorder()
+	handleAdminShard(w, httptest.NewRequest(http.MethodGet, "/admin/shard?biz_tag=order:pay", nil))
+	var resp ShardResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Table != "segments_order" {
+		t.Fatalf("shard = (%+v, %v), want segments_order", resp, err)
+	}
+
+	w = httptest.NewRecorder()
+	handleAdminShard(w, httptest.NewRequest(http.MethodGet, "/admin/shard", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("shard without biz_tag = %d, want 400", w.Code)
+	}
+}
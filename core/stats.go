@@ -0,0 +1,244 @@
+package core
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsConfig 定义 /stats 接口的配置
+// /stats 以 JSON 输出各业务最近 1 分钟和 5 分钟的分配速率、错误率、请求耗时和号段获取耗时的分位数以及等待队列长度, 便于没有接入 Prometheus 时快速查看
+type StatsConfig struct {
+	Enable bool `json:"enable"` // 是否统计滑动窗口并提供 /stats 接口
+}
+
+const (
+	statsSlotSeconds = 10                     // 每个统计槽覆盖的秒数
+	statsSlots       = 30                     // 统计槽个数, 共覆盖 5 分钟
+	latencyBuckets   = 56                     // 耗时分布的桶数, 每 2 倍分 2 个桶, 从 1 微秒到约 4.5 分钟
+	latencyScale     = 2                      // 每 2 倍的桶数
+	statsMinute      = 60 / statsSlotSeconds  // 1 分钟窗口的槽数
+	statsFiveMinutes = 300 / statsSlotSeconds // 5 分钟窗口的槽数
+)
+
+// latencyDist 耗时分布, 桶按对数划分, 所有字段原子更新
+type latencyDist [latencyBuckets]int64
+
+// observe 记录一次耗时
+func (dist *latencyDist) observe(elapsed time.Duration) {
+	idx := 0
+	if us := float64(elapsed) / float64(time.Microsecond); us > 1 {
+		idx = min(int(math.Log2(us)*latencyScale)+1, latencyBuckets-1)
+	}
+	atomic.AddInt64(&dist[idx], 1)
+}
+
+// quantile 返回分位数 q 所在桶的上界（毫秒）, 没有观测时返回 0
+func (dist *latencyDist) quantile(q float64) float64 {
+	var total, seen int64
+	for i := range dist {
+		total += dist[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(total)))
+	for i := range dist {
+		if seen += dist[i]; seen >= rank {
+			return math.Pow(2, float64(i)/latencyScale) / 1000
+		}
+	}
+	return math.Pow(2, float64(latencyBuckets-1)/latencyScale) / 1000
+}
+
+// statsSlot 一个统计槽内的计数
+type statsSlot struct {
+	epoch      int64       // 槽对应的时间段编号(秒数/statsSlotSeconds), 原子读写
+	allocs     int64       // 成功分配的ID数
+	errors     int64       // 分配失败次数
+	fetches    int64       // 获取号段次数
+	maxWaiting int64       // 等待补偿线程的最大请求数
+	latency    latencyDist // 分配请求耗时
+	fetch      latencyDist // 号段获取耗时
+}
+
+// slidingStats 单个业务的滑动窗口统计, 由环形的统计槽组成, 记录时只在进入新的时间段时加锁清零
+type slidingStats struct {
+	mutex sync.Mutex
+	slots [statsSlots]statsSlot
+}
+
+// slot 返回 now 所在时间段的统计槽, 槽中是更早的时间段时先清零
+func (stats *slidingStats) slot(now time.Time) *statsSlot {
+	epoch := now.Unix() / statsSlotSeconds
+	slot := &stats.slots[epoch%statsSlots]
+	if atomic.LoadInt64(&slot.epoch) == epoch {
+		return slot
+	}
+
+	stats.mutex.Lock()
+	if atomic.LoadInt64(&slot.epoch) != epoch {
+		slot.reset()
+		atomic.StoreInt64(&slot.epoch, epoch)
+	}
+	stats.mutex.Unlock()
+	return slot
+}
+
+// reset 清零统计槽, 与并发的记录和汇总一样使用原子操作
+func (slot *statsSlot) reset() {
+	for _, counter := range []*int64{&slot.allocs, &slot.errors, &slot.fetches, &slot.maxWaiting} {
+		atomic.StoreInt64(counter, 0)
+	}
+	for i := range slot.latency {
+		atomic.StoreInt64(&slot.latency[i], 0)
+		atomic.StoreInt64(&slot.fetch[i], 0)
+	}
+}
+
+// recordAlloc 记录一次分配的结果
+func (alloc *Alloc) recordAlloc(bizAlloc *BizAlloc, err error) {
+	if !alloc.conf.Stats.Enable {
+		return
+	}
+	slot := bizAlloc.metrics.window.slot(alloc.now())
+	if err != nil {
+		atomic.AddInt64(&slot.errors, 1)
+	} else {
+		atomic.AddInt64(&slot.allocs, 1)
+	}
+}
+
+// recordLatency 记录一次分配请求的处理耗时, 业务没有号段池时不记录
+func (alloc *Alloc) recordLatency(bizTag string, startTime time.Time) {
+	if !alloc.conf.Stats.Enable {
+		return
+	}
+	if bizAlloc := alloc.load(bizTag); bizAlloc != nil {
+		bizAlloc.metrics.window.slot(alloc.now()).latency.observe(alloc.since(startTime))
+	}
+}
+
+// recordFetch 记录一次号段获取的耗时
+func (alloc *Alloc) recordFetch(bizAlloc *BizAlloc, elapsed time.Duration) {
+	if !alloc.conf.Stats.Enable {
+		return
+	}
+	slot := bizAlloc.metrics.window.slot(alloc.now())
+	atomic.AddInt64(&slot.fetches, 1)
+	slot.fetch.observe(elapsed)
+}
+
+// recordWaiting 记录等待补偿线程的请求数, 调用方持有号段池的锁
+func (alloc *Alloc) recordWaiting(bizAlloc *BizAlloc) {
+	if !alloc.conf.Stats.Enable {
+		return
+	}
+	slot := bizAlloc.metrics.window.slot(alloc.now())
+	waiting := int64(len(bizAlloc.waiting))
+	for {
+		current := atomic.LoadInt64(&slot.maxWaiting)
+		if waiting <= current || atomic.CompareAndSwapInt64(&slot.maxWaiting, current, waiting) {
+			return
+		}
+	}
+}
+
+// LatencySummary 耗时分位数（毫秒）, 取分位数所在对数桶的上界, 最多偏大约 1.4 倍
+type LatencySummary struct {
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+}
+
+// WindowStats 单个业务在一个滑动窗口内的统计
+type WindowStats struct {
+	Allocs       int64          `json:"allocs"`        // 成功分配的ID数
+	Rate         float64        `json:"rate"`          // 每秒成功分配的ID数
+	Errors       int64          `json:"errors"`        // 分配失败次数
+	ErrorRate    float64        `json:"error_rate"`    // 失败次数占分配请求的比例
+	Latency      LatencySummary `json:"latency"`       // 分配请求的处理耗时
+	Fetches      int64          `json:"fetches"`       // 获取号段次数
+	FetchLatency LatencySummary `json:"fetch_latency"` // 号段获取耗时
+	MaxWaiting   int64          `json:"max_waiting"`   // 等待补偿线程的最大请求数
+}
+
+// BizStats /stats 中单个业务的统计
+type BizStats struct {
+	Waiting     int         `json:"waiting"`                   // 当前等待补偿线程的请求数
+	Remaining   int64       `json:"remaining"`                 // 内存中剩余可分配的号码数
+	LastMinute  WindowStats `json:"1m" graphql:"last_minute"`  // 最近 1 分钟
+	FiveMinutes WindowStats `json:"5m" graphql:"five_minutes"` // 最近 5 分钟
+}
+
+// window 汇总截至 now 最近 n 个时间段的统计, 当前时间段只计入已经过去的部分
+func (stats *slidingStats) window(now time.Time, n int64) (result WindowStats) {
+	var (
+		current          = now.Unix() / statsSlotSeconds
+		latency, fetches latencyDist
+		seconds          = float64((n-1)*statsSlotSeconds) + now.Sub(time.Unix(current*statsSlotSeconds, 0)).Seconds()
+	)
+
+	for epoch := current - n + 1; epoch <= current; epoch++ {
+		slot := &stats.slots[epoch%statsSlots]
+		if atomic.LoadInt64(&slot.epoch) != epoch {
+			continue
+		}
+		result.Allocs += atomic.LoadInt64(&slot.allocs)
+		result.Errors += atomic.LoadInt64(&slot.errors)
+		result.Fetches += atomic.LoadInt64(&slot.fetches)
+		result.MaxWaiting = max(result.MaxWaiting, atomic.LoadInt64(&slot.maxWaiting))
+		for i := range latency {
+			latency[i] += atomic.LoadInt64(&slot.latency[i])
+			fetches[i] += atomic.LoadInt64(&slot.fetch[i])
+		}
+	}
+
+	if seconds > 0 {
+		result.Rate = float64(result.Allocs) / seconds
+	}
+	if total := result.Allocs + result.Errors; total > 0 {
+		result.ErrorRate = float64(result.Errors) / float64(total)
+	}
+	result.Latency = LatencySummary{P50: latency.quantile(0.5), P95: latency.quantile(0.95), P99: latency.quantile(0.99)}
+	result.FetchLatency = LatencySummary{P50: fetches.quantile(0.5), P95: fetches.quantile(0.95), P99: fetches.quantile(0.99)}
+	return
+}
+
+// Stats 汇总各业务最近 1 分钟和 5 分钟的统计
+func (alloc *Alloc) Stats() map[string]BizStats {
+	var (
+		now    = alloc.now()
+		result = map[string]BizStats{}
+	)
+
+	for _, g := range alloc.gauges() {
+		result[g.bizTag] = BizStats{
+			Waiting:     g.waiting,
+			Remaining:   g.remaining,
+			LastMinute:  g.metrics.window.window(now, statsMinute),
+			FiveMinutes: g.metrics.window.window(now, statsFiveMinutes),
+		}
+	}
+	return result
+}
+
+// handleStats 处理 /stats 请求
+// 分配端口上与 /alloc 一样经过认证和命名空间解析, 调用方只能看到有权访问的业务, 管理员可以看到全部业务
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := DefaultAlloc.Stats()
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok && !p.admin {
+		maps.DeleteFunc(stats, func(bizTag string, _ BizStats) bool { return !p.allow(bizTag) })
+	}
+	bytes, err := json.Marshal(map[string]any{"biz": stats})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bytes)
+}
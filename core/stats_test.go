@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyQuantile(t *testing.T) {
+	var dist latencyDist
+	for i := 0; i < 90; i++ {
+		dist.observe(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		dist.observe(100 * time.Millisecond)
+	}
+
+	// 取所在桶的上界, 不小于真实值且不超过 1.42 倍
+	for _, c := range []struct {
+		q    float64
+		want float64
+	}{{0.5, 1}, {0.9, 1}, {0.99, 100}} {
+		if got := dist.quantile(c.q); got < c.want || got > c.want*1.42 {
+			t.Errorf("quantile(%v) = %v ms, want about %v ms", c.q, got, c.want)
+		}
+	}
+	if got := (&latencyDist{}).quantile(0.5); got != 0 {
+		t.Fatalf("quantile of empty dist = %v, want 0", got)
+	}
+}
+
+func TestAllocStats(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Stats.Enable = true
+	DefaultConfig.Quota.Tags = map[string]TagQuota{"test": {PerMinute: 30}}
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	alloc.clock = clock
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		startTime := alloc.now()
+		if _, err := alloc.NextId(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Second)
+		alloc.recordLatency("test", startTime)
+	}
+	if _, err := alloc.NextId(ctx, "test"); err == nil { // 超过每分钟配额
+		t.Fatal("NextId succeeded over the quota")
+	}
+
+	stats := alloc.Stats()["test"]
+	minute := stats.LastMinute
+	if minute.Allocs != 30 || minute.Errors != 1 || minute.Fetches < 1 {
+		t.Fatalf("1m stats = %+v, want 30 allocs, 1 error and segment fetches", minute)
+	}
+	if minute.Rate < 0.5 || minute.Rate > 1.5 {
+		t.Fatalf("1m rate = %v, want about 1 per second", minute.Rate)
+	}
+	if minute.Latency.P50 < 1000 || minute.Latency.P99 > 1420 {
+		t.Fatalf("1m latency = %+v, want about 1000 ms", minute.Latency)
+	}
+
+	// 1 分钟后只在 5 分钟窗口中
+	clock.advance(2 * time.Minute)
+	stats = alloc.Stats()["test"]
+	if stats.LastMinute.Allocs != 0 || stats.FiveMinutes.Allocs != 30 {
+		t.Fatalf("allocs after 2 minutes = (1m %d, 5m %d), want (0, 30)", stats.LastMinute.Allocs, stats.FiveMinutes.Allocs)
+	}
+
+	// 5 分钟后统计槽被复用, 旧的统计不再计入
+	clock.advance(5 * time.Minute)
+	if _, err := alloc.NextId(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if stats = alloc.Stats()["test"]; stats.FiveMinutes.Allocs != 1 || stats.FiveMinutes.Errors != 0 {
+		t.Fatalf("5m stats after 7 minutes = %+v, want only the new alloc", stats.FiveMinutes)
+	}
+
+	DefaultAlloc = alloc
+	w := httptest.NewRecorder()
+	handleStats(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var resp struct {
+		Biz map[string]BizStats `json:"biz"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Biz) == 0 {
+		t.Fatalf("GET /stats = (%s, %v)", w.Body.String(), err)
+	}
+}
+
+func TestStatsAuth(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Stats.Enable = true
+	DefaultAlloc = newTestAlloc(t, newFakeStorage(100))
+	for _, bizTag := range []string{"test", "other"} {
+		if _, err := DefaultAlloc.NextId(context.Background(), bizTag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	auth, err := newAPIKeyAuth(AuthConfig{Header: "X-Api-Key", Keys: []APIKey{
+		{Key: "team", Name: "team", BizTags: []string{"test"}},
+		{Key: "ops", Name: "ops", Admin: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := withAuth([]authenticator{auth}, handleStats)
+
+	// 未认证时拒绝, 调用方只能看到有权访问的业务, 管理员可以看到全部业务
+	for key, want := range map[string]int{"": 0, "team": 1, "ops": 2} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		r.Header.Set("X-Api-Key", key)
+		handler(w, r)
+		var resp struct {
+			Biz map[string]BizStats `json:"biz"`
+		}
+		if want == 0 {
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("unauthenticated status %d, want 401", w.Code)
+			}
+			continue
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Biz) != want {
+			t.Fatalf("key %q: GET /stats = (%s, %v), want %d biz_tags", key, w.Body.String(), err, want)
+		}
+	}
+}
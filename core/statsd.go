@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdMaxPacket 单个 UDP 包的最大字节数, 避免超过常见 MTU 被分片
+const statsdMaxPacket = 1432
+
+// StatsdConfig 定义 StatsD/Datadog 指标上报的配置
+type StatsdConfig struct {
+	Enable        bool     `json:"enable"`         // 是否启用 StatsD 上报
+	Addr          string   `json:"addr"`           // StatsD 服务地址, 如 127.0.0.1:8125
+	Prefix        string   `json:"prefix"`         // 指标名前缀, 如 leaf.
+	Tags          []string `json:"tags"`           // 附加到所有指标上的标签, 如 env:prod
+	FlushInterval int      `json:"flush_interval"` // 批量发送间隔（毫秒）
+}
+
+// statsdClient 基于 UDP 的 StatsD 客户端, 指标先写入队列再由后台协程批量发送
+type statsdClient struct {
+	conn   net.Conn    // UDP 连接
+	prefix string      // 指标名前缀
+	tags   string      // 全局标签, 已格式化为 DogStatsD 形式
+	queue  chan string // 待发送的指标行
+}
+
+// statsd 全局 StatsD 客户端, 未启用时为 nil
+var statsd *statsdClient
+
+// InitStatsd 根据配置初始化 StatsD 客户端
+func InitStatsd() (err error) {
+	var (
+		conf = DefaultConfig.Statsd
+		conn net.Conn
+	)
+
+	if !conf.Enable {
+		return
+	}
+
+	if conn, err = net.Dial("udp", conf.Addr); err != nil {
+		return
+	}
+
+	statsd = &statsdClient{
+		conn:   conn,
+		prefix: conf.Prefix,
+		tags:   strings.Join(conf.Tags, ","),
+		queue:  make(chan string, 4096),
+	}
+	go statsd.loop(time.Duration(conf.FlushInterval) * time.Millisecond)
+	return
+}
+
+// emit 格式化一条指标并放入发送队列, 队列满时直接丢弃, 不阻塞分配路径
+func (client *statsdClient) emit(name string, value string, kind string, tags ...string) {
+	var (
+		line strings.Builder
+		all  = tags
+	)
+
+	if client == nil {
+		return
+	}
+
+	line.WriteString(client.prefix)
+	line.WriteString(name)
+	line.WriteString(":")
+	line.WriteString(value)
+	line.WriteString("|")
+	line.WriteString(kind)
+
+	// DogStatsD 标签格式: |#k1:v1,k2:v2
+	if client.tags != "" {
+		all = append([]string{client.tags}, tags...)
+	}
+	if len(all) != 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(all, ","))
+	}
+
+	select {
+	case client.queue <- line.String():
+	default:
+	}
+}
+
+// Incr 计数器加一
+func (client *statsdClient) Incr(name string, tags ...string) {
+	client.emit(name, "1", "c", tags...)
+}
+
+// Timing 上报耗时（毫秒）
+func (client *statsdClient) Timing(name string, elapsed time.Duration, tags ...string) {
+	client.emit(name, fmt.Sprintf("%g", float64(elapsed.Microseconds())/1000), "ms", tags...)
+}
+
+// Gauge 上报瞬时值
+func (client *statsdClient) Gauge(name string, value float64, tags ...string) {
+	client.emit(name, fmt.Sprintf("%g", value), "g", tags...)
+}
+
+// reportGauges 上报所有业务的号段余量
+func (client *statsdClient) reportGauges() {
+	if DefaultAlloc == nil {
+		return
+	}
+	for _, g := range DefaultAlloc.gauges() {
+		tag := "biz_tag:" + g.bizTag
+		client.Gauge("segment.remaining", float64(g.remaining), tag)
+		client.Gauge("segment.remaining_ratio", g.ratio, tag)
+		client.Gauge("buffer.count", float64(g.buffers), tag)
+		client.Gauge("waiting.clients", float64(g.waiting), tag)
+	}
+}
+
+// loop 批量发送队列中的指标, 每个周期同时上报一次号段余量
+func (client *statsdClient) loop(interval time.Duration) {
+	var (
+		packet bytes.Buffer
+		ticker *time.Ticker
+	)
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if packet.Len() != 0 {
+			_, _ = client.conn.Write(packet.Bytes()) // UDP 发送失败不重试
+			packet.Reset()
+		}
+	}
+
+	for {
+		select {
+		case line := <-client.queue:
+			if packet.Len()+len(line)+1 > statsdMaxPacket {
+				flush()
+			}
+			if packet.Len() != 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		case <-ticker.C:
+			client.reportGauges()
+			flush()
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"context"
+)
+
+// Storage 号段存储, 负责推进业务的 max_id 并返回新号段的上界和步长
+// Data 是基于 MySQL 的实现, 熔断等能力以装饰器的形式包装在外层
+type Storage interface {
+	// NextId 将 bizTag 的 max_id 推进 multiple 个步长, 返回推进后的 max_id 和单个步长
+	// 新号段为 [maxId - step*multiple, maxId)
+	NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error)
+
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+// newStorage 在基础号段存储(MySQL 或本地文件)外按配置装配包装, ledger 为 nil 表示不记录台账
+func newStorage(conf *Config, base Storage, ledger *segmentLedger) (storage Storage) {
+	storage = base
+
+	// 号码上限紧贴基础存储, 循环时直接重置号段行; 号码耗尽不会触发熔断
+	storage = wrapCapacity(storage, conf)
+
+	// 故障注入包装在熔断器之内, 注入的故障同样会触发熔断
+	storage = wrapChaos(storage, conf.Chaos)
+
+	// 台账记录分配器实际拿到的号段, 包括故障注入修改后的结果
+	storage = wrapLedger(storage, ledger)
+
+	// 熔断器包装在最外层, 数据库故障时快速失败
+	if conf.Breaker.Enable {
+		storage = newBreakerStorage(storage, conf.Breaker)
+	}
+
+	// 分区在熔断器之外改写业务标识, 台账记录实际使用的号段行
+	storage = wrapPartition(storage, conf.Partition)
+	return
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResult 预先安排的一次号段获取结果
+type fakeResult struct {
+	maxId int64
+	step  int64
+	err   error
+}
+
+// fakeStorage 可控的号段存储, 默认每个业务从0开始按固定步长推进,
+// 可以注入延迟、错误和指定的号段, 也可以阻塞每次获取直到测试放行
+type fakeStorage struct {
+	mutex   sync.Mutex
+	step    int64            // 每个业务的步长
+	maxId   map[string]int64 // 各业务当前的 max_id
+	latency time.Duration    // 每次获取的延迟
+	script  []fakeResult     // 依次返回的结果, 用完后按步长推进
+	err     error            // 不为 nil 时每次获取都返回该错误, 优先于 script
+	gate    chan struct{}    // 不为 nil 时每次获取都要从中取到一个令牌才返回
+	calls   int              // 获取次数
+}
+
+// newFakeStorage 创建按 step 推进的号段存储
+func newFakeStorage(step int64) *fakeStorage {
+	return &fakeStorage{step: step, maxId: map[string]int64{}}
+}
+
+// NextId 按注入的行为返回号段
+func (storage *fakeStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	storage.mutex.Lock()
+	storage.calls++
+	latency, gate := storage.latency, storage.gate
+	storage.mutex.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		}
+	}
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		}
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if storage.err != nil {
+		return 0, 0, storage.err
+	}
+	if len(storage.script) != 0 {
+		result := storage.script[0]
+		storage.script = storage.script[1:]
+		if result.err == nil {
+			storage.maxId[bizTag] = result.maxId
+		}
+		return result.maxId, result.step, result.err
+	}
+	storage.maxId[bizTag] += storage.step * multiple
+	return storage.maxId[bizTag], storage.step, nil
+}
+
+// Close 内存存储无需释放资源
+func (storage *fakeStorage) Close() error {
+	return nil
+}
+
+// setErr 设置之后每次获取都返回的错误, nil 表示恢复正常
+func (storage *fakeStorage) setErr(err error) {
+	storage.mutex.Lock()
+	storage.err = err
+	storage.mutex.Unlock()
+}
+
+// callCount 返回获取次数
+func (storage *fakeStorage) callCount() int {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.calls
+}
+
+// setupTestConfig 使用默认配置初始化全局配置, 测试期间只输出错误日志
+func setupTestConfig(tb testing.TB) {
+	tb.Helper()
+
+	DefaultConfig = &Config{}
+	logLevel.Set(slog.LevelError)
+}
+
+// newTestAlloc 创建使用指定号段存储的分配器, 测试结束时取消并等待补偿线程退出
+func newTestAlloc(tb testing.TB, storage Storage) *Alloc {
+	tb.Helper()
+
+	alloc := newAlloc(DefaultConfig, storage)
+	tb.Cleanup(func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		cancelFunc() // 不等待宽限期, 被阻塞的获取立即取消
+		_ = alloc.Close(ctx)
+	})
+	return alloc
+}
+
+// waitFor 轮询直到条件成立, 超过1秒视为失败
+func waitFor(tb testing.TB, what string, cond func() bool) {
+	tb.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			tb.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeTable 假数据库中的一张表, 每次查询都返回全部行, 供加载配置表的测试使用
+type fakeTable struct {
+	mutex   sync.Mutex
+	columns []string
+	rows    [][]driver.Value
+	err     error            // 不为 nil 时每次查询和执行都返回该错误
+	queries int              // 查询次数
+	execs   [][]driver.Value // 依次执行的语句参数, 不修改表中的行
+}
+
+// fakeTables 按 DSN 登记的假表
+var fakeTables sync.Map
+
+func init() {
+	sql.Register("leaf-fake", fakeDriver{})
+}
+
+// openFakeData 以假表作为全局数据库, 测试结束时恢复
+func openFakeData(tb testing.TB, table *fakeTable) {
+	tb.Helper()
+
+	fakeTables.Store(tb.Name(), table)
+	db, err := sql.Open("leaf-fake", tb.Name())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	DefaultData = &Data{conf: DefaultConfig, dbs: []*sql.DB{db}}
+	tb.Cleanup(func() {
+		DefaultData = nil
+		_ = db.Close()
+		fakeTables.Delete(tb.Name())
+	})
+}
+
+// setRows 替换表中的全部行
+func (table *fakeTable) setRows(rows ...[]driver.Value) {
+	table.mutex.Lock()
+	table.rows = rows
+	table.mutex.Unlock()
+}
+
+// queryCount 返回查询次数
+func (table *fakeTable) queryCount() int {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	return table.queries
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	table, ok := fakeTables.Load(dsn)
+	if !ok {
+		return nil, errors.New("unknown fake table " + dsn)
+	}
+	return fakeConn{table: table.(*fakeTable)}, nil
+}
+
+type fakeConn struct {
+	table *fakeTable
+}
+
+func (conn fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{table: conn.table}, nil
+}
+
+func (conn fakeConn) Close() error {
+	return nil
+}
+
+func (conn fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// fakeTx 假表的事务, 执行的语句立即记录, 提交和回滚都不做任何事
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	table *fakeTable
+}
+
+func (stmt fakeStmt) Close() error {
+	return nil
+}
+
+func (stmt fakeStmt) NumInput() int {
+	return -1
+}
+
+func (stmt fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	stmt.table.mutex.Lock()
+	defer stmt.table.mutex.Unlock()
+
+	if stmt.table.err != nil {
+		return nil, stmt.table.err
+	}
+	stmt.table.execs = append(stmt.table.execs, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (stmt fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	stmt.table.mutex.Lock()
+	defer stmt.table.mutex.Unlock()
+
+	stmt.table.queries++
+	if stmt.table.err != nil {
+		return nil, stmt.table.err
+	}
+	return &fakeRows{columns: stmt.table.columns, rows: stmt.table.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (rows *fakeRows) Columns() []string {
+	return rows.columns
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if len(rows.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.rows[0])
+	rows.rows = rows.rows[1:]
+	return nil
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart systemd 传入的第一个文件描述符
+var listenFdsStart = 3
+
+// systemdListenerNames 未通过 FileDescriptorName= 命名时, 按顺序对应的端口
+var systemdListenerNames = []string{"http", "fast", "admin"}
+
+// inheritSystemdListeners 由 systemd 套接字激活启动时, 使用传入的监听代替按配置监听
+// 套接字按 FileDescriptorName= 命名为 http、fast 或 admin, 未命名时按 Sockets= 的顺序依次对应;
+// 重启期间由 systemd 持有套接字, 新连接在队列中等待新进程接收, 不会被拒绝
+func inheritSystemdListeners(opts *ServerOptions) (err error) {
+	var (
+		pid, count int
+		names      []string
+	)
+
+	if pid, err = strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil // 不是由 systemd 套接字激活, 或环境变量是传给父进程的
+	}
+	if count, err = strconv.Atoi(os.Getenv("LISTEN_FDS")); err != nil || count <= 0 {
+		return nil
+	}
+	if value := os.Getenv("LISTEN_FDNAMES"); value != "" {
+		names = strings.Split(value, ":")
+	}
+
+	// 只使用一次, 避免重新启动服务器或子进程再次使用已关闭的描述符
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < count; i++ {
+		var (
+			name     string
+			listener net.Listener
+		)
+
+		fd := listenFdsStart + i
+		if i < len(names) && isSystemdListenerName(names[i]) {
+			name = names[i]
+		} else if i < len(systemdListenerNames) {
+			name = systemdListenerNames[i]
+		}
+
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err = net.FileListener(file)
+		file.Close() // FileListener 复制了描述符
+		if err != nil {
+			return fmt.Errorf("systemd socket %d: %w", fd, err)
+		}
+
+		var target *net.Listener
+		switch name {
+		case "http":
+			target = &opts.Listener
+		case "fast":
+			target = &opts.FastListener
+		case "admin":
+			target = &opts.AdminListener
+		}
+		if target == nil || *target != nil { // 多余的套接字, 或调用方已注入监听
+			logger.Warn("systemd socket not used", "fd", fd, "name", name, "addr", listener.Addr().String())
+			listener.Close()
+			continue
+		}
+		*target = listener
+		logger.Info("using systemd socket", "fd", fd, "name", name, "addr", listener.Addr().String())
+	}
+	return nil
+}
+
+// isSystemdListenerName 是否为可识别的套接字名称
+func isSystemdListenerName(name string) bool {
+	for _, known := range systemdListenerNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// sdNotify 通过 NOTIFY_SOCKET 向 systemd 报告状态, 未由 systemd 以 Type=notify 启动时不做任何事
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if addr[0] == '@' { // 抽象命名空间
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		logger.Warn("sd_notify failed", "state", state, "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		logger.Warn("sd_notify failed", "state", state, "err", err)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+package core
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestInheritSystemdListeners(t *testing.T) {
+	setupTestConfig(t)
+
+	// 模拟 systemd 传入一个命名为 admin 的套接字
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	file, err := origin.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(file.Fd()))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := listenFdsStart
+	listenFdsStart = fd
+	t.Cleanup(func() { listenFdsStart = start })
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "admin")
+
+	var opts ServerOptions
+	if err = inheritSystemdListeners(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Listener != nil || opts.FastListener != nil || opts.AdminListener == nil {
+		t.Fatalf("listeners = %+v, want only the admin listener", opts)
+	}
+	defer opts.AdminListener.Close()
+	if opts.AdminListener.Addr().String() != origin.Addr().String() {
+		t.Fatalf("admin listener on %s, want %s", opts.AdminListener.Addr(), origin.Addr())
+	}
+
+	// 环境变量只使用一次
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("LISTEN_FDS not cleared")
+	}
+	opts = ServerOptions{}
+	if err = inheritSystemdListeners(&opts); err != nil || opts.AdminListener != nil {
+		t.Fatalf("second inherit = (%+v, %v), want nothing", opts, err)
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	setupTestConfig(t)
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	sdNotify("READY=1")
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Fatalf("received (%q, %v), want READY=1", buf[:n], err)
+	}
+}
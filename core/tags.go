@@ -0,0 +1,235 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// maxDescriptionLength 业务描述的最大字符数, 与 segments 表 description 列的长度一致
+const maxDescriptionLength = 1024
+
+var (
+	errDescriptionTooLong = errors.New("description too long")
+	errArchiveDisabled    = errors.New("archive is not enabled")
+	errInvalidStep        = errors.New("step must be positive")
+)
+
+// ArchiveConfig 定义业务归档的配置, 启用后号段表需要 archived 列
+// 退役的业务归档后不再发号, 号段行、max_id、台账和审计记录仍可查询, 不必删除号段行
+type ArchiveConfig struct {
+	Enable bool `json:"enable"` // 是否启用归档
+}
+
+// TagInfo 号段表中一个业务的号段行
+type TagInfo struct {
+	BizTag      string    `json:"biz_tag"`     // 业务标识, 启用分区时为带分区后缀的号段行
+	MaxId       int64     `json:"max_id"`      // 已分配出去的最大号码(不包含)
+	Step        int64     `json:"step"`        // 步长
+	Description string    `json:"description"` // 业务描述, 如负责的团队
+	UpdateTime  time.Time `json:"update_time"` // 号段行最近一次修改的时间
+	Table       string    `json:"table"`       // 号段行所在的表
+	Archived    bool      `json:"archived"`    // 是否已归档, 未启用归档时总为 false
+}
+
+// TagsResponse 用于封装业务查询和修改请求的响应
+type TagsResponse struct {
+	ErrNo int       `json:"err_no"` // 错误码
+	Msg   string    `json:"msg"`    // 错误或成功消息
+	Tags  []TagInfo `json:"tags"`   // 号段行, 按业务标识排序
+}
+
+// Tags 查询号段表中的业务, bizTag 为空表示全部业务; 启用分表时查询所有号段表
+// 非关键查询, 配置了只读副本时在副本上执行
+func (data *Data) Tags(ctx context.Context, bizTag string) (tags []TagInfo, err error) {
+	archived := "0" // 未启用归档时号段表可能没有 archived 列
+	if data.conf.Archive.Enable {
+		archived = "archived"
+	}
+
+	for _, table := range data.conf.Sharding.tables(data.conf.Table) {
+		var (
+			rows *sql.Rows
+		)
+
+		if rows, err = data.reader().QueryContext(ctx,
+			"SELECT biz_tag, max_id, step, "+descriptionColumn(data.conf)+", update_time, "+archived+" FROM "+table+
+				" WHERE ? = '' OR biz_tag = ?", bizTag, bizTag); err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			tag := TagInfo{Table: table}
+			if err = rows.Scan(&tag.BizTag, &tag.MaxId, &tag.Step, &tag.Description, &tag.UpdateTime, &tag.Archived); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tags = append(tags, tag)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(tags, func(a, b TagInfo) int {
+		return cmp.Compare(a.BizTag, b.BizTag)
+	})
+	return tags, nil
+}
+
+// SetDescription 修改业务的描述, 不改变号段; 业务不存在时返回 ErrBizTagNotFound
+func (data *Data) SetDescription(ctx context.Context, bizTag string, description string) error {
+	if utf8.RuneCountInString(description) > descriptionLength(data.conf) {
+		return errDescriptionTooLong
+	}
+	return data.updateTag(ctx, bizTag, "description", description)
+}
+
+// SetStep 修改业务的步长, 不改变已分配的号段, 下一次获取号段时生效; 业务不存在时返回 ErrBizTagNotFound
+// 本实例直接按新步长走单语句获取, 其他实例的单语句获取因步长不符失败后回退到事务读到新步长
+func (data *Data) SetStep(ctx context.Context, bizTag string, step int64) (err error) {
+	if step <= 0 || (data.conf.Schema == SchemaLeaf && step > math.MaxInt32) { // leaf_alloc 的 step 为 int(11)
+		return errInvalidStep
+	}
+	if err = data.updateTag(ctx, bizTag, "step", step); err != nil {
+		return
+	}
+	data.steps.Store(bizTag, step)
+	return
+}
+
+// SetArchived 归档或恢复业务, 归档后号段行、max_id 和台账保留, 但不再发号; 业务不存在时返回 ErrBizTagNotFound
+func (data *Data) SetArchived(ctx context.Context, bizTag string, archived bool) error {
+	if !data.conf.Archive.Enable {
+		return errArchiveDisabled
+	}
+	return data.updateTag(ctx, bizTag, "archived", archived)
+}
+
+// updateTag 修改号段行的一列, 不改变号段
+func (data *Data) updateTag(ctx context.Context, bizTag string, column string, value any) (err error) {
+	var (
+		result       sql.Result
+		rowsAffected int64
+		table        = data.conf.Sharding.table(data.conf.Table, bizTag)
+	)
+
+	if result, err = data.current().ExecContext(ctx, "UPDATE "+table+" SET "+column+" = ? WHERE biz_tag = ?", value, bizTag); err != nil {
+		return
+	}
+	if rowsAffected, err = result.RowsAffected(); err != nil {
+		return
+	}
+	if rowsAffected == 0 {
+		// 值未变化时 MySQL 同样返回 0 行, 再确认业务是否存在
+		if err = data.current().QueryRowContext(ctx, "SELECT 1 FROM "+table+" WHERE biz_tag = ?", bizTag).Scan(new(int)); errors.Is(err, sql.ErrNoRows) {
+			err = ErrBizTagNotFound
+		}
+	}
+	return
+}
+
+// handleAdminTags 处理业务的查询(GET)和修改(PUT)请求, 仅 MySQL 号段存储可用
+// 查询支持 biz_tag 参数过滤; 修改需要 biz_tag 参数, 以及 description、step 或 archived 参数中的至少一个
+// 修改步长后本实例立即按新步长补充号段, 监控中的步长同时更新
+func handleAdminTags(w http.ResponseWriter, r *http.Request) {
+	var (
+		resp     = TagsResponse{} // 响应数据
+		err      error            // 错误信息
+		bizTag   string           // 业务标识
+		archived bool             // 是否归档
+		step     int64            // 步长
+	)
+
+	if DefaultData == nil {
+		w.WriteHeader(http.StatusNotFound)
+		resp.ErrNo, resp.Msg = -1, "tags need the mysql store"
+		goto RESP
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if resp.Tags, err = DefaultData.Tags(r.Context(), r.URL.Query().Get("biz_tag")); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			resp.ErrNo, resp.Msg = -1, err.Error()
+			goto RESP
+		}
+	case http.MethodPut:
+		// 解析请求参数, 支持 query/form 中的 biz_tag、description、step 和 archived 参数
+		if err = r.ParseForm(); err != nil || r.Form.Get("biz_tag") == "" ||
+			(!r.Form.Has("description") && !r.Form.Has("step") && !r.Form.Has("archived")) {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.ErrNo, resp.Msg = -1, "biz_tag and description, step or archived are required"
+			goto RESP
+		}
+		bizTag = r.Form.Get("biz_tag")
+		if r.Form.Has("step") {
+			if step, err = strconv.ParseInt(r.Form.Get("step"), 10, 64); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				resp.ErrNo, resp.Msg = -1, "invalid step"
+				goto RESP
+			}
+		}
+		if r.Form.Has("archived") {
+			if archived, err = strconv.ParseBool(r.Form.Get("archived")); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				resp.ErrNo, resp.Msg = -1, "invalid archived"
+				goto RESP
+			}
+		}
+
+		if r.Form.Has("description") {
+			if err = DefaultData.SetDescription(r.Context(), bizTag, r.Form.Get("description")); err != nil {
+				goto FAIL
+			}
+			auditor.record(r, AuditDescription, bizTag, r.Form.Get("description"))
+		}
+		if r.Form.Has("step") {
+			if err = DefaultData.SetStep(r.Context(), bizTag, step); err != nil {
+				goto FAIL
+			}
+			auditor.record(r, AuditStep, bizTag, strconv.FormatInt(step, 10))
+			DefaultAlloc.applyStep(bizTag, step) // 下一次补充号段使用新步长
+		}
+		if r.Form.Has("archived") {
+			if err = DefaultData.SetArchived(r.Context(), bizTag, archived); err != nil {
+				goto FAIL
+			}
+			auditor.record(r, AuditArchive, bizTag, strconv.FormatBool(archived))
+			if archived { // 本实例立即停止发号, 其他实例在内存中的号段用完后停止
+				DefaultAlloc.forget(bizTag)
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	resp.Msg = "success"
+	goto RESP
+
+FAIL:
+	switch {
+	case errors.Is(err, ErrBizTagNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, errArchiveDisabled), errors.Is(err, errDescriptionTooLong), errors.Is(err, errInvalidStep):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	resp.ErrNo, resp.Msg = -1, err.Error()
+
+RESP:
+	// 将响应数据编码为 JSON 并写入响应
+	if bytes, err := json.Marshal(&resp); err == nil {
+		_, _ = w.Write(bytes) // 写入响应数据
+	}
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminTagsWithoutMySQL(t *testing.T) {
+	setupHandlerTest(t)
+
+	w := httptest.NewRecorder()
+	handleAdminTags(w, httptest.NewRequest(http.MethodGet, "/admin/tags", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "mysql store") {
+		t.Fatalf("tags = %d %s, want 404", w.Code, w.Body)
+	}
+}
+
+func TestSetDescriptionTooLong(t *testing.T) {
+	data := &Data{conf: &Config{Table: "segments"}}
+	if err := data.SetDescription(context.Background(), "test", strings.Repeat("描", maxDescriptionLength+1)); err == nil {
+		t.Fatal("SetDescription accepted a description longer than the column")
+	}
+}
+
+func TestAdminTagsArchive(t *testing.T) {
+	setupHandlerTest(t)
+	DefaultData = &Data{conf: DefaultConfig}
+	t.Cleanup(func() { DefaultData = nil })
+
+	put := func(query string) int {
+		w := httptest.NewRecorder()
+		handleAdminTags(w, httptest.NewRequest(http.MethodPut, "/admin/tags?"+query, nil))
+		return w.Code
+	}
+	for _, query := range []string{"biz_tag=test", "archived=true", "biz_tag=test&archived=maybe", "biz_tag=test&archived=true",
+		"biz_tag=test&step=abc", "biz_tag=test&step=0"} {
+		if code := put(query); code != http.StatusBadRequest {
+			t.Errorf("PUT %s = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestAllocForget(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Partition = PartitionConfig{Enable: true, Count: 2, Instance: 1, Separator: "#"}
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	if _, err := alloc.NextId(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按号段行归档时丢弃对应业务在内存中的号段
+	alloc.forget("test#1")
+	if alloc.load("test") != nil {
+		t.Fatal("forget kept the archived biz_tag in memory")
+	}
+	if status, errNo, _ := allocFailure(context.Background(), "test", ErrBizTagArchived); status != http.StatusGone || errNo != ErrNoArchived {
+		t.Fatalf("allocFailure = (%d, %d), want (410, %d)", status, errNo, ErrNoArchived)
+	}
+	if retryableFill(ErrBizTagArchived) || isStorageFault(ErrBizTagArchived) {
+		t.Fatal("archived biz_tag treated as a transient storage fault")
+	}
+}
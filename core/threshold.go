@@ -0,0 +1,163 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AlertThreshold 定义单个业务的告警阈值, 覆盖 alert 中的全局阈值
+// 发号速率相差几个数量级的业务不适合共用一组阈值, 可以在配置文件的 alert.tags 或数据库的 alert.table 中单独设置;
+// 字段为 0 时使用全局阈值, 为负数时该业务不做这项检查
+type AlertThreshold struct {
+	RemainingThreshold  float64 `json:"remaining_threshold"`   // 剩余比例低于该值时告警, 0~1
+	RefillFailThreshold int     `json:"refill_fail_threshold"` // 补偿线程连续放弃多少次后告警
+	QPSThreshold        float64 `json:"qps_threshold"`         // 检查周期内的分配请求速率（次/秒）超过该值时告警
+}
+
+// 告警阈值的名称, 用作指标的 threshold 标签
+const (
+	thresholdRemaining  = "remaining_ratio"
+	thresholdRefillFail = "refill_fail"
+	thresholdQPS        = "qps"
+)
+
+// alertBreach 单个业务最近一次检查时各项阈值是否被突破
+type alertBreach struct {
+	remaining  bool
+	refillFail bool
+	qps        bool
+}
+
+// threshold 返回业务生效的告警阈值, 数据库中的配置优先于配置文件, 未单独配置的字段使用全局阈值
+func (alerter *Alerter) threshold(bizTag string) AlertThreshold {
+	alerter.mutex.Lock()
+	override, ok := alerter.loaded[bizTag]
+	alerter.mutex.Unlock()
+	if !ok {
+		override = alerter.conf.Tags[bizTag]
+	}
+
+	return AlertThreshold{
+		RemainingThreshold:  cmp.Or(override.RemainingThreshold, alerter.conf.RemainingThreshold),
+		RefillFailThreshold: cmp.Or(override.RefillFailThreshold, alerter.conf.RefillFailThreshold),
+		QPSThreshold:        cmp.Or(override.QPSThreshold, alerter.conf.QPSThreshold),
+	}
+}
+
+// checkAlertThresholds 检查全局和按业务的告警阈值
+func checkAlertThresholds(conf AlertConfig) error {
+	if conf.RemainingThreshold > 1 || conf.QPSThreshold < 0 {
+		return errors.New("alert.remaining_threshold must not exceed 1 and alert.qps_threshold must not be negative")
+	}
+	for bizTag, th := range conf.Tags {
+		if th.RemainingThreshold > 1 {
+			return fmt.Errorf("alert.tags %q: remaining_threshold must not exceed 1", bizTag)
+		}
+	}
+	return nil
+}
+
+// InitAlertThresholds 配置了 alert.table 时从数据库加载按业务的告警阈值, 之后定期重新加载, 需在初始化号段存储之后调用
+func InitAlertThresholds() (err error) {
+	if alerter == nil || alerter.conf.Table == "" {
+		return nil
+	}
+	if DefaultData == nil {
+		return errors.New("alert.table needs the mysql store")
+	}
+	if err = alerter.reloadThresholds(); err != nil {
+		return fmt.Errorf("load alert thresholds: %w", err)
+	}
+	go alerter.reloadLoop()
+	return nil
+}
+
+// reloadThresholds 从数据库加载全部业务的告警阈值
+func (alerter *Alerter) reloadThresholds() (err error) {
+	var (
+		rows   *sql.Rows
+		loaded = map[string]AlertThreshold{}
+	)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFunc()
+
+	if rows, err = DefaultData.reader().QueryContext(ctx,
+		"SELECT biz_tag, remaining_threshold, refill_fail_threshold, qps_threshold FROM "+alerter.conf.Table); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			bizTag string
+			th     AlertThreshold
+		)
+		if err = rows.Scan(&bizTag, &th.RemainingThreshold, &th.RefillFailThreshold, &th.QPSThreshold); err != nil {
+			return
+		}
+		loaded[bizTag] = th
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	alerter.mutex.Lock()
+	alerter.loaded = loaded
+	alerter.mutex.Unlock()
+	return
+}
+
+// reloadLoop 定期重新加载数据库中的告警阈值, 加载失败时保留上一次的结果
+func (alerter *Alerter) reloadLoop() {
+	var (
+		interval = time.Duration(alerter.conf.ReloadInterval) * time.Millisecond
+		ticker   *time.Ticker
+	)
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := alerter.reloadThresholds(); err != nil {
+			logger.Warn("reload alert thresholds failed", "table", alerter.conf.Table, "err", err)
+		}
+	}
+}
+
+// writeAlertMetrics 输出各业务生效的告警阈值和最近一次检查时是否突破, 负数阈值表示不检查
+func writeAlertMetrics(b *strings.Builder, gauges []bizGauge) {
+	if alerter == nil {
+		return
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_alert_threshold Effective alert threshold by biz_tag, negative when the check is disabled.")
+	fmt.Fprintln(b, "# TYPE leaf_alert_threshold gauge")
+	for _, g := range gauges {
+		tag, th := escapeLabel(g.bizTag), alerter.threshold(g.bizTag)
+		fmt.Fprintf(b, "leaf_alert_threshold{biz_tag=\"%s\",threshold=\"%s\"} %g\n", tag, thresholdRemaining, th.RemainingThreshold)
+		fmt.Fprintf(b, "leaf_alert_threshold{biz_tag=\"%s\",threshold=\"%s\"} %d\n", tag, thresholdRefillFail, th.RefillFailThreshold)
+		fmt.Fprintf(b, "leaf_alert_threshold{biz_tag=\"%s\",threshold=\"%s\"} %g\n", tag, thresholdQPS, th.QPSThreshold)
+	}
+
+	fmt.Fprintln(b, "# HELP leaf_alert_breached Whether the biz_tag breached the alert threshold at the last check.")
+	fmt.Fprintln(b, "# TYPE leaf_alert_breached gauge")
+	for _, g := range gauges {
+		alerter.mutex.Lock()
+		breach := alerter.breaches[g.bizTag]
+		alerter.mutex.Unlock()
+
+		tag := escapeLabel(g.bizTag)
+		fmt.Fprintf(b, "leaf_alert_breached{biz_tag=\"%s\",threshold=\"%s\"} %d\n", tag, thresholdRemaining, boolGauge(breach.remaining))
+		fmt.Fprintf(b, "leaf_alert_breached{biz_tag=\"%s\",threshold=\"%s\"} %d\n", tag, thresholdRefillFail, boolGauge(breach.refillFail))
+		fmt.Fprintf(b, "leaf_alert_breached{biz_tag=\"%s\",threshold=\"%s\"} %d\n", tag, thresholdQPS, boolGauge(breach.qps))
+	}
+}
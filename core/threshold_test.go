@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nopNotifier 不发送告警, 事件留在告警器的队列中供测试读取
+type nopNotifier struct{}
+
+func (nopNotifier) Notify(context.Context, AlertEvent) error { return nil }
+
+// newTestAlerter 创建不启动后台循环的告警器
+func newTestAlerter(conf AlertConfig) *Alerter {
+	return &Alerter{
+		conf:      conf,
+		notifiers: []Notifier{nopNotifier{}},
+		events:    make(chan AlertEvent, 16),
+		lastSent:  map[alertKey]time.Time{},
+		breaches:  map[string]alertBreach{},
+		rates:     map[string]*rateState{},
+	}
+}
+
+// drainAlerts 取出队列中所有事件的类型
+func drainAlerts(alerter *Alerter) (types []string) {
+	for {
+		select {
+		case event := <-alerter.events:
+			types = append(types, event.Type+":"+event.BizTag)
+		default:
+			return
+		}
+	}
+}
+
+func TestAlertThreshold(t *testing.T) {
+	alerter := newTestAlerter(AlertConfig{
+		RemainingThreshold:  0.2,
+		RefillFailThreshold: 3,
+		QPSThreshold:        100,
+		Tags: map[string]AlertThreshold{
+			"hot":   {RemainingThreshold: 0.5, QPSThreshold: 50000},
+			"quiet": {RemainingThreshold: -1, RefillFailThreshold: -1},
+			"db":    {QPSThreshold: 1},
+		},
+	})
+	alerter.loaded = map[string]AlertThreshold{"db": {QPSThreshold: 10}}
+
+	cases := map[string]AlertThreshold{
+		"other": {RemainingThreshold: 0.2, RefillFailThreshold: 3, QPSThreshold: 100},   // 全部使用全局阈值
+		"hot":   {RemainingThreshold: 0.5, RefillFailThreshold: 3, QPSThreshold: 50000}, // 未配置的字段使用全局阈值
+		"quiet": {RemainingThreshold: -1, RefillFailThreshold: -1, QPSThreshold: 100},   // 负数表示不检查
+		"db":    {RemainingThreshold: 0.2, RefillFailThreshold: 3, QPSThreshold: 10},    // 数据库优先于配置文件
+	}
+	for bizTag, want := range cases {
+		if got := alerter.threshold(bizTag); got != want {
+			t.Errorf("threshold(%s) = %+v, want %+v", bizTag, got, want)
+		}
+	}
+}
+
+func TestAlertCheckPerBiz(t *testing.T) {
+	alerter := newTestAlerter(AlertConfig{
+		RemainingThreshold:  0.2,
+		RefillFailThreshold: 1,
+		QPSThreshold:        100,
+		Tags: map[string]AlertThreshold{
+			"hot":   {QPSThreshold: 50000},
+			"quiet": {RemainingThreshold: -1, RefillFailThreshold: -1},
+		},
+	})
+	gauge := func(bizTag string, ratio float64, giveUps int, metrics *BizMetrics) bizGauge {
+		return bizGauge{bizTag: bizTag, segSize: 100, ratio: ratio, failing: giveUps > 0, giveUps: giveUps, metrics: metrics}
+	}
+	hot, cold, quiet := newBizMetrics(), newBizMetrics(), newBizMetrics()
+	now := time.Unix(1700000000, 0)
+
+	// 第一个周期只记录速率基准
+	gauges := []bizGauge{gauge("hot", 0.3, 0, hot), gauge("cold", 0.3, 0, cold), gauge("quiet", 0.1, 2, quiet)}
+	alerter.check(gauges, now)
+	if types := drainAlerts(alerter); len(types) != 0 {
+		t.Fatalf("alerts %v in the first period, want none", types)
+	}
+
+	// 10 秒内 hot 分配 20 万次(2万/秒)未超过自己的阈值, cold 分配 2000 次(200/秒)超过全局阈值
+	atomic.AddInt64(&hot.allocSuccess, 200000)
+	atomic.AddInt64(&cold.allocSuccess, 2000)
+	gauges[1] = gauge("cold", 0.1, 1, cold)
+	alerter.check(gauges, now.Add(10*time.Second))
+
+	want := map[string]bool{"qps_exceeded:cold": true, "low_remaining:cold": true}
+	types := drainAlerts(alerter)
+	for _, typ := range types {
+		if !want[typ] {
+			t.Errorf("unexpected alert %s", typ)
+		}
+		delete(want, typ)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing alerts %v, got %v", want, types)
+	}
+	if breach := alerter.breaches["cold"]; !breach.remaining || !breach.refillFail || !breach.qps {
+		t.Errorf("cold breaches %+v, want all", breach)
+	}
+	if breach := alerter.breaches["hot"]; breach != (alertBreach{}) {
+		t.Errorf("hot breaches %+v, want none", breach)
+	}
+	if breach := alerter.breaches["quiet"]; breach != (alertBreach{}) {
+		t.Errorf("quiet breaches %+v, want none", breach)
+	}
+
+	// 补偿失败告警同样使用业务的阈值
+	alerter.RefillFailed("quiet", 5, context.DeadlineExceeded)
+	alerter.RefillFailed("cold", 1, context.DeadlineExceeded)
+	if types := drainAlerts(alerter); len(types) != 1 || types[0] != "refill_failed:cold" {
+		t.Errorf("refill alerts %v, want only cold", types)
+	}
+}
+
+func TestAlertMetrics(t *testing.T) {
+	setupHandlerTest(t)
+	saved := alerter
+	alerter = newTestAlerter(AlertConfig{RemainingThreshold: 0.2, Tags: map[string]AlertThreshold{"test": {RemainingThreshold: 0.5}}})
+	t.Cleanup(func() { alerter = saved })
+	if _, err := DefaultAlloc.NextId(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	alerter.breaches["test"] = alertBreach{remaining: true}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`leaf_alert_threshold{biz_tag="test",threshold="remaining_ratio"} 0.5`,
+		`leaf_alert_breached{biz_tag="test",threshold="remaining_ratio"} 1`,
+		`leaf_alert_breached{biz_tag="test",threshold="qps"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
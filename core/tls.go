@@ -0,0 +1,167 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig 定义 HTTPS 的配置
+type TLSConfig struct {
+	Enable     bool       `json:"enable"`      // 是否以 HTTPS 对外提供服务
+	CertFile   string     `json:"cert_file"`   // 证书文件(PEM), 使用 ACME 时可为空
+	KeyFile    string     `json:"key_file"`    // 私钥文件(PEM), 使用 ACME 时可为空
+	MinVersion string     `json:"min_version"` // 最低 TLS 版本: 1.2 或 1.3
+	ACME       ACMEConfig `json:"acme"`        // 自动申请证书的配置
+	ClientAuth ClientAuth `json:"client_auth"` // 客户端证书认证(mTLS)的配置
+}
+
+// ClientAuth 定义客户端证书认证的配置
+type ClientAuth struct {
+	Enable       bool     `json:"enable"`        // 是否要求并校验客户端证书
+	CAFile       string   `json:"ca_file"`       // 签发客户端证书的 CA 证书包(PEM)
+	AllowedNames []string `json:"allowed_names"` // 允许的客户端证书 CN 或 DNS/URI SAN, 为空表示 CA 签发的证书均允许
+}
+
+// ACMEConfig 定义通过 ACME(如 Let's Encrypt) 自动申请和续期证书的配置
+type ACMEConfig struct {
+	Enable   bool     `json:"enable"`    // 是否自动申请证书, 启用后忽略 cert_file 和 key_file
+	Domains  []string `json:"domains"`   // 允许申请证书的域名
+	Email    string   `json:"email"`     // ACME 账号的联系邮箱
+	CacheDir string   `json:"cache_dir"` // 证书缓存目录, 重启后复用已申请的证书
+	HttpPort int      `json:"http_port"` // HTTP-01 验证的监听端口, 0 表示只使用 TLS-ALPN-01 验证
+}
+
+// acmeServer 响应 ACME HTTP-01 验证的 HTTP 服务器, 未启用时为 nil
+var acmeServer *http.Server
+
+// tlsVersions 配置中的 TLS 版本名称
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig 根据配置创建服务端 TLS 配置, ACME 需要 HTTP-01 验证时同时启动验证服务器
+func newTLSConfig(errChan chan<- error) (tlsConfig *tls.Config, err error) {
+	var (
+		conf     = DefaultConfig.TLS
+		cert     tls.Certificate
+		manager  *autocert.Manager
+		listener net.Listener
+		version  uint16
+		ok       bool
+	)
+
+	if version, ok = tlsVersions[conf.MinVersion]; !ok {
+		return nil, errors.New("unsupported tls min_version: " + conf.MinVersion)
+	}
+
+	// 使用静态证书
+	if !conf.ACME.Enable {
+		if cert, err = tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile); err != nil {
+			return
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:   version,
+			Certificates: []tls.Certificate{cert},
+		}
+		err = setClientAuth(tlsConfig, conf.ClientAuth)
+		return
+	}
+
+	// 通过 ACME 自动申请证书
+	if len(conf.ACME.Domains) == 0 {
+		return nil, errors.New("tls acme requires at least one domain")
+	}
+	manager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(conf.ACME.Domains...),
+		Email:      conf.ACME.Email,
+	}
+	if conf.ACME.CacheDir != "" {
+		manager.Cache = autocert.DirCache(conf.ACME.CacheDir)
+	}
+	tlsConfig = manager.TLSConfig()
+	tlsConfig.MinVersion = version
+	// TLS-ALPN-01 验证方无法出示客户端证书, 同时启用 mTLS 时只能使用 HTTP-01 验证
+	if conf.ClientAuth.Enable && conf.ACME.HttpPort == 0 {
+		return nil, errors.New("tls client_auth with acme requires acme http_port")
+	}
+	if err = setClientAuth(tlsConfig, conf.ClientAuth); err != nil {
+		return nil, err
+	}
+
+	if conf.ACME.HttpPort > 0 {
+		if listener, err = net.Listen("tcp", ":"+strconv.Itoa(conf.ACME.HttpPort)); err != nil {
+			return nil, err
+		}
+		acmeServer = &http.Server{Handler: manager.HTTPHandler(nil)}
+		logger.Info("acme challenge server started", "addr", listener.Addr().String())
+		go func() {
+			if err := acmeServer.Serve(listener); err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
+	}
+	return
+}
+
+// setClientAuth 要求客户端出示由指定 CA 签发的证书, 并按 CN/SAN 白名单校验
+func setClientAuth(tlsConfig *tls.Config, conf ClientAuth) (err error) {
+	var (
+		pem     []byte
+		pool    = x509.NewCertPool()
+		allowed = map[string]bool{}
+	)
+
+	if !conf.Enable {
+		return
+	}
+
+	if pem, err = os.ReadFile(conf.CAFile); err != nil {
+		return
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("no certificate found in tls client_auth ca_file: " + conf.CAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	if len(conf.AllowedNames) == 0 {
+		return
+	}
+	for _, name := range conf.AllowedNames {
+		allowed[name] = true
+	}
+
+	// 证书链已由标准库校验, 这里只检查叶子证书的身份
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("client certificate required")
+		}
+		cert := state.PeerCertificates[0]
+		if allowed[cert.Subject.CommonName] {
+			return nil
+		}
+		for _, name := range cert.DNSNames {
+			if allowed[name] {
+				return nil
+			}
+		}
+		for _, uri := range cert.URIs {
+			if allowed[uri.String()] {
+				return nil
+			}
+		}
+		logger.Warn("client certificate rejected", "cn", cert.Subject.CommonName, "dns_names", cert.DNSNames)
+		return errors.New("client certificate not allowed: " + cert.Subject.CommonName)
+	}
+	return
+}
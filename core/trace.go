@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName 链路追踪的instrumentation名称
+const tracerName = "leaf-segment"
+
+// tracer 全局tracer, 未启用追踪时为no-op实现
+var tracer = otel.Tracer(tracerName)
+
+// tracerProvider 启用追踪时的TracerProvider, 用于退出时刷新
+var tracerProvider *sdktrace.TracerProvider
+
+// InitTrace 根据配置初始化OpenTelemetry链路追踪和OTLP导出
+func InitTrace() (err error) {
+	var (
+		conf     = DefaultConfig.Trace
+		opts     []otlptracehttp.Option
+		exporter *otlptrace.Exporter
+		res      *resource.Resource
+	)
+
+	// 未启用追踪, 保持no-op实现
+	if !conf.Enable {
+		return
+	}
+
+	// OTLP/HTTP导出配置
+	if conf.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(conf.Endpoint))
+	}
+	if conf.URLPath != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(conf.URLPath))
+	}
+	if conf.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(conf.Headers) != 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(conf.Headers))
+	}
+
+	if exporter, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+		return
+	}
+
+	// 服务资源描述
+	if res, err = resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(conf.ServiceName),
+	)); err != nil {
+		return
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracer = tracerProvider.Tracer(tracerName)
+	return
+}
+
+// ShutdownTrace 刷新并关闭链路追踪导出
+func ShutdownTrace() (err error) {
+	if tracerProvider == nil {
+		return
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	return tracerProvider.Shutdown(ctx)
+}
+
+// withTrace 为 HTTP 处理函数开启服务端 span, 并从请求头中提取上游的追踪上下文
+func withTrace(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("biz_tag", r.URL.Query().Get("biz_tag")),
+			attribute.String("request_id", RequestID(r.Context())),
+		))
+		defer span.End()
+
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// recordSpanError 在span上记录错误
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// 获取号段的加锁方式
+const (
+	LockingUpdateSelect    = "update_select"     // 先 UPDATE 前进 max_id 再读取, 已知步长时单条语句完成
+	LockingSelectForUpdate = "select_for_update" // 先 SELECT ... FOR UPDATE 锁定号段行, 再按读到的值 UPDATE
+)
+
+// TransactionConfig 定义获取号段的事务隔离级别和加锁方式
+// 默认的 UPDATE 后 SELECT 适用于 MySQL; 对 LAST_INSERT_ID 或 UPDATE 后读取支持不好的数据库或代理可改为 select_for_update
+type TransactionConfig struct {
+	Isolation string                       `json:"isolation"` // 事务隔离级别: read_uncommitted、read_committed、repeatable_read、serializable, 为空时使用数据库默认
+	Locking   string                       `json:"locking"`   // 加锁方式: update_select 或 select_for_update, 为空时为 update_select
+	Backends  map[string]TransactionConfig `json:"backends"`  // 按数据库地址(host:port)覆盖的配置, 未配置的字段使用上面的值
+}
+
+// txStrategy 一个数据库获取号段使用的事务选项和加锁方式
+type txStrategy struct {
+	options *sql.TxOptions // 事务选项, 使用数据库默认隔离级别时为 nil
+	locking string         // 加锁方式
+}
+
+// isolationLevels 支持的事务隔离级别
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                 sql.LevelDefault,
+	"read_uncommitted": sql.LevelReadUncommitted,
+	"read_committed":   sql.LevelReadCommitted,
+	"repeatable_read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+}
+
+// txStrategies 检查事务配置, 返回与 dsns 一一对应的事务策略
+func txStrategies(conf TransactionConfig, dsns []string) (strategies []txStrategy, err error) {
+	var (
+		used = map[string]bool{}
+	)
+
+	for _, dsn := range dsns {
+		var (
+			addr     = dsnAddr(dsn)
+			resolved = conf
+			strategy txStrategy
+		)
+
+		if backend, ok := conf.Backends[addr]; ok {
+			used[addr] = true
+			if backend.Isolation != "" {
+				resolved.Isolation = backend.Isolation
+			}
+			if backend.Locking != "" {
+				resolved.Locking = backend.Locking
+			}
+		}
+		if strategy, err = newTxStrategy(resolved); err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		strategies = append(strategies, strategy)
+	}
+
+	// 地址写错时覆盖不会生效, 启动时提示
+	for addr := range conf.Backends {
+		if !used[addr] {
+			return nil, fmt.Errorf("transaction.backends %q matches no dsn", addr)
+		}
+	}
+	return strategies, nil
+}
+
+// newTxStrategy 解析隔离级别和加锁方式
+func newTxStrategy(conf TransactionConfig) (strategy txStrategy, err error) {
+	level, ok := isolationLevels[conf.Isolation]
+	if !ok {
+		return strategy, errors.New("unsupported transaction.isolation: " + conf.Isolation)
+	}
+	if level != sql.LevelDefault {
+		strategy.options = &sql.TxOptions{Isolation: level}
+	}
+
+	switch conf.Locking {
+	case "", LockingUpdateSelect:
+		strategy.locking = LockingUpdateSelect
+	case LockingSelectForUpdate:
+		strategy.locking = LockingSelectForUpdate
+	default:
+		return strategy, errors.New("unsupported transaction.locking: " + conf.Locking)
+	}
+	return strategy, nil
+}
+
+// nextIdLocked 在事务中先锁定号段行读取 max_id 和 step, 再写回前进后的 max_id
+func (data *Data) nextIdLocked(ctx context.Context, db *sql.DB, stmts *segmentStmts, options *sql.TxOptions, table string, bizTag string, multiple int64, phases *queryPhases) (maxId int64, step int64, rowsAffected int64, err error) {
+	var (
+		tx     *sql.Tx    // 事务对象
+		result sql.Result // SQL 执行结果
+	)
+
+	// 开启事务，设置上下文以支持超时和取消
+	if tx, err = db.BeginTx(ctx, options); err != nil {
+		data.triggerProbe() // 连接失败, 尽快探测是否需要切换
+		return
+	}
+	phases.mark("begin")
+
+	// STEP 1: 锁定号段行并读取当前的 max_id 和 step
+	if err = tx.StmtContext(ctx, stmts.lock).QueryRowContext(ctx, bizTag).Scan(&maxId, &step); err != nil {
+		if errors.Is(err, sql.ErrNoRows) { // 业务不存在或已归档
+			err = data.missing(ctx, tx, table, bizTag)
+		}
+		goto ROLLBACK
+	}
+	phases.mark("select")
+
+	// STEP 2: 写回前进 multiple 个步长后的 max_id, 行已被锁定, 不会被其他事务修改
+	maxId += step * multiple
+	if result, err = tx.StmtContext(ctx, stmts.set).ExecContext(ctx, maxId, bizTag); err != nil {
+		goto ROLLBACK
+	}
+	if rowsAffected, err = result.RowsAffected(); err != nil {
+		goto ROLLBACK
+	}
+	phases.mark("update")
+
+	// STEP 3: 提交事务，保存更新的 max_id
+	err = tx.Commit()
+	phases.mark("commit")
+	return
+
+ROLLBACK:
+	// 如果有任何错误则回滚事务
+	tx.Rollback()
+	phases.mark("rollback")
+	return
+}
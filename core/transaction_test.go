@@ -0,0 +1,43 @@
+package core
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestTxStrategies(t *testing.T) {
+	dsns := []string{"root:pw@tcp(db-a:3306)/leaf", "root:pw@tcp(db-b:3306)/leaf"}
+	conf := TransactionConfig{
+		Isolation: "read_committed",
+		Backends:  map[string]TransactionConfig{"db-b:3306": {Locking: LockingSelectForUpdate}},
+	}
+
+	strategies, err := txStrategies(conf, dsns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strategies[0].locking != LockingUpdateSelect || strategies[1].locking != LockingSelectForUpdate {
+		t.Fatalf("locking = %s/%s, want the override only on db-b", strategies[0].locking, strategies[1].locking)
+	}
+	for i, strategy := range strategies {
+		if strategy.options == nil || strategy.options.Isolation != sql.LevelReadCommitted {
+			t.Fatalf("strategy %d options = %+v, want read committed", i, strategy.options)
+		}
+	}
+
+	// 默认隔离级别不设置事务选项
+	if strategies, err = txStrategies(TransactionConfig{}, dsns); err != nil || strategies[0].options != nil {
+		t.Fatalf("default strategies = (%+v, %v)", strategies, err)
+	}
+
+	for name, invalid := range map[string]TransactionConfig{
+		"isolation": {Isolation: "snapshot"},
+		"locking":   {Locking: "optimistic"},
+		"backend":   {Backends: map[string]TransactionConfig{"db-b:3306": {Isolation: "snapshot"}}},
+		"unmatched": {Backends: map[string]TransactionConfig{"db-c:3306": {Locking: LockingSelectForUpdate}}},
+	} {
+		if _, err = txStrategies(invalid, dsns); err == nil {
+			t.Errorf("%s: txStrategies accepted %+v", name, invalid)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UnknownTagConfig 定义号段存储中不存在的业务的负缓存配置
+// 客户端配置错误、反复请求不存在的业务时, 缓存期内直接返回业务不存在, 不再访问号段存储, 也不创建号段池和补偿线程
+type UnknownTagConfig struct {
+	TTL        int `json:"ttl"`         // 业务不存在的结果缓存时间（毫秒）, 0 表示不缓存; 新建号段行后最多等待该时间才能发号
+	MaxEntries int `json:"max_entries"` // 最多缓存的业务数, 已满时先清理过期的业务, 仍满时不再缓存新业务, 0 表示不限制
+}
+
+// negativeCache 缓存号段存储中不存在的业务
+type negativeCache struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time // 业务标识 -> 缓存到期时间
+	hits    int64                // 命中负缓存被直接拒绝的请求数, 原子读写
+}
+
+// unknown 业务是否在负缓存中, 命中时返回业务不存在的错误
+func (alloc *Alloc) unknown(bizTag string) error {
+	cache := &alloc.negative
+	if alloc.conf.UnknownTags.TTL <= 0 {
+		return nil
+	}
+
+	cache.mutex.Lock()
+	expire, ok := cache.expires[bizTag]
+	if ok && !alloc.now().Before(expire) { // 已过期, 重新访问号段存储
+		delete(cache.expires, bizTag)
+		ok = false
+	}
+	cache.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+	atomic.AddInt64(&cache.hits, 1)
+	return fmt.Errorf("%w: %s", ErrBizTagNotFound, bizTag)
+}
+
+// markUnknown 将号段存储中不存在的业务加入负缓存
+func (alloc *Alloc) markUnknown(bizTag string) {
+	var (
+		cache = &alloc.negative
+		conf  = alloc.conf.UnknownTags
+		now   = alloc.now()
+	)
+
+	if conf.TTL <= 0 {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.expires == nil {
+		cache.expires = map[string]time.Time{}
+	}
+	if conf.MaxEntries > 0 && len(cache.expires) >= conf.MaxEntries {
+		for tag, expire := range cache.expires {
+			if !now.Before(expire) {
+				delete(cache.expires, tag)
+			}
+		}
+		if len(cache.expires) >= conf.MaxEntries {
+			return
+		}
+	}
+	cache.expires[bizTag] = now.Add(time.Duration(conf.TTL) * time.Millisecond)
+}
+
+// writeUnknownTagMetrics 输出负缓存的大小和命中次数, 未启用负缓存时不输出
+func writeUnknownTagMetrics(b *strings.Builder) {
+	cache := &DefaultAlloc.negative
+	if DefaultAlloc.conf.UnknownTags.TTL <= 0 {
+		return
+	}
+
+	cache.mutex.Lock()
+	entries := len(cache.expires)
+	cache.mutex.Unlock()
+
+	fmt.Fprintln(b, "# HELP leaf_unknown_biz_tags Number of biz tags cached as not found.")
+	fmt.Fprintln(b, "# TYPE leaf_unknown_biz_tags gauge")
+	fmt.Fprintf(b, "leaf_unknown_biz_tags %d\n", entries)
+	fmt.Fprintln(b, "# HELP leaf_unknown_biz_tag_hits_total Number of requests rejected by the not-found cache without querying storage.")
+	fmt.Fprintln(b, "# TYPE leaf_unknown_biz_tag_hits_total counter")
+	fmt.Fprintf(b, "leaf_unknown_biz_tag_hits_total %d\n", atomic.LoadInt64(&cache.hits))
+}
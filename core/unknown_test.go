@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUnknownTagCache(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.UnknownTags = UnknownTagConfig{TTL: 1000, MaxEntries: 1}
+	storage := newFakeStorage(100)
+	storage.setErr(ErrBizTagNotFound)
+	clock := newFakeClock(time.Now())
+	alloc := newTestAlloc(t, storage)
+	alloc.clock = clock
+	ctx := context.Background()
+
+	// 第一次请求访问号段存储, 放弃重试并丢弃号段池
+	if _, err := alloc.NextId(ctx, "bogus"); !errors.Is(err, ErrBizTagNotFound) {
+		t.Fatalf("NextId err = %v, want ErrBizTagNotFound", err)
+	}
+	if calls := storage.callCount(); calls != 1 {
+		t.Fatalf("storage calls = %d, want 1", calls)
+	}
+	waitFor(t, "pool dropped", func() bool { return alloc.load("bogus") == nil })
+
+	// 缓存期内不再访问号段存储, 也不创建号段池
+	for i := 0; i < 10; i++ {
+		if _, err := alloc.NextId(ctx, "bogus"); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("NextId err = %v, want ErrBizTagNotFound", err)
+		}
+		if _, err := alloc.Lease(ctx, "bogus", "test", ""); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("Lease err = %v, want ErrBizTagNotFound", err)
+		}
+	}
+	if calls := storage.callCount(); calls != 1 || alloc.load("bogus") != nil {
+		t.Fatalf("storage calls = %d, pool kept = %v; want 1 call and no pool", calls, alloc.load("bogus") != nil)
+	}
+
+	// 缓存已满时不再缓存其他业务
+	if _, err := alloc.Lease(ctx, "other", "test", ""); !errors.Is(err, ErrBizTagNotFound) {
+		t.Fatalf("Lease err = %v, want ErrBizTagNotFound", err)
+	}
+	if alloc.unknown("other") != nil {
+		t.Fatal("cache grew beyond max_entries")
+	}
+
+	// 过期后重新访问号段存储, 新建的号段行可以发号
+	storage.setErr(nil)
+	clock.advance(time.Second)
+	if _, err := alloc.NextId(ctx, "bogus"); err != nil {
+		t.Fatalf("NextId after ttl: %v", err)
+	}
+	if status, errNo, _ := allocFailure(context.Background(), "bogus", ErrBizTagNotFound); status != 404 || errNo != ErrNoBizTagUnknown {
+		t.Fatalf("allocFailure = (%d, %d), want (404, %d)", status, errNo, ErrNoBizTagUnknown)
+	}
+}
+
+func TestUnknownTagCacheDisabled(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(100)
+	storage.setErr(ErrBizTagNotFound)
+	alloc := newTestAlloc(t, storage)
+
+	for i := 0; i < 3; i++ {
+		if _, err := alloc.NextId(context.Background(), "bogus"); !errors.Is(err, ErrBizTagNotFound) {
+			t.Fatalf("NextId err = %v, want ErrBizTagNotFound", err)
+		}
+	}
+	if calls := storage.callCount(); calls != 3 {
+		t.Fatalf("storage calls = %d, want 3 without the cache", calls)
+	}
+}
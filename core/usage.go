@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	CREATE TABLE `segment_usage` (
+	 `biz_tag` varchar(32) NOT NULL,
+	 `stat_date` date NOT NULL,
+	 `ids_issued` bigint NOT NULL DEFAULT 0,
+	 `segments_fetched` bigint NOT NULL DEFAULT 0,
+	 `last_alloc_time` datetime(3) DEFAULT NULL,
+	 `update_time` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	 PRIMARY KEY (`biz_tag`, `stat_date`)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+*/
+
+// UsageConfig 定义业务使用统计的配置, 定期将各业务的分配计数累加写入数据库表, 重启后不丢失
+// 每个业务每天一行, 多个实例累加到同一行, 用于容量评估和步长调整
+type UsageConfig struct {
+	Table    string `json:"table"`    // 使用统计数据库表, 为空表示不写入
+	Interval int    `json:"interval"` // 写入间隔（毫秒）, 最近分配时间精确到写入间隔
+}
+
+// usageCounters 业务的累计计数
+type usageCounters struct {
+	issued  int64 // 成功分配的ID数
+	fetched int64 // 成功获取的号段数
+}
+
+// usageDelta 业务在一个写入周期内的增量
+type usageDelta struct {
+	bizTag string
+	usageCounters
+	total usageCounters // 写入成功后记为已写入的累计计数
+}
+
+// usageRecorder 定期将分配计数的增量写入数据库表
+type usageRecorder struct {
+	conf     UsageConfig
+	data     *Data
+	written  map[string]usageCounters // 各业务已写入数据库的累计计数, 只在写入协程中访问
+	stopChan chan struct{}            // 停止定期写入
+	wait     sync.WaitGroup           // 等待写入协程退出
+}
+
+// usage 全局使用统计, 未启用时为 nil
+var usage *usageRecorder
+
+// InitUsage 根据配置初始化使用统计, 依赖 InitData, 写入时读取 DefaultAlloc 的计数
+func InitUsage() (err error) {
+	if DefaultConfig.Usage.Table == "" {
+		return nil
+	}
+	usage = &usageRecorder{
+		conf:     DefaultConfig.Usage,
+		data:     DefaultData,
+		written:  map[string]usageCounters{},
+		stopChan: make(chan struct{}),
+	}
+	usage.wait.Add(1)
+	go usage.loop()
+	return nil
+}
+
+// loop 定期写入使用统计
+func (usage *usageRecorder) loop() {
+	var (
+		interval = time.Duration(usage.conf.Interval) * time.Millisecond
+		ticker   *time.Ticker
+	)
+
+	defer usage.wait.Done()
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			usage.flush(DefaultAlloc, time.Now())
+		case <-usage.stopChan:
+			return
+		}
+	}
+}
+
+// deltas 计算各业务自上次写入以来的增量, 没有变化的业务不写入
+func (usage *usageRecorder) deltas(alloc *Alloc) (result []usageDelta) {
+	if alloc == nil {
+		return nil
+	}
+
+	alloc.bizMap.Range(func(key, value any) bool {
+		var (
+			bizAlloc = value.(*BizAlloc)
+			total    = usageCounters{
+				issued:  atomic.LoadInt64(&bizAlloc.metrics.allocSuccess),
+				fetched: atomic.LoadInt64(&bizAlloc.metrics.fetchSuccess),
+			}
+			written = usage.written[bizAlloc.bizTag]
+		)
+
+		if total != written {
+			result = append(result, usageDelta{
+				bizTag:        bizAlloc.bizTag,
+				usageCounters: usageCounters{issued: total.issued - written.issued, fetched: total.fetched - written.fetched},
+				total:         total,
+			})
+		}
+		return true
+	})
+	return
+}
+
+// flush 将增量累加到当天的统计行, 写入失败的业务下次连同新的增量一起写入
+func (usage *usageRecorder) flush(alloc *Alloc, now time.Time) {
+	for _, delta := range usage.deltas(alloc) {
+		var (
+			lastAlloc any // 本周期没有分配时保留原有的最近分配时间
+		)
+
+		if delta.issued > 0 {
+			lastAlloc = now
+		}
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := usage.data.current().ExecContext(ctx,
+			"INSERT INTO "+usage.conf.Table+"(biz_tag, stat_date, ids_issued, segments_fetched, last_alloc_time) VALUES(?, ?, ?, ?, ?)"+
+				" ON DUPLICATE KEY UPDATE ids_issued = ids_issued + VALUES(ids_issued), segments_fetched = segments_fetched + VALUES(segments_fetched),"+
+				" last_alloc_time = COALESCE(VALUES(last_alloc_time), last_alloc_time)",
+			delta.bizTag, now.Format(time.DateOnly), delta.issued, delta.fetched, lastAlloc)
+		cancelFunc()
+		if err != nil {
+			logger.Error("write usage table failed", "table", usage.conf.Table, "biz_tag", delta.bizTag, "err", err)
+			continue
+		}
+		usage.written[delta.bizTag] = delta.total
+	}
+}
+
+// close 停止定期写入, 并写入最后一个周期的增量, 需在分配器退出之后调用
+func (usage *usageRecorder) close() {
+	close(usage.stopChan)
+	usage.wait.Wait()
+	usage.flush(DefaultAlloc, time.Now())
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUsageDeltas(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(100))
+	usage := &usageRecorder{written: map[string]usageCounters{}}
+	ctx := context.Background()
+
+	if deltas := usage.deltas(nil); deltas != nil {
+		t.Fatalf("deltas without allocator = %+v", deltas)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := alloc.NextId(ctx, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deltas := usage.deltas(alloc)
+	if len(deltas) != 1 || deltas[0].bizTag != "test" || deltas[0].issued != 3 || deltas[0].fetched < 1 {
+		t.Fatalf("deltas = %+v, want 3 ids issued for test", deltas)
+	}
+
+	// 写入成功后只计算之后的增量, 没有变化的业务不写入
+	usage.written["test"] = deltas[0].total
+	if deltas = usage.deltas(alloc); len(deltas) != 0 {
+		t.Fatalf("deltas after write = %+v, want none", deltas)
+	}
+	if _, err := alloc.NextId(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if deltas = usage.deltas(alloc); len(deltas) != 1 || deltas[0].issued != 1 {
+		t.Fatalf("deltas = %+v, want 1 id issued", deltas)
+	}
+}
+
+func TestUsageWithoutMySQL(t *testing.T) {
+	if err := checkWithoutMySQL(&Config{Usage: UsageConfig{Table: "segment_usage"}}); err == nil {
+		t.Fatal("checkWithoutMySQL accepted usage.table with the file store")
+	}
+}
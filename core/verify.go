@@ -0,0 +1,178 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VerifyResult 并发分配校验的结果
+type VerifyResult struct {
+	BizTag     string        `json:"biz_tag"`    // 校验的业务标识
+	Workers    int           `json:"workers"`    // 并发协程数
+	Allocated  int           `json:"allocated"`  // 成功分配的号码数
+	Failed     int           `json:"failed"`     // 分配失败的次数
+	Segments   int           `json:"segments"`   // 从存储获取号段的次数
+	Duplicates int           `json:"duplicates"` // 重复分配的号码数
+	Overlaps   int64         `json:"overlaps"`   // 同时属于多个号段的号码数, 多个实例拿到了相同的号段
+	Gaps       int64         `json:"gaps"`       // 号段内既未分配也不在内存中的号码数, 即被跳过的号码
+	Instances  []int         `json:"instances"`  // 每个实例成功分配的号码数, 用于检查负载是否公平
+	Elapsed    time.Duration `json:"elapsed"`    // 校验耗时
+}
+
+// OK 没有重复, 号段没有重叠也没有跳号
+func (result *VerifyResult) OK() bool {
+	return result.Duplicates == 0 && result.Overlaps == 0 && result.Gaps == 0
+}
+
+// idRange 左闭右开的号码区间
+type idRange struct {
+	left  int64
+	right int64
+}
+
+// recordingStorage 记录每次获取到的号段范围, 用于校验号段内是否跳号
+type recordingStorage struct {
+	Storage
+	mutex  sync.Mutex
+	ranges []idRange
+}
+
+// NextId 获取号段并记录范围
+func (storage *recordingStorage) NextId(ctx context.Context, bizTag string, multiple int64) (maxId int64, step int64, err error) {
+	if maxId, step, err = storage.Storage.NextId(ctx, bizTag, multiple); err == nil {
+		storage.mutex.Lock()
+		storage.ranges = append(storage.ranges, idRange{left: maxId - step*multiple, right: maxId})
+		storage.mutex.Unlock()
+	}
+	return
+}
+
+// Verify 使用配置的号段存储校验分配的正确性, 供独立的校验模式使用
+// 使用单独的分配器, 不影响正在提供服务的 DefaultAlloc
+func Verify(ctx context.Context, bizTag string, workers int, total int) (VerifyResult, error) {
+	return verify(ctx, newStorage(DefaultConfig, DefaultStore, ledger), bizTag, workers, total)
+}
+
+// verify 由 workers 个协程并发分配共 total 个号码, 跨越多次号段切换,
+// 检查是否有号码被重复分配, 以及获取到的号段内是否有号码被跳过
+func verify(ctx context.Context, storage Storage, bizTag string, workers int, total int) (VerifyResult, error) {
+	return verifyCluster(ctx, []Storage{storage}, bizTag, workers, total)
+}
+
+// verifyInstance 校验中的一个实例, 拥有独立的分配器, 只通过号段存储与其他实例协调
+type verifyInstance struct {
+	alloc    *Alloc
+	recorder *recordingStorage
+	bizAlloc *BizAlloc
+	ids      []int64
+}
+
+// verifyCluster 模拟多实例部署: 每个号段存储对应一个实例, 各实例 workers 个协程共同分配 total 个号码,
+// 检查全局是否有号码被重复分配, 不同实例获取的号段是否重叠, 以及号段内是否有号码被跳过
+func verifyCluster(ctx context.Context, storages []Storage, bizTag string, workers int, total int) (result VerifyResult, err error) {
+	var (
+		instances = make([]*verifyInstance, 0, len(storages))
+		start     = make(chan struct{}) // 所有协程就绪后同时开始
+		issued    int64                 // 已开始的分配次数
+		failed    int64                 // 失败次数
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		ids       = make([]int64, 0, total)
+		ranges    []idRange
+		remaining []idRange
+		startTime = time.Now()
+	)
+
+	for _, storage := range storages {
+		instance := &verifyInstance{recorder: &recordingStorage{Storage: storage}}
+		instance.alloc = newAlloc(DefaultConfig, instance.recorder)
+		defer instance.alloc.Close(context.Background())
+		instance.bizAlloc = instance.alloc.loadOrCreate(bizTag)
+		instances = append(instances, instance)
+	}
+
+	result.BizTag, result.Workers = bizTag, workers*len(instances)
+	for _, instance := range instances {
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(instance *verifyInstance) {
+				defer wg.Done()
+				var local []int64
+				<-start
+				for atomic.AddInt64(&issued, 1) <= int64(total) && ctx.Err() == nil {
+					if id, err := instance.bizAlloc.nextId(ctx); err != nil {
+						atomic.AddInt64(&failed, 1)
+					} else {
+						local = append(local, id)
+					}
+					// 每次请求后让出处理器, 相当于客户端的网络往返, 否则单核时一个协程在时间片内就能包揽大部分请求
+					runtime.Gosched()
+				}
+				mutex.Lock()
+				instance.ids = append(instance.ids, local...)
+				mutex.Unlock()
+			}(instance)
+		}
+	}
+	close(start)
+	wg.Wait()
+	result.Elapsed = time.Since(startTime)
+	result.Failed = int(failed)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	// 停止补偿线程后, 号段只剩已分配和仍在内存中两种状态
+	for _, instance := range instances {
+		instance.alloc.cancelFunc()
+		instance.alloc.fillWait.Wait()
+
+		result.Instances = append(result.Instances, len(instance.ids))
+		ids = append(ids, instance.ids...)
+
+		instance.bizAlloc.mutex.Lock()
+		for _, seg := range instance.bizAlloc.segments {
+			remaining = append(remaining, idRange{left: seg.right - seg.remaining(), right: seg.right})
+		}
+		instance.bizAlloc.mutex.Unlock()
+
+		instance.recorder.mutex.Lock()
+		ranges = append(ranges, instance.recorder.ranges...)
+		instance.recorder.mutex.Unlock()
+	}
+	result.Allocated, result.Segments = len(ids), len(ranges)
+
+	slices.Sort(ids)
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			result.Duplicates++
+		}
+	}
+	ids = slices.Compact(ids)
+
+	// 号段按左端点排序后, 与之前所有号段的最大右端点比较即可得到重叠部分
+	slices.SortFunc(ranges, func(a, b idRange) int { return cmp.Compare(a.left, b.left) })
+	for i, reach := 0, int64(math.MinInt64); i < len(ranges); i++ {
+		result.Overlaps += max(min(reach, ranges[i].right)-ranges[i].left, 0)
+		reach = max(reach, ranges[i].right)
+	}
+
+	// 统计每个号段内未分配的号码, 扣除仍在内存中可分配的部分即为跳号
+	for _, r := range ranges {
+		unused := r.right - r.left
+		begin, _ := slices.BinarySearch(ids, r.left)
+		end, _ := slices.BinarySearch(ids, r.right)
+		unused -= int64(end - begin)
+		for _, rest := range remaining {
+			unused -= max(min(rest.right, r.right)-max(rest.left, r.left), 0)
+		}
+		result.Gaps += max(unused, 0)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVerifyStress(t *testing.T) {
+	setupTestConfig(t)
+
+	cases := []struct {
+		name    string
+		step    int64
+		latency time.Duration
+		workers int
+		total   int
+	}{
+		{name: "rollover", step: 37, workers: 300, total: 200000},
+		{name: "slow storage", step: 500, latency: 2 * time.Millisecond, workers: 300, total: 100000},
+		{name: "single id segments", step: 1, workers: 50, total: 5000},
+	}
+	if testing.Short() {
+		for i := range cases {
+			cases[i].total /= 10
+		}
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			storage := newFakeStorage(c.step)
+			storage.latency = c.latency
+
+			result, err := verify(context.Background(), storage, "stress", c.workers, c.total)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allocated != c.total || result.Failed != 0 {
+				t.Fatalf("allocated %d, failed %d, want %d allocated", result.Allocated, result.Failed, c.total)
+			}
+			if !result.OK() {
+				t.Fatalf("found %d duplicate and %d skipped ids across %d segments",
+					result.Duplicates, result.Gaps, result.Segments)
+			}
+		})
+	}
+}
+
+func TestVerifyDetectsGaps(t *testing.T) {
+	setupTestConfig(t)
+
+	// 号段之间不连续但各自完整分配, [10, 20) 从未获取, 不算跳号
+	storage := newFakeStorage(10)
+	storage.script = []fakeResult{{maxId: 10, step: 10}, {maxId: 30, step: 10}}
+	result, err := verify(context.Background(), storage, "gap", 1, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Duplicates != 0 || result.Gaps != 0 {
+		t.Fatalf("found %d duplicates and %d gaps in non-contiguous but complete segments", result.Duplicates, result.Gaps)
+	}
+
+	// 同一个号段被返回两次, 号码重复分配
+	storage = newFakeStorage(10)
+	storage.script = []fakeResult{{maxId: 10, step: 10}, {maxId: 10, step: 10}}
+	if result, err = verify(context.Background(), storage, "dup", 1, 20); err != nil {
+		t.Fatal(err)
+	}
+	if result.Duplicates != 10 {
+		t.Fatalf("found %d duplicates, want 10", result.Duplicates)
+	}
+}
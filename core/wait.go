@@ -0,0 +1,60 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// waitHeader 调用方指定等待补偿线程最长时间（毫秒）的请求头, 也可以使用 wait_timeout 查询参数
+const waitHeader = "X-Wait-Timeout"
+
+// WaitConfig 定义号段耗尽时分配请求等待补偿线程的时间
+// 调用方可以通过 X-Wait-Timeout 请求头或 wait_timeout 参数指定本次请求的等待时间（毫秒）:
+// 对延迟敏感的调用方传 0 立即失败, 批量任务可以传较大的值, 超过 max 时按 max 处理
+type WaitConfig struct {
+	Default int `json:"default"` // 调用方未指定时的等待时间（毫秒）, 0 表示 2000
+	Max     int `json:"max"`     // 调用方可以指定的最长等待时间（毫秒）, 同时受 request_timeout 限制; 0 表示与 default 相同, 调用方只能缩短等待
+}
+
+// defaultWait 未配置等待时间时使用的默认值
+const defaultWait = 2 * time.Second
+
+// waitKey context 中保存调用方指定的等待时间的键
+type waitKey struct{}
+
+// withWaitHint 解析调用方指定的等待时间并放入 context, 格式错误或为负数时忽略, 使用默认值
+func withWaitHint(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hint := r.Header.Get(waitHeader)
+		if hint == "" {
+			hint = queryValue(r.URL.RawQuery, "wait_timeout")
+		}
+		if ms, err := strconv.Atoi(hint); err == nil && ms >= 0 {
+			r = r.WithContext(context.WithValue(r.Context(), waitKey{}, time.Duration(ms)*time.Millisecond))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// waitTimeout 返回本次分配等待补偿线程的最长时间, 调用方指定的值不超过 max
+func (alloc *Alloc) waitTimeout(ctx context.Context) time.Duration {
+	var (
+		conf         = alloc.conf.Wait
+		wait         = time.Duration(conf.Default) * time.Millisecond
+		longest      = time.Duration(conf.Max) * time.Millisecond
+		hint, hinted = ctx.Value(waitKey{}).(time.Duration)
+	)
+
+	if wait <= 0 {
+		wait = defaultWait
+	}
+	if longest <= 0 {
+		longest = wait
+	}
+	if hinted {
+		return min(hint, longest)
+	}
+	return wait
+}
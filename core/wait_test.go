@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitTimeout(t *testing.T) {
+	setupTestConfig(t)
+	alloc := newTestAlloc(t, newFakeStorage(100))
+
+	hinted := func(header string, query string) context.Context {
+		var ctx context.Context
+		r := httptest.NewRequest(http.MethodGet, "/alloc?biz_tag=test&"+query, nil)
+		if header != "" {
+			r.Header.Set(waitHeader, header)
+		}
+		withWaitHint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ctx = r.Context() })).ServeHTTP(httptest.NewRecorder(), r)
+		return ctx
+	}
+
+	DefaultConfig.Wait = WaitConfig{Default: 500, Max: 5000}
+	for _, c := range []struct {
+		header, query string
+		want          time.Duration
+	}{
+		{"", "", 500 * time.Millisecond},
+		{"0", "", 0},
+		{"", "wait_timeout=100", 100 * time.Millisecond},
+		{"60000", "", 5 * time.Second}, // 不超过 max
+		{"-1", "", 500 * time.Millisecond},
+		{"soon", "", 500 * time.Millisecond},
+	} {
+		if got := alloc.waitTimeout(hinted(c.header, c.query)); got != c.want {
+			t.Errorf("waitTimeout(header %q, query %q) = %v, want %v", c.header, c.query, got, c.want)
+		}
+	}
+
+	// 未配置时沿用 2 秒, 调用方只能缩短
+	DefaultConfig.Wait = WaitConfig{}
+	if got := alloc.waitTimeout(hinted("60000", "")); got != defaultWait {
+		t.Fatalf("waitTimeout without config = %v, want %v", got, defaultWait)
+	}
+}
+
+func TestNextIdWaitHint(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(100)
+	storage.gate = make(chan struct{}) // 补偿线程一直等待号段存储
+	clock := newFakeClock(time.Now())
+	alloc := newTestAlloc(t, storage)
+	alloc.clock = clock
+	bizAlloc := alloc.loadOrCreate("test")
+
+	// 指定不等待时立即失败, 不启动等待计时器
+	ctx := context.WithValue(context.Background(), waitKey{}, time.Duration(0))
+	if _, err := bizAlloc.nextId(ctx); err == nil {
+		t.Fatal("nextId without wait succeeded")
+	}
+	if n := clock.pending(); n != 0 {
+		t.Fatalf("pending timers = %d, want 0", n)
+	}
+
+	// 指定等待时间时按该时间超时
+	done := make(chan error, 1)
+	go func() {
+		_, err := bizAlloc.nextId(context.WithValue(context.Background(), waitKey{}, 100*time.Millisecond))
+		done <- err
+	}()
+	waitFor(t, "wait timer", func() bool { return clock.pending() == 1 })
+	clock.advance(100 * time.Millisecond)
+	if err := <-done; err == nil {
+		t.Fatal("nextId succeeded while storage is blocked")
+	}
+	close(storage.gate)
+}
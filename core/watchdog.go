@@ -0,0 +1,109 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WatchdogConfig 定义分配器自检的配置
+// 数据库抖动后补偿线程可能卡在网络读写上, 号段池一直处于补充中, 之后的请求既不会触发新的补充也得不到号码
+// 看门狗定期检查所有号段池, 发现补偿线程运行过久时记录诊断信息并强制重置, 发现等待队列长期不清空时唤醒等待者重新判断
+type WatchdogConfig struct {
+	Enable         bool `json:"enable"`          // 是否启用看门狗
+	Interval       int  `json:"interval"`        // 检查间隔（毫秒）, 0 表示默认 5 秒
+	StuckAfter     int  `json:"stuck_after"`     // 补偿线程运行超过多久视为卡住（毫秒）, 0 表示默认 30 秒
+	WaitingAfter   int  `json:"waiting_after"`   // 等待队列持续非空超过多久视为泄漏（毫秒）, 0 表示默认 30 秒
+	DumpGoroutines bool `json:"dump_goroutines"` // 发现补偿线程卡住时是否在日志中输出所有协程的调用栈
+}
+
+// durationOr 将毫秒配置转换为时长, 未配置时使用默认值
+func durationOr(ms int, fallback time.Duration) time.Duration {
+	if ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return fallback
+}
+
+// runWatchdog 定期检查所有号段池, 分配器退出时返回
+func (alloc *Alloc) runWatchdog() {
+	interval := durationOr(alloc.conf.Watchdog.Interval, 5*time.Second)
+	for {
+		timer := alloc.newTimer(interval)
+		select {
+		case <-timer.C():
+		case <-alloc.ctx.Done():
+			timer.Stop()
+			return
+		}
+		alloc.bizMap.Range(func(_, value any) bool {
+			value.(*BizAlloc).watchdog()
+			return true
+		})
+	}
+}
+
+// watchdog 检查号段池的补偿线程和等待队列, 发现异常时记录日志、计数并恢复
+func (bizAlloc *BizAlloc) watchdog() {
+	var (
+		conf         = bizAlloc.alloc.conf.Watchdog
+		stuckAfter   = durationOr(conf.StuckAfter, 30*time.Second)
+		waitingAfter = durationOr(conf.WaitingAfter, 30*time.Second)
+		stuck        time.Duration // 卡住的补偿线程已运行的时长, 未卡住时为0
+		leaked       int           // 被唤醒的长期等待者个数
+	)
+
+	bizAlloc.mutex.Lock()
+	if bizAlloc.isAllocating && bizAlloc.alloc.since(bizAlloc.fillStart) > stuckAfter {
+		// 卡住的线程恢复后看到序号变化, 不再修改号段池的状态; 下一个请求会启动新的补偿线程
+		stuck = bizAlloc.alloc.since(bizAlloc.fillStart)
+		bizAlloc.fillSeq++
+		bizAlloc.isAllocating = false
+	}
+	if len(bizAlloc.waiting) != 0 && bizAlloc.alloc.since(bizAlloc.waitSince) > waitingAfter {
+		leaked = len(bizAlloc.waiting)
+	}
+	if stuck > 0 || leaked > 0 {
+		bizAlloc.wakeup() // 等待者重新检查号码池, 需要时启动新的补偿线程
+		bizAlloc.publish()
+	}
+	bizAlloc.mutex.Unlock()
+
+	if stuck > 0 {
+		atomic.AddInt64(&bizAlloc.metrics.watchdogStuck, 1)
+		attrs := []any{"code", CodeRefillStuck, "biz_tag", bizAlloc.bizTag, "running_ms", stuck.Milliseconds()}
+		if conf.DumpGoroutines {
+			attrs = append(attrs, "goroutines", goroutineDump())
+		}
+		logger.Error("refill stuck, reset by watchdog", attrs...)
+	}
+	if leaked > 0 {
+		atomic.AddInt64(&bizAlloc.metrics.watchdogWaiting, int64(leaked))
+		logger.Warn("waiters not drained, woken by watchdog", "code", CodeRefillStuck, "biz_tag", bizAlloc.bizTag,
+			"waiting", leaked)
+	}
+}
+
+// goroutineDump 返回所有协程的调用栈
+func goroutineDump() string {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	return buf.String()
+}
+
+// writeWatchdogMetrics 输出看门狗发现的异常次数
+func writeWatchdogMetrics(b *strings.Builder, gauges []bizGauge) {
+	fmt.Fprintln(b, "# HELP leaf_watchdog_stuck_refills_total Number of refill goroutines reset by the watchdog after running too long.")
+	fmt.Fprintln(b, "# TYPE leaf_watchdog_stuck_refills_total counter")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_watchdog_stuck_refills_total{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), atomic.LoadInt64(&g.metrics.watchdogStuck))
+	}
+	fmt.Fprintln(b, "# HELP leaf_watchdog_woken_waiters_total Number of waiting clients woken by the watchdog after the queue did not drain.")
+	fmt.Fprintln(b, "# TYPE leaf_watchdog_woken_waiters_total counter")
+	for _, g := range gauges {
+		fmt.Fprintf(b, "leaf_watchdog_woken_waiters_total{biz_tag=\"%s\"} %d\n", escapeLabel(g.bizTag), atomic.LoadInt64(&g.metrics.watchdogWaiting))
+	}
+}
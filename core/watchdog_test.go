@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdogResetsStuckRefill(t *testing.T) {
+	setupTestConfig(t)
+	DefaultConfig.Wait.Default = 60000 // 推进时钟时等待者不超时
+	storage := newFakeStorage(10)
+	storage.gate = make(chan struct{})
+	alloc := newTestAlloc(t, storage)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc.clock = clock
+	bizAlloc := alloc.loadOrCreate("test")
+
+	type result struct {
+		id  int64
+		err error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		id, err := bizAlloc.nextId(context.Background())
+		resultChan <- result{id, err}
+	}()
+	waitFor(t, "waiter", func() bool { return bizAlloc.stats().waiting == 1 })
+
+	// 未超过阈值时不做处理
+	clock.advance(10 * time.Second)
+	bizAlloc.watchdog()
+	if stuck := atomic.LoadInt64(&bizAlloc.metrics.watchdogStuck); stuck != 0 {
+		t.Fatalf("watchdogStuck = %d before the threshold, want 0", stuck)
+	}
+
+	// 补偿线程卡住超过30秒, 看门狗重置后等待者重新启动补偿线程
+	clock.advance(21 * time.Second)
+	bizAlloc.watchdog()
+	if stuck := atomic.LoadInt64(&bizAlloc.metrics.watchdogStuck); stuck != 1 {
+		t.Fatalf("watchdogStuck = %d, want 1", stuck)
+	}
+	if woken := atomic.LoadInt64(&bizAlloc.metrics.watchdogWaiting); woken != 1 {
+		t.Fatalf("watchdogWaiting = %d, want 1", woken)
+	}
+	waitFor(t, "new refill", func() bool { return storage.callCount() == 2 })
+
+	// 放行卡住的和新启动的补偿线程, 卡住的线程的结果被丢弃, 等待者从新号段取号
+	storage.gate <- struct{}{}
+	storage.gate <- struct{}{}
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			t.Fatalf("nextId err = %v", r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not served after the watchdog reset")
+	}
+}
+
+func TestWatchdogDiscardsStaleRefill(t *testing.T) {
+	setupTestConfig(t)
+	storage := newFakeStorage(10)
+	storage.gate = make(chan struct{})
+	alloc := newTestAlloc(t, storage)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	alloc.clock = clock
+	bizAlloc := alloc.loadOrCreate("test")
+
+	bizAlloc.mutex.Lock()
+	bizAlloc.triggerFill(context.Background())
+	bizAlloc.mutex.Unlock()
+	waitFor(t, "refill", func() bool { return storage.callCount() == 1 })
+
+	// 看门狗重置后, 新的补偿线程已补足号段并记录了放弃的错误
+	clock.advance(31 * time.Second)
+	bizAlloc.watchdog()
+	fillErr := errors.New("newer refill gave up")
+	bizAlloc.mutex.Lock()
+	bizAlloc.segments = []*Segment{{left: 100, right: 110}, {left: 110, right: 120}}
+	bizAlloc.fillErr, bizAlloc.giveUps = fillErr, 2
+	bizAlloc.mutex.Unlock()
+
+	// 卡住的线程恢复后丢弃获取到的号段, 不修改号段池的状态
+	storage.gate <- struct{}{}
+	if err := alloc.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	bizAlloc.mutex.Lock()
+	defer bizAlloc.mutex.Unlock()
+	if len(bizAlloc.segments) != 2 || bizAlloc.segments[0].left != 100 {
+		t.Fatalf("segments = %d starting at %d, want the 2 of the newer refill", len(bizAlloc.segments), bizAlloc.segments[0].left)
+	}
+	if bizAlloc.fillErr != fillErr || bizAlloc.giveUps != 2 || bizAlloc.isAllocating {
+		t.Fatalf("fillErr %v, giveUps %d, isAllocating %v changed by the stale refill", bizAlloc.fillErr, bizAlloc.giveUps, bizAlloc.isAllocating)
+	}
+}
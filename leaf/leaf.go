@@ -0,0 +1,56 @@
+// Package leaf 以库的方式使用号段分配器, 直接链接进业务服务, 无需单独部署
+//
+//	conf := leaf.NewConfig()
+//	conf.DSN = "root:123456@tcp(localhost:3306)/leaf-segment"
+//	conf.Table = "segments"
+//	allocator, err := leaf.New(conf)
+//	...
+//	id, err := allocator.NextId(ctx, "test")
+//	...
+//	_ = allocator.Close(ctx)
+//
+// 不依赖 MySQL 时使用本地文件保存号段, 同一时间只能有一个进程使用该文件:
+//
+//	conf := leaf.NewConfig()
+//	conf.Store.Type = leaf.StoreFile
+//	conf.Store.File.Path = "/var/lib/leaf/segments.json"
+//	conf.Store.File.Tags = map[string]int64{"test": 1000}
+package leaf
+
+import (
+	"leaf-segment/core"
+)
+
+// Config 分配器配置, 与配置文件的格式相同
+type Config = core.Config
+
+// Allocator 号段分配器
+type Allocator = core.Allocator
+
+// Hooks 分配流程中的扩展点, 通过 Allocator.Use 注册
+type Hooks = core.Hooks
+
+// RefillStatus 业务补偿线程的状态
+type RefillStatus = core.RefillStatus
+
+// 号段存储类型
+const (
+	StoreMySQL = core.StoreMySQL // MySQL 号段表, 默认
+	StoreFile  = core.StoreFile  // 本地文件
+)
+
+var (
+	ErrBizTagNotFound = core.ErrBizTagNotFound // 号段表中不存在该业务标识
+	ErrInvalidBizTag  = core.ErrInvalidBizTag  // 业务标识不符合校验规则
+	ErrCircuitOpen    = core.ErrCircuitOpen    // 熔断器打开, 暂停访问数据库
+)
+
+// NewConfig 返回带默认值的配置
+func NewConfig() Config {
+	return core.NewConfig()
+}
+
+// New 按配置创建分配器, 不使用全局的配置、数据库和分配器, 日志、指标和告警与同一进程中的服务共享
+func New(conf Config) (*Allocator, error) {
+	return core.New(conf)
+}
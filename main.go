@@ -1,23 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"leaf-segment/core"
+	"leaf-segment/server"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 )
 
 var (
-	configFile string // 配置文件路径
+	configFile    string // 配置文件路径
+	verifyTag     string // 独立校验模式的业务标识, 为空表示正常启动服务
+	verifyWorkers int    // 独立校验模式的并发协程数
+	verifyTotal   int    // 独立校验模式分配的号码总数
+	showVersion   bool   // 输出构建信息后退出
+	checkConfig   bool   // 检查配置后退出, 由 check-config 子命令开启
 )
 
 // initCmd 初始化命令行参数
 func initCmd() {
 	// 设置 configFile 变量的默认值为 "./allocate.json"，并允许通过命令行传递配置文件路径
 	flag.StringVar(&configFile, "config", "./allocate.json", "配置文件路径，默认是 ./allocate.json")
-	// 解析命令行参数
-	flag.Parse()
+	// 独立校验模式: 对指定业务并发分配号码, 检查重复和跳号后退出, 会真实消耗该业务的号段
+	flag.StringVar(&verifyTag, "verify", "", "独立校验模式的业务标识，为空表示正常启动服务")
+	flag.IntVar(&verifyWorkers, "verify-workers", 200, "独立校验模式的并发协程数")
+	flag.IntVar(&verifyTotal, "verify-total", 1000000, "独立校验模式分配的号码总数")
+	// 输出版本、提交和构建时间后退出, 不加载配置
+	flag.BoolVar(&showVersion, "version", false, "输出构建信息后退出")
+	// 解析命令行参数, check-config 子命令之后的参数与正常启动相同
+	args := os.Args[1:]
+	if len(args) != 0 && args[0] == "check-config" {
+		checkConfig, args = true, args[1:]
+	}
+	_ = flag.CommandLine.Parse(args)
 }
 
 // initEnv 初始化环境配置
@@ -33,30 +54,41 @@ func main() {
 
 	// 初始化命令行参数
 	initCmd()
+	if showVersion {
+		fmt.Println(core.GetBuildInfo())
+		os.Exit(0)
+	}
 
-	var err error = nil
-
-	// 加载配置文件
-	if err = core.LoadConfig(configFile); err != nil {
-		// 如果加载配置失败，跳转到错误处理
-		goto ERROR
+	// 检查配置模式: 校验配置、读取证书、连接数据库并检查号段表结构, 输出报告后退出, 不启动服务
+	if checkConfig {
+		os.Exit(runCheckConfig())
 	}
 
-	// 初始化 MySQL 连接
-	if err = core.InitData(); err != nil {
-		// 如果初始化 MySQL 失败，跳转到错误处理
+	var (
+		err       error  = nil
+		code      string // 失败步骤对应的错误码
+		srv       *server.Server
+		serverErr *server.Error
+		sigChan   = make(chan os.Signal, 1)
+	)
+
+	// 按配置文件组装服务: 日志、链路追踪、指标、告警、号段存储、审计、台账和分配器
+	if srv, err = server.New(server.WithConfigFile(configFile)); err != nil {
 		goto ERROR
 	}
 
-	// 初始化分配器
-	if err = core.InitAlloc(); err != nil {
-		// 如果初始化分配器失败，跳转到错误处理
-		goto ERROR
+	// 独立校验模式, 不启动服务器
+	if verifyTag != "" {
+		if err = runVerify(); err != nil {
+			code = core.CodeVerifyFail
+			goto ERROR
+		}
+		os.Exit(0)
 	}
 
-	// 启动服务器
-	if err = core.StartServer(); err != nil {
-		// 如果启动服务器失败，跳转到错误处理
+	// 启动服务器, 收到退出信号后优雅退出: 排空处理中的请求, 停止补偿线程, 关闭号段存储
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	if err = srv.Run(signalContext(sigChan)); err != nil {
 		goto ERROR
 	}
 
@@ -65,12 +97,66 @@ func main() {
 
 ERROR:
 	// 发生错误时，输出错误信息并退出程序
-	fmt.Println(err)
+	if errors.As(err, &serverErr) && code == "" {
+		code = serverErr.Code
+	}
+	_ = core.ShutdownTrace()
+	core.Logger().Error("leaf-segment exited", "code", code, "err", err)
 	os.Exit(-1)
 }
 
+// signalContext 收到退出信号时取消, 取消原因中带有信号名
+func signalContext(sigChan <-chan os.Signal) context.Context {
+	ctx, cancelFunc := context.WithCancelCause(context.Background())
+	go func() {
+		sig := <-sigChan
+		cancelFunc(fmt.Errorf("received signal %s", sig))
+	}()
+	return ctx
+}
+
+// runCheckConfig 检查配置并输出报告, 全部通过时返回0, 否则返回1
+func runCheckConfig() int {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+
+	report := core.CheckConfig(ctx, configFile)
+	fmt.Print(report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// runVerify 运行独立校验模式, 发现重复或跳号时返回错误
+func runVerify() error {
+	ctx, cancelFunc := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelFunc()
+
+	result, err := core.Verify(ctx, verifyTag, verifyWorkers, verifyTotal)
+	core.Logger().Info("verify finished", "biz_tag", result.BizTag, "workers", result.Workers,
+		"allocated", result.Allocated, "failed", result.Failed, "segments", result.Segments,
+		"duplicates", result.Duplicates, "overlaps", result.Overlaps, "gaps", result.Gaps, "elapsed", result.Elapsed.String())
+	if err != nil {
+		return err
+	}
+	if !result.OK() {
+		return fmt.Errorf("found %d duplicate, %d overlapping and %d skipped ids", result.Duplicates, result.Overlaps, result.Gaps)
+	}
+	return nil
+}
+
 /*
 	测试命令：
 		curl http://localhost:8880/alloc?biz_tag=test
 		curl http://localhost:8880/health?biz_tag=test
+		curl http://localhost:8880/metrics
+		curl http://localhost:8880/version
+		./leaf-segment -version
+		./leaf-segment check-config -config allocate.json
+		go tool pprof http://localhost:8881/debug/pprof/profile
+		curl -X PUT http://localhost:8881/admin/loglevel?level=debug
+		curl http://localhost:8881/admin/audit?limit=10
+		curl "http://localhost:8881/admin/segments?biz_tag=test&id=12345"
+		./leaf-segment -config allocate.json -verify test -verify-workers 200 -verify-total 1000000
 */
@@ -0,0 +1,236 @@
+// Package server 以组件的方式组装并运行 leaf-segment 服务
+// 替代 main 中 LoadConfig → InitData → InitAlloc → StartServer 的固定流程, 监听、号段存储、日志和中间件都可以注入
+//
+//	srv, err := server.New(server.WithConfigFile("allocate.json"), server.WithListener(listener))
+//	...
+//	err = srv.Run(ctx)
+//
+// 服务内部仍使用 core 中的全局实例, 同一时间一个进程只能运行一个 Server, 上一个 Shutdown 后可以再创建
+package server
+
+import (
+	"context"
+	"errors"
+	"leaf-segment/core"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrRunning 已有一个 Server 尚未 Shutdown
+var ErrRunning = errors.New("server: another server is running in this process")
+
+// running 是否已有 Server 尚未 Shutdown
+var running atomic.Bool
+
+// Error 组装或启动失败的步骤和原因, Code 与日志中的错误码一致
+type Error struct {
+	Code string // 失败步骤对应的错误码
+	Err  error  // 失败原因
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Option 配置 Server 的选项
+type Option func(srv *Server)
+
+// Server 组装好的服务, 由 New 创建
+type Server struct {
+	conf       *core.Config       // 直接提供的配置, 优先于配置文件
+	configFile string             // 配置文件路径
+	store      core.Storage       // 注入的号段存储, 为 nil 时按配置打开
+	logger     *slog.Logger       // 注入的日志对象, 为 nil 时按配置创建
+	opts       core.ServerOptions // 注入的监听和中间件
+	hooks      []core.Hooks       // 注册到分配器的钩子
+}
+
+// WithConfig 直接使用配置, 通常在 core.NewConfig 返回的默认值上修改
+func WithConfig(conf core.Config) Option {
+	return func(srv *Server) {
+		srv.conf = &conf
+	}
+}
+
+// WithConfigFile 从配置文件加载配置, 与 WithConfig 同时使用时忽略
+func WithConfigFile(path string) Option {
+	return func(srv *Server) {
+		srv.configFile = path
+	}
+}
+
+// WithListener 分配端口使用该监听, 不再监听 http_port
+func WithListener(listener net.Listener) Option {
+	return func(srv *Server) {
+		srv.opts.Listener = listener
+	}
+}
+
+// WithFastListener 高性能分配端口使用该监听, 设置后即使 fast.enable 为 false 也启动
+func WithFastListener(listener net.Listener) Option {
+	return func(srv *Server) {
+		srv.opts.FastListener = listener
+	}
+}
+
+// WithAdminListener 管理端口使用该监听, 设置后即使 admin.port 为 0 也启动
+func WithAdminListener(listener net.Listener) Option {
+	return func(srv *Server) {
+		srv.opts.AdminListener = listener
+	}
+}
+
+// WithStorage 使用调用方提供的号段存储, 不再按配置连接 MySQL 或打开本地文件, Shutdown 时关闭
+// 审计、台账、认证和限流的数据库表依赖 MySQL, 不能同时配置
+func WithStorage(store core.Storage) Option {
+	return func(srv *Server) {
+		srv.store = store
+	}
+}
+
+// WithLogger 使用调用方的日志对象, 忽略配置中的 log 部分
+func WithLogger(logger *slog.Logger) Option {
+	return func(srv *Server) {
+		srv.logger = logger
+	}
+}
+
+// WithMiddleware 在分配端口最外层追加中间件, 先追加的在外层; 高性能端口和管理端口不经过
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(srv *Server) {
+		srv.opts.Middleware = append(srv.opts.Middleware, middleware...)
+	}
+}
+
+// WithHooks 在分配器上注册钩子, 实现配额、打标签、ID 变换等策略
+func WithHooks(hooks ...core.Hooks) Option {
+	return func(srv *Server) {
+		srv.hooks = append(srv.hooks, hooks...)
+	}
+}
+
+// New 按选项组装服务: 加载配置, 初始化日志、链路追踪、指标、告警、号段存储、审计、台账、分配器和选主
+// 返回后即可分配 ID, 调用 Start 或 Run 才开始监听
+func New(opts ...Option) (srv *Server, err error) {
+	if !running.CompareAndSwap(false, true) {
+		return nil, ErrRunning
+	}
+	defer func() {
+		if err != nil {
+			running.Store(false)
+		}
+	}()
+
+	srv = &Server{configFile: "./allocate.json"}
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	// 加载配置
+	if srv.conf != nil {
+		core.DefaultConfig = srv.conf
+	} else if err = core.LoadConfig(srv.configFile); err != nil {
+		return nil, &Error{Code: core.CodeConfigInvalid, Err: err}
+	}
+
+	// 初始化日志
+	if srv.logger != nil {
+		core.SetLogger(srv.logger)
+	} else if err = core.InitLog(); err != nil {
+		return nil, &Error{Code: core.CodeConfigInvalid, Err: err}
+	}
+
+	// 以 Kubernetes 工作负载运行时按 Pod 信息补全实例标识
+	if err = core.InitKubernetes(); err != nil {
+		return nil, &Error{Code: core.CodeConfigInvalid, Err: err}
+	}
+	core.LogConfigSummary()
+
+	// 设置内存上限和 GC, 初始化链路追踪、StatsD 指标上报、号段告警和事件流
+	for _, initFunc := range []func() error{core.InitMemory, core.InitTrace, core.InitStatsd, core.InitAlert, core.InitEvents} {
+		if err = initFunc(); err != nil {
+			return nil, &Error{Code: core.CodeConfigInvalid, Err: err}
+		}
+	}
+
+	// 初始化号段存储, 注入的存储优先
+	if srv.store != nil {
+		err = core.UseStore(srv.store)
+	} else {
+		err = core.InitData()
+	}
+	if err != nil {
+		return nil, &Error{Code: core.CodeDataInit, Err: err}
+	}
+
+	// 之后的步骤失败时关闭已打开的号段存储, 释放数据库连接或文件锁
+	defer func() {
+		if err != nil {
+			_ = core.Shutdown()
+		}
+	}()
+
+	// 初始化审计日志、号段台账、使用统计和数据库中的告警阈值, 台账需在分配器装配号段存储之前
+	for _, initFunc := range []func() error{core.InitAudit, core.InitLedger, core.InitUsage, core.InitAlertThresholds} {
+		if err = initFunc(); err != nil {
+			return nil, &Error{Code: core.CodeConfigInvalid, Err: err}
+		}
+	}
+
+	// 初始化分配器
+	if err = core.InitAlloc(); err != nil {
+		return nil, &Error{Code: core.CodeAllocInit, Err: err}
+	}
+	core.DefaultAlloc.Use(srv.hooks...)
+
+	// 启用选主时竞争选主锁, 未取得锁的实例作为备用
+	if err = core.InitElection(); err != nil {
+		return nil, &Error{Code: core.CodeConfigInvalid, Err: err}
+	}
+	return srv, nil
+}
+
+// Start 开始监听, 监听成功后立即返回
+func (srv *Server) Start() error {
+	if err := core.StartServerWith(srv.opts); err != nil {
+		return &Error{Code: core.CodeServerExit, Err: err}
+	}
+	return nil
+}
+
+// Errors 返回服务器异常退出时的错误通道
+func (srv *Server) Errors() <-chan error {
+	return core.ServerErrors()
+}
+
+// Run 启动服务器并等待 ctx 取消或服务器异常退出, 然后优雅退出
+func (srv *Server) Run(ctx context.Context) (err error) {
+	if err = srv.Start(); err != nil {
+		_ = srv.Shutdown()
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		core.Logger().Info("shutting down", "cause", context.Cause(ctx))
+	case err = <-srv.Errors():
+		err = &Error{Code: core.CodeServerExit, Err: err}
+	}
+	return errors.Join(err, srv.Shutdown())
+}
+
+// Shutdown 优雅退出: 排空处理中的请求, 停止补偿线程, 关闭号段存储和链路追踪, 之后可以再创建 Server
+func (srv *Server) Shutdown() (err error) {
+	defer running.Store(false)
+
+	if err = core.Shutdown(); err != nil {
+		err = &Error{Code: core.CodeServerExit, Err: err}
+	}
+	return errors.Join(err, core.ShutdownTrace())
+}
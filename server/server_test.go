@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"leaf-segment/core"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// listenLocal 监听随机端口
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+func TestServer(t *testing.T) {
+	conf := core.NewConfig()
+	conf.Store.Type = core.StoreFile
+	conf.Store.File.Path = filepath.Join(t.TempDir(), "segments.json")
+	conf.Store.File.Tags = map[string]int64{"test": 100}
+
+	newServer := func() (*Server, net.Listener, net.Listener) {
+		listener, admin := listenLocal(t), listenLocal(t)
+		srv, err := New(
+			WithConfig(conf),
+			WithListener(listener),
+			WithAdminListener(admin),
+			WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+			WithMiddleware(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-Test", "wrapped")
+					next.ServeHTTP(w, r)
+				})
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return srv, listener, admin
+	}
+
+	srv, listener, admin := newServer()
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	// 注入的监听和中间件生效
+	resp, err := http.Get("http://" + listener.Addr().String() + "/alloc?biz_tag=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body core.AllocResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || body.ID == 0 || resp.Header.Get("X-Test") != "wrapped" {
+		t.Fatalf("alloc: status %d, body %+v, X-Test %q, err %v", resp.StatusCode, body, resp.Header.Get("X-Test"), err)
+	}
+	if resp, err = http.Get("http://" + admin.Addr().String() + "/admin/leases"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin: %v", err)
+	}
+	resp.Body.Close()
+
+	// 同一时间只能运行一个 Server
+	if _, err = New(WithConfig(conf)); !errors.Is(err, ErrRunning) {
+		t.Fatalf("second New err = %v, want ErrRunning", err)
+	}
+
+	cancelFunc()
+	if err = <-done; err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+
+	// 退出后释放号段文件, 可以再创建
+	srv, _, _ = newServer()
+	if err = srv.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	conf := core.NewConfig()
+	conf.Store.Type = "unknown"
+
+	var serverErr *Error
+	if _, err := New(WithConfig(conf), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))); !errors.As(err, &serverErr) || serverErr.Code != core.CodeDataInit {
+		t.Fatalf("New err = %v, want a %s error", err, core.CodeDataInit)
+	}
+}